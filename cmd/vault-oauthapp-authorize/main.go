@@ -0,0 +1,111 @@
+// Command vault-oauthapp-authorize creates a credential in a mount of this
+// plugin by running the authorization code flow from the command line. It
+// reads the address of Vault and the token to use from the same environment
+// variables as the Vault CLI.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/client"
+)
+
+// providerOptions collects repeated key=value flags.
+type providerOptions map[string]string
+
+func (po providerOptions) String() string {
+	var pairs []string
+	for k, v := range po {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (po providerOptions) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+
+	po[kv[0]] = kv[1]
+	return nil
+}
+
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
+
+func main() {
+	po := make(providerOptions)
+
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [options] <credential name>\n\nOptions:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	mount := flags.String("mount", "oauth2", "the path the plugin is mounted at")
+	addr := flags.String("addr", client.DefaultAuthorizeAddr, "the local address to listen for the redirect from the provider on")
+	scopes := flags.String("scopes", "", "a comma-separated list of scopes to request")
+	flags.Var(po, "provider-option", "a provider option as key=value (may be repeated)")
+	timeout := flags.Duration("timeout", 5*time.Minute, "how long to wait for the authorization to complete")
+	noBrowser := flags.Bool("no-browser", false, "print the authorization URL without opening a browser")
+	_ = flags.Parse(os.Args[1:])
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	name := flags.Arg(0)
+
+	ac, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %+v\n", err)
+		os.Exit(1)
+	}
+
+	opts := client.AuthorizeOptions{
+		Addr:            *addr,
+		ProviderOptions: po,
+		StateTTLSeconds: int(timeout.Seconds()),
+		OpenURL: func(url string) error {
+			fmt.Fprintf(os.Stderr, "Visit the following URL to authorize %q:\n\n    %s\n\n", name, url)
+			if !*noBrowser {
+				if err := openBrowser(url); err != nil {
+					fmt.Fprintf(os.Stderr, "Could not open a browser: %+v\n\n", err)
+				}
+			}
+			fmt.Fprintln(os.Stderr, "Waiting for the provider to redirect back...")
+			return nil
+		},
+	}
+	if *scopes != "" {
+		opts.Scopes = strings.Split(*scopes, ",")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if err := client.New(ac, *mount).Authorize(ctx, name, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %+v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Credential %q is ready at %s/creds/%s.\n", name, strings.Trim(*mount, "/"), name)
+}
@@ -13,10 +13,11 @@ func main() {
 	meta := &api.PluginAPIClientMeta{}
 
 	flags := meta.FlagSet()
+	eventLogDir := flags.String("event-log-dir", "", "directory to create credential event log files in")
 	_ = flags.Parse(os.Args[1:])
 
 	err := plugin.Serve(&plugin.ServeOpts{
-		BackendFactoryFunc: backend.Factory,
+		BackendFactoryFunc: backend.NewFactory(backend.Options{EventLogDir: *eventLogDir}),
 		TLSProviderFunc:    api.VaultPluginTLSProvider(meta.GetTLSConfig()),
 	})
 	if err != nil {
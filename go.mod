@@ -15,8 +15,12 @@ require (
 	github.com/puppetlabs/leg/scheduler v0.3.0
 	github.com/puppetlabs/leg/timeutil v0.4.2
 	github.com/spf13/afero v1.2.2 // indirect
-	github.com/stretchr/testify v1.6.1
-	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
+	github.com/stretchr/testify v1.7.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	google.golang.org/appengine v1.6.2 // indirect
 	gopkg.in/square/go-jose.v2 v2.5.1
 	gotest.tools/gotestsum v0.6.0
@@ -7,6 +7,7 @@ import (
 
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/puppetlabs/leg/scheduler"
 	"github.com/puppetlabs/leg/timeutil/pkg/clock"
@@ -19,6 +20,10 @@ type backend struct {
 	logger           hclog.Logger
 	clock            clock.Clock
 
+	// system provides information about the Vault node this backend is
+	// running on. It is only available once the backend has been set up.
+	system func() logical.SystemView
+
 	// scheduler is a worker that processes token renewals with hard schedules.
 	// It will be created by the backend lifecycle in the initialize method.
 	scheduler scheduler.StartedLifecycle
@@ -33,6 +38,17 @@ type backend struct {
 
 	// data is the API to the internal storage.
 	data *persistence.Holder
+
+	// refreshSchedule tracks when credentials next need to be refreshed by the
+	// automatic refresher.
+	refreshSchedule *refreshSchedule
+
+	// revocationSchedule tracks when emergency access tokens need to be
+	// revoked.
+	revocationSchedule *revocationSchedule
+
+	// eventLogDir is the directory event log files may be created in.
+	eventLogDir string
 }
 
 const backendHelp = `
@@ -42,7 +58,16 @@ The OAuth app backend provides OAuth authorization tokens on demand given a secr
 type Options struct {
 	ProviderRegistry *provider.Registry
 	Logger           hclog.Logger
-	Clock            clock.Clock
+
+	// Clock is the source of time for token expiry checks, the automatic
+	// refresher, and reaping. Tests can use a fake clock to advance time
+	// instead of waiting. Defaults to the wall clock.
+	Clock clock.Clock
+
+	// EventLogDir is the directory that the event_log_file configuration
+	// option names a file in. If it is empty, credential lifecycle events can
+	// only be sent to syslog.
+	EventLogDir string
 }
 
 func New(opts Options) *framework.Backend {
@@ -66,24 +91,62 @@ func New(opts Options) *framework.Backend {
 		logger:           logger,
 		clock:            clk,
 
-		data: persistence.NewHolder(),
+		data:               persistence.NewHolder(),
+		refreshSchedule:    newRefreshSchedule(),
+		revocationSchedule: newRevocationSchedule(),
+		eventLogDir:        opts.EventLogDir,
 	}
+	b.data.ObserveAuthCode(b.refreshSchedule)
 
-	return &framework.Backend{
+	fb := &framework.Backend{
 		Help:           strings.TrimSpace(backendHelp),
 		PathsSpecial:   pathsSpecial(),
 		Paths:          paths(b),
+		Secrets:        secrets(b),
 		BackendType:    logical.TypeLogical,
 		InitializeFunc: b.initialize,
 		Clean:          b.clean,
 		Invalidate:     b.invalidate,
 	}
+	b.system = fb.System
+
+	return fb
+}
+
+// readOnly returns true if this node cannot persist changes to credentials.
+// This is the case on performance standbys and on performance secondaries
+// unless the mount is local. Operations that would contact a provider and
+// store the result must not run on such nodes; instead they return
+// logical.ErrReadOnly so that Vault forwards the request to the active node.
+// Otherwise, a rotated refresh token could be lost when the write fails.
+func (b *backend) readOnly() bool {
+	sys := b.system()
+	if sys == nil {
+		return false
+	}
+
+	state := sys.ReplicationState()
+	return state.HasState(consts.ReplicationPerformanceStandby) ||
+		(state.HasState(consts.ReplicationPerformanceSecondary) && !sys.LocalMount())
 }
 
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
-	b := New(Options{Logger: conf.Logger})
-	if err := b.Setup(ctx, conf); err != nil {
-		return nil, err
+	return NewFactory(Options{})(ctx, conf)
+}
+
+// NewFactory returns a factory that creates backends with the given options.
+// If no logger is given, the logger of the backend configuration is used.
+func NewFactory(opts Options) logical.Factory {
+	return func(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
+		opts := opts
+		if opts.Logger == nil {
+			opts.Logger = conf.Logger
+		}
+
+		b := New(opts)
+		if err := b.Setup(ctx, conf); err != nil {
+			return nil, err
+		}
+		return b, nil
 	}
-	return b, nil
 }
@@ -17,3 +17,26 @@ func TestBackendNew(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, b)
 }
+
+func TestBackendSealWrapStorage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	b, err := Factory(ctx, &logical.BackendConfig{})
+	require.NoError(t, err)
+
+	// Everything that holds a refresh token, client secret, or signing key
+	// must be seal wrapped.
+	require.ElementsMatch(t, []string{
+		"config",
+		"creds/",
+		"devices/",
+		"exchanges/",
+		"reaped/",
+		"state_signing_key",
+		"self/",
+		"fingerprint_key",
+		"registration",
+		"revocations/",
+	}, b.SpecialPaths().SealWrapStorage)
+}
@@ -0,0 +1,134 @@
+package backend
+
+import (
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+const (
+	breakerStateClosed   = "closed"
+	breakerStateOpen     = "open"
+	breakerStateHalfOpen = "half-open"
+)
+
+// circuitBreaker stops the automatic refresher from contacting a provider that
+// keeps failing. After a configured number of consecutive failures, it opens
+// for a cool-down period during which background refreshes are skipped. Once
+// the period ends, refreshes are attempted again; the first failure opens it
+// for another period and the first success closes it.
+//
+// Requests made on behalf of clients are never blocked, but their outcomes are
+// recorded like any other.
+type circuitBreaker struct {
+	threshold int
+	coolDown  time.Duration
+	logger    hclog.Logger
+
+	mut         sync.Mutex
+	failures    int
+	openUntil   time.Time
+	lastError   string
+	lastOpening time.Time
+}
+
+type breakerStatus struct {
+	State       string
+	Failures    int
+	OpenUntil   time.Time
+	LastError   string
+	LastOpening time.Time
+}
+
+// Allow returns true if a background refresh may contact the provider.
+func (cb *circuitBreaker) Allow(now time.Time) bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mut.Lock()
+	defer cb.mut.Unlock()
+
+	return !now.Before(cb.openUntil)
+}
+
+// Success records that the provider responded to a request, even if it
+// rejected it for reasons specific to the credential.
+func (cb *circuitBreaker) Success() {
+	if cb == nil {
+		return
+	}
+
+	cb.mut.Lock()
+	defer cb.mut.Unlock()
+
+	if cb.failures >= cb.threshold {
+		cb.logger.Info("provider circuit breaker closed")
+	}
+
+	cb.failures = 0
+	cb.openUntil = time.Time{}
+	cb.lastError = ""
+}
+
+// Failure records that a request to the provider failed in a way that
+// suggests the provider is unavailable.
+func (cb *circuitBreaker) Failure(now time.Time, err error) {
+	if cb == nil {
+		return
+	}
+
+	cb.mut.Lock()
+	defer cb.mut.Unlock()
+
+	cb.failures++
+	cb.lastError = err.Error()
+
+	// Only the failure that trips the breaker (or the first one after a
+	// cool-down period) opens it, so that failures of requests that were
+	// already in progress don't extend the period.
+	if cb.failures >= cb.threshold && !now.Before(cb.openUntil) {
+		cb.openUntil = now.Add(cb.coolDown)
+		cb.lastOpening = now
+		cb.logger.Warn("provider circuit breaker opened; background refreshes are paused", "failures", cb.failures, "until", cb.openUntil, "error", cb.lastError)
+	}
+}
+
+// Status returns the current state of the breaker.
+func (cb *circuitBreaker) Status(now time.Time) breakerStatus {
+	cb.mut.Lock()
+	defer cb.mut.Unlock()
+
+	status := breakerStatus{
+		State:       breakerStateClosed,
+		Failures:    cb.failures,
+		LastError:   cb.lastError,
+		LastOpening: cb.lastOpening,
+	}
+
+	switch {
+	case cb.failures < cb.threshold:
+	case now.Before(cb.openUntil):
+		status.State = breakerStateOpen
+		status.OpenUntil = cb.openUntil
+	default:
+		status.State = breakerStateHalfOpen
+	}
+
+	return status
+}
+
+// newCircuitBreaker creates a breaker from the given configuration, or returns
+// nil if the breaker is disabled.
+func newCircuitBreaker(threshold int, coolDown time.Duration, logger hclog.Logger) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return &circuitBreaker{
+		threshold: threshold,
+		coolDown:  coolDown,
+		logger:    logger,
+	}
+}
@@ -2,70 +2,250 @@ package backend
 
 import (
 	"context"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/jwks"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type cache struct {
-	Config   *persistence.ConfigEntry
-	Provider provider.Provider
-	cancel   context.CancelFunc
+	Config         *persistence.ConfigEntry
+	TracerProvider trace.TracerProvider
+	EventLog       *eventLog
+	Breaker        *circuitBreaker
+	UserInfo       *userInfoCache
+	JARMKeySet     *jwks.KeySet
+	JWTKeySet      *jwks.KeySet
+	registry       *provider.Registry
+	logger         hclog.Logger
+	ctx            context.Context
+	cancel         context.CancelFunc
+	shutdown       func(context.Context) error
+
+	providerMut sync.Mutex
+	provider    provider.Provider
+
+	// users is the number of leases held on this cache and retired is true
+	// once the configuration has changed. A retired cache is closed when its
+	// last lease is released. Both are protected by the backend mutex.
+	users   int
+	retired bool
 }
 
-func (c *cache) ProviderWithTimeout(expiryDelta time.Duration) provider.Provider {
-	if c.Config.Tuning.ProviderTimeoutSeconds <= 0 {
-		return c.Provider
+// Provider returns the configured provider, constructing it the first time it
+// is needed. Constructing some providers requires contacting them, e.g., to
+// retrieve an OpenID Connect discovery document, so operations that don't
+// need the provider never wait for it. If construction fails, it is attempted
+// again on the next call.
+func (c *cache) Provider() (provider.Provider, error) {
+	c.providerMut.Lock()
+	defer c.providerMut.Unlock()
+
+	if c.provider == nil {
+		// The provider may keep using the context it was constructed with, so
+		// it must live as long as the cache.
+		p, err := c.registry.NewAt(c.ctx, c.Config.ProviderName, c.Config.ProviderVersion, c.Config.ProviderOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		c.provider = p
 	}
 
-	// Minimum ramp-up time. TODO: Should this be hardcoded?
-	if expiryDelta < time.Minute {
-		expiryDelta = time.Minute
+	return c.provider, nil
+}
+
+func (c *cache) ProviderWithTimeout() (provider.Provider, error) {
+	return c.ProviderWithTuning(c.Config.Tuning)
+}
+
+// ProviderWithTuning is like ProviderWithTimeout, but uses the timeouts from
+// the given tuning instead of the mount tuning.
+func (c *cache) ProviderWithTuning(tuning persistence.ConfigTuningEntry) (provider.Provider, error) {
+	cp, err := c.Provider()
+	if err != nil {
+		return nil, err
+	}
+
+	var infer []provider.ExpiryFunc
+	if c.Config.DecodeJWTAccessTokens {
+		infer = append(infer, c.jwtAccessTokenExpiry)
+	}
+	if lifetime := c.Config.DefaultTokenLifetimeSeconds; lifetime > 0 || len(infer) > 0 {
+		cp = provider.NewExpiryProvider(cp, time.Duration(lifetime)*time.Second, infer...)
+	}
+
+	p := provider.Provider(provider.NewTracingProvider(cp, c.TracerProvider, c.Config.ProviderName))
+	if tuning.ProviderTimeoutSeconds <= 0 {
+		return p, nil
 	}
 
 	return provider.NewTimeoutProvider(
-		c.Provider,
-		provider.NewBoundedLogarithmicTimeoutAlgorithm(
-			c.Config.Tuning.ProviderTimeoutExpiryLeewayFactor,
-			time.Duration(c.Config.Tuning.ProviderTimeoutSeconds)*time.Second,
-			expiryDelta,
+		p,
+		provider.NewDeadlineTimeoutAlgorithm(
+			tuning.ProviderTimeoutExpiryLeewayFactor,
+			time.Duration(tuning.ProviderTimeoutSeconds)*time.Second,
 		),
-	)
+		func(op string, timeout, elapsed time.Duration, err error) {
+			c.logger.Debug("provider request completed", "operation", op, "timeout", timeout, "elapsed", elapsed, "error", err)
+		},
+	), nil
 }
 
 func (c *cache) Close() {
 	c.cancel()
+
+	// Spans that have not been exported yet are dropped if the collector
+	// doesn't accept them in time.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = c.shutdown(ctx)
+
+	_ = c.EventLog.Close()
 }
 
-func newCache(c *persistence.ConfigEntry, r *provider.Registry) (*cache, error) {
+func newCache(c *persistence.ConfigEntry, r *provider.Registry, logger hclog.Logger, eventLogDir string) (*cache, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	p, err := r.NewAt(ctx, c.ProviderName, c.ProviderVersion, c.ProviderOptions)
+	tp, shutdown, err := newTracerProvider(ctx, c.TracingOTLPEndpoint)
 	if err != nil {
 		cancel()
 		return nil, err
 	}
 
+	events, err := newEventLog(c, eventLogDir)
+	if err != nil {
+		cancel()
+		_ = shutdown(ctx)
+		return nil, err
+	}
+
+	// The key set is refreshed in the background for as long as the cache is
+	// in use.
+	var jarmKeySet *jwks.KeySet
+	if c.JARMJWKSURL != "" {
+		jarmKeySet = jwks.NewKeySet(ctx, c.JARMJWKSURL, jwks.Options{})
+	}
+
+	var jwtKeySet *jwks.KeySet
+	if c.JWTAccessTokenJWKSURL != "" {
+		jwtKeySet = jwks.NewKeySet(ctx, c.JWTAccessTokenJWKSURL, jwks.Options{})
+	}
+
 	return &cache{
-		Config:   c,
-		Provider: p,
-		cancel:   cancel,
+		Config:         c,
+		TracerProvider: tp,
+		EventLog:       events,
+		Breaker:        newCircuitBreaker(c.Tuning.CircuitBreakerFailures, time.Duration(c.Tuning.CircuitBreakerCoolDownSeconds)*time.Second, logger),
+		UserInfo:       newUserInfoCache(time.Duration(c.Tuning.UserInfoCacheSeconds) * time.Second),
+		JARMKeySet:     jarmKeySet,
+		JWTKeySet:      jwtKeySet,
+		registry:       r,
+		logger:         logger,
+		ctx:            ctx,
+		cancel:         cancel,
+		shutdown:       shutdown,
 	}, nil
 }
 
+type cacheLeaseKey struct{}
+
+type cacheLease struct {
+	cache *cache
+}
+
+// leaseCache returns a context in which the cache returned by getCache is
+// fixed to the one current when it is first called. If the configuration
+// changes before the returned function is called, the operation continues
+// with the configuration and provider it started with, and they are only
+// closed once every operation using them is done.
+func (b *backend) leaseCache(ctx context.Context) (context.Context, func()) {
+	lease := &cacheLease{}
+
+	return context.WithValue(ctx, cacheLeaseKey{}, lease), func() {
+		b.mut.Lock()
+		c := lease.cache
+		lease.cache = nil
+
+		closing := false
+		if c != nil {
+			c.users--
+			closing = c.retired && c.users == 0
+		}
+		b.mut.Unlock()
+
+		if closing {
+			c.Close()
+		}
+	}
+}
+
+// withCacheLease wraps every operation of the given paths so that it holds a
+// cache lease until it returns.
+func (b *backend) withCacheLease(paths []*framework.Path) []*framework.Path {
+	for _, p := range paths {
+		for _, h := range p.Operations {
+			if po, ok := h.(*framework.PathOperation); ok && po.Callback != nil {
+				po.Callback = b.leasedOperation(po.Callback)
+			}
+		}
+	}
+	return paths
+}
+
+func (b *backend) leasedOperation(fn framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		ctx, release := b.leaseCache(ctx)
+		defer release()
+
+		return fn(ctx, req, data)
+	}
+}
+
+// retireCache removes the current cache so that the next call to getCache
+// reads the configuration again. The cache is closed once no lease holds it.
+// The backend mutex must be held.
+func (b *backend) retireCache() {
+	if b.cache == nil {
+		return
+	}
+
+	if b.cache.users == 0 {
+		b.cache.Close()
+	} else {
+		b.cache.retired = true
+	}
+	b.cache = nil
+}
+
 func (b *backend) getCache(ctx context.Context, storage logical.Storage) (*cache, error) {
 	b.mut.Lock()
 	defer b.mut.Unlock()
 
+	lease, _ := ctx.Value(cacheLeaseKey{}).(*cacheLease)
+	if lease != nil && lease.cache != nil {
+		return lease.cache, nil
+	}
+
 	if b.cache == nil {
+		if err := b.checkStorageVersion(ctx, storage); err != nil {
+			return nil, err
+		}
+
 		cfg, err := b.data.Managers(storage).Config().ReadConfig(ctx)
 		if err != nil || cfg == nil {
 			return nil, err
 		}
 
-		cache, err := newCache(cfg, b.providerRegistry)
+		cache, err := newCache(cfg, b.providerRegistry, b.logger, b.eventLogDir)
 		if err != nil {
 			return nil, err
 		}
@@ -73,5 +253,117 @@ func (b *backend) getCache(ctx context.Context, storage logical.Storage) (*cache
 		b.cache = cache
 	}
 
+	if lease != nil {
+		lease.cache = b.cache
+		b.cache.users++
+	}
+
 	return b.cache, nil
 }
+
+// checkStorageVersion returns an error if the storage was written by a newer
+// version of this plugin.
+func (b *backend) checkStorageVersion(ctx context.Context, storage logical.Storage) error {
+	entry, err := b.data.Managers(storage).Migration().ReadMigrationEntry(ctx)
+	if err != nil {
+		return err
+	}
+
+	return entry.Supported()
+}
+
+// maintenanceMode returns true if the mount is configured to pause all
+// requests to the provider.
+func (b *backend) maintenanceMode(ctx context.Context, storage logical.Storage) (bool, error) {
+	c, err := b.getCache(ctx, storage)
+	if err != nil || c == nil {
+		return false, err
+	}
+
+	return c.Config.MaintenanceMode, nil
+}
+
+// maintenanceResponse returns an error response if the mount is in
+// maintenance mode, for operations that must contact the provider.
+func (b *backend) maintenanceResponse(ctx context.Context, storage logical.Storage) (*logical.Response, error) {
+	if maintenance, err := b.maintenanceMode(ctx, storage); err != nil || !maintenance {
+		return nil, err
+	}
+
+	return errorResponse(ErrorCodeMaintenance, "requests to the provider are paused for maintenance"), nil
+}
+
+// grantTypeResponse returns an error response if the mount configuration does
+// not allow credentials to be issued using the given grant type.
+func (b *backend) grantTypeResponse(ctx context.Context, storage logical.Storage, grantType string) (*logical.Response, error) {
+	c, err := b.getCache(ctx, storage)
+	if err != nil || c == nil || c.Config.GrantTypeAllowed(grantType) {
+		return nil, err
+	}
+
+	return grantTypeNotAllowedResponse(grantType), nil
+}
+
+func grantTypeNotAllowedResponse(grantType string) *logical.Response {
+	return errorResponse(ErrorCodeUnsupported, "the %s grant type is not allowed by the configuration", grantType)
+}
+
+// redirectURLResponse returns an error response if the mount configuration
+// does not allow authorization codes to be exchanged with the given redirect
+// URL.
+func (b *backend) redirectURLResponse(ctx context.Context, storage logical.Storage, redirectURL string) (*logical.Response, error) {
+	c, err := b.getCache(ctx, storage)
+	if err != nil || c == nil || c.Config.RedirectURLAllowed(redirectURL) {
+		return nil, err
+	}
+
+	return redirectURLNotAllowedResponse(redirectURL), nil
+}
+
+// scopeResponse returns an error response if the scope policy of the mount
+// configuration does not allow all of the given scopes.
+func (b *backend) scopeResponse(ctx context.Context, storage logical.Storage, scopes []string) (*logical.Response, error) {
+	c, err := b.getCache(ctx, storage)
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	return scopeNotAllowedResponse(c.Config.DisallowedScopes(scopes)), nil
+}
+
+// scopeNotAllowedResponse returns an error response for the given disallowed
+// scopes, or nil if there are none.
+func scopeNotAllowedResponse(disallowed []string) *logical.Response {
+	if len(disallowed) == 0 {
+		return nil
+	}
+
+	return errorResponse(ErrorCodeScopeNotAllowed, "scopes not allowed by the configuration: %s", strings.Join(disallowed, " "))
+}
+
+// readProviderOptionsResponse returns an error response if the mount
+// configuration does not allow all of the given provider options to be given
+// when reading a credential.
+func (b *backend) readProviderOptionsResponse(ctx context.Context, storage logical.Storage, opts map[string]string) (*logical.Response, error) {
+	c, err := b.getCache(ctx, storage)
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	var disallowed []string
+	for name := range opts {
+		if !c.Config.ReadProviderOptionAllowed(name) {
+			disallowed = append(disallowed, name)
+		}
+	}
+	if len(disallowed) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(disallowed)
+	return errorResponse(ErrorCodeInvalidRequest, "provider options not allowed when reading credentials: %s", strings.Join(disallowed, " ")), nil
+}
+
+func redirectURLNotAllowedResponse(redirectURL string) *logical.Response {
+	return errorResponse(ErrorCodeInvalidRequest, "redirect URL %q is not allowed by the configuration", redirectURL)
+}
@@ -0,0 +1,66 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+)
+
+// correlationID returns the ID used to trace the handling of a request across
+// Vault, this plugin, and the provider. A client can choose its own ID by
+// sending the X-Correlation-ID header if the mount is tuned to pass it
+// through; otherwise, we use the ID Vault assigned to the request, which
+// also appears in the audit log.
+func correlationID(req *logical.Request) string {
+	if id := http.Header(req.Headers).Get(provider.CorrelationIDHeader); id != "" {
+		return id
+	}
+
+	return req.ID
+}
+
+// correlate wraps an operation so that requests it makes to the provider and
+// its log messages include the correlation ID of the Vault request, and so
+// that its error response reports the ID.
+func (b *backend) correlate(fn framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		id := correlationID(req)
+		if id == "" {
+			return fn(ctx, req, data)
+		}
+
+		ctx = provider.WithCorrelationID(ctx, id)
+
+		b.logger.Debug("handling request", "correlation_id", id, "operation", req.Operation, "path", req.Path)
+
+		resp, err := fn(ctx, req, data)
+		switch {
+		case err != nil:
+			b.logger.Debug("request failed", "correlation_id", id, "error", err)
+		case resp != nil && resp.IsError():
+			if msg, ok := resp.Data["error"].(string); ok {
+				resp.Data["error"] = fmt.Sprintf("%s (correlation ID: %s)", msg, id)
+			}
+
+			b.logger.Debug("request failed", "correlation_id", id, "error", resp.Data["error"])
+		}
+
+		return resp, err
+	}
+}
+
+// withCorrelation applies correlate to every operation of the given paths.
+func (b *backend) withCorrelation(paths []*framework.Path) []*framework.Path {
+	for _, p := range paths {
+		for _, h := range p.Operations {
+			if po, ok := h.(*framework.PathOperation); ok && po.Callback != nil {
+				po.Callback = b.correlate(po.Callback)
+			}
+		}
+	}
+	return paths
+}
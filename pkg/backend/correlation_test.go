@@ -0,0 +1,92 @@
+package backend_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestCorrelationID(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var ids []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.Header.Get("X-Correlation-ID"))
+
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "invalid_grant",
+		})
+	})
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}})
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     "abc",
+			"client_secret": "def",
+			"provider":      "custom",
+			"provider_options": map[string]interface{}{
+				"token_url":  testutil.MockTokenURL,
+				"auth_style": "in_params",
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// The ID Vault assigns to the request is used by default.
+	req = &logical.Request{
+		ID:        "vault-request-id",
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"code": "test",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	assert.True(t, strings.HasSuffix(resp.Error().Error(), " (correlation ID: vault-request-id)"), resp.Error().Error())
+
+	code, ok := backend.ParseErrorCode(resp.Error().Error())
+	require.True(t, ok)
+	assert.Equal(t, backend.ErrorCodeProviderRejected, code)
+
+	// Clients can choose their own ID.
+	req.Headers = map[string][]string{
+		"X-Correlation-Id": {"client-id"},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	assert.True(t, strings.HasSuffix(resp.Error().Error(), " (correlation ID: client-id)"), resp.Error().Error())
+
+	assert.Equal(t, []string{"vault-request-id", "client-id"}, ids)
+}
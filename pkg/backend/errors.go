@@ -1,7 +1,133 @@
 package backend
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmap"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+)
 
 var (
-	ErrNotConfigured = errors.New("not configured")
+	ErrNotConfigured   = errors.New("not configured")
+	ErrMaintenanceMode = errors.New("maintenance mode")
+	ErrScopeNotAllowed = errors.New("scope not allowed")
+)
+
+// ErrorCode is a stable, machine-readable identifier for an error response.
+//
+// Vault only returns the message of an error response to clients, so the code
+// is included at the start of the message in square brackets, e.g.,
+// "[ERR_NOT_CONFIGURED] not configured". The rest of the message is intended
+// for humans and may change between releases.
+type ErrorCode string
+
+const (
+	// ErrorCodeNotConfigured indicates that the plugin has not been
+	// configured.
+	ErrorCodeNotConfigured ErrorCode = "ERR_NOT_CONFIGURED"
+
+	// ErrorCodeInvalidRequest indicates that a required field is missing or
+	// that a field has an invalid value.
+	ErrorCodeInvalidRequest ErrorCode = "ERR_INVALID_REQUEST"
+
+	// ErrorCodeUnsupported indicates that the provider or configuration does
+	// not support the requested operation.
+	ErrorCodeUnsupported ErrorCode = "ERR_UNSUPPORTED"
+
+	// ErrorCodeDisabled indicates that the credential has been disabled.
+	ErrorCodeDisabled ErrorCode = "ERR_DISABLED"
+
+	// ErrorCodeNotFound indicates that a credential or a version of it does
+	// not exist.
+	ErrorCodeNotFound ErrorCode = "ERR_NOT_FOUND"
+
+	// ErrorCodeStorageVersion indicates that the storage was written by a
+	// newer version of this plugin.
+	ErrorCodeStorageVersion ErrorCode = "ERR_STORAGE_VERSION"
+
+	// ErrorCodeInvalidState indicates that an authorization code state is
+	// missing, unknown, or expired.
+	ErrorCodeInvalidState ErrorCode = "ERR_INVALID_STATE"
+
+	// ErrorCodeProviderRejected indicates that the provider rejected a
+	// request.
+	ErrorCodeProviderRejected ErrorCode = "ERR_PROVIDER_REJECTED"
+
+	// ErrorCodeProviderTimeout indicates that the provider did not respond in
+	// time.
+	ErrorCodeProviderTimeout ErrorCode = "ERR_PROVIDER_TIMEOUT"
+
+	// ErrorCodeRefreshRevoked indicates that the provider rejected the refresh
+	// token and the credential must be authorized again.
+	ErrorCodeRefreshRevoked ErrorCode = "ERR_REFRESH_REVOKED"
+
+	// ErrorCodeTokenPending indicates that a token has not been issued yet.
+	ErrorCodeTokenPending ErrorCode = "ERR_TOKEN_PENDING"
+
+	// ErrorCodeTokenExpired indicates that a token has expired and could not
+	// be refreshed.
+	ErrorCodeTokenExpired ErrorCode = "ERR_TOKEN_EXPIRED"
+
+	// ErrorCodeMaintenance indicates that the operation requires contacting
+	// the provider, but the mount is in maintenance mode.
+	ErrorCodeMaintenance ErrorCode = "ERR_MAINTENANCE"
+
+	// ErrorCodeQuotaExceeded indicates that a credential could not be created
+	// because a limit on the number of credentials has been reached.
+	ErrorCodeQuotaExceeded ErrorCode = "ERR_QUOTA_EXCEEDED"
+
+	// ErrorCodeScopeNotAllowed indicates that the scope policy of the
+	// configuration does not allow one or more of the requested scopes.
+	ErrorCodeScopeNotAllowed ErrorCode = "ERR_SCOPE_NOT_ALLOWED"
 )
+
+// errorResponse is like logical.ErrorResponse, but also includes the given
+// error code in the message.
+func errorResponse(code ErrorCode, text string, vargs ...interface{}) *logical.Response {
+	if len(vargs) > 0 {
+		text = fmt.Sprintf(text, vargs...)
+	}
+
+	return logical.ErrorResponse("[%s] %s", code, text)
+}
+
+// scopeNotAllowedError returns an error wrapping ErrScopeNotAllowed for the
+// given disallowed scopes.
+func scopeNotAllowedError(disallowed []string) error {
+	return fmt.Errorf("%w by the configuration: %s", ErrScopeNotAllowed, strings.Join(disallowed, " "))
+}
+
+// ParseErrorCode extracts the error code from the message of an error response
+// returned by this plugin.
+func ParseErrorCode(msg string) (ErrorCode, bool) {
+	if !strings.HasPrefix(msg, "[ERR_") {
+		return "", false
+	}
+
+	end := strings.Index(msg, "]")
+	if end < 0 {
+		return "", false
+	}
+
+	return ErrorCode(msg[1:end]), true
+}
+
+// providerErrorResponse creates an error response for an error returned by a
+// provider if the error should be reported to the user. Otherwise, it returns
+// nil and the error should be handled as an internal error.
+func providerErrorResponse(err error, msg string) *logical.Response {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.DeadlineExceeded):
+		return errorResponse(ErrorCodeProviderTimeout, "%s: provider did not respond in time", msg)
+	case errmark.MarkedUser(err):
+		return errorResponse(ErrorCodeProviderRejected, errmap.Wrap(errmark.MarkShort(err), msg).Error())
+	default:
+		return nil
+	}
+}
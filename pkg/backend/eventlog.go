@@ -0,0 +1,162 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+)
+
+// eventLogTag identifies the plugin in syslog messages.
+const eventLogTag = "vault-plugin-secrets-oauthapp"
+
+// credEvent is a change in the lifecycle of a credential that is recorded in
+// the event log.
+type credEvent string
+
+const (
+	credEventCreated         credEvent = "created"
+	credEventRefreshed       credEvent = "refreshed"
+	credEventRefreshFailed   credEvent = "refresh-failed"
+	credEventScopeDowngraded credEvent = "scope-downgraded"
+	credEventReaped          credEvent = "reaped"
+	credEventRevoked         credEvent = "revoked"
+	credEventRenamed         credEvent = "renamed"
+	credEventInactive        credEvent = "inactive"
+)
+
+type eventLogRecord struct {
+	Time          time.Time `json:"time"`
+	Event         credEvent `json:"event"`
+	Credential    string    `json:"credential"`
+	Reason        string    `json:"reason,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+}
+
+// eventLog records credential lifecycle events for auditing, separately from
+// the Vault audit log, which only sees requests made by clients.
+type eventLog struct {
+	mut     sync.Mutex
+	writers []io.WriteCloser
+}
+
+func (el *eventLog) Write(ctx context.Context, now time.Time, event credEvent, name, reason string) error {
+	if el == nil {
+		return nil
+	}
+
+	rec := &eventLogRecord{
+		Time:       now.UTC(),
+		Event:      event,
+		Credential: name,
+		Reason:     reason,
+	}
+	rec.CorrelationID, _ = provider.CorrelationID(ctx)
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	el.mut.Lock()
+	defer el.mut.Unlock()
+
+	for _, w := range el.writers {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (el *eventLog) Close() (err error) {
+	if el == nil {
+		return nil
+	}
+
+	el.mut.Lock()
+	defer el.mut.Unlock()
+
+	for _, w := range el.writers {
+		if cerr := w.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	el.writers = nil
+
+	return err
+}
+
+// newEventLog opens the event log destinations in the given configuration.
+// Files are only created in dir, which is set by the operator when the plugin
+// is started, so that the configuration cannot be used to write to arbitrary
+// files on the Vault server. It returns nil if no destination is configured.
+func newEventLog(c *persistence.ConfigEntry, dir string) (*eventLog, error) {
+	el := &eventLog{}
+
+	if name := c.EventLogFile; name != "" {
+		if dir == "" {
+			return nil, errors.New("event log files are disabled because the plugin was not started with an event log directory")
+		} else if name != filepath.Base(name) || name == "." || name == ".." {
+			return nil, fmt.Errorf("file %q must be the name of a file in the event log directory", name)
+		}
+
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		el.writers = append(el.writers, f)
+	}
+
+	if c.EventLogSyslog {
+		w, err := newSyslogWriter()
+		if err != nil {
+			_ = el.Close()
+			return nil, err
+		}
+
+		el.writers = append(el.writers, w)
+	}
+
+	if len(el.writers) == 0 {
+		return nil, nil
+	}
+
+	return el, nil
+}
+
+// logCredEvent records a credential lifecycle event. Failing to record an
+// event does not fail the operation that caused it.
+func (b *backend) logCredEvent(ctx context.Context, c *cache, event credEvent, name, reason string) {
+	if c == nil || c.EventLog == nil {
+		return
+	}
+
+	if err := c.EventLog.Write(ctx, b.clock.Now(), event, name, reason); err != nil {
+		b.logger.Warn("failed to write to event log", "event", event, "credential", name, "error", err)
+	}
+}
+
+// logCredCreated records that a credential was created or replaced using the
+// given grant type.
+func (b *backend) logCredCreated(ctx context.Context, storage logical.Storage, name, grantType string) error {
+	c, err := b.getCache(ctx, storage)
+	if err != nil {
+		return err
+	}
+
+	b.logCredEvent(ctx, c, credEventCreated, name, grantType)
+	return nil
+}
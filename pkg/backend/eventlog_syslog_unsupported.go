@@ -0,0 +1,15 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package backend
+
+import (
+	"errors"
+	"io"
+)
+
+var errSyslogUnsupported = errors.New("syslog is not supported on this platform")
+
+func newSyslogWriter() (io.WriteCloser, error) {
+	return nil, errSyslogUnsupported
+}
@@ -0,0 +1,142 @@
+package backend_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventLog(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	refresh := func(i int) (time.Duration, error) {
+		switch i {
+		case 1:
+			// Force a refresh when the credential is read.
+			return 2 * time.Second, nil
+		default:
+			return 10 * time.Minute, nil
+		}
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, testutil.RefreshableMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), refresh))))
+
+	storage := &logical.InmemStorage{}
+
+	dir, err := ioutil.TempDir("", "oauthapp-events-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":      client.ID,
+			"client_secret":  client.Secret,
+			"provider":       "mock",
+			"event_log_file": "events.log",
+		},
+	}
+
+	// Files can't be written unless the operator sets the event log
+	// directory.
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	b = backend.New(backend.Options{ProviderRegistry: pr, EventLogDir: dir})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Files outside of the event log directory are rejected.
+	for _, name := range []string{filepath.Join(dir, "events.log"), "../events.log", ".."} {
+		req.Data["event_log_file"] = name
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError(), "file %q was accepted", name)
+	}
+
+	req.Data["event_log_file"] = "events.log"
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Create, refresh, and delete a credential.
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			Data: map[string]interface{}{
+				"code": "test",
+			},
+			ID: "req-1",
+		},
+		{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			ID:        "req-2",
+		},
+		{
+			Operation: logical.DeleteOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			ID:        "req-3",
+		},
+	} {
+		req.Storage = storage
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	b.Clean(ctx)
+
+	f, err := os.Open(filepath.Join(dir, "events.log"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	type record struct {
+		Event         string `json:"event"`
+		Credential    string `json:"credential"`
+		Reason        string `json:"reason"`
+		CorrelationID string `json:"correlation_id"`
+	}
+
+	var records []record
+	for s := bufio.NewScanner(f); s.Scan(); {
+		var r record
+		require.NoError(t, json.Unmarshal(s.Bytes(), &r))
+		records = append(records, r)
+	}
+
+	require.Equal(t, []record{
+		{Event: "created", Credential: "test", Reason: "authorization_code", CorrelationID: "req-1"},
+		{Event: "refreshed", Credential: "test", CorrelationID: "req-2"},
+		{Event: "revoked", Credential: "test", Reason: "deleted", CorrelationID: "req-3"},
+	}, records)
+}
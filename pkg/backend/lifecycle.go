@@ -9,18 +9,42 @@ import (
 )
 
 func (b *backend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	// Do not start any background processes if the storage was written by a
+	// newer version of the plugin.
+	if err := b.checkStorageVersion(ctx, req.Storage); err != nil {
+		return err
+	}
+
+	// Create the key used to sign states while this node can write to
+	// storage, so that other nodes only ever need to read it.
+	if !b.readOnly() {
+		if _, err := b.data.Managers(req.Storage).AuthCodeState().ReadOrCreateSigningKey(ctx); err != nil {
+			return err
+		}
+	}
+
 	deviceCodeExchange := &deviceCodeExchangeDescriptor{backend: b, storage: req.Storage}
+	authCodeExchange := &authCodeExchangeDescriptor{backend: b, storage: req.Storage}
 	refresh, restartRefresh := scheduler.NewRestartableDescriptor(&refreshDescriptor{backend: b, storage: req.Storage})
 	reap, restartReap := scheduler.NewRestartableDescriptor(&reapDescriptor{backend: b, storage: req.Storage})
+	revocation := &revocationDescriptor{backend: b, storage: req.Storage}
+	introspection, restartIntrospection := scheduler.NewRestartableDescriptor(&introspectionDescriptor{backend: b, storage: req.Storage})
+	notify, restartNotify := scheduler.NewRestartableDescriptor(&reauthorizationNotifyDescriptor{backend: b, storage: req.Storage})
 
 	b.scheduler = scheduler.NewSegment(16, []scheduler.Descriptor{
 		scheduler.NewRecoveryDescriptor(deviceCodeExchange, scheduler.RecoveryDescriptorWithClock(b.clock)),
+		scheduler.NewRecoveryDescriptor(authCodeExchange, scheduler.RecoveryDescriptorWithClock(b.clock)),
 		scheduler.NewRecoveryDescriptor(refresh, scheduler.RecoveryDescriptorWithClock(b.clock)),
 		scheduler.NewRecoveryDescriptor(reap, scheduler.RecoveryDescriptorWithClock(b.clock)),
+		scheduler.NewRecoveryDescriptor(notify, scheduler.RecoveryDescriptorWithClock(b.clock)),
+		scheduler.NewRecoveryDescriptor(revocation, scheduler.RecoveryDescriptorWithClock(b.clock)),
+		scheduler.NewRecoveryDescriptor(introspection, scheduler.RecoveryDescriptorWithClock(b.clock)),
 	}).WithErrorBehavior(scheduler.ErrorBehaviorDrop).Start(scheduler.LifecycleStartOptions{})
 	b.restartDescriptors = func() {
 		restartRefresh()
 		restartReap()
+		restartNotify()
+		restartIntrospection()
 	}
 
 	return nil
@@ -30,10 +54,17 @@ func (b *backend) reset() {
 	b.mut.Lock()
 	defer b.mut.Unlock()
 
-	if b.cache != nil {
-		b.cache.Close()
-		b.cache = nil
-	}
+	// Operations that are still using the current configuration can finish
+	// with it.
+	b.retireCache()
+
+	// The refresh schedule is rebuilt with the new configuration when the
+	// refresher restarts.
+	b.refreshSchedule.Reset()
+
+	// The revocation schedule is rebuilt in case the revoker was paused for
+	// maintenance.
+	b.revocationSchedule.Reset()
 
 	if b.restartDescriptors != nil {
 		b.restartDescriptors()
@@ -41,8 +72,10 @@ func (b *backend) reset() {
 }
 
 func (b *backend) invalidate(ctx context.Context, key string) {
-	if persistence.IsConfigKey(key) {
+	if persistence.IsConfigKey(key) || persistence.IsMigrationKey(key) {
 		b.reset()
+	} else if keyer, ok := persistence.AuthCodeKeyFromStorageKey(key); ok {
+		b.refreshSchedule.Invalidate(keyer)
 	}
 }
 
@@ -0,0 +1,324 @@
+package backend
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+// The responses in this file are published in the OpenAPI document that Vault
+// generates for the mount (sys/internal/specs/openapi). The SDK describes a
+// response by an example rather than a schema, so each example includes every
+// field the operation can return.
+
+// exampleTime is the time used in example responses.
+var exampleTime = time.Date(2021, 10, 14, 9, 21, 5, 0, time.UTC)
+
+// okResponse documents an operation that returns data like the given example.
+func okResponse(description string, example map[string]interface{}) map[int][]framework.Response {
+	return map[int][]framework.Response{
+		http.StatusOK: {{Description: description, Example: &logical.Response{Data: example}}},
+	}
+}
+
+// noContentResponse documents an operation that does not return any data.
+func noContentResponse(description string) map[int][]framework.Response {
+	return map[int][]framework.Response{
+		http.StatusNoContent: {{Description: description}},
+	}
+}
+
+// okOrNoContentResponse documents an operation that only returns data in
+// some cases, like a write that may need to be completed later.
+func okOrNoContentResponse(okDescription string, example map[string]interface{}, noContentDescription string) map[int][]framework.Response {
+	responses := okResponse(okDescription, example)
+	responses[http.StatusNoContent] = noContentResponse(noContentDescription)[http.StatusNoContent]
+	return responses
+}
+
+// listResponse documents a list operation whose keys are described like the
+// given example.
+func listResponse(description string, keyInfo map[string]interface{}) map[int][]framework.Response {
+	keys := make([]string, 0, len(keyInfo))
+	for k := range keyInfo {
+		keys = append(keys, k)
+	}
+
+	return map[int][]framework.Response{
+		http.StatusOK: {{Description: description, Example: logical.ListResponseWithInfo(keys, keyInfo)}},
+	}
+}
+
+var exampleConfig = &persistence.ConfigEntry{
+	ClientID:        "d0e6d1b4-9c1e-4a3b-bb5e-1b0c2c5ad0f8",
+	AuthURLParams:   map[string]string{"prompt": "consent"},
+	ProviderName:    "oidc",
+	ProviderVersion: 1,
+	ProviderOptions: map[string]string{"issuer_url": "https://login.example.com"},
+	LeaseTokens:     false,
+	Tuning:          persistence.DefaultConfigTuningEntry,
+}
+
+var (
+	configReadResponses      = okResponse("The current configuration, without the client secret.", configResponseData(exampleConfig))
+	configWriteResponses     = okOrNoContentResponse("The configuration was written, but some settings are likely to cause problems.", map[string]interface{}{}, "The configuration was written.")
+	configDeleteResponses    = noContentResponse("The configuration was deleted.")
+	configDefaultsResponses  = okResponse("The default settings.", configResponseData(&persistence.ConfigEntry{Tuning: persistence.DefaultConfigTuningEntry}))
+	configAuthCodeURLExample = map[string]interface{}{
+		"url":         "https://login.example.com/authorize?client_id=abc&response_type=code&state=gh0Lc4",
+		"nonce":       "Jt5cD0",
+		"state":       "gh0Lc4",
+		"expire_time": exampleTime,
+	}
+	configAuthCodeURLResponses = okResponse("The authorization code URL. The state is only returned if it was generated, and the nonce only if the provider supports one.", configAuthCodeURLExample)
+)
+
+var (
+	callbackResponses = okResponse("The authorization code was exchanged for a token.", map[string]interface{}{
+		"name": "alice",
+	})
+
+	configMigrateResponses = okResponse("The status of the storage migration.", map[string]interface{}{
+		"version":        int(persistence.StorageVersionLatest),
+		"latest_version": int(persistence.StorageVersionLatest),
+		"pending":        false,
+		"in_progress":    false,
+		"processed":      1024,
+		"started_time":   exampleTime,
+		"completed_time": exampleTime,
+		"last_error":     "",
+	})
+
+	configRegisterReadResponses = okResponse("The dynamic client registration.", map[string]interface{}{
+		"registration_endpoint":     "https://login.example.com/register",
+		"registration_client_uri":   "https://login.example.com/register/abc",
+		"client_id":                 "abc",
+		"metadata":                  map[string]interface{}{"client_name": "vault"},
+		"manageable":                true,
+		"register_time":             exampleTime,
+		"update_time":               exampleTime,
+		"client_secret_expire_time": exampleTime,
+	})
+	configRegisterWriteResponses = okResponse("The client was registered or updated.", map[string]interface{}{
+		"client_id":                          "abc",
+		"client_secret_expire_time":          exampleTime,
+		"previous_client_secret_expire_time": exampleTime,
+	})
+	configRegisterDeleteResponses = noContentResponse("The client was unregistered.")
+
+	configRotateResponses = okOrNoContentResponse("The client secret was rotated and the previous secret is still accepted until the given time.", map[string]interface{}{
+		"previous_client_secret_expire_time": exampleTime,
+	}, "The client secret was rotated.")
+
+	configSchedulerResponses = okResponse("The status of the automatic refresher.", map[string]interface{}{
+		"refresh_enabled":       true,
+		"maintenance_mode":      false,
+		"running":               true,
+		"scheduled_credentials": 12,
+		"due_credentials":       0,
+		"next_refresh_time":     exampleTime,
+		"last_check_time":       exampleTime,
+		"last_rebuild_time":     exampleTime,
+
+		"circuit_breaker_state":    "closed",
+		"circuit_breaker_failures": 0,
+	})
+	configUsageResponses = okResponse("Credential counts and storage usage.", map[string]interface{}{
+		"credentials":                     15,
+		"valid_credentials":               10,
+		"expired_refreshable_credentials": 2,
+		"expired_credentials":             0,
+		"revoked_credentials":             1,
+		"pending_credentials":             1,
+		"disabled_credentials":            1,
+		"storage_entries":                 24,
+		"storage_bytes":                   18432,
+	})
+
+	configSelfReadResponses = okResponse("The client credentials configuration.", map[string]interface{}{
+		"token_url_params": map[string]string{"audience": "https://api.example.com"},
+		"scopes":           []string{"read"},
+		"provider_options": map[string]string{},
+	})
+	configSelfWriteResponses  = noContentResponse("The client credentials configuration was written.")
+	configSelfDeleteResponses = noContentResponse("The client credentials configuration was deleted.")
+
+	exampleConfigTemplate = map[string]interface{}{
+		"name":             "engineering",
+		"scopes":           []string{"openid", "email"},
+		"redirect_url":     "https://app.example.com/callback",
+		"provider_options": map[string]string{},
+		"metadata":         map[string]string{"team": "engineering"},
+	}
+	configTemplatesListResponses = map[int][]framework.Response{
+		http.StatusOK: {{Description: "The names of the credential templates.", Example: logical.ListResponse([]string{"engineering"})}},
+	}
+	configTemplatesReadResponses   = okResponse("The credential template.", exampleConfigTemplate)
+	configTemplatesUpdateResponses = noContentResponse("The credential template was written.")
+	configTemplatesDeleteResponses = noContentResponse("The credential template was deleted.")
+
+	configTestResponses = okResponse("The result of each check.", map[string]interface{}{
+		"ok": true,
+		"checks": []interface{}{
+			map[string]interface{}{"name": "discovery", "status": "ok", "message": `provider "oidc" (version 1) loaded`},
+			map[string]interface{}{"name": "token_endpoint", "status": "ok", "message": "token endpoint https://login.example.com/token responded with HTTP status 400"},
+		},
+	})
+)
+
+var (
+	exampleJWTAccessTokenClaims = map[string]interface{}{
+		"iss":   "https://login.example.com",
+		"aud":   []string{"https://api.example.com"},
+		"exp":   exampleTime,
+		"scope": "read write",
+	}
+	exampleCredToken = map[string]interface{}{
+		"access_token":              "ya29.a0AfH6SM",
+		"access_token_fingerprint":  "4f1c0e3b",
+		"type":                      "Bearer",
+		"version":                   3,
+		"status":                    "ready",
+		"expire_time":               exampleTime,
+		"extra_data":                map[string]interface{}{"id_token": "eyJhbGciOi"},
+		"provider_options":          map[string]string{},
+		"access_token_claims":       exampleJWTAccessTokenClaims,
+		"metadata":                  map[string]string{"email": "alice@example.com"},
+		"template":                  "engineering",
+		"resources":                 []string{"https://api.example.com"},
+		"bound_entity_id":           "7d2e3179-f69b-450c-7179-ac8ee8bd8ca9",
+		"expired":                   false,
+		"refresh_attempts":          0,
+		"last_refresh_time":         exampleTime,
+		"last_refresh_error":        "",
+		"provider_response_code":    0,
+		"refresh_token_expire_time": exampleTime,
+		"reauthorize_time":          exampleTime,
+		"last_refresh_check_time":   exampleTime,
+		"next_scheduled_refresh":    exampleTime,
+		"inactive_time":             exampleTime,
+	}
+	credsListResponses = listResponse("The credentials matching the filters, if any.", map[string]interface{}{
+		"alice": map[string]interface{}{
+			"expired":             false,
+			"refreshable":         true,
+			"expire_time":         exampleTime,
+			"metadata":            map[string]string{"email": "alice@example.com"},
+			"access_token_claims": exampleJWTAccessTokenClaims,
+		},
+	})
+	credsReadResponses  = okResponse("A current access token for the credential.", exampleCredToken)
+	credsWriteResponses = okOrNoContentResponse("The credential is pending until the user completes a device code authorization or an asynchronous exchange finishes.", map[string]interface{}{
+		"status":           "pending",
+		"submit_time":      exampleTime,
+		"user_code":        "WDJB-MJHT",
+		"verification_uri": "https://login.example.com/device",
+		"expire_time":      exampleTime,
+	}, "The credential was written.")
+	credsDeleteResponses = noContentResponse("The credential was deleted.")
+
+	selfReadResponses = okResponse("A current access token issued using the client credentials flow.", map[string]interface{}{
+		"access_token":             "ya29.c0AfH6SM",
+		"access_token_fingerprint": "9a7b2c11",
+		"type":                     "Bearer",
+		"expire_time":              exampleTime,
+		"extra_data":               map[string]interface{}{},
+	})
+	selfDeleteResponses = noContentResponse("The cached token was deleted.")
+
+	credsStateResponses = noContentResponse("The state of the credential was changed.")
+
+	historyCredsResponses = okResponse("The most recent token exchanges and refreshes of the credential.", map[string]interface{}{
+		"events": []interface{}{
+			map[string]interface{}{
+				"time":                   exampleTime,
+				"event":                  "refresh-failed",
+				"error":                  "refresh failed: server error",
+				"provider_response_code": 503,
+			},
+			map[string]interface{}{
+				"time":  exampleTime,
+				"event": "exchanged",
+			},
+		},
+	})
+
+	emergencyCredsResponses = okResponse("An access token that expires or is revoked after the requested TTL.", map[string]interface{}{
+		"access_token": "ya29.e0AfH6SM",
+		"type":         "Bearer",
+		"expire_time":  exampleTime,
+	})
+
+	fingerprintResponses = okResponse("The fingerprint of the token.", map[string]interface{}{
+		"fingerprint": "4f1c0e3b",
+	})
+
+	userInfoCredsResponses = okResponse("The claims about the subject of the credential.", map[string]interface{}{
+		"claims":        map[string]interface{}{"sub": "248289761001", "email": "alice@example.com"},
+		"retrieve_time": exampleTime,
+	})
+)
+
+var (
+	examplePendingAuthorization = map[string]interface{}{
+		"reason":                    "refresh token will expire",
+		"reauthorization_due":       true,
+		"time":                      exampleTime,
+		"refresh_token_expire_time": exampleTime,
+	}
+	pendingAuthorizationsListResponses = listResponse("The credentials that must be authorized again.", map[string]interface{}{
+		"alice": examplePendingAuthorization,
+	})
+	pendingAuthorizationsReadResponses   = okResponse("Why and when the credential must be authorized again.", withExampleName(examplePendingAuthorization))
+	pendingAuthorizationsUpdateResponses = okResponse("An authorization code URL to authorize the credential again.", configAuthCodeURLExample)
+
+	exampleReapedCreds = map[string]interface{}{
+		"reason":      "refresh token revoked",
+		"reap_time":   exampleTime,
+		"expire_time": exampleTime,
+	}
+	reapedCredsListResponses = listResponse("The credentials that can be restored.", map[string]interface{}{
+		"alice": exampleReapedCreds,
+	})
+	reapedCredsReadResponses   = okResponse("Why and when the credential was reaped.", withExampleName(exampleReapedCreds))
+	reapedCredsDeleteResponses = noContentResponse("The reaped credential was purged.")
+	restoreCredsResponses      = noContentResponse("The credential was restored.")
+	rollbackCredsResponses     = noContentResponse("The credential was rolled back.")
+	renameCredsResponses       = noContentResponse("The credential was renamed.")
+)
+
+var (
+	exampleProviderFlows   = []string{"authorization_code", "device_code", "client_credentials", "token_exchange"}
+	providersListResponses = listResponse("The available providers.", map[string]interface{}{
+		"gitlab": map[string]interface{}{
+			"version": 2,
+			"flows":   exampleProviderFlows,
+		},
+	})
+	providersReadResponses = okResponse("The capabilities and options of the provider.", map[string]interface{}{
+		"name":    "gitlab",
+		"version": 2,
+		"flows":   exampleProviderFlows,
+		"options": map[string]interface{}{
+			"base_url": map[string]interface{}{
+				"type":        "url",
+				"description": "The URL of a self-managed GitLab instance, including any relative URL root. If not specified, GitLab.com is used.",
+				"required":    false,
+			},
+		},
+		"token_lifetime_seconds": 7200,
+	})
+)
+
+// withExampleName adds the name of a credential to the given example.
+func withExampleName(example map[string]interface{}) map[string]interface{} {
+	named := make(map[string]interface{}, len(example)+1)
+	for k, v := range example {
+		named[k] = v
+	}
+	named["name"] = "alice"
+	return named
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
 )
 
 // nameRegex allows any character other than a : followed by a /, which allows
@@ -16,20 +17,58 @@ func nameRegex(name string) string {
 
 func pathsSpecial() *logical.Paths {
 	return &logical.Paths{
-		SealWrapStorage: []string{
-			ConfigPath,
-			CredsPathPrefix,
-			SelfPathPrefix,
+		Unauthenticated: []string{
+			CallbackPath,
+			CallbackJARMPath,
 		},
+		SealWrapStorage: persistence.SealWrapStorage(),
+	}
+}
+
+func secrets(b *backend) []*framework.Secret {
+	return []*framework.Secret{
+		secretAccessToken(b),
 	}
 }
 
 func paths(b *backend) []*framework.Path {
-	return []*framework.Path{
+	return b.withCorrelation(b.withCacheLease([]*framework.Path{
+		pathAuthCodeURLCreds(b),
+		pathCallback(b),
+		pathCallbackJARM(b),
 		pathConfig(b),
 		pathConfigAuthCodeURL(b),
+		pathConfigDefaults(b),
+		pathConfigMigrate(b),
+		pathConfigPatch(b),
+		pathConfigRegister(b),
+		pathConfigRotate(b),
+		pathConfigScheduler(b),
 		pathConfigSelf(b),
+		pathConfigTemplatesList(b),
+		pathConfigTemplates(b),
+		pathConfigTest(b),
+		pathConfigUsage(b),
+		pathCredsList(b),
 		pathCreds(b),
+		pathDisableCreds(b),
+		pathEmergencyCreds(b),
+		pathEnableCreds(b),
+		pathFingerprint(b),
+		pathHistoryCreds(b),
+		pathOverrideCreds(b),
+		pathPendingAuthorizationsList(b),
+		pathPendingAuthorizations(b),
+		pathProvidersList(b),
+		pathProviders(b),
+		pathReapedCredsList(b),
+		pathReapedCreds(b),
+		pathRestoreCreds(b),
+		pathRollbackCreds(b),
+		pathRenameCreds(b),
 		pathSelf(b),
-	}
+		pathSelfCreds(b),
+		pathUserCreds(b),
+		pathUserInfoCreds(b),
+	}))
 }
@@ -0,0 +1,78 @@
+package backend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) authCodeURLCredsUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.authCodeURL(ctx, req, data, data.Get("name").(string), false)
+}
+
+const (
+	AuthCodeURLCredsPathPrefix = "auth-code-url/" + CredsPathPrefix
+)
+
+var authCodeURLCredsFields = func() map[string]*framework.FieldSchema {
+	fields := map[string]*framework.FieldSchema{
+		"name": {
+			Type:        framework.TypeString,
+			Description: "Specifies the name of the credential to create when the provider redirects to the callback endpoint.",
+		},
+		"state": {
+			Type:        framework.TypeString,
+			Description: "Specifies the state to set in the authorization code URL. If not specified, a signed state is generated.",
+		},
+		"state_ttl_seconds": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Specifies how long the state will be accepted.",
+			Default:     600,
+		},
+		"template": {
+			Type:        framework.TypeString,
+			Description: "Specifies the name of a credential template to inherit the redirect URL, scopes, and provider options from if they are not given. The credential also inherits the metadata of the template.",
+		},
+	}
+	for k, v := range configAuthCodeURLFields {
+		if _, found := fields[k]; !found {
+			fields[k] = v
+		}
+	}
+	return fields
+}()
+
+const authCodeURLCredsHelpSynopsis = `
+Generates authorization code URLs that create a credential.
+`
+
+const authCodeURLCredsHelpDescription = `
+This endpoint behaves like the config/auth_code_url endpoint, but binds
+the state to a new credential with the given name. When the provider
+redirects to the callback endpoint, the code is exchanged and the
+credential is created without any further requests. Because the
+callback endpoint does not require authentication, a policy that
+grants access to this endpoint for a name should be as restrictive as
+one that grants write access to the credential itself. The credential
+must not already exist.
+`
+
+func pathAuthCodeURLCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: AuthCodeURLCredsPathPrefix + nameRegex("name") + `$`,
+		Fields:  authCodeURLCredsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    withoutUserCreds(b.withCredBinding(b.authCodeURLCredsUpdateOperation)),
+				Summary:                     "Generate an authorization code URL that creates a credential.",
+				Responses:                   configAuthCodeURLResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(authCodeURLCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(authCodeURLCredsHelpDescription),
+	}
+}
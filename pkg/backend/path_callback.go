@@ -0,0 +1,185 @@
+package backend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+)
+
+// authorizationResponse holds the parameters of a redirect from the provider
+// to the callback endpoint.
+type authorizationResponse struct {
+	Code             string `json:"code"`
+	State            string `json:"state"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (b *backend) callbackReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.completeAuthorization(ctx, req.Storage, &authorizationResponse{
+		Code:             data.Get("code").(string),
+		State:            data.Get("state").(string),
+		Error:            data.Get("error").(string),
+		ErrorDescription: data.Get("error_description").(string),
+	})
+}
+
+// completeAuthorization exchanges the code in the given authorization response
+// and stores the resulting token in the credential that the state was
+// generated for.
+func (b *backend) completeAuthorization(ctx context.Context, storage logical.Storage, ar *authorizationResponse) (*logical.Response, error) {
+	// The code can only be exchanged once, so make sure we can store the
+	// result before we try.
+	if b.readOnly() {
+		return nil, logical.ErrReadOnly
+	}
+
+	// Leave the state unused so the user can try again after maintenance.
+	if resp, err := b.maintenanceResponse(ctx, storage); err != nil || resp != nil {
+		return resp, err
+	}
+
+	if resp, err := b.grantTypeResponse(ctx, storage, "authorization_code"); err != nil || resp != nil {
+		return resp, err
+	}
+
+	if ar.State == "" {
+		return errorResponse(ErrorCodeInvalidRequest, "missing state"), nil
+	}
+
+	// Each state may only be used once, regardless of the outcome of the
+	// exchange.
+	entry, err := b.consumeState(ctx, storage, ar.State, "")
+	if err != nil {
+		return nil, err
+	} else if entry == nil {
+		return errorResponse(ErrorCodeInvalidState, "unknown or expired state"), nil
+	}
+
+	if ar.Error != "" {
+		msg := ar.Error
+		if ar.ErrorDescription != "" {
+			msg += ": " + ar.ErrorDescription
+		}
+
+		return errorResponse(ErrorCodeProviderRejected, "authorization failed: %s", msg), nil
+	}
+
+	if ar.Code == "" {
+		return errorResponse(ErrorCodeInvalidRequest, "missing code"), nil
+	}
+
+	if resp, err := b.redirectURLResponse(ctx, storage, entry.RedirectURL); err != nil || resp != nil {
+		return resp, err
+	}
+
+	tmpl, resp, err := b.readCredTemplate(ctx, storage, entry.Template)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+
+	// Unless the state was generated to authorize an existing credential
+	// again, the flow may only create a new credential, subject to the same
+	// limits as writing it directly.
+	created, release, resp, err := b.credQuotaResponse(ctx, storage, entry.EntityID, entry.CredentialName)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+	defer release()
+
+	if !created && !entry.Reauthorize {
+		return errorResponse(ErrorCodeInvalidRequest, "a credential with this name already exists"), nil
+	}
+
+	cu := &credUpdate{}
+	if created {
+		cu.creatorEntityID = entry.EntityID
+	}
+
+	resp, err = b.authCodeExchange(
+		withCredUpdate(ctx, cu),
+		storage,
+		persistence.AuthCodeName(entry.CredentialName),
+		tmpl,
+		ar.Code,
+		provider.WithRedirectURL(entry.RedirectURL),
+		provider.WithResources(entry.Resources),
+		provider.WithProviderOptions(entry.ProviderOptions),
+	)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+
+	if err := b.logCredCreated(ctx, storage, entry.CredentialName, "authorization_code"); err != nil {
+		return nil, err
+	}
+
+	resp = &logical.Response{
+		Data: map[string]interface{}{
+			"name": entry.CredentialName,
+		},
+	}
+	return resp, nil
+}
+
+const (
+	CallbackPath = "callback"
+)
+
+var callbackFields = map[string]*framework.FieldSchema{
+	"code": {
+		Type:        framework.TypeString,
+		Description: "Specifies the authorization code returned by the provider.",
+		Query:       true,
+	},
+	"state": {
+		Type:        framework.TypeString,
+		Description: "Specifies the state given to the authorization code URL.",
+		Query:       true,
+	},
+	"error": {
+		Type:        framework.TypeString,
+		Description: "Specifies the error code returned by the provider if authorization failed.",
+		Query:       true,
+	},
+	"error_description": {
+		Type:        framework.TypeString,
+		Description: "Specifies the description of the error returned by the provider.",
+		Query:       true,
+	},
+}
+
+const callbackHelpSynopsis = `
+Completes authorization code flows started by this plugin.
+`
+
+const callbackHelpDescription = `
+This endpoint receives redirects from the provider after a user has
+authorized the application. If the state was given to the
+auth-code-url/creds/<name> endpoint, the code is exchanged and the
+resulting token is stored in that credential, which must not already
+exist unless the state was generated to authorize it again.
+
+This endpoint does not require authentication. The state must be
+unpredictable and is only accepted once.
+`
+
+func pathCallback(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: CallbackPath + `$`,
+		Fields:  callbackFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.callbackReadOperation,
+				Summary:   "Exchange an authorization code returned by the provider.",
+				Responses: callbackResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(callbackHelpSynopsis),
+		HelpDescription: strings.TrimSpace(callbackHelpDescription),
+	}
+}
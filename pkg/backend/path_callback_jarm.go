@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func (b *backend) callbackJARMReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	response, ok := data.GetOk("response")
+	if !ok {
+		return errorResponse(ErrorCodeInvalidRequest, "missing response"), nil
+	}
+
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	} else if c.JARMKeySet == nil {
+		return errorResponse(ErrorCodeUnsupported, "JWT-secured authorization responses are not enabled in the configuration"), nil
+	}
+
+	payload, err := c.JARMKeySet.VerifySignature(ctx, response.(string))
+	if err != nil {
+		return errorResponse(ErrorCodeInvalidRequest, "invalid response: %+v", err), nil
+	}
+
+	var claims struct {
+		jwt.Claims
+		authorizationResponse
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return errorResponse(ErrorCodeInvalidRequest, "invalid response: %+v", err), nil
+	}
+
+	// The response is only valid for a short time, so it must have an
+	// expiration time.
+	if claims.Expiry == nil {
+		return errorResponse(ErrorCodeInvalidRequest, "invalid response: missing expiration time"), nil
+	} else if err := claims.Claims.ValidateWithLeeway(jwt.Expected{
+		Issuer:   c.Config.JARMIssuer,
+		Audience: jwt.Audience{c.Config.ClientID},
+		Time:     b.clock.Now(),
+	}, jwt.DefaultLeeway+expiryLeeway(c.Config.Tuning)); err != nil {
+		return errorResponse(ErrorCodeInvalidRequest, "invalid response: %+v", err), nil
+	}
+
+	return b.completeAuthorization(ctx, req.Storage, &claims.authorizationResponse)
+}
+
+const (
+	CallbackJARMPath = CallbackPath + "/jarm"
+)
+
+var callbackJARMFields = map[string]*framework.FieldSchema{
+	"response": {
+		Type:        framework.TypeString,
+		Description: "Specifies the JWT-secured authorization response returned by the provider.",
+		Query:       true,
+	},
+}
+
+const callbackJARMHelpSynopsis = `
+Completes authorization code flows that use JWT-secured authorization responses.
+`
+
+const callbackJARMHelpDescription = `
+This endpoint is like the callback endpoint, but receives redirects from
+providers that return the authorization response as a signed JWT (JARM).
+The signature is verified using the keys at the configured JARM JWKS URL,
+and the issuer, audience, and expiration time of the response are
+checked before the code is exchanged.
+
+This endpoint does not require authentication.
+`
+
+func pathCallbackJARM(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: CallbackJARMPath + `$`,
+		Fields:  callbackJARMFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.callbackJARMReadOperation,
+				Summary:   "Exchange an authorization code returned by the provider in a JWT-secured authorization response.",
+				Responses: callbackResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(callbackJARMHelpSynopsis),
+		HelpDescription: strings.TrimSpace(callbackJARMHelpDescription),
+	}
+}
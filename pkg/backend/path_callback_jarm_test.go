@@ -0,0 +1,144 @@
+package backend_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestCallbackJARM(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "key-1", Algorithm: string(jose.ES256), Use: "sig"}},
+		})
+	}))
+	defer jwks.Close()
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jose.JSONWebKey{Key: key, KeyID: "key-1"}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	require.NoError(t, err)
+
+	sign := func(issuer, audience, state string) string {
+		tok, err := jwt.Signed(signer).
+			Claims(map[string]interface{}{"state": state, "code": "123456"}).
+			Claims(&jwt.Claims{
+				Issuer:   issuer,
+				Audience: jwt.Audience{audience},
+				Expiry:   jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			}).
+			CompactSerialize()
+		require.NoError(t, err)
+		return tok
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.RestrictMockAuthCodeExchange(map[string]testutil.MockAuthCodeExchangeFunc{
+			"123456": testutil.IncrementMockAuthCodeExchange("token_"),
+		})),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	config := map[string]interface{}{
+		"client_id":     client.ID,
+		"client_secret": client.Secret,
+		"provider":      "mock",
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Responses are rejected until the key set is configured.
+	resp = handle(logical.ReadOperation, backend.CallbackJARMPath, map[string]interface{}{
+		"response": sign("https://example.com", client.ID, "qwerty"),
+	})
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	config["jarm_jwks_url"] = jwks.URL
+	config["jarm_issuer"] = "https://example.com"
+	resp = handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.UpdateOperation, backend.AuthCodeURLCredsPathPrefix+"test", map[string]interface{}{
+		"state":        "qwerty",
+		"redirect_url": "http://example.com/redirect",
+	})
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Responses from another issuer or for another client are rejected
+	// without using the state.
+	for _, response := range []string{
+		sign("https://attacker.example.com", client.ID, "qwerty"),
+		sign("https://example.com", "xyz", "qwerty"),
+		"not-a-jwt",
+	} {
+		resp = handle(logical.ReadOperation, backend.CallbackJARMPath, map[string]interface{}{
+			"response": response,
+		})
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+
+		code, ok := backend.ParseErrorCode(resp.Error().Error())
+		require.True(t, ok)
+		assert.Equal(t, backend.ErrorCodeInvalidRequest, code)
+	}
+
+	resp = handle(logical.ReadOperation, backend.CallbackJARMPath, map[string]interface{}{
+		"response": sign("https://example.com", client.ID, "qwerty"),
+	})
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "test", resp.Data["name"])
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "token_1", resp.Data["access_token"])
+}
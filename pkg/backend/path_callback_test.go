@@ -0,0 +1,218 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.RestrictMockAuthCodeExchange(map[string]testutil.MockAuthCodeExchangeFunc{
+			"123456": testutil.IncrementMockAuthCodeExchange("token_"),
+		})),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Start authorization code flows for two credentials.
+	for state, name := range map[string]string{"qwerty": "test", "asdfgh": "other"} {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.AuthCodeURLCredsPathPrefix + name,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"state":        state,
+				"redirect_url": "http://example.com/redirect",
+			},
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+		require.NotEmpty(t, resp.Data["expire_time"])
+	}
+
+	callback := func(data map[string]interface{}) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CallbackPath,
+			Storage:   storage,
+			Data:      data,
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	// Unknown states are rejected.
+	resp = callback(map[string]interface{}{"state": "zxcvbn", "code": "123456"})
+	assert.True(t, resp.IsError())
+
+	// The provider redirects back with a code.
+	resp = callback(map[string]interface{}{"state": "qwerty", "code": "123456"})
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "test", resp.Data["name"])
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "token_1", resp.Data["access_token"])
+
+	// The state cannot be reused.
+	resp = callback(map[string]interface{}{"state": "qwerty", "code": "123456"})
+	assert.True(t, resp.IsError())
+
+	// Errors from the provider are reported and also consume the state.
+	resp = callback(map[string]interface{}{"state": "asdfgh", "error": "access_denied", "error_description": "user declined"})
+	require.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "access_denied: user declined")
+
+	resp = callback(map[string]interface{}{"state": "asdfgh", "code": "123456"})
+	assert.True(t, resp.IsError())
+}
+
+func TestCallbackOnlyCreatesCredentials(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.IncrementMockAuthCodeExchange("token_")),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(op logical.Operation, path, entityID string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			EntityID:  entityID,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, "", map[string]interface{}{
+		"client_id":                       client.ID,
+		"client_secret":                   client.Secret,
+		"provider":                        "mock",
+		"tune_max_credentials_per_entity": 1,
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+"existing", "", map[string]interface{}{
+		"code": "test",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// A URL can't be bound to a credential that already exists.
+	resp = handle(logical.UpdateOperation, backend.AuthCodeURLCredsPathPrefix+"existing", "", map[string]interface{}{
+		"state": "qwerty",
+	})
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	// Nor can the callback replace a credential created after the URL was
+	// generated.
+	resp = handle(logical.UpdateOperation, backend.AuthCodeURLCredsPathPrefix+"racy", "", map[string]interface{}{
+		"state": "asdfgh",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+"racy", "", map[string]interface{}{
+		"code": "test",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.CallbackPath, "", map[string]interface{}{
+		"state": "asdfgh",
+		"code":  "test",
+	})
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"racy", "", nil)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "token_2", resp.Data["access_token"])
+
+	// Credentials created by the callback count toward the quota of the
+	// entity that generated the URL.
+	for state, name := range map[string]string{"zxcvbn": "alice-1", "poiuyt": "alice-2"} {
+		resp = handle(logical.UpdateOperation, backend.AuthCodeURLCredsPathPrefix+name, "alice", map[string]interface{}{
+			"state": state,
+		})
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	resp = handle(logical.ReadOperation, backend.CallbackPath, "", map[string]interface{}{
+		"state": "zxcvbn",
+		"code":  "test",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.CallbackPath, "", map[string]interface{}{
+		"state": "poiuyt",
+		"code":  "test",
+	})
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	code, ok := backend.ParseErrorCode(resp.Error().Error())
+	require.True(t, ok)
+	assert.Equal(t, backend.ErrorCodeQuotaExceeded, code)
+}
@@ -3,6 +3,9 @@ package backend
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
 	"strings"
 	"time"
 
@@ -13,6 +16,91 @@ import (
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
 )
 
+// configResponseData returns the fields of the given configuration as they
+// are read from the config endpoint. Every field that can be written is
+// included, except for the client secret and request object signing key, so
+// that tools like Terraform can compare the configuration with the one they
+// would write.
+func configResponseData(c *persistence.ConfigEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"client_id":        c.ClientID,
+		"auth_url_params":  normalizeStringMap(c.AuthURLParams, false),
+		"provider":         c.ProviderName,
+		"provider_version": c.ProviderVersion,
+		"provider_options": normalizeStringMap(c.ProviderOptions, true),
+		"claim_metadata":   normalizeStringMap(c.ClaimMetadata, true),
+
+		"lease_tokens":      c.LeaseTokens,
+		"token_ttl_seconds": c.TokenTTLSeconds,
+
+		"default_token_lifetime_seconds": c.DefaultTokenLifetimeSeconds,
+
+		"allow_password_grant": c.AllowPasswordGrant,
+		"allowed_grant_types":  normalizeStringSlice(c.AllowedGrantTypes),
+
+		"allowed_redirect_urls": normalizeStringSlice(c.AllowedRedirectURLs),
+
+		"allowed_scopes": normalizeStringSlice(c.AllowedScopes),
+		"denied_scopes":  normalizeStringSlice(c.DeniedScopes),
+
+		"allowed_read_provider_options": normalizeStringSlice(c.AllowedReadProviderOptions),
+
+		"request_object_signing_key_id":    c.RequestObjectSigningKeyID,
+		"request_object_signing_algorithm": c.RequestObjectSigningAlgorithm,
+		"request_object_audience":          c.RequestObjectAudience,
+
+		"jarm_jwks_url": c.JARMJWKSURL,
+		"jarm_issuer":   c.JARMIssuer,
+
+		"decode_jwt_access_tokens":  c.DecodeJWTAccessTokens,
+		"jwt_access_token_jwks_url": c.JWTAccessTokenJWKSURL,
+
+		"reauthorization_webhook_url": c.ReauthorizationWebhookURL,
+
+		"maintenance_mode": c.MaintenanceMode,
+
+		"redact_tokens": c.RedactTokens,
+
+		"tracing_otlp_endpoint": c.TracingOTLPEndpoint,
+
+		"event_log_file":   c.EventLogFile,
+		"event_log_syslog": c.EventLogSyslog,
+
+		"tune_provider_timeout_seconds":              c.Tuning.ProviderTimeoutSeconds,
+		"tune_provider_timeout_expiry_leeway_factor": c.Tuning.ProviderTimeoutExpiryLeewayFactor,
+
+		"tune_refresh_check_interval_seconds": c.Tuning.RefreshCheckIntervalSeconds,
+		"tune_refresh_expiry_delta_factor":    c.Tuning.RefreshExpiryDeltaFactor,
+		"tune_refresh_before_expiry_seconds":  c.Tuning.RefreshBeforeExpirySeconds,
+		"tune_expiry_leeway_seconds":          c.Tuning.ExpiryLeewaySeconds,
+
+		"tune_reap_check_interval_seconds":   c.Tuning.ReapCheckIntervalSeconds,
+		"tune_reap_dry_run":                  c.Tuning.ReapDryRun,
+		"tune_reap_non_refreshable_seconds":  c.Tuning.ReapNonRefreshableSeconds,
+		"tune_reap_revoked_seconds":          c.Tuning.ReapRevokedSeconds,
+		"tune_reap_transient_error_attempts": c.Tuning.ReapTransientErrorAttempts,
+		"tune_reap_transient_error_seconds":  c.Tuning.ReapTransientErrorSeconds,
+		"tune_reap_quarantine_seconds":       c.Tuning.ReapQuarantineSeconds,
+		"tune_reap_deleted_entities":         c.Tuning.ReapDeletedEntities,
+
+		"tune_introspection_check_interval_seconds": c.Tuning.IntrospectionCheckIntervalSeconds,
+		"tune_introspection_sample_size":            c.Tuning.IntrospectionSampleSize,
+
+		"tune_max_credential_versions":    c.Tuning.MaxCredentialVersions,
+		"tune_max_credential_history":     c.Tuning.MaxCredentialHistory,
+		"tune_max_credentials":            c.Tuning.MaxCredentials,
+		"tune_max_credentials_per_entity": c.Tuning.MaxCredentialsPerEntity,
+
+		"tune_storage_scan_page_size":        c.Tuning.StorageScanPageSize,
+		"tune_storage_scan_pages_per_second": c.Tuning.StorageScanPagesPerSecond,
+
+		"tune_circuit_breaker_failures":          c.Tuning.CircuitBreakerFailures,
+		"tune_circuit_breaker_cool_down_seconds": c.Tuning.CircuitBreakerCoolDownSeconds,
+
+		"tune_user_info_cache_seconds": c.Tuning.UserInfoCacheSeconds,
+	}
+}
+
 func (b *backend) configReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	c, err := b.getCache(ctx, req.Storage)
 	if err != nil {
@@ -22,97 +110,329 @@ func (b *backend) configReadOperation(ctx context.Context, req *logical.Request,
 	}
 
 	resp := &logical.Response{
-		Data: map[string]interface{}{
-			"client_id":        c.Config.ClientID,
-			"auth_url_params":  c.Config.AuthURLParams,
-			"provider":         c.Config.ProviderName,
-			"provider_version": c.Config.ProviderVersion,
-			"provider_options": c.Config.ProviderOptions,
-
-			"tune_provider_timeout_seconds":              c.Config.Tuning.ProviderTimeoutSeconds,
-			"tune_provider_timeout_expiry_leeway_factor": c.Config.Tuning.ProviderTimeoutExpiryLeewayFactor,
-
-			"tune_refresh_check_interval_seconds": c.Config.Tuning.RefreshCheckIntervalSeconds,
-			"tune_refresh_expiry_delta_factor":    c.Config.Tuning.RefreshExpiryDeltaFactor,
-
-			"tune_reap_check_interval_seconds":   c.Config.Tuning.ReapCheckIntervalSeconds,
-			"tune_reap_dry_run":                  c.Config.Tuning.ReapDryRun,
-			"tune_reap_non_refreshable_seconds":  c.Config.Tuning.ReapNonRefreshableSeconds,
-			"tune_reap_revoked_seconds":          c.Config.Tuning.ReapRevokedSeconds,
-			"tune_reap_transient_error_attempts": c.Config.Tuning.ReapTransientErrorAttempts,
-			"tune_reap_transient_error_seconds":  c.Config.Tuning.ReapTransientErrorSeconds,
-		},
+		Data: configResponseData(c.Config),
 	}
+
+	if c.Config.PreviousClientSecretValid(b.clock.Now()) {
+		resp.Data["previous_client_secret_expire_time"] = c.Config.PreviousClientSecretExpireTime
+	}
+
 	return resp, nil
 }
 
 func (b *backend) configUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	clientID, ok := data.GetOk("client_id")
-	if !ok {
-		return logical.ErrorResponse("missing client ID"), nil
+	if _, ok := data.GetOk("client_id"); !ok {
+		return errorResponse(ErrorCodeInvalidRequest, "missing client ID"), nil
 	}
 
-	providerName, ok := data.GetOk("provider")
-	if !ok {
-		return logical.ErrorResponse("missing provider"), nil
+	if _, ok := data.GetOk("provider"); !ok {
+		return errorResponse(ErrorCodeInvalidRequest, "missing provider"), nil
 	}
 
-	providerOptions := data.Get("provider_options").(map[string]string)
-
-	p, err := b.providerRegistry.New(ctx, providerName.(string), providerOptions)
-	if errors.Is(err, provider.ErrNoSuchProvider) {
-		return logical.ErrorResponse("provider %q does not exist", providerName), nil
-	} else if errmark.MarkedUser(err) {
-		return logical.ErrorResponse(errmark.MarkShort(err).Error()), nil
+	var sve *persistence.StorageVersionError
+	if err := b.checkStorageVersion(ctx, req.Storage); errors.As(err, &sve) {
+		return errorResponse(ErrorCodeStorageVersion, err.Error()), nil
 	} else if err != nil {
 		return nil, err
 	}
 
-	c := &persistence.ConfigEntry{
-		Version:         persistence.ConfigVersionLatest,
-		ClientID:        clientID.(string),
-		ClientSecret:    data.Get("client_secret").(string),
-		AuthURLParams:   data.Get("auth_url_params").(map[string]string),
-		ProviderName:    providerName.(string),
-		ProviderVersion: p.Version(),
-		ProviderOptions: providerOptions,
+	c := configEntryFromFieldData(data)
+	if resp, err := b.validateConfig(ctx, c, true); err != nil || resp != nil {
+		return resp, err
+	}
+
+	// Writing the same configuration again, as tools that manage the mount
+	// declaratively do, must not restart the background processes or end the
+	// grace period of a rotated client secret.
+	prev, err := b.data.Managers(req.Storage).Config().ReadConfig(ctx)
+	if err != nil {
+		return nil, err
+	} else if configEqual(prev, c) {
+		return b.configWarningResponse(c), nil
+	}
+
+	if err := b.data.Managers(req.Storage).Config().WriteConfig(ctx, c); err != nil {
+		return nil, err
+	}
+
+	// States can be generated as soon as the mount is configured, so make
+	// sure the key used to sign them exists even if initialize could not
+	// create it.
+	if _, err := b.data.Managers(req.Storage).AuthCodeState().ReadOrCreateSigningKey(ctx); err != nil {
+		return nil, err
+	}
+
+	b.reset()
+
+	return b.configWarningResponse(c), nil
+}
+
+// configExistenceCheck reports whether the mount has been configured, so that
+// the first write to the config endpoint is a create operation.
+func (b *backend) configExistenceCheck(ctx context.Context, req *logical.Request, _ *framework.FieldData) (bool, error) {
+	c, err := b.data.Managers(req.Storage).Config().ReadConfig(ctx)
+	return c != nil, err
+}
+
+// normalizeStringMap returns a copy of the given map that is never nil. If
+// dropEmpty is true, entries with empty values are omitted, as they are
+// equivalent to not setting the key at all.
+func normalizeStringMap(m map[string]string, dropEmpty bool) map[string]string {
+	nm := make(map[string]string, len(m))
+	for k, v := range m {
+		if dropEmpty && v == "" {
+			continue
+		}
+		nm[k] = v
+	}
+	return nm
+}
+
+// normalizeStringSlice returns a copy of the given slice that is never nil.
+func normalizeStringSlice(s []string) []string {
+	return append([]string{}, s...)
+}
+
+// configEqual returns true if writing the configuration b would not change
+// the stored configuration a. The state of a previous client secret is not
+// compared because it is not set by a write.
+func configEqual(a, b *persistence.ConfigEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	ac, bc := *a, *b
+	for _, c := range []*persistence.ConfigEntry{&ac, &bc} {
+		c.AuthURLParams = normalizeStringMap(c.AuthURLParams, false)
+		c.ProviderOptions = normalizeStringMap(c.ProviderOptions, true)
+		c.ClaimMetadata = normalizeStringMap(c.ClaimMetadata, true)
+		c.AllowedGrantTypes = normalizeStringSlice(c.AllowedGrantTypes)
+		c.AllowedRedirectURLs = normalizeStringSlice(c.AllowedRedirectURLs)
+		c.AllowedScopes = normalizeStringSlice(c.AllowedScopes)
+		c.DeniedScopes = normalizeStringSlice(c.DeniedScopes)
+		c.AllowedReadProviderOptions = normalizeStringSlice(c.AllowedReadProviderOptions)
+		c.PreviousClientSecret = ""
+		c.PreviousClientSecretExpireTime = time.Time{}
+	}
+
+	return reflect.DeepEqual(ac, bc)
+}
+
+// configEntryFromFieldData creates a configuration from the fields of a
+// request. The provider version is set by validateConfig.
+func configEntryFromFieldData(data *framework.FieldData) *persistence.ConfigEntry {
+	return &persistence.ConfigEntry{
+		Version:                       persistence.ConfigVersionLatest,
+		ClientID:                      data.Get("client_id").(string),
+		ClientSecret:                  data.Get("client_secret").(string),
+		AuthURLParams:                 normalizeStringMap(data.Get("auth_url_params").(map[string]string), false),
+		ProviderName:                  data.Get("provider").(string),
+		ProviderOptions:               normalizeStringMap(data.Get("provider_options").(map[string]string), true),
+		ClaimMetadata:                 normalizeStringMap(data.Get("claim_metadata").(map[string]string), true),
+		LeaseTokens:                   data.Get("lease_tokens").(bool),
+		TokenTTLSeconds:               data.Get("token_ttl_seconds").(int),
+		DefaultTokenLifetimeSeconds:   data.Get("default_token_lifetime_seconds").(int),
+		AllowPasswordGrant:            data.Get("allow_password_grant").(bool),
+		AllowedGrantTypes:             normalizeStringSlice(data.Get("allowed_grant_types").([]string)),
+		AllowedRedirectURLs:           normalizeStringSlice(data.Get("allowed_redirect_urls").([]string)),
+		AllowedScopes:                 normalizeStringSlice(data.Get("allowed_scopes").([]string)),
+		DeniedScopes:                  normalizeStringSlice(data.Get("denied_scopes").([]string)),
+		AllowedReadProviderOptions:    normalizeStringSlice(data.Get("allowed_read_provider_options").([]string)),
+		RequestObjectSigningKey:       data.Get("request_object_signing_key").(string),
+		RequestObjectSigningKeyID:     data.Get("request_object_signing_key_id").(string),
+		RequestObjectSigningAlgorithm: data.Get("request_object_signing_algorithm").(string),
+		RequestObjectAudience:         data.Get("request_object_audience").(string),
+		JARMJWKSURL:                   data.Get("jarm_jwks_url").(string),
+		JARMIssuer:                    data.Get("jarm_issuer").(string),
+		DecodeJWTAccessTokens:         data.Get("decode_jwt_access_tokens").(bool),
+		JWTAccessTokenJWKSURL:         data.Get("jwt_access_token_jwks_url").(string),
+		ReauthorizationWebhookURL:     data.Get("reauthorization_webhook_url").(string),
+		MaintenanceMode:               data.Get("maintenance_mode").(bool),
+		RedactTokens:                  data.Get("redact_tokens").(bool),
+		TracingOTLPEndpoint:           data.Get("tracing_otlp_endpoint").(string),
+		EventLogFile:                  data.Get("event_log_file").(string),
+		EventLogSyslog:                data.Get("event_log_syslog").(bool),
 		Tuning: persistence.ConfigTuningEntry{
 			ProviderTimeoutSeconds:            data.Get("tune_provider_timeout_seconds").(int),
 			ProviderTimeoutExpiryLeewayFactor: data.Get("tune_provider_timeout_expiry_leeway_factor").(float64),
 			RefreshCheckIntervalSeconds:       data.Get("tune_refresh_check_interval_seconds").(int),
 			RefreshExpiryDeltaFactor:          data.Get("tune_refresh_expiry_delta_factor").(float64),
+			RefreshBeforeExpirySeconds:        data.Get("tune_refresh_before_expiry_seconds").(int),
+			ExpiryLeewaySeconds:               data.Get("tune_expiry_leeway_seconds").(int),
 			ReapCheckIntervalSeconds:          data.Get("tune_reap_check_interval_seconds").(int),
 			ReapDryRun:                        data.Get("tune_reap_dry_run").(bool),
 			ReapNonRefreshableSeconds:         data.Get("tune_reap_non_refreshable_seconds").(int),
 			ReapRevokedSeconds:                data.Get("tune_reap_revoked_seconds").(int),
 			ReapTransientErrorAttempts:        data.Get("tune_reap_transient_error_attempts").(int),
 			ReapTransientErrorSeconds:         data.Get("tune_reap_transient_error_seconds").(int),
+			ReapQuarantineSeconds:             data.Get("tune_reap_quarantine_seconds").(int),
+			ReapDeletedEntities:               data.Get("tune_reap_deleted_entities").(bool),
+			IntrospectionCheckIntervalSeconds: data.Get("tune_introspection_check_interval_seconds").(int),
+			IntrospectionSampleSize:           data.Get("tune_introspection_sample_size").(int),
+			MaxCredentialVersions:             data.Get("tune_max_credential_versions").(int),
+			MaxCredentialHistory:              data.Get("tune_max_credential_history").(int),
+			MaxCredentials:                    data.Get("tune_max_credentials").(int),
+			MaxCredentialsPerEntity:           data.Get("tune_max_credentials_per_entity").(int),
+			StorageScanPageSize:               data.Get("tune_storage_scan_page_size").(int),
+			StorageScanPagesPerSecond:         data.Get("tune_storage_scan_pages_per_second").(float64),
+			CircuitBreakerFailures:            data.Get("tune_circuit_breaker_failures").(int),
+			CircuitBreakerCoolDownSeconds:     data.Get("tune_circuit_breaker_cool_down_seconds").(int),
+			UserInfoCacheSeconds:              data.Get("tune_user_info_cache_seconds").(int),
 		},
 	}
+}
+
+// validateConfig checks that the provider exists and accepts its options and
+// that the other settings of the given configuration are sensible. If
+// upgradeProvider is true, the configuration is set to use the latest version
+// of the provider.
+func (b *backend) validateConfig(ctx context.Context, c *persistence.ConfigEntry, upgradeProvider bool) (*logical.Response, error) {
+	p, err := b.providerRegistry.New(ctx, c.ProviderName, c.ProviderOptions)
+	if errors.Is(err, provider.ErrNoSuchProvider) {
+		return errorResponse(ErrorCodeInvalidRequest, "provider %q does not exist", c.ProviderName), nil
+	} else if errmark.MarkedUser(err) {
+		return errorResponse(ErrorCodeInvalidRequest, errmark.MarkShort(err).Error()), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if upgradeProvider {
+		c.ProviderVersion = p.Version()
+	}
 
 	// Sanity checks for tuning options.
 	switch {
+	case c.TokenTTLSeconds < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "token TTL cannot be negative"), nil
+	case c.DefaultTokenLifetimeSeconds < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "default token lifetime cannot be negative"), nil
 	case c.Tuning.ProviderTimeoutExpiryLeewayFactor < 1:
-		return logical.ErrorResponse("provider timeout expiry leeway factor must be at least 1.0"), nil
+		return errorResponse(ErrorCodeInvalidRequest, "provider timeout expiry leeway factor must be at least 1.0"), nil
 	case c.Tuning.RefreshCheckIntervalSeconds > int((90 * 24 * time.Hour).Seconds()):
-		return logical.ErrorResponse("refresh check interval can be at most 90 days"), nil
+		return errorResponse(ErrorCodeInvalidRequest, "refresh check interval can be at most 90 days"), nil
 	case c.Tuning.RefreshExpiryDeltaFactor < 1:
-		return logical.ErrorResponse("refresh expiry delta factor must be at least 1.0"), nil
+		return errorResponse(ErrorCodeInvalidRequest, "refresh expiry delta factor must be at least 1.0"), nil
+	case c.Tuning.RefreshBeforeExpirySeconds < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "refresh before expiry cannot be negative"), nil
+	case c.Tuning.ExpiryLeewaySeconds < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "expiry leeway cannot be negative"), nil
 	case c.Tuning.ReapCheckIntervalSeconds > int((180 * 24 * time.Hour).Seconds()):
-		return logical.ErrorResponse("reap check interval can be at most 180 days"), nil
+		return errorResponse(ErrorCodeInvalidRequest, "reap check interval can be at most 180 days"), nil
 	case c.Tuning.ReapTransientErrorAttempts < 0:
-		return logical.ErrorResponse("reap transient error attempts cannot be negative"), nil
+		return errorResponse(ErrorCodeInvalidRequest, "reap transient error attempts cannot be negative"), nil
+	case c.Tuning.ReapQuarantineSeconds < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "reap quarantine time cannot be negative"), nil
+	case c.Tuning.IntrospectionSampleSize < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "introspection sample size cannot be negative"), nil
+	case c.Tuning.MaxCredentialVersions < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "max credential versions cannot be negative"), nil
+	case c.Tuning.MaxCredentialHistory < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "max credential history cannot be negative"), nil
+	case c.Tuning.MaxCredentials < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "max credentials cannot be negative"), nil
+	case c.Tuning.MaxCredentialsPerEntity < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "max credentials per entity cannot be negative"), nil
+	case c.Tuning.StorageScanPageSize <= 0:
+		return errorResponse(ErrorCodeInvalidRequest, "storage scan page size must be positive"), nil
+	case c.Tuning.StorageScanPagesPerSecond < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "storage scan pages per second cannot be negative"), nil
+	case c.Tuning.CircuitBreakerFailures < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "circuit breaker failures cannot be negative"), nil
+	case c.Tuning.CircuitBreakerFailures > 0 && c.Tuning.CircuitBreakerCoolDownSeconds <= 0:
+		return errorResponse(ErrorCodeInvalidRequest, "circuit breaker cool-down must be positive"), nil
+	case c.Tuning.UserInfoCacheSeconds < 0:
+		return errorResponse(ErrorCodeInvalidRequest, "user info cache duration cannot be negative"), nil
 	}
 
-	if err := b.data.Managers(req.Storage).Config().WriteConfig(ctx, c); err != nil {
-		return nil, err
+	for _, grantType := range c.AllowedGrantTypes {
+		if _, found := credUpdateGrantHandlers[grantType]; !found && grantType != ClientCredentialsGrantType {
+			return errorResponse(ErrorCodeInvalidRequest, "unknown grant type %q in allowed grant types", grantType), nil
+		}
 	}
 
-	b.reset()
+	for _, redirectURL := range c.AllowedRedirectURLs {
+		if u, err := url.Parse(redirectURL); err != nil || !u.IsAbs() {
+			return errorResponse(ErrorCodeInvalidRequest, "allowed redirect URL %q must be an absolute URL", redirectURL), nil
+		}
+	}
+
+	if c.RequestObjectSigningKey != "" {
+		if _, _, err := parseJWTBearerSigningKey(c.RequestObjectSigningKey, c.RequestObjectSigningAlgorithm); err != nil {
+			return errorResponse(ErrorCodeInvalidRequest, "request object %s", err), nil
+		} else if c.RequestObjectAudience == "" {
+			return errorResponse(ErrorCodeInvalidRequest, "missing request object audience"), nil
+		}
+	}
+
+	if c.JARMJWKSURL != "" {
+		if u, err := url.Parse(c.JARMJWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errorResponse(ErrorCodeInvalidRequest, "JARM JWKS URL must be an HTTP or HTTPS URL"), nil
+		} else if c.JARMIssuer == "" {
+			return errorResponse(ErrorCodeInvalidRequest, "missing JARM issuer"), nil
+		}
+	}
+
+	if c.JWTAccessTokenJWKSURL != "" {
+		if u, err := url.Parse(c.JWTAccessTokenJWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errorResponse(ErrorCodeInvalidRequest, "JWT access token JWKS URL must be an HTTP or HTTPS URL"), nil
+		} else if !c.DecodeJWTAccessTokens {
+			return errorResponse(ErrorCodeInvalidRequest, "JWT access token JWKS URL requires decoding JWT access tokens"), nil
+		}
+	}
+
+	if c.ReauthorizationWebhookURL != "" {
+		if u, err := url.Parse(c.ReauthorizationWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errorResponse(ErrorCodeInvalidRequest, "reauthorization webhook URL must be an HTTP or HTTPS URL"), nil
+		}
+	}
+
+	if c.TracingOTLPEndpoint != "" {
+		if u, err := url.Parse(c.TracingOTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errorResponse(ErrorCodeInvalidRequest, "tracing OTLP endpoint must be an HTTP or HTTPS URL"), nil
+		}
+	}
+
+	// Open the event log now so that a destination we can't write to is
+	// reported to the operator instead of breaking every later request.
+	if events, err := newEventLog(c, b.eventLogDir); err != nil {
+		return errorResponse(ErrorCodeInvalidRequest, "cannot open event log: %+v", err), nil
+	} else if events != nil {
+		_ = events.Close()
+	}
 
 	return nil, nil
 }
 
+// configWarningResponse returns a response warning about settings in the given
+// configuration that are valid but likely to cause problems, or nil if there
+// is nothing to warn about.
+func (b *backend) configWarningResponse(c *persistence.ConfigEntry) *logical.Response {
+	resp := &logical.Response{}
+
+	if c.AllowPasswordGrant {
+		resp.AddWarning(passwordGrantWarning)
+	}
+
+	// With no minimum number of attempts, the transient error criterion
+	// deletes a credential that failed to refresh only once, for example
+	// because the provider was briefly unreachable.
+	if c.Tuning.ReapCheckIntervalSeconds > 0 && !c.Tuning.ReapDryRun && c.Tuning.ReapTransientErrorAttempts == 0 && c.Tuning.ReapTransientErrorSeconds > 0 {
+		resp.AddWarning("The reaper will delete expired credentials after a single transient error, such as a network outage. Set tune_reap_transient_error_attempts to require more refresh attempts first, or set tune_reap_dry_run to check which credentials would be deleted.")
+	}
+
+	if lifetime, ok, _ := b.providerRegistry.TokenLifetime(c.ProviderName); ok {
+		if interval := time.Duration(c.Tuning.RefreshCheckIntervalSeconds) * time.Second; interval > lifetime {
+			resp.AddWarning(fmt.Sprintf("The refresh check interval (%s) is longer than the lifetime of access tokens usually issued by provider %q (%s), so tokens may expire between background refreshes.", interval, c.ProviderName, lifetime))
+		}
+	}
+
+	if len(resp.Warnings) == 0 {
+		return nil
+	}
+
+	return resp
+}
+
 func (b *backend) configDeleteOperation(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
 	if err := b.data.Managers(req.Storage).Config().DeleteConfig(ctx); err != nil {
 		return nil, err
@@ -124,35 +444,181 @@ func (b *backend) configDeleteOperation(ctx context.Context, req *logical.Reques
 }
 
 func (b *backend) configAuthCodeURLUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.authCodeURL(ctx, req, data, "", false)
+}
+
+// authCodeURL generates an authorization code URL. If a credential name is
+// given, the state is bound to that credential so that the callback endpoint
+// can create it, or replace it if reauthorize is set.
+func (b *backend) authCodeURL(ctx context.Context, req *logical.Request, data *framework.FieldData, name string, reauthorize bool) (*logical.Response, error) {
 	c, err := b.getCache(ctx, req.Storage)
 	if err != nil {
 		return nil, err
 	} else if c == nil {
-		return logical.ErrorResponse("not configured"), nil
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	} else if !c.Config.GrantTypeAllowed("authorization_code") {
+		return grantTypeNotAllowedResponse("authorization_code"), nil
+	}
+
+	hasName := name != ""
+
+	// The callback endpoint does not require authentication, so unless the
+	// credential is being authorized again, it may only create a new
+	// credential.
+	if hasName && !reauthorize {
+		entry, err := b.data.Managers(req.Storage).AuthCode().ReadAuthCodeEntry(ctx, persistence.AuthCodeName(name))
+		if err != nil {
+			return nil, err
+		} else if entry != nil {
+			return errorResponse(ErrorCodeInvalidRequest, "a credential with this name already exists"), nil
+		}
 	}
 
+	tmpl, resp, err := b.readCredTemplate(ctx, req.Storage, data.Get("template").(string))
+	if err != nil || resp != nil {
+		return resp, err
+	}
+
+	var expiry time.Time
+	if hasName {
+		ttl := time.Duration(data.Get("state_ttl_seconds").(int)) * time.Second
+		if ttl <= 0 {
+			return errorResponse(ErrorCodeInvalidRequest, "state TTL must be positive"), nil
+		}
+		expiry = b.clock.Now().Add(ttl)
+	}
+
+	// If a state is not provided, we can generate one tied to the named
+	// credential.
 	state, ok := data.GetOk("state")
+	generated := false
 	if !ok {
-		return logical.ErrorResponse("missing state"), nil
+		if !hasName {
+			return errorResponse(ErrorCodeInvalidRequest, "missing state"), nil
+		}
+
+		state, err = b.generateState(ctx, req.Storage, name, expiry)
+		if err != nil {
+			return nil, err
+		}
+		generated = true
+	}
+
+	p, err := c.Provider()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := p.Public(c.Config.ClientID)
+
+	// Settings that are not given are inherited from the template.
+	redirectURL := data.Get("redirect_url").(string)
+	scopes := data.Get("scopes").([]string)
+	resources := data.Get("resources").([]string)
+	providerOptions := data.Get("provider_options").(map[string]string)
+	if tmpl != nil {
+		if _, ok := data.GetOk("redirect_url"); !ok {
+			redirectURL = tmpl.RedirectURL
+		}
+		if _, ok := data.GetOk("scopes"); !ok {
+			scopes = tmpl.Scopes
+		}
+		if _, ok := data.GetOk("provider_options"); !ok {
+			providerOptions = tmpl.ProviderOptions
+		}
+	}
+
+	if !c.Config.RedirectURLAllowed(redirectURL) {
+		return redirectURLNotAllowedResponse(redirectURL), nil
+	}
+	if resp := scopeNotAllowedResponse(c.Config.DisallowedScopes(scopes)); resp != nil {
+		return resp, nil
+	}
+
+	// For providers that support it, generate a nonce to bind the resulting ID
+	// token to this request.
+	nonce := providerOptions[provider.NonceProviderOption]
+	if no, ok := ops.(provider.NonceOperations); ok && no.SupportsNonce() && nonce == "" {
+		nonce, err = generateNonce()
+		if err != nil {
+			return nil, err
+		}
+
+		po := make(map[string]string, len(providerOptions)+1)
+		for k, v := range providerOptions {
+			po[k] = v
+		}
+		po[provider.NonceProviderOption] = nonce
+		providerOptions = po
 	}
 
-	url, ok := c.Provider.Public(c.Config.ClientID).AuthCodeURL(
+	url, ok := ops.AuthCodeURL(
 		state.(string),
-		provider.WithRedirectURL(data.Get("redirect_url").(string)),
-		provider.WithScopes(data.Get("scopes").([]string)),
+		provider.WithRedirectURL(redirectURL),
+		provider.WithScopes(scopes),
+		provider.WithResources(resources),
 		provider.WithURLParams(data.Get("auth_url_params").(map[string]string)),
 		provider.WithURLParams(c.Config.AuthURLParams),
-		provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
+		provider.WithProviderOptions(providerOptions),
 	)
 	if !ok {
-		return logical.ErrorResponse("authorization code URL not available"), nil
+		return errorResponse(ErrorCodeUnsupported, "authorization code URL not available"), nil
 	}
 
-	resp := &logical.Response{
+	if c.Config.RequestObjectSigningKey != "" {
+		url, err = b.signAuthCodeURL(url, c.Config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp = &logical.Response{
 		Data: map[string]interface{}{
 			"url": url,
 		},
 	}
+
+	if nonce != "" {
+		resp.Data["nonce"] = nonce
+	}
+
+	// If a credential name is given, the exchange can be completed by the
+	// callback endpoint or by writing the code and state to the credential.
+	if hasName {
+		entry := &persistence.AuthCodeStateEntry{
+			CredentialName:  name,
+			EntityID:        req.EntityID,
+			Reauthorize:     reauthorize,
+			RedirectURL:     redirectURL,
+			Resources:       resources,
+			ProviderOptions: providerOptions,
+			ExpireTime:      expiry,
+			Signed:          generated,
+		}
+		if tmpl != nil {
+			entry.Template = tmpl.Name
+		}
+		if err := b.data.Managers(req.Storage).AuthCodeState().WriteAuthCodeStateEntry(ctx, persistence.AuthCodeStateName(state.(string)), entry); err != nil {
+			return nil, err
+		}
+
+		resp.Data["expire_time"] = entry.ExpireTime
+	}
+
+	// A generated state must be presented when the code is written back.
+	if generated {
+		pse := &persistence.PendingStateEntry{
+			ExpireTime: expiry,
+		}
+		if err := b.data.Managers(req.Storage).AuthCode().WithLock(persistence.AuthCodeName(name), func(lacm *persistence.LockedAuthCodeManager) error {
+			return lacm.WritePendingStateEntry(ctx, pse)
+		}); err != nil {
+			return nil, err
+		}
+
+		resp.Data["state"] = state
+	}
+
 	return resp, nil
 }
 
@@ -183,6 +649,108 @@ var configFields = map[string]*framework.FieldSchema{
 		Type:        framework.TypeKVPairs,
 		Description: "Specifies any provider-specific options.",
 	},
+	"claim_metadata": {
+		Type:        framework.TypeKVPairs,
+		Description: "Specifies a map of credential metadata fields to the names of ID token or UserInfo claims to populate them from.",
+	},
+	"lease_tokens": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to return access tokens as leased secrets.",
+		Default:     false,
+	},
+	"token_ttl_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies the TTL of access token leases in seconds. If 0, leases last until the access token expires.",
+		Default:     0,
+	},
+	"default_token_lifetime_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies the lifetime in seconds to assume for access tokens the provider issues without an expiry, so that they are refreshed proactively. If 0, such tokens never expire.",
+		Default:     0,
+	},
+	"allow_password_grant": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether credentials may be issued using the resource owner password credentials grant. Not recommended.",
+		Default:     false,
+	},
+	"allowed_grant_types": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the grant types credentials may be issued with, including client_credentials for the config/self endpoint. If empty, all grant types are allowed.",
+	},
+	"allowed_redirect_urls": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the redirect URLs authorization codes may be requested for and exchanged with. Redirect URLs must match one of these exactly. If empty, any redirect URL is allowed.",
+	},
+	"allowed_scopes": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the scopes credentials may request. If empty, any scope that is not denied is allowed.",
+	},
+	"denied_scopes": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies scopes credentials may never request. Denied scopes take precedence over allowed scopes.",
+	},
+	"allowed_read_provider_options": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the provider options that may be given when reading a credential. If empty, provider options can't be given when reading credentials.",
+	},
+	"request_object_signing_key": {
+		Type:        framework.TypeString,
+		Description: "Specifies a PEM-encoded RSA or ECDSA private key to sign request objects with. If set, the parameters of authorization code URLs are sent in a signed request object (RFC 9101).",
+	},
+	"request_object_signing_key_id": {
+		Type:        framework.TypeString,
+		Description: "Specifies the key ID to include in the header of request objects.",
+	},
+	"request_object_signing_algorithm": {
+		Type:        framework.TypeString,
+		Description: "Specifies the algorithm to sign request objects with. Defaults to RS256 for RSA keys and the ECDSA algorithm matching the curve of EC keys.",
+	},
+	"request_object_audience": {
+		Type:        framework.TypeString,
+		Description: "Specifies the audience of request objects, usually the issuer identifier of the authorization server. Required if a request object signing key is set.",
+	},
+	"jarm_jwks_url": {
+		Type:        framework.TypeString,
+		Description: "Specifies the URL of the JSON Web Key Set used to verify JWT-secured authorization responses (JARM). If set, the callback/jarm endpoint accepts them.",
+	},
+	"jarm_issuer": {
+		Type:        framework.TypeString,
+		Description: "Specifies the expected issuer of JWT-secured authorization responses. Required if a JARM JWKS URL is set.",
+	},
+	"decode_jwt_access_tokens": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to decode access tokens that are JWTs to report their claims and determine their expiry when the provider does not.",
+		Default:     false,
+	},
+	"jwt_access_token_jwks_url": {
+		Type:        framework.TypeString,
+		Description: "Specifies the URL of the JSON Web Key Set used to verify the signature of JWT access tokens before their claims are used. If not set, claims are used without verification.",
+	},
+	"reauthorization_webhook_url": {
+		Type:        framework.TypeString,
+		Description: "Specifies a URL to send a POST request to when a credential must be authorized again.",
+	},
+	"maintenance_mode": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to pause all requests to the provider, for example during a provider maintenance window. Tokens that are still valid continue to be served.",
+	},
+	"redact_tokens": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to return SHA-256 digests of tokens instead of the tokens themselves when credentials are read, unless include_token is set.",
+	},
+	"tracing_otlp_endpoint": {
+		Type:        framework.TypeString,
+		Description: "Specifies the URL of an OTLP/HTTP collector to export OpenTelemetry spans for requests to the provider to.",
+	},
+	"event_log_file": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of a file in the event log directory of each Vault server to append credential lifecycle events to as JSON lines.",
+	},
+	"event_log_syslog": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to send credential lifecycle events to the local syslog daemon.",
+	},
+
 	"tune_provider_timeout_seconds": {
 		Type:        framework.TypeDurationSecond,
 		Description: "Specifies the maximum time to wait for a provider response in seconds. Infinite if 0.",
@@ -190,7 +758,7 @@ var configFields = map[string]*framework.FieldSchema{
 	},
 	"tune_provider_timeout_expiry_leeway_factor": {
 		Type:        framework.TypeFloat,
-		Description: "Specifies a multiplier for the provider timeout when a credential is about to expire. Must be at least 1.",
+		Description: "Specifies a multiplier for the provider timeout when refreshing a credential that has already expired. Must be at least 1.",
 		Default:     persistence.DefaultConfigTuningEntry.ProviderTimeoutExpiryLeewayFactor,
 	},
 	"tune_refresh_check_interval_seconds": {
@@ -203,6 +771,16 @@ var configFields = map[string]*framework.FieldSchema{
 		Description: "Specifies a multipler for the refresh check interval to use to detect tokens that will expire soon after a background refresh process is invoked. Must be at least 1.",
 		Default:     persistence.DefaultConfigTuningEntry.RefreshExpiryDeltaFactor,
 	},
+	"tune_refresh_before_expiry_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies the minimum amount of time before a token expires that the background refresh process should refresh it, regardless of the refresh check interval.",
+		Default:     persistence.DefaultConfigTuningEntry.RefreshBeforeExpirySeconds,
+	},
+	"tune_expiry_leeway_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies how long past their expiry time tokens and JWTs are still accepted, to tolerate a provider clock that differs from the Vault server's.",
+		Default:     persistence.DefaultConfigTuningEntry.ExpiryLeewaySeconds,
+	},
 	"tune_reap_check_interval_seconds": {
 		Type:        framework.TypeDurationSecond,
 		Description: "Specifies the interval in seconds between invocations of the expired credential reaper background process. Disabled if 0.",
@@ -233,6 +811,71 @@ var configFields = map[string]*framework.FieldSchema{
 		Description: "Specifies the minimum additional time to wait before automatically deleting an expired credential that cannot be refreshed because of a transient problem like network connectivity issues. Set to 0 to disable this reaping criterion.",
 		Default:     persistence.DefaultConfigTuningEntry.ReapTransientErrorSeconds,
 	},
+	"tune_reap_quarantine_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies how long to retain reaped credentials so that they can be restored. Reaped credentials are deleted immediately if 0.",
+		Default:     persistence.DefaultConfigTuningEntry.ReapQuarantineSeconds,
+	},
+	"tune_reap_deleted_entities": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether the reaper should delete credentials that belong to a Vault identity entity that no longer exists.",
+		Default:     persistence.DefaultConfigTuningEntry.ReapDeletedEntities,
+	},
+	"tune_introspection_check_interval_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies the number of seconds between checking stored access tokens with the provider's token introspection endpoint. Disabled if 0.",
+		Default:     persistence.DefaultConfigTuningEntry.IntrospectionCheckIntervalSeconds,
+	},
+	"tune_introspection_sample_size": {
+		Type:        framework.TypeInt,
+		Description: "Specifies the number of randomly chosen credentials to check each time stored access tokens are checked with the provider. All credentials are checked if 0.",
+		Default:     persistence.DefaultConfigTuningEntry.IntrospectionSampleSize,
+	},
+	"tune_max_credential_versions": {
+		Type:        framework.TypeInt,
+		Description: "Specifies the number of previous versions of each credential to retain for rollback. Disabled if 0.",
+		Default:     persistence.DefaultConfigTuningEntry.MaxCredentialVersions,
+	},
+	"tune_max_credential_history": {
+		Type:        framework.TypeInt,
+		Description: "Specifies the number of recent token exchanges and refreshes to record in the history of each credential. Disabled if 0.",
+		Default:     persistence.DefaultConfigTuningEntry.MaxCredentialHistory,
+	},
+	"tune_max_credentials": {
+		Type:        framework.TypeInt,
+		Description: "Specifies the maximum number of credentials in this mount. New credentials are rejected once it is reached. Unlimited if 0.",
+		Default:     persistence.DefaultConfigTuningEntry.MaxCredentials,
+	},
+	"tune_max_credentials_per_entity": {
+		Type:        framework.TypeInt,
+		Description: "Specifies the maximum number of credentials each Vault entity can create. Unlimited if 0.",
+		Default:     persistence.DefaultConfigTuningEntry.MaxCredentialsPerEntity,
+	},
+	"tune_storage_scan_page_size": {
+		Type:        framework.TypeInt,
+		Description: "Specifies the number of storage keys background processes list and dispatch at a time.",
+		Default:     persistence.DefaultConfigTuningEntry.StorageScanPageSize,
+	},
+	"tune_storage_scan_pages_per_second": {
+		Type:        framework.TypeFloat,
+		Description: "Specifies the maximum number of pages of storage keys background processes list per second. Unlimited if 0.",
+		Default:     persistence.DefaultConfigTuningEntry.StorageScanPagesPerSecond,
+	},
+	"tune_circuit_breaker_failures": {
+		Type:        framework.TypeInt,
+		Description: "Specifies the number of consecutive failed requests to the provider after which the automatic refresher pauses. Disabled if 0.",
+		Default:     persistence.DefaultConfigTuningEntry.CircuitBreakerFailures,
+	},
+	"tune_circuit_breaker_cool_down_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies how long the automatic refresher pauses once the circuit breaker opens.",
+		Default:     persistence.DefaultConfigTuningEntry.CircuitBreakerCoolDownSeconds,
+	},
+	"tune_user_info_cache_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies how long to reuse the claims returned by the userinfo/creds endpoint for the same access token. Disabled if 0.",
+		Default:     persistence.DefaultConfigTuningEntry.UserInfoCacheSeconds,
+	},
 }
 
 const configHelpSynopsis = `
@@ -252,18 +895,33 @@ func pathConfig(b *backend) *framework.Path {
 		Fields:  configFields,
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
-				Callback: b.configReadOperation,
-				Summary:  "Return the current configuration for this mount.",
+				Callback:  b.configReadOperation,
+				Summary:   "Return the current configuration for this mount.",
+				Responses: configReadResponses,
+			},
+			logical.CreateOperation: &framework.PathOperation{
+				Callback:                    b.configUpdateOperation,
+				Summary:                     "Create or replace the client configuration.",
+				Responses:                   configWriteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
 			},
 			logical.UpdateOperation: &framework.PathOperation{
-				Callback: b.configUpdateOperation,
-				Summary:  "Create a new client configuration or replace the configuration with new client information.",
+				Callback:                    b.configUpdateOperation,
+				Summary:                     "Create or replace the client configuration.",
+				Responses:                   configWriteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
 			},
 			logical.DeleteOperation: &framework.PathOperation{
-				Callback: b.configDeleteOperation,
-				Summary:  "Delete the client configuration, invalidating all credentials.",
+				Callback:                    b.configDeleteOperation,
+				Summary:                     "Delete the client configuration, invalidating all credentials.",
+				Responses:                   configDeleteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
 			},
 		},
+		ExistenceCheck:  b.configExistenceCheck,
 		HelpSynopsis:    strings.TrimSpace(configHelpSynopsis),
 		HelpDescription: strings.TrimSpace(configHelpDescription),
 	}
@@ -282,6 +940,10 @@ var configAuthCodeURLFields = map[string]*framework.FieldSchema{
 		Type:        framework.TypeCommaStringSlice,
 		Description: "The scopes to request for authorization.",
 	},
+	"resources": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "The RFC 8707 resource indicators to request for authorization. A credential created using the resulting state requests the same resources when its code is exchanged and its token is refreshed.",
+	},
 	"state": {
 		Type:        framework.TypeString,
 		Description: "Specifies the state to set in the authorization code URL.",
@@ -290,6 +952,10 @@ var configAuthCodeURLFields = map[string]*framework.FieldSchema{
 		Type:        framework.TypeKVPairs,
 		Description: "Specifies any provider-specific options.",
 	},
+	"template": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of a credential template to inherit the redirect URL, scopes, and provider options from if they are not given.",
+	},
 }
 
 const configAuthCodeURLHelpSynopsis = `
@@ -300,7 +966,9 @@ const configAuthCodeURLHelpDescription = `
 This endpoint merges the configuration data with requested parameters
 like a redirect URL and scopes to create an authorization code URL.
 The code returned in the response should be written to a credential
-endpoint to start managing authentication tokens.
+endpoint to start managing authentication tokens. To have the provider
+redirect to the callback endpoint instead, use the
+auth-code-url/creds/<name> endpoint.
 `
 
 func pathConfigAuthCodeURL(b *backend) *framework.Path {
@@ -309,8 +977,11 @@ func pathConfigAuthCodeURL(b *backend) *framework.Path {
 		Fields:  configAuthCodeURLFields,
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
-				Callback: b.configAuthCodeURLUpdateOperation,
-				Summary:  "Generate an initial authorization code URL.",
+				Callback:                    b.configAuthCodeURLUpdateOperation,
+				Summary:                     "Generate an initial authorization code URL.",
+				Responses:                   configAuthCodeURLResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
 			},
 		},
 		HelpSynopsis:    strings.TrimSpace(configAuthCodeURLHelpSynopsis),
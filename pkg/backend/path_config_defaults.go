@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+)
+
+func (b *backend) configDefaultsReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	// The defaults are whatever a configuration written with no optional
+	// fields would contain.
+	c := configEntryFromFieldData(&framework.FieldData{
+		Raw:    map[string]interface{}{},
+		Schema: configFields,
+	})
+
+	rd := configResponseData(c)
+	delete(rd, "client_id")
+	delete(rd, "provider")
+	delete(rd, "provider_version")
+
+	if name, ok := data.GetOk("provider"); ok {
+		schema, _, err := b.providerRegistry.Schema(name.(string))
+		if errors.Is(err, provider.ErrNoSuchProvider) {
+			return errorResponse(ErrorCodeInvalidRequest, "provider %q does not exist", name), nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		rd["provider"] = name
+		rd["provider_options"] = schema.Defaults()
+	}
+
+	return &logical.Response{
+		Data: rd,
+	}, nil
+}
+
+const (
+	ConfigDefaultsPath = ConfigPathPrefix + "defaults"
+)
+
+var configDefaultsFields = map[string]*framework.FieldSchema{
+	"provider": {
+		Type:        framework.TypeString,
+		Description: "Specifies a provider to include the default provider options of.",
+		Query:       true,
+	},
+}
+
+const configDefaultsHelpSynopsis = `
+Reports the default configuration settings.
+`
+
+const configDefaultsHelpDescription = `
+This endpoint returns the value this version of the plugin uses for each
+configuration field that is not specified, including every tuning option,
+in the same form as the config endpoint. If a provider is given, the
+response also includes the default values of its provider options.
+`
+
+func pathConfigDefaults(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ConfigDefaultsPath + `$`,
+		Fields:  configDefaultsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.configDefaultsReadOperation,
+				Summary:   "Return the default configuration settings.",
+				Responses: configDefaultsResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(configDefaultsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(configDefaultsHelpDescription),
+	}
+}
@@ -0,0 +1,101 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigDefaults(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(), provider.WithSchema(provider.OptionSchema{
+		"region": {
+			Type:    provider.OptionTypeString,
+			Default: "us",
+		},
+		"tenant": {
+			Type: provider.OptionTypeString,
+		},
+	}))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigDefaultsPath,
+		Storage:   storage,
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	assert.NotContains(t, resp.Data, "client_id")
+	assert.NotContains(t, resp.Data, "provider")
+	assert.Equal(t, false, resp.Data["lease_tokens"])
+	assert.Equal(t, persistence.DefaultConfigTuningEntry.RefreshCheckIntervalSeconds, resp.Data["tune_refresh_check_interval_seconds"])
+	assert.Equal(t, persistence.DefaultConfigTuningEntry.ReapTransientErrorAttempts, resp.Data["tune_reap_transient_error_attempts"])
+	assert.Equal(t, persistence.DefaultConfigTuningEntry.StorageScanPagesPerSecond, resp.Data["tune_storage_scan_pages_per_second"])
+
+	// The defaults are the same as a minimal configuration.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id": "abc",
+			"provider":  "mock",
+		},
+	}
+
+	_, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+	}
+
+	config, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, config)
+	for k, v := range resp.Data {
+		assert.Equal(t, v, config.Data[k], "field %q", k)
+	}
+
+	// Provider option defaults are included on request.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigDefaultsPath,
+		Storage:   storage,
+		Data:      map[string]interface{}{"provider": "mock"},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "mock", resp.Data["provider"])
+	assert.Equal(t, map[string]string{"region": "us"}, resp.Data["provider_options"])
+
+	req.Data = map[string]interface{}{"provider": "nope"}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.True(t, resp != nil && resp.IsError())
+	code, _ := backend.ParseErrorCode(resp.Error().Error())
+	assert.Equal(t, backend.ErrorCodeInvalidRequest, code)
+}
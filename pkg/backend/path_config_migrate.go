@@ -0,0 +1,103 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+func migrationResponse(entry *persistence.MigrationEntry) *logical.Response {
+	rd := map[string]interface{}{
+		"version":        int(entry.Version),
+		"latest_version": int(persistence.StorageVersionLatest),
+		"pending":        entry.Pending(),
+		"in_progress":    entry.InProgress(),
+		"processed":      entry.Processed,
+	}
+
+	if !entry.StartedTime.IsZero() {
+		rd["started_time"] = entry.StartedTime
+	}
+
+	if !entry.CompletedTime.IsZero() {
+		rd["completed_time"] = entry.CompletedTime
+	}
+
+	if entry.LastError != "" {
+		rd["last_error"] = entry.LastError
+	}
+
+	return &logical.Response{
+		Data: rd,
+	}
+}
+
+func (b *backend) configMigrateReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.data.Managers(req.Storage).Migration().ReadMigrationEntry(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return migrationResponse(entry), nil
+}
+
+func (b *backend) configMigrateUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.data.Managers(req.Storage).Migration().Migrate(clockctx.WithClock(ctx, b.clock))
+
+	var sve *persistence.StorageVersionError
+	var cve *persistence.ConfigVersionError
+	switch {
+	case errors.As(err, &sve) || errors.As(err, &cve):
+		return errorResponse(ErrorCodeStorageVersion, err.Error()), nil
+	case err != nil:
+		return nil, err
+	}
+
+	// Configuration may have been rewritten.
+	b.reset()
+
+	return migrationResponse(entry), nil
+}
+
+const (
+	ConfigMigratePath = ConfigPathPrefix + "migrate"
+)
+
+const configMigrateHelpSynopsis = `
+Upgrades the storage schema used by this mount.
+`
+
+const configMigrateHelpDescription = `
+This endpoint reports the version of the storage schema used by this
+mount and whether a newer version is available. Writing to this endpoint
+upgrades the storage to the latest version supported by the plugin.
+Progress is reported while the migration runs, and a failed migration
+can be resumed by writing to this endpoint again.
+`
+
+func pathConfigMigrate(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ConfigMigratePath + `$`,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.configMigrateReadOperation,
+				Summary:   "Return the status of the storage schema migration.",
+				Responses: configMigrateResponses,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.configMigrateUpdateOperation,
+				Summary:                     "Upgrade the storage schema to the latest version.",
+				Responses:                   configMigrateResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(configMigrateHelpSynopsis),
+		HelpDescription: strings.TrimSpace(configMigrateHelpDescription),
+	}
+}
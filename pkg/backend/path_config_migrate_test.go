@@ -0,0 +1,107 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigMigrate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory())
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Write configuration using an old version.
+	require.NoError(t, persistence.NewHolder().Managers(storage).Config().WriteConfig(ctx, &persistence.ConfigEntry{
+		Version:      persistence.ConfigVersion1,
+		ClientID:     "abc",
+		ClientSecret: "def",
+		ProviderName: "mock",
+	}))
+
+	// A migration should be pending.
+	read := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigMigratePath,
+		Storage:   storage,
+	}
+
+	resp, err := b.HandleRequest(ctx, read)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, int(persistence.StorageVersionInitial), resp.Data["version"])
+	assert.Equal(t, int(persistence.StorageVersionLatest), resp.Data["latest_version"])
+	assert.Equal(t, true, resp.Data["pending"])
+	assert.Equal(t, false, resp.Data["in_progress"])
+
+	// Run the migration.
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigMigratePath,
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, int(persistence.StorageVersionLatest), resp.Data["version"])
+	assert.Equal(t, false, resp.Data["pending"])
+	assert.NotEmpty(t, resp.Data["completed_time"])
+
+	resp, err = b.HandleRequest(ctx, read)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, false, resp.Data["pending"])
+}
+
+func TestConfigMigrateDowngradeGuard(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory())
+
+	storage := &logical.InmemStorage{}
+
+	se, err := logical.StorageEntryJSON("migration", &persistence.MigrationEntry{
+		Version: persistence.StorageVersionLatest + 1,
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, se))
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.Error(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+
+	// Migrating is refused.
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigMigratePath,
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	// So is reading configuration.
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+	})
+	require.Error(t, err)
+}
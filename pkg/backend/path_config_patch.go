@@ -0,0 +1,116 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+// patchFieldData returns field data in which each field the request does not
+// contain takes its value from current, so that an update only changes the
+// fields that were given.
+func patchFieldData(data *framework.FieldData, current map[string]interface{}) *framework.FieldData {
+	raw := make(map[string]interface{}, len(current)+len(data.Raw))
+	for k, v := range current {
+		raw[k] = v
+	}
+	for k, v := range data.Raw {
+		raw[k] = v
+	}
+
+	return &framework.FieldData{Raw: raw, Schema: data.Schema}
+}
+
+func (b *backend) configPatchUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var sve *persistence.StorageVersionError
+	if err := b.checkStorageVersion(ctx, req.Storage); errors.As(err, &sve) {
+		return errorResponse(ErrorCodeStorageVersion, err.Error()), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var c *persistence.ConfigEntry
+	var resp *logical.Response
+	err := b.data.Managers(req.Storage).Config().WithLock(func(cm *persistence.LockedConfigManager) error {
+		prev, err := cm.ReadConfig(ctx)
+		if err != nil {
+			return err
+		} else if prev == nil {
+			resp = errorResponse(ErrorCodeNotConfigured, "not configured")
+			return nil
+		}
+
+		current := configResponseData(prev)
+		delete(current, "provider_version")
+		current["client_secret"] = prev.ClientSecret
+
+		c = configEntryFromFieldData(patchFieldData(data, current))
+
+		// Keep the provider version unless the provider is being changed, as
+		// the existing credentials were issued by it.
+		_, changeProvider := data.GetOk("provider")
+		_, changeProviderOptions := data.GetOk("provider_options")
+		upgradeProvider := changeProvider || changeProviderOptions
+		if !upgradeProvider {
+			c.ProviderVersion = prev.ProviderVersion
+		}
+
+		resp, err = b.validateConfig(ctx, c, upgradeProvider)
+		if err != nil || resp != nil {
+			return err
+		}
+
+		// Replacing the client secret this way ends any grace period for the
+		// previous one, as writing the whole configuration does.
+		if _, ok := data.GetOk("client_secret"); !ok {
+			c.PreviousClientSecret = prev.PreviousClientSecret
+			c.PreviousClientSecretExpireTime = prev.PreviousClientSecretExpireTime
+		}
+
+		return cm.WriteConfig(ctx, c)
+	})
+	if err != nil || resp != nil {
+		return resp, err
+	}
+
+	b.reset()
+
+	return b.configWarningResponse(c), nil
+}
+
+const (
+	ConfigPatchPath = ConfigPathPrefix + "patch"
+)
+
+const configPatchHelpSynopsis = `
+Updates part of the OAuth 2.0 client configuration.
+`
+
+const configPatchHelpDescription = `
+This endpoint accepts the same fields as the config endpoint, but only
+changes the fields given in the request. Every other field, including
+the client secret, keeps its current value, so automation can adjust
+tuning options without handling the secret.
+`
+
+func pathConfigPatch(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ConfigPatchPath + `$`,
+		Fields:  configFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.configPatchUpdateOperation,
+				Summary:                     "Change only the given fields of the client configuration.",
+				Responses:                   configWriteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(configPatchHelpSynopsis),
+		HelpDescription: strings.TrimSpace(configPatchHelpDescription),
+	}
+}
@@ -0,0 +1,116 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigPatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory())
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	patch := func(data map[string]interface{}) (*logical.Response, error) {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigPatchPath,
+			Storage:   storage,
+			Data:      data,
+		}
+
+		return b.HandleRequest(ctx, req)
+	}
+
+	// There is nothing to patch yet.
+	resp, err := patch(map[string]interface{}{"maintenance_mode": true})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	code, _ := backend.ParseErrorCode(resp.Error().Error())
+	assert.Equal(t, backend.ErrorCodeNotConfigured, code)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":                           "abc",
+			"client_secret":                       "def",
+			"provider":                            "mock",
+			"auth_url_params":                     map[string]interface{}{"foo": "bar"},
+			"tune_refresh_check_interval_seconds": 120,
+			"tune_reap_dry_run":                   true,
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Only the given field changes.
+	resp, err = patch(map[string]interface{}{"tune_refresh_check_interval_seconds": "5m"})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	cfg, err := persistence.NewHolder().Managers(storage).Config().ReadConfig(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+	assert.Equal(t, "abc", cfg.ClientID)
+	assert.Equal(t, "def", cfg.ClientSecret)
+	assert.Equal(t, "mock", cfg.ProviderName)
+	assert.Equal(t, map[string]string{"foo": "bar"}, cfg.AuthURLParams)
+	assert.Equal(t, 300, cfg.Tuning.RefreshCheckIntervalSeconds)
+	assert.True(t, cfg.Tuning.ReapDryRun)
+	assert.Equal(t, persistence.DefaultConfigTuningEntry.ReapCheckIntervalSeconds, cfg.Tuning.ReapCheckIntervalSeconds)
+
+	// The backend picks up the change.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, 300, resp.Data["tune_refresh_check_interval_seconds"])
+
+	// Invalid values are rejected and the configuration is left alone.
+	resp, err = patch(map[string]interface{}{
+		"tune_reap_dry_run":                false,
+		"tune_refresh_expiry_delta_factor": 0.5,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	cfg, err = persistence.NewHolder().Managers(storage).Config().ReadConfig(ctx)
+	require.NoError(t, err)
+	assert.True(t, cfg.Tuning.ReapDryRun)
+
+	// The client secret can be patched too.
+	resp, err = patch(map[string]interface{}{"client_secret": "ghi"})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	cfg, err = persistence.NewHolder().Managers(storage).Config().ReadConfig(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ghi", cfg.ClientSecret)
+	assert.Equal(t, 300, cfg.Tuning.RefreshCheckIntervalSeconds)
+}
@@ -0,0 +1,339 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmap"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/registration"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/semerr"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"golang.org/x/oauth2"
+)
+
+func (b *backend) configRegisterReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.data.Managers(req.Storage).Registration().ReadRegistrationEntry(ctx)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	rd := map[string]interface{}{
+		"registration_endpoint":   entry.RegistrationEndpoint,
+		"registration_client_uri": entry.RegistrationClientURI,
+		"client_id":               entry.ClientID,
+		"metadata":                entry.Metadata,
+		"manageable":              entry.Manageable(),
+		"register_time":           entry.RegisterTime,
+	}
+
+	if !entry.UpdateTime.IsZero() {
+		rd["update_time"] = entry.UpdateTime
+	}
+
+	if !entry.ClientSecretExpireTime.IsZero() {
+		rd["client_secret_expire_time"] = entry.ClientSecretExpireTime
+	}
+
+	resp := &logical.Response{
+		Data: rd,
+	}
+	return resp, nil
+}
+
+// registrationErrorResponse maps an error from the provider's registration
+// endpoint to a response.
+func registrationErrorResponse(err error, msg string) (*logical.Response, error) {
+	err = semerr.Map(err)
+	if errmark.Matches(err, errmark.RuleType(&oauth2.RetrieveError{})) || errmark.MarkedUser(err) {
+		return errorResponse(ErrorCodeProviderRejected, errmap.Wrap(errmark.MarkShort(err), msg).Error()), nil
+	}
+
+	return nil, err
+}
+
+func (b *backend) configRegisterUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	gracePeriod := time.Duration(data.Get("grace_period_seconds").(int)) * time.Second
+	if gracePeriod < 0 {
+		return errorResponse(ErrorCodeInvalidRequest, "grace period cannot be negative"), nil
+	}
+
+	var sve *persistence.StorageVersionError
+	if err := b.checkStorageVersion(ctx, req.Storage); errors.As(err, &sve) {
+		return errorResponse(ErrorCodeStorageVersion, err.Error()), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c != nil && c.Config.MaintenanceMode {
+		return errorResponse(ErrorCodeMaintenance, "requests to the provider are paused for maintenance"), nil
+	}
+
+	metadata, hasMetadata := data.GetOk("metadata")
+
+	var initial *persistence.ConfigEntry
+	var resp *logical.Response
+	err = b.data.Managers(req.Storage).Registration().WithLock(func(rm *persistence.LockedRegistrationManager) error {
+		entry, err := rm.ReadRegistrationEntry(ctx)
+		if err != nil {
+			return err
+		}
+
+		// If the mount isn't configured yet, we need to know which provider
+		// to configure before we register or update the client.
+		if c == nil {
+			if initial, resp, err = b.newRegisterConfig(ctx, data); err != nil || resp != nil {
+				return err
+			}
+		}
+
+		var client *registration.Client
+		if entry == nil {
+			endpoint := data.Get("registration_endpoint").(string)
+			if endpoint == "" {
+				resp = errorResponse(ErrorCodeInvalidRequest, "missing registration endpoint")
+				return nil
+			}
+
+			client, err = registration.Register(ctx, endpoint, data.Get("initial_access_token").(string), data.Get("metadata").(map[string]interface{}))
+			if err != nil {
+				resp, err = registrationErrorResponse(err, "client registration failed")
+				return err
+			}
+
+			entry = &persistence.RegistrationEntry{
+				RegistrationEndpoint: endpoint,
+				RegisterTime:         b.clock.Now(),
+			}
+		} else {
+			if !entry.Manageable() {
+				resp = errorResponse(ErrorCodeUnsupported, "the provider did not issue credentials to manage this registration")
+				return nil
+			}
+
+			md := entry.Metadata
+			if hasMetadata {
+				md = metadata.(map[string]interface{})
+			}
+
+			client, err = registration.Update(ctx, entry.RegistrationClientURI, entry.RegistrationAccessToken, entry.ClientID, md)
+			if err != nil {
+				resp, err = registrationErrorResponse(err, "client registration update failed")
+				return err
+			}
+
+			entry.UpdateTime = b.clock.Now()
+		}
+
+		entry.ClientID = client.ClientID
+		entry.Metadata = client.Metadata
+		if client.RegistrationClientURI != "" {
+			entry.RegistrationClientURI = client.RegistrationClientURI
+		}
+		if client.RegistrationAccessToken != "" {
+			entry.RegistrationAccessToken = client.RegistrationAccessToken
+		}
+		entry.ClientSecretExpireTime = time.Time{}
+		if client.ClientSecretExpiresAt > 0 {
+			entry.ClientSecretExpireTime = time.Unix(client.ClientSecretExpiresAt, 0)
+		}
+
+		if err := rm.WriteRegistrationEntry(ctx, entry); err != nil {
+			return err
+		}
+
+		return b.data.Managers(req.Storage).Config().WithLock(func(cm *persistence.LockedConfigManager) error {
+			cfg, err := cm.ReadConfig(ctx)
+			if err != nil {
+				return err
+			} else if cfg == nil {
+				if initial == nil {
+					// The configuration was deleted while we were talking to
+					// the provider.
+					resp = errorResponse(ErrorCodeNotConfigured, "not configured")
+					return nil
+				}
+
+				cfg = initial
+			}
+
+			switch {
+			case cfg.ClientID != client.ClientID:
+				cfg.ClientID = client.ClientID
+				cfg.ClientSecret = client.ClientSecret
+				cfg.PreviousClientSecret = ""
+				cfg.PreviousClientSecretExpireTime = time.Time{}
+			case client.ClientSecret != "":
+				b.rotateClientSecret(cfg, client.ClientSecret, gracePeriod)
+			}
+
+			rd := map[string]interface{}{
+				"client_id": cfg.ClientID,
+			}
+			if !entry.ClientSecretExpireTime.IsZero() {
+				rd["client_secret_expire_time"] = entry.ClientSecretExpireTime
+			}
+			if cfg.PreviousClientSecretValid(b.clock.Now()) {
+				rd["previous_client_secret_expire_time"] = cfg.PreviousClientSecretExpireTime
+			}
+
+			resp = &logical.Response{
+				Data: rd,
+			}
+
+			return cm.WriteConfig(ctx, cfg)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.reset()
+
+	return resp, nil
+}
+
+// newRegisterConfig creates the configuration for a mount that is configured
+// by registering a client.
+func (b *backend) newRegisterConfig(ctx context.Context, data *framework.FieldData) (*persistence.ConfigEntry, *logical.Response, error) {
+	providerName, ok := data.GetOk("provider")
+	if !ok {
+		return nil, errorResponse(ErrorCodeInvalidRequest, "missing provider"), nil
+	}
+
+	providerOptions := data.Get("provider_options").(map[string]string)
+
+	p, err := b.providerRegistry.New(ctx, providerName.(string), providerOptions)
+	if errors.Is(err, provider.ErrNoSuchProvider) {
+		return nil, errorResponse(ErrorCodeInvalidRequest, "provider %q does not exist", providerName), nil
+	} else if errmark.MarkedUser(err) {
+		return nil, errorResponse(ErrorCodeInvalidRequest, errmark.MarkShort(err).Error()), nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	c := &persistence.ConfigEntry{
+		Version:         persistence.ConfigVersionLatest,
+		ProviderName:    providerName.(string),
+		ProviderVersion: p.Version(),
+		ProviderOptions: providerOptions,
+		Tuning:          persistence.DefaultConfigTuningEntry,
+	}
+	return c, nil, nil
+}
+
+func (b *backend) configRegisterDeleteOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var resp *logical.Response
+	err := b.data.Managers(req.Storage).Registration().WithLock(func(rm *persistence.LockedRegistrationManager) error {
+		entry, err := rm.ReadRegistrationEntry(ctx)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		if entry.Manageable() {
+			if c, err := b.getCache(ctx, req.Storage); err != nil {
+				return err
+			} else if c != nil && c.Config.MaintenanceMode {
+				resp = errorResponse(ErrorCodeMaintenance, "requests to the provider are paused for maintenance")
+				return nil
+			}
+
+			if err := registration.Delete(ctx, entry.RegistrationClientURI, entry.RegistrationAccessToken); err != nil {
+				resp, err = registrationErrorResponse(err, "client registration delete failed")
+				return err
+			}
+		}
+
+		return rm.DeleteRegistrationEntry(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+const (
+	ConfigRegisterPath = ConfigPathPrefix + "register"
+)
+
+var configRegisterFields = map[string]*framework.FieldSchema{
+	"registration_endpoint": {
+		Type:        framework.TypeString,
+		Description: "Specifies the URL of the provider's dynamic client registration endpoint.",
+	},
+	"initial_access_token": {
+		Type:        framework.TypeString,
+		Description: "Specifies a token issued by the provider to authorize the registration, if required.",
+	},
+	"metadata": {
+		Type:        framework.TypeMap,
+		Description: "Specifies the client metadata to register, such as redirect_uris and client_name.",
+	},
+	"provider": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the provider to configure if the mount is not configured yet.",
+	},
+	"provider_options": {
+		Type:        framework.TypeKVPairs,
+		Description: "Specifies a list of options to pass on to the provider if the mount is not configured yet.",
+	},
+	"grace_period_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies how long the previous client secret is used to retry refreshes if the provider issues a new one.",
+		Default:     3600,
+	},
+}
+
+const configRegisterHelpSynopsis = `
+Registers this plugin as a client of the provider.
+`
+
+const configRegisterHelpDescription = `
+This endpoint uses OAuth 2.0 dynamic client registration (RFC 7591) to
+register a client with the provider and stores the issued client ID and
+secret in the configuration. If the mount is not configured yet, the
+provider must be specified. Writing to this endpoint again updates the
+registration using the client configuration endpoint (RFC 7592), which
+may cause the provider to issue a new client secret. Deleting this
+endpoint deletes the registration from the provider, but not the
+configuration.
+`
+
+func pathConfigRegister(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ConfigRegisterPath + `$`,
+		Fields:  configRegisterFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.configRegisterReadOperation,
+				Summary:   "Return the client registration.",
+				Responses: configRegisterReadResponses,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.configRegisterUpdateOperation,
+				Summary:                     "Register the client or update its registration.",
+				Responses:                   configRegisterWriteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback:                    b.configRegisterDeleteOperation,
+				Summary:                     "Delete the client registration.",
+				Responses:                   configRegisterDeleteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(configRegisterHelpSynopsis),
+		HelpDescription: strings.TrimSpace(configRegisterHelpDescription),
+	}
+}
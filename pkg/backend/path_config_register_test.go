@@ -0,0 +1,152 @@
+package backend_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestConfigRegister(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var deleted bool
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond := func(token, secret string) {
+			md := make(map[string]interface{})
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&md))
+
+			md["client_id"] = "abc"
+			md["client_secret"] = secret
+			md["registration_access_token"] = token
+			md["registration_client_uri"] = "http://localhost/register/abc"
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			assert.NoError(t, json.NewEncoder(w).Encode(md))
+		}
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/register":
+			assert.Equal(t, "Bearer initial", r.Header.Get("Authorization"))
+			respond("rat_1", "def")
+		case r.Method == http.MethodPut && r.URL.Path == "/register/abc":
+			assert.Equal(t, "Bearer rat_1", r.Header.Get("Authorization"))
+			respond("rat_2", "ghi")
+		case r.Method == http.MethodDelete && r.URL.Path == "/register/abc":
+			assert.Equal(t, "Bearer rat_2", r.Header.Get("Authorization"))
+			deleted = true
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}})
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory())
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// The mount isn't configured, so we need to know the provider.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigRegisterPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"registration_endpoint": "http://localhost/register",
+			"initial_access_token":  "initial",
+			"metadata": map[string]interface{}{
+				"client_name":   "vault",
+				"redirect_uris": []string{"http://localhost/callback"},
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.EqualError(t, resp.Error(), "[ERR_INVALID_REQUEST] missing provider")
+
+	req.Data["provider"] = "mock"
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "abc", resp.Data["client_id"])
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.Equal(t, "abc", resp.Data["client_id"])
+	require.Equal(t, "mock", resp.Data["provider"])
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigRegisterPath,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.Equal(t, "http://localhost/register/abc", resp.Data["registration_client_uri"])
+	require.Equal(t, true, resp.Data["manageable"])
+	require.Equal(t, "vault", resp.Data["metadata"].(map[string]interface{})["client_name"])
+	require.NotContains(t, resp.Data, "registration_access_token")
+
+	// Updating the registration rotates the client secret.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigRegisterPath,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.NotEmpty(t, resp.Data["previous_client_secret_expire_time"])
+
+	// Deleting the registration removes it from the provider.
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      backend.ConfigRegisterPath,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.True(t, deleted)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigRegisterPath,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
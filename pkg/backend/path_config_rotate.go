@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmap"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/semerr"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"golang.org/x/oauth2"
+)
+
+// clientAuthenticated returns true if the result of a client credentials
+// request shows that the provider accepted the client ID and secret. Providers
+// report a client that fails to authenticate with the invalid_client error, so
+// a client that authenticates but isn't allowed to use the grant still passes.
+func clientAuthenticated(err error) bool {
+	return err == nil ||
+		semerr.IsCode(err, "unauthorized_client") ||
+		semerr.IsCode(err, "unsupported_grant_type") ||
+		semerr.IsCode(err, "invalid_scope")
+}
+
+// validateClientSecret makes a client credentials request using the given
+// secret.
+func (b *backend) validateClientSecret(ctx context.Context, c *cache, clientSecret string) (*logical.Response, error) {
+	p, err := c.ProviderWithTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.Private(c.Config.ClientID, clientSecret).ClientCredentials(clockctx.WithClock(ctx, b.clock))
+	switch {
+	case clientAuthenticated(err):
+	case errmark.Matches(err, errmark.RuleType(&oauth2.RetrieveError{})) || errmark.MarkedUser(err):
+		return errorResponse(ErrorCodeProviderRejected, errmap.Wrap(errmark.MarkShort(err), "client secret validation failed").Error()), nil
+	default:
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// rotateClientSecret replaces the client secret in the given configuration,
+// keeping the previous secret for the grace period.
+func (b *backend) rotateClientSecret(cfg *persistence.ConfigEntry, clientSecret string, gracePeriod time.Duration) {
+	cfg.PreviousClientSecret = ""
+	cfg.PreviousClientSecretExpireTime = time.Time{}
+	if gracePeriod > 0 && cfg.ClientSecret != "" && cfg.ClientSecret != clientSecret {
+		cfg.PreviousClientSecret = cfg.ClientSecret
+		cfg.PreviousClientSecretExpireTime = b.clock.Now().Add(gracePeriod)
+	}
+	cfg.ClientSecret = clientSecret
+}
+
+func (b *backend) configRotateUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	clientSecret, ok := data.GetOk("client_secret")
+	if !ok || clientSecret.(string) == "" {
+		return errorResponse(ErrorCodeInvalidRequest, "missing client secret"), nil
+	}
+
+	gracePeriod := time.Duration(data.Get("grace_period_seconds").(int)) * time.Second
+	if gracePeriod < 0 {
+		return errorResponse(ErrorCodeInvalidRequest, "grace period cannot be negative"), nil
+	}
+
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	}
+
+	if data.Get("validate").(bool) {
+		if c.Config.MaintenanceMode {
+			return errorResponse(ErrorCodeMaintenance, "requests to the provider are paused for maintenance"), nil
+		}
+
+		if resp, err := b.validateClientSecret(ctx, c, clientSecret.(string)); err != nil || resp != nil {
+			return resp, err
+		}
+	}
+
+	var resp *logical.Response
+	err = b.data.Managers(req.Storage).Config().WithLock(func(cm *persistence.LockedConfigManager) error {
+		cfg, err := cm.ReadConfig(ctx)
+		if err != nil {
+			return err
+		} else if cfg == nil {
+			resp = errorResponse(ErrorCodeNotConfigured, "not configured")
+			return nil
+		}
+
+		b.rotateClientSecret(cfg, clientSecret.(string), gracePeriod)
+		if cfg.PreviousClientSecretValid(b.clock.Now()) {
+			resp = &logical.Response{
+				Data: map[string]interface{}{
+					"previous_client_secret_expire_time": cfg.PreviousClientSecretExpireTime,
+				},
+			}
+		}
+
+		return cm.WriteConfig(ctx, cfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.reset()
+
+	return resp, nil
+}
+
+const (
+	ConfigRotatePath = ConfigPathPrefix + "rotate"
+)
+
+var configRotateFields = map[string]*framework.FieldSchema{
+	"client_secret": {
+		Type:        framework.TypeString,
+		Description: "Specifies the new OAuth 2.0 client secret.",
+	},
+	"grace_period_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies how long the previous client secret is used to retry refreshes that the provider rejects with the new one.",
+		Default:     3600,
+	},
+	"validate": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to validate the new client secret with the provider before storing it.",
+		Default:     true,
+	},
+}
+
+const configRotateHelpSynopsis = `
+Rotates the OAuth 2.0 client secret.
+`
+
+const configRotateHelpDescription = `
+This endpoint replaces the client secret without rewriting the rest of
+the configuration. Unless validation is disabled, the new secret is
+first used to make a client credentials request; the rotation fails if
+the provider does not accept it. The previous secret is retained for
+the grace period so that refreshes already in flight, or that reach a
+provider that has not yet seen the new secret, can still succeed.
+`
+
+func pathConfigRotate(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ConfigRotatePath + `$`,
+		Fields:  configRotateFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.configRotateUpdateOperation,
+				Summary:                     "Rotate the client secret.",
+				Responses:                   configRotateResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(configRotateHelpSynopsis),
+		HelpDescription: strings.TrimSpace(configRotateHelpDescription),
+	}
+}
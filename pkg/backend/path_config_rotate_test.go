@@ -0,0 +1,165 @@
+package backend_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigRotate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	previous := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+	next := testutil.MockClient{
+		ID:     "abc",
+		Secret: "ghi",
+	}
+
+	refresh := func(i int) (time.Duration, error) {
+		switch i {
+		case 1:
+			return 2 * time.Second, nil
+		default:
+			return 10 * time.Minute, nil
+		}
+	}
+
+	// The provider hasn't picked up the new secret for refreshes yet.
+	rejected := func(_ string, _ *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		return nil, testutil.MockErrorResponse(http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(previous, testutil.RefreshableMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), refresh)),
+		testutil.MockWithAuthCodeExchange(next, rejected),
+		testutil.MockWithClientCredentials(next, testutil.RandomMockClientCredentials),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":                           previous.ID,
+			"client_secret":                       previous.Secret,
+			"provider":                            "mock",
+			"tune_refresh_check_interval_seconds": 0,
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Write a credential that needs to be refreshed.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"code": "test",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// A secret the provider doesn't accept is not stored.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigRotatePath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_secret": "nope",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	require.True(t, strings.HasPrefix(resp.Error().Error(), "[ERR_PROVIDER_REJECTED] "), resp.Error().Error())
+
+	// Rotate to the new secret.
+	req.Data["client_secret"] = next.Secret
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.NotEmpty(t, resp.Data["previous_client_secret_expire_time"])
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.NotEmpty(t, resp.Data["previous_client_secret_expire_time"])
+	require.NotContains(t, resp.Data, "client_secret")
+
+	// The refresh is rejected with the new secret and retried with the
+	// previous one.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_2", resp.Data["access_token"])
+
+	// Without a grace period, the previous secret is discarded immediately.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigRotatePath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_secret":        "jkl",
+			"grace_period_seconds": 0,
+			"validate":             false,
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.NotContains(t, resp.Data, "previous_client_secret_expire_time")
+}
@@ -0,0 +1,92 @@
+package backend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) configSchedulerReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	}
+
+	status := b.refreshSchedule.Status(b.clock.Now())
+
+	rd := map[string]interface{}{
+		"refresh_enabled":       c.Config.Tuning.RefreshCheckIntervalSeconds > 0 && !c.Config.MaintenanceMode,
+		"maintenance_mode":      c.Config.MaintenanceMode,
+		"running":               status.Built,
+		"scheduled_credentials": status.Scheduled,
+		"due_credentials":       status.Due,
+	}
+
+	if !status.Next.IsZero() {
+		rd["next_refresh_time"] = status.Next
+	}
+
+	if !status.LastCheck.IsZero() {
+		rd["last_check_time"] = status.LastCheck
+	}
+
+	if !status.Rebuilt.IsZero() {
+		rd["last_rebuild_time"] = status.Rebuilt
+	}
+
+	if c.Breaker != nil {
+		bs := c.Breaker.Status(b.clock.Now())
+
+		rd["circuit_breaker_state"] = bs.State
+		rd["circuit_breaker_failures"] = bs.Failures
+		if !bs.OpenUntil.IsZero() {
+			rd["circuit_breaker_open_until"] = bs.OpenUntil
+		}
+		if bs.LastError != "" {
+			rd["circuit_breaker_last_error"] = bs.LastError
+		}
+		if !bs.LastOpening.IsZero() {
+			rd["circuit_breaker_last_open_time"] = bs.LastOpening
+		}
+	}
+
+	return &logical.Response{
+		Data: rd,
+	}, nil
+}
+
+const (
+	ConfigSchedulerPath = ConfigPathPrefix + "scheduler"
+)
+
+const configSchedulerHelpSynopsis = `
+Reports the state of the automatic credential refresher.
+`
+
+const configSchedulerHelpDescription = `
+This endpoint summarizes the schedule kept by the automatic credential
+refresher on this node: how many credentials are scheduled, how many are
+due, when the next one is due, and when the refresher last checked for
+due credentials and last rebuilt its schedule from storage, as well as the
+state of the provider circuit breaker if it is enabled. The schedule is
+kept in memory, so it only describes the node that handles the request.
+`
+
+func pathConfigScheduler(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ConfigSchedulerPath + `$`,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.configSchedulerReadOperation,
+				Summary:   "Return the state of the automatic credential refresher.",
+				Responses: configSchedulerResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(configSchedulerHelpSynopsis),
+		HelpDescription: strings.TrimSpace(configSchedulerHelpDescription),
+	}
+}
@@ -0,0 +1,248 @@
+package backend_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/puppetlabs/leg/timeutil/pkg/retry"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	testclock "k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestConfigScheduler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	clk := testclock.NewFakeClock(time.Now())
+	exchange := testutil.AmendTokenMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(tok *provider.Token) error {
+			tok.RefreshToken = "refresh"
+			tok.Expiry = clk.Now().Add(10 * time.Minute)
+			return nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock: clock.NewTimerCallbackClock(
+			k8sext.NewClock(clk),
+			func(d time.Duration) {
+				clk.Step(d)
+			},
+		),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	// The scheduler cannot be read until the mount is configured.
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigSchedulerPath,
+		Storage:   storage,
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.True(t, resp != nil && resp.IsError())
+
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"client_id":     client.ID,
+				"client_secret": client.Secret,
+				"provider":      "mock",
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + "test",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"code": "test",
+			},
+		},
+	} {
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	// Wait for the refresher to consider the credential.
+	require.NoError(t, retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + "test",
+			Storage:   storage,
+		}
+
+		// The clock moves quickly, so the token may briefly be expired.
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		if resp.IsError() {
+			return retry.Repeat(resp.Error())
+		}
+
+		if _, found := resp.Data["last_refresh_check_time"]; !found {
+			return retry.Repeat(fmt.Errorf("credential not yet considered"))
+		}
+
+		assert.NotEmpty(t, resp.Data["next_scheduled_refresh"])
+		return retry.Done(nil)
+	}))
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, true, resp.Data["refresh_enabled"])
+	assert.Equal(t, true, resp.Data["running"])
+	assert.Equal(t, 1, resp.Data["scheduled_credentials"])
+	assert.NotEmpty(t, resp.Data["next_refresh_time"])
+	assert.NotEmpty(t, resp.Data["last_check_time"])
+	assert.NotEmpty(t, resp.Data["last_rebuild_time"])
+}
+
+func TestConfigSchedulerCircuitBreaker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	var failing int32
+	exchange := testutil.AmendTokenMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(tok *provider.Token) error {
+			if atomic.LoadInt32(&failing) != 0 {
+				return testutil.MockErrorResponse(http.StatusServiceUnavailable, nil)
+			}
+
+			tok.RefreshToken = "refresh"
+			tok.Expiry = clk.Now().Add(time.Minute)
+			return nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(req *logical.Request) *logical.Response {
+		req.Storage = storage
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigPath,
+			Data: map[string]interface{}{
+				"client_id":                              client.ID,
+				"client_secret":                          client.Secret,
+				"provider":                               "mock",
+				"tune_circuit_breaker_failures":          2,
+				"tune_circuit_breaker_cool_down_seconds": 60,
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + "test",
+			Data: map[string]interface{}{
+				"code": "test",
+			},
+		},
+	} {
+		resp := handle(req)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	status := func() map[string]interface{} {
+		resp := handle(&logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.ConfigSchedulerPath,
+		})
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp.Data
+	}
+
+	read := func() *logical.Response {
+		resp := handle(&logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + "test",
+		})
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	assert.Equal(t, "closed", status()["circuit_breaker_state"])
+
+	// Expire the token while the provider is down.
+	atomic.StoreInt32(&failing, 1)
+	clk.Step(2 * time.Minute)
+
+	require.True(t, read().IsError())
+	assert.Equal(t, "closed", status()["circuit_breaker_state"])
+	assert.Equal(t, 1, status()["circuit_breaker_failures"])
+
+	require.True(t, read().IsError())
+	st := status()
+	assert.Equal(t, "open", st["circuit_breaker_state"])
+	assert.Equal(t, 2, st["circuit_breaker_failures"])
+	assert.Equal(t, clk.Now().Add(time.Minute), st["circuit_breaker_open_until"])
+	assert.NotEmpty(t, st["circuit_breaker_last_error"])
+
+	// After the cool-down period, refreshes are attempted again.
+	clk.Step(time.Minute)
+	assert.Equal(t, "half-open", status()["circuit_breaker_state"])
+
+	atomic.StoreInt32(&failing, 0)
+
+	resp := read()
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	st = status()
+	assert.Equal(t, "closed", st["circuit_breaker_state"])
+	assert.Equal(t, 0, st["circuit_breaker_failures"])
+	assert.NotContains(t, st, "circuit_breaker_open_until")
+	assert.NotEmpty(t, st["circuit_breaker_last_open_time"])
+}
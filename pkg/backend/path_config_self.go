@@ -37,7 +37,11 @@ func (b *backend) configSelfUpdateOperation(ctx context.Context, req *logical.Re
 	if err != nil {
 		return nil, err
 	} else if c == nil {
-		return logical.ErrorResponse("not configured"), nil
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	} else if c.Config.MaintenanceMode {
+		return errorResponse(ErrorCodeMaintenance, "requests to the provider are paused for maintenance"), nil
+	} else if !c.Config.GrantTypeAllowed(ClientCredentialsGrantType) {
+		return grantTypeNotAllowedResponse(ClientCredentialsGrantType), nil
 	}
 
 	entry := &persistence.ClientCredsEntry{}
@@ -45,14 +49,23 @@ func (b *backend) configSelfUpdateOperation(ctx context.Context, req *logical.Re
 	entry.Config.Scopes = data.Get("scopes").([]string)
 	entry.Config.ProviderOptions = data.Get("provider_options").(map[string]string)
 
-	tok, err := c.ProviderWithTimeout(defaultExpiryDelta).Private(c.Config.ClientID, c.Config.ClientSecret).ClientCredentials(
+	if resp := scopeNotAllowedResponse(c.Config.DisallowedScopes(entry.Config.Scopes)); resp != nil {
+		return resp, nil
+	}
+
+	p, err := c.ProviderWithTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := p.Private(c.Config.ClientID, c.Config.ClientSecret).ClientCredentials(
 		clockctx.WithClock(ctx, b.clock),
 		provider.WithURLParams(entry.Config.TokenURLParams),
 		provider.WithScopes(entry.Config.Scopes),
 		provider.WithProviderOptions(entry.Config.ProviderOptions),
 	)
 	if errmark.Matches(err, errmark.RuleType(&oauth2.RetrieveError{})) || errmark.MarkedUser(err) {
-		return logical.ErrorResponse(errmap.Wrap(errmark.MarkShort(err), "client credentials flow failed").Error()), nil
+		return errorResponse(ErrorCodeProviderRejected, errmap.Wrap(errmark.MarkShort(err), "client credentials flow failed").Error()), nil
 	} else if err != nil {
 		return nil, err
 	}
@@ -121,16 +134,23 @@ func pathConfigSelf(b *backend) *framework.Path {
 		Fields:  configSelfFields,
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
-				Callback: b.configSelfReadOperation,
-				Summary:  "Return the current configuration for this credential.",
+				Callback:  b.configSelfReadOperation,
+				Summary:   "Return the current configuration for this credential.",
+				Responses: configSelfReadResponses,
 			},
 			logical.UpdateOperation: &framework.PathOperation{
-				Callback: b.configSelfUpdateOperation,
-				Summary:  "Create a new credential configuration or replace the configuration with new settings.",
+				Callback:                    b.configSelfUpdateOperation,
+				Summary:                     "Create a new credential configuration or replace the configuration with new settings.",
+				Responses:                   configSelfWriteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
 			},
 			logical.DeleteOperation: &framework.PathOperation{
-				Callback: b.configSelfDeleteOperation,
-				Summary:  "Remove a credential configuration and any associated token.",
+				Callback:                    b.configSelfDeleteOperation,
+				Summary:                     "Remove a credential configuration and any associated token.",
+				Responses:                   configSelfDeleteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
 			},
 		},
 		HelpSynopsis:    strings.TrimSpace(configSelfHelpSynopsis),
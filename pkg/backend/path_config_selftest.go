@@ -0,0 +1,211 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"golang.org/x/oauth2"
+)
+
+type configTestStatus string
+
+const (
+	configTestStatusOK      configTestStatus = "ok"
+	configTestStatusFailed  configTestStatus = "failed"
+	configTestStatusSkipped configTestStatus = "skipped"
+)
+
+type configTestCheck struct {
+	Name    string
+	Status  configTestStatus
+	Message string
+}
+
+func (ctc *configTestCheck) data() map[string]interface{} {
+	return map[string]interface{}{
+		"name":    ctc.Name,
+		"status":  string(ctc.Status),
+		"message": ctc.Message,
+	}
+}
+
+// configTestRunner runs each check of the config/test endpoint in turn. Once
+// a check fails, the checks that depend on it are skipped.
+type configTestRunner struct {
+	timeout time.Duration
+	checks  []*configTestCheck
+	failed  bool
+}
+
+func (ctr *configTestRunner) run(ctx context.Context, name string, fn func(ctx context.Context) (configTestStatus, string)) {
+	check := &configTestCheck{Name: name}
+	ctr.checks = append(ctr.checks, check)
+
+	if ctr.failed {
+		check.Status, check.Message = configTestStatusSkipped, "a previous check failed"
+		return
+	}
+
+	if ctr.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ctr.timeout)
+		defer cancel()
+	}
+
+	check.Status, check.Message = fn(ctx)
+	if check.Status == configTestStatusFailed {
+		ctr.failed = true
+	}
+}
+
+// checkTokenEndpoint sends an empty token request to the given URL. Any
+// response other than a server error shows that the endpoint is reachable.
+func checkTokenEndpoint(ctx context.Context, tokenURL string) (configTestStatus, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, http.NoBody)
+	if err != nil {
+		return configTestStatusFailed, err.Error()
+	}
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	resp, err := oauth2.NewClient(ctx, nil).Do(req)
+	if err != nil {
+		return configTestStatusFailed, err.Error()
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return configTestStatusFailed, fmt.Sprintf("token endpoint %s responded with HTTP status %d", tokenURL, resp.StatusCode)
+	}
+
+	return configTestStatusOK, fmt.Sprintf("token endpoint %s responded with HTTP status %d", tokenURL, resp.StatusCode)
+}
+
+func (b *backend) configTestUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var sve *persistence.StorageVersionError
+	if err := b.checkStorageVersion(ctx, req.Storage); errors.As(err, &sve) {
+		return errorResponse(ErrorCodeStorageVersion, err.Error()), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// We don't use the cache here because loading it fails outright if the
+	// provider can't be set up, which is one of the things we want to report.
+	cfg, err := b.data.Managers(req.Storage).Config().ReadConfig(ctx)
+	if err != nil {
+		return nil, err
+	} else if cfg == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	} else if cfg.MaintenanceMode {
+		return errorResponse(ErrorCodeMaintenance, "requests to the provider are paused for maintenance"), nil
+	}
+
+	// The provider may start background work that lasts as long as its
+	// context, so it must not outlive this request.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	runner := &configTestRunner{
+		timeout: time.Duration(cfg.Tuning.ProviderTimeoutSeconds) * time.Second,
+	}
+
+	var p provider.Provider
+	runner.run(ctx, "discovery", func(ctx context.Context) (configTestStatus, string) {
+		p, err = b.providerRegistry.NewAt(ctx, cfg.ProviderName, cfg.ProviderVersion, cfg.ProviderOptions)
+		if err != nil {
+			return configTestStatusFailed, errmark.MarkShort(err).Error()
+		}
+
+		return configTestStatusOK, fmt.Sprintf("provider %q (version %d) loaded", cfg.ProviderName, p.Version())
+	})
+
+	runner.run(ctx, "token_endpoint", func(ctx context.Context) (configTestStatus, string) {
+		eo, ok := p.Public(cfg.ClientID).(provider.EndpointOperations)
+		if !ok || eo.TokenURL() == "" {
+			return configTestStatusSkipped, "provider does not report its token endpoint"
+		}
+
+		return checkTokenEndpoint(ctx, eo.TokenURL())
+	})
+
+	runner.run(ctx, "client_credentials", func(ctx context.Context) (configTestStatus, string) {
+		switch {
+		case !data.Get("client_credentials").(bool):
+			return configTestStatusSkipped, "not requested"
+		case cfg.ClientSecret == "":
+			return configTestStatusSkipped, "missing client secret in configuration"
+		}
+
+		_, err := p.Private(cfg.ClientID, cfg.ClientSecret).ClientCredentials(clockctx.WithClock(ctx, b.clock))
+		if !clientAuthenticated(err) {
+			return configTestStatusFailed, errmark.MarkShort(err).Error()
+		}
+
+		return configTestStatusOK, "provider accepted the client ID and secret"
+	})
+
+	checks := make([]interface{}, len(runner.checks))
+	for i, check := range runner.checks {
+		checks[i] = check.data()
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"ok":     !runner.failed,
+			"checks": checks,
+		},
+	}
+	return resp, nil
+}
+
+const (
+	ConfigTestPath = ConfigPathPrefix + "test"
+)
+
+var configTestFields = map[string]*framework.FieldSchema{
+	"client_credentials": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to make a client credentials request to check that the provider accepts the client ID and secret.",
+	},
+}
+
+const configTestHelpSynopsis = `
+Checks that the provider can be reached with the current configuration.
+`
+
+const configTestHelpDescription = `
+This endpoint loads the configured provider, fetching its discovery
+document if it has one, checks that its token endpoint responds, and,
+if requested, makes a client credentials request to validate the client
+secret. It returns the result of each check. Nothing is stored.
+`
+
+func pathConfigTest(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ConfigTestPath + `$`,
+		Fields:  configTestFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.configTestUpdateOperation,
+				Summary:                     "Check the connection to the provider.",
+				Responses:                   configTestResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(configTestHelpSynopsis),
+		HelpDescription: strings.TrimSpace(configTestHelpDescription),
+	}
+}
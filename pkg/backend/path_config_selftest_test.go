@@ -0,0 +1,82 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestConfigTest(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mi := testutil.NewMockIssuer(testutil.MockIssuerWithClient(testutil.MockClient{ID: "abc", Secret: "def"}))
+	defer mi.Close()
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, mi.Client())
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	statuses := func(secret string) (bool, map[string]string) {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"client_id":     "abc",
+				"client_secret": secret,
+				"provider":      "oidc",
+				"provider_options": map[string]interface{}{
+					"issuer_url": mi.URL,
+				},
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigTestPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"client_credentials": true,
+			},
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+		m := make(map[string]string)
+		for _, check := range resp.Data["checks"].([]interface{}) {
+			check := check.(map[string]interface{})
+			m[check["name"].(string)] = check["status"].(string)
+		}
+		return resp.Data["ok"].(bool), m
+	}
+
+	ok, m := statuses("def")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{
+		"discovery":          "ok",
+		"token_endpoint":     "ok",
+		"client_credentials": "ok",
+	}, m)
+
+	ok, m = statuses("wrong")
+	assert.False(t, ok)
+	assert.Equal(t, "failed", m["client_credentials"])
+}
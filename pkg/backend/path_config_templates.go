@@ -0,0 +1,162 @@
+package backend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+func (b *backend) configTemplatesListOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	names, err := b.data.Managers(req.Storage).CredTemplate().ListCredTemplateNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return logical.ListResponse(names), nil
+}
+
+func (b *backend) configTemplatesReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.data.Managers(req.Storage).CredTemplate().ReadCredTemplateEntry(ctx, data.Get("name").(string))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"name":             entry.Name,
+			"scopes":           entry.Scopes,
+			"redirect_url":     entry.RedirectURL,
+			"provider_options": entry.ProviderOptions,
+			"metadata":         entry.Metadata,
+		},
+	}
+	return resp, nil
+}
+
+func (b *backend) configTemplatesUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry := &persistence.CredTemplateEntry{
+		Name:            data.Get("name").(string),
+		Scopes:          data.Get("scopes").([]string),
+		RedirectURL:     data.Get("redirect_url").(string),
+		ProviderOptions: data.Get("provider_options").(map[string]string),
+		Metadata:        data.Get("metadata").(map[string]string),
+	}
+
+	if err := b.data.Managers(req.Storage).CredTemplate().WriteCredTemplateEntry(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) configTemplatesDeleteOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := b.data.Managers(req.Storage).CredTemplate().DeleteCredTemplateEntry(ctx, data.Get("name").(string)); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// readCredTemplate returns the named template, or nil if no name is given.
+func (b *backend) readCredTemplate(ctx context.Context, storage logical.Storage, name string) (*persistence.CredTemplateEntry, *logical.Response, error) {
+	if name == "" {
+		return nil, nil, nil
+	}
+
+	entry, err := b.data.Managers(storage).CredTemplate().ReadCredTemplateEntry(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	} else if entry == nil {
+		return nil, errorResponse(ErrorCodeInvalidRequest, "template %q does not exist", name), nil
+	}
+
+	return entry, nil, nil
+}
+
+const (
+	ConfigTemplatesPathPrefix = ConfigPathPrefix + "templates/"
+)
+
+var configTemplatesFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the template.",
+	},
+	"scopes": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the default scopes to request when authorizing credentials created from this template.",
+	},
+	"redirect_url": {
+		Type:        framework.TypeString,
+		Description: "Specifies the default redirect URL for credentials created from this template.",
+	},
+	"provider_options": {
+		Type:        framework.TypeKVPairs,
+		Description: "Specifies default provider-specific options for credentials created from this template.",
+	},
+	"metadata": {
+		Type:        framework.TypeKVPairs,
+		Description: "Specifies metadata to attach to credentials created from this template. Values derived from token claims take precedence.",
+	},
+}
+
+const configTemplatesHelpSynopsis = `
+Manages templates for creating credentials.
+`
+
+const configTemplatesHelpDescription = `
+A template holds default scopes, a redirect URL, provider options, and
+metadata. Generating an authorization code URL with a template uses its
+settings for any that are not given, and writing the resulting code
+creates a credential that records the template and inherits its
+metadata. Changes to a template do not affect credentials that were
+already created from it.
+`
+
+func pathConfigTemplatesList(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ConfigTemplatesPathPrefix + `?$`,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback:  b.configTemplatesListOperation,
+				Summary:   "List the credential templates.",
+				Responses: configTemplatesListResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(configTemplatesHelpSynopsis),
+		HelpDescription: strings.TrimSpace(configTemplatesHelpDescription),
+	}
+}
+
+func pathConfigTemplates(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ConfigTemplatesPathPrefix + framework.GenericNameRegex("name") + `$`,
+		Fields:  configTemplatesFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.configTemplatesReadOperation,
+				Summary:   "Get a credential template.",
+				Responses: configTemplatesReadResponses,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.configTemplatesUpdateOperation,
+				Summary:                     "Write a credential template.",
+				Responses:                   configTemplatesUpdateResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback:                    b.configTemplatesDeleteOperation,
+				Summary:                     "Remove a credential template.",
+				Responses:                   configTemplatesDeleteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(configTemplatesHelpSynopsis),
+		HelpDescription: strings.TrimSpace(configTemplatesHelpDescription),
+	}
+}
@@ -0,0 +1,148 @@
+package backend_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigTemplates(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exchange := testutil.AmendTokenMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(tok *provider.Token) error {
+			tok.ExtraData = map[string]interface{}{
+				"id_token_claims": map[string]interface{}{"email": "alice@example.com"},
+			}
+			return nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, map[string]interface{}{
+		"client_id":      client.ID,
+		"client_secret":  client.Secret,
+		"provider":       "mock",
+		"claim_metadata": map[string]interface{}{"email": "email"},
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.UpdateOperation, backend.ConfigTemplatesPathPrefix+`engineering`, map[string]interface{}{
+		"scopes":       []string{"openid", "email"},
+		"redirect_url": "http://example.com/redirect",
+		"metadata":     map[string]interface{}{"team": "engineering", "email": "unknown"},
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ListOperation, backend.ConfigTemplatesPathPrefix, nil)
+	require.NotNil(t, resp)
+	assert.Equal(t, []string{"engineering"}, resp.Data["keys"])
+
+	resp = handle(logical.ReadOperation, backend.ConfigTemplatesPathPrefix+`engineering`, nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, []string{"openid", "email"}, resp.Data["scopes"])
+	assert.Equal(t, "http://example.com/redirect", resp.Data["redirect_url"])
+
+	// Unknown templates are rejected.
+	resp = handle(logical.UpdateOperation, backend.AuthCodeURLCredsPathPrefix+"alice", map[string]interface{}{
+		"template": "sales",
+	})
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	// The URL inherits the settings of the template that are not given.
+	resp = handle(logical.UpdateOperation, backend.AuthCodeURLCredsPathPrefix+"alice", map[string]interface{}{
+		"template": "engineering",
+	})
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	u, err := url.Parse(resp.Data["url"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "openid email", u.Query().Get("scope"))
+	assert.Equal(t, "http://example.com/redirect", u.Query().Get("redirect_uri"))
+
+	// The credential records the template and inherits its metadata, but
+	// claims take precedence.
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+`alice`, map[string]interface{}{
+		"code":  "123456",
+		"state": resp.Data["state"],
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`alice`, nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "engineering", resp.Data["template"])
+	assert.Equal(t, map[string]string{"team": "engineering", "email": "alice@example.com"}, resp.Data["metadata"])
+
+	// Changing the template does not affect existing credentials, and the
+	// template is retained when the credential is authorized again.
+	resp = handle(logical.UpdateOperation, backend.ConfigTemplatesPathPrefix+`engineering`, map[string]interface{}{
+		"metadata": map[string]interface{}{"team": "platform"},
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+`alice`, map[string]interface{}{
+		"code": "123456",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`alice`, nil)
+	require.NotNil(t, resp)
+	assert.Equal(t, "token_2", resp.Data["access_token"])
+	assert.Equal(t, "engineering", resp.Data["template"])
+	assert.Equal(t, map[string]string{"team": "engineering", "email": "alice@example.com"}, resp.Data["metadata"])
+
+	// A template can be given directly when writing a code.
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+`bob`, map[string]interface{}{
+		"code":     "123456",
+		"template": "engineering",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`bob`, nil)
+	require.NotNil(t, resp)
+	assert.Equal(t, map[string]string{"team": "platform", "email": "alice@example.com"}, resp.Data["metadata"])
+
+	resp = handle(logical.DeleteOperation, backend.ConfigTemplatesPathPrefix+`engineering`, nil)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Nil(t, handle(logical.ReadOperation, backend.ConfigTemplatesPathPrefix+`engineering`, nil))
+}
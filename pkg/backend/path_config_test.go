@@ -2,7 +2,16 @@ package backend_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -12,6 +21,8 @@ import (
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2/jwt"
 )
 
 func TestConfigReadWrite(t *testing.T) {
@@ -64,6 +75,112 @@ func TestConfigReadWrite(t *testing.T) {
 	require.Equal(t, 2, resp.Data["provider_version"])
 }
 
+func TestConfigIdempotent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory())
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// The first write creates the configuration.
+	checkFound, exists, err := b.HandleExistenceCheck(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.True(t, checkFound)
+	require.False(t, exists)
+
+	write := &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":        "abc",
+			"client_secret":    "def",
+			"provider":         "mock",
+			"provider_options": map[string]interface{}{"unused": ""},
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, write)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	_, exists, err = b.HandleExistenceCheck(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	read := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, read)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	// Unset maps are empty instead of null, and empty options are dropped.
+	assert.Equal(t, map[string]string{}, resp.Data["auth_url_params"])
+	assert.Equal(t, map[string]string{}, resp.Data["provider_options"])
+
+	// Every field that can be written is returned, except for the secrets.
+	for name := range b.Route(backend.ConfigPath).Fields {
+		if name != "client_secret" && name != "request_object_signing_key" {
+			assert.Contains(t, resp.Data, name)
+		}
+	}
+
+	// Rotate the secret, then write back what was read. The configuration is
+	// unchanged, so the grace period of the previous secret is kept.
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigRotatePath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_secret": "ghi",
+			"validate":      false,
+		},
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp, err = b.HandleRequest(ctx, read)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.NotEmpty(t, resp.Data["previous_client_secret_expire_time"])
+
+	expected := resp.Data
+	write.Operation = logical.UpdateOperation
+	write.Data = make(map[string]interface{}, len(expected))
+	for k, v := range expected {
+		if k != "provider_version" && k != "previous_client_secret_expire_time" {
+			write.Data[k] = v
+		}
+	}
+	write.Data["client_secret"] = "ghi"
+
+	resp, err = b.HandleRequest(ctx, write)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp, err = b.HandleRequest(ctx, read)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, expected, resp.Data)
+}
+
 func TestConfigAuthCodeURL(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -130,6 +247,289 @@ func TestConfigAuthCodeURL(t *testing.T) {
 	assert.Equal(t, "quux", qs.Get("baz"))
 }
 
+func TestConfigAuthCodeURLRequestObject(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory())
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	config := map[string]interface{}{
+		"client_id":                     "abc",
+		"client_secret":                 "def",
+		"provider":                      "mock",
+		"request_object_signing_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"request_object_signing_key_id": "key-1",
+	}
+
+	// The audience is required.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data:      config,
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.True(t, resp != nil && resp.IsError())
+
+	config["request_object_audience"] = "https://example.com"
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Retrieve an auth code URL.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigAuthCodeURLPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"state":        "qwerty",
+			"scopes":       []string{"read", "write"},
+			"redirect_url": "http://example.com/redirect",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	u, err := url.Parse(resp.Data["url"].(string))
+	require.NoError(t, err)
+
+	// Only the parameters OpenID Connect requires remain in the URL.
+	qs := u.Query()
+	assert.Equal(t, "code", qs.Get("response_type"))
+	assert.Equal(t, "abc", qs.Get("client_id"))
+	assert.Equal(t, "read write", qs.Get("scope"))
+	assert.Empty(t, qs.Get("state"))
+	assert.Empty(t, qs.Get("redirect_uri"))
+
+	tok, err := jwt.ParseSigned(qs.Get("request"))
+	require.NoError(t, err)
+	require.Len(t, tok.Headers, 1)
+	assert.Equal(t, "key-1", tok.Headers[0].KeyID)
+
+	var claims struct {
+		jwt.Claims
+		State       string `json:"state"`
+		RedirectURI string `json:"redirect_uri"`
+		Scope       string `json:"scope"`
+	}
+	require.NoError(t, tok.Claims(&key.PublicKey, &claims))
+	require.NoError(t, claims.Validate(jwt.Expected{
+		Issuer:   "abc",
+		Audience: jwt.Audience{"https://example.com"},
+		Time:     time.Now(),
+	}))
+	assert.Equal(t, "qwerty", claims.State)
+	assert.Equal(t, "http://example.com/redirect", claims.RedirectURI)
+	assert.Equal(t, "read write", claims.Scope)
+}
+
+func TestConfigProviderUnavailable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mi := testutil.NewMockIssuer(testutil.MockIssuerWithClient(testutil.MockClient{ID: "abc", Secret: "def"}))
+	defer mi.Close()
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Writing the configuration checks that the provider can be loaded.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     "abc",
+			"client_secret": "def",
+			"provider":      "oidc",
+			"provider_options": map[string]interface{}{
+				"issuer_url": mi.URL,
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Another backend using the same storage, e.g., after a restart, can't
+	// reach the discovery endpoint.
+	mi.InjectFault(testutil.MockIssuerDiscoveryPath, testutil.MockIssuerFault{StatusCode: http.StatusServiceUnavailable})
+	discoveries := mi.Requests(testutil.MockIssuerDiscoveryPath)
+
+	b = backend.New(backend.Options{})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Reading the configuration doesn't need the provider.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "oidc", resp.Data["provider"])
+	assert.Equal(t, discoveries, mi.Requests(testutil.MockIssuerDiscoveryPath))
+
+	// Generating an authorization code URL does.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigAuthCodeURLPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"state": "foo",
+		},
+	}
+
+	_, err = b.HandleRequest(ctx, req)
+	require.Error(t, err)
+	assert.Equal(t, discoveries+1, mi.Requests(testutil.MockIssuerDiscoveryPath))
+
+	// Once the provider is reachable again, it is loaded on the next request
+	// and kept for later ones.
+	mi.ClearFaults()
+
+	for i := 0; i < 2; i++ {
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+		assert.Contains(t, resp.Data["url"], mi.URL)
+	}
+	assert.Equal(t, discoveries+2, mi.Requests(testutil.MockIssuerDiscoveryPath))
+}
+
+func TestConfigChangeDuringExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	started := make(chan struct{})
+	resume := make(chan struct{})
+	exchange := func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		close(started)
+		<-resume
+
+		return testutil.StaticMockAuthCodeExchange(&provider.Token{
+			Token: &oauth2.Token{
+				AccessToken: "valid",
+			},
+		})(code, opts)
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	dir, err := ioutil.TempDir("", "oauthapp-config-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b := backend.New(backend.Options{ProviderRegistry: pr, EventLogDir: dir})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	writeConfig := func(eventLogFile string) {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"client_id":      client.ID,
+				"client_secret":  client.Secret,
+				"provider":       "mock",
+				"event_log_file": eventLogFile,
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	writeConfig("before.log")
+
+	type result struct {
+		resp *logical.Response
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + "test",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"code": "test",
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		ch <- result{resp: resp, err: err}
+	}()
+
+	// Change the configuration while the exchange is in progress.
+	<-started
+	writeConfig("after.log")
+	close(resume)
+
+	r := <-ch
+	require.NoError(t, r.err)
+	require.False(t, r.resp != nil && r.resp.IsError(), "response has error: %+v", r.resp.Error())
+
+	// The exchange finished using the configuration it started with, so the
+	// new credential is recorded in the original event log.
+	events, err := ioutil.ReadFile(filepath.Join(dir, "before.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(events), `"event":"created"`)
+
+	events, err = ioutil.ReadFile(filepath.Join(dir, "after.log"))
+	require.NoError(t, err)
+	assert.Empty(t, string(events))
+
+	// Later requests use the new configuration.
+	req := &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      backend.CredsPathPrefix + "test",
+		Storage:   storage,
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	events, err = ioutil.ReadFile(filepath.Join(dir, "after.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(events), `"event":"revoked"`)
+}
+
 func TestConfigClientCredentials(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -176,5 +576,132 @@ func TestConfigClientCredentials(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, resp)
 	require.True(t, resp.IsError())
-	require.EqualError(t, resp.Error(), "authorization code URL not available")
+	require.EqualError(t, resp.Error(), "[ERR_UNSUPPORTED] authorization code URL not available")
+}
+
+func TestConfigProviderOptionsSchema(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(), provider.WithSchema(provider.OptionSchema{
+		"issuer_url": {
+			Type:     provider.OptionTypeURL,
+			Required: true,
+		},
+	}))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id": "abc",
+			"provider":  "mock",
+			"provider_options": map[string]interface{}{
+				"isuser_url": "https://example.com",
+			},
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.True(t, resp != nil && resp.IsError())
+	assert.Contains(t, resp.Error().Error(), `option "isuser_url": unknown option`)
+	assert.Contains(t, resp.Error().Error(), `option "issuer_url": option is required`)
+}
+
+func TestConfigWarnings(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(), provider.WithTokenLifetime(5*time.Minute))
+
+	tests := []struct {
+		Name     string
+		Data     map[string]interface{}
+		Warnings []string
+	}{
+		{
+			Name: "Defaults",
+		},
+		{
+			Name: "Reap after one transient error",
+			Data: map[string]interface{}{
+				"tune_reap_transient_error_attempts": 0,
+			},
+			Warnings: []string{"single transient error"},
+		},
+		{
+			Name: "Reap after one transient error in dry run",
+			Data: map[string]interface{}{
+				"tune_reap_transient_error_attempts": 0,
+				"tune_reap_dry_run":                  true,
+			},
+		},
+		{
+			Name: "Transient error criterion disabled",
+			Data: map[string]interface{}{
+				"tune_reap_transient_error_attempts": 0,
+				"tune_reap_transient_error_seconds":  0,
+			},
+		},
+		{
+			Name: "Refresh interval longer than token lifetime",
+			Data: map[string]interface{}{
+				"tune_refresh_check_interval_seconds": 600,
+			},
+			Warnings: []string{`The refresh check interval (10m0s) is longer than the lifetime of access tokens usually issued by provider "mock" (5m0s)`},
+		},
+		{
+			Name: "Multiple",
+			Data: map[string]interface{}{
+				"allow_password_grant":                true,
+				"tune_reap_transient_error_attempts":  0,
+				"tune_refresh_check_interval_seconds": 600,
+			},
+			Warnings: []string{"resource owner password credentials grant", "single transient error", "refresh check interval"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			b := backend.New(backend.Options{ProviderRegistry: pr})
+			require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+			data := map[string]interface{}{
+				"client_id": "abc",
+				"provider":  "mock",
+			}
+			for k, v := range test.Data {
+				data[k] = v
+			}
+
+			req := &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      backend.ConfigPath,
+				Storage:   &logical.InmemStorage{},
+				Data:      data,
+			}
+
+			resp, err := b.HandleRequest(ctx, req)
+			require.NoError(t, err)
+			if len(test.Warnings) == 0 {
+				require.Nil(t, resp)
+				return
+			}
+
+			require.NotNil(t, resp)
+			require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+			require.Len(t, resp.Warnings, len(test.Warnings))
+			for i, warning := range test.Warnings {
+				assert.Contains(t, resp.Warnings[i], warning)
+			}
+		})
+	}
 }
@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+// credUsageState classifies a credential for usage accounting.
+func (b *backend) credUsageState(entry *persistence.AuthCodeEntry) string {
+	switch {
+	case entry.Disabled:
+		return "disabled"
+	case entry.UserError != "" || !entry.InactiveTime.IsZero():
+		return "revoked"
+	case !entry.TokenIssued():
+		return "pending"
+	case entry.Expiry.IsZero() || entry.Expiry.After(b.clock.Now()):
+		return "valid"
+	case entry.Refreshable():
+		return "expired_refreshable"
+	default:
+		return "expired"
+	}
+}
+
+func (b *backend) configUsageReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Usage is also useful before the mount is configured, for example after
+	// the configuration has been deleted.
+	pageSize := persistence.DefaultConfigTuningEntry.StorageScanPageSize
+	if c != nil {
+		pageSize = c.Config.Tuning.StorageScanPageSize
+	}
+
+	counts := map[string]int{
+		"valid":               0,
+		"expired_refreshable": 0,
+		"expired":             0,
+		"revoked":             0,
+		"pending":             0,
+		"disabled":            0,
+	}
+	total := 0
+
+	mgrs := b.data.Managers(req.Storage)
+	acm := mgrs.AuthCode()
+
+	err = acm.ForEachAuthCodeKeyPage(ctx, pageSize, func(page []persistence.AuthCodeKeyer) error {
+		for _, keyer := range page {
+			entry, err := acm.ReadAuthCodeEntry(ctx, keyer)
+			if err != nil {
+				return err
+			} else if entry == nil {
+				continue
+			}
+
+			counts[b.credUsageState(entry)]++
+			total++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := mgrs.StorageUsage(ctx, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	rd := map[string]interface{}{
+		"credentials":     total,
+		"storage_entries": usage.Entries,
+		"storage_bytes":   usage.Bytes,
+	}
+	for state, n := range counts {
+		rd[state+"_credentials"] = n
+	}
+
+	return &logical.Response{
+		Data: rd,
+	}, nil
+}
+
+const (
+	ConfigUsagePath = ConfigPathPrefix + "usage"
+)
+
+const configUsageHelpSynopsis = `
+Reports the number of credentials and the storage used by this mount.
+`
+
+const configUsageHelpDescription = `
+This endpoint counts the authorization code credentials stored by this mount
+by state: valid, expired but refreshable, expired, revoked, pending
+authorization, and disabled. It also reports the total number of storage
+entries and their approximate size in bytes, for capacity planning. Every
+storage entry is read to compute the result, so requests to this endpoint may
+be slow for mounts with many credentials.
+`
+
+func pathConfigUsage(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ConfigUsagePath + `$`,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.configUsageReadOperation,
+				Summary:   "Return credential counts and storage usage.",
+				Responses: configUsageResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(configUsageHelpSynopsis),
+		HelpDescription: strings.TrimSpace(configUsageHelpDescription),
+	}
+}
@@ -0,0 +1,109 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	testclock "k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestConfigUsage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	clk := testclock.NewFakeClock(time.Now())
+	expiring := func(refreshToken string) testutil.MockAuthCodeExchangeFunc {
+		return testutil.AmendTokenMockAuthCodeExchange(
+			testutil.RandomMockAuthCodeExchange,
+			func(tok *provider.Token) error {
+				tok.RefreshToken = refreshToken
+				tok.Expiry = clk.Now().Add(10 * time.Minute)
+				return nil
+			},
+		)
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.RestrictMockAuthCodeExchange(map[string]testutil.MockAuthCodeExchangeFunc{
+			"forever":     testutil.RandomMockAuthCodeExchange,
+			"refreshable": expiring("refresh"),
+			"static":      expiring(""),
+		})),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	// Usage can be read before the mount is configured.
+	resp := handle(logical.ReadOperation, backend.ConfigUsagePath, nil)
+	assert.Equal(t, 0, resp.Data["credentials"])
+	assert.Equal(t, 0, resp.Data["storage_entries"])
+	assert.Equal(t, int64(0), resp.Data["storage_bytes"])
+
+	handle(logical.UpdateOperation, backend.ConfigPath, map[string]interface{}{
+		"client_id":     client.ID,
+		"client_secret": client.Secret,
+		"provider":      "mock",
+	})
+
+	for name, code := range map[string]string{
+		"forever":     "forever",
+		"disabled":    "forever",
+		"refreshable": "refreshable",
+		"static":      "static",
+	} {
+		handle(logical.UpdateOperation, backend.CredsPathPrefix+name, map[string]interface{}{
+			"code": code,
+		})
+	}
+
+	handle(logical.UpdateOperation, backend.DisableCredsPathPrefix+"disabled", nil)
+
+	// Expire the tokens without giving the refresher a chance to run.
+	clk.Step(20 * time.Minute)
+
+	resp = handle(logical.ReadOperation, backend.ConfigUsagePath, nil)
+	assert.Equal(t, 4, resp.Data["credentials"])
+	assert.Equal(t, 1, resp.Data["valid_credentials"])
+	assert.Equal(t, 1, resp.Data["expired_refreshable_credentials"])
+	assert.Equal(t, 1, resp.Data["expired_credentials"])
+	assert.Equal(t, 0, resp.Data["revoked_credentials"])
+	assert.Equal(t, 0, resp.Data["pending_credentials"])
+	assert.Equal(t, 1, resp.Data["disabled_credentials"])
+
+	// The configuration and credentials are stored, along with any state the
+	// plugin keeps internally.
+	assert.GreaterOrEqual(t, resp.Data["storage_entries"], 5)
+	assert.Greater(t, resp.Data["storage_bytes"], int64(0))
+}
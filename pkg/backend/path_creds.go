@@ -6,14 +6,15 @@ package backend
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
-	"github.com/puppetlabs/leg/errmap/pkg/errmap"
-	"github.com/puppetlabs/leg/errmap/pkg/errmark"
 	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/devicecode"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
@@ -21,6 +22,25 @@ import (
 	"golang.org/x/oauth2"
 )
 
+const (
+	// SAML2BearerGrantType is the grant type for exchanging a SAML 2.0
+	// assertion for an access token, as described in RFC 7522.
+	SAML2BearerGrantType = "urn:ietf:params:oauth:grant-type:saml2-bearer"
+
+	// PasswordGrantType is the resource owner password credentials grant type.
+	PasswordGrantType = "password"
+
+	// StaticGrantType stores an access token obtained outside of this plugin,
+	// such as a personal access token, without contacting the provider.
+	StaticGrantType = "static"
+
+	// ClientCredentialsGrantType is the grant type used by the config/self
+	// endpoint to issue tokens to the client itself.
+	ClientCredentialsGrantType = "client_credentials"
+)
+
+const passwordGrantWarning = `The resource owner password credentials grant exposes user passwords to this plugin and is deprecated by the OAuth 2.0 Security Best Current Practice. Use it only with identity providers that support no other flow.`
+
 // credGrantType returns the grant type to be used for a given update operation.
 func credGrantType(data *framework.FieldData) string {
 	if v, ok := data.GetOk("grant_type"); ok {
@@ -38,6 +58,10 @@ var credUpdateGrantHandlers = map[string]func(b *backend) framework.OperationFun
 	"authorization_code": func(b *backend) framework.OperationFunc { return b.credsUpdateAuthorizationCodeOperation },
 	"refresh_token":      func(b *backend) framework.OperationFunc { return b.credsUpdateRefreshTokenOperation },
 	devicecode.GrantType: func(b *backend) framework.OperationFunc { return b.credsUpdateDeviceCodeOperation },
+	SAML2BearerGrantType: func(b *backend) framework.OperationFunc { return b.credsUpdateSAML2BearerOperation },
+	JWTBearerGrantType:   func(b *backend) framework.OperationFunc { return b.credsUpdateJWTBearerOperation },
+	PasswordGrantType:    func(b *backend) framework.OperationFunc { return b.credsUpdatePasswordOperation },
+	StaticGrantType:      func(b *backend) framework.OperationFunc { return b.credsUpdateStaticOperation },
 }
 
 // credGrantTypes returns the list of supported grant types for credentials for
@@ -49,57 +73,459 @@ func credGrantTypes() (types []interface{}) {
 	return
 }
 
+// addCredStatus adds diagnostic information about the refresh state of a
+// credential to a response.
+func (b *backend) addCredStatus(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer, entry *persistence.AuthCodeEntry, rd map[string]interface{}) error {
+	now := b.clock.Now()
+
+	rd["expired"] = !entry.Expiry.IsZero() && !entry.Expiry.After(now)
+	rd["refresh_attempts"] = entry.TransientErrorsSinceLastIssue
+
+	if !entry.LastIssueTime.IsZero() {
+		rd["last_refresh_time"] = entry.LastIssueTime
+	}
+
+	if entry.UserError != "" {
+		rd["last_refresh_error"] = entry.UserError
+	} else if entry.LastTransientError != "" {
+		rd["last_refresh_error"] = entry.LastTransientError
+	}
+
+	if entry.LastProviderResponseCode != 0 {
+		rd["provider_response_code"] = entry.LastProviderResponseCode
+	}
+
+	if t := entry.RefreshTokenExpiry(); !t.IsZero() {
+		rd["refresh_token_expire_time"] = t
+		rd["reauthorize_time"] = entry.ReauthorizeTime()
+	}
+
+	if !entry.LastRefreshCheckTime.IsZero() {
+		rd["last_refresh_check_time"] = entry.LastRefreshCheckTime
+	}
+
+	if entry.ScopesDowngraded() {
+		rd["revoked_scopes"] = entry.RevokedScopes
+		rd["scope_downgrade_time"] = entry.ScopeDowngradeTime
+	}
+
+	if !entry.InactiveTime.IsZero() {
+		rd["inactive_time"] = entry.InactiveTime
+	}
+
+	addCredTuning(entry.Tuning, rd)
+
+	// Tokens that can't be refreshed or that have already failed permanently
+	// will not be picked up by the automatic refresher.
+	if entry.Expiry.IsZero() || !entry.Refreshable() || entry.UserError != "" {
+		return nil
+	}
+
+	c, err := b.getCache(ctx, storage)
+	if err != nil {
+		return err
+	} else if c == nil || c.Config.Tuning.RefreshCheckIntervalSeconds <= 0 {
+		return nil
+	}
+
+	// Prefer the time the refresher actually has scheduled. It is not known
+	// on nodes that do not run the refresher.
+	next, ok := b.refreshSchedule.Lookup(keyer)
+	if !ok {
+		next = entry.Expiry.Add(-refreshExpiryDelta(entry.Tuning.Apply(c.Config.Tuning)))
+	}
+	if next.Before(now) {
+		next = now
+	}
+
+	rd["next_scheduled_refresh"] = next
+	return nil
+}
+
+// credsListSorts are the orders in which the credentials LIST operation can
+// return credentials. Credentials that compare equal are ordered by name.
+var credsListSorts = map[string]func(a, b *persistence.AuthCodeEntry) bool{
+	"name": func(a, b *persistence.AuthCodeEntry) bool { return false },
+	// Credentials that expire soonest come first. Credentials that never
+	// expire or have not been issued a token come last.
+	"expire_time": func(a, b *persistence.AuthCodeEntry) bool {
+		ae, be := credExpiry(a), credExpiry(b)
+		switch {
+		case ae.IsZero() || be.IsZero():
+			return !ae.IsZero() && be.IsZero()
+		default:
+			return ae.Before(be)
+		}
+	},
+	// Credentials that failed to refresh most recently come first.
+	// Credentials without a refresh error come last.
+	"last_refresh_error": func(a, b *persistence.AuthCodeEntry) bool {
+		ae, be := credLastRefreshError(a) != "", credLastRefreshError(b) != ""
+		switch {
+		case ae != be:
+			return ae
+		default:
+			return ae && a.LastAttemptedIssueTime.After(b.LastAttemptedIssueTime)
+		}
+	},
+}
+
+func credExpiry(entry *persistence.AuthCodeEntry) time.Time {
+	if entry.Token == nil {
+		return time.Time{}
+	}
+	return entry.Expiry
+}
+
+// credLastRefreshError returns the error reported by the most recent failed
+// attempt to issue or refresh the token of a credential, if any.
+func credLastRefreshError(entry *persistence.AuthCodeEntry) string {
+	if entry.UserError != "" {
+		return entry.UserError
+	}
+	return entry.LastTransientError
+}
+
+func credsListData(entry *persistence.AuthCodeEntry, now time.Time) map[string]interface{} {
+	expiry := credExpiry(entry)
+
+	rd := map[string]interface{}{
+		"expired":     !expiry.IsZero() && !expiry.After(now),
+		"refreshable": entry.Refreshable(),
+	}
+
+	if !expiry.IsZero() {
+		rd["expire_time"] = expiry
+	}
+
+	if msg := credLastRefreshError(entry); msg != "" {
+		rd["last_refresh_error"] = msg
+	}
+
+	if len(entry.Metadata) > 0 {
+		rd["metadata"] = entry.Metadata
+	}
+
+	if !entry.InactiveTime.IsZero() {
+		rd["inactive_time"] = entry.InactiveTime
+	}
+
+	return rd
+}
+
+func (b *backend) credsListOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	sortName := data.Get("sort").(string)
+	less, found := credsListSorts[sortName]
+	if !found {
+		return errorResponse(ErrorCodeInvalidRequest, "unknown sort order %q", sortName), nil
+	}
+
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := data.Get("access_token_issuer").(string)
+	audience := data.Get("access_token_audience").(string)
+	scope := data.Get("access_token_scope").(string)
+	filterClaims := issuer != "" || audience != "" || scope != ""
+	if filterClaims && (c == nil || !c.Config.DecodeJWTAccessTokens) {
+		return errorResponse(ErrorCodeUnsupported, "decoding JWT access tokens is not enabled in the configuration"), nil
+	}
+
+	tuning := persistence.DefaultConfigTuningEntry
+	if c != nil {
+		tuning = c.Config.Tuning
+	}
+
+	now := b.clock.Now()
+	filter := data.Get("metadata").(map[string]string)
+	expired, filterExpired := data.GetOk("expired")
+	refreshable, filterRefreshable := data.GetOk("refreshable")
+
+	// Credential names are only recorded in their entries, so every entry is
+	// read to list it, but entries are only kept around when they are needed
+	// to sort by something other than the name.
+	type item struct {
+		name  string
+		entry *persistence.AuthCodeEntry
+	}
+
+	var items []item
+	keyInfo := make(map[string]interface{})
+
+	acm := b.data.Managers(req.Storage).AuthCode()
+	pacer := newScanPacer(b.clock, tuning)
+	err = acm.ForEachAuthCodeKeyPage(ctx, tuning.StorageScanPageSize, func(page []persistence.AuthCodeKeyer) error {
+		if err := pacer.Wait(ctx); err != nil {
+			return err
+		}
+
+		for _, keyer := range page {
+			entry, err := acm.ReadAuthCodeEntry(ctx, keyer)
+			if err != nil {
+				return err
+			} else if entry == nil || !entry.MatchesMetadata(filter) {
+				continue
+			}
+
+			info := credsListData(entry, now)
+			if filterExpired && info["expired"] != expired {
+				continue
+			} else if filterRefreshable && info["refreshable"] != refreshable {
+				continue
+			}
+
+			if c != nil && entry.Token != nil {
+				claims, ok := c.JWTAccessTokenClaims(ctx, entry.AccessToken)
+				if filterClaims && (!ok || !claims.matches(issuer, audience, scope)) {
+					continue
+				} else if ok {
+					info["access_token_claims"] = claims.responseData()
+				}
+			} else if filterClaims {
+				continue
+			}
+
+			// Credentials written before names were recorded are listed by
+			// their storage key.
+			name := entry.Name
+			if name == "" {
+				name = strings.TrimPrefix(keyer.AuthCodeKey(), CredsPathPrefix)
+			}
+
+			it := item{name: name}
+			if sortName != "name" {
+				it.entry = entry
+			}
+
+			items = append(items, it)
+			keyInfo[name] = info
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].name < items[j].name
+	})
+	if sortName != "name" {
+		sort.SliceStable(items, func(i, j int) bool {
+			return less(items[i].entry, items[j].entry)
+		})
+	}
+
+	keys := make([]string, len(items))
+	for i, it := range items {
+		keys[i] = it.name
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
 func (b *backend) credsReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	providerOptions := data.Get("provider_options").(map[string]string)
+	if len(providerOptions) > 0 {
+		if _, ok := data.GetOk("version"); ok {
+			return errorResponse(ErrorCodeInvalidRequest, "cannot use provider_options with version"), nil
+		}
+
+		if resp, err := b.readProviderOptionsResponse(ctx, req.Storage, providerOptions); err != nil || resp != nil {
+			return resp, err
+		}
+	}
+
 	expiryDelta := time.Duration(data.Get("minimum_seconds").(int)) * time.Second
 
-	entry, err := b.getRefreshCredToken(
-		ctx,
-		req.Storage,
-		persistence.AuthCodeName(data.Get("name").(string)),
-		expiryDelta,
-	)
+	leeway, err := b.expiryLeeway(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// Previous versions are returned as stored, but are otherwise subject to
+	// the same checks as the current version. Requests for the current version
+	// are handled like any other read.
+	var entry *persistence.AuthCodeEntry
+	var prev *persistence.AuthCodeVersionEntry
+	version, versioned := data.GetOk("version")
+	if versioned {
+		entry, err = b.data.Managers(req.Storage).AuthCode().ReadAuthCodeEntry(ctx, persistence.AuthCodeName(data.Get("name").(string)))
+		if err != nil {
+			return nil, err
+		} else if entry != nil && entry.Version != version.(int) {
+			var found bool
+			if prev, found = entry.PreviousVersion(version.(int)); !found && !entry.Disabled {
+				return errorResponse(ErrorCodeNotFound, "version %d not found", version.(int)), nil
+			}
+		}
+	}
+
+	if !versioned || (entry != nil && entry.Version == version.(int)) {
+		entry, err = b.getRefreshCredToken(
+			ctx,
+			req.Storage,
+			persistence.AuthCodeName(data.Get("name").(string)),
+			expiryDelta,
+		)
+	}
 	switch {
 	case err == ErrNotConfigured:
-		return logical.ErrorResponse("not configured"), nil
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	case err == ErrMaintenanceMode:
+		return errorResponse(ErrorCodeMaintenance, "token expired and requests to the provider are paused for maintenance"), nil
+	case errors.Is(err, ErrScopeNotAllowed):
+		return errorResponse(ErrorCodeScopeNotAllowed, "token expired and %s", err), nil
 	case err != nil:
 		return nil, err
 	case entry == nil:
 		return nil, nil
+	case entry.Disabled:
+		return errorResponse(ErrorCodeDisabled, "credential is disabled"), nil
+	case prev != nil:
+		if !b.tokenValid(prev.Token, expiryDelta, leeway) {
+			return errorResponse(ErrorCodeTokenExpired, "token expired"), nil
+		}
 	case !entry.TokenIssued():
-		if entry.UserError != "" {
-			return logical.ErrorResponse(entry.UserError), nil
+		// Report the progress of an exchange running in the background.
+		exchange, err := b.data.Managers(req.Storage).AuthCode().ReadAuthCodeExchangeEntry(ctx, persistence.AuthCodeName(data.Get("name").(string)))
+		if err != nil {
+			return nil, err
+		} else if exchange != nil {
+			rd := map[string]interface{}{
+				"status":      "pending",
+				"submit_time": exchange.SubmitTime,
+			}
+			if exchange.Failed() {
+				rd["status"] = "failed"
+				rd["fail_time"] = exchange.FailTime
+				rd["error"] = entry.UserError
+			}
+
+			return &logical.Response{Data: rd}, nil
 		}
 
-		return logical.ErrorResponse("token pending issuance"), nil
-	case !b.tokenValid(entry.Token, expiryDelta):
 		if entry.UserError != "" {
-			return logical.ErrorResponse(entry.UserError), nil
+			return errorResponse(ErrorCodeProviderRejected, entry.UserError), nil
+		}
+
+		return errorResponse(ErrorCodeTokenPending, "token pending issuance"), nil
+	case !b.credTokenValid(entry, expiryDelta, leeway):
+		if entry.ReauthorizationRequired {
+			return errorResponse(ErrorCodeRefreshRevoked, "credential must be reauthorized: %s", entry.UserError), nil
+		} else if entry.UserError != "" {
+			return errorResponse(ErrorCodeProviderRejected, entry.UserError), nil
+		} else if !entry.InactiveTime.IsZero() {
+			return errorResponse(ErrorCodeTokenExpired, "token is no longer active"), nil
 		}
 
-		return logical.ErrorResponse("token expired"), nil
+		return errorResponse(ErrorCodeTokenExpired, "token expired"), nil
+	}
+
+	// Tokens issued with provider options given by the caller are only
+	// returned from this read.
+	tok, tokVersion := entry.Token, entry.Version
+	if prev != nil {
+		tok, tokVersion = prev.Token, prev.Version
+	} else if len(providerOptions) > 0 {
+		if !entry.Refreshable() || entry.JWTBearer != nil {
+			return errorResponse(ErrorCodeInvalidRequest, "provider options can only be given when reading a credential that has a refresh token"), nil
+		}
+
+		tok, err = b.issueCredTokenWithOptions(ctx, req.Storage, persistence.AuthCodeName(data.Get("name").(string)), providerOptions)
+		switch {
+		case err == ErrNotConfigured:
+			return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+		case err == ErrMaintenanceMode:
+			return errorResponse(ErrorCodeMaintenance, "requests to the provider are paused for maintenance"), nil
+		case err != nil:
+			if resp := providerErrorResponse(err, "token request failed"); resp != nil {
+				return resp, nil
+			}
+			return nil, err
+		case tok == nil:
+			return nil, nil
+		}
 	}
 
 	rd := map[string]interface{}{
-		"access_token": entry.AccessToken,
-		"type":         entry.Type(),
+		"access_token": tok.AccessToken,
+		"type":         tok.Type(),
+		"version":      tokVersion,
+		"status":       "ready",
 	}
 
-	if !entry.Expiry.IsZero() {
-		rd["expire_time"] = entry.Expiry
+	if !tok.Expiry.IsZero() {
+		rd["expire_time"] = tok.Expiry
 	}
 
-	if len(entry.ExtraData) > 0 {
-		rd["extra_data"] = entry.ExtraData
+	if prev != nil {
+		rd["superseded_time"] = prev.SupersededTime
 	}
 
-	if len(entry.ProviderOptions) > 0 {
-		rd["provider_options"] = entry.ProviderOptions
+	if len(tok.ExtraData) > 0 {
+		rd["extra_data"] = tok.ExtraData
 	}
 
-	resp := &logical.Response{
-		Data: rd,
+	if len(tok.ProviderOptions) > 0 {
+		rd["provider_options"] = tok.ProviderOptions
 	}
-	if entry.UserError != "" {
+
+	if c, err := b.getCache(ctx, req.Storage); err != nil {
+		return nil, err
+	} else if c != nil {
+		if claims, ok := c.JWTAccessTokenClaims(ctx, tok.AccessToken); ok {
+			rd["access_token_claims"] = claims.responseData()
+		}
+	}
+
+	if len(entry.Metadata) > 0 {
+		rd["metadata"] = entry.Metadata
+	}
+
+	if len(entry.Resources) > 0 {
+		rd["resources"] = entry.Resources
+	}
+
+	if entry.Template != "" {
+		rd["template"] = entry.Template
+	}
+
+	if entry.BoundEntityID != "" {
+		rd["bound_entity_id"] = entry.BoundEntityID
+	}
+	if entry.BoundTokenAccessor != "" {
+		rd["bound_token_accessor"] = entry.BoundTokenAccessor
+	}
+
+	// The refresh status and warnings describe the current version.
+	if prev == nil {
+		if err := b.addCredStatus(ctx, req.Storage, persistence.AuthCodeName(data.Get("name").(string)), entry, rd); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := b.addTokenFingerprint(ctx, req.Storage, rd); err != nil {
+		return nil, err
+	}
+
+	if err := b.redactTokens(ctx, req.Storage, data, rd); err != nil {
+		return nil, err
+	}
+
+	resp, err := b.leaseResponse(ctx, req.Storage, data.Get("name").(string), tok, rd)
+	if err != nil {
+		return nil, err
+	} else if prev != nil {
+		return resp, nil
+	}
+
+	if entry.ReauthorizationRequired {
+		resp.Warnings = []string{
+			fmt.Sprintf("token will expire and the credential must be reauthorized: %s", entry.UserError),
+		}
+	} else if entry.UserError != "" {
 		resp.Warnings = []string{
 			fmt.Sprintf("token will expire: %s", entry.UserError),
 		}
@@ -111,94 +537,539 @@ func (b *backend) credsReadOperation(ctx context.Context, req *logical.Request,
 				entry.LastTransientError,
 			),
 		}
+	} else if t := entry.ReauthorizeTime(); !t.IsZero() && !b.clock.Now().Before(t) {
+		resp.Warnings = []string{
+			fmt.Sprintf("refresh token expires at %s and the credential must be reauthorized", entry.RefreshTokenExpiry().Format(time.RFC3339)),
+		}
+	}
+	if entry.ScopesDowngraded() {
+		resp.AddWarning(fmt.Sprintf("the provider no longer grants the following originally granted scope(s): %s", strings.Join(entry.RevokedScopes, ", ")))
 	}
 	return resp, nil
 }
 
 func (b *backend) credsUpdateAuthorizationCodeOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	c, err := b.getCache(ctx, req.Storage)
+	code, ok := data.GetOk("code")
+	if !ok {
+		return errorResponse(ErrorCodeInvalidRequest, "missing code"), nil
+	}
+	if _, ok := data.GetOk("refresh_token"); ok {
+		return errorResponse(ErrorCodeInvalidRequest, "cannot use refresh_token with authorization_code grant type"), nil
+	}
+
+	name := data.Get("name").(string)
+	keyer := persistence.AuthCodeName(name)
+	redirectURL := data.Get("redirect_url").(string)
+	resources := data.Get("resources").([]string)
+	providerOptions := data.Get("provider_options").(map[string]string)
+	templateName := data.Get("template").(string)
+
+	if state, ok := data.GetOk("state"); ok {
+		entry, err := b.consumeState(ctx, req.Storage, state.(string), name)
+		if err != nil {
+			return nil, err
+		} else if entry == nil {
+			return errorResponse(ErrorCodeInvalidState, "unknown or expired state"), nil
+		}
+
+		if _, ok := data.GetOk("redirect_url"); !ok {
+			redirectURL = entry.RedirectURL
+		}
+		if _, ok := data.GetOk("provider_options"); !ok {
+			providerOptions = entry.ProviderOptions
+		} else if nonce, ok := entry.ProviderOptions[provider.NonceProviderOption]; ok {
+			// The nonce was sent to the provider, so it must always be
+			// verified.
+			providerOptions[provider.NonceProviderOption] = nonce
+		}
+		if _, ok := data.GetOk("resources"); !ok {
+			resources = entry.Resources
+		}
+		if _, ok := data.GetOk("template"); !ok {
+			templateName = entry.Template
+		}
+	} else {
+		pse, err := b.data.Managers(req.Storage).AuthCode().ReadPendingStateEntry(ctx, keyer)
+		if err != nil {
+			return nil, err
+		} else if pse != nil && !pse.Expired(b.clock.Now()) {
+			return errorResponse(ErrorCodeInvalidRequest, "missing state (a state was generated for this credential)"), nil
+		}
+	}
+
+	tmpl, resp, err := b.readCredTemplate(ctx, req.Storage, templateName)
+	if err != nil || resp != nil {
+		return resp, err
+	}
+	if tmpl != nil {
+		if _, ok := data.GetOk("redirect_url"); !ok && redirectURL == "" {
+			redirectURL = tmpl.RedirectURL
+		}
+		if _, ok := data.GetOk("provider_options"); !ok && len(providerOptions) == 0 {
+			providerOptions = tmpl.ProviderOptions
+		}
+	}
+
+	if resp, err := b.redirectURLResponse(ctx, req.Storage, redirectURL); err != nil || resp != nil {
+		return resp, err
+	}
+
+	if data.Get("async").(bool) {
+		return b.submitAuthCodeExchange(ctx, req.Storage, keyer, tmpl, &persistence.AuthCodeExchangeEntry{
+			Code:            code.(string),
+			RedirectURL:     redirectURL,
+			Resources:       resources,
+			ProviderOptions: providerOptions,
+			SubmitTime:      b.clock.Now(),
+		})
+	}
+
+	return b.authCodeExchange(
+		ctx,
+		req.Storage,
+		keyer,
+		tmpl,
+		code.(string),
+		provider.WithRedirectURL(redirectURL),
+		provider.WithResources(resources),
+		provider.WithProviderOptions(providerOptions),
+	)
+}
+
+// submitAuthCodeExchange replaces the given credential with one that will be
+// issued a token when the authorization code is exchanged in the background.
+func (b *backend) submitAuthCodeExchange(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer, tmpl *persistence.CredTemplateEntry, exchange *persistence.AuthCodeExchangeEntry) (*logical.Response, error) {
+	c, err := b.getCache(ctx, storage)
 	if err != nil {
 		return nil, err
 	} else if c == nil {
-		return logical.ErrorResponse("not configured"), nil
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
 	} else if c.Config.ClientSecret == "" {
-		return logical.ErrorResponse("missing client secret in configuration"), nil
+		return errorResponse(ErrorCodeNotConfigured, "missing client secret in configuration"), nil
 	}
 
-	ops := c.ProviderWithTimeout(defaultExpiryDelta).Private(c.Config.ClientID, c.Config.ClientSecret)
+	err = b.data.Managers(storage).AuthCode().WithLock(keyer, func(acm *persistence.LockedAuthCodeManager) error {
+		prev, err := acm.ReadAuthCodeEntry(ctx)
+		if err != nil {
+			return err
+		}
+
+		entry := &persistence.AuthCodeEntry{Resources: exchange.Resources}
+		entry.ApplyTemplate(tmpl)
+		entry.Supersede(prev, c.Config.Tuning.MaxCredentialVersions, b.clock.Now())
+		applyCredUpdate(ctx, prev, entry)
 
-	code, ok := data.GetOk("code")
+		// As with the device code flow, the exchange is written first so
+		// that the credential is never left without a pending exchange.
+		if err := acm.WriteAuthCodeExchangeEntry(ctx, exchange); err != nil {
+			return err
+		}
+
+		if err := acm.WriteAuthCodeEntry(ctx, entry); err != nil {
+			return err
+		}
+
+		return acm.DeletePendingStateEntry(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"status":      "pending",
+			"submit_time": exchange.SubmitTime,
+		},
+	}
+	return resp, nil
+}
+
+// authCodeExchange exchanges an authorization code for a token and stores it
+// in the given credential, instantiating it from the given template if not
+// nil.
+func (b *backend) authCodeExchange(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer, tmpl *persistence.CredTemplateEntry, code string, opts ...provider.AuthCodeExchangeOption) (*logical.Response, error) {
+	c, err := b.getCache(ctx, storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	} else if c.Config.ClientSecret == "" {
+		return errorResponse(ErrorCodeNotConfigured, "missing client secret in configuration"), nil
+	}
+
+	p, err := c.ProviderWithTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := p.Private(c.Config.ClientID, c.Config.ClientSecret)
+
+	tok, err := ops.AuthCodeExchange(clockctx.WithClock(ctx, b.clock), code, opts...)
+	if resp := providerErrorResponse(err, "exchange failed"); resp != nil {
+		return resp, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The resources requested in the exchange are requested again when the
+	// token is refreshed.
+	o := &provider.AuthCodeExchangeOptions{}
+	o.ApplyOptions(opts)
+
+	entry := &persistence.AuthCodeEntry{Resources: o.Resources}
+	entry.SetToken(tok, b.clock.Now())
+	entry.ApplyTemplate(tmpl)
+
+	if err := b.replaceAuthCodeEntry(ctx, storage, c, keyer, entry, credHistoryEventExchanged); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) credsUpdateRefreshTokenOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	}
+
+	p, err := c.ProviderWithTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := p.Private(c.Config.ClientID, c.Config.ClientSecret)
+
+	refreshToken, ok := data.GetOk("refresh_token")
 	if !ok {
-		return logical.ErrorResponse("missing code"), nil
+		return errorResponse(ErrorCodeInvalidRequest, "missing refresh_token"), nil
 	}
-	if _, ok := data.GetOk("refresh_token"); ok {
-		return logical.ErrorResponse("cannot use refresh_token with authorization_code grant type"), nil
+	if _, ok := data.GetOk("code"); ok {
+		return errorResponse(ErrorCodeInvalidRequest, "cannot use code with refresh_token grant type"), nil
+	}
+
+	resources := data.Get("resources").([]string)
+
+	tok := &provider.Token{
+		Token: &oauth2.Token{
+			RefreshToken: refreshToken.(string),
+		},
 	}
 
-	tok, err := ops.AuthCodeExchange(
+	// When importing many refresh tokens at once, the first refresh can wait
+	// until the credential is read so that we don't flood the provider.
+	if data.Get("defer_refresh").(bool) {
+		if po := data.Get("provider_options").(map[string]string); len(po) > 0 {
+			tok.ProviderOptions = po
+		}
+
+		entry := &persistence.AuthCodeEntry{Resources: resources}
+		entry.SetToken(tok, b.clock.Now())
+
+		if err := b.replaceAuthCodeEntry(ctx, req.Storage, c, persistence.AuthCodeName(data.Get("name").(string)), entry, ""); err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
+	tok, err = ops.RefreshToken(
 		clockctx.WithClock(ctx, b.clock),
-		code.(string),
-		provider.WithRedirectURL(data.Get("redirect_url").(string)),
+		tok,
+		provider.WithResources(resources),
 		provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
 	)
-	if errmark.MarkedUser(err) {
-		return logical.ErrorResponse(errmap.Wrap(errmark.MarkShort(err), "exchange failed").Error()), nil
+	if resp := providerErrorResponse(err, "refresh failed"); resp != nil {
+		return resp, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	entry := &persistence.AuthCodeEntry{Resources: resources}
+	entry.SetToken(tok, b.clock.Now())
+
+	if err := b.replaceAuthCodeEntry(ctx, req.Storage, c, persistence.AuthCodeName(data.Get("name").(string)), entry, credHistoryEventRefreshed); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) credsUpdateSAML2BearerOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	}
+
+	assertion, ok := data.GetOk("assertion")
+	if !ok {
+		return errorResponse(ErrorCodeInvalidRequest, "missing assertion"), nil
+	}
+	if _, ok := data.GetOk("code"); ok {
+		return errorResponse(ErrorCodeInvalidRequest, "cannot use code with %s grant type", SAML2BearerGrantType), nil
+	}
+
+	p, err := c.ProviderWithTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := p.Private(c.Config.ClientID, c.Config.ClientSecret)
+
+	// The provider client credentials flow allows us to override the grant
+	// type, and otherwise makes the same request we need.
+	tok, err := ops.ClientCredentials(
+		clockctx.WithClock(ctx, b.clock),
+		provider.WithURLParams{
+			"grant_type": SAML2BearerGrantType,
+			"assertion":  encodeSAML2Assertion(assertion.(string)),
+		},
+		provider.WithScopes(data.Get("scopes").([]string)),
+		provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
+	)
+	if resp := providerErrorResponse(err, "exchange failed"); resp != nil {
+		return resp, nil
 	} else if err != nil {
 		return nil, err
 	}
 
 	entry := &persistence.AuthCodeEntry{}
-	entry.SetToken(tok)
+	entry.SetToken(tok, b.clock.Now())
 
-	if err := b.data.Managers(req.Storage).AuthCode().WriteAuthCodeEntry(ctx, persistence.AuthCodeName(data.Get("name").(string)), entry); err != nil {
+	if err := b.replaceAuthCodeEntry(ctx, req.Storage, c, persistence.AuthCodeName(data.Get("name").(string)), entry, credHistoryEventExchanged); err != nil {
 		return nil, err
 	}
 
 	return nil, nil
 }
 
-func (b *backend) credsUpdateRefreshTokenOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+// encodeSAML2Assertion returns the given assertion in the base64url encoding
+// required by RFC 7522. Assertions that are already encoded are returned
+// unchanged.
+func encodeSAML2Assertion(assertion string) string {
+	assertion = strings.TrimSpace(assertion)
+	if !strings.HasPrefix(assertion, "<") {
+		return assertion
+	}
+
+	return base64.RawURLEncoding.EncodeToString([]byte(assertion))
+}
+
+func (b *backend) credsUpdatePasswordOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	} else if !c.Config.AllowPasswordGrant {
+		return errorResponse(ErrorCodeUnsupported, "the %s grant type is not enabled in the configuration", PasswordGrantType), nil
+	}
+
+	username, ok := data.GetOk("username")
+	if !ok {
+		return errorResponse(ErrorCodeInvalidRequest, "missing username"), nil
+	}
+	password, ok := data.GetOk("password")
+	if !ok {
+		return errorResponse(ErrorCodeInvalidRequest, "missing password"), nil
+	}
+
+	p, err := c.ProviderWithTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := p.Private(c.Config.ClientID, c.Config.ClientSecret)
+
+	// The username and password are only sent to the provider. Only the
+	// resulting token is stored.
+	tok, err := ops.ClientCredentials(
+		clockctx.WithClock(ctx, b.clock),
+		provider.WithURLParams{
+			"grant_type": PasswordGrantType,
+			"username":   username.(string),
+			"password":   password.(string),
+		},
+		provider.WithScopes(data.Get("scopes").([]string)),
+		provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
+	)
+	if resp := providerErrorResponse(err, "exchange failed"); resp != nil {
+		return resp, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	entry := &persistence.AuthCodeEntry{}
+	entry.SetToken(tok, b.clock.Now())
+
+	if err := b.replaceAuthCodeEntry(ctx, req.Storage, c, persistence.AuthCodeName(data.Get("name").(string)), entry, credHistoryEventExchanged); err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{}
+	resp.AddWarning(passwordGrantWarning)
+	return resp, nil
+}
+
+func (b *backend) credsUpdateStaticOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	}
+
+	accessToken, ok := data.GetOk("access_token")
+	if !ok || accessToken.(string) == "" {
+		return errorResponse(ErrorCodeInvalidRequest, "missing access_token"), nil
+	}
+	for _, field := range []string{"code", "refresh_token", "device_code"} {
+		if _, ok := data.GetOk(field); ok {
+			return errorResponse(ErrorCodeInvalidRequest, "cannot use %s with %s grant type", field, StaticGrantType), nil
+		}
+	}
+
+	// The token is never refreshed, so it is reaped like any other
+	// non-refreshable token once it expires.
+	tok := &provider.Token{
+		Token: &oauth2.Token{
+			AccessToken: accessToken.(string),
+			TokenType:   data.Get("token_type").(string),
+		},
+	}
+	if expireTime, ok := data.GetOk("expire_time"); ok {
+		tok.Expiry = expireTime.(time.Time)
+		if !tok.Expiry.After(b.clock.Now()) {
+			return errorResponse(ErrorCodeInvalidRequest, "expire_time must be in the future"), nil
+		}
+	}
+
+	entry := &persistence.AuthCodeEntry{}
+	entry.SetToken(tok, b.clock.Now())
+
+	if err := b.replaceAuthCodeEntry(ctx, req.Storage, c, persistence.AuthCodeName(data.Get("name").(string)), entry, ""); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) credsUpdateJWTBearerOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	c, err := b.getCache(ctx, req.Storage)
 	if err != nil {
 		return nil, err
 	} else if c == nil {
-		return logical.ErrorResponse("not configured"), nil
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
 	}
 
-	ops := c.ProviderWithTimeout(defaultExpiryDelta).Private(c.Config.ClientID, c.Config.ClientSecret)
+	if _, ok := data.GetOk("code"); ok {
+		return errorResponse(ErrorCodeInvalidRequest, "cannot use code with %s grant type", JWTBearerGrantType), nil
+	}
+
+	var resp *logical.Response
+	err = b.data.Managers(req.Storage).AuthCode().WithLock(persistence.AuthCodeName(data.Get("name").(string)), func(acm *persistence.LockedAuthCodeManager) error {
+		prev, err := acm.ReadAuthCodeEntry(ctx)
+		if err != nil {
+			return err
+		}
+
+		// Any fields not specified are retained from the existing
+		// configuration so that, e.g., the signing key can be rotated
+		// without repeating the claim template.
+		cfg := &persistence.JWTBearerEntry{}
+		if prev != nil && prev.JWTBearer != nil {
+			*cfg = *prev.JWTBearer
+		}
+
+		if v, ok := data.GetOk("signing_key"); ok {
+			cfg.SigningKey = v.(string)
+
+			// A new key invalidates the previous key ID and algorithm.
+			cfg.SigningKeyID = ""
+			cfg.SigningAlgorithm = ""
+		}
+		if v, ok := data.GetOk("signing_key_id"); ok {
+			cfg.SigningKeyID = v.(string)
+		}
+		if v, ok := data.GetOk("signing_algorithm"); ok {
+			cfg.SigningAlgorithm = v.(string)
+		}
+		if v, ok := data.GetOk("issuer"); ok {
+			cfg.Issuer = v.(string)
+		}
+		if v, ok := data.GetOk("subject"); ok {
+			cfg.Subject = v.(string)
+		}
+		if v, ok := data.GetOk("audience"); ok {
+			cfg.Audience = v.([]string)
+		}
+		if v, ok := data.GetOk("claims"); ok {
+			cfg.Claims = v.(map[string]interface{})
+		}
+		if v, ok := data.GetOk("scopes"); ok {
+			cfg.Scopes = v.([]string)
+		}
+		if v, ok := data.GetOk("provider_options"); ok {
+			cfg.ProviderOptions = v.(map[string]string)
+		}
+
+		switch {
+		case cfg.SigningKey == "":
+			resp = errorResponse(ErrorCodeInvalidRequest, "missing signing_key")
+			return nil
+		case cfg.Issuer == "":
+			resp = errorResponse(ErrorCodeInvalidRequest, "missing issuer")
+			return nil
+		case cfg.Subject == "":
+			resp = errorResponse(ErrorCodeInvalidRequest, "missing subject")
+			return nil
+		case len(cfg.Audience) == 0:
+			resp = errorResponse(ErrorCodeInvalidRequest, "missing audience")
+			return nil
+		}
 
-	refreshToken, ok := data.GetOk("refresh_token")
-	if !ok {
-		return logical.ErrorResponse("missing refresh_token"), nil
-	}
-	if _, ok := data.GetOk("code"); ok {
-		return logical.ErrorResponse("cannot use code with refresh_token grant type"), nil
-	}
+		// Scopes may be retained from a previous version of the credential.
+		if resp = scopeNotAllowedResponse(c.Config.DisallowedScopes(cfg.Scopes)); resp != nil {
+			return nil
+		}
 
-	tok := &provider.Token{
-		Token: &oauth2.Token{
-			RefreshToken: refreshToken.(string),
-		},
-	}
-	tok, err = ops.RefreshToken(
-		clockctx.WithClock(ctx, b.clock),
-		tok,
-		provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
-	)
-	if errmark.MarkedUser(err) {
-		return logical.ErrorResponse(errmap.Wrap(errmark.MarkShort(err), "refresh failed").Error()), nil
-	} else if err != nil {
-		return nil, err
-	}
+		_, alg, err := parseJWTBearerSigningKey(cfg.SigningKey, cfg.SigningAlgorithm)
+		if err != nil {
+			resp = errorResponse(ErrorCodeInvalidRequest, err.Error())
+			return nil
+		}
+		cfg.SigningAlgorithm = string(alg)
 
-	entry := &persistence.AuthCodeEntry{}
-	entry.SetToken(tok)
+		tok, err := b.jwtBearerExchange(ctx, c, cfg)
+		if presp := providerErrorResponse(err, "exchange failed"); presp != nil {
+			resp = presp
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		entry := &persistence.AuthCodeEntry{JWTBearer: cfg}
+		entry.SetToken(tok, b.clock.Now())
+		entry.Supersede(prev, c.Config.Tuning.MaxCredentialVersions, b.clock.Now())
+		applyCredUpdate(ctx, prev, entry)
+		entry.SetClaimMetadata(c.Config.ClaimMetadata)
+		b.recordCredHistory(c, entry, credHistoryEventExchanged, "")
 
-	if err := b.data.Managers(req.Storage).AuthCode().WriteAuthCodeEntry(ctx, persistence.AuthCodeName(data.Get("name").(string)), entry); err != nil {
+		if err := acm.WriteAuthCodeEntry(ctx, entry); err != nil {
+			return err
+		}
+
+		return acm.DeletePendingStateEntry(ctx)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return nil, nil
+	return resp, nil
 }
 
 func (b *backend) credsUpdateDeviceCodeOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -206,10 +1077,15 @@ func (b *backend) credsUpdateDeviceCodeOperation(ctx context.Context, req *logic
 	if err != nil {
 		return nil, err
 	} else if c == nil {
-		return logical.ErrorResponse("not configured"), nil
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	}
+
+	p, err := c.ProviderWithTimeout()
+	if err != nil {
+		return nil, err
 	}
 
-	ops := c.ProviderWithTimeout(defaultExpiryDelta).Public(c.Config.ClientID)
+	ops := p.Public(c.Config.ClientID)
 
 	// If a device code isn't provided, we'll end up setting this response to
 	// information important to return to the user. Otherwise, it will remain
@@ -229,12 +1105,12 @@ func (b *backend) credsUpdateDeviceCodeOperation(ctx context.Context, req *logic
 			provider.WithScopes(data.Get("scopes").([]string)),
 			provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
 		)
-		if errmark.MarkedUser(err) {
-			return logical.ErrorResponse(errmap.Wrap(errmark.MarkShort(err), "device code authorization request failed").Error()), nil
+		if resp := providerErrorResponse(err, "device code authorization request failed"); resp != nil {
+			return resp, nil
 		} else if err != nil {
 			return nil, err
 		} else if !ok {
-			return logical.ErrorResponse("device code URL not available"), nil
+			return errorResponse(ErrorCodeUnsupported, "device code URL not available"), nil
 		}
 
 		if auth.Interval > 0 {
@@ -272,10 +1148,22 @@ func (b *backend) credsUpdateDeviceCodeOperation(ctx context.Context, req *logic
 	if err != nil {
 		return nil, err
 	} else if ace.UserError != "" {
-		return logical.ErrorResponse(ace.UserError), nil
+		return errorResponse(ErrorCodeProviderRejected, ace.UserError), nil
 	}
 
 	err = b.data.Managers(req.Storage).AuthCode().WithLock(persistence.AuthCodeName(data.Get("name").(string)), func(acm *persistence.LockedAuthCodeManager) error {
+		prev, err := acm.ReadAuthCodeEntry(ctx)
+		if err != nil {
+			return err
+		}
+
+		ace.Supersede(prev, c.Config.Tuning.MaxCredentialVersions, b.clock.Now())
+		applyCredUpdate(ctx, prev, ace)
+		ace.SetClaimMetadata(c.Config.ClaimMetadata)
+		if ace.TokenIssued() {
+			b.recordCredHistory(c, ace, credHistoryEventExchanged, "")
+		}
+
 		if !ace.TokenIssued() {
 			// We'll write the device auth out first. In the issuer, it checks
 			// that the target entry exists first (because someone could delete
@@ -302,20 +1190,359 @@ func (b *backend) credsUpdateDeviceCodeOperation(ctx context.Context, req *logic
 	return resp, nil
 }
 
+// replaceAuthCodeEntry writes a new token for a credential, retaining the
+// previous token according to the configured version history. If the token was
+// issued by the provider, the event is recorded in the credential history.
+func (b *backend) replaceAuthCodeEntry(ctx context.Context, storage logical.Storage, c *cache, keyer persistence.AuthCodeKeyer, entry *persistence.AuthCodeEntry, event credHistoryEvent) error {
+	return b.data.Managers(storage).AuthCode().WithLock(keyer, func(acm *persistence.LockedAuthCodeManager) error {
+		prev, err := acm.ReadAuthCodeEntry(ctx)
+		if err != nil {
+			return err
+		}
+
+		entry.Supersede(prev, c.Config.Tuning.MaxCredentialVersions, b.clock.Now())
+		applyCredUpdate(ctx, prev, entry)
+		entry.SetClaimMetadata(c.Config.ClaimMetadata)
+		if event != "" {
+			b.recordCredHistory(c, entry, event, "")
+		}
+
+		if err := acm.WriteAuthCodeEntry(ctx, entry); err != nil {
+			return err
+		}
+
+		// Any exchange running in the background for the previous
+		// credential is superseded.
+		if err := acm.DeleteAuthCodeExchangeEntry(ctx); err != nil {
+			return err
+		}
+
+		// Any state generated for this credential is no longer required.
+		return acm.DeletePendingStateEntry(ctx)
+	})
+}
+
 func (b *backend) credsUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	hnd, found := credUpdateGrantHandlers[credGrantType(data)]
+	grantType := credGrantType(data)
+	hnd, found := credUpdateGrantHandlers[grantType]
 	if !found {
-		return logical.ErrorResponse("unknown grant_type"), nil
+		return errorResponse(ErrorCodeInvalidRequest, "unknown grant_type"), nil
+	}
+
+	if resp, err := b.grantTypeResponse(ctx, req.Storage, grantType); err != nil || resp != nil {
+		return resp, err
+	}
+	if resp, err := b.scopeResponse(ctx, req.Storage, data.Get("scopes").([]string)); err != nil || resp != nil {
+		return resp, err
+	}
+
+	if err := validateCredTuning(data); err != nil {
+		return errorResponse(ErrorCodeInvalidRequest, "%+v", err), nil
+	}
+	if err := validateCredBinding(req, data); err != nil {
+		return errorResponse(ErrorCodeInvalidRequest, "%+v", err), nil
+	}
+
+	if resp, err := b.maintenanceResponse(ctx, req.Storage); err != nil || resp != nil {
+		return resp, err
+	}
+
+	created, release, resp, err := b.credQuotaResponse(ctx, req.Storage, req.EntityID, data.Get("name").(string))
+	if err != nil || resp != nil {
+		return resp, err
+	}
+	defer release()
+
+	cu := newCredUpdate(req, data)
+	if created {
+		cu.creatorEntityID = req.EntityID
+	}
+
+	resp, err = hnd(b)(withCredUpdate(ctx, cu), req, data)
+	if err != nil || (resp != nil && resp.IsError()) {
+		return resp, err
+	}
+
+	if err := b.logCredCreated(ctx, req.Storage, data.Get("name").(string), credGrantType(data)); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// credQuotaResponse returns an error response if writing the credential with
+// the given name would create a new credential beyond the limits configured
+// for the mount or for the given entity. It also reports whether the write
+// creates a new credential. A new credential counts toward the limits until the
+// returned function is called, which the caller must do once the credential
+// and its creator have been written.
+func (b *backend) credQuotaResponse(ctx context.Context, storage logical.Storage, entityID, name string) (bool, func(), *logical.Response, error) {
+	acm := b.data.Managers(storage).AuthCode()
+
+	entry, err := acm.ReadAuthCodeEntry(ctx, persistence.AuthCodeName(name))
+	if err != nil || entry != nil {
+		return false, func() {}, nil, err
+	}
+
+	c, err := b.getCache(ctx, storage)
+	if err != nil || c == nil {
+		return true, func() {}, nil, err
+	}
+
+	release, err := acm.ReserveAuthCode(ctx, entityID, c.Config.Tuning.MaxCredentials, c.Config.Tuning.MaxCredentialsPerEntity)
+
+	var qe *persistence.AuthCodeQuotaError
+	switch {
+	case errors.As(err, &qe):
+		return true, nil, errorResponse(ErrorCodeQuotaExceeded, qe.Error()), nil
+	case err != nil:
+		return true, nil, nil, err
+	}
+
+	return true, release, nil, nil
+}
+
+// validateCredTuning checks the tuning overrides of a write request before the
+// credential is issued.
+func validateCredTuning(data *framework.FieldData) error {
+	if v, ok := data.GetOk("tune_refresh_expiry_delta_factor"); ok && v.(float64) < 1 {
+		return fmt.Errorf("refresh expiry delta factor must be at least 1.0")
+	}
+
+	for _, field := range []string{
+		"tune_provider_timeout_seconds",
+		"tune_reap_non_refreshable_seconds",
+		"tune_reap_revoked_seconds",
+		"tune_reap_transient_error_attempts",
+		"tune_reap_transient_error_seconds",
+	} {
+		if v, ok := data.GetOk(field); ok && v.(int) < 0 {
+			return fmt.Errorf("%s must not be negative", field)
+		}
+	}
+
+	return nil
+}
+
+const (
+	credBindEntity   = "entity"
+	credBindAccessor = "accessor"
+	credBindNone     = "none"
+)
+
+func validateCredBinding(req *logical.Request, data *framework.FieldData) error {
+	switch data.Get("bind").(string) {
+	case "", credBindNone:
+	case credBindEntity:
+		if req.EntityID == "" {
+			return fmt.Errorf("cannot bind to an entity because the token used for this request is not associated with one")
+		}
+	case credBindAccessor:
+		if req.ClientTokenAccessor == "" {
+			return fmt.Errorf("cannot bind to a token accessor because the request has none")
+		}
+	default:
+		return fmt.Errorf("bind must be one of %q, %q, or %q", credBindEntity, credBindAccessor, credBindNone)
+	}
+
+	return nil
+}
+
+// withCredBinding adapts a credential operation so that it is denied if the
+// credential is bound to a different identity than the one making the
+// request.
+func (b *backend) withCredBinding(fn framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		entry, err := b.data.Managers(req.Storage).AuthCode().ReadAuthCodeEntry(ctx, persistence.AuthCodeName(data.Get("name").(string)))
+		if err != nil {
+			return nil, err
+		} else if entry != nil && !entry.BoundTo(req.EntityID, req.ClientTokenAccessor) {
+			return nil, logical.ErrPermissionDenied
+		}
+
+		return fn(ctx, req, data)
+	}
+}
+
+type credUpdateKey struct{}
+
+// credUpdate holds the settings of a credential write request that belong to
+// the credential rather than its token. They are applied in the same locked
+// write that stores the new token. Settings that are not specified are
+// retained from the previous version of the credential.
+type credUpdate struct {
+	creatorEntityID string
+
+	refreshTokenTTLSeconds   *int
+	reauthorizeBeforeSeconds *int
+
+	// tuneReset discards the previous tuning overrides before tuning is
+	// applied.
+	tuneReset bool
+	tuning    persistence.AuthCodeTuningEntry
+
+	bind          string
+	entityID      string
+	tokenAccessor string
+}
+
+// newCredUpdate reads the credential settings from a validated write request.
+func newCredUpdate(req *logical.Request, data *framework.FieldData) *credUpdate {
+	intField := func(field string) *int {
+		if v, ok := data.GetOk(field); ok {
+			i := v.(int)
+			return &i
+		}
+		return nil
+	}
+
+	cu := &credUpdate{
+		refreshTokenTTLSeconds:   intField("refresh_token_ttl_seconds"),
+		reauthorizeBeforeSeconds: intField("reauthorize_before_seconds"),
+		tuneReset:                data.Get("tune_reset").(bool),
+		bind:                     data.Get("bind").(string),
+		entityID:                 req.EntityID,
+		tokenAccessor:            req.ClientTokenAccessor,
+	}
+
+	cu.tuning.ProviderTimeoutSeconds = intField("tune_provider_timeout_seconds")
+	if v, ok := data.GetOk("tune_refresh_expiry_delta_factor"); ok {
+		f := v.(float64)
+		cu.tuning.RefreshExpiryDeltaFactor = &f
+	}
+	cu.tuning.ReapNonRefreshableSeconds = intField("tune_reap_non_refreshable_seconds")
+	cu.tuning.ReapRevokedSeconds = intField("tune_reap_revoked_seconds")
+	cu.tuning.ReapTransientErrorAttempts = intField("tune_reap_transient_error_attempts")
+	cu.tuning.ReapTransientErrorSeconds = intField("tune_reap_transient_error_seconds")
+
+	return cu
+}
+
+// withCredUpdate returns a context that carries the given settings to the
+// write of the new token.
+func withCredUpdate(ctx context.Context, cu *credUpdate) context.Context {
+	return context.WithValue(ctx, credUpdateKey{}, cu)
+}
+
+// applyCredUpdate applies the settings carried by the context, if any, to an
+// entry that has superseded prev.
+func applyCredUpdate(ctx context.Context, prev, entry *persistence.AuthCodeEntry) {
+	cu, ok := ctx.Value(credUpdateKey{}).(*credUpdate)
+	if !ok {
+		return
+	}
+
+	// The creator is only recorded for new credentials. Writing it with the
+	// first version also counts the credential toward the creator's quota.
+	if prev == nil && cu.creatorEntityID != "" {
+		entry.CreatorEntityID = cu.creatorEntityID
+	}
+
+	if cu.refreshTokenTTLSeconds != nil {
+		entry.RefreshTokenTTLSeconds = *cu.refreshTokenTTLSeconds
+	}
+	if cu.reauthorizeBeforeSeconds != nil {
+		entry.ReauthorizeBeforeSeconds = *cu.reauthorizeBeforeSeconds
+	}
+
+	if cu.tuneReset || !cu.tuning.Empty() {
+		var tuning persistence.AuthCodeTuningEntry
+		if entry.Tuning != nil && !cu.tuneReset {
+			tuning = *entry.Tuning
+		}
+
+		if cu.tuning.ProviderTimeoutSeconds != nil {
+			tuning.ProviderTimeoutSeconds = cu.tuning.ProviderTimeoutSeconds
+		}
+		if cu.tuning.RefreshExpiryDeltaFactor != nil {
+			tuning.RefreshExpiryDeltaFactor = cu.tuning.RefreshExpiryDeltaFactor
+		}
+		if cu.tuning.ReapNonRefreshableSeconds != nil {
+			tuning.ReapNonRefreshableSeconds = cu.tuning.ReapNonRefreshableSeconds
+		}
+		if cu.tuning.ReapRevokedSeconds != nil {
+			tuning.ReapRevokedSeconds = cu.tuning.ReapRevokedSeconds
+		}
+		if cu.tuning.ReapTransientErrorAttempts != nil {
+			tuning.ReapTransientErrorAttempts = cu.tuning.ReapTransientErrorAttempts
+		}
+		if cu.tuning.ReapTransientErrorSeconds != nil {
+			tuning.ReapTransientErrorSeconds = cu.tuning.ReapTransientErrorSeconds
+		}
+
+		entry.Tuning = nil
+		if !tuning.Empty() {
+			entry.Tuning = &tuning
+		}
+	}
+
+	if cu.bind != "" {
+		entry.BoundEntityID, entry.BoundTokenAccessor = "", ""
+		switch cu.bind {
+		case credBindEntity:
+			entry.BoundEntityID = cu.entityID
+		case credBindAccessor:
+			entry.BoundTokenAccessor = cu.tokenAccessor
+		}
+	}
+}
+
+// addCredTuning adds the tuning overrides of a credential to a response.
+func addCredTuning(tuning *persistence.AuthCodeTuningEntry, rd map[string]interface{}) {
+	if tuning == nil {
+		return
 	}
 
-	return hnd(b)(ctx, req, data)
+	if tuning.ProviderTimeoutSeconds != nil {
+		rd["tune_provider_timeout_seconds"] = *tuning.ProviderTimeoutSeconds
+	}
+	if tuning.RefreshExpiryDeltaFactor != nil {
+		rd["tune_refresh_expiry_delta_factor"] = *tuning.RefreshExpiryDeltaFactor
+	}
+	if tuning.ReapNonRefreshableSeconds != nil {
+		rd["tune_reap_non_refreshable_seconds"] = *tuning.ReapNonRefreshableSeconds
+	}
+	if tuning.ReapRevokedSeconds != nil {
+		rd["tune_reap_revoked_seconds"] = *tuning.ReapRevokedSeconds
+	}
+	if tuning.ReapTransientErrorAttempts != nil {
+		rd["tune_reap_transient_error_attempts"] = *tuning.ReapTransientErrorAttempts
+	}
+	if tuning.ReapTransientErrorSeconds != nil {
+		rd["tune_reap_transient_error_seconds"] = *tuning.ReapTransientErrorSeconds
+	}
 }
 
 func (b *backend) credsDeleteOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	if err := b.data.Managers(req.Storage).AuthCode().DeleteAuthCodeEntry(ctx, persistence.AuthCodeName(data.Get("name").(string))); err != nil {
+	name := data.Get("name").(string)
+
+	var deleted bool
+	err := b.data.Managers(req.Storage).AuthCode().WithLock(persistence.AuthCodeName(name), func(lacm *persistence.LockedAuthCodeManager) error {
+		if err := lacm.DeletePendingStateEntry(ctx); err != nil {
+			return err
+		}
+
+		entry, err := lacm.ReadAuthCodeEntry(ctx)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		deleted = true
+		return lacm.DeleteAuthCodeEntry(ctx)
+	})
+	if err != nil {
 		return nil, err
 	}
 
+	if deleted {
+		c, err := b.getCache(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		b.logCredEvent(ctx, c, credEventRevoked, name, "deleted")
+	}
+
 	return nil, nil
 }
 
@@ -336,6 +1563,16 @@ var credsFields = map[string]*framework.FieldSchema{
 		Default:     0,
 		Query:       true,
 	},
+	"include_token": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to return tokens even if the redact_tokens configuration option is set.",
+		Query:       true,
+	},
+	"version": {
+		Type:        framework.TypeInt,
+		Description: "Specifies a previous version of the credential to read.",
+		Query:       true,
+	},
 	// fields for write operation
 	"grant_type": {
 		Type:          framework.TypeString,
@@ -354,20 +1591,199 @@ var credsFields = map[string]*framework.FieldSchema{
 		Type:        framework.TypeString,
 		Description: "Specifies a refresh token retrieved from the provider by some means external to this plugin.",
 	},
+	"state": {
+		Type:        framework.TypeString,
+		Description: "Specifies the state returned by the provider with the code. Required if the state was generated by this plugin.",
+	},
+	"defer_refresh": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to store the refresh token without exchanging it until the credential is first read.",
+		Default:     false,
+	},
 	"device_code": {
 		Type:        framework.TypeString,
 		Description: "Specifies a device token retrieved from the provider by some means external to this plugin.",
 	},
 	"scopes": {
 		Type:        framework.TypeStringSlice,
-		Description: "Specifies the scopes to provide for a device code authorization request.",
+		Description: "Specifies the scopes to provide for a device code authorization request, assertion exchange, or password grant.",
+	},
+	"username": {
+		Type:        framework.TypeString,
+		Description: "Specifies the username of the resource owner for the password grant. Not stored.",
+	},
+	"password": {
+		Type:        framework.TypeString,
+		Description: "Specifies the password of the resource owner for the password grant. Not stored.",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Sensitive: true,
+		},
+	},
+	"signing_key": {
+		Type:        framework.TypeString,
+		Description: "Specifies the PEM-encoded private key to sign JWT bearer assertions with.",
+	},
+	"signing_key_id": {
+		Type:        framework.TypeString,
+		Description: "Specifies the key ID to include in the header of JWT bearer assertions.",
+	},
+	"signing_algorithm": {
+		Type:        framework.TypeString,
+		Description: "Specifies the algorithm to sign JWT bearer assertions with. Defaults to an algorithm appropriate for the signing key.",
+	},
+	"issuer": {
+		Type:        framework.TypeString,
+		Description: "Specifies the issuer claim of JWT bearer assertions.",
+	},
+	"subject": {
+		Type:        framework.TypeString,
+		Description: "Specifies the subject claim of JWT bearer assertions.",
+	},
+	"audience": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the audience claim of JWT bearer assertions.",
+	},
+	"claims": {
+		Type:        framework.TypeMap,
+		Description: "Specifies additional claims to include in JWT bearer assertions.",
+	},
+	"assertion": {
+		Type:        framework.TypeString,
+		Description: "Specifies the SAML 2.0 assertion to exchange, either as XML or base64url-encoded.",
+	},
+	"access_token": {
+		Type:        framework.TypeString,
+		Description: "Specifies the access token to store for the static grant type.",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Sensitive: true,
+		},
+	},
+	"token_type": {
+		Type:        framework.TypeString,
+		Description: "Specifies the type of the access token for the static grant type. Defaults to Bearer.",
+	},
+	"expire_time": {
+		Type:        framework.TypeTime,
+		Description: "Specifies when the access token for the static grant type expires. If not specified, the token never expires.",
 	},
 	"provider_options": {
 		Type:        framework.TypeKVPairs,
-		Description: "Specifies a list of options to pass on to the provider for configuring this token exchange.",
+		Description: "Specifies a list of options to pass on to the provider for configuring this token exchange. When reading a credential, issues a token with these options in addition to those of the credential; only options allowed by the allowed_read_provider_options configuration can be given.",
+	},
+	"resources": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the RFC 8707 resource indicators to request when exchanging the code or refresh token and when refreshing the token. If a state is given, defaults to the resources used to generate it. Otherwise, defaults to the previous value.",
+	},
+	"template": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of a credential template to create the credential from. The redirect URL and provider options of the template are used if they are not given. Defaults to the template used to generate the state, if any.",
+	},
+	"bind": {
+		Type:          framework.TypeString,
+		Description:   "Specifies whether to restrict access to this credential to the identity entity (entity) or token (accessor) making this request, or to remove an existing restriction (none). If not specified, the existing restriction is retained.",
+		AllowedValues: []interface{}{credBindEntity, credBindAccessor, credBindNone},
+	},
+	"async": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to exchange the authorization code in the background. Read the credential to check the status of the exchange.",
+		Default:     false,
+	},
+	"refresh_token_ttl_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies the lifetime of refresh tokens for providers that do not report it. Retained across writes.",
+	},
+	"reauthorize_before_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies how long before the refresh token expires the credential should be authorized again. Retained across writes.",
+	},
+	"tune_provider_timeout_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Overrides the mount provider timeout for this credential. Retained across writes.",
+	},
+	"tune_refresh_expiry_delta_factor": {
+		Type:        framework.TypeFloat,
+		Description: "Overrides the mount refresh expiry delta factor for this credential. Retained across writes.",
+	},
+	"tune_reap_non_refreshable_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Overrides the mount reap threshold for non-refreshable tokens for this credential. Retained across writes.",
+	},
+	"tune_reap_revoked_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Overrides the mount reap threshold for revoked tokens for this credential. Retained across writes.",
+	},
+	"tune_reap_transient_error_attempts": {
+		Type:        framework.TypeInt,
+		Description: "Overrides the mount reap threshold for transient error attempts for this credential. Retained across writes.",
+	},
+	"tune_reap_transient_error_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Overrides the mount reap threshold for transient error age for this credential. Retained across writes.",
+	},
+	"tune_reset": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to remove all existing tuning overrides from this credential before applying any given in this request.",
+		Default:     false,
+	},
+}
+
+var credsListFields = map[string]*framework.FieldSchema{
+	"metadata": {
+		Type:        framework.TypeKVPairs,
+		Description: "Specifies metadata values that listed credentials must have.",
+		Query:       true,
+	},
+	"expired": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to list only credentials whose access token has expired, or only credentials whose access token has not.",
+		Query:       true,
+	},
+	"refreshable": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to list only credentials that can be refreshed, or only credentials that cannot.",
+		Query:       true,
+	},
+	"access_token_issuer": {
+		Type:        framework.TypeString,
+		Description: "Specifies the issuer that the access tokens of listed credentials must have. Requires decoding JWT access tokens.",
+		Query:       true,
+	},
+	"access_token_audience": {
+		Type:        framework.TypeString,
+		Description: "Specifies an audience that the access tokens of listed credentials must have. Requires decoding JWT access tokens.",
+		Query:       true,
+	},
+	"access_token_scope": {
+		Type:        framework.TypeString,
+		Description: "Specifies a scope that the access tokens of listed credentials must have. Requires decoding JWT access tokens.",
+		Query:       true,
+	},
+	"sort": {
+		Type:          framework.TypeString,
+		Description:   "Specifies the order of the listed credentials.",
+		AllowedValues: []interface{}{"name", "expire_time", "last_refresh_error"},
+		Default:       "name",
+		Query:         true,
 	},
 }
 
+const credsListHelpSynopsis = `
+Lists credentials, optionally filtered by their state or metadata.
+`
+
+const credsListHelpDescription = `
+This endpoint lists the names of credentials along with their expiry,
+most recent refresh error, and metadata. Metadata is copied from the
+claims of each credential's token according to the claim_metadata
+configuration option whenever the token is issued or refreshed. If
+metadata values are given, only credentials with all of the values
+are listed. Credentials can also be filtered by whether they have
+expired or can be refreshed, and sorted by name, expiry, or most
+recent refresh error. If decoding JWT access tokens is enabled, the
+claims of each access token are also listed, and credentials can be
+filtered by the issuer, audience, or scope of their access token.
+`
+
 const credsHelpSynopsis = `
 Provides access tokens for authorized credentials.
 `
@@ -379,22 +1795,45 @@ HTTP response of the authorization redirect. If the code is valid,
 the access token will be available when reading the endpoint.
 `
 
+func pathCredsList(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: CredsPathPrefix + `?$`,
+		Fields:  credsListFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback:  b.credsListOperation,
+				Summary:   "List credentials.",
+				Responses: credsListResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(credsListHelpSynopsis),
+		HelpDescription: strings.TrimSpace(credsListHelpDescription),
+	}
+}
+
 func pathCreds(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern: CredsPathPrefix + nameRegex("name") + `$`,
 		Fields:  credsFields,
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
-				Callback: b.credsReadOperation,
-				Summary:  "Get a current access token for this credential.",
+				Callback:  withoutUserCreds(b.withCredBinding(b.credsReadOperation)),
+				Summary:   "Get a current access token for this credential.",
+				Responses: credsReadResponses,
 			},
 			logical.UpdateOperation: &framework.PathOperation{
-				Callback: b.credsUpdateOperation,
-				Summary:  "Write a new credential or update an existing credential.",
+				Callback:                    withoutUserCreds(b.withCredBinding(b.credsUpdateOperation)),
+				Summary:                     "Write a new credential or update an existing credential.",
+				Responses:                   credsWriteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
 			},
 			logical.DeleteOperation: &framework.PathOperation{
-				Callback: b.credsDeleteOperation,
-				Summary:  "Remove a credential.",
+				Callback:                    withoutUserCreds(b.withCredBinding(b.credsDeleteOperation)),
+				Summary:                     "Remove a credential.",
+				Responses:                   credsDeleteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
 			},
 		},
 		HelpSynopsis:    strings.TrimSpace(credsHelpSynopsis),
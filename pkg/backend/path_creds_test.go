@@ -2,7 +2,14 @@ package backend_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"net/url"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -12,10 +19,12 @@ import (
 	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/devicecode"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2/jwt"
 	testclock "k8s.io/apimachinery/pkg/util/clock"
 )
 
@@ -90,6 +99,102 @@ func TestBasicAuthCodeExchange(t *testing.T) {
 	require.Empty(t, resp.Data["expire_time"])
 }
 
+func TestRedactTokens(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	token := &provider.Token{
+		Token: &oauth2.Token{
+			AccessToken: "valid",
+		},
+		ExtraData: map[string]interface{}{
+			"id_token": "id",
+			"scope":    "openid",
+		},
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, testutil.StaticMockAuthCodeExchange(token))))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+			"redact_tokens": true,
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write a valid credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"code": "test",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Only digests of the tokens are returned by default.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.NotContains(t, resp.Data, "access_token")
+	require.Equal(t, "ec654fac9599f62e79e2706abef23dfb7c07c08185aa86db4d8695f0b718d1b3", resp.Data["access_token_sha256"])
+	require.Equal(t, map[string]interface{}{
+		"id_token_sha256": "a56145270ce6b3bebd1dd012b73948677dd618d496488bc608a3cb43ce3547dd",
+		"scope":           "openid",
+	}, resp.Data["extra_data"])
+
+	// The tokens are returned when requested explicitly.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"include_token": true,
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, token.AccessToken, resp.Data["access_token"])
+	require.Equal(t, token.ExtraData, resp.Data["extra_data"])
+}
+
 func TestInvalidAuthCodeExchange(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -141,7 +246,147 @@ func TestInvalidAuthCodeExchange(t *testing.T) {
 	resp, err = b.HandleRequest(ctx, req)
 	require.NoError(t, err)
 	require.NotNil(t, resp)
-	require.EqualError(t, resp.Error(), "exchange failed: server rejected request: unauthorized_client")
+	require.EqualError(t, resp.Error(), "[ERR_PROVIDER_REJECTED] exchange failed: server rejected request: unauthorized_client")
+}
+
+func TestAsyncAuthCodeExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	// The provider is slow to respond until we release it.
+	release := make(chan struct{})
+	var badAttempts int32
+	exchange := func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if code == "bad" {
+			atomic.AddInt32(&badAttempts, 1)
+		}
+
+		return testutil.RestrictMockAuthCodeExchange(map[string]testutil.MockAuthCodeExchangeFunc{
+			"good": testutil.IncrementMockAuthCodeExchange("token_"),
+		})(code, opts)
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	write := func(name, code string) {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + name,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"code":  code,
+				"async": true,
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+		require.Equal(t, "pending", resp.Data["status"])
+	}
+
+	read := func(name string) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + name,
+			Storage:   storage,
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	// The write returns before the provider responds.
+	write("good", "good")
+	write("bad", "bad")
+
+	resp = read("good")
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "pending", resp.Data["status"])
+	require.NotContains(t, resp.Data, "access_token")
+
+	close(release)
+
+	for {
+		resp = read("good")
+		if resp.IsError() || resp.Data["status"] != "pending" {
+			break
+		}
+
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			require.Fail(t, "context expired waiting for exchange")
+		}
+	}
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "ready", resp.Data["status"])
+	require.Equal(t, "token_1", resp.Data["access_token"])
+
+	// The other code is rejected by the provider.
+	for {
+		resp = read("bad")
+		if resp.IsError() || resp.Data["status"] != "pending" {
+			break
+		}
+
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			require.Fail(t, "context expired waiting for exchange")
+		}
+	}
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "failed", resp.Data["status"])
+	require.Contains(t, resp.Data["error"], "exchange failed")
+	require.NotContains(t, resp.Data, "access_token")
+
+	// The spent code is not exchanged again.
+	select {
+	case <-time.After(2 * time.Second):
+	case <-ctx.Done():
+		require.Fail(t, "context expired waiting for exchange")
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&badAttempts))
+	require.Equal(t, "failed", read("bad").Data["status"])
 }
 
 func TestRefreshableAuthCodeExchange(t *testing.T) {
@@ -239,7 +484,7 @@ func TestRefreshableAuthCodeExchange(t *testing.T) {
 	require.Equal(t, map[string]string{"tenant": "test"}, resp.Data["provider_options"])
 }
 
-func TestRefreshFailureReturnsNotConfigured(t *testing.T) {
+func TestDeferredRefreshTokenImport(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -248,129 +493,412 @@ func TestRefreshFailureReturnsNotConfigured(t *testing.T) {
 		Secret: "def",
 	}
 
-	refresh := func(i int) (time.Duration, error) {
-		switch i {
-		case 1:
-			// Start with a short duration, which will force a refresh within
-			// the library's grace period (< 10 seconds to expiry).
-			return 2 * time.Second, nil
-		default:
-			// Now we'll force a refresh failure.
-			return 0, fmt.Errorf("you are not welcome")
-		}
+	var exchanges int32
+	exchange := testutil.RefreshableMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), func(_ int) (time.Duration, error) {
+		return 10 * time.Minute, nil
+	})
+	handler := func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		atomic.AddInt32(&exchanges, 1)
+		return exchange(code, opts)
 	}
 
-	exchange := testutil.RefreshableMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), refresh)
-
 	pr := provider.NewRegistry()
-	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, handler)))
 
 	storage := &logical.InmemStorage{}
 
 	b := backend.New(backend.Options{ProviderRegistry: pr})
 	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
 
-	// Write configuration.
-	req := &logical.Request{
+	handle := func(req *logical.Request) *logical.Response {
+		req.Storage = storage
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	handle(&logical.Request{
 		Operation: logical.UpdateOperation,
 		Path:      backend.ConfigPath,
-		Storage:   storage,
 		Data: map[string]interface{}{
 			"client_id":     client.ID,
 			"client_secret": client.Secret,
 			"provider":      "mock",
 		},
-	}
-
-	resp, err := b.HandleRequest(ctx, req)
-	require.NoError(t, err)
-	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
-	require.Nil(t, resp)
+	})
 
-	// Write a valid credential.
-	req = &logical.Request{
+	// Obtain a refresh token the mock provider knows about.
+	handle(&logical.Request{
 		Operation: logical.UpdateOperation,
-		Path:      backend.CredsPathPrefix + `test`,
-		Storage:   storage,
+		Path:      backend.CredsPathPrefix + `source`,
 		Data: map[string]interface{}{
 			"code": "test",
 		},
-	}
+	})
+	require.Equal(t, int32(1), atomic.LoadInt32(&exchanges))
 
-	resp, err = b.HandleRequest(ctx, req)
+	se, err := storage.Get(ctx, persistence.AuthCodeName("source").AuthCodeKey())
 	require.NoError(t, err)
-	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
-	require.Nil(t, resp)
+	require.NotNil(t, se)
 
-	// Read the corresponding access token. This should force a refresh, which
-	// should now return an invalidation from the server.
-	req = &logical.Request{
-		Operation: logical.ReadOperation,
+	source := &persistence.AuthCodeEntry{}
+	require.NoError(t, se.DecodeJSON(source))
+	require.NotEmpty(t, source.RefreshToken)
+
+	// Importing the refresh token does not contact the provider.
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
 		Path:      backend.CredsPathPrefix + `test`,
-		Storage:   storage,
+		Data: map[string]interface{}{
+			"refresh_token": source.RefreshToken,
+			"defer_refresh": true,
+		},
+	})
+	require.Equal(t, int32(1), atomic.LoadInt32(&exchanges))
+
+	// The first read refreshes the token, and later reads reuse it.
+	for i := 0; i < 2; i++ {
+		resp := handle(&logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+		})
+		require.NotNil(t, resp)
+		require.Equal(t, "token_2", resp.Data["access_token"])
+		require.Equal(t, int32(2), atomic.LoadInt32(&exchanges))
 	}
-
-	resp, err = b.HandleRequest(ctx, req)
-	require.NoError(t, err)
-	require.NotNil(t, resp)
-	require.EqualError(t, resp.Error(), "token expired")
 }
 
-func TestDeviceCodeAuthAndExchange(t *testing.T) {
+func TestScopeDowngradeOnRefresh(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	client := testutil.MockClient{ID: "abc"}
-
-	auth := testutil.StaticMockDeviceCodeAuth(&devicecode.Auth{
-		DeviceCode:              "xyz123",
-		UserCode:                "ABCD-1234",
-		VerificationURI:         "http://localhost/verify",
-		VerificationURIComplete: "http://localhost/verify?user_code=ABCD-1234",
-		ExpiresIn:               300,
-		Interval:                5,
-	})
-
-	// This contains the state of the issuer: either 0 (pending), 1 (request to
-	// issue), or 2 (issued).
-	var issue int32
-	exchange := func(deviceCode string, opts *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
-		require.Equal(t, "xyz123", deviceCode)
-
-		switch {
-		case atomic.CompareAndSwapInt32(&issue, 1, 2) || atomic.LoadInt32(&issue) > 1:
-			atomic.AddInt32(&issue, 1)
-			return &provider.Token{Token: &oauth2.Token{AccessToken: "hello"}}, nil
-		default:
-			return testutil.AuthorizationPendingErrorMockDeviceCodeExchange(deviceCode, opts)
-		}
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
 	}
 
+	// The first token expires almost immediately and grants both scopes. The
+	// refreshed token only grants one of them.
+	var issued int32
+	exchange := testutil.AmendTokenMockAuthCodeExchange(
+		testutil.RefreshableMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), func(i int) (time.Duration, error) {
+			if i == 1 {
+				return time.Second, nil
+			}
+			return 10 * time.Minute, nil
+		}),
+		func(tok *provider.Token) error {
+			scope := "read write"
+			if atomic.AddInt32(&issued, 1) > 1 {
+				scope = "read"
+			}
+
+			tok.ExtraData = map[string]interface{}{"scope": scope}
+			return nil
+		},
+	)
+
 	pr := provider.NewRegistry()
-	pr.MustRegister("mock", testutil.MockFactory(
-		testutil.MockWithDeviceCodeAuth(client, auth),
-		testutil.MockWithDeviceCodeExchange(client, exchange),
-	))
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
 
 	storage := &logical.InmemStorage{}
 
-	clk := testclock.NewFakeClock(time.Now())
-
-	b := backend.New(backend.Options{
-		ProviderRegistry: pr,
-		Clock: clock.NewTimerCallbackClock(
-			k8sext.NewClock(clk),
-			func(d time.Duration) {
-				// Stepping the clock every time a timer is created or reset
-				// guarantees that the normally-delayed timer will immediately
-				// retry over and over.
-				clk.Step(d)
-			},
-		),
-	})
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(req *logical.Request) *logical.Response {
+		req.Storage = storage
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	})
+
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Data: map[string]interface{}{
+			"code": "test",
+		},
+	})
+
+	resp := handle(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+	})
+	require.NotNil(t, resp)
+	require.Equal(t, "token_2", resp.Data["access_token"])
+	require.Equal(t, []string{"write"}, resp.Data["revoked_scopes"])
+	require.NotEmpty(t, resp.Data["scope_downgrade_time"])
+	require.Len(t, resp.Warnings, 1)
+	require.Contains(t, resp.Warnings[0], "write")
+}
+
+func TestClaimMetadata(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	// The first token expires almost immediately. The refreshed token reports
+	// a different email address.
+	claims := map[string]map[string]interface{}{
+		"token_1": {"email": "alice@example.com", "email_verified": false},
+		"token_2": {"email": "alice@example.org", "email_verified": true},
+		"token_3": {"email": "bob@example.com", "groups": []interface{}{"admins"}},
+	}
+	exchange := testutil.AmendTokenMockAuthCodeExchange(
+		testutil.RefreshableMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), func(i int) (time.Duration, error) {
+			if i == 1 {
+				return time.Second, nil
+			}
+			return 10 * time.Minute, nil
+		}),
+		func(tok *provider.Token) error {
+			tok.ExtraData = map[string]interface{}{"id_token_claims": claims[tok.AccessToken]}
+			return nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(req *logical.Request) *logical.Response {
+		req.Storage = storage
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+			"claim_metadata": map[string]interface{}{
+				"email":    "email",
+				"verified": "email_verified",
+				"groups":   "groups",
+			},
+		},
+	})
+
+	for _, name := range []string{"alice", "bob"} {
+		handle(&logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + name,
+			Data: map[string]interface{}{
+				"code": "test",
+			},
+		})
+
+		// Metadata is kept in sync when the token is refreshed.
+		resp := handle(&logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + name,
+		})
+		require.NotNil(t, resp)
+
+		switch name {
+		case "alice":
+			require.Equal(t, "token_2", resp.Data["access_token"])
+			require.Equal(t, map[string]string{"email": "alice@example.org", "verified": "true"}, resp.Data["metadata"])
+		case "bob":
+			// Claims that aren't scalar values are omitted.
+			require.Equal(t, "token_3", resp.Data["access_token"])
+			require.Equal(t, map[string]string{"email": "bob@example.com"}, resp.Data["metadata"])
+		}
+	}
+
+	list := func(filter map[string]interface{}) []string {
+		resp := handle(&logical.Request{
+			Operation: logical.ListOperation,
+			Path:      backend.CredsPathPrefix,
+			Data: map[string]interface{}{
+				"metadata": filter,
+			},
+		})
+		require.NotNil(t, resp)
+
+		keys, _ := resp.Data["keys"].([]string)
+		return keys
+	}
+
+	require.Equal(t, []string{"alice", "bob"}, list(nil))
+	require.Equal(t, []string{"bob"}, list(map[string]interface{}{"email": "bob@example.com"}))
+	require.Equal(t, []string{"alice"}, list(map[string]interface{}{"email": "alice@example.org", "verified": "true"}))
+	require.Empty(t, list(map[string]interface{}{"email": "alice@example.com"}))
+}
+
+func TestCredsListFilterAndSort(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, testutil.IncrementMockAuthCodeExchange("token_"))))
+
+	storage := &logical.InmemStorage{}
+	now := time.Now()
+
+	acm := persistence.NewHolder().Managers(storage).AuthCode()
+	for name, setup := range map[string]func(ace *persistence.AuthCodeEntry){
+		"a": func(ace *persistence.AuthCodeEntry) {
+			ace.Expiry = now.Add(2 * time.Hour)
+			ace.RefreshToken = "refresh"
+		},
+		"b": func(ace *persistence.AuthCodeEntry) {
+			ace.Expiry = now.Add(time.Hour)
+		},
+		"c": func(ace *persistence.AuthCodeEntry) {
+			ace.Expiry = now.Add(-time.Hour)
+			ace.RefreshToken = "refresh"
+			ace.SetTransientError("server error", now.Add(-10*time.Minute))
+		},
+		"d": func(ace *persistence.AuthCodeEntry) {
+			ace.Expiry = now.Add(-2 * time.Hour)
+			ace.SetUserError("invalid_grant", now.Add(-5*time.Minute))
+		},
+		"e": func(ace *persistence.AuthCodeEntry) {},
+	} {
+		ace := &persistence.AuthCodeEntry{}
+		ace.SetToken(&provider.Token{Token: &oauth2.Token{AccessToken: name}}, now)
+		setup(ace)
+		require.NoError(t, acm.WriteAuthCodeEntry(ctx, persistence.AuthCodeName(name), ace))
+	}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(req *logical.Request) *logical.Response {
+		req.Storage = storage
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	list := func(query map[string]interface{}) []string {
+		resp := handle(&logical.Request{
+			Operation: logical.ListOperation,
+			Path:      backend.CredsPathPrefix,
+			Data:      query,
+		})
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+		keys, _ := resp.Data["keys"].([]string)
+		return keys
+	}
+
+	require.Equal(t, []string{"a", "b", "c", "d", "e"}, list(nil))
+	require.Equal(t, []string{"d", "c", "b", "a", "e"}, list(map[string]interface{}{"sort": "expire_time"}))
+	require.Equal(t, []string{"d", "c", "a", "b", "e"}, list(map[string]interface{}{"sort": "last_refresh_error"}))
+	require.Equal(t, []string{"c", "d"}, list(map[string]interface{}{"expired": "true"}))
+	require.Equal(t, []string{"a", "b", "e"}, list(map[string]interface{}{"expired": "false"}))
+	require.Equal(t, []string{"b", "d", "e"}, list(map[string]interface{}{"refreshable": "false"}))
+	require.Equal(t, []string{"c"}, list(map[string]interface{}{"expired": "true", "refreshable": "true"}))
+
+	resp = handle(&logical.Request{
+		Operation: logical.ListOperation,
+		Path:      backend.CredsPathPrefix,
+		Data:      map[string]interface{}{"sort": "created"},
+	})
+	require.True(t, resp != nil && resp.IsError())
+	code, _ := backend.ParseErrorCode(resp.Error().Error())
+	require.Equal(t, backend.ErrorCodeInvalidRequest, code)
+
+	resp = handle(&logical.Request{
+		Operation: logical.ListOperation,
+		Path:      backend.CredsPathPrefix,
+	})
+	require.NotNil(t, resp)
+
+	info := resp.Data["key_info"].(map[string]interface{})["d"].(map[string]interface{})
+	require.Equal(t, true, info["expired"])
+	require.Equal(t, false, info["refreshable"])
+	require.Equal(t, "invalid_grant", info["last_refresh_error"])
+
+	// Credentials written before names were recorded are listed by their
+	// storage key.
+	legacy := &persistence.AuthCodeEntry{}
+	legacy.SetToken(&provider.Token{Token: &oauth2.Token{AccessToken: "legacy"}}, now)
+	require.NoError(t, acm.WriteAuthCodeEntry(ctx, persistence.AuthCodeKey("legacy"), legacy))
+
+	require.Equal(t, []string{"a", "b", "c", "d", "e", "legacy"}, list(nil))
+	require.Equal(t, []string{"d", "c", "b", "a", "e", "legacy"}, list(map[string]interface{}{"sort": "expire_time"}))
+}
+
+func TestRefreshFailureReturnsNotConfigured(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	refresh := func(i int) (time.Duration, error) {
+		switch i {
+		case 1:
+			// Start with a short duration, which will force a refresh within
+			// the library's grace period (< 10 seconds to expiry).
+			return 2 * time.Second, nil
+		default:
+			// Now we'll force a refresh failure.
+			return 0, fmt.Errorf("you are not welcome")
+		}
+	}
+
+	exchange := testutil.RefreshableMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), refresh)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
 	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
-	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
-	defer b.Clean(ctx)
 
 	// Write configuration.
 	req := &logical.Request{
@@ -378,8 +906,9 @@ func TestDeviceCodeAuthAndExchange(t *testing.T) {
 		Path:      backend.ConfigPath,
 		Storage:   storage,
 		Data: map[string]interface{}{
-			"client_id": client.ID,
-			"provider":  "mock",
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
 		},
 	}
 
@@ -394,21 +923,17 @@ func TestDeviceCodeAuthAndExchange(t *testing.T) {
 		Path:      backend.CredsPathPrefix + `test`,
 		Storage:   storage,
 		Data: map[string]interface{}{
-			"grant_type": devicecode.GrantType,
-			"scopes":     []interface{}{"first", "second"},
+			"code": "test",
 		},
 	}
 
 	resp, err = b.HandleRequest(ctx, req)
 	require.NoError(t, err)
-	require.NotNil(t, resp)
-	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
-	require.Equal(t, "ABCD-1234", resp.Data["user_code"])
-	require.Equal(t, "http://localhost/verify", resp.Data["verification_uri"])
-	require.Equal(t, "http://localhost/verify?user_code=ABCD-1234", resp.Data["verification_uri_complete"])
-	require.NotEmpty(t, resp.Data["expire_time"])
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
 
-	// Token should now be pending.
+	// Read the corresponding access token. This should force a refresh, which
+	// should now return an invalidation from the server.
 	req = &logical.Request{
 		Operation: logical.ReadOperation,
 		Path:      backend.CredsPathPrefix + `test`,
@@ -418,22 +943,1653 @@ func TestDeviceCodeAuthAndExchange(t *testing.T) {
 	resp, err = b.HandleRequest(ctx, req)
 	require.NoError(t, err)
 	require.NotNil(t, resp)
-	require.EqualError(t, resp.Error(), "token pending issuance")
+	require.EqualError(t, resp.Error(), "[ERR_TOKEN_EXPIRED] token expired")
+}
 
-	require.Equal(t, int32(1), atomic.AddInt32(&issue, 1))
-	for atomic.LoadInt32(&issue) == 1 {
-		select {
-		case <-ctx.Done():
-			require.Fail(t, "context expired waiting for token issuance")
+func TestMaintenanceMode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	refresh := func(i int) (time.Duration, error) {
+		switch i {
+		case 1:
+			// Force a refresh when the first credential is read.
+			return 2 * time.Second, nil
 		default:
+			return time.Hour, nil
+		}
+	}
+
+	exchange := testutil.RefreshableMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), refresh)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	writeConfig := func(maintenance bool) {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"client_id":        client.ID,
+				"client_secret":    client.Secret,
+				"provider":         "mock",
+				"maintenance_mode": maintenance,
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		require.Nil(t, resp)
+	}
+
+	writeConfig(false)
+
+	for _, name := range []string{"expiring", "valid"} {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + name,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"code": "test",
+			},
 		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		require.Nil(t, resp)
+	}
+
+	writeConfig(true)
+
+	// Valid tokens are still served from storage.
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + "valid",
+		Storage:   storage,
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_2", resp.Data["access_token"])
+
+	// Tokens that need to be refreshed are not.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + "expiring",
+		Storage:   storage,
 	}
 
 	resp, err = b.HandleRequest(ctx, req)
 	require.NoError(t, err)
 	require.NotNil(t, resp)
-	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
-	require.Equal(t, "hello", resp.Data["access_token"])
-	require.Equal(t, "Bearer", resp.Data["type"])
-	require.Empty(t, resp.Data["expire_time"])
+	require.EqualError(t, resp.Error(), "[ERR_MAINTENANCE] token expired and requests to the provider are paused for maintenance")
+
+	// New exchanges are rejected.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + "new",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"code": "test",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.EqualError(t, resp.Error(), "[ERR_MAINTENANCE] requests to the provider are paused for maintenance")
+
+	// Once maintenance is over, the token is refreshed as usual.
+	writeConfig(false)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + "expiring",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_3", resp.Data["access_token"])
+}
+
+func TestDeviceCodeAuthAndExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{ID: "abc"}
+
+	auth := testutil.StaticMockDeviceCodeAuth(&devicecode.Auth{
+		DeviceCode:              "xyz123",
+		UserCode:                "ABCD-1234",
+		VerificationURI:         "http://localhost/verify",
+		VerificationURIComplete: "http://localhost/verify?user_code=ABCD-1234",
+		ExpiresIn:               300,
+		Interval:                5,
+	})
+
+	// This contains the state of the issuer: either 0 (pending), 1 (request to
+	// issue), or 2 (issued).
+	var issue int32
+	exchange := func(deviceCode string, opts *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
+		require.Equal(t, "xyz123", deviceCode)
+
+		switch {
+		case atomic.CompareAndSwapInt32(&issue, 1, 2) || atomic.LoadInt32(&issue) > 1:
+			atomic.AddInt32(&issue, 1)
+			return &provider.Token{Token: &oauth2.Token{AccessToken: "hello"}}, nil
+		default:
+			return testutil.AuthorizationPendingErrorMockDeviceCodeExchange(deviceCode, opts)
+		}
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithDeviceCodeAuth(client, auth),
+		testutil.MockWithDeviceCodeExchange(client, exchange),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock: clock.NewTimerCallbackClock(
+			k8sext.NewClock(clk),
+			func(d time.Duration) {
+				// Stepping the clock every time a timer is created or reset
+				// guarantees that the normally-delayed timer will immediately
+				// retry over and over.
+				clk.Step(d)
+			},
+		),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id": client.ID,
+			"provider":  "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write a valid credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"grant_type": devicecode.GrantType,
+			"scopes":     []interface{}{"first", "second"},
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "ABCD-1234", resp.Data["user_code"])
+	require.Equal(t, "http://localhost/verify", resp.Data["verification_uri"])
+	require.Equal(t, "http://localhost/verify?user_code=ABCD-1234", resp.Data["verification_uri_complete"])
+	require.NotEmpty(t, resp.Data["expire_time"])
+
+	// Token should now be pending.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.EqualError(t, resp.Error(), "[ERR_TOKEN_PENDING] token pending issuance")
+
+	require.Equal(t, int32(1), atomic.AddInt32(&issue, 1))
+	for atomic.LoadInt32(&issue) == 1 {
+		select {
+		case <-ctx.Done():
+			require.Fail(t, "context expired waiting for token issuance")
+		default:
+		}
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "hello", resp.Data["access_token"])
+	require.Equal(t, "Bearer", resp.Data["type"])
+	require.Empty(t, resp.Data["expire_time"])
+}
+
+func TestSAML2BearerExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	assertion := `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion"></saml:Assertion>`
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithClientCredentials(client, func(opts *provider.ClientCredentialsOptions) (*provider.Token, error) {
+		require.Equal(t, backend.SAML2BearerGrantType, opts.EndpointParams.Get("grant_type"))
+		require.Equal(t, base64.RawURLEncoding.EncodeToString([]byte(assertion)), opts.EndpointParams.Get("assertion"))
+		require.Equal(t, []string{"api"}, opts.Scopes)
+
+		return &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken: "valid",
+			},
+		}, nil
+	})))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Exchange the assertion.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"grant_type": backend.SAML2BearerGrantType,
+			"assertion":  assertion,
+			"scopes":     []string{"api"},
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Read the corresponding access token.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "valid", resp.Data["access_token"])
+}
+
+func TestJWTBearerExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	newKey := func() (*ecdsa.PrivateKey, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		require.NoError(t, err)
+
+		return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	}
+
+	keyA, pemA := newKey()
+	keyB, pemB := newKey()
+
+	var (
+		expectedKey = keyA
+		exchanges   int32
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithClientCredentials(client, func(opts *provider.ClientCredentialsOptions) (*provider.Token, error) {
+		require.Equal(t, backend.JWTBearerGrantType, opts.EndpointParams.Get("grant_type"))
+
+		tok, err := jwt.ParseSigned(opts.EndpointParams.Get("assertion"))
+		require.NoError(t, err)
+
+		var claims struct {
+			jwt.Claims
+			Scope string `json:"scope"`
+		}
+		require.NoError(t, tok.Claims(&expectedKey.PublicKey, &claims))
+		require.NoError(t, claims.Validate(jwt.Expected{
+			Issuer:   "service@example.com",
+			Subject:  "user@example.com",
+			Audience: jwt.Audience{"https://example.com/token"},
+			Time:     time.Now(),
+		}))
+		require.Equal(t, "https://example.com/api", claims.Scope)
+		require.NotEmpty(t, claims.ID)
+
+		n := atomic.AddInt32(&exchanges, 1)
+
+		expiry := time.Now().Add(time.Hour)
+		if n == 1 {
+			expiry = time.Now().Add(time.Minute)
+		}
+
+		return &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken: fmt.Sprintf("token%d", n),
+				Expiry:      expiry,
+			},
+		}, nil
+	})))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Write a credential using the JWT bearer grant.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"grant_type":  backend.JWTBearerGrantType,
+			"signing_key": pemA,
+			"issuer":      "service@example.com",
+			"subject":     "user@example.com",
+			"audience":    "https://example.com/token",
+			"claims": map[string]interface{}{
+				"scope": "https://example.com/api",
+				"iss":   "ignored",
+			},
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	read := func(minimumSeconds int) string {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"minimum_seconds": minimumSeconds,
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+		return resp.Data["access_token"].(string)
+	}
+
+	require.Equal(t, "token1", read(0))
+
+	// Requiring a longer lifetime than the token has causes a new assertion
+	// to be minted.
+	require.Equal(t, "token2", read(120))
+
+	// Rotate the signing key. The rest of the template is retained.
+	expectedKey = keyB
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"grant_type":     backend.JWTBearerGrantType,
+			"signing_key":    pemB,
+			"signing_key_id": "b",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token3", read(0))
+
+	// Keys must be compatible with the requested algorithm.
+	req.Data = map[string]interface{}{
+		"grant_type":        backend.JWTBearerGrantType,
+		"signing_algorithm": "RS256",
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.True(t, resp != nil && resp.IsError())
+}
+
+func TestPasswordGrant(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithClientCredentials(client, func(opts *provider.ClientCredentialsOptions) (*provider.Token, error) {
+		require.Equal(t, backend.PasswordGrantType, opts.EndpointParams.Get("grant_type"))
+		require.Equal(t, "alice", opts.EndpointParams.Get("username"))
+		require.Equal(t, "hunter2", opts.EndpointParams.Get("password"))
+
+		return &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken: "valid",
+			},
+		}, nil
+	})))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	writeConfig := func(allow bool) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"client_id":            client.ID,
+				"client_secret":        client.Secret,
+				"provider":             "mock",
+				"allow_password_grant": allow,
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	credsReq := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"grant_type": backend.PasswordGrantType,
+			"username":   "alice",
+			"password":   "hunter2",
+		},
+	}
+
+	// The grant type must be enabled explicitly.
+	require.Nil(t, writeConfig(false))
+
+	resp, err := b.HandleRequest(ctx, credsReq)
+	require.NoError(t, err)
+	require.True(t, resp != nil && resp.IsError())
+
+	resp = writeConfig(true)
+	require.NotNil(t, resp)
+	require.NotEmpty(t, resp.Warnings)
+
+	resp, err = b.HandleRequest(ctx, credsReq)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.NotEmpty(t, resp.Warnings)
+
+	// The password is never stored.
+	se, err := storage.Get(ctx, persistence.AuthCodeName("test").AuthCodeKey())
+	require.NoError(t, err)
+	require.NotNil(t, se)
+	require.NotContains(t, string(se.Value), "hunter2")
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "valid", resp.Data["access_token"])
+}
+
+func TestStaticGrant(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	clk := testclock.NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	// The provider is never contacted.
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, func(_ string, _ *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		require.Fail(t, "unexpected exchange")
+		return nil, nil
+	})))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	write := func(data map[string]interface{}) *logical.Response {
+		data["grant_type"] = backend.StaticGrantType
+
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			Storage:   storage,
+			Data:      data,
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	read := func() *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			Storage:   storage,
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	// The token is required and can't be combined with other grants.
+	resp = write(map[string]interface{}{})
+	require.True(t, resp != nil && resp.IsError())
+	resp = write(map[string]interface{}{"access_token": "pat", "refresh_token": "foo"})
+	require.True(t, resp != nil && resp.IsError())
+	resp = write(map[string]interface{}{"access_token": "pat", "expire_time": clk.Now().Add(-time.Minute).Format(time.RFC3339)})
+	require.True(t, resp != nil && resp.IsError())
+
+	// A token without an expiry is returned as written.
+	resp = write(map[string]interface{}{"access_token": "pat", "token_type": "token"})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = read()
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "pat", resp.Data["access_token"])
+	require.Equal(t, "token", resp.Data["type"])
+	require.NotContains(t, resp.Data, "expire_time")
+
+	// A token with an expiry expires like any other non-refreshable token.
+	resp = write(map[string]interface{}{"access_token": "pat2", "expire_time": clk.Now().Add(time.Hour).Format(time.RFC3339)})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = read()
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "pat2", resp.Data["access_token"])
+	require.Equal(t, "Bearer", resp.Data["type"])
+	require.Equal(t, clk.Now().Add(time.Hour), resp.Data["expire_time"])
+
+	clk.Step(2 * time.Hour)
+
+	resp = read()
+	require.True(t, resp.IsError())
+	code, ok := backend.ParseErrorCode(resp.Error().Error())
+	require.True(t, ok)
+	require.Equal(t, backend.ErrorCodeTokenExpired, code)
+}
+
+func TestExpiryWithFakeClock(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	// Start well away from the wall clock so that any use of it shows up.
+	clk := testclock.NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.ExpiringMockAuthCodeExchange(testutil.RandomMockAuthCodeExchange, 10*time.Minute, testutil.MockExpiryWithClock(clk))),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"code": "test",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	read := func() *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			Storage:   storage,
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	resp = read()
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, clk.Now().Add(10*time.Minute), resp.Data["expire_time"])
+	require.Equal(t, clk.Now(), resp.Data["last_refresh_time"])
+
+	// Fast-forward past the expiry instead of waiting for it.
+	clk.Step(15 * time.Minute)
+
+	resp = read()
+	require.True(t, resp.IsError())
+	code, ok := backend.ParseErrorCode(resp.Error().Error())
+	require.True(t, ok)
+	require.Equal(t, backend.ErrorCodeTokenExpired, code)
+}
+
+func TestExpiryLeeway(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	clk := testclock.NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.ExpiringMockAuthCodeExchange(testutil.RandomMockAuthCodeExchange, 10*time.Minute, testutil.MockExpiryWithClock(clk))),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, map[string]interface{}{
+		"client_id":                  client.ID,
+		"client_secret":              client.Secret,
+		"provider":                   "mock",
+		"tune_expiry_leeway_seconds": 60,
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+"test", map[string]interface{}{
+		"code": "test",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// The token is still accepted shortly after it expires.
+	clk.Step(10*time.Minute + 30*time.Second)
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"test", nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	// But not once the leeway has passed.
+	clk.Step(time.Minute)
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"test", nil)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	code, ok := backend.ParseErrorCode(resp.Error().Error())
+	require.True(t, ok)
+	require.Equal(t, backend.ErrorCodeTokenExpired, code)
+}
+
+func TestDefaultTokenLifetime(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	clk := testclock.NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.RandomMockAuthCodeExchange),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, map[string]interface{}{
+		"client_id":                      client.ID,
+		"client_secret":                  client.Secret,
+		"provider":                       "mock",
+		"default_token_lifetime_seconds": 3600,
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+"test", map[string]interface{}{
+		"code": "test",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// The provider did not specify an expiry, so one is assumed.
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"test", nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, clk.Now().Add(time.Hour), resp.Data["expire_time"])
+
+	clk.Step(time.Hour)
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"test", nil)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	code, ok := backend.ParseErrorCode(resp.Error().Error())
+	require.True(t, ok)
+	require.Equal(t, backend.ErrorCodeTokenExpired, code)
+}
+
+func TestDeviceCodeExchangeSlowDown(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{ID: "abc"}
+
+	auth := testutil.StaticMockDeviceCodeAuth(&devicecode.Auth{
+		DeviceCode:      "xyz123",
+		UserCode:        "ABCD-1234",
+		VerificationURI: "http://localhost/verify",
+		ExpiresIn:       300,
+		Interval:        5,
+	})
+
+	// The user completes the authorization after the provider has asked us to
+	// poll less often.
+	exchange := testutil.RestrictMockDeviceCodeExchange(map[string]testutil.MockDeviceCodeExchangeFunc{
+		"xyz123": testutil.SequenceMockDeviceCodeExchange(
+			testutil.AuthorizationPendingErrorMockDeviceCodeExchange,
+			testutil.SlowDownErrorMockDeviceCodeExchange,
+			testutil.AuthorizationPendingErrorMockDeviceCodeExchange,
+			testutil.IncrementMockDeviceCodeExchange("token_"),
+		),
+	})
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithDeviceCodeAuth(client, auth),
+		testutil.MockWithDeviceCodeExchange(client, exchange),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock: clock.NewTimerCallbackClock(
+			k8sext.NewClock(clk),
+			func(d time.Duration) {
+				clk.Step(d)
+			},
+		),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id": client.ID,
+			"provider":  "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"grant_type": devicecode.GrantType,
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	for {
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+
+		if !resp.IsError() {
+			break
+		}
+
+		code, ok := backend.ParseErrorCode(resp.Error().Error())
+		require.True(t, ok)
+		require.Equal(t, backend.ErrorCodeTokenPending, code)
+
+		select {
+		case <-ctx.Done():
+			require.Fail(t, "context expired waiting for token issuance")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_1", resp.Data["access_token"])
+}
+
+// benchmarkBackend returns a configured backend whose storage contains the
+// given number of credentials, named bench-0 through bench-(n-1). Their tokens
+// remain valid for the duration of the benchmark, so reading them never
+// contacts the provider.
+func benchmarkBackend(b *testing.B, n int) (logical.Backend, logical.Storage) {
+	if n > 1000 && testing.Short() {
+		b.Skip("not populating large storage in short mode")
+	}
+
+	ctx := context.Background()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	token := &provider.Token{
+		Token: &oauth2.Token{
+			AccessToken:  "valid",
+			RefreshToken: "refresh",
+			Expiry:       time.Now().Add(24 * time.Hour),
+		},
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, testutil.StaticMockAuthCodeExchange(token))))
+
+	storage := &logical.InmemStorage{}
+
+	acm := persistence.NewHolder().Managers(storage).AuthCode()
+	for i := 0; i < n; i++ {
+		ace := &persistence.AuthCodeEntry{}
+		ace.SetToken(token, time.Now())
+		require.NoError(b, acm.WriteAuthCodeEntry(ctx, persistence.AuthCodeName(fmt.Sprintf("bench-%d", i)), ace))
+	}
+
+	be := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(b, be.Setup(ctx, &logical.BackendConfig{}))
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := be.HandleRequest(ctx, req)
+	require.NoError(b, err)
+	require.False(b, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	b.Cleanup(func() { be.Cleanup(ctx) })
+	return be, storage
+}
+
+// benchmarkCreds makes the request returned by fn for every combination of
+// credential count and parallelism.
+func benchmarkCreds(b *testing.B, fn func(n, i int) *logical.Request) {
+	ctx := context.Background()
+
+	for _, n := range []int{1000, 100000} {
+		b.Run(fmt.Sprintf("entries=%d", n), func(b *testing.B) {
+			be, storage := benchmarkBackend(b, n)
+
+			for _, p := range []int{1, 8} {
+				b.Run(fmt.Sprintf("parallelism=%d", p), func(b *testing.B) {
+					var offset int64
+
+					b.ReportAllocs()
+					b.SetParallelism(p)
+					b.ResetTimer()
+					b.RunParallel(func(pb *testing.PB) {
+						i := int(atomic.AddInt64(&offset, 7919))
+						for pb.Next() {
+							req := fn(n, i)
+							req.Storage = storage
+
+							resp, err := be.HandleRequest(ctx, req)
+							if err != nil {
+								b.Fatalf("request failed: %+v", err)
+							} else if resp != nil && resp.IsError() {
+								b.Fatalf("response has error: %+v", resp.Error())
+							}
+
+							i++
+						}
+					})
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkCredsRead(b *testing.B) {
+	benchmarkCreds(b, func(n, i int) *logical.Request {
+		return &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + fmt.Sprintf("bench-%d", i%n),
+		}
+	})
+}
+
+func BenchmarkCredsWrite(b *testing.B) {
+	benchmarkCreds(b, func(n, i int) *logical.Request {
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + fmt.Sprintf("bench-%d", i%n),
+			Data: map[string]interface{}{
+				"code": "test",
+			},
+		}
+	})
+}
+
+func TestCredsQuota(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, testutil.RandomMockAuthCodeExchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":                       client.ID,
+			"client_secret":                   client.Secret,
+			"provider":                        "mock",
+			"tune_max_credentials":            3,
+			"tune_max_credentials_per_entity": 2,
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	write := func(name, entityID string) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + name,
+			Storage:   storage,
+			EntityID:  entityID,
+			Data: map[string]interface{}{
+				"code": "test",
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	requireQuotaExceeded := func(resp *logical.Response) {
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+		code, _ := backend.ParseErrorCode(resp.Error().Error())
+		require.Equal(t, backend.ErrorCodeQuotaExceeded, code)
+	}
+
+	// The entity can create two credentials, but not a third.
+	for _, name := range []string{"a1", "a2"} {
+		resp = write(name, "alice")
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+	requireQuotaExceeded(write("a3", "alice"))
+
+	// Replacing an existing credential is always allowed.
+	resp = write("a1", "alice")
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Another entity can create a credential until the mount is full.
+	resp = write("b1", "bob")
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	requireQuotaExceeded(write("b2", "bob"))
+	requireQuotaExceeded(write("root", ""))
+
+	// Deleting a credential frees it for the entity that created it.
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      backend.CredsPathPrefix + "a2",
+		Storage:   storage,
+	}
+
+	_, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+
+	resp = write("a3", "alice")
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+}
+
+func TestCredsResources(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	var requested [][]string
+	increment := testutil.IncrementMockAuthCodeExchange("token_")
+	exchange := testutil.RefreshableMockAuthCodeExchange(
+		func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+			requested = append(requested, opts.Resources)
+			return increment(code, opts)
+		},
+		func(i int) (time.Duration, error) {
+			if i == 1 {
+				return time.Minute, nil
+			}
+			return time.Hour, nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	handle(logical.UpdateOperation, backend.ConfigPath, map[string]interface{}{
+		"client_id":     client.ID,
+		"client_secret": client.Secret,
+		"provider":      "mock",
+	})
+
+	resources := []string{"https://api.example.com", "https://files.example.com"}
+
+	// Each resource is a separate parameter of the authorization code URL.
+	resp := handle(logical.UpdateOperation, backend.AuthCodeURLCredsPathPrefix+"test", map[string]interface{}{
+		"resources": resources,
+	})
+	u, err := url.Parse(resp.Data["url"].(string))
+	require.NoError(t, err)
+	require.Equal(t, resources, u.Query()["resource"])
+
+	// The resources of the state are used when the code is exchanged and
+	// are retained by the credential.
+	handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"code":  "123456",
+		"state": resp.Data["state"],
+	})
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, nil)
+	require.Equal(t, "token_1", resp.Data["access_token"])
+	require.Equal(t, resources, resp.Data["resources"])
+
+	// Refreshing the token requests the same resources.
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"minimum_seconds": 120,
+	})
+	require.Equal(t, "token_2", resp.Data["access_token"])
+
+	// Writing a new code without resources keeps those of the credential for
+	// refreshes.
+	handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"code": "123456",
+	})
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, nil)
+	require.Equal(t, resources, resp.Data["resources"])
+
+	require.Equal(t, [][]string{resources, resources, nil}, requested)
+}
+
+func TestAllowedGrantTypes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.IncrementMockAuthCodeExchange("token_")),
+		testutil.MockWithClientCredentials(client, func(_ *provider.ClientCredentialsOptions) (*provider.Token, error) {
+			return &provider.Token{Token: &oauth2.Token{AccessToken: "self"}}, nil
+		}),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	requireNotAllowed := func(resp *logical.Response) {
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+
+		code, ok := backend.ParseErrorCode(resp.Error().Error())
+		require.True(t, ok)
+		require.Equal(t, backend.ErrorCodeUnsupported, code)
+	}
+
+	config := map[string]interface{}{
+		"client_id":           client.ID,
+		"client_secret":       client.Secret,
+		"provider":            "mock",
+		"allowed_grant_types": []string{"authorization_code", "refresh_token", "implicit"},
+	}
+
+	// Unknown grant types are rejected.
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.True(t, resp != nil && resp.IsError())
+
+	config["allowed_grant_types"] = []string{"authorization_code", "refresh_token"}
+	resp = handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.ConfigPath, nil)
+	require.NotNil(t, resp)
+	require.Equal(t, []string{"authorization_code", "refresh_token"}, resp.Data["allowed_grant_types"])
+
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"code": "123456",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	requireNotAllowed(handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"grant_type":   backend.StaticGrantType,
+		"access_token": "static",
+	}))
+	requireNotAllowed(handle(logical.UpdateOperation, backend.ConfigSelfPathPrefix+`test`, nil))
+
+	// Removing a grant type applies to every way of starting it.
+	config["allowed_grant_types"] = []string{backend.ClientCredentialsGrantType}
+	resp = handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	requireNotAllowed(handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"code": "123456",
+	}))
+	requireNotAllowed(handle(logical.UpdateOperation, backend.AuthCodeURLCredsPathPrefix+"test", map[string]interface{}{}))
+	requireNotAllowed(handle(logical.ReadOperation, backend.CallbackPath, map[string]interface{}{
+		"state": "qwerty",
+		"code":  "123456",
+	}))
+
+	resp = handle(logical.UpdateOperation, backend.ConfigSelfPathPrefix+`test`, nil)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Existing credentials can still be read.
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, nil)
+	require.NotNil(t, resp)
+	require.Equal(t, "token_1", resp.Data["access_token"])
+}
+
+func TestAllowedRedirectURLs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.IncrementMockAuthCodeExchange("token_")),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	requireNotAllowed := func(resp *logical.Response) {
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+
+		code, ok := backend.ParseErrorCode(resp.Error().Error())
+		require.True(t, ok)
+		require.Equal(t, backend.ErrorCodeInvalidRequest, code)
+	}
+
+	config := map[string]interface{}{
+		"client_id":             client.ID,
+		"client_secret":         client.Secret,
+		"provider":              "mock",
+		"allowed_redirect_urls": []string{"/redirect"},
+	}
+
+	// Relative URLs are rejected.
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.True(t, resp != nil && resp.IsError())
+
+	config["allowed_redirect_urls"] = []string{"https://example.com/redirect"}
+	resp = handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.ConfigPath, nil)
+	require.NotNil(t, resp)
+	require.Equal(t, []string{"https://example.com/redirect"}, resp.Data["allowed_redirect_urls"])
+
+	requireNotAllowed(handle(logical.UpdateOperation, backend.AuthCodeURLCredsPathPrefix+"test", map[string]interface{}{
+		"redirect_url": "https://attacker.example.com/redirect",
+	}))
+	requireNotAllowed(handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"code":         "123456",
+		"redirect_url": "https://example.com/redirect/other",
+	}))
+
+	resp = handle(logical.UpdateOperation, backend.AuthCodeURLCredsPathPrefix+"test", map[string]interface{}{
+		"redirect_url": "https://example.com/redirect",
+	})
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"code":  "123456",
+		"state": resp.Data["state"],
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Not giving a redirect URL uses the one registered with the provider.
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"code": "123456",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, nil)
+	require.NotNil(t, resp)
+	require.Equal(t, "token_2", resp.Data["access_token"])
+}
+
+func TestCredsReadProviderOptions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	increment := testutil.IncrementMockAuthCodeExchange("token_")
+	exchange := testutil.RefreshableMockAuthCodeExchange(
+		func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+			tok, err := increment(code, opts)
+			if err != nil {
+				return nil, err
+			}
+
+			if tenant := opts.ProviderOptions["tenant"]; tenant != "" {
+				tok.AccessToken += "@" + tenant
+			}
+			return tok, nil
+		},
+		func(_ int) (time.Duration, error) { return time.Hour, nil },
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	requireInvalidRequest := func(resp *logical.Response) {
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+
+		code, ok := backend.ParseErrorCode(resp.Error().Error())
+		require.True(t, ok)
+		require.Equal(t, backend.ErrorCodeInvalidRequest, code)
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, map[string]interface{}{
+		"client_id":                     client.ID,
+		"client_secret":                 client.Secret,
+		"provider":                      "mock",
+		"allowed_read_provider_options": []string{"tenant"},
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.ConfigPath, nil)
+	require.NotNil(t, resp)
+	require.Equal(t, []string{"tenant"}, resp.Data["allowed_read_provider_options"])
+
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"code": "test",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Options that are not allowed are rejected.
+	requireInvalidRequest(handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"provider_options": map[string]interface{}{"region": "eu"},
+	}))
+
+	// The token issued with the options is returned from the read.
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"provider_options": map[string]interface{}{"tenant": "other"},
+	})
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_2@other", resp.Data["access_token"])
+	require.Equal(t, map[string]string{"tenant": "other"}, resp.Data["provider_options"])
+
+	// But it does not replace the stored token.
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_1", resp.Data["access_token"])
+	require.NotContains(t, resp.Data, "provider_options")
+
+	// Provider options can't be combined with a previous version.
+	requireInvalidRequest(handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"provider_options": map[string]interface{}{"tenant": "other"},
+		"version":          1,
+	}))
+}
+
+type authCodePutCountingStorage struct {
+	logical.Storage
+
+	key  string
+	puts int32
+}
+
+func (s *authCodePutCountingStorage) Put(ctx context.Context, se *logical.StorageEntry) error {
+	if se.Key == s.key {
+		atomic.AddInt32(&s.puts, 1)
+	}
+
+	return s.Storage.Put(ctx, se)
+}
+
+func TestCredsUpdateSettingsWrittenWithToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory())
+
+	keyer := persistence.AuthCodeName("test")
+	storage := &authCodePutCountingStorage{
+		Storage: &logical.InmemStorage{},
+		key:     keyer.AuthCodeKey(),
+	}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     "abc",
+			"client_secret": "def",
+			"provider":      "mock",
+		},
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// The creator, reauthorization settings, tuning, and binding are all
+	// stored with the token in a single write.
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		EntityID:  "alice",
+		Data: map[string]interface{}{
+			"grant_type":                    backend.StaticGrantType,
+			"access_token":                  "static",
+			"reauthorize_before_seconds":    3600,
+			"tune_provider_timeout_seconds": 5,
+			"bind":                          "entity",
+		},
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, int32(1), atomic.LoadInt32(&storage.puts))
+
+	entry, err := persistence.NewHolder().Managers(storage).AuthCode().ReadAuthCodeEntry(ctx, keyer)
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	require.Equal(t, "alice", entry.CreatorEntityID)
+	require.Equal(t, "alice", entry.BoundEntityID)
+	require.Equal(t, 3600, entry.ReauthorizeBeforeSeconds)
+	require.NotNil(t, entry.Tuning)
+	require.NotNil(t, entry.Tuning.ProviderTimeoutSeconds)
+	require.Equal(t, 5, *entry.Tuning.ProviderTimeoutSeconds)
 }
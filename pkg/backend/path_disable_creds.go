@@ -0,0 +1,118 @@
+package backend
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+// setCredsDisabled disables or enables the named credential.
+func (b *backend) setCredsDisabled(ctx context.Context, storage logical.Storage, name string, disabled bool) (*logical.Response, error) {
+	var resp *logical.Response
+	err := b.data.Managers(storage).AuthCode().WithLock(persistence.AuthCodeName(name), func(acm *persistence.LockedAuthCodeManager) error {
+		entry, err := acm.ReadAuthCodeEntry(ctx)
+		if err != nil {
+			return err
+		} else if entry == nil {
+			resp = errorResponse(ErrorCodeNotFound, "credential not found")
+			return nil
+		} else if entry.Disabled == disabled {
+			return nil
+		}
+
+		entry.Disabled = disabled
+		if disabled {
+			entry.DisableTime = b.clock.Now()
+		} else {
+			entry.DisableTime = time.Time{}
+		}
+
+		return acm.WriteAuthCodeEntry(ctx, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (b *backend) disableCredsUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.setCredsDisabled(ctx, req.Storage, data.Get("name").(string), true)
+}
+
+func (b *backend) enableCredsUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return b.setCredsDisabled(ctx, req.Storage, data.Get("name").(string), false)
+}
+
+const (
+	DisableCredsPathPrefix = "disable/" + CredsPathPrefix
+	EnableCredsPathPrefix  = "enable/" + CredsPathPrefix
+)
+
+var disableCredsFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential.",
+	},
+}
+
+const disableCredsHelpSynopsis = `
+Suspends a credential without deleting it.
+`
+
+const disableCredsHelpDescription = `
+This endpoint disables a credential. The stored token is retained,
+but it is not refreshed, reaped, or returned when the credential is
+read until the credential is enabled again using the enable/creds
+endpoint.
+`
+
+const enableCredsHelpSynopsis = `
+Resumes a disabled credential.
+`
+
+const enableCredsHelpDescription = `
+This endpoint enables a credential that was disabled using the
+disable/creds endpoint. The credential is refreshed as usual when it
+is next read.
+`
+
+func pathDisableCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: DisableCredsPathPrefix + nameRegex("name") + `$`,
+		Fields:  disableCredsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.withCredBinding(b.disableCredsUpdateOperation),
+				Summary:                     "Disable a credential.",
+				Responses:                   credsStateResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(disableCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(disableCredsHelpDescription),
+	}
+}
+
+func pathEnableCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: EnableCredsPathPrefix + nameRegex("name") + `$`,
+		Fields:  disableCredsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.withCredBinding(b.enableCredsUpdateOperation),
+				Summary:                     "Enable a disabled credential.",
+				Responses:                   credsStateResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(enableCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(enableCredsHelpDescription),
+	}
+}
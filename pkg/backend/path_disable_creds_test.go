@@ -0,0 +1,112 @@
+package backend_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisableCreds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	var exchanges int32
+	exchange := testutil.AmendTokenMockAuthCodeExchange(
+		testutil.RefreshableMockAuthCodeExchange(
+			testutil.IncrementMockAuthCodeExchange("token_"),
+			func(_ int) (time.Duration, error) { return time.Minute, nil },
+		),
+		func(_ *provider.Token) error {
+			atomic.AddInt32(&exchanges, 1)
+			return nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	requireCode := func(resp *logical.Response, expected backend.ErrorCode) {
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+
+		code, ok := backend.ParseErrorCode(resp.Error().Error())
+		require.True(t, ok)
+		assert.Equal(t, expected, code)
+	}
+
+	// Write configuration.
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, map[string]interface{}{
+		"client_id":     client.ID,
+		"client_secret": client.Secret,
+		"provider":      "mock",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Credentials must exist to be disabled.
+	requireCode(handle(logical.UpdateOperation, backend.DisableCredsPathPrefix+`test`, nil), backend.ErrorCodeNotFound)
+
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"code": "123456",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, int32(1), atomic.LoadInt32(&exchanges))
+
+	resp = handle(logical.UpdateOperation, backend.DisableCredsPathPrefix+`test`, nil)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Reads are blocked and the token is not refreshed.
+	requireCode(handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"minimum_seconds": 120,
+	}), backend.ErrorCodeDisabled)
+	requireCode(handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"version": 1,
+	}), backend.ErrorCodeDisabled)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&exchanges))
+
+	// Writing a new token does not enable the credential.
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"code": "123456",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	requireCode(handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, nil), backend.ErrorCodeDisabled)
+
+	resp = handle(logical.UpdateOperation, backend.EnableCredsPathPrefix+`test`, nil)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "token_2", resp.Data["access_token"])
+}
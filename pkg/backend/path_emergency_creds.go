@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+func (b *backend) emergencyCredsUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ttl := time.Duration(data.Get("ttl_seconds").(int)) * time.Second
+	if ttl <= 0 {
+		return errorResponse(ErrorCodeInvalidRequest, "ttl_seconds must be positive"), nil
+	}
+
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	}
+
+	// There is no standard way to ask the provider for a shorter lifetime,
+	// so we must be able to revoke the token before issuing it.
+	ops, err := revocationOperations(c)
+	if err != nil {
+		return nil, err
+	} else if ops == nil {
+		return errorResponse(ErrorCodeUnsupported, "provider %q does not support token revocation", c.Config.ProviderName), nil
+	}
+
+	name := data.Get("name").(string)
+	keyer := persistence.AuthCodeName(name)
+
+	entry, err := b.data.Managers(req.Storage).AuthCode().ReadAuthCodeEntry(ctx, keyer)
+	switch {
+	case err != nil:
+		return nil, err
+	case entry == nil:
+		return nil, nil
+	case entry.Disabled:
+		return errorResponse(ErrorCodeDisabled, "credential is disabled"), nil
+	case !entry.TokenIssued():
+		return errorResponse(ErrorCodeTokenPending, "token pending issuance"), nil
+	case !entry.Refreshable() || entry.JWTBearer != nil:
+		return errorResponse(ErrorCodeInvalidRequest, "emergency access tokens can only be issued for a credential that has a refresh token"), nil
+	}
+
+	tok, err := b.issueCredTokenWithOptions(ctx, req.Storage, keyer, nil)
+	switch {
+	case err == ErrNotConfigured:
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	case err == ErrMaintenanceMode:
+		return errorResponse(ErrorCodeMaintenance, "requests to the provider are paused for maintenance"), nil
+	case err != nil:
+		if resp := providerErrorResponse(err, "token request failed"); resp != nil {
+			return resp, nil
+		}
+		return nil, err
+	case tok == nil:
+		return nil, nil
+	}
+
+	expireTime := b.clock.Now().Add(ttl)
+	if tok.Expiry.IsZero() || tok.Expiry.After(expireTime) {
+		revocationKeyer := persistence.RevocationName(tok.AccessToken)
+		err := b.data.Managers(req.Storage).Revocation().WriteRevocationEntry(ctx, revocationKeyer, &persistence.RevocationEntry{
+			CredentialName:  name,
+			AccessToken:     tok.AccessToken,
+			ProviderOptions: tok.ProviderOptions,
+			RevokeTime:      expireTime,
+			ExpireTime:      tok.Expiry,
+		})
+		if err != nil {
+			// The token is never returned, so make a best effort to get rid
+			// of it now.
+			if rerr := ops.RevokeToken(clockctx.WithClock(ctx, b.clock), tok); rerr != nil {
+				b.logger.Error("failed to revoke unscheduled emergency access token", "credential", name, "error", rerr)
+			}
+			return nil, err
+		}
+
+		b.revocationSchedule.Set(revocationKeyer, expireTime)
+	} else {
+		expireTime = tok.Expiry
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"access_token": tok.AccessToken,
+			"type":         tok.Type(),
+			"expire_time":  expireTime,
+		},
+	}
+	return resp, nil
+}
+
+const (
+	EmergencyCredsPathPrefix = "emergency/" + CredsPathPrefix
+)
+
+var emergencyCredsFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential.",
+	},
+	"ttl_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Specifies the maximum number of seconds the access token may be used for.",
+		Required:    true,
+	},
+}
+
+const emergencyCredsHelpSynopsis = `
+Issues a short-lived access token for a credential.
+`
+
+const emergencyCredsHelpDescription = `
+This endpoint uses the refresh token of a credential to issue a new
+access token that is valid for at most the given TTL, for break-glass
+access that must expire automatically. The token is not stored with
+the credential. OAuth 2.0 has no standard way to request a shorter
+token lifetime, so if the provider would let the token live longer
+than the TTL, it is revoked using the provider's token revocation
+endpoint when the TTL elapses. Providers that cannot revoke tokens are
+not supported.
+`
+
+func pathEmergencyCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: EmergencyCredsPathPrefix + nameRegex("name") + `$`,
+		Fields:  emergencyCredsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:  b.withCredBinding(b.emergencyCredsUpdateOperation),
+				Summary:   "Issue an access token for this credential that is revoked after the given TTL.",
+				Responses: emergencyCredsResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(emergencyCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(emergencyCredsHelpDescription),
+	}
+}
@@ -0,0 +1,270 @@
+package backend_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/retry"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmergencyCreds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exchange := testutil.RefreshableMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(_ int) (time.Duration, error) { return time.Hour, nil },
+	)
+
+	var mut sync.Mutex
+	revoked := make(map[string]bool)
+	revoke := func(tok *provider.Token) error {
+		mut.Lock()
+		defer mut.Unlock()
+
+		revoked[tok.AccessToken] = true
+		return nil
+	}
+	isRevoked := func(token string) bool {
+		mut.Lock()
+		defer mut.Unlock()
+
+		return revoked[token]
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, exchange),
+		testutil.MockWithRevokeToken(revoke),
+	))
+	pr.MustRegister("mock-without-revocation", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, exchange),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	configure := func(providerName string) {
+		resp := handle(logical.UpdateOperation, backend.ConfigPath, map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      providerName,
+		})
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	configure("mock")
+
+	resp := handle(logical.UpdateOperation, backend.CredsPathPrefix+"test", map[string]interface{}{
+		"code": "test",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"test", nil)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	stored := resp.Data["access_token"]
+
+	// A TTL shorter than the token lifetime causes the token to be revoked.
+	now := time.Now()
+	resp = handle(logical.UpdateOperation, backend.EmergencyCredsPathPrefix+"test", map[string]interface{}{
+		"ttl_seconds": 1,
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	token := resp.Data["access_token"].(string)
+	assert.NotEqual(t, stored, token)
+	assert.WithinDuration(t, now.Add(time.Second), resp.Data["expire_time"].(time.Time), 500*time.Millisecond)
+	assert.False(t, isRevoked(token))
+
+	require.NoError(t, retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		if !isRevoked(token) {
+			return retry.Repeat(fmt.Errorf("token %q not revoked", token))
+		}
+
+		return retry.Done(nil)
+	}))
+
+	// The stored token is not affected.
+	assert.False(t, isRevoked(stored.(string)))
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"test", nil)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, stored, resp.Data["access_token"])
+
+	// A TTL longer than the token lifetime needs no revocation.
+	resp = handle(logical.UpdateOperation, backend.EmergencyCredsPathPrefix+"test", map[string]interface{}{
+		"ttl_seconds": 7200,
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	assert.WithinDuration(t, time.Now().Add(time.Hour), resp.Data["expire_time"].(time.Time), time.Minute)
+
+	keys, err := storage.List(ctx, "revocations/")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	// Missing credentials are not found.
+	resp = handle(logical.UpdateOperation, backend.EmergencyCredsPathPrefix+"missing", map[string]interface{}{
+		"ttl_seconds": 60,
+	})
+	require.Nil(t, resp)
+
+	// Providers that cannot revoke tokens are not supported.
+	configure("mock-without-revocation")
+
+	resp = handle(logical.UpdateOperation, backend.EmergencyCredsPathPrefix+"test", map[string]interface{}{
+		"ttl_seconds": 60,
+	})
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	code, ok := backend.ParseErrorCode(resp.Error().Error())
+	require.True(t, ok)
+	assert.Equal(t, backend.ErrorCodeUnsupported, code)
+}
+
+type revocationListCountingStorage struct {
+	logical.Storage
+
+	mut   sync.Mutex
+	lists int
+}
+
+func (s *revocationListCountingStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	if prefix == "revocations/" {
+		s.mut.Lock()
+		s.lists++
+		s.mut.Unlock()
+	}
+
+	return s.Storage.List(ctx, prefix)
+}
+
+func (s *revocationListCountingStorage) Lists() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	return s.lists
+}
+
+func TestEmergencyCredsRevokedAfterRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exchange := testutil.RefreshableMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(_ int) (time.Duration, error) { return time.Hour, nil },
+	)
+
+	revoked := make(chan string, 1)
+	revoke := func(tok *provider.Token) error {
+		select {
+		case revoked <- tok.AccessToken:
+		default:
+		}
+		return nil
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, exchange),
+		testutil.MockWithRevokeToken(revoke),
+	))
+
+	storage := &revocationListCountingStorage{Storage: &logical.InmemStorage{}}
+
+	// Issue the token using a backend that is shut down before it needs to be
+	// revoked, like a node that fails over.
+	prev := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, prev.Setup(ctx, &logical.BackendConfig{}))
+
+	var token string
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigPath,
+			Data: map[string]interface{}{
+				"client_id":     client.ID,
+				"client_secret": client.Secret,
+				"provider":      "mock",
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + "test",
+			Data: map[string]interface{}{
+				"code": "test",
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.EmergencyCredsPathPrefix + "test",
+			Data: map[string]interface{}{
+				"ttl_seconds": 1,
+			},
+		},
+	} {
+		req.Storage = storage
+
+		resp, err := prev.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		if resp != nil {
+			token, _ = resp.Data["access_token"].(string)
+		}
+	}
+	require.NotEmpty(t, token)
+
+	prev.Clean(ctx)
+
+	// The new backend finds the token in storage and revokes it when it is
+	// due.
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	select {
+	case tok := <-revoked:
+		assert.Equal(t, token, tok)
+	case <-ctx.Done():
+		require.Fail(t, "context expired waiting for token revocation")
+	}
+
+	// Storage is only listed when the schedule is rebuilt, not every time
+	// the revoker wakes up.
+	lists := storage.Lists()
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal(t, lists, storage.Lists())
+}
@@ -0,0 +1,109 @@
+package backend
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// tokenFingerprint computes a keyed digest of the given token that can be
+// used to identify it without revealing it. Unlike a plain digest, it cannot
+// be used to confirm a guess of the token without access to the plugin.
+func (b *backend) tokenFingerprint(ctx context.Context, storage logical.Storage, tok string) (string, error) {
+	key, err := b.data.Managers(storage).Fingerprint().ReadOrCreateKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(tok))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// addTokenFingerprint adds the fingerprint of the access token in the given
+// response data.
+func (b *backend) addTokenFingerprint(ctx context.Context, storage logical.Storage, rd map[string]interface{}) error {
+	tok, ok := rd["access_token"].(string)
+	if !ok || tok == "" {
+		return nil
+	}
+
+	fp, err := b.tokenFingerprint(ctx, storage, tok)
+	if errors.Is(err, logical.ErrReadOnly) {
+		// The key has not been created yet and can't be created on this
+		// node, so there are no fingerprints to correlate with anyway.
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	rd["access_token_fingerprint"] = fp
+	return nil
+}
+
+func (b *backend) fingerprintUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	tok, ok := data.GetOk("token")
+	if !ok || tok.(string) == "" {
+		return errorResponse(ErrorCodeInvalidRequest, "missing token"), nil
+	}
+
+	fp, err := b.tokenFingerprint(ctx, req.Storage, tok.(string))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"fingerprint": fp,
+		},
+	}
+	return resp, nil
+}
+
+const (
+	FingerprintPath = "fingerprint"
+)
+
+var fingerprintFields = map[string]*framework.FieldSchema{
+	"token": {
+		Type:        framework.TypeString,
+		Description: "Specifies the access token to compute the fingerprint of.",
+	},
+}
+
+const fingerprintHelpSynopsis = `
+Computes the fingerprint of an access token.
+`
+
+const fingerprintHelpDescription = `
+Reading a credential returns a fingerprint of its access token, a
+keyed digest that is unique to this mount. If an access token is
+found somewhere it should not be, for example in a log file, write it
+to this endpoint to compute its fingerprint and compare the result
+with the fingerprints of the credentials on the mount to find out
+which credential it belongs to.
+`
+
+func pathFingerprint(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: FingerprintPath + `$`,
+		Fields:  fingerprintFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.fingerprintUpdateOperation,
+				Summary:                     "Compute the fingerprint of an access token.",
+				Responses:                   fingerprintResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(fingerprintHelpSynopsis),
+		HelpDescription: strings.TrimSpace(fingerprintHelpDescription),
+	}
+}
@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+// credHistoryEvent is a request to the provider that is recorded in the
+// history of a credential.
+type credHistoryEvent string
+
+const (
+	credHistoryEventExchanged      credHistoryEvent = "exchanged"
+	credHistoryEventExchangeFailed credHistoryEvent = "exchange-failed"
+	credHistoryEventRefreshed      credHistoryEvent = "refreshed"
+	credHistoryEventRefreshFailed  credHistoryEvent = "refresh-failed"
+)
+
+// recordCredHistory adds an event to the history of the given credential,
+// including the status code of the last provider response recorded in it. For
+// a failed request, the message should be the same one reported to readers of
+// the credential so that the history doesn't reveal anything more. The caller
+// must store the credential.
+func (b *backend) recordCredHistory(c *cache, entry *persistence.AuthCodeEntry, event credHistoryEvent, msg string) {
+	entry.RecordHistory(&persistence.AuthCodeHistoryEntry{
+		Time:                 b.clock.Now(),
+		Event:                string(event),
+		Error:                msg,
+		ProviderResponseCode: entry.LastProviderResponseCode,
+	}, c.Config.Tuning.MaxCredentialHistory)
+}
+
+func (b *backend) historyCredsReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.data.Managers(req.Storage).AuthCode().ReadAuthCodeEntry(ctx, persistence.AuthCodeName(data.Get("name").(string)))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	events := make([]interface{}, len(entry.History))
+	for i, he := range entry.History {
+		event := map[string]interface{}{
+			"time":  he.Time,
+			"event": he.Event,
+		}
+		if he.Error != "" {
+			event["error"] = he.Error
+		}
+		if he.ProviderResponseCode != 0 {
+			event["provider_response_code"] = he.ProviderResponseCode
+		}
+
+		events[i] = event
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"events": events,
+		},
+	}
+	return resp, nil
+}
+
+const (
+	HistoryCredsPathPrefix = "history/" + CredsPathPrefix
+)
+
+var historyCredsFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential.",
+	},
+}
+
+const historyCredsHelpSynopsis = `
+Reports recent token exchanges and refreshes of a credential.
+`
+
+const historyCredsHelpDescription = `
+This endpoint returns the outcomes of the most recent requests made to
+the provider to issue or refresh the token of a credential, most recent
+first, including any error and the HTTP status code of the provider
+response. Tokens are never included. The number of events retained is
+controlled by the tune_max_credential_history configuration option.
+`
+
+func pathHistoryCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: HistoryCredsPathPrefix + nameRegex("name") + `$`,
+		Fields:  historyCredsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.withCredBinding(b.historyCredsReadOperation),
+				Summary:   "Get the history of a credential.",
+				Responses: historyCredsResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(historyCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(historyCredsHelpDescription),
+	}
+}
@@ -0,0 +1,121 @@
+package backend_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryCreds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	// The first refresh fails because the provider is unavailable.
+	exchange := testutil.RefreshableMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(i int) (time.Duration, error) {
+			if i == 2 {
+				return 0, testutil.MockErrorResponse(http.StatusServiceUnavailable, nil)
+			}
+			return time.Minute, nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	events := func() []interface{} {
+		resp := handle(logical.ReadOperation, backend.HistoryCredsPathPrefix+`test`, nil)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+		return resp.Data["events"].([]interface{})
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, map[string]interface{}{
+		"client_id":                   client.ID,
+		"client_secret":               client.Secret,
+		"provider":                    "mock",
+		"tune_max_credential_history": 2,
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Credentials that don't exist have no history.
+	assert.Nil(t, handle(logical.ReadOperation, backend.HistoryCredsPathPrefix+`test`, nil))
+
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"code": "123456",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	history := events()
+	require.Len(t, history, 1)
+	assert.Equal(t, "exchanged", history[0].(map[string]interface{})["event"])
+
+	// Force a refresh, which fails.
+	handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"minimum_seconds": 120,
+	})
+
+	history = events()
+	require.Len(t, history, 2)
+
+	failed := history[0].(map[string]interface{})
+	assert.Equal(t, "refresh-failed", failed["event"])
+	assert.Contains(t, failed["error"], "refresh failed")
+	assert.Equal(t, http.StatusServiceUnavailable, failed["provider_response_code"])
+	assert.Equal(t, "exchanged", history[1].(map[string]interface{})["event"])
+
+	// The next refresh succeeds, and the oldest event is discarded.
+	handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"minimum_seconds": 120,
+	})
+
+	history = events()
+	require.Len(t, history, 2)
+	assert.Equal(t, "refreshed", history[0].(map[string]interface{})["event"])
+	assert.NotContains(t, history[0], "error")
+	assert.Equal(t, "refresh-failed", history[1].(map[string]interface{})["event"])
+
+	// The history is kept when the credential is replaced.
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{
+		"code": "123456",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	history = events()
+	require.Len(t, history, 2)
+	assert.Equal(t, "exchanged", history[0].(map[string]interface{})["event"])
+	assert.Equal(t, "refreshed", history[1].(map[string]interface{})["event"])
+}
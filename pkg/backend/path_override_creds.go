@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	OverrideCredsPathPrefix = "override/" + CredsPathPrefix
+)
+
+const overrideCredsHelpSynopsis = `
+Manages credentials regardless of the identity they are bound to.
+`
+
+const overrideCredsHelpDescription = `
+This endpoint behaves like the creds endpoint, but allows operators to
+read, write, and delete credentials that are bound to another identity
+entity or token. Grant access to it only to policies that should be
+able to override the binding of any credential.
+`
+
+func pathOverrideCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: OverrideCredsPathPrefix + nameRegex("name") + `$`,
+		Fields:  credsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.credsReadOperation,
+				Summary:   "Get a current access token for this credential, even if it is bound to another identity.",
+				Responses: credsReadResponses,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.credsUpdateOperation,
+				Summary:                     "Write a credential, even if it is bound to another identity.",
+				Responses:                   credsWriteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback:                    b.credsDeleteOperation,
+				Summary:                     "Remove a credential, even if it is bound to another identity.",
+				Responses:                   credsDeleteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(overrideCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(overrideCredsHelpDescription),
+	}
+}
@@ -0,0 +1,230 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredsBinding(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory())
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path, entityID, accessor string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(ctx, &logical.Request{
+			Operation:           op,
+			Path:                path,
+			Storage:             storage,
+			Data:                data,
+			EntityID:            entityID,
+			ClientTokenAccessor: accessor,
+		})
+	}
+
+	resp, err := handle(logical.UpdateOperation, backend.ConfigPath, "", "", map[string]interface{}{
+		"client_id":     "abc",
+		"client_secret": "def",
+		"provider":      "mock",
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// A token without an entity can't bind a credential to one.
+	resp, err = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, "", "accessor-a", map[string]interface{}{
+		"grant_type":   backend.StaticGrantType,
+		"access_token": "static",
+		"bind":         "entity",
+	})
+	require.NoError(t, err)
+	require.True(t, resp != nil && resp.IsError())
+
+	resp, err = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, "alice", "accessor-a", map[string]interface{}{
+		"grant_type":   backend.StaticGrantType,
+		"access_token": "static",
+		"bind":         "entity",
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// The same entity can read the credential using any token.
+	resp, err = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, "alice", "accessor-b", nil)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, "static", resp.Data["access_token"])
+	assert.Equal(t, "alice", resp.Data["bound_entity_id"])
+
+	// Other entities can't read, replace, or delete it.
+	_, err = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, "bob", "accessor-c", nil)
+	require.ErrorIs(t, err, logical.ErrPermissionDenied)
+
+	_, err = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, "bob", "accessor-c", map[string]interface{}{
+		"grant_type":   backend.StaticGrantType,
+		"access_token": "stolen",
+	})
+	require.ErrorIs(t, err, logical.ErrPermissionDenied)
+
+	_, err = handle(logical.DeleteOperation, backend.CredsPathPrefix+`test`, "bob", "accessor-c", nil)
+	require.ErrorIs(t, err, logical.ErrPermissionDenied)
+
+	// Writing the credential again retains the binding, unless it is
+	// changed.
+	resp, err = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, "alice", "accessor-a", map[string]interface{}{
+		"grant_type":   backend.StaticGrantType,
+		"access_token": "static2",
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	_, err = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, "bob", "accessor-c", nil)
+	require.ErrorIs(t, err, logical.ErrPermissionDenied)
+
+	resp, err = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, "alice", "accessor-a", map[string]interface{}{
+		"grant_type":   backend.StaticGrantType,
+		"access_token": "static3",
+		"bind":         "accessor",
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	_, err = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, "alice", "accessor-b", nil)
+	require.ErrorIs(t, err, logical.ErrPermissionDenied)
+
+	resp, err = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, "", "accessor-a", nil)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, "static3", resp.Data["access_token"])
+
+	// Operators can override the binding.
+	resp, err = handle(logical.ReadOperation, backend.OverrideCredsPathPrefix+`test`, "", "operator", nil)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, "static3", resp.Data["access_token"])
+	assert.Equal(t, "accessor-a", resp.Data["bound_token_accessor"])
+
+	resp, err = handle(logical.DeleteOperation, backend.OverrideCredsPathPrefix+`test`, "", "operator", nil)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	resp, err = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, "", "accessor-a", nil)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
+
+func TestCredsBindingOtherEndpoints(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory())
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path, entityID string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(ctx, &logical.Request{
+			Operation:           op,
+			Path:                path,
+			Storage:             storage,
+			Data:                data,
+			EntityID:            entityID,
+			ClientTokenAccessor: "accessor-" + entityID,
+		})
+	}
+
+	resp, err := handle(logical.UpdateOperation, backend.ConfigPath, "", map[string]interface{}{
+		"client_id":     "abc",
+		"client_secret": "def",
+		"provider":      "mock",
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp, err = handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, "alice", map[string]interface{}{
+		"grant_type":   backend.StaticGrantType,
+		"access_token": "static",
+		"bind":         "entity",
+	})
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	tests := []struct {
+		Name      string
+		Operation logical.Operation
+		Path      string
+		Data      map[string]interface{}
+	}{
+		{
+			Name:      "User info",
+			Operation: logical.ReadOperation,
+			Path:      backend.UserInfoCredsPathPrefix + `test`,
+		},
+		{
+			Name:      "History",
+			Operation: logical.ReadOperation,
+			Path:      backend.HistoryCredsPathPrefix + `test`,
+		},
+		{
+			Name:      "Rollback",
+			Operation: logical.UpdateOperation,
+			Path:      backend.RollbackCredsPathPrefix + `test`,
+			Data:      map[string]interface{}{"version": 1},
+		},
+		{
+			Name:      "Rename",
+			Operation: logical.UpdateOperation,
+			Path:      backend.RenameCredsPathPrefix + `test`,
+			Data:      map[string]interface{}{"new_name": "stolen"},
+		},
+		{
+			Name:      "Disable",
+			Operation: logical.UpdateOperation,
+			Path:      backend.DisableCredsPathPrefix + `test`,
+		},
+		{
+			Name:      "Enable",
+			Operation: logical.UpdateOperation,
+			Path:      backend.EnableCredsPathPrefix + `test`,
+		},
+		{
+			Name:      "Authorization code URL",
+			Operation: logical.UpdateOperation,
+			Path:      backend.AuthCodeURLCredsPathPrefix + `test`,
+			Data:      map[string]interface{}{"redirect_url": "http://example.com/redirect"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			_, err := handle(test.Operation, test.Path, "bob", test.Data)
+			require.ErrorIs(t, err, logical.ErrPermissionDenied)
+		})
+	}
+
+	// None of the requests changed the credential.
+	resp, err = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, "alice", nil)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, "static", resp.Data["access_token"])
+
+	resp, err = handle(logical.ReadOperation, backend.HistoryCredsPathPrefix+`test`, "alice", nil)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+}
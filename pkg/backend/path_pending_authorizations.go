@@ -0,0 +1,225 @@
+package backend
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+)
+
+func (b *backend) pendingAuthorizationsListOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	acm := b.data.Managers(req.Storage).AuthCode()
+
+	var keyers []persistence.AuthCodeKeyer
+	if err := acm.ForEachPendingAuthorizationKey(ctx, func(keyer persistence.AuthCodeKeyer) {
+		keyers = append(keyers, keyer)
+	}); err != nil {
+		return nil, err
+	}
+
+	now := b.clock.Now()
+	within := time.Duration(data.Get("expiring_within_seconds").(int)) * time.Second
+
+	keyInfo := make(map[string]interface{}, len(keyers))
+	for _, keyer := range keyers {
+		entry, err := acm.ReadPendingAuthorizationEntry(ctx, keyer)
+		if err != nil {
+			return nil, err
+		} else if entry == nil || entry.Name == "" {
+			// Credentials written before names were recorded can't be
+			// listed.
+			continue
+		}
+
+		// Credentials whose refresh token expires later are only included
+		// if requested.
+		expiring := within > 0 && !entry.RefreshTokenExpireTime.IsZero() && !entry.RefreshTokenExpireTime.After(now.Add(within))
+		if !entry.Due(now) && !expiring {
+			continue
+		}
+
+		keyInfo[entry.Name] = pendingAuthorizationData(entry, now)
+	}
+
+	keys := make([]string, 0, len(keyInfo))
+	for name := range keyInfo {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+func (b *backend) pendingAuthorizationsReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.data.Managers(req.Storage).AuthCode().ReadPendingAuthorizationEntry(ctx, persistence.AuthCodeName(data.Get("name").(string)))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	rd := pendingAuthorizationData(entry, b.clock.Now())
+	rd["name"] = data.Get("name").(string)
+
+	resp := &logical.Response{
+		Data: rd,
+	}
+	return resp, nil
+}
+
+// pendingAuthorizationData describes why and when a credential must be
+// authorized again.
+func pendingAuthorizationData(entry *persistence.PendingAuthorizationEntry, now time.Time) map[string]interface{} {
+	rd := map[string]interface{}{
+		"reason":              entry.Reason,
+		"reauthorization_due": entry.Due(now),
+	}
+
+	if rd["reason"] == "" {
+		rd["reason"] = "refresh token will expire"
+	}
+
+	if t := entry.DueTime(); !t.IsZero() {
+		rd["time"] = t
+	}
+
+	if !entry.RefreshTokenExpireTime.IsZero() {
+		rd["refresh_token_expire_time"] = entry.RefreshTokenExpireTime
+	}
+
+	return rd
+}
+
+func (b *backend) pendingAuthorizationsUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	keyer := persistence.AuthCodeName(name)
+
+	pae, err := b.data.Managers(req.Storage).AuthCode().ReadPendingAuthorizationEntry(ctx, keyer)
+	if err != nil {
+		return nil, err
+	} else if pae == nil {
+		return errorResponse(ErrorCodeNotFound, "credential %q does not require authorization", name), nil
+	}
+
+	raw := map[string]interface{}{}
+	for _, field := range []string{"auth_url_params", "redirect_url", "scopes", "resources", "provider_options", "state_ttl_seconds"} {
+		if v, ok := data.Raw[field]; ok {
+			raw[field] = v
+		}
+	}
+
+	entry, err := b.data.Managers(req.Storage).AuthCode().ReadAuthCodeEntry(ctx, keyer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unless overridden, we reuse the provider options of the credential
+	// (e.g., a tenant) so the user authorizes the same way as before.
+	if _, ok := raw["provider_options"]; !ok {
+		if entry != nil && entry.Token != nil && len(entry.ProviderOptions) > 0 {
+			po := make(map[string]string, len(entry.ProviderOptions))
+			for k, v := range entry.ProviderOptions {
+				if k != provider.NonceProviderOption {
+					po[k] = v
+				}
+			}
+			raw["provider_options"] = po
+		}
+	}
+
+	// Likewise for the resources the credential requests.
+	if _, ok := raw["resources"]; !ok && entry != nil && len(entry.Resources) > 0 {
+		raw["resources"] = entry.Resources
+	}
+
+	return b.authCodeURL(ctx, req, &framework.FieldData{
+		Raw:    raw,
+		Schema: authCodeURLCredsFields,
+	}, name, true)
+}
+
+const (
+	PendingAuthorizationsPathPrefix = "pending-authorizations/"
+)
+
+var pendingAuthorizationsFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential.",
+	},
+	"auth_url_params":   configAuthCodeURLFields["auth_url_params"],
+	"redirect_url":      configAuthCodeURLFields["redirect_url"],
+	"scopes":            configAuthCodeURLFields["scopes"],
+	"state_ttl_seconds": authCodeURLCredsFields["state_ttl_seconds"],
+	"resources": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the RFC 8707 resource indicators to request. Defaults to the resources of the credential.",
+	},
+	"provider_options": {
+		Type:        framework.TypeKVPairs,
+		Description: "Specifies any provider-specific options. Defaults to the options used to issue the credential.",
+	},
+}
+
+var pendingAuthorizationsListFields = map[string]*framework.FieldSchema{
+	"expiring_within_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Also list credentials whose refresh token expires within this many seconds.",
+	},
+}
+
+const pendingAuthorizationsHelpSynopsis = `
+Lists credentials that must be authorized again.
+`
+
+const pendingAuthorizationsHelpDescription = `
+When the provider permanently rejects a credential, for example
+because its refresh token was revoked, the credential is listed here
+until it is authorized again or deleted. Credentials whose refresh
+token has a known lifetime are also listed once it is time to
+authorize them again. Writing to a credential generates an
+authorization code URL bound to it that the user can visit to
+authorize the application again.
+`
+
+func pathPendingAuthorizationsList(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: PendingAuthorizationsPathPrefix + `?$`,
+		Fields:  pendingAuthorizationsListFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback:  b.pendingAuthorizationsListOperation,
+				Summary:   "List credentials that must be authorized again.",
+				Responses: pendingAuthorizationsListResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(pendingAuthorizationsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(pendingAuthorizationsHelpDescription),
+	}
+}
+
+func pathPendingAuthorizations(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: PendingAuthorizationsPathPrefix + nameRegex("name") + `$`,
+		Fields:  pendingAuthorizationsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.pendingAuthorizationsReadOperation,
+				Summary:   "Get the reason a credential must be authorized again.",
+				Responses: pendingAuthorizationsReadResponses,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.pendingAuthorizationsUpdateOperation,
+				Summary:                     "Generate an authorization code URL to authorize a credential again.",
+				Responses:                   pendingAuthorizationsUpdateResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(pendingAuthorizationsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(pendingAuthorizationsHelpDescription),
+	}
+}
@@ -0,0 +1,375 @@
+package backend_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/interop"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	testclock "k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestPendingAuthorizations(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exchange := testutil.RotatingMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(i int) (time.Duration, error) {
+			if i == 2 {
+				// The refresh token has been revoked.
+				return 0, testutil.MockErrorResponse(http.StatusBadRequest, &interop.JSONError{Error: "invalid_grant"})
+			}
+
+			return time.Minute, nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(req *logical.Request) *logical.Response {
+		req.Storage = storage
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	list := func() []interface{} {
+		resp := handle(&logical.Request{
+			Operation: logical.ListOperation,
+			Path:      backend.PendingAuthorizationsPathPrefix,
+		})
+		require.NotNil(t, resp)
+
+		keys, _ := resp.Data["keys"].([]string)
+		out := make([]interface{}, len(keys))
+		for i, key := range keys {
+			out[i] = key
+		}
+		return out
+	}
+
+	// Write configuration.
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	})
+
+	// Write our credential.
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Data: map[string]interface{}{
+			"code": "123456",
+		},
+	})
+	assert.Empty(t, list())
+
+	// Force a refresh, which the provider rejects.
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"minimum_seconds": 120,
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+
+	assert.Equal(t, []interface{}{"test"}, list())
+
+	resp = handle(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.PendingAuthorizationsPathPrefix + `test`,
+	})
+	require.NotNil(t, resp)
+	assert.Equal(t, "test", resp.Data["name"])
+	assert.Contains(t, resp.Data["reason"], "invalid_grant")
+	assert.NotEmpty(t, resp.Data["time"])
+
+	// Generate a URL to authorize the credential again.
+	resp = handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.PendingAuthorizationsPathPrefix + `test`,
+		Data: map[string]interface{}{
+			"redirect_url": "http://example.com/redirect",
+		},
+	})
+	require.NotNil(t, resp)
+	assert.Contains(t, resp.Data["url"], testutil.MockAuthCodeURL)
+	require.NotEmpty(t, resp.Data["state"])
+
+	// Completing the exchange removes the credential from the list.
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Data: map[string]interface{}{
+			"code":         "654321",
+			"state":        resp.Data["state"],
+			"redirect_url": "http://example.com/redirect",
+		},
+	})
+	assert.Empty(t, list())
+
+	// Credentials that don't need authorization can't be written.
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.PendingAuthorizationsPathPrefix + `test`,
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.True(t, resp.IsError())
+}
+
+// expiringRefreshTokenMockAuthCodeExchange issues refresh tokens that the
+// provider reports will expire after the given duration.
+func expiringRefreshTokenMockAuthCodeExchange(expiresIn time.Duration) testutil.MockAuthCodeExchangeFunc {
+	return testutil.AmendTokenMockAuthCodeExchange(
+		testutil.RefreshableMockAuthCodeExchange(
+			testutil.IncrementMockAuthCodeExchange("token_"),
+			func(_ int) (time.Duration, error) { return time.Hour, nil },
+		),
+		func(tok *provider.Token) error {
+			tok.Token = tok.Token.WithExtra(map[string]interface{}{
+				"refresh_token_expires_in": expiresIn.Seconds(),
+			})
+			return nil
+		},
+	)
+}
+
+func TestReauthorizeBefore(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, expiringRefreshTokenMockAuthCodeExchange(2*time.Hour))))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(req *logical.Request) *logical.Response {
+		req.Storage = storage
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	list := func(data map[string]interface{}) map[string]interface{} {
+		resp := handle(&logical.Request{
+			Operation: logical.ListOperation,
+			Path:      backend.PendingAuthorizationsPathPrefix,
+			Data:      data,
+		})
+		require.NotNil(t, resp)
+
+		info, _ := resp.Data["key_info"].(map[string]interface{})
+		return info
+	}
+
+	// Write configuration.
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	})
+
+	// Write our credential, which should be reauthorized an hour before its
+	// refresh token expires.
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Data: map[string]interface{}{
+			"code":                       "123456",
+			"reauthorize_before_seconds": 3600,
+		},
+	})
+
+	resp := handle(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+	})
+	require.NotNil(t, resp)
+	assert.Empty(t, resp.Warnings)
+	expireTime, ok := resp.Data["refresh_token_expire_time"].(time.Time)
+	require.True(t, ok, "unexpected refresh token expiry: %+v", resp.Data["refresh_token_expire_time"])
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), expireTime, time.Minute)
+	assert.Equal(t, expireTime.Add(-time.Hour), resp.Data["reauthorize_time"])
+
+	// The credential is only listed if we ask for upcoming expirations.
+	assert.Empty(t, list(nil))
+
+	info := list(map[string]interface{}{"expiring_within_seconds": 3 * 3600})
+	require.Contains(t, info, "test")
+	assert.Equal(t, false, info["test"].(map[string]interface{})["reauthorization_due"])
+	assert.Equal(t, expireTime, info["test"].(map[string]interface{})["refresh_token_expire_time"])
+
+	// A wider window makes the credential due immediately. The setting is
+	// retained when the credential is written again.
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Data: map[string]interface{}{
+			"code":                       "123456",
+			"reauthorize_before_seconds": 3 * 3600,
+		},
+	})
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Data: map[string]interface{}{
+			"code": "123456",
+		},
+	})
+
+	resp = handle(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+	})
+	require.NotNil(t, resp)
+	require.Len(t, resp.Warnings, 1)
+	assert.Contains(t, resp.Warnings[0], "must be reauthorized")
+
+	info = list(nil)
+	require.Contains(t, info, "test")
+	assert.Equal(t, true, info["test"].(map[string]interface{})["reauthorization_due"])
+}
+
+func TestReauthorizationWebhook(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	notified := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		notified <- body
+	}))
+	defer srv.Close()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, expiringRefreshTokenMockAuthCodeExchange(time.Hour))))
+
+	storage := &logical.InmemStorage{}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock: clock.NewTimerCallbackClock(
+			k8sext.NewClock(clk),
+			func(d time.Duration) {
+				clk.Step(d)
+			},
+		),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":                   client.ID,
+			"client_secret":               client.Secret,
+			"provider":                    "mock",
+			"reauthorization_webhook_url": srv.URL,
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Write a credential that is due for reauthorization immediately.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"code":                       "123456",
+			"reauthorize_before_seconds": 2 * 3600,
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	select {
+	case body := <-notified:
+		assert.Equal(t, "test", body["name"])
+		assert.Equal(t, true, body["reauthorization_due"])
+		assert.NotEmpty(t, body["refresh_token_expire_time"])
+	case <-ctx.Done():
+		require.Fail(t, "context expired waiting for notification")
+	}
+
+	// The notification is only sent once.
+	select {
+	case body := <-notified:
+		require.Fail(t, "unexpected notification", "%+v", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
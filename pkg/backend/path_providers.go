@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+)
+
+// providerSummaryData returns the fields of the given provider description
+// that are included when listing providers.
+func providerSummaryData(info *provider.Info) map[string]interface{} {
+	rd := map[string]interface{}{
+		"flows": providerFlowsData(info.Flows),
+	}
+
+	if info.Version > 0 {
+		rd["version"] = info.Version
+	}
+
+	return rd
+}
+
+func providerFlowsData(flows []provider.Flow) []string {
+	out := make([]string, len(flows))
+	for i, flow := range flows {
+		out[i] = string(flow)
+	}
+	return out
+}
+
+func providerOptionsData(schema provider.OptionSchema) map[string]interface{} {
+	out := make(map[string]interface{}, len(schema))
+	for name, spec := range schema {
+		typ := spec.Type
+		if typ == "" {
+			typ = provider.OptionTypeString
+		}
+
+		od := map[string]interface{}{
+			"type":        string(typ),
+			"description": spec.Description,
+			"required":    spec.Required,
+		}
+
+		if len(spec.Enum) > 0 {
+			od["enum"] = spec.Enum
+		}
+
+		if spec.Default != "" {
+			od["default"] = spec.Default
+		}
+
+		out[name] = od
+	}
+	return out
+}
+
+func (b *backend) providersListOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	names := b.providerRegistry.Names()
+
+	keyInfo := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		info, err := b.providerRegistry.Info(name)
+		if err != nil {
+			return nil, err
+		}
+
+		keyInfo[name] = providerSummaryData(info)
+	}
+
+	return logical.ListResponseWithInfo(names, keyInfo), nil
+}
+
+func (b *backend) providersReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	info, err := b.providerRegistry.Info(data.Get("name").(string))
+	if errors.Is(err, provider.ErrNoSuchProvider) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	rd := providerSummaryData(info)
+	rd["name"] = info.Name
+
+	// Providers that do not publish a schema accept any options.
+	if info.Schema != nil {
+		rd["options"] = providerOptionsData(info.Schema)
+	}
+
+	if info.TokenLifetime > 0 {
+		rd["token_lifetime_seconds"] = int(info.TokenLifetime.Seconds())
+	}
+
+	return &logical.Response{
+		Data: rd,
+	}, nil
+}
+
+const (
+	ProvidersPathPrefix = "providers/"
+)
+
+var providersFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the provider.",
+	},
+}
+
+const providersHelpSynopsis = `
+Describes the providers this plugin supports.
+`
+
+const providersHelpDescription = `
+This endpoint lists the providers built into the plugin and describes
+each one: its latest version, the flows it supports, the provider
+options it accepts, and the usual lifetime of the access tokens it
+issues, where known. Some flows, such as the device code flow, also
+depend on the provider options or on what the server supports.
+`
+
+func pathProvidersList(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ProvidersPathPrefix + `?$`,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback:  b.providersListOperation,
+				Summary:   "List the supported providers.",
+				Responses: providersListResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(providersHelpSynopsis),
+		HelpDescription: strings.TrimSpace(providersHelpDescription),
+	}
+}
+
+func pathProviders(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ProvidersPathPrefix + framework.GenericNameRegex("name") + `$`,
+		Fields:  providersFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.providersReadOperation,
+				Summary:   "Describe a provider.",
+				Responses: providersReadResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(providersHelpSynopsis),
+		HelpDescription: strings.TrimSpace(providersHelpDescription),
+	}
+}
@@ -0,0 +1,105 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(),
+		provider.WithSchema(provider.OptionSchema{
+			"issuer_url": {
+				Type:        provider.OptionTypeURL,
+				Description: "The issuer.",
+				Required:    true,
+			},
+			"mode": {
+				Description: "The mode.",
+				Enum:        []string{"fast", "slow"},
+				Default:     "fast",
+			},
+		}),
+		provider.WithVersion(3),
+		provider.WithFlows{provider.FlowAuthCode, provider.FlowDeviceCode},
+		provider.WithTokenLifetime(time.Hour),
+	)
+	pr.MustRegister("other", testutil.MockFactory())
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Providers can be described before the mount is configured.
+	req := &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      backend.ProvidersPathPrefix,
+		Storage:   storage,
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, []string{"mock", "other"}, resp.Data["keys"])
+	assert.Equal(t, map[string]interface{}{
+		"version": 3,
+		"flows":   []string{"authorization_code", "device_code"},
+	}, resp.Data["key_info"].(map[string]interface{})["mock"])
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ProvidersPathPrefix + "mock",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, "mock", resp.Data["name"])
+	assert.Equal(t, 3, resp.Data["version"])
+	assert.Equal(t, []string{"authorization_code", "device_code"}, resp.Data["flows"])
+	assert.Equal(t, 3600, resp.Data["token_lifetime_seconds"])
+	assert.Equal(t, map[string]interface{}{
+		"issuer_url": map[string]interface{}{
+			"type":        "url",
+			"description": "The issuer.",
+			"required":    true,
+		},
+		"mode": map[string]interface{}{
+			"type":        "string",
+			"description": "The mode.",
+			"required":    false,
+			"enum":        []string{"fast", "slow"},
+			"default":     "fast",
+		},
+	}, resp.Data["options"])
+
+	// Nothing is published about this provider.
+	req.Path = backend.ProvidersPathPrefix + "other"
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, map[string]interface{}{
+		"name":  "other",
+		"flows": []string{},
+	}, resp.Data)
+
+	req.Path = backend.ProvidersPathPrefix + "nope"
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+}
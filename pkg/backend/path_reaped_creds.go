@@ -0,0 +1,210 @@
+package backend
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+func (b *backend) reapedCredsListOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	acm := b.data.Managers(req.Storage).AuthCode()
+
+	var keyers []persistence.AuthCodeKeyer
+	if err := acm.ForEachReapedAuthCodeKey(ctx, func(keyer persistence.AuthCodeKeyer) {
+		keyers = append(keyers, keyer)
+	}); err != nil {
+		return nil, err
+	}
+
+	keyInfo := make(map[string]interface{}, len(keyers))
+	for _, keyer := range keyers {
+		entry, err := acm.ReadReapedAuthCodeEntry(ctx, keyer)
+		if err != nil {
+			return nil, err
+		} else if entry == nil || entry.Name == "" {
+			// Credentials written before names were recorded can't be
+			// listed.
+			continue
+		}
+
+		keyInfo[entry.Name] = reapedCredsData(entry)
+	}
+
+	keys := make([]string, 0, len(keyInfo))
+	for name := range keyInfo {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+func (b *backend) reapedCredsReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.data.Managers(req.Storage).AuthCode().ReadReapedAuthCodeEntry(ctx, persistence.AuthCodeName(data.Get("name").(string)))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	rd := reapedCredsData(entry)
+	rd["name"] = data.Get("name").(string)
+
+	resp := &logical.Response{
+		Data: rd,
+	}
+	return resp, nil
+}
+
+// reapedCredsData describes why and when a credential was reaped.
+func reapedCredsData(entry *persistence.ReapedAuthCodeEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"reason":      entry.Reason,
+		"reap_time":   entry.ReapTime,
+		"expire_time": entry.ExpireTime,
+	}
+}
+
+func (b *backend) reapedCredsDeleteOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := b.data.Managers(req.Storage).AuthCode().WithLock(persistence.AuthCodeName(data.Get("name").(string)), func(acm *persistence.LockedAuthCodeManager) error {
+		return acm.DeleteReapedAuthCodeEntry(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) restoreCredsUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var resp *logical.Response
+	err := b.data.Managers(req.Storage).AuthCode().WithLock(persistence.AuthCodeName(data.Get("name").(string)), func(acm *persistence.LockedAuthCodeManager) error {
+		reaped, err := acm.ReadReapedAuthCodeEntry(ctx)
+		if err != nil {
+			return err
+		} else if reaped == nil || reaped.Entry == nil {
+			resp = errorResponse(ErrorCodeNotFound, "reaped credential not found")
+			return nil
+		}
+
+		existing, err := acm.ReadAuthCodeEntry(ctx)
+		if err != nil {
+			return err
+		} else if existing != nil {
+			resp = errorResponse(ErrorCodeInvalidRequest, "a credential with this name already exists")
+			return nil
+		}
+
+		// The credential still meets the criteria it was reaped for, so we
+		// restore it disabled to keep the reaper from removing it again.
+		entry := reaped.Entry
+		if !entry.Disabled {
+			entry.Disabled = true
+			entry.DisableTime = b.clock.Now()
+		}
+
+		if err := acm.WriteAuthCodeEntry(ctx, entry); err != nil {
+			return err
+		}
+
+		return acm.DeleteReapedAuthCodeEntry(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+const (
+	ReapedCredsPathPrefix  = "reaped/" + CredsPathPrefix
+	RestoreCredsPathPrefix = "restore/" + CredsPathPrefix
+)
+
+var reapedCredsFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential.",
+	},
+}
+
+const reapedCredsHelpSynopsis = `
+Lists credentials removed by the reaper that can still be restored.
+`
+
+const reapedCredsHelpDescription = `
+If the tune_reap_quarantine_seconds configuration option is set,
+credentials removed by the reaper are retained here for that long
+before they are permanently deleted. Each entry records why and when
+the credential was reaped. Deleting an entry permanently deletes the
+credential immediately.
+`
+
+const restoreCredsHelpSynopsis = `
+Restores a credential removed by the reaper.
+`
+
+const restoreCredsHelpDescription = `
+This endpoint moves a quarantined credential back to the creds
+endpoint. Because the credential still meets the criteria it was
+reaped for, it is restored disabled. Enable it using the enable/creds
+endpoint once it has been fixed, for example by authorizing it again.
+`
+
+func pathReapedCredsList(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ReapedCredsPathPrefix + `?$`,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback:  b.reapedCredsListOperation,
+				Summary:   "List credentials removed by the reaper.",
+				Responses: reapedCredsListResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(reapedCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(reapedCredsHelpDescription),
+	}
+}
+
+func pathReapedCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: ReapedCredsPathPrefix + nameRegex("name") + `$`,
+		Fields:  reapedCredsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.reapedCredsReadOperation,
+				Summary:   "Get the reason a credential was removed by the reaper.",
+				Responses: reapedCredsReadResponses,
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback:                    b.reapedCredsDeleteOperation,
+				Summary:                     "Permanently delete a credential removed by the reaper.",
+				Responses:                   reapedCredsDeleteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(reapedCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(reapedCredsHelpDescription),
+	}
+}
+
+func pathRestoreCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: RestoreCredsPathPrefix + nameRegex("name") + `$`,
+		Fields:  reapedCredsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.restoreCredsUpdateOperation,
+				Summary:                     "Restore a credential removed by the reaper.",
+				Responses:                   restoreCredsResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(restoreCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(restoreCredsHelpDescription),
+	}
+}
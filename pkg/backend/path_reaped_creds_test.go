@@ -0,0 +1,175 @@
+package backend_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/puppetlabs/leg/timeutil/pkg/retry"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	testclock "k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestReapQuarantine(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	clk := testclock.NewFakeClock(time.Now())
+	exchange := testutil.AmendTokenMockAuthCodeExchange(testutil.RandomMockAuthCodeExchange, func(tok *provider.Token) error {
+		tok.Expiry = clk.Now().Add(time.Minute)
+		return nil
+	})
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock: clock.NewTimerCallbackClock(
+			k8sext.NewClock(clk),
+			func(d time.Duration) {
+				clk.Step(d)
+			},
+		),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":                         client.ID,
+			"client_secret":                     client.Secret,
+			"provider":                          "mock",
+			"tune_reap_non_refreshable_seconds": "5m",
+			"tune_reap_quarantine_seconds":      "876000h",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write our credentials.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + "test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"code": "test",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Wait for the reaper to move the credential to quarantine.
+	require.NoError(t, retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		clk.Step(time.Minute)
+
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.ReapedCredsPathPrefix + "test",
+			Storage:   storage,
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+
+		if resp == nil {
+			return retry.Repeat(fmt.Errorf("credential not quarantined"))
+		}
+
+		return retry.Done(nil)
+	}))
+	assert.Equal(t, "token expired", resp.Data["reason"])
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + "test",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      backend.ReapedCredsPathPrefix,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, []string{"test"}, resp.Data["keys"])
+
+	// Restore the credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.RestoreCredsPathPrefix + "test",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// It comes back disabled so it isn't reaped again right away.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + "test",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), string(backend.ErrorCodeDisabled))
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ReapedCredsPathPrefix + "test",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	// Restoring again fails.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.RestoreCredsPathPrefix + "test",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+}
@@ -0,0 +1,161 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+var credNamePattern = regexp.MustCompile(`^` + nameRegex("name") + `$`)
+
+func (b *backend) renameCredsUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	newName := data.Get("new_name").(string)
+	switch {
+	case newName == "":
+		return errorResponse(ErrorCodeInvalidRequest, "missing new_name"), nil
+	case !credNamePattern.MatchString(newName):
+		return errorResponse(ErrorCodeInvalidRequest, "new_name is not a valid credential name"), nil
+	case newName == name:
+		return errorResponse(ErrorCodeInvalidRequest, "new_name must differ from the current name"), nil
+	case userCredNameReserved(newName):
+		// Renaming would move a credential into the credentials of a Vault
+		// entity. Moving one out of them is rejected by withoutUserCreds.
+		return userCredNameReservedResponse(), nil
+	}
+
+	var (
+		resp  *logical.Response
+		entry *persistence.AuthCodeEntry
+	)
+	keyers := []persistence.AuthCodeKeyer{persistence.AuthCodeName(name), persistence.AuthCodeName(newName)}
+	err := b.data.Managers(req.Storage).AuthCode().WithLocks(keyers, func(lacms []*persistence.LockedAuthCodeManager) error {
+		src, dst := lacms[0], lacms[1]
+
+		var err error
+		entry, err = src.ReadAuthCodeEntry(ctx)
+		if err != nil {
+			return err
+		} else if entry == nil {
+			resp = errorResponse(ErrorCodeNotFound, "credential not found")
+			return nil
+		}
+
+		// Pending device code authorizations and asynchronous exchanges are
+		// processed in the background for the current name, so they must
+		// finish first.
+		if dae, err := src.ReadDeviceAuthEntry(ctx); err != nil {
+			return err
+		} else if dae != nil {
+			resp = errorResponse(ErrorCodeTokenPending, "cannot rename a credential while its device code authorization is pending")
+			return nil
+		}
+
+		if exchange, err := src.ReadAuthCodeExchangeEntry(ctx); err != nil {
+			return err
+		} else if exchange != nil {
+			resp = errorResponse(ErrorCodeTokenPending, "cannot rename a credential while its code is being exchanged")
+			return nil
+		}
+
+		if existing, err := dst.ReadAuthCodeEntry(ctx); err != nil {
+			return err
+		} else if existing != nil {
+			resp = errorResponse(ErrorCodeInvalidRequest, "a credential with this name already exists")
+			return nil
+		}
+
+		pae, err := src.ReadPendingAuthorizationEntry(ctx)
+		if err != nil {
+			return err
+		}
+
+		// The credential continues to count toward the quota of the entity
+		// that created it, as the creator is moved along with it.
+		entry.Name = newName
+		if err := dst.WriteAuthCodeEntry(ctx, entry); err != nil {
+			return err
+		}
+
+		// Retain the notification state of a pending authorization so that
+		// the user is not notified again.
+		if pae != nil {
+			pae.Name = newName
+			if err := dst.WritePendingAuthorizationEntry(ctx, pae); err != nil {
+				return err
+			}
+		}
+
+		// A generated state is bound to the current name, so it can no longer
+		// be used.
+		if err := src.DeletePendingStateEntry(ctx); err != nil {
+			return err
+		}
+
+		return src.DeleteAuthCodeEntry(ctx)
+	})
+	if err != nil {
+		return nil, err
+	} else if resp != nil {
+		return resp, nil
+	}
+
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	b.logCredEvent(ctx, c, credEventRenamed, name, fmt.Sprintf("renamed to %s", newName))
+
+	return nil, nil
+}
+
+const (
+	RenameCredsPathPrefix = "rename/" + CredsPathPrefix
+)
+
+var renameCredsFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential.",
+	},
+	"new_name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name to move the credential to. A credential with this name must not already exist.",
+	},
+}
+
+const renameCredsHelpSynopsis = `
+Renames a credential.
+`
+
+const renameCredsHelpDescription = `
+This endpoint atomically moves a credential to a new name, retaining
+its tokens, previous versions, metadata, and tuning, so users do not
+need to authorize the application again. Authorization code URLs
+generated for the old name can no longer be used.
+`
+
+func pathRenameCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: RenameCredsPathPrefix + nameRegex("name") + `$`,
+		Fields:  renameCredsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    withoutUserCreds(b.withCredBinding(b.renameCredsUpdateOperation)),
+				Summary:                     "Move a credential to a new name.",
+				Responses:                   renameCredsResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(renameCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(renameCredsHelpDescription),
+	}
+}
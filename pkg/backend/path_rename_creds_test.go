@@ -0,0 +1,208 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenameCreds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exchange := testutil.AmendTokenMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(tok *provider.Token) error {
+			tok.ExtraData = map[string]interface{}{
+				"id_token_claims": map[string]interface{}{"email": "alice@example.com"},
+			}
+			return nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	requireCode := func(resp *logical.Response, expected backend.ErrorCode) {
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+
+		code, ok := backend.ParseErrorCode(resp.Error().Error())
+		require.True(t, ok)
+		assert.Equal(t, expected, code)
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, map[string]interface{}{
+		"client_id":                    client.ID,
+		"client_secret":                client.Secret,
+		"provider":                     "mock",
+		"claim_metadata":               map[string]interface{}{"email": "email"},
+		"tune_max_credential_versions": 1,
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Credentials must exist to be renamed.
+	requireCode(handle(logical.UpdateOperation, backend.RenameCredsPathPrefix+`old`, map[string]interface{}{
+		"new_name": "new",
+	}), backend.ErrorCodeNotFound)
+
+	for _, name := range []string{"old", "old", "taken"} {
+		resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+name, map[string]interface{}{
+			"code": "123456",
+		})
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	requireCode(handle(logical.UpdateOperation, backend.RenameCredsPathPrefix+`old`, nil), backend.ErrorCodeInvalidRequest)
+	requireCode(handle(logical.UpdateOperation, backend.RenameCredsPathPrefix+`old`, map[string]interface{}{
+		"new_name": "old",
+	}), backend.ErrorCodeInvalidRequest)
+	requireCode(handle(logical.UpdateOperation, backend.RenameCredsPathPrefix+`old`, map[string]interface{}{
+		"new_name": "taken",
+	}), backend.ErrorCodeInvalidRequest)
+
+	// Names reserved for the credentials of Vault entities can be neither
+	// the source nor the target of a rename.
+	for _, test := range []struct {
+		name, newName string
+	}{
+		{name: backend.UsersPathPrefix + "alice/github/work", newName: "stolen"},
+		{name: "taken", newName: backend.UsersPathPrefix + "alice/github/planted"},
+	} {
+		resp = handle(logical.UpdateOperation, backend.RenameCredsPathPrefix+test.name, map[string]interface{}{
+			"new_name": test.newName,
+		})
+		requireCode(resp, backend.ErrorCodeInvalidRequest)
+		assert.Contains(t, resp.Error().Error(), "reserved")
+	}
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`taken`, nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.UpdateOperation, backend.RenameCredsPathPrefix+`old`, map[string]interface{}{
+		"new_name": "team/new",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// The old name no longer exists.
+	assert.Nil(t, handle(logical.ReadOperation, backend.CredsPathPrefix+`old`, nil))
+
+	// The token, its previous versions, and the metadata are retained.
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`team/new`, nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "token_2", resp.Data["access_token"])
+	assert.Equal(t, 2, resp.Data["version"])
+	assert.Equal(t, map[string]string{"email": "alice@example.com"}, resp.Data["metadata"])
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`team/new`, map[string]interface{}{
+		"version": 1,
+	})
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "token_1", resp.Data["access_token"])
+
+	resp = handle(logical.ListOperation, backend.CredsPathPrefix, nil)
+	require.NotNil(t, resp)
+	assert.Equal(t, []string{"taken", "team/new"}, resp.Data["keys"])
+}
+
+func TestRenameCredsQuota(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, testutil.RandomMockAuthCodeExchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(op logical.Operation, path, entityID string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			EntityID:  entityID,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, "", map[string]interface{}{
+		"client_id":                       client.ID,
+		"client_secret":                   client.Secret,
+		"provider":                        "mock",
+		"tune_max_credentials_per_entity": 2,
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	for _, name := range []string{"a1", "a2"} {
+		resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+name, "alice", map[string]interface{}{
+			"code": "test",
+		})
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	resp = handle(logical.UpdateOperation, backend.RenameCredsPathPrefix+"a1", "alice", map[string]interface{}{
+		"new_name": "a3",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// The renamed credential still counts toward the entity's quota.
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+"a4", "alice", map[string]interface{}{
+		"code": "test",
+	})
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	code, _ := backend.ParseErrorCode(resp.Error().Error())
+	assert.Equal(t, backend.ErrorCodeQuotaExceeded, code)
+
+	// Deleting it under its new name frees the quota.
+	resp = handle(logical.DeleteOperation, backend.CredsPathPrefix+"a3", "alice", nil)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+"a4", "alice", map[string]interface{}{
+		"code": "test",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+}
@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+func (b *backend) rollbackCredsUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	version, ok := data.GetOk("version")
+	if !ok {
+		return errorResponse(ErrorCodeInvalidRequest, "missing version"), nil
+	}
+
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	}
+
+	var resp *logical.Response
+	err = b.data.Managers(req.Storage).AuthCode().WithLock(persistence.AuthCodeName(data.Get("name").(string)), func(acm *persistence.LockedAuthCodeManager) error {
+		entry, err := acm.ReadAuthCodeEntry(ctx)
+		if err != nil {
+			return err
+		} else if entry == nil {
+			resp = errorResponse(ErrorCodeNotFound, "credential not found")
+			return nil
+		}
+
+		ve, found := entry.PreviousVersion(version.(int))
+		if !found {
+			resp = errorResponse(ErrorCodeNotFound, "version %d not found", version.(int))
+			return nil
+		}
+
+		// Rolling back creates a new version of the credential with the token
+		// from the previous version, so the current token is retained too.
+		next := &persistence.AuthCodeEntry{JWTBearer: entry.JWTBearer}
+		next.SetToken(ve.Token, b.clock.Now())
+		next.Supersede(entry, c.Config.Tuning.MaxCredentialVersions, b.clock.Now())
+		next.SetClaimMetadata(c.Config.ClaimMetadata)
+
+		return acm.WriteAuthCodeEntry(ctx, next)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+const (
+	RollbackCredsPathPrefix = "rollback/" + CredsPathPrefix
+)
+
+var rollbackCredsFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential.",
+	},
+	"version": {
+		Type:        framework.TypeInt,
+		Description: "Specifies the previous version of the credential to restore.",
+	},
+}
+
+const rollbackCredsHelpSynopsis = `
+Restores a previous version of a credential.
+`
+
+const rollbackCredsHelpDescription = `
+This endpoint replaces the token of a credential with the token from
+a retained previous version. The current token becomes a previous
+version itself, so a rollback can be undone the same way. Previous
+versions are only retained if the tune_max_credential_versions
+configuration option is set.
+`
+
+func pathRollbackCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: RollbackCredsPathPrefix + nameRegex("name") + `$`,
+		Fields:  rollbackCredsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback:                    b.withCredBinding(b.rollbackCredsUpdateOperation),
+				Summary:                     "Restore a previous version of a credential.",
+				Responses:                   rollbackCredsResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(rollbackCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(rollbackCredsHelpDescription),
+	}
+}
@@ -0,0 +1,217 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/require"
+	testclock "k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestCredentialVersionRollback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, testutil.IncrementMockAuthCodeExchange("token_"))))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":                    client.ID,
+			"client_secret":                client.Secret,
+			"provider":                     "mock",
+			"tune_max_credential_versions": 2,
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write the credential three times. Only the two previous versions should
+	// be retained.
+	for i := 0; i < 3; i++ {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"code": "test",
+			},
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		require.Nil(t, resp)
+	}
+
+	read := func(version int) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"version": version,
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	resp = read(3)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_3", resp.Data["access_token"])
+	require.Equal(t, 3, resp.Data["version"])
+
+	resp = read(2)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_2", resp.Data["access_token"])
+	require.NotEmpty(t, resp.Data["superseded_time"])
+
+	resp = read(1)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_1", resp.Data["access_token"])
+
+	// Roll back to the first version.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.RollbackCredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"version": 1,
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_1", resp.Data["access_token"])
+	require.Equal(t, 4, resp.Data["version"])
+
+	// The replaced version is still available, but the oldest one has been
+	// discarded.
+	resp = read(3)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_3", resp.Data["access_token"])
+
+	resp = read(1)
+	require.EqualError(t, resp.Error(), "[ERR_NOT_FOUND] version 1 not found")
+}
+
+func TestCredentialVersionRead(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, testutil.ExpiringMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), 10*time.Minute, testutil.MockExpiryWithClock(clk)))))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	requireErrorCode := func(resp *logical.Response, expected backend.ErrorCode) {
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+		code, _ := backend.ParseErrorCode(resp.Error().Error())
+		require.Equal(t, expected, code)
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, map[string]interface{}{
+		"client_id":                    client.ID,
+		"client_secret":                client.Secret,
+		"provider":                     "mock",
+		"redact_tokens":                true,
+		"tune_max_credential_versions": 2,
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// The first version expires before the credential is written again.
+	handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{"code": "test"})
+	clk.Step(20 * time.Minute)
+	for i := 0; i < 2; i++ {
+		handle(logical.UpdateOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{"code": "test"})
+	}
+
+	// Expired versions are rejected like an expired current version.
+	requireErrorCode(handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{"version": 1}), backend.ErrorCodeTokenExpired)
+
+	// Tokens of previous versions are redacted like the current token.
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{"version": 2})
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.NotContains(t, resp.Data, "access_token")
+	require.NotEmpty(t, resp.Data["access_token_sha256"])
+	require.Equal(t, 2, resp.Data["version"])
+	require.Equal(t, "ready", resp.Data["status"])
+	require.NotEmpty(t, resp.Data["superseded_time"])
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{"version": 2, "include_token": true})
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_2", resp.Data["access_token"])
+
+	requireErrorCode(handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{"version": 5}), backend.ErrorCodeNotFound)
+
+	// Disabled credentials can't be read at any version.
+	handle(logical.UpdateOperation, backend.DisableCredsPathPrefix+`test`, nil)
+	for _, version := range []int{2, 3, 5} {
+		requireErrorCode(handle(logical.ReadOperation, backend.CredsPathPrefix+`test`, map[string]interface{}{"version": version}), backend.ErrorCodeDisabled)
+	}
+}
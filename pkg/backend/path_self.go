@@ -17,6 +17,11 @@ import (
 func (b *backend) selfReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	expiryDelta := time.Duration(data.Get("minimum_seconds").(int)) * time.Second
 
+	leeway, err := b.expiryLeeway(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
 	entry, err := b.getUpdateClientCredsToken(
 		ctx,
 		req.Storage,
@@ -25,15 +30,19 @@ func (b *backend) selfReadOperation(ctx context.Context, req *logical.Request, d
 	)
 	switch {
 	case errors.Is(err, ErrNotConfigured):
-		return logical.ErrorResponse("not configured"), nil
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	case errors.Is(err, ErrMaintenanceMode):
+		return errorResponse(ErrorCodeMaintenance, "token expired and requests to the provider are paused for maintenance"), nil
+	case errors.Is(err, ErrScopeNotAllowed):
+		return errorResponse(ErrorCodeScopeNotAllowed, "token expired and %s", err), nil
 	case errmark.Matches(err, errmark.RuleType(&oauth2.RetrieveError{})) || errmark.MarkedUser(err):
-		return logical.ErrorResponse(errmap.Wrap(errmark.MarkShort(err), "client credentials flow failed").Error()), nil
+		return errorResponse(ErrorCodeProviderRejected, errmap.Wrap(errmark.MarkShort(err), "client credentials flow failed").Error()), nil
 	case err != nil:
 		return nil, err
 	case entry == nil:
 		return nil, nil
-	case !b.tokenValid(entry.Token, expiryDelta):
-		return logical.ErrorResponse("token expired"), nil
+	case !b.tokenValid(entry.Token, expiryDelta, leeway):
+		return errorResponse(ErrorCodeTokenExpired, "token expired"), nil
 	}
 
 	rd := map[string]interface{}{
@@ -49,10 +58,15 @@ func (b *backend) selfReadOperation(ctx context.Context, req *logical.Request, d
 		rd["extra_data"] = entry.Token.ExtraData
 	}
 
-	resp := &logical.Response{
-		Data: rd,
+	if err := b.addTokenFingerprint(ctx, req.Storage, rd); err != nil {
+		return nil, err
+	}
+
+	if err := b.redactTokens(ctx, req.Storage, data, rd); err != nil {
+		return nil, err
 	}
-	return resp, nil
+
+	return b.leaseResponse(ctx, req.Storage, "", entry.Token, rd)
 }
 
 func (b *backend) selfDeleteOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -84,6 +98,7 @@ var selfFields = map[string]*framework.FieldSchema{
 		Description: "Minimum remaining seconds to allow when reusing access token.",
 		Query:       true,
 	},
+	"include_token": credsFields["include_token"],
 }
 
 const selfHelpSynopsis = `
@@ -103,12 +118,16 @@ func pathSelf(b *backend) *framework.Path {
 		Fields:  selfFields,
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.ReadOperation: &framework.PathOperation{
-				Callback: b.selfReadOperation,
-				Summary:  "Get a current access token for this credential.",
+				Callback:  b.selfReadOperation,
+				Summary:   "Get a current access token for this credential.",
+				Responses: selfReadResponses,
 			},
 			logical.DeleteOperation: &framework.PathOperation{
-				Callback: b.selfDeleteOperation,
-				Summary:  "Remove a credential.",
+				Callback:                    b.selfDeleteOperation,
+				Summary:                     "Remove a credential.",
+				Responses:                   selfDeleteResponses,
+				ForwardPerformanceStandby:   true,
+				ForwardPerformanceSecondary: true,
 			},
 		},
 		HelpSynopsis:    strings.TrimSpace(selfHelpSynopsis),
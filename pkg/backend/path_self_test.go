@@ -231,3 +231,103 @@ func TestExpiredClientCredentials(t *testing.T) {
 		require.NotEmpty(t, resp.Data["expire_time"])
 	}
 }
+
+func TestClientCredentialsScopePolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	handler := testutil.AmendTokenMockClientCredentials(testutil.IncrementMockClientCredentials("token_"), func(t *provider.Token) error {
+		t.Expiry = time.Now().Add(time.Hour)
+		return nil
+	})
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.IncrementMockAuthCodeExchange("token_")),
+		testutil.MockWithClientCredentials(client, handler),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	requireNotAllowed := func(resp *logical.Response) {
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+
+		code, ok := backend.ParseErrorCode(resp.Error().Error())
+		require.True(t, ok)
+		require.Equal(t, backend.ErrorCodeScopeNotAllowed, code)
+	}
+
+	config := map[string]interface{}{
+		"client_id":      client.ID,
+		"client_secret":  client.Secret,
+		"provider":       "mock",
+		"allowed_scopes": []string{"read", "write"},
+		"denied_scopes":  []string{"write"},
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.ConfigPath, nil)
+	require.NotNil(t, resp)
+	require.Equal(t, []string{"read", "write"}, resp.Data["allowed_scopes"])
+	require.Equal(t, []string{"write"}, resp.Data["denied_scopes"])
+
+	// Scopes must be allowed and not denied.
+	requireNotAllowed(handle(logical.UpdateOperation, backend.ConfigSelfPathPrefix+`test`, map[string]interface{}{
+		"scopes": []string{"read", "admin"},
+	}))
+	requireNotAllowed(handle(logical.UpdateOperation, backend.ConfigSelfPathPrefix+`test`, map[string]interface{}{
+		"scopes": []string{"write"},
+	}))
+	requireNotAllowed(handle(logical.UpdateOperation, backend.AuthCodeURLCredsPathPrefix+"test", map[string]interface{}{
+		"scopes": []string{"admin"},
+	}))
+
+	resp = handle(logical.UpdateOperation, backend.AuthCodeURLCredsPathPrefix+"test", map[string]interface{}{
+		"scopes": []string{"read"},
+	})
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.UpdateOperation, backend.ConfigSelfPathPrefix+`test`, map[string]interface{}{
+		"scopes": []string{"read"},
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Tightening the policy prevents existing credentials from being
+	// refreshed, but tokens that are still valid are returned.
+	config["denied_scopes"] = []string{"read"}
+	resp = handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.SelfPathPrefix+`test`, nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_1", resp.Data["access_token"])
+
+	requireNotAllowed(handle(logical.ReadOperation, backend.SelfPathPrefix+`test`, map[string]interface{}{
+		"minimum_seconds": 2 * 60 * 60,
+	}))
+}
@@ -5,10 +5,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -128,3 +130,49 @@ func TestAcceptableCredentialNames(t *testing.T) {
 		})
 	}
 }
+
+func TestOpenAPI(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	b := backend.New(backend.Options{ProviderRegistry: provider.NewRegistry()})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.HelpOperation,
+		Storage:   &logical.InmemStorage{},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	doc, ok := resp.Data["openapi"].(*framework.OASDocument)
+	require.True(t, ok, "response does not contain an OpenAPI document")
+	require.NotEmpty(t, doc.Paths)
+
+	doc.CreateOperationIDs("")
+
+	ids := make(map[string]string)
+	for path, item := range doc.Paths {
+		for method, op := range map[string]*framework.OASOperation{
+			"get":    item.Get,
+			"post":   item.Post,
+			"delete": item.Delete,
+		} {
+			if op == nil {
+				continue
+			}
+
+			assert.NotEmpty(t, op.Summary, "%s %s has no summary", method, path)
+			assert.NotContains(t, ids, op.OperationID, "%s %s has the same operation ID as %s", method, path, ids[op.OperationID])
+			ids[op.OperationID] = method + " " + path
+
+			// Every operation documents its responses instead of relying on
+			// the generic defaults.
+			require.NotEmpty(t, op.Responses, "%s %s has no responses", method, path)
+			for code, r := range op.Responses {
+				assert.NotEqual(t, framework.OASStdRespOK, r, "%s %s has the default %d response", method, path, code)
+				assert.NotEqual(t, framework.OASStdRespNoContent, r, "%s %s has the default %d response", method, path, code)
+			}
+		}
+	}
+}
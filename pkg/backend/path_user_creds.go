@@ -0,0 +1,180 @@
+package backend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// defaultSelfCredName is the name of the credential used by the self-creds
+// endpoint when the caller does not specify one.
+const defaultSelfCredName = "default"
+
+// userCredName returns the name under which a credential belonging to the
+// given Vault entity is stored.
+func userCredName(entityID, name string) string {
+	return UsersPathPrefix + entityID + "/" + name
+}
+
+// userCredNameReserved returns true if the given credential name belongs to
+// a Vault entity. Such credentials may only be read and written using the
+// users/:entity_id/creds and self-creds endpoints, so that a policy for the
+// creds endpoint does not grant access to them.
+func userCredNameReserved(name string) bool {
+	return strings.HasPrefix(name, UsersPathPrefix)
+}
+
+func userCredNameReservedResponse() *logical.Response {
+	return errorResponse(ErrorCodeInvalidRequest, "credential names starting with %q are reserved for the credentials of Vault entities", UsersPathPrefix)
+}
+
+// withoutUserCreds adapts a credential operation so that it rejects the names
+// of credentials that belong to a Vault entity.
+func withoutUserCreds(fn framework.OperationFunc) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		if userCredNameReserved(data.Get("name").(string)) {
+			return userCredNameReservedResponse(), nil
+		}
+
+		return fn(ctx, req, data)
+	}
+}
+
+// withCredName adapts a credential operation to a path that determines the
+// name of the credential some other way, for example from the identity of the
+// caller. If credName returns a response, it is returned instead of calling
+// the operation.
+func withCredName(fn framework.OperationFunc, credName func(req *logical.Request, data *framework.FieldData) (string, *logical.Response)) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		name, resp := credName(req, data)
+		if resp != nil {
+			return resp, nil
+		}
+
+		raw := make(map[string]interface{}, len(data.Raw))
+		for k, v := range data.Raw {
+			raw[k] = v
+		}
+		delete(raw, "entity_id")
+		raw["name"] = name
+
+		return fn(ctx, req, &framework.FieldData{
+			Raw:    raw,
+			Schema: credsFields,
+		})
+	}
+}
+
+// forUserCreds adapts a credential operation to the users/:entity_id/creds
+// path family by qualifying the credential name with the entity ID.
+func forUserCreds(fn framework.OperationFunc) framework.OperationFunc {
+	return withCredName(fn, func(req *logical.Request, data *framework.FieldData) (string, *logical.Response) {
+		return userCredName(data.Get("entity_id").(string), data.Get("name").(string)), nil
+	})
+}
+
+// forSelfCreds adapts a credential operation to the self-creds path by
+// qualifying the credential name with the entity ID of the caller.
+func forSelfCreds(fn framework.OperationFunc) framework.OperationFunc {
+	return withCredName(fn, func(req *logical.Request, data *framework.FieldData) (string, *logical.Response) {
+		if req.EntityID == "" {
+			return "", errorResponse(ErrorCodeInvalidRequest, "the token used for this request is not associated with an identity entity")
+		}
+
+		name := defaultSelfCredName
+		if v, ok := data.GetOk("name"); ok && v.(string) != "" {
+			name = v.(string)
+		}
+
+		return userCredName(req.EntityID, name), nil
+	})
+}
+
+const (
+	UsersPathPrefix = "users/"
+	SelfCredsPath   = "self-creds"
+)
+
+var userCredsFields = func() map[string]*framework.FieldSchema {
+	fields := map[string]*framework.FieldSchema{
+		"entity_id": {
+			Type:        framework.TypeString,
+			Description: "Specifies the ID of the Vault entity that owns the credential.",
+		},
+	}
+	for k, v := range credsFields {
+		fields[k] = v
+	}
+	return fields
+}()
+
+const userCredsHelpSynopsis = `
+Manages credentials that belong to a particular Vault entity.
+`
+
+const userCredsHelpDescription = `
+This endpoint behaves like the creds endpoint, but keeps the credentials
+of each Vault entity separate so that a policy can grant each user
+access to only their own credentials using the templated path
+users/{{identity.entity.id}}/creds/*. The credential is stored under
+the name users/<entity_id>/<name>, which the creds endpoint rejects, but
+operators can use it with the other credential endpoints, such as
+disable/creds.
+`
+
+const selfCredsHelpSynopsis = `
+Manages the credentials of the caller.
+`
+
+const selfCredsHelpDescription = `
+This endpoint behaves like the users/<entity_id>/creds endpoint for the
+identity entity of the token used to make the request, so users can
+manage their own credentials without knowing their entity ID. If no
+name is given, the credential is named "default".
+`
+
+func userCredsOperations(wrap func(fn framework.OperationFunc) framework.OperationFunc, b *backend) map[logical.Operation]framework.OperationHandler {
+	return map[logical.Operation]framework.OperationHandler{
+		logical.ReadOperation: &framework.PathOperation{
+			Callback:  wrap(b.withCredBinding(b.credsReadOperation)),
+			Summary:   "Get a current access token for this credential.",
+			Responses: credsReadResponses,
+		},
+		logical.UpdateOperation: &framework.PathOperation{
+			Callback:                    wrap(b.withCredBinding(b.credsUpdateOperation)),
+			Summary:                     "Write a new credential or update an existing credential.",
+			Responses:                   credsWriteResponses,
+			ForwardPerformanceStandby:   true,
+			ForwardPerformanceSecondary: true,
+		},
+		logical.DeleteOperation: &framework.PathOperation{
+			Callback:                    wrap(b.withCredBinding(b.credsDeleteOperation)),
+			Summary:                     "Remove a credential.",
+			Responses:                   credsDeleteResponses,
+			ForwardPerformanceStandby:   true,
+			ForwardPerformanceSecondary: true,
+		},
+	}
+}
+
+func pathUserCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:         UsersPathPrefix + framework.GenericNameRegex("entity_id") + "/" + CredsPathPrefix + nameRegex("name") + `$`,
+		Fields:          userCredsFields,
+		Operations:      userCredsOperations(forUserCreds, b),
+		HelpSynopsis:    strings.TrimSpace(userCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(userCredsHelpDescription),
+	}
+}
+
+func pathSelfCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:         SelfCredsPath + `(?:/` + nameRegex("name") + `)?$`,
+		Fields:          credsFields,
+		Operations:      userCredsOperations(forSelfCreds, b),
+		HelpSynopsis:    strings.TrimSpace(selfCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(selfCredsHelpDescription),
+	}
+}
@@ -0,0 +1,309 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestUserCreds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exchange := func(code string, _ *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		return &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken: "token-" + code,
+			},
+		}, nil
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Two users can have credentials with the same name.
+	for _, user := range []string{"alice", "bob"} {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.UsersPathPrefix + user + "/" + backend.CredsPathPrefix + "github/work",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"code": user,
+			},
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	for _, user := range []string{"alice", "bob"} {
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.UsersPathPrefix + user + "/" + backend.CredsPathPrefix + "github/work",
+			Storage:   storage,
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+		assert.Equal(t, "token-"+user, resp.Data["access_token"])
+	}
+
+	// The credentials are stored under qualified names, which can't be used
+	// with the creds endpoint or moved to or from with the rename endpoint.
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + backend.UsersPathPrefix + "alice/github/work",
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + backend.UsersPathPrefix + "alice/github/work",
+			Data:      map[string]interface{}{"code": "mallory"},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + backend.UsersPathPrefix + "alice/github/planted",
+			Data:      map[string]interface{}{"code": "mallory"},
+		},
+		{
+			Operation: logical.DeleteOperation,
+			Path:      backend.CredsPathPrefix + backend.UsersPathPrefix + "alice/github/work",
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.AuthCodeURLCredsPathPrefix + backend.UsersPathPrefix + "alice/github/planted",
+			Data:      map[string]interface{}{"state": "qwerty"},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.RenameCredsPathPrefix + backend.UsersPathPrefix + "alice/github/work",
+			Data:      map[string]interface{}{"new_name": "stolen"},
+		},
+	} {
+		req.Storage = storage
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp, "%s %s", req.Operation, req.Path)
+		require.True(t, resp.IsError(), "%s %s", req.Operation, req.Path)
+		code, _ := backend.ParseErrorCode(resp.Error().Error())
+		assert.Equal(t, backend.ErrorCodeInvalidRequest, code)
+	}
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + "mine",
+		Storage:   storage,
+		Data:      map[string]interface{}{"code": "mallory"},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.RenameCredsPathPrefix + "mine",
+		Storage:   storage,
+		Data:      map[string]interface{}{"new_name": backend.UsersPathPrefix + "bob/github/work"},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.UsersPathPrefix + "alice/" + backend.CredsPathPrefix + "github/work",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, "token-alice", resp.Data["access_token"])
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + "github/work",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+
+	// Deleting one user's credential leaves the other alone.
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      backend.UsersPathPrefix + "alice/" + backend.CredsPathPrefix + "github/work",
+		Storage:   storage,
+	}
+
+	_, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+
+	for user, expected := range map[string]bool{"alice": false, "bob": true} {
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.UsersPathPrefix + user + "/" + backend.CredsPathPrefix + "github/work",
+			Storage:   storage,
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, expected, resp != nil, "credential for %s", user)
+	}
+}
+
+func TestSelfCreds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exchange := func(code string, _ *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		return &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken: "token-" + code,
+			},
+		}, nil
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	tests := []struct {
+		Path string
+		Code string
+		As   string
+	}{
+		{Path: backend.SelfCredsPath, Code: "default", As: "default"},
+		{Path: backend.SelfCredsPath + "/github/work", Code: "work", As: "github/work"},
+	}
+	for _, test := range tests {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      test.Path,
+			Storage:   storage,
+			EntityID:  "alice",
+			Data: map[string]interface{}{
+				"code": test.Code,
+			},
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      test.Path,
+			Storage:   storage,
+			EntityID:  "alice",
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, "token-"+test.Code, resp.Data["access_token"])
+
+		// The credential belongs to the caller's entity.
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.UsersPathPrefix + "alice/" + backend.CredsPathPrefix + test.As,
+			Storage:   storage,
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, "token-"+test.Code, resp.Data["access_token"])
+
+		// Other entities don't see it.
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      test.Path,
+			Storage:   storage,
+			EntityID:  "bob",
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		assert.Nil(t, resp)
+	}
+
+	// Tokens without an entity, such as root tokens, can't use the endpoint.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.SelfCredsPath,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	code, _ := backend.ParseErrorCode(resp.Error().Error())
+	assert.Equal(t, backend.ErrorCodeInvalidRequest, code)
+}
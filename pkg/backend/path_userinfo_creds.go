@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+)
+
+func (b *backend) userInfoCredsReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	}
+
+	p, err := c.ProviderWithTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	if ops, ok := p.Private(c.Config.ClientID, c.Config.ClientSecret).(provider.UserInfoOperations); !ok || !ops.SupportsUserInfo() {
+		return errorResponse(ErrorCodeUnsupported, "provider %q does not support retrieving user info", c.Config.ProviderName), nil
+	}
+
+	name := data.Get("name").(string)
+
+	entry, err := b.getRefreshCredToken(ctx, req.Storage, persistence.AuthCodeName(name), 0)
+	switch {
+	case err == ErrNotConfigured:
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	case err == ErrMaintenanceMode:
+		return errorResponse(ErrorCodeMaintenance, "token expired and requests to the provider are paused for maintenance"), nil
+	case err != nil:
+		return nil, err
+	case entry == nil:
+		return nil, nil
+	case entry.Disabled:
+		return errorResponse(ErrorCodeDisabled, "credential is disabled"), nil
+	case !entry.TokenIssued():
+		return errorResponse(ErrorCodeTokenPending, "token pending issuance"), nil
+	case !b.tokenValid(entry.Token, 0, expiryLeeway(c.Config.Tuning)):
+		return errorResponse(ErrorCodeTokenExpired, "token expired"), nil
+	}
+
+	now := b.clock.Now()
+
+	claims, retrieveTime, ok := c.UserInfo.Get(name, entry.AccessToken, now)
+	if !ok {
+		// The request uses the timeout of the credential, if it overrides
+		// the mount tuning.
+		p, err := c.ProviderWithTuning(entry.Tuning.Apply(c.Config.Tuning))
+		if err != nil {
+			return nil, err
+		}
+
+		ops := p.Private(c.Config.ClientID, c.Config.ClientSecret).(provider.UserInfoOperations)
+		claims, err = ops.UserInfo(clockctx.WithClock(ctx, b.clock), entry.Token)
+		if resp := providerErrorResponse(err, "user info request failed"); resp != nil {
+			return resp, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		retrieveTime = now
+		c.UserInfo.Put(name, entry.AccessToken, claims, now)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"claims":        claims,
+			"retrieve_time": retrieveTime,
+		},
+	}, nil
+}
+
+const (
+	UserInfoCredsPathPrefix = "userinfo/" + CredsPathPrefix
+)
+
+var userInfoCredsFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential.",
+	},
+}
+
+const userInfoCredsHelpSynopsis = `
+Describes the subject of a credential using the provider's UserInfo endpoint.
+`
+
+const userInfoCredsHelpDescription = `
+This endpoint calls the OpenID Connect UserInfo endpoint of the
+provider with the access token of a credential, refreshing it first if
+necessary, and returns the claims about the subject of the token. The
+claims are reused for the same access token for the duration set by
+the tune_user_info_cache_seconds configuration option.
+`
+
+func pathUserInfoCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: UserInfoCredsPathPrefix + nameRegex("name") + `$`,
+		Fields:  userInfoCredsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback:  b.withCredBinding(b.userInfoCredsReadOperation),
+				Summary:   "Get the claims about the subject of this credential.",
+				Responses: userInfoCredsResponses,
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(userInfoCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(userInfoCredsHelpDescription),
+	}
+}
@@ -0,0 +1,129 @@
+package backend_test
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserInfoCreds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	var requests int32
+	userInfo := func(tok *provider.Token) (map[string]interface{}, error) {
+		atomic.AddInt32(&requests, 1)
+		if tok.AccessToken == "token_2" {
+			return nil, errmark.MarkUser(testutil.MockErrorResponse(http.StatusUnauthorized, nil))
+		}
+
+		return map[string]interface{}{"sub": "alice", "token": tok.AccessToken}, nil
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.IncrementMockAuthCodeExchange("token_")),
+		testutil.MockWithUserInfo(userInfo),
+	))
+	pr.MustRegister("mock-without-user-info", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.IncrementMockAuthCodeExchange("token_")),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(req *logical.Request) *logical.Response {
+		req.Storage = storage
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	configure := func(providerName string) {
+		resp := handle(&logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigPath,
+			Data: map[string]interface{}{
+				"client_id":     client.ID,
+				"client_secret": client.Secret,
+				"provider":      providerName,
+			},
+		})
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	write := func() {
+		resp := handle(&logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			Data: map[string]interface{}{
+				"code": "test",
+			},
+		})
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	read := func() *logical.Response {
+		resp := handle(&logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.UserInfoCredsPathPrefix + `test`,
+		})
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	// Providers that can't describe the subject of a token are rejected.
+	configure("mock-without-user-info")
+	write()
+
+	resp := read()
+	require.True(t, resp.IsError())
+	code, _ := backend.ParseErrorCode(resp.Error().Error())
+	assert.Equal(t, backend.ErrorCodeUnsupported, code)
+
+	configure("mock")
+	write()
+
+	// Claims are reused for the same access token.
+	for i := 0; i < 2; i++ {
+		resp = read()
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+		assert.Equal(t, map[string]interface{}{"sub": "alice", "token": "token_1"}, resp.Data["claims"])
+		assert.NotEmpty(t, resp.Data["retrieve_time"])
+		assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	}
+
+	// A new access token invalidates them.
+	write()
+
+	resp = read()
+	require.True(t, resp.IsError())
+	code, _ = backend.ParseErrorCode(resp.Error().Error())
+	assert.Equal(t, backend.ErrorCodeProviderRejected, code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	write()
+
+	resp = read()
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, map[string]interface{}{"sub": "alice", "token": "token_3"}, resp.Data["claims"])
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
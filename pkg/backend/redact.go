@@ -0,0 +1,58 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// redactedExtraDataFields are the fields of a token's extra data that contain
+// tokens themselves.
+var redactedExtraDataFields = []string{"id_token", "refresh_token"}
+
+func tokenDigest(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
+
+// redactTokens replaces the tokens in the data of a read response with their
+// SHA-256 digests if the mount is configured to redact tokens, unless the
+// request sets include_token.
+func (b *backend) redactTokens(ctx context.Context, storage logical.Storage, data *framework.FieldData, rd map[string]interface{}) error {
+	if data.Get("include_token").(bool) {
+		return nil
+	}
+
+	c, err := b.getCache(ctx, storage)
+	if err != nil || c == nil || !c.Config.RedactTokens {
+		return err
+	}
+
+	if tok, ok := rd["access_token"].(string); ok {
+		delete(rd, "access_token")
+		rd["access_token_sha256"] = tokenDigest(tok)
+	}
+
+	if extra, ok := rd["extra_data"].(map[string]interface{}); ok {
+		// The extra data belongs to the stored token, so we must not modify
+		// it in place.
+		redacted := make(map[string]interface{}, len(extra))
+		for k, v := range extra {
+			redacted[k] = v
+		}
+
+		for _, field := range redactedExtraDataFields {
+			if tok, ok := redacted[field].(string); ok {
+				delete(redacted, field)
+				redacted[field+"_sha256"] = tokenDigest(tok)
+			}
+		}
+
+		rd["extra_data"] = redacted
+	}
+
+	return nil
+}
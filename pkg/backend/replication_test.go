@@ -0,0 +1,317 @@
+package backend_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReplicationTestNode(ctx context.Context, t *testing.T, pr *provider.Registry, state consts.ReplicationState) *framework.Backend {
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{
+		System: &logical.StaticSystemView{
+			ReplicationStateVal: state,
+		},
+	}))
+	return b
+}
+
+func TestReplicationConfigInvalidation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory())
+
+	cluster := testutil.NewReplicationCluster(&logical.InmemStorage{})
+
+	active := newReplicationTestNode(ctx, t, pr, 0)
+	cluster.SetActive(active)
+
+	standby := newReplicationTestNode(ctx, t, pr, consts.ReplicationPerformanceStandby)
+	cluster.AddStandby(standby)
+
+	for _, clientID := range []string{"abc", "ghi"} {
+		// Write configuration through the standby, which must be forwarded.
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigPath,
+			Data: map[string]interface{}{
+				"client_id":     clientID,
+				"client_secret": "def",
+				"provider":      "mock",
+			},
+		}
+
+		resp, err := cluster.HandleRequest(ctx, standby, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+		// The standby must see the new configuration.
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.ConfigPath,
+		}
+
+		resp, err = cluster.HandleRequest(ctx, standby, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+		assert.Equal(t, clientID, resp.Data["client_id"])
+	}
+}
+
+func TestReplicationRefresh(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	var refreshes int32
+	exchange := testutil.RefreshableMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(i int) (time.Duration, error) {
+			if i == 1 {
+				// Initial exchange: expires immediately.
+				return 2 * time.Second, nil
+			}
+
+			atomic.AddInt32(&refreshes, 1)
+			return time.Hour, nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	cluster := testutil.NewReplicationCluster(&logical.InmemStorage{})
+
+	active := newReplicationTestNode(ctx, t, pr, 0)
+	cluster.SetActive(active)
+
+	standby := newReplicationTestNode(ctx, t, pr, consts.ReplicationPerformanceStandby)
+	cluster.AddStandby(standby)
+
+	// Write configuration and credential through the standby.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := cluster.HandleRequest(ctx, standby, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Data: map[string]interface{}{
+			"code": "test",
+		},
+	}
+
+	resp, err = cluster.HandleRequest(ctx, standby, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	read := func(node *framework.Backend) string {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+		}
+
+		resp, err := cluster.HandleRequest(ctx, node, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+		return resp.Data["access_token"].(string)
+	}
+
+	// The token needs a refresh, so the standby must forward the read to the
+	// active node, which refreshes it exactly once.
+	assert.Equal(t, "token_2", read(standby))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refreshes))
+
+	// Subsequent reads on either node use the stored token.
+	assert.Equal(t, "token_2", read(standby))
+	assert.Equal(t, "token_2", read(active))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refreshes))
+}
+
+func TestReplicationFailover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	var refreshes int32
+	exchange := testutil.RefreshableMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(i int) (time.Duration, error) {
+			if i == 1 {
+				return 2 * time.Second, nil
+			}
+
+			atomic.AddInt32(&refreshes, 1)
+			return time.Hour, nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	cluster := testutil.NewReplicationCluster(&logical.InmemStorage{})
+
+	active := newReplicationTestNode(ctx, t, pr, 0)
+	cluster.SetActive(active)
+
+	standby := newReplicationTestNode(ctx, t, pr, consts.ReplicationPerformanceStandby)
+	cluster.AddStandby(standby)
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := cluster.HandleRequest(ctx, active, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Data: map[string]interface{}{
+			"code": "test",
+		},
+	}
+
+	resp, err = cluster.HandleRequest(ctx, active, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	read := func(node *framework.Backend) string {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+		}
+
+		resp, err := cluster.HandleRequest(ctx, node, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+		return resp.Data["access_token"].(string)
+	}
+
+	// Refresh on the original active node through the standby.
+	assert.Equal(t, "token_2", read(standby))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refreshes))
+
+	// Fail over. The new active node must use the token rotated by the previous
+	// active node without refreshing it again.
+	cluster.Promote(standby)
+	require.NoError(t, standby.Initialize(ctx, &logical.InitializationRequest{Storage: cluster.ActiveStorage()}))
+	defer standby.Clean(ctx)
+
+	assert.Equal(t, "token_2", read(standby))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refreshes))
+}
+
+func TestReplicationStateSigningKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.IncrementMockAuthCodeExchange("token_")),
+	))
+
+	cluster := testutil.NewReplicationCluster(&logical.InmemStorage{})
+
+	active := newReplicationTestNode(ctx, t, pr, 0)
+	cluster.SetActive(active)
+
+	standby := newReplicationTestNode(ctx, t, pr, consts.ReplicationPerformanceStandby)
+	cluster.AddStandby(standby)
+
+	// The standby can't write to storage, so it must not try to create the
+	// signing key, even if the active node hasn't created it yet.
+	require.NoError(t, standby.Initialize(ctx, &logical.InitializationRequest{Storage: cluster.StandbyStorage()}))
+	defer standby.Clean(ctx)
+
+	require.NoError(t, active.Initialize(ctx, &logical.InitializationRequest{Storage: cluster.ActiveStorage()}))
+	defer active.Clean(ctx)
+
+	handle := func(req *logical.Request) *logical.Response {
+		resp, err := cluster.HandleRequest(ctx, standby, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	})
+
+	// The state is signed with the key created by the active node.
+	resp := handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.AuthCodeURLCredsPathPrefix + `test`,
+		Data: map[string]interface{}{
+			"redirect_url": "http://example.com/redirect",
+		},
+	})
+	require.NotEmpty(t, resp.Data["state"])
+
+	resp = handle(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CallbackPath,
+		Data: map[string]interface{}{
+			"state": resp.Data["state"],
+			"code":  "123456",
+		},
+	})
+	assert.Equal(t, "test", resp.Data["name"])
+
+	resp = handle(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+	})
+	assert.Equal(t, "token_1", resp.Data["access_token"])
+}
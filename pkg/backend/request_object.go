@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// requestObjectLifetime is the time each request object we sign is valid
+// for. It should be long enough for a user to follow the authorization code
+// URL.
+const requestObjectLifetime = 10 * time.Minute
+
+// requestObjectURLParams are the parameters that remain in an authorization
+// code URL after its parameters are moved into a request object. OpenID
+// Connect requires the response type and scope to be present in the URL
+// even when they are also given in the request object.
+var requestObjectURLParams = []string{"client_id", "response_type", "scope"}
+
+// signAuthCodeURL moves the query parameters of the given authorization code
+// URL into a signed request object, as described in RFC 9101.
+func (b *backend) signAuthCodeURL(authCodeURL string, c *persistence.ConfigEntry) (string, error) {
+	u, err := url.Parse(authCodeURL)
+	if err != nil {
+		return "", err
+	}
+
+	key, alg, err := parseJWTBearerSigningKey(c.RequestObjectSigningKey, c.RequestObjectSigningAlgorithm)
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{
+			Algorithm: alg,
+			Key:       jose.JSONWebKey{Key: key, KeyID: c.RequestObjectSigningKeyID},
+		},
+		(&jose.SignerOptions{}).WithType("oauth-authz-req+jwt"),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+
+	params := make(map[string]interface{}, len(query))
+	for k, vs := range query {
+		if len(vs) == 1 {
+			params[k] = vs[0]
+		} else {
+			params[k] = vs
+		}
+	}
+
+	now := b.clock.Now()
+
+	request, err := jwt.Signed(signer).
+		Claims(params).
+		Claims(&jwt.Claims{
+			Issuer:    c.ClientID,
+			Audience:  jwt.Audience{c.RequestObjectAudience},
+			Expiry:    jwt.NewNumericDate(now.Add(requestObjectLifetime)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		}).
+		CompactSerialize()
+	if err != nil {
+		return "", err
+	}
+
+	remaining := make(url.Values, len(requestObjectURLParams)+1)
+	for _, k := range requestObjectURLParams {
+		if vs, ok := query[k]; ok {
+			remaining[k] = vs
+		}
+	}
+	remaining.Set("request", request)
+
+	u.RawQuery = remaining.Encode()
+	return u.String(), nil
+}
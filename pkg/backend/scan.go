@@ -0,0 +1,45 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	"github.com/puppetlabs/leg/timeutil/pkg/clock"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+// scanPacer limits the rate at which background processes list pages of
+// storage keys so that a sweep over a large mount doesn't monopolize the
+// storage backend.
+type scanPacer struct {
+	clock    clock.Clock
+	interval time.Duration
+	last     time.Time
+}
+
+func newScanPacer(clk clock.Clock, tuning persistence.ConfigTuningEntry) *scanPacer {
+	sp := &scanPacer{clock: clk}
+	if tuning.StorageScanPagesPerSecond > 0 {
+		sp.interval = time.Duration(float64(time.Second) / tuning.StorageScanPagesPerSecond)
+	}
+	return sp
+}
+
+// Wait blocks until the next page may be processed or the context is
+// canceled. The first page is never delayed.
+func (sp *scanPacer) Wait(ctx context.Context) error {
+	if sp.interval > 0 && !sp.last.IsZero() {
+		if d := sp.last.Add(sp.interval).Sub(sp.clock.Now()); d > 0 {
+			timer := sp.clock.NewTimer(d)
+			select {
+			case <-timer.C():
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+	}
+
+	sp.last = sp.clock.Now()
+	return ctx.Err()
+}
@@ -0,0 +1,233 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"golang.org/x/oauth2"
+)
+
+const (
+	SecretAccessTokenType = "access_token"
+)
+
+// leaseResponse returns a response containing the given token data. If the
+// configuration enables leasing, the response is a Vault secret whose lease
+// lasts at most as long as the access token. The name is the authorization code
+// credential the token was issued for, if any.
+func (b *backend) leaseResponse(ctx context.Context, storage logical.Storage, name string, tok *provider.Token, rd map[string]interface{}) (*logical.Response, error) {
+	c, err := b.getCache(ctx, storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil || !c.Config.LeaseTokens {
+		return &logical.Response{Data: rd}, nil
+	}
+
+	internal := map[string]interface{}{
+		"access_token": tok.AccessToken,
+	}
+	if name != "" {
+		internal["credential"] = name
+	}
+	if len(tok.ProviderOptions) > 0 {
+		internal["provider_options"] = tok.ProviderOptions
+	}
+	if !tok.Expiry.IsZero() {
+		internal["expire_time"] = tok.Expiry.Format(time.RFC3339Nano)
+	}
+
+	// Several leases may hold the same access token, so count them to revoke
+	// the token only when the last one is revoked.
+	if ops, err := revocationOperations(c); err != nil {
+		return nil, err
+	} else if ops != nil {
+		if b.readOnly() {
+			return nil, logical.ErrReadOnly
+		}
+
+		if err := b.data.Managers(storage).AccessTokenLease().AddAccessTokenLease(ctx, persistence.AccessTokenLeaseName(tok.AccessToken)); err != nil {
+			return nil, err
+		}
+		internal["counted"] = true
+	}
+
+	resp := secretAccessToken(b).Response(rd, internal)
+	resp.Secret.TTL, resp.Secret.MaxTTL = b.leaseTTL(time.Duration(c.Config.TokenTTLSeconds)*time.Second, tok.Expiry)
+	resp.Secret.Renewable = resp.Secret.MaxTTL == 0 || resp.Secret.TTL < resp.Secret.MaxTTL
+	return resp, nil
+}
+
+// leaseTTL determines the TTL and maximum TTL of a lease for an access token
+// that expires at the given time. A zero TTL uses the mount default.
+func (b *backend) leaseTTL(ttl time.Duration, expiry time.Time) (time.Duration, time.Duration) {
+	if expiry.IsZero() {
+		return ttl, 0
+	}
+
+	maxTTL := expiry.Sub(b.clock.Now())
+	if maxTTL < time.Second {
+		maxTTL = time.Second
+	}
+
+	if ttl <= 0 || ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	return ttl, maxTTL
+}
+
+func (b *backend) accessTokenRenew(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return errorResponse(ErrorCodeNotConfigured, "not configured"), nil
+	}
+
+	var expiry time.Time
+	if raw, ok := req.Secret.InternalData["expire_time"].(string); ok {
+		expiry, err = time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The lease can't outlive the access token it was issued for.
+	if !expiry.IsZero() && !expiry.After(b.clock.Now()) {
+		return errorResponse(ErrorCodeTokenExpired, "access token has expired"), nil
+	}
+
+	resp := &logical.Response{Secret: req.Secret}
+	resp.Secret.TTL, resp.Secret.MaxTTL = b.leaseTTL(time.Duration(c.Config.TokenTTLSeconds)*time.Second, expiry)
+	return resp, nil
+}
+
+func (b *backend) accessTokenRevoke(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	// Leases issued by previous versions do not record the access token.
+	accessToken, _ := req.Secret.InternalData["access_token"].(string)
+	if accessToken == "" {
+		return nil, nil
+	}
+
+	c, err := b.getCache(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	} else if c == nil {
+		return nil, nil
+	}
+
+	// If the provider can't revoke access tokens, the token remains valid
+	// until it expires.
+	ops, err := revocationOperations(c)
+	if err != nil || ops == nil {
+		return nil, err
+	}
+
+	var expired bool
+	if raw, ok := req.Secret.InternalData["expire_time"].(string); ok {
+		expiry, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return nil, err
+		}
+		expired = !expiry.After(b.clock.Now())
+	}
+
+	tok := &provider.Token{
+		Token: &oauth2.Token{AccessToken: accessToken},
+	}
+	if opts, ok := req.Secret.InternalData["provider_options"].(map[string]interface{}); ok {
+		tok.ProviderOptions = make(map[string]string, len(opts))
+		for k, v := range opts {
+			tok.ProviderOptions[k], _ = v.(string)
+		}
+	}
+
+	revoke := func() error {
+		if expired {
+			return nil
+		}
+
+		// Vault retries the revocation if it fails.
+		return ops.RevokeToken(clockctx.WithClock(ctx, b.clock), tok)
+	}
+
+	// Leases issued by previous versions are not counted, so the token is
+	// revoked as soon as any of them is revoked.
+	if counted, _ := req.Secret.InternalData["counted"].(bool); counted {
+		var last bool
+		err = b.data.Managers(req.Storage).AccessTokenLease().WithLock(persistence.AccessTokenLeaseName(accessToken), func(lam *persistence.LockedAccessTokenLeaseManager) error {
+			entry, err := lam.ReadAccessTokenLeaseEntry(ctx)
+			if err != nil || entry == nil {
+				return err
+			}
+
+			// Other leases still hold the token, so leave it alone.
+			if entry.Count > 1 {
+				entry.Count--
+				return lam.WriteAccessTokenLeaseEntry(ctx, entry)
+			}
+
+			if err := revoke(); err != nil {
+				return err
+			}
+
+			last = true
+			return lam.DeleteAccessTokenLeaseEntry(ctx)
+		})
+		if err != nil || !last {
+			return nil, err
+		}
+	} else if err := revoke(); err != nil {
+		return nil, err
+	}
+
+	if expired {
+		return nil, nil
+	}
+
+	// The credential holds on to the revoked token until it is refreshed, so
+	// flag it in the same way as a token that introspection reports is no
+	// longer active. The next read refreshes it.
+	name, _ := req.Secret.InternalData["credential"].(string)
+	if name == "" {
+		return nil, nil
+	}
+
+	err = b.data.Managers(req.Storage).AuthCode().WithLock(persistence.AuthCodeName(name), func(cm *persistence.LockedAuthCodeManager) error {
+		entry, err := cm.ReadAuthCodeEntry(ctx)
+		if err != nil || entry == nil || !entry.TokenIssued() || entry.AccessToken != accessToken || !entry.InactiveTime.IsZero() {
+			return err
+		}
+
+		entry.InactiveTime = b.clock.Now()
+		return cm.WriteAuthCodeEntry(ctx, entry)
+	})
+	return nil, err
+}
+
+func secretAccessToken(b *backend) *framework.Secret {
+	return &framework.Secret{
+		Type: SecretAccessTokenType,
+		Fields: map[string]*framework.FieldSchema{
+			"access_token": {
+				Type:        framework.TypeString,
+				Description: "The access token issued by the provider.",
+			},
+			"type": {
+				Type:        framework.TypeString,
+				Description: "The type of the access token.",
+			},
+			"expire_time": {
+				Type:        framework.TypeTime,
+				Description: "The time at which the access token expires.",
+			},
+		},
+		Renew:  b.accessTokenRenew,
+		Revoke: b.accessTokenRevoke,
+	}
+}
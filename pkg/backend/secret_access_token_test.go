@@ -0,0 +1,320 @@
+package backend_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeasedAccessToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.ExpiringMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), 10*time.Minute)),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":         client.ID,
+			"client_secret":     client.Secret,
+			"provider":          "mock",
+			"lease_tokens":      true,
+			"token_ttl_seconds": 60,
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Write our credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"code": "123456",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// The token is issued as a secret.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "token_1", resp.Data["access_token"])
+	require.NotNil(t, resp.Secret)
+	assert.Equal(t, time.Minute, resp.Secret.TTL)
+	assert.True(t, resp.Secret.Renewable)
+	assert.InDelta(t, 10*time.Minute, resp.Secret.MaxTTL, float64(5*time.Second))
+
+	// The lease can be renewed up to the expiry of the token.
+	req = &logical.Request{
+		Operation: logical.RenewOperation,
+		Storage:   storage,
+		Secret:    resp.Secret,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.NotNil(t, resp.Secret)
+	assert.Equal(t, time.Minute, resp.Secret.TTL)
+
+	// The provider can't revoke the token, so revoking the lease does not
+	// affect the credential.
+	req = &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   storage,
+		Secret:    resp.Secret,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "token_1", resp.Data["access_token"])
+}
+
+func TestLeasedAccessTokenRevocation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	var mut sync.Mutex
+	var revoked []string
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.ExpiringMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), 10*time.Minute)),
+		testutil.MockWithRevokeToken(func(tok *provider.Token) error {
+			mut.Lock()
+			defer mut.Unlock()
+
+			revoked = append(revoked, tok.AccessToken)
+			return nil
+		}),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(req *logical.Request) *logical.Response {
+		req.Storage = storage
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+			"lease_tokens":  true,
+		},
+	})
+
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Data: map[string]interface{}{
+			"code": "123456",
+		},
+	})
+
+	resp := handle(&logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+	})
+	require.NotNil(t, resp.Secret)
+	assert.Empty(t, resp.Warnings)
+
+	// Revoking the lease revokes the access token at the provider.
+	resp = handle(&logical.Request{
+		Operation: logical.RevokeOperation,
+		Secret:    resp.Secret,
+	})
+	require.Nil(t, resp)
+
+	mut.Lock()
+	assert.Equal(t, []string{"token_1"}, revoked)
+	mut.Unlock()
+
+	// The credential can't be refreshed, so reading it fails until it is
+	// reauthorized.
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.True(t, resp != nil && resp.IsError())
+	code, ok := backend.ParseErrorCode(resp.Error().Error())
+	require.True(t, ok)
+	assert.Equal(t, backend.ErrorCodeTokenExpired, code)
+	assert.Contains(t, resp.Error().Error(), "no longer active")
+}
+
+func TestLeasedAccessTokenSharedRevocation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	var mut sync.Mutex
+	var revoked []string
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.RefreshableMockAuthCodeExchange(
+			testutil.IncrementMockAuthCodeExchange("token_"),
+			func(_ int) (time.Duration, error) { return 10 * time.Minute, nil },
+		)),
+		testutil.MockWithRevokeToken(func(tok *provider.Token) error {
+			mut.Lock()
+			defer mut.Unlock()
+
+			revoked = append(revoked, tok.AccessToken)
+			return nil
+		}),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	handle := func(req *logical.Request) *logical.Response {
+		req.Storage = storage
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+			"lease_tokens":  true,
+		},
+	})
+
+	handle(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Data: map[string]interface{}{
+			"code": "123456",
+		},
+	})
+
+	read := func() *logical.Response {
+		resp := handle(&logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+		})
+		require.NotNil(t, resp.Secret)
+		return resp
+	}
+	revoke := func(secret *logical.Secret) {
+		resp := handle(&logical.Request{
+			Operation: logical.RevokeOperation,
+			Secret:    secret,
+		})
+		require.Nil(t, resp)
+	}
+	revokedTokens := func() []string {
+		mut.Lock()
+		defer mut.Unlock()
+
+		return append([]string{}, revoked...)
+	}
+
+	first, second := read(), read()
+	assert.Equal(t, "token_1", first.Data["access_token"])
+	assert.Equal(t, "token_1", second.Data["access_token"])
+
+	// Another lease still holds the token, so it is not revoked.
+	revoke(first.Secret)
+	assert.Empty(t, revokedTokens())
+
+	resp := read()
+	assert.Equal(t, "token_1", resp.Data["access_token"])
+	assert.Empty(t, resp.Warnings)
+
+	revoke(second.Secret)
+	assert.Empty(t, revokedTokens())
+
+	// Revoking the last lease revokes the token at the provider.
+	revoke(resp.Secret)
+	assert.Equal(t, []string{"token_1"}, revokedTokens())
+
+	// The next read refreshes the revoked token.
+	resp = read()
+	assert.Equal(t, "token_2", resp.Data["access_token"])
+	assert.NotContains(t, resp.Data, "inactive_time")
+	assert.Empty(t, resp.Warnings)
+}
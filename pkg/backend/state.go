@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+const stateRandomSize = 24
+
+var (
+	ErrStateInvalid = errors.New("state is invalid")
+	ErrStateExpired = errors.New("state has expired")
+)
+
+func randomToken() (string, error) {
+	r := make([]byte, stateRandomSize)
+	if _, err := rand.Read(r); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(r), nil
+}
+
+// generateNonce creates a random nonce to bind an ID token to an authorization
+// request.
+func generateNonce() (string, error) {
+	return randomToken()
+}
+
+func signState(key []byte, name, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(name))
+	_, _ = mac.Write([]byte{0})
+	_, _ = mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// generateState creates a random state that can only be used with the given
+// credential name until the given expiry time. The state is signed with a key
+// held in storage so that it can be verified without consulting any other
+// data.
+func (b *backend) generateState(ctx context.Context, storage logical.Storage, name string, expiry time.Time) (string, error) {
+	key, err := b.data.Managers(storage).AuthCodeState().ReadOrCreateSigningKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	payload := r + "." + strconv.FormatInt(expiry.Unix(), 10)
+	return payload + "." + signState(key, name, payload), nil
+}
+
+// verifyState checks that the given state was generated for the given
+// credential name and has not expired.
+func (b *backend) verifyState(ctx context.Context, storage logical.Storage, name, state string) error {
+	i := strings.LastIndexByte(state, '.')
+	if i < 0 {
+		return ErrStateInvalid
+	}
+	payload, sig := state[:i], state[i+1:]
+
+	j := strings.LastIndexByte(payload, '.')
+	if j < 0 {
+		return ErrStateInvalid
+	}
+
+	expiry, err := strconv.ParseInt(payload[j+1:], 10, 64)
+	if err != nil {
+		return ErrStateInvalid
+	}
+
+	// The key is created before any state is generated, so a missing key
+	// means that the state was not signed by this mount. This path is also
+	// used on nodes that cannot write to storage, so it never creates the
+	// key.
+	key, err := b.data.Managers(storage).AuthCodeState().ReadSigningKey(ctx)
+	if err != nil {
+		return err
+	} else if key == nil {
+		return ErrStateInvalid
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signState(key, name, payload))) {
+		return ErrStateInvalid
+	}
+
+	if !time.Unix(expiry, 0).After(b.clock.Now()) {
+		return ErrStateExpired
+	}
+
+	return nil
+}
+
+// consumeState looks up the pending authorization code flow for the given state
+// and removes it so that it cannot be used again. If name is not empty, the
+// state must have been created for that credential. A nil entry is returned if
+// the state cannot be used.
+func (b *backend) consumeState(ctx context.Context, storage logical.Storage, state, name string) (*persistence.AuthCodeStateEntry, error) {
+	var entry *persistence.AuthCodeStateEntry
+	err := b.data.Managers(storage).AuthCodeState().WithLock(persistence.AuthCodeStateName(state), func(lasm *persistence.LockedAuthCodeStateManager) (err error) {
+		entry, err = lasm.ReadAuthCodeStateEntry(ctx)
+		if err != nil || entry == nil {
+			return
+		}
+
+		// A state presented for the wrong credential does not invalidate it
+		// for the right one.
+		if name != "" && entry.CredentialName != name {
+			entry = nil
+			return
+		}
+
+		return lasm.DeleteAuthCodeStateEntry(ctx)
+	})
+	switch {
+	case err != nil:
+		return nil, err
+	case entry == nil || entry.Expired(b.clock.Now()):
+		return nil, nil
+	case entry.Signed:
+		if err := b.verifyState(ctx, storage, entry.CredentialName, state); errors.Is(err, ErrStateInvalid) || errors.Is(err, ErrStateExpired) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,132 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratedState(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.RestrictMockAuthCodeExchange(map[string]testutil.MockAuthCodeExchangeFunc{
+			"123456": testutil.IncrementMockAuthCodeExchange("token_"),
+		})),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Ask the plugin to generate a state.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.AuthCodeURLCredsPathPrefix + "test",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"redirect_url": "http://example.com/redirect",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	state, ok := resp.Data["state"].(string)
+	require.True(t, ok)
+	require.NotEmpty(t, state)
+	assert.Contains(t, resp.Data["url"], state)
+
+	write := func(data map[string]interface{}) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			Storage:   storage,
+			Data:      data,
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// The state is now required.
+	resp = write(map[string]interface{}{"code": "123456"})
+	require.NotNil(t, resp)
+	assert.True(t, resp.IsError())
+
+	// Tampered states are rejected.
+	resp = write(map[string]interface{}{"code": "123456", "state": state + "x"})
+	require.NotNil(t, resp)
+	assert.True(t, resp.IsError())
+
+	// The state is only valid for the credential it was generated for.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `other`,
+		Storage:   storage,
+		Data:      map[string]interface{}{"code": "123456", "state": state},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.True(t, resp.IsError())
+
+	// The correct state is accepted.
+	resp = write(map[string]interface{}{"code": "123456", "state": state})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "token_1", resp.Data["access_token"])
+
+	// The state cannot be reused, but is no longer required.
+	resp = write(map[string]interface{}{"code": "123456", "state": state})
+	require.NotNil(t, resp)
+	assert.True(t, resp.IsError())
+
+	resp = write(map[string]interface{}{"code": "123456"})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+}
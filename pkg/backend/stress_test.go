@@ -0,0 +1,219 @@
+package backend_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	testclock "k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestConcurrentCredentialOperations(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	start := time.Now()
+	clk := testclock.NewFakeClock(start)
+
+	// Codes starting with "once-" are issued tokens that can't be refreshed,
+	// so the reaper deletes them shortly after they expire.
+	ledger := testutil.NewStressLedger()
+	exchange := ledger.AuthCodeExchange(func(code string) (time.Duration, bool) {
+		if strings.HasPrefix(code, "once-") {
+			return 2 * time.Minute, false
+		}
+
+		return 10 * time.Minute, true
+	}, testutil.MockExpiryWithClock(clk))
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":                           client.ID,
+			"client_secret":                       client.Secret,
+			"provider":                            "mock",
+			"tune_refresh_check_interval_seconds": "1m",
+			"tune_reap_check_interval_seconds":    "1m",
+			"tune_reap_non_refreshable_seconds":   "1m",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Advance the clock in the background so that the refresher and reaper
+	// run alongside the requests.
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+				clk.Step(2 * time.Minute)
+			}
+		}
+	}()
+
+	names := make([]string, 32)
+	for i := range names {
+		names[i] = fmt.Sprintf("stress-%d", i)
+	}
+	name := func(rnd *rand.Rand) string {
+		return backend.CredsPathPrefix + names[rnd.Intn(len(names))]
+	}
+
+	var codes int32
+
+	// A read may find the credential deleted or its token expired, but must
+	// never return an older token than a read that finished before it
+	// started.
+	checkRead := func(sr *testutil.StressResult) error {
+		switch {
+		case sr.Err != nil:
+			return sr.Err
+		case sr.Response == nil:
+			return nil
+		case sr.Response.IsError():
+			if code, _ := backend.ParseErrorCode(sr.Response.Error().Error()); code != backend.ErrorCodeTokenExpired {
+				return sr.Response.Error()
+			}
+
+			return nil
+		}
+
+		return ledger.Observe(sr.Response.Data["access_token"].(string), sr.Start, sr.End)
+	}
+
+	st := &testutil.StressTest{
+		Backend:    b,
+		Storage:    storage,
+		Workers:    16,
+		Iterations: 200,
+		Seed:       time.Now().UnixNano(),
+		Operations: []testutil.StressOperation{
+			{
+				Name:   "write",
+				Weight: 2,
+				Request: func(rnd *rand.Rand) *logical.Request {
+					code := fmt.Sprintf("code-%d", atomic.AddInt32(&codes, 1))
+					if rnd.Intn(4) == 0 {
+						code = "once-" + code
+					}
+
+					return &logical.Request{
+						Operation: logical.UpdateOperation,
+						Path:      name(rnd),
+						Data:      map[string]interface{}{"code": code},
+					}
+				},
+			},
+			{
+				Name:   "read",
+				Weight: 6,
+				Request: func(rnd *rand.Rand) *logical.Request {
+					return &logical.Request{
+						Operation: logical.ReadOperation,
+						Path:      name(rnd),
+					}
+				},
+				Check: checkRead,
+			},
+			{
+				// Asking for a token that lasts longer than the provider ever
+				// issues forces a refresh every time.
+				Name:   "refresh",
+				Weight: 2,
+				Request: func(rnd *rand.Rand) *logical.Request {
+					return &logical.Request{
+						Operation: logical.ReadOperation,
+						Path:      name(rnd),
+						Data:      map[string]interface{}{"minimum_seconds": 3600},
+					}
+				},
+				Check: checkRead,
+			},
+			{
+				Name: "delete",
+				Request: func(rnd *rand.Rand) *logical.Request {
+					return &logical.Request{
+						Operation: logical.DeleteOperation,
+						Path:      name(rnd),
+					}
+				},
+			},
+		},
+	}
+
+	report := st.Run(ctx)
+	require.NoError(t, report.Err(), "seed %d", st.Seed)
+	for _, op := range []string{"write", "read", "refresh", "delete"} {
+		assert.NotZero(t, report.Requests[op], "no %s requests made", op)
+	}
+
+	// The clock must keep moving until the background processes stop, as
+	// they may be waiting to retry a write.
+	b.Clean(ctx)
+	close(stop)
+	<-stopped
+
+	// With the background processes stopped, each remaining credential must
+	// hold the last token issued for its grant. We read them from a backend
+	// that never starts the background processes and whose clock is set to
+	// before any token was issued, so that reading doesn't refresh them.
+	b = backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(testclock.NewFakeClock(start)),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	for _, name := range names {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + name,
+			Storage:   storage,
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		if resp == nil || resp.IsError() {
+			continue
+		}
+
+		assert.NoError(t, ledger.Current(resp.Data["access_token"].(string)), "credential %s", name)
+	}
+}
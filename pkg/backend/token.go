@@ -1,9 +1,12 @@
 package backend
 
 import (
+	"context"
 	"time"
 
+	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/puppetlabs/leg/timeutil/pkg/clock"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
 )
 
@@ -11,7 +14,10 @@ const (
 	defaultExpiryDelta = 10 * time.Second
 )
 
-func tokenExpired(clk clock.Clock, t *provider.Token, expiryDelta time.Duration) bool {
+// tokenExpired returns true if the given token expires within expiryDelta of
+// now. The leeway is added to the expiry time to tolerate a provider clock that
+// differs from ours.
+func tokenExpired(clk clock.Clock, t *provider.Token, expiryDelta, leeway time.Duration) bool {
 	if t.Expiry.IsZero() {
 		return false
 	}
@@ -20,9 +26,31 @@ func tokenExpired(clk clock.Clock, t *provider.Token, expiryDelta time.Duration)
 		expiryDelta = defaultExpiryDelta
 	}
 
-	return t.Expiry.Round(0).Add(-expiryDelta).Before(clk.Now())
+	return t.Expiry.Round(0).Add(leeway - expiryDelta).Before(clk.Now())
 }
 
-func (b *backend) tokenValid(tok *provider.Token, expiryDelta time.Duration) bool {
-	return tok != nil && tok.AccessToken != "" && !tokenExpired(b.clock, tok, expiryDelta)
+func (b *backend) tokenValid(tok *provider.Token, expiryDelta, leeway time.Duration) bool {
+	return tok != nil && tok.AccessToken != "" && !tokenExpired(b.clock, tok, expiryDelta, leeway)
+}
+
+// credTokenValid is like tokenValid, but also treats the token of the given
+// credential as invalid if it has been revoked or the provider reports that it
+// is no longer active.
+func (b *backend) credTokenValid(entry *persistence.AuthCodeEntry, expiryDelta, leeway time.Duration) bool {
+	return entry.InactiveTime.IsZero() && b.tokenValid(entry.Token, expiryDelta, leeway)
+}
+
+func expiryLeeway(tuning persistence.ConfigTuningEntry) time.Duration {
+	return time.Duration(tuning.ExpiryLeewaySeconds) * time.Second
+}
+
+// expiryLeeway returns the expiry leeway of the current configuration, or 0 if
+// the mount is not configured.
+func (b *backend) expiryLeeway(ctx context.Context, storage logical.Storage) (time.Duration, error) {
+	c, err := b.getCache(ctx, storage)
+	if err != nil || c == nil {
+		return 0, err
+	}
+
+	return expiryLeeway(c.Config.Tuning), nil
 }
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
@@ -14,14 +15,20 @@ import (
 	"github.com/puppetlabs/leg/timeutil/pkg/backoff"
 	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
 	"github.com/puppetlabs/leg/timeutil/pkg/retry"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/semerr"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
 )
 
+// rotatedWriteRetries is the number of additional attempts to make to store a
+// credential after its refresh token is rotated.
+const rotatedWriteRetries = 4
+
 type refreshProcess struct {
-	backend     *backend
-	storage     logical.Storage
-	keyer       persistence.AuthCodeKeyer
-	expiryDelta time.Duration
+	backend *backend
+	storage logical.Storage
+	keyer   persistence.AuthCodeKeyer
+	tuning  persistence.ConfigTuningEntry
 }
 
 var _ scheduler.Process = &refreshProcess{}
@@ -31,8 +38,64 @@ func (rp *refreshProcess) Description() string {
 }
 
 func (rp *refreshProcess) Run(ctx context.Context) error {
-	_, err := rp.backend.getRefreshCredToken(ctx, rp.storage, rp.keyer, rp.expiryDelta)
-	return err
+	ctx, release := rp.backend.leaseCache(ctx)
+	defer release()
+
+	// The refresh window may be overridden by the credential itself.
+	entry, err := rp.backend.data.Managers(rp.storage).AuthCode().ReadAuthCodeEntry(ctx, rp.keyer)
+	if err != nil {
+		return err
+	} else if entry == nil {
+		rp.backend.refreshSchedule.AuthCodeDeleted(rp.keyer)
+		return nil
+	}
+
+	// Don't keep contacting a provider that appears to be down.
+	c, err := rp.backend.getCache(ctx, rp.storage)
+	if err != nil {
+		return err
+	} else if c != nil && !c.Breaker.Allow(rp.backend.clock.Now()) {
+		return nil
+	}
+
+	entry, err = rp.backend.getRefreshCredToken(ctx, rp.storage, rp.keyer, refreshExpiryDelta(entry.Tuning.Apply(rp.tuning)))
+	if err != nil {
+		return err
+	}
+
+	if rp.backend.readOnly() {
+		rp.backend.refreshSchedule.AuthCodeWritten(rp.keyer, entry)
+		return nil
+	}
+
+	// Record that we looked at this credential. Writing the entry also makes
+	// sure the schedule reflects it even if it was not refreshed, such as when
+	// it was invalidated by another node.
+	return rp.backend.data.Managers(rp.storage).AuthCode().WithLock(rp.keyer, func(cm *persistence.LockedAuthCodeManager) error {
+		entry, err := cm.ReadAuthCodeEntry(ctx)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		entry.LastRefreshCheckTime = rp.backend.clock.Now()
+		return cm.WriteAuthCodeEntry(ctx, entry)
+	})
+}
+
+// refreshExpiryDelta returns the window before a token expires in which the
+// automatic refresher will refresh it. It is the larger of the check interval
+// scaled by the expiry delta factor and the absolute minimum configured by the
+// operator.
+func refreshExpiryDelta(tuning persistence.ConfigTuningEntry) time.Duration {
+	expiryDeltaSeconds := float64(tuning.RefreshCheckIntervalSeconds) * tuning.RefreshExpiryDeltaFactor
+	if before := float64(tuning.RefreshBeforeExpirySeconds); before > expiryDeltaSeconds {
+		expiryDeltaSeconds = before
+	}
+	if lim := float64(math.MaxInt64 / time.Second); expiryDeltaSeconds > lim {
+		expiryDeltaSeconds = lim
+	}
+
+	return time.Duration(expiryDeltaSeconds) * time.Second
 }
 
 type refreshDescriptor struct {
@@ -47,84 +110,192 @@ func (rd *refreshDescriptor) Run(ctx context.Context, pc chan<- scheduler.Proces
 	switch {
 	case err != nil:
 		return err
-	case c == nil || c.Config.Tuning.RefreshCheckIntervalSeconds <= 0:
+	case c == nil || c.Config.Tuning.RefreshCheckIntervalSeconds <= 0 || c.Config.MaintenanceMode:
 		return nil
 	}
 
 	refreshInterval := time.Duration(c.Config.Tuning.RefreshCheckIntervalSeconds) * time.Second
 
-	expiryDeltaSeconds := float64(c.Config.Tuning.RefreshCheckIntervalSeconds) * c.Config.Tuning.RefreshExpiryDeltaFactor
-	if lim := float64(math.MaxInt64 / time.Second); expiryDeltaSeconds > lim {
-		expiryDeltaSeconds = lim
-	}
+	for {
+		now := rd.backend.clock.Now()
+
+		// Reconcile with storage on startup (including after a failover or
+		// configuration change) and periodically thereafter.
+		if rd.backend.refreshSchedule.RebuildDue(now.Add(-refreshScheduleRebuildInterval)) {
+			if err := rd.backend.refreshSchedule.Rebuild(ctx, rd.backend, rd.storage, c.Config.Tuning); err != nil {
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					return nil
+				}
+				return err
+			}
+		}
 
-	b := backoff.Build(
-		backoff.Constant(refreshInterval),
-		backoff.NonSliding,
-	)
-	err = retry.Wait(ctx, func(ctx context.Context) (bool, error) {
 		rd.backend.logger.Debug("running automatic credential refresh")
 
-		err := rd.backend.data.Managers(rd.storage).AuthCode().ForEachAuthCodeKey(ctx, func(keyer persistence.AuthCodeKeyer) {
+		// Credentials are dispatched in the order they expire. Any that are
+		// not refreshed (for example, because of an error) come around again
+		// after the check interval.
+		for _, keyer := range rd.backend.refreshSchedule.Due(now, refreshInterval) {
 			proc := &refreshProcess{
-				backend:     rd.backend,
-				storage:     rd.storage,
-				keyer:       keyer,
-				expiryDelta: time.Duration(expiryDeltaSeconds) * time.Second,
+				backend: rd.backend,
+				storage: rd.storage,
+				keyer:   keyer,
+				tuning:  c.Config.Tuning,
 			}
 
 			select {
 			case pc <- proc:
 			case <-ctx.Done():
+				return nil
 			}
-		})
-		if err != nil {
-			return retry.Done(err)
 		}
 
-		return retry.Repeat(nil)
-	}, retry.WithClock(rd.backend.clock), retry.WithBackoffFactory(b))
-	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-		return nil
+		// Sleep until the next credential is due, but no longer than the
+		// check interval so that the schedule is rebuilt on time.
+		wait := refreshInterval
+		if next, ok := rd.backend.refreshSchedule.Next(); ok {
+			if d := next.Sub(now); d < wait {
+				wait = d
+			}
+		}
+
+		timer := rd.backend.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-rd.backend.refreshSchedule.Wake():
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
 	}
-	return err
 }
 
 func (b *backend) refreshCredToken(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer, expiryDelta time.Duration) (*persistence.AuthCodeEntry, error) {
+	leeway, err := b.expiryLeeway(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
 	var entry *persistence.AuthCodeEntry
-	err := b.data.Managers(storage).AuthCode().WithLock(keyer, func(cm *persistence.LockedAuthCodeManager) error {
+	err = b.data.Managers(storage).AuthCode().WithLock(keyer, func(cm *persistence.LockedAuthCodeManager) error {
 		// In case someone else refreshed this token from under us, we'll re-request
 		// it here with the lock acquired.
 		candidate, err := cm.ReadAuthCodeEntry(ctx)
 		switch {
 		case err != nil || candidate == nil:
 			return err
-		case !candidate.TokenIssued() || b.tokenValid(candidate.Token, expiryDelta) || candidate.RefreshToken == "":
+		case candidate.Disabled || (!candidate.TokenIssued() && !candidate.RefreshDeferred()) || b.credTokenValid(candidate, expiryDelta, leeway) || !candidate.Refreshable():
 			entry = candidate
 			return nil
 		}
 
+		if b.readOnly() {
+			return logical.ErrReadOnly
+		}
+
 		c, err := b.getCache(ctx, storage)
 		if err != nil {
 			return err
 		} else if c == nil {
 			return ErrNotConfigured
+		} else if c.Config.MaintenanceMode {
+			return ErrMaintenanceMode
 		}
 
-		// Refresh.
-		refreshed, err := c.
-			ProviderWithTimeout(expiryDelta).
-			Private(c.Config.ClientID, c.Config.ClientSecret).
-			RefreshToken(clockctx.WithClock(ctx, b.clock), candidate.Token)
-		if err != nil {
-			msg := errmap.Wrap(errmark.MarkShort(err), "refresh failed").Error()
-			if errmark.MarkedUser(err) {
-				candidate.SetUserError(msg)
-			} else {
-				candidate.SetTransientError(msg)
+		// Credentials issued using a JWT bearer grant request their scopes
+		// again on every refresh, so the scope policy may have changed since.
+		if candidate.JWTBearer != nil {
+			if disallowed := c.Config.DisallowedScopes(candidate.JWTBearer.Scopes); len(disallowed) > 0 {
+				return scopeNotAllowedError(disallowed)
 			}
+		}
+
+		// Refresh. Credentials issued using a JWT bearer grant don't
+		// generally have a refresh token, so we mint a new assertion instead.
+		var refreshed *provider.Token
+		if candidate.JWTBearer != nil {
+			refreshed, err = b.jwtBearerExchange(ctx, c, candidate.JWTBearer)
 		} else {
-			candidate.SetToken(refreshed)
+			// A provider that can't be constructed says nothing about this
+			// credential, so it isn't recorded as an error against it.
+			var p provider.Provider
+			p, err = c.ProviderWithTuning(candidate.Tuning.Apply(c.Config.Tuning))
+			if err != nil {
+				c.Breaker.Failure(b.clock.Now(), err)
+				return err
+			}
+
+			refreshed, err = p.
+				Private(c.Config.ClientID, c.Config.ClientSecret).
+				RefreshToken(clockctx.WithClock(ctx, b.clock), candidate.Token, provider.WithResources(candidate.Resources))
+
+			// The provider may not have picked up a recently rotated client
+			// secret yet, so we fall back to the previous one.
+			if semerr.IsCode(err, "invalid_client") && c.Config.PreviousClientSecretValid(b.clock.Now()) {
+				refreshed, err = p.
+					Private(c.Config.ClientID, c.Config.PreviousClientSecret).
+					RefreshToken(clockctx.WithClock(ctx, b.clock), candidate.Token, provider.WithResources(candidate.Resources))
+			}
+		}
+		switch {
+		case err == nil, semerr.IsCode(err, "invalid_grant"), errmark.MarkedUser(err):
+			// The provider responded, even if it rejected this credential.
+			c.Breaker.Success()
+		case !errors.Is(err, context.Canceled):
+			c.Breaker.Failure(b.clock.Now(), err)
+		}
+
+		var failure string
+		switch {
+		case err == nil:
+			downgradeTime := candidate.ScopeDowngradeTime
+
+			candidate.SetRefreshedToken(refreshed, b.clock.Now())
+			candidate.SetClaimMetadata(c.Config.ClaimMetadata)
+			b.logCredEvent(ctx, c, credEventRefreshed, candidate.Name, "")
+			b.recordCredHistory(c, candidate, credHistoryEventRefreshed, "")
+
+			if candidate.ScopesDowngraded() && !candidate.ScopeDowngradeTime.Equal(downgradeTime) {
+				revoked := strings.Join(candidate.RevokedScopes, " ")
+				b.logger.Warn("provider no longer grants some scopes to credential", "key", keyer.AuthCodeKey(), "revoked_scopes", revoked)
+				b.logCredEvent(ctx, c, credEventScopeDowngraded, candidate.Name, revoked)
+			}
+
+			// If the provider rotated the refresh token, the one we have
+			// stored is no longer valid, so the new one must be persisted
+			// before we report success.
+			if candidate.RefreshTokenRotated {
+				if err := b.writeRotatedAuthCodeEntry(ctx, cm, candidate); err != nil {
+					b.logger.Error("failed to store rotated refresh token; the credential must be reauthorized", "key", keyer.AuthCodeKey(), "error", err)
+					return err
+				}
+
+				entry = candidate
+				return nil
+			}
+		case semerr.IsCode(err, "invalid_grant"):
+			failure = errmap.Wrap(errmark.MarkShort(err), "refresh failed").Error()
+			if candidate.RefreshTokenRotated {
+				// Providers that rotate refresh tokens reject any token that
+				// has already been used, and usually revoke the entire grant
+				// when they see one.
+				failure += " (the refresh token may have been reused)"
+			}
+
+			candidate.SetReauthorizationRequired(failure, b.clock.Now())
+		case errmark.MarkedUser(err):
+			failure = errmap.Wrap(errmark.MarkShort(err), "refresh failed").Error()
+			candidate.SetUserError(failure, b.clock.Now())
+		default:
+			failure = errmap.Wrap(errmark.MarkShort(err), "refresh failed").Error()
+			candidate.SetTransientError(failure, b.clock.Now())
+		}
+
+		if err != nil {
+			candidate.LastProviderResponseCode, _ = semerr.StatusCode(err)
+			b.logCredEvent(ctx, c, credEventRefreshFailed, candidate.Name, errmark.MarkShort(err).Error())
+			b.recordCredHistory(c, candidate, credHistoryEventRefreshFailed, failure)
 		}
 
 		if err := cm.WriteAuthCodeEntry(ctx, candidate); err != nil {
@@ -137,14 +308,119 @@ func (b *backend) refreshCredToken(ctx context.Context, storage logical.Storage,
 	return entry, err
 }
 
+// writeRotatedAuthCodeEntry stores a credential that contains a newly rotated
+// refresh token. Because the provider has already invalidated the previous
+// refresh token, we retry the write a few times before giving up.
+func (b *backend) writeRotatedAuthCodeEntry(ctx context.Context, cm *persistence.LockedAuthCodeManager, entry *persistence.AuthCodeEntry) error {
+	// The first attempt must not wait for the backoff, otherwise the token is
+	// lost if the context is canceled in the meantime (for example, when the
+	// plugin shuts down).
+	err := cm.WriteAuthCodeEntry(ctx, entry)
+	if err == nil || errors.Is(err, logical.ErrReadOnly) {
+		return err
+	}
+
+	bf := backoff.Build(
+		backoff.Exponential(100*time.Millisecond, 2),
+		backoff.MaxRetries(rotatedWriteRetries-1),
+	)
+	return retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		err := cm.WriteAuthCodeEntry(ctx, entry)
+		switch {
+		case err == nil:
+			return retry.Done(nil)
+		case errors.Is(err, logical.ErrReadOnly):
+			return retry.Done(err)
+		default:
+			return retry.Repeat(err)
+		}
+	}, retry.WithClock(b.clock), retry.WithBackoffFactory(bf))
+}
+
+// issueCredTokenWithOptions uses the refresh token of a credential to issue
+// an access token with additional provider options. The access token is
+// returned to the caller instead of being stored with the credential, but if
+// the provider rotates the refresh token, the new one is persisted.
+func (b *backend) issueCredTokenWithOptions(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer, opts map[string]string) (*provider.Token, error) {
+	var tok *provider.Token
+	err := b.data.Managers(storage).AuthCode().WithLock(keyer, func(cm *persistence.LockedAuthCodeManager) error {
+		candidate, err := cm.ReadAuthCodeEntry(ctx)
+		switch {
+		case err != nil || candidate == nil:
+			return err
+		case candidate.Disabled || !candidate.TokenIssued() || !candidate.Refreshable() || candidate.JWTBearer != nil:
+			return nil
+		}
+
+		if b.readOnly() {
+			return logical.ErrReadOnly
+		}
+
+		c, err := b.getCache(ctx, storage)
+		if err != nil {
+			return err
+		} else if c == nil {
+			return ErrNotConfigured
+		} else if c.Config.MaintenanceMode {
+			return ErrMaintenanceMode
+		}
+
+		p, err := c.ProviderWithTuning(candidate.Tuning.Apply(c.Config.Tuning))
+		if err != nil {
+			c.Breaker.Failure(b.clock.Now(), err)
+			return err
+		}
+
+		tok, err = p.
+			Private(c.Config.ClientID, c.Config.ClientSecret).
+			RefreshToken(
+				clockctx.WithClock(ctx, b.clock),
+				candidate.Token,
+				provider.WithResources(candidate.Resources),
+				provider.WithProviderOptions(opts),
+			)
+		switch {
+		case err == nil, semerr.IsCode(err, "invalid_grant"), errmark.MarkedUser(err):
+			c.Breaker.Success()
+		case !errors.Is(err, context.Canceled):
+			c.Breaker.Failure(b.clock.Now(), err)
+		}
+		if err != nil {
+			// The provider options given by the caller may be the reason
+			// the provider rejected this request, so it isn't recorded as
+			// an error against the credential.
+			return err
+		}
+
+		if tok.RefreshToken == "" || tok.RefreshToken == candidate.RefreshToken {
+			return nil
+		}
+
+		candidate.RefreshToken = tok.RefreshToken
+		candidate.RefreshTokenRotated = true
+		if err := b.writeRotatedAuthCodeEntry(ctx, cm, candidate); err != nil {
+			b.logger.Error("failed to store rotated refresh token; the credential must be reauthorized", "key", keyer.AuthCodeKey(), "error", err)
+			return err
+		}
+
+		return nil
+	})
+	return tok, err
+}
+
 func (b *backend) getRefreshCredToken(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer, expiryDelta time.Duration) (*persistence.AuthCodeEntry, error) {
+	leeway, err := b.expiryLeeway(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
 	entry, err := b.data.Managers(storage).AuthCode().ReadAuthCodeEntry(ctx, keyer)
 	switch {
 	case err != nil:
 		return nil, err
 	case entry == nil:
 		return nil, nil
-	case !entry.TokenIssued() || b.tokenValid(entry.Token, expiryDelta):
+	case entry.Disabled || (!entry.TokenIssued() && !entry.RefreshDeferred()) || b.credTokenValid(entry, expiryDelta, leeway):
 		return entry, nil
 	default:
 		return b.refreshCredToken(ctx, storage, keyer, expiryDelta)
@@ -0,0 +1,155 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmap"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/scheduler"
+	"github.com/puppetlabs/leg/timeutil/pkg/backoff"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/leg/timeutil/pkg/retry"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/semerr"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+)
+
+type authCodeExchangeProcess struct {
+	backend *backend
+	storage logical.Storage
+	keyer   persistence.AuthCodeKeyer
+}
+
+var _ scheduler.Process = &authCodeExchangeProcess{}
+
+func (acep *authCodeExchangeProcess) Description() string {
+	return fmt.Sprintf("authorization code exchange (%s)", acep.keyer.AuthCodeKey())
+}
+
+func (acep *authCodeExchangeProcess) Run(ctx context.Context) error {
+	ctx, release := acep.backend.leaseCache(ctx)
+	defer release()
+
+	return acep.backend.exchangeAuthCodeAsync(ctx, acep.storage, acep.keyer)
+}
+
+type authCodeExchangeDescriptor struct {
+	backend *backend
+	storage logical.Storage
+}
+
+var _ scheduler.Descriptor = &authCodeExchangeDescriptor{}
+
+func (aced *authCodeExchangeDescriptor) Run(ctx context.Context, pc chan<- scheduler.Process) error {
+	b := backoff.Build(
+		backoff.Constant(time.Second),
+		backoff.NonSliding,
+	)
+	err := retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		// Exchanged tokens are stored by the active node.
+		if aced.backend.readOnly() {
+			return retry.Repeat(nil)
+		}
+
+		// Pending exchanges are resumed once maintenance is over.
+		if maintenance, err := aced.backend.maintenanceMode(ctx, aced.storage); err != nil {
+			return retry.Done(err)
+		} else if maintenance {
+			return retry.Repeat(nil)
+		}
+
+		err := aced.backend.data.Managers(aced.storage).AuthCode().ForEachAuthCodeExchangeKey(ctx, func(keyer persistence.AuthCodeKeyer) {
+			proc := &authCodeExchangeProcess{
+				backend: aced.backend,
+				storage: aced.storage,
+				keyer:   keyer,
+			}
+
+			select {
+			case pc <- proc:
+			case <-ctx.Done():
+			}
+		})
+		if err != nil {
+			return retry.Done(err)
+		}
+
+		return retry.Repeat(nil)
+	}, retry.WithClock(aced.backend.clock), retry.WithBackoffFactory(b))
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
+
+// exchangeAuthCodeAsync performs an authorization code exchange that was
+// submitted to run in the background.
+func (b *backend) exchangeAuthCodeAsync(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer) error {
+	return b.data.Managers(storage).AuthCode().WithLock(keyer, func(cm *persistence.LockedAuthCodeManager) error {
+		exchange, err := cm.ReadAuthCodeExchangeEntry(ctx)
+		if err != nil || exchange == nil || exchange.Failed() {
+			return err
+		}
+
+		ct, err := cm.ReadAuthCodeEntry(ctx)
+		switch {
+		case err != nil:
+			return err
+		case ct == nil || ct.TokenIssued():
+			// The credential was deleted or replaced, so this exchange is no
+			// longer wanted.
+			return cm.DeleteAuthCodeExchangeEntry(ctx)
+		}
+
+		c, err := b.getCache(ctx, storage)
+		if err != nil {
+			return err
+		} else if c == nil {
+			return ErrNotConfigured
+		}
+
+		p, err := c.ProviderWithTimeout()
+		if err != nil {
+			return err
+		}
+
+		tok, err := p.Private(c.Config.ClientID, c.Config.ClientSecret).AuthCodeExchange(
+			clockctx.WithClock(ctx, b.clock),
+			exchange.Code,
+			provider.WithRedirectURL(exchange.RedirectURL),
+			provider.WithResources(exchange.Resources),
+			provider.WithProviderOptions(exchange.ProviderOptions),
+		)
+		if err != nil {
+			// The provider may have spent the code even if it did not
+			// respond, so the exchange is never attempted again. The failed
+			// exchange is kept so that readers of the credential can tell
+			// what happened to it.
+			msg := errmap.Wrap(errmark.MarkShort(err), "exchange failed").Error()
+			ct.SetUserError(msg, b.clock.Now())
+			ct.LastProviderResponseCode, _ = semerr.StatusCode(err)
+			b.recordCredHistory(c, ct, credHistoryEventExchangeFailed, msg)
+
+			exchange.SetFailed(b.clock.Now())
+			if err := cm.WriteAuthCodeExchangeEntry(ctx, exchange); err != nil {
+				return err
+			}
+
+			return cm.WriteAuthCodeEntry(ctx, ct)
+		}
+
+		ct.SetToken(tok, b.clock.Now())
+		ct.SetClaimMetadata(c.Config.ClaimMetadata)
+		b.recordCredHistory(c, ct, credHistoryEventExchanged, "")
+
+		if err := cm.WriteAuthCodeEntry(ctx, ct); err != nil {
+			return err
+		}
+
+		return cm.DeleteAuthCodeExchangeEntry(ctx)
+	})
+}
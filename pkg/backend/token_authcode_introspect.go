@@ -0,0 +1,205 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/scheduler"
+	"github.com/puppetlabs/leg/timeutil/pkg/backoff"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/leg/timeutil/pkg/retry"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+)
+
+// introspectionOperations returns the provider operations used to check
+// whether access tokens are active, or nil if the configured provider cannot
+// check them.
+func introspectionOperations(c *cache) (provider.IntrospectionOperations, error) {
+	p, err := c.ProviderWithTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	ops, ok := p.Private(c.Config.ClientID, c.Config.ClientSecret).(provider.IntrospectionOperations)
+	if !ok || !ops.SupportsIntrospection() {
+		return nil, nil
+	}
+
+	return ops, nil
+}
+
+// introspectCredToken asks the provider whether the access token of a
+// credential is still active and flags the credential if the provider reports
+// that it is not, even though it has not expired. This catches tokens revoked
+// at the provider without the plugin's knowledge.
+func (b *backend) introspectCredToken(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer) error {
+	c, err := b.getCache(ctx, storage)
+	if err != nil {
+		return err
+	} else if c == nil {
+		return ErrNotConfigured
+	}
+
+	ops, err := introspectionOperations(c)
+	if err != nil || ops == nil {
+		return err
+	}
+
+	return b.data.Managers(storage).AuthCode().WithLock(keyer, func(cm *persistence.LockedAuthCodeManager) error {
+		entry, err := cm.ReadAuthCodeEntry(ctx)
+		if err != nil || entry == nil || entry.Disabled || !entry.TokenIssued() {
+			return err
+		}
+
+		// Tokens that have expired are already handled by the refresher and
+		// the reaper.
+		if !b.tokenValid(entry.Token, 0, expiryLeeway(c.Config.Tuning)) {
+			return nil
+		}
+
+		pctx := clockctx.WithClock(ctx, b.clock)
+
+		active, err := ops.IntrospectToken(pctx, entry.Token)
+		if err != nil {
+			b.logger.Warn("failed to introspect access token", "credential", entry.Name, "error", err)
+			return nil
+		}
+
+		switch {
+		case active && !entry.InactiveTime.IsZero():
+			entry.InactiveTime = time.Time{}
+		case !active && entry.InactiveTime.IsZero():
+			entry.InactiveTime = b.clock.Now()
+
+			b.logCredEvent(ctx, c, credEventInactive, entry.Name, "")
+			b.logger.Warn("provider reports access token is not active", "credential", entry.Name)
+		default:
+			return nil
+		}
+
+		return cm.WriteAuthCodeEntry(ctx, entry)
+	})
+}
+
+type introspectionProcess struct {
+	backend *backend
+	storage logical.Storage
+	keyer   persistence.AuthCodeKeyer
+}
+
+var _ scheduler.Process = &introspectionProcess{}
+
+func (ip *introspectionProcess) Description() string {
+	return fmt.Sprintf("access token introspection (%s)", ip.keyer.AuthCodeKey())
+}
+
+func (ip *introspectionProcess) Run(ctx context.Context) error {
+	ctx, release := ip.backend.leaseCache(ctx)
+	defer release()
+
+	return ip.backend.introspectCredToken(ctx, ip.storage, ip.keyer)
+}
+
+type introspectionDescriptor struct {
+	backend *backend
+	storage logical.Storage
+}
+
+var _ scheduler.Descriptor = &introspectionDescriptor{}
+
+func (id *introspectionDescriptor) Run(ctx context.Context, pc chan<- scheduler.Process) error {
+	c, err := id.backend.getCache(ctx, id.storage)
+	switch {
+	case err != nil:
+		return err
+	case c == nil || c.Config.Tuning.IntrospectionCheckIntervalSeconds <= 0:
+		return nil
+	}
+
+	interval := time.Duration(c.Config.Tuning.IntrospectionCheckIntervalSeconds) * time.Second
+	sampleSize := c.Config.Tuning.IntrospectionSampleSize
+
+	b := backoff.Build(
+		backoff.Constant(interval),
+		backoff.NonSliding,
+	)
+	err = retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		// Credentials are updated by the active node, and the provider is
+		// not contacted while requests to it are paused.
+		if id.backend.readOnly() || c.Config.MaintenanceMode {
+			return retry.Repeat(nil)
+		}
+
+		if ops, err := introspectionOperations(c); err != nil {
+			id.backend.logger.Warn("failed to load provider for introspection", "error", err)
+			return retry.Repeat(nil)
+		} else if ops == nil {
+			return retry.Repeat(nil)
+		}
+
+		id.backend.logger.Debug("running access token introspection")
+
+		dispatch := func(keyers []persistence.AuthCodeKeyer) error {
+			for _, keyer := range keyers {
+				proc := &introspectionProcess{
+					backend: id.backend,
+					storage: id.storage,
+					keyer:   keyer,
+				}
+
+				select {
+				case pc <- proc:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		}
+
+		acm := id.backend.data.Managers(id.storage).AuthCode()
+		pacer := newScanPacer(id.backend.clock, c.Config.Tuning)
+
+		// Without a sample size, every credential is checked a page at a
+		// time. Otherwise, a uniform random sample of credentials is chosen
+		// while storage is listed.
+		var sample []persistence.AuthCodeKeyer
+		seen := 0
+
+		err := acm.ForEachAuthCodeKeyPage(ctx, c.Config.Tuning.StorageScanPageSize, func(page []persistence.AuthCodeKeyer) error {
+			if err := pacer.Wait(ctx); err != nil {
+				return err
+			}
+
+			if sampleSize <= 0 {
+				return dispatch(page)
+			}
+
+			for _, keyer := range page {
+				seen++
+				if len(sample) < sampleSize {
+					sample = append(sample, keyer)
+				} else if i := rand.Intn(seen); i < sampleSize {
+					sample[i] = keyer
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			err = dispatch(sample)
+		}
+		if err != nil {
+			return retry.Done(err)
+		}
+
+		return retry.Repeat(nil)
+	}, retry.WithClock(id.backend.clock), retry.WithBackoffFactory(b))
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package backend_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/retry"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exchange := testutil.RefreshableMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(_ int) (time.Duration, error) { return time.Hour, nil },
+	)
+
+	var mut sync.Mutex
+	inactive := make(map[string]bool)
+	setActive := func(token string, active bool) {
+		mut.Lock()
+		defer mut.Unlock()
+
+		inactive[token] = !active
+	}
+	introspect := func(tok *provider.Token) (bool, error) {
+		mut.Lock()
+		defer mut.Unlock()
+
+		return !inactive[tok.AccessToken], nil
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, exchange),
+		testutil.MockWithIntrospectToken(introspect),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, map[string]interface{}{
+		"client_id":     client.ID,
+		"client_secret": client.Secret,
+		"provider":      "mock",
+		"tune_introspection_check_interval_seconds": 1,
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+"test", map[string]interface{}{
+		"code": "test",
+	})
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"test", nil)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	token := resp.Data["access_token"].(string)
+	assert.NotContains(t, resp.Data, "inactive_time")
+	assert.Empty(t, resp.Warnings)
+
+	// Listing credentials reports the flag without refreshing the token.
+	waitFor := func(inactive bool) {
+		require.NoError(t, retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+			resp := handle(logical.ListOperation, backend.CredsPathPrefix, nil)
+			if resp == nil || resp.IsError() {
+				return retry.Done(fmt.Errorf("unexpected response: %+v", resp))
+			}
+
+			if _, found := resp.Data["key_info"].(map[string]interface{})["test"].(map[string]interface{})["inactive_time"]; found != inactive {
+				return retry.Repeat(fmt.Errorf("credential not flagged as inactive: %t", inactive))
+			}
+
+			return retry.Done(nil)
+		}))
+	}
+
+	// The provider reports the token revoked, so the credential is flagged
+	// even though the token has not expired.
+	setActive(token, false)
+	waitFor(true)
+
+	// The flag is cleared if the provider reports the token active again.
+	setActive(token, true)
+	waitFor(false)
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"test", nil)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, token, resp.Data["access_token"])
+
+	// Reading a credential whose token is no longer active refreshes it.
+	setActive(token, false)
+	waitFor(true)
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"test", nil)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	assert.NotEqual(t, token, resp.Data["access_token"])
+	assert.NotContains(t, resp.Data, "inactive_time")
+	assert.Empty(t, resp.Warnings)
+}
@@ -0,0 +1,149 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/scheduler"
+	"github.com/puppetlabs/leg/timeutil/pkg/backoff"
+	"github.com/puppetlabs/leg/timeutil/pkg/retry"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+// defaultReauthorizationNotifyTimeout is the time allowed for each request to
+// the reauthorization webhook when no provider timeout is configured. The
+// credential remains locked while the request is in progress.
+const defaultReauthorizationNotifyTimeout = 30 * time.Second
+
+type reauthorizationNotifyProcess struct {
+	backend *backend
+	storage logical.Storage
+	keyer   persistence.AuthCodeKeyer
+	url     string
+	timeout time.Duration
+}
+
+var _ scheduler.Process = &reauthorizationNotifyProcess{}
+
+func (rnp *reauthorizationNotifyProcess) Description() string {
+	return fmt.Sprintf("reauthorization notification (%s)", rnp.keyer.PendingAuthorizationKey())
+}
+
+func (rnp *reauthorizationNotifyProcess) Run(ctx context.Context) error {
+	return rnp.backend.data.Managers(rnp.storage).AuthCode().WithLock(rnp.keyer, func(lacm *persistence.LockedAuthCodeManager) error {
+		entry, err := lacm.ReadPendingAuthorizationEntry(ctx)
+		if err != nil || entry == nil || entry.Name == "" || !entry.NotifyTime.IsZero() {
+			return err
+		}
+
+		now := rnp.backend.clock.Now()
+		if !entry.Due(now) {
+			return nil
+		}
+
+		if err := rnp.notify(ctx, pendingAuthorizationData(entry, now), entry.Name); err != nil {
+			// We'll try again on the next check.
+			rnp.backend.logger.Warn("failed to send reauthorization notification", "key", rnp.keyer.PendingAuthorizationKey(), "error", err)
+			return nil
+		}
+
+		entry.NotifyTime = now
+		return lacm.WritePendingAuthorizationEntry(ctx, entry)
+	})
+}
+
+func (rnp *reauthorizationNotifyProcess) notify(ctx context.Context, rd map[string]interface{}, name string) error {
+	rd["name"] = name
+
+	body, err := json.Marshal(rd)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rnp.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+
+	client := &http.Client{Timeout: rnp.timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+type reauthorizationNotifyDescriptor struct {
+	backend *backend
+	storage logical.Storage
+}
+
+var _ scheduler.Descriptor = &reauthorizationNotifyDescriptor{}
+
+func (rnd *reauthorizationNotifyDescriptor) Run(ctx context.Context, pc chan<- scheduler.Process) error {
+	c, err := rnd.backend.getCache(ctx, rnd.storage)
+	switch {
+	case err != nil:
+		return err
+	case c == nil || c.Config.ReauthorizationWebhookURL == "" || c.Config.Tuning.RefreshCheckIntervalSeconds <= 0:
+		return nil
+	}
+
+	interval := time.Duration(c.Config.Tuning.RefreshCheckIntervalSeconds) * time.Second
+
+	timeout := time.Duration(c.Config.Tuning.ProviderTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultReauthorizationNotifyTimeout
+	}
+
+	b := backoff.Build(
+		backoff.Constant(interval),
+		backoff.NonSliding,
+	)
+	err = retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		// Notifications are recorded by the active node, which is the only
+		// one that sends them.
+		if rnd.backend.readOnly() {
+			return retry.Repeat(nil)
+		}
+
+		rnd.backend.logger.Debug("running reauthorization notification")
+
+		err := rnd.backend.data.Managers(rnd.storage).AuthCode().ForEachPendingAuthorizationKey(ctx, func(keyer persistence.AuthCodeKeyer) {
+			proc := &reauthorizationNotifyProcess{
+				backend: rnd.backend,
+				storage: rnd.storage,
+				keyer:   keyer,
+				url:     c.Config.ReauthorizationWebhookURL,
+				timeout: timeout,
+			}
+
+			select {
+			case pc <- proc:
+			case <-ctx.Done():
+			}
+		})
+		if err != nil {
+			return retry.Done(err)
+		}
+
+		return retry.Repeat(nil)
+	}, retry.WithClock(rnd.backend.clock), retry.WithBackoffFactory(b))
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
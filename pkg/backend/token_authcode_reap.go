@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/logical"
@@ -16,11 +17,15 @@ import (
 )
 
 type reapProcess struct {
-	backend *backend
-	storage logical.Storage
-	keyer   persistence.AuthCodeKeyer
-	dryRun  bool
-	checker *reap.AuthCodeChecker
+	backend    *backend
+	storage    logical.Storage
+	keyer      persistence.AuthCodeKeyer
+	dryRun     bool
+	quarantine time.Duration
+	entities   bool
+	tuning     persistence.ConfigTuningEntry
+	checker    *reap.AuthCodeChecker
+	cache      *cache
 }
 
 var _ scheduler.Process = &reapProcess{}
@@ -36,7 +41,15 @@ func (rp *reapProcess) Run(ctx context.Context) error {
 			return err
 		}
 
-		err = rp.checker.Check(clockctx.WithClock(ctx, rp.backend.clock), entry)
+		checker := rp.checker
+		if !entry.Tuning.Empty() {
+			checker = reap.NewAuthCodeCheckerWithTuning(entry.Tuning.Apply(rp.tuning))
+		}
+
+		err = checker.Check(clockctx.WithClock(ctx, rp.backend.clock), entry)
+		if err == nil && rp.entities {
+			err = rp.backend.checkOwnerEntity(entry)
+		}
 		if err == nil {
 			return nil
 		}
@@ -46,12 +59,137 @@ func (rp *reapProcess) Run(ctx context.Context) error {
 			return nil
 		}
 
+		if rp.quarantine > 0 {
+			now := rp.backend.clock.Now()
+			if err := cm.WriteReapedAuthCodeEntry(ctx, &persistence.ReapedAuthCodeEntry{
+				Name:       entry.Name,
+				Entry:      entry,
+				Reason:     err.Error(),
+				ReapTime:   now,
+				ExpireTime: now.Add(rp.quarantine),
+			}); err != nil {
+				return err
+			}
+		}
+
 		if err := cm.DeleteAuthCodeEntry(ctx); err != nil {
 			return err
 		}
 
-		rp.backend.logger.Debug("credential deleted by reaping", "key", rp.keyer.AuthCodeKey(), "cause", err)
+		rp.backend.logCredEvent(ctx, rp.cache, credEventReaped, entry.Name, err.Error())
+		rp.backend.logger.Debug("credential deleted by reaping", "key", rp.keyer.AuthCodeKey(), "cause", err, "quarantined", rp.quarantine > 0)
+		return nil
+	})
+}
+
+// credOwnerEntityID returns the ID of the Vault identity entity that owns the
+// given credential, either because the credential is bound to it or because
+// it was created using the users/:entity_id/creds or self-creds endpoints.
+func credOwnerEntityID(entry *persistence.AuthCodeEntry) string {
+	if entry.BoundEntityID != "" {
+		return entry.BoundEntityID
+	}
+
+	if rest := strings.TrimPrefix(entry.Name, UsersPathPrefix); rest != entry.Name {
+		if i := strings.Index(rest, "/"); i > 0 {
+			return rest[:i]
+		}
+	}
+
+	return ""
+}
+
+// checkOwnerEntity returns an error if the Vault identity entity that owns the
+// given credential no longer exists. If Vault can't tell us whether the entity
+// exists, the credential is kept.
+func (b *backend) checkOwnerEntity(entry *persistence.AuthCodeEntry) error {
+	entityID := credOwnerEntityID(entry)
+	if entityID == "" {
+		return nil
+	}
+
+	sys := b.system()
+	if sys == nil {
 		return nil
+	}
+
+	entity, err := sys.EntityInfo(entityID)
+	if err != nil {
+		b.logger.Debug("failed to look up owner of credential", "name", entry.Name, "entity_id", entityID, "error", err)
+		return nil
+	} else if entity != nil {
+		return nil
+	}
+
+	return fmt.Errorf("owning entity %q no longer exists", entityID)
+}
+
+type reapedPurgeProcess struct {
+	backend *backend
+	storage logical.Storage
+	keyer   persistence.AuthCodeKeyer
+}
+
+var _ scheduler.Process = &reapedPurgeProcess{}
+
+func (rpp *reapedPurgeProcess) Description() string {
+	return fmt.Sprintf("reaped credential purge (%s)", rpp.keyer.ReapedAuthCodeKey())
+}
+
+func (rpp *reapedPurgeProcess) Run(ctx context.Context) error {
+	return rpp.backend.data.Managers(rpp.storage).AuthCode().WithLock(rpp.keyer, func(lacm *persistence.LockedAuthCodeManager) error {
+		entry, err := lacm.ReadReapedAuthCodeEntry(ctx)
+		if err != nil || entry == nil || !entry.Expired(rpp.backend.clock.Now()) {
+			return err
+		}
+
+		return lacm.DeleteReapedAuthCodeEntry(ctx)
+	})
+}
+
+type stateReapProcess struct {
+	backend *backend
+	storage logical.Storage
+	keyer   persistence.AuthCodeStateKeyer
+}
+
+var _ scheduler.Process = &stateReapProcess{}
+
+func (srp *stateReapProcess) Description() string {
+	return fmt.Sprintf("authorization code state reap (%s)", srp.keyer.AuthCodeStateKey())
+}
+
+func (srp *stateReapProcess) Run(ctx context.Context) error {
+	return srp.backend.data.Managers(srp.storage).AuthCodeState().WithLock(srp.keyer, func(lasm *persistence.LockedAuthCodeStateManager) error {
+		entry, err := lasm.ReadAuthCodeStateEntry(ctx)
+		if err != nil || entry == nil || !entry.Expired(srp.backend.clock.Now()) {
+			return err
+		}
+
+		return lasm.DeleteAuthCodeStateEntry(ctx)
+	})
+}
+
+type pendingStateReapProcess struct {
+	backend *backend
+	storage logical.Storage
+	keyer   persistence.AuthCodeKeyer
+}
+
+var _ scheduler.Process = &pendingStateReapProcess{}
+
+func (psrp *pendingStateReapProcess) Description() string {
+	return fmt.Sprintf("pending state reap (%s)", psrp.keyer.PendingStateKey())
+}
+
+func (psrp *pendingStateReapProcess) Run(ctx context.Context) error {
+	return psrp.backend.data.Managers(psrp.storage).AuthCode().WithLock(psrp.keyer, func(lacm *persistence.LockedAuthCodeManager) error {
+		entry, err := lacm.ReadPendingStateEntry(ctx)
+		if err != nil || entry == nil || !entry.Expired(psrp.backend.clock.Now()) {
+			return err
+		}
+
+		return lacm.DeletePendingStateEntry(ctx)
 	})
 }
 
@@ -81,19 +219,91 @@ func (rd *reapDescriptor) Run(ctx context.Context, pc chan<- scheduler.Process)
 	err = retry.Wait(ctx, func(ctx context.Context) (bool, error) {
 		rd.backend.logger.Debug("running credential reap")
 
-		err := rd.backend.data.Managers(rd.storage).AuthCode().ForEachAuthCodeKey(ctx, func(keyer persistence.AuthCodeKeyer) {
-			proc := &reapProcess{
-				backend: rd.backend,
-				storage: rd.storage,
-				keyer:   keyer,
-				dryRun:  c.Config.Tuning.ReapDryRun,
-				checker: checker,
+		acm := rd.backend.data.Managers(rd.storage).AuthCode()
+		pacer := newScanPacer(rd.backend.clock, c.Config.Tuning)
+		pageSize := c.Config.Tuning.StorageScanPageSize
+
+		// Storage is listed a page at a time, and each page is dispatched
+		// before the next is listed, so a sweep never holds every key of a
+		// large mount in memory.
+		dispatch := func(procs []scheduler.Process) error {
+			if err := pacer.Wait(ctx); err != nil {
+				return err
+			}
+
+			for _, proc := range procs {
+				select {
+				case pc <- proc:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		}
+
+		err := acm.ForEachAuthCodeKeyPage(ctx, pageSize, func(page []persistence.AuthCodeKeyer) error {
+			procs := make([]scheduler.Process, len(page))
+			for i, keyer := range page {
+				procs[i] = &reapProcess{
+					backend:    rd.backend,
+					storage:    rd.storage,
+					keyer:      keyer,
+					dryRun:     c.Config.Tuning.ReapDryRun,
+					quarantine: time.Duration(c.Config.Tuning.ReapQuarantineSeconds) * time.Second,
+					entities:   c.Config.Tuning.ReapDeletedEntities,
+					tuning:     c.Config.Tuning,
+					checker:    checker,
+					cache:      c,
+				}
 			}
+			return dispatch(procs)
+		})
+		if err != nil {
+			return retry.Done(err)
+		}
+
+		// Expired authorization code states are always removed.
+		err = rd.backend.data.Managers(rd.storage).AuthCodeState().ForEachAuthCodeStateKeyPage(ctx, pageSize, func(page []persistence.AuthCodeStateKeyer) error {
+			procs := make([]scheduler.Process, len(page))
+			for i, keyer := range page {
+				procs[i] = &stateReapProcess{
+					backend: rd.backend,
+					storage: rd.storage,
+					keyer:   keyer,
+				}
+			}
+			return dispatch(procs)
+		})
+		if err != nil {
+			return retry.Done(err)
+		}
+
+		// Quarantined credentials are removed once their retention expires.
+		err = acm.ForEachReapedAuthCodeKeyPage(ctx, pageSize, func(page []persistence.AuthCodeKeyer) error {
+			procs := make([]scheduler.Process, len(page))
+			for i, keyer := range page {
+				procs[i] = &reapedPurgeProcess{
+					backend: rd.backend,
+					storage: rd.storage,
+					keyer:   keyer,
+				}
+			}
+			return dispatch(procs)
+		})
+		if err != nil {
+			return retry.Done(err)
+		}
 
-			select {
-			case pc <- proc:
-			case <-ctx.Done():
+		err = acm.ForEachPendingStateKeyPage(ctx, pageSize, func(page []persistence.AuthCodeKeyer) error {
+			procs := make([]scheduler.Process, len(page))
+			for i, keyer := range page {
+				procs[i] = &pendingStateReapProcess{
+					backend: rd.backend,
+					storage: rd.storage,
+					keyer:   keyer,
+				}
 			}
+			return dispatch(procs)
 		})
 		if err != nil {
 			return retry.Done(err)
@@ -119,3 +119,222 @@ func TestPeriodicReap(t *testing.T) {
 		return retry.Done(nil)
 	}))
 }
+
+func TestPerCredentialReapTuning(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	clk := testclock.NewFakeClock(time.Now())
+	exchange := testutil.AmendTokenMockAuthCodeExchange(testutil.RandomMockAuthCodeExchange, func(tok *provider.Token) error {
+		tok.Expiry = clk.Now().Add(time.Minute)
+		return nil
+	})
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock: clock.NewTimerCallbackClock(
+			k8sext.NewClock(clk),
+			func(d time.Duration) {
+				clk.Step(d)
+			},
+		),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	// Write configuration. Non-refreshable tokens are never reaped by
+	// default on this mount.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":                         client.ID,
+			"client_secret":                     client.Secret,
+			"provider":                          "mock",
+			"tune_reap_non_refreshable_seconds": 0,
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Write one credential that uses the mount tuning and one that overrides
+	// it.
+	for name, data := range map[string]map[string]interface{}{
+		"keep": {"code": "test"},
+		"reap": {"code": "test", "tune_reap_non_refreshable_seconds": "5m"},
+	} {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + name,
+			Storage:   storage,
+			Data:      data,
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + "reap",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, 300, resp.Data["tune_reap_non_refreshable_seconds"])
+
+	wait := time.Minute + 5*time.Minute
+	wait += time.Duration(persistence.DefaultConfigTuningEntry.ReapCheckIntervalSeconds) * time.Second
+
+	select {
+	case <-clk.After(wait):
+	case <-ctx.Done():
+		require.Fail(t, "context expired waiting for reaper to run")
+	}
+
+	require.NoError(t, retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		req = &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + "reap",
+			Storage:   storage,
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+
+		if resp != nil {
+			return retry.Repeat(fmt.Errorf("token still exists"))
+		}
+
+		return retry.Done(nil)
+	}))
+
+	// The credential without an override is retained.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + "keep",
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.NotContains(t, resp.Data, "tune_reap_non_refreshable_seconds")
+}
+
+type entitySystemView struct {
+	logical.StaticSystemView
+	entities map[string]bool
+}
+
+func (sv *entitySystemView) EntityInfo(entityID string) (*logical.Entity, error) {
+	if !sv.entities[entityID] {
+		return nil, nil
+	}
+
+	return &logical.Entity{ID: entityID}, nil
+}
+
+func TestReapDeletedEntities(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	clk := testclock.NewFakeClock(time.Now())
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, testutil.RandomMockAuthCodeExchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock: clock.NewTimerCallbackClock(
+			k8sext.NewClock(clk),
+			func(d time.Duration) {
+				clk.Step(d)
+			},
+		),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{
+		System: &entitySystemView{
+			entities: map[string]bool{"alive": true},
+		},
+	}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	handle := func(path, entityID string, data map[string]interface{}) *logical.Response {
+		var op logical.Operation = logical.ReadOperation
+		if data != nil {
+			op = logical.UpdateOperation
+		}
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			EntityID:  entityID,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	handle(backend.ConfigPath, "", map[string]interface{}{
+		"client_id":                  client.ID,
+		"client_secret":              client.Secret,
+		"provider":                   "mock",
+		"tune_reap_deleted_entities": true,
+	})
+
+	handle(backend.UsersPathPrefix+"alive/"+backend.CredsPathPrefix+"test", "", map[string]interface{}{"code": "test"})
+	handle(backend.UsersPathPrefix+"gone/"+backend.CredsPathPrefix+"test", "", map[string]interface{}{"code": "test"})
+	handle(backend.CredsPathPrefix+"bound", "gone", map[string]interface{}{"code": "test", "bind": "entity"})
+	handle(backend.CredsPathPrefix+"unowned", "", map[string]interface{}{"code": "test"})
+
+	select {
+	case <-clk.After(time.Duration(persistence.DefaultConfigTuningEntry.ReapCheckIntervalSeconds) * time.Second):
+	case <-ctx.Done():
+		require.Fail(t, "context expired waiting for reaper to run")
+	}
+
+	// Credentials owned by the deleted entity are removed.
+	require.NoError(t, retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		for _, path := range []string{
+			backend.UsersPathPrefix + "gone/" + backend.CredsPathPrefix + "test",
+			backend.CredsPathPrefix + "bound",
+		} {
+			if resp := handle(path, "gone", nil); resp != nil {
+				return retry.Repeat(fmt.Errorf("credential %q still exists", path))
+			}
+		}
+
+		return retry.Done(nil)
+	}))
+
+	// The others are retained.
+	require.NotNil(t, handle(backend.UsersPathPrefix+"alive/"+backend.CredsPathPrefix+"test", "", nil))
+	require.NotNil(t, handle(backend.CredsPathPrefix+"unowned", "", nil))
+}
@@ -0,0 +1,172 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmap"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/scheduler"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"golang.org/x/oauth2"
+)
+
+// revocationOperations returns the provider operations used to revoke access
+// tokens, or nil if the configured provider cannot revoke them.
+func revocationOperations(c *cache) (provider.RevocationOperations, error) {
+	p, err := c.ProviderWithTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	ops, ok := p.Private(c.Config.ClientID, c.Config.ClientSecret).(provider.RevocationOperations)
+	if !ok || !ops.SupportsRevocation() {
+		return nil, nil
+	}
+
+	return ops, nil
+}
+
+// revokeTokenAsync revokes an emergency access token once it is due. A token
+// that the provider fails to revoke is retried until the provider would have
+// expired it anyway.
+func (b *backend) revokeTokenAsync(ctx context.Context, storage logical.Storage, keyer persistence.RevocationKeyer) error {
+	return b.data.Managers(storage).Revocation().WithLock(keyer, func(rm *persistence.LockedRevocationManager) error {
+		entry, err := rm.ReadRevocationEntry(ctx)
+		switch {
+		case err != nil:
+			return err
+		case entry == nil:
+			b.revocationSchedule.Remove(keyer)
+			return nil
+		case !entry.Due(b.clock.Now()):
+			b.revocationSchedule.Set(keyer, entry.RevokeTime)
+			return nil
+		}
+
+		c, err := b.getCache(ctx, storage)
+		if err != nil {
+			return err
+		} else if c == nil {
+			return ErrNotConfigured
+		}
+
+		ops, err := revocationOperations(c)
+		if err != nil {
+			return err
+		} else if ops == nil {
+			return fmt.Errorf("provider %q does not support token revocation", c.Config.ProviderName)
+		}
+
+		pctx := clockctx.WithClock(ctx, b.clock)
+
+		err = ops.RevokeToken(pctx, &provider.Token{
+			Token:           &oauth2.Token{AccessToken: entry.AccessToken},
+			ProviderOptions: entry.ProviderOptions,
+		})
+		switch {
+		case err == nil:
+			b.logger.Info("revoked emergency access token", "credential", entry.CredentialName)
+		case !entry.ExpireTime.IsZero() && !entry.ExpireTime.After(b.clock.Now()):
+			b.logger.Warn("emergency access token expired before it could be revoked", "credential", entry.CredentialName, "error", err)
+		default:
+			entry.LastError = errmap.Wrap(errmark.MarkShort(err), "revocation failed").Error()
+			b.logger.Error("failed to revoke emergency access token; retrying", "credential", entry.CredentialName, "error", err)
+			return rm.WriteRevocationEntry(ctx, entry)
+		}
+
+		if err := rm.DeleteRevocationEntry(ctx); err != nil {
+			return err
+		}
+
+		b.revocationSchedule.Remove(keyer)
+		return nil
+	})
+}
+
+type revocationProcess struct {
+	backend *backend
+	storage logical.Storage
+	keyer   persistence.RevocationKeyer
+}
+
+var _ scheduler.Process = &revocationProcess{}
+
+func (rp *revocationProcess) Description() string {
+	return fmt.Sprintf("emergency access token revocation (%s)", rp.keyer.RevocationKey())
+}
+
+func (rp *revocationProcess) Run(ctx context.Context) error {
+	ctx, release := rp.backend.leaseCache(ctx)
+	defer release()
+
+	return rp.backend.revokeTokenAsync(ctx, rp.storage, rp.keyer)
+}
+
+type revocationDescriptor struct {
+	backend *backend
+	storage logical.Storage
+}
+
+var _ scheduler.Descriptor = &revocationDescriptor{}
+
+func (rd *revocationDescriptor) Run(ctx context.Context, pc chan<- scheduler.Process) error {
+	rs := rd.backend.revocationSchedule
+
+	for {
+		now := rd.backend.clock.Now()
+		wait := revocationScheduleRebuildInterval
+
+		// Tokens are revoked by the active node, and not while requests to
+		// the provider are paused. Leaving maintenance mode changes the
+		// configuration, which resets the schedule and wakes us up.
+		if maintenance, err := rd.backend.maintenanceMode(ctx, rd.storage); err != nil {
+			return err
+		} else if !rd.backend.readOnly() && !maintenance {
+			if rs.RebuildDue(now.Add(-revocationScheduleRebuildInterval)) {
+				if err := rs.Rebuild(ctx, rd.backend, rd.storage); err != nil {
+					if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+						return nil
+					}
+					return err
+				}
+			}
+
+			for _, keyer := range rs.Due(now, revocationRetryInterval) {
+				proc := &revocationProcess{
+					backend: rd.backend,
+					storage: rd.storage,
+					keyer:   keyer,
+				}
+
+				select {
+				case pc <- proc:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+
+			// Sleep until the next token is due, but no longer than the
+			// rebuild interval so that the schedule is rebuilt on time.
+			if next, ok := rs.Next(); ok {
+				if d := next.Sub(now); d < wait {
+					wait = d
+				}
+			}
+		}
+
+		timer := rd.backend.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-rs.Wake():
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package backend
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+const (
+	// revocationScheduleRebuildInterval is how often the revocation schedule
+	// is rebuilt from storage to recover from any changes it did not observe.
+	revocationScheduleRebuildInterval = time.Hour
+
+	// revocationRetryInterval is how long to wait before trying to revoke a
+	// token again after a failed attempt.
+	revocationRetryInterval = 10 * time.Second
+)
+
+type revocationScheduleEntry struct {
+	keyer persistence.RevocationKeyer
+	time  time.Time
+	gen   uint64
+	index int
+}
+
+// revocationScheduleQueue is a min-heap of emergency access tokens ordered by
+// the time they need to be revoked.
+type revocationScheduleQueue []*revocationScheduleEntry
+
+var _ heap.Interface = &revocationScheduleQueue{}
+
+func (q revocationScheduleQueue) Len() int           { return len(q) }
+func (q revocationScheduleQueue) Less(i, j int) bool { return q[i].time.Before(q[j].time) }
+
+func (q revocationScheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *revocationScheduleQueue) Push(x interface{}) {
+	entry := x.(*revocationScheduleEntry)
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+func (q *revocationScheduleQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return entry
+}
+
+// revocationSchedule tracks the time each emergency access token needs to be
+// revoked, so that the revoker only wakes up when a token is due instead of
+// repeatedly listing storage. It is rebuilt from storage when the revoker
+// starts and is kept up to date as tokens are issued and revoked.
+type revocationSchedule struct {
+	mut     sync.Mutex
+	built   bool
+	entries map[string]*revocationScheduleEntry
+	queue   revocationScheduleQueue
+	gen     uint64
+	rebuilt time.Time
+	wake    chan struct{}
+}
+
+func newRevocationSchedule() *revocationSchedule {
+	return &revocationSchedule{
+		entries: make(map[string]*revocationScheduleEntry),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// notify wakes up the revoker if it is waiting.
+func (rs *revocationSchedule) notify() {
+	select {
+	case rs.wake <- struct{}{}:
+	default:
+	}
+}
+
+// set records the revocation time of a token. The schedule lock must be held.
+func (rs *revocationSchedule) set(keyer persistence.RevocationKeyer, t time.Time) {
+	key := keyer.RevocationKey()
+
+	entry, found := rs.entries[key]
+	if found {
+		entry.time = t
+		entry.gen = rs.gen
+		heap.Fix(&rs.queue, entry.index)
+	} else {
+		entry = &revocationScheduleEntry{
+			keyer: keyer,
+			time:  t,
+			gen:   rs.gen,
+		}
+		rs.entries[key] = entry
+		heap.Push(&rs.queue, entry)
+	}
+
+	// If this token is now the next one due, the revoker may need to wake up
+	// earlier than it planned to.
+	if rs.queue[0] == entry {
+		rs.notify()
+	}
+}
+
+// remove removes a token from the schedule. The schedule lock must be held.
+func (rs *revocationSchedule) remove(key string) {
+	entry, found := rs.entries[key]
+	if !found {
+		return
+	}
+
+	heap.Remove(&rs.queue, entry.index)
+	delete(rs.entries, key)
+}
+
+// Set records that the token with the given keyer must be revoked at the
+// given time.
+func (rs *revocationSchedule) Set(keyer persistence.RevocationKeyer, t time.Time) {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	if !rs.built {
+		// Not yet built; the next rebuild will read this token.
+		return
+	}
+
+	rs.set(keyer, t)
+}
+
+// Remove removes the token with the given keyer from the schedule, for example
+// because it has been revoked.
+func (rs *revocationSchedule) Remove(keyer persistence.RevocationKeyer) {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	rs.remove(keyer.RevocationKey())
+}
+
+// Due returns the tokens that need to be revoked at the given time, earliest
+// first. Each returned token is rescheduled to be considered again after the
+// given retry delay in case it is not removed by then.
+func (rs *revocationSchedule) Due(now time.Time, retry time.Duration) []persistence.RevocationKeyer {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	var due []persistence.RevocationKeyer
+	for len(rs.queue) > 0 && !rs.queue[0].time.After(now) {
+		entry := rs.queue[0]
+		due = append(due, entry.keyer)
+
+		entry.time = now.Add(retry)
+		heap.Fix(&rs.queue, 0)
+	}
+	return due
+}
+
+// Next returns the time the next token is due, if any.
+func (rs *revocationSchedule) Next() (time.Time, bool) {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	if len(rs.queue) == 0 {
+		return time.Time{}, false
+	}
+	return rs.queue[0].time, true
+}
+
+// Wake returns a channel that receives a value when a token is scheduled ahead
+// of all others or the schedule is reset.
+func (rs *revocationSchedule) Wake() <-chan struct{} {
+	return rs.wake
+}
+
+// RebuildDue returns true if the schedule has not been rebuilt from storage
+// since the given time.
+func (rs *revocationSchedule) RebuildDue(since time.Time) bool {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	return !rs.built || rs.rebuilt.Before(since)
+}
+
+// Rebuild reconciles the schedule with the tokens in storage. Entries for
+// tokens that no longer need to be revoked are removed once the scan
+// completes.
+func (rs *revocationSchedule) Rebuild(ctx context.Context, b *backend, storage logical.Storage) error {
+	rs.mut.Lock()
+	rs.built = true
+	rs.gen++
+	gen := rs.gen
+	rs.mut.Unlock()
+
+	rm := b.data.Managers(storage).Revocation()
+	err := rm.ForEachRevocationKeyPage(ctx, persistence.DefaultConfigTuningEntry.StorageScanPageSize, func(page []persistence.RevocationKeyer) error {
+		for _, keyer := range page {
+			err := rm.WithLock(keyer, func(lrm *persistence.LockedRevocationManager) error {
+				entry, err := lrm.ReadRevocationEntry(ctx)
+				if err != nil {
+					return err
+				}
+
+				rs.mut.Lock()
+				defer rs.mut.Unlock()
+
+				if entry == nil {
+					rs.remove(keyer.RevocationKey())
+				} else {
+					rs.set(keyer, entry.RevokeTime)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return ctx.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	for key, entry := range rs.entries {
+		if entry.gen < gen {
+			rs.remove(key)
+		}
+	}
+	rs.rebuilt = b.clock.Now()
+	return nil
+}
+
+// Reset discards the schedule so that it is rebuilt from storage the next time
+// the revoker runs.
+func (rs *revocationSchedule) Reset() {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	rs.built = false
+	rs.entries = make(map[string]*revocationScheduleEntry)
+	rs.queue = nil
+	rs.rebuilt = time.Time{}
+
+	rs.notify()
+}
@@ -0,0 +1,71 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevocationScheduleOrder(t *testing.T) {
+	now := time.Now()
+
+	rs := newRevocationSchedule()
+	rs.built = true
+
+	rs.Set(persistence.RevocationKey("late"), now.Add(time.Hour))
+	rs.Set(persistence.RevocationKey("second"), now.Add(-time.Second))
+
+	next, ok := rs.Next()
+	require.True(t, ok)
+	assert.Equal(t, now.Add(-time.Second), next)
+
+	// A token that needs to be revoked before all others wakes up the
+	// revoker.
+	select {
+	case <-rs.Wake():
+	default:
+	}
+	rs.Set(persistence.RevocationKey("first"), now.Add(-time.Minute))
+	select {
+	case <-rs.Wake():
+	default:
+		require.Fail(t, "schedule did not wake the revoker")
+	}
+
+	due := rs.Due(now, 10*time.Second)
+	assert.Equal(t, []persistence.RevocationKeyer{
+		persistence.RevocationKey("first"),
+		persistence.RevocationKey("second"),
+	}, due)
+
+	// Tokens that were not revoked are retried after the given delay.
+	assert.Empty(t, rs.Due(now, 10*time.Second))
+	assert.Len(t, rs.Due(now.Add(10*time.Second), 10*time.Second), 2)
+
+	rs.Remove(persistence.RevocationKey("first"))
+	rs.Remove(persistence.RevocationKey("second"))
+
+	next, ok = rs.Next()
+	require.True(t, ok)
+	assert.Equal(t, now.Add(time.Hour), next)
+
+	// Resetting the schedule discards it until it is rebuilt, and wakes up
+	// the revoker to do so.
+	rs.Reset()
+	select {
+	case <-rs.Wake():
+	default:
+		require.Fail(t, "schedule did not wake the revoker")
+	}
+
+	_, ok = rs.Next()
+	assert.False(t, ok)
+	assert.True(t, rs.RebuildDue(now))
+
+	rs.Set(persistence.RevocationKey("unbuilt"), now)
+	_, ok = rs.Next()
+	assert.False(t, ok)
+}
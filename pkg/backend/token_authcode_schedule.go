@@ -0,0 +1,370 @@
+package backend
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+)
+
+const (
+	// refreshScheduleRebuildInterval is how often the refresh schedule is
+	// rebuilt from storage to recover from any changes it did not observe.
+	refreshScheduleRebuildInterval = time.Hour
+)
+
+type refreshScheduleEntry struct {
+	keyer persistence.AuthCodeKeyer
+	time  time.Time
+	gen   uint64
+	index int
+
+	// dispatched and retry record when the credential was last due and the
+	// retry delay it was given, if ever.
+	dispatched time.Time
+	retry      time.Duration
+}
+
+// refreshScheduleQueue is a min-heap of credentials ordered by the time they
+// need to be refreshed.
+type refreshScheduleQueue []*refreshScheduleEntry
+
+var _ heap.Interface = &refreshScheduleQueue{}
+
+func (q refreshScheduleQueue) Len() int           { return len(q) }
+func (q refreshScheduleQueue) Less(i, j int) bool { return q[i].time.Before(q[j].time) }
+
+func (q refreshScheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *refreshScheduleQueue) Push(x interface{}) {
+	entry := x.(*refreshScheduleEntry)
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+func (q *refreshScheduleQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return entry
+}
+
+// refreshSchedule tracks the time each credential next needs to be considered
+// by the automatic refresher, so that credentials are refreshed in order of
+// expiry and the refresher only wakes up when there is work to do. It is
+// rebuilt from storage when the refresher starts and is kept up to date by
+// observing credential writes.
+type refreshSchedule struct {
+	mut       sync.Mutex
+	tuning    *persistence.ConfigTuningEntry
+	entries   map[string]*refreshScheduleEntry
+	queue     refreshScheduleQueue
+	gen       uint64
+	rebuilt   time.Time
+	lastCheck time.Time
+	wake      chan struct{}
+}
+
+// refreshScheduleStatus summarizes the state of the refresh schedule.
+type refreshScheduleStatus struct {
+	Built     bool
+	Scheduled int
+	Due       int
+	Next      time.Time
+	LastCheck time.Time
+	Rebuilt   time.Time
+}
+
+var _ persistence.AuthCodeObserver = &refreshSchedule{}
+
+func newRefreshSchedule() *refreshSchedule {
+	return &refreshSchedule{
+		entries: make(map[string]*refreshScheduleEntry),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// refreshScheduleTime returns the time the automatic refresher should refresh
+// the given credential, or false if it will never need to be refreshed. Like
+// token validity checks, it accounts for the expiry leeway.
+func refreshScheduleTime(entry *persistence.AuthCodeEntry, tuning persistence.ConfigTuningEntry) (time.Time, bool) {
+	if entry.Disabled || !entry.TokenIssued() || entry.Expiry.IsZero() || !entry.Refreshable() {
+		return time.Time{}, false
+	}
+
+	return entry.Expiry.Add(expiryLeeway(tuning) - refreshExpiryDelta(entry.Tuning.Apply(tuning))), true
+}
+
+// set records the refresh time of a credential. The schedule lock must be
+// held.
+func (rs *refreshSchedule) set(keyer persistence.AuthCodeKeyer, t time.Time) {
+	key := keyer.AuthCodeKey()
+
+	entry, found := rs.entries[key]
+	if found {
+		entry.time = t
+		entry.gen = rs.gen
+		heap.Fix(&rs.queue, entry.index)
+	} else {
+		entry = &refreshScheduleEntry{
+			keyer: keyer,
+			time:  t,
+			gen:   rs.gen,
+		}
+		rs.entries[key] = entry
+		heap.Push(&rs.queue, entry)
+	}
+
+	// If this credential is now the next one due, the refresher may need to
+	// wake up earlier than it planned to.
+	if rs.queue[0] == entry {
+		select {
+		case rs.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// remove removes a credential from the schedule. The schedule lock must be
+// held.
+func (rs *refreshSchedule) remove(key string) {
+	entry, found := rs.entries[key]
+	if !found {
+		return
+	}
+
+	heap.Remove(&rs.queue, entry.index)
+	delete(rs.entries, key)
+}
+
+// update records the refresh time of a credential given its current entry.
+// The schedule lock must be held.
+func (rs *refreshSchedule) update(keyer persistence.AuthCodeKeyer, entry *persistence.AuthCodeEntry) {
+	if rs.tuning == nil {
+		// Not yet built; the next rebuild will read this credential.
+		return
+	}
+
+	if entry == nil {
+		rs.remove(keyer.AuthCodeKey())
+		return
+	}
+
+	t, ok := refreshScheduleTime(entry, *rs.tuning)
+	if !ok {
+		rs.remove(keyer.AuthCodeKey())
+		return
+	}
+
+	// A token that is shorter than the refresh window would be due again as
+	// soon as it is issued. Instead, wait until it is halfway to expiring
+	// (but no longer than the retry delay) since the credential was last
+	// dispatched.
+	if prev, found := rs.entries[keyer.AuthCodeKey()]; found && !prev.dispatched.IsZero() {
+		wait := entry.Expiry.Sub(prev.dispatched) / 2
+		if wait > prev.retry {
+			wait = prev.retry
+		}
+		if wait < time.Second {
+			wait = time.Second
+		}
+
+		if floor := prev.dispatched.Add(wait); t.Before(floor) {
+			t = floor
+		}
+	}
+
+	rs.set(keyer, t)
+}
+
+// AuthCodeWritten implements persistence.AuthCodeObserver.
+func (rs *refreshSchedule) AuthCodeWritten(keyer persistence.AuthCodeKeyer, entry *persistence.AuthCodeEntry) {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	rs.update(keyer, entry)
+}
+
+// AuthCodeDeleted implements persistence.AuthCodeObserver.
+func (rs *refreshSchedule) AuthCodeDeleted(keyer persistence.AuthCodeKeyer) {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	rs.remove(keyer.AuthCodeKey())
+}
+
+// Invalidate causes the credential with the given keyer to be considered at
+// the next refresh check, for example because another node changed it.
+func (rs *refreshSchedule) Invalidate(keyer persistence.AuthCodeKeyer) {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	if rs.tuning == nil {
+		return
+	}
+
+	rs.set(keyer, time.Time{})
+}
+
+// Due returns the credentials that need to be considered for refresh at the
+// given time, earliest first. Each returned credential is rescheduled to be
+// considered again after the given retry delay in case it is not updated by
+// then.
+func (rs *refreshSchedule) Due(now time.Time, retry time.Duration) []persistence.AuthCodeKeyer {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	if retry < time.Second {
+		retry = time.Second
+	}
+
+	rs.lastCheck = now
+
+	var due []persistence.AuthCodeKeyer
+	for len(rs.queue) > 0 && !rs.queue[0].time.After(now) {
+		entry := rs.queue[0]
+		due = append(due, entry.keyer)
+
+		entry.time = now.Add(retry)
+		entry.dispatched = now
+		entry.retry = retry
+		heap.Fix(&rs.queue, 0)
+	}
+	return due
+}
+
+// Next returns the time the next credential is due, if any.
+func (rs *refreshSchedule) Next() (time.Time, bool) {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	if len(rs.queue) == 0 {
+		return time.Time{}, false
+	}
+	return rs.queue[0].time, true
+}
+
+// Lookup returns the time the given credential is next due, if it is
+// scheduled.
+func (rs *refreshSchedule) Lookup(keyer persistence.AuthCodeKeyer) (time.Time, bool) {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	entry, found := rs.entries[keyer.AuthCodeKey()]
+	if !found {
+		return time.Time{}, false
+	}
+	return entry.time, true
+}
+
+// Status returns a summary of the schedule at the given time.
+func (rs *refreshSchedule) Status(now time.Time) refreshScheduleStatus {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	status := refreshScheduleStatus{
+		Built:     rs.tuning != nil,
+		Scheduled: len(rs.queue),
+		LastCheck: rs.lastCheck,
+		Rebuilt:   rs.rebuilt,
+	}
+	if len(rs.queue) > 0 {
+		status.Next = rs.queue[0].time
+	}
+	for _, entry := range rs.queue {
+		if !entry.time.After(now) {
+			status.Due++
+		}
+	}
+	return status
+}
+
+// Wake returns a channel that receives a value when a credential is scheduled
+// ahead of all others.
+func (rs *refreshSchedule) Wake() <-chan struct{} {
+	return rs.wake
+}
+
+// RebuildDue returns true if the schedule has not been rebuilt from storage
+// since the given time.
+func (rs *refreshSchedule) RebuildDue(since time.Time) bool {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	return rs.tuning == nil || rs.rebuilt.Before(since)
+}
+
+// Rebuild reconciles the schedule with the credentials in storage. Credentials
+// are read a page at a time at the configured storage scan rate, each under its
+// lock so that concurrent writes are never overwritten by stale data. Entries
+// for credentials that no longer exist are removed once the scan completes.
+func (rs *refreshSchedule) Rebuild(ctx context.Context, b *backend, storage logical.Storage, tuning persistence.ConfigTuningEntry) error {
+	rs.mut.Lock()
+	rs.tuning = &tuning
+	rs.gen++
+	gen := rs.gen
+	rs.mut.Unlock()
+
+	acm := b.data.Managers(storage).AuthCode()
+	pacer := newScanPacer(b.clock, tuning)
+	err := acm.ForEachAuthCodeKeyPage(ctx, tuning.StorageScanPageSize, func(page []persistence.AuthCodeKeyer) error {
+		if err := pacer.Wait(ctx); err != nil {
+			return err
+		}
+
+		for _, keyer := range page {
+			err := acm.WithLock(keyer, func(lacm *persistence.LockedAuthCodeManager) error {
+				entry, err := lacm.ReadAuthCodeEntry(ctx)
+				if err != nil {
+					return err
+				}
+
+				rs.AuthCodeWritten(keyer, entry)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return ctx.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	for key, entry := range rs.entries {
+		if entry.gen < gen {
+			rs.remove(key)
+		}
+	}
+	rs.rebuilt = b.clock.Now()
+
+	b.logger.Debug("rebuilt credential refresh schedule", "scheduled", len(rs.entries))
+	return nil
+}
+
+// Reset discards the schedule, for example because the tuning it was computed
+// with is no longer current.
+func (rs *refreshSchedule) Reset() {
+	rs.mut.Lock()
+	defer rs.mut.Unlock()
+
+	rs.tuning = nil
+	rs.entries = make(map[string]*refreshScheduleEntry)
+	rs.queue = nil
+	rs.rebuilt = time.Time{}
+	rs.lastCheck = time.Time{}
+}
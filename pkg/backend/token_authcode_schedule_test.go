@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestRefreshScheduleOrder(t *testing.T) {
+	now := time.Now()
+
+	rs := newRefreshSchedule()
+	rs.tuning = &persistence.DefaultConfigTuningEntry
+
+	entry := func(expiry time.Duration) *persistence.AuthCodeEntry {
+		return &persistence.AuthCodeEntry{
+			Token: &provider.Token{
+				Token: &oauth2.Token{
+					AccessToken:  "access",
+					RefreshToken: "refresh",
+					Expiry:       now.Add(expiry),
+				},
+			},
+		}
+	}
+
+	// The default expiry delta is 72 seconds.
+	rs.AuthCodeWritten(persistence.AuthCodeKey("late"), entry(time.Hour))
+	rs.AuthCodeWritten(persistence.AuthCodeKey("second"), entry(time.Minute))
+	rs.AuthCodeWritten(persistence.AuthCodeKey("never"), &persistence.AuthCodeEntry{})
+
+	next, ok := rs.Next()
+	require.True(t, ok)
+	assert.Equal(t, now.Add(-12*time.Second), next)
+
+	// A credential that needs to be refreshed before all others wakes up the
+	// refresher.
+	select {
+	case <-rs.Wake():
+	default:
+	}
+	rs.AuthCodeWritten(persistence.AuthCodeKey("first"), entry(30*time.Second))
+	select {
+	case <-rs.Wake():
+	default:
+		require.Fail(t, "schedule did not wake the refresher")
+	}
+
+	due := rs.Due(now, time.Minute)
+	assert.Equal(t, []persistence.AuthCodeKeyer{
+		persistence.AuthCodeKey("first"),
+		persistence.AuthCodeKey("second"),
+	}, due)
+
+	// Credentials that were not updated are retried after the given delay.
+	assert.Empty(t, rs.Due(now, time.Minute))
+	assert.Len(t, rs.Due(now.Add(time.Minute), time.Minute), 2)
+
+	rs.AuthCodeDeleted(persistence.AuthCodeKey("first"))
+	rs.AuthCodeDeleted(persistence.AuthCodeKey("second"))
+
+	next, ok = rs.Next()
+	require.True(t, ok)
+	assert.Equal(t, now.Add(time.Hour-72*time.Second), next)
+}
+
+func TestRefreshExpiryDelta(t *testing.T) {
+	tuning := persistence.DefaultConfigTuningEntry
+	assert.Equal(t, 72*time.Second, refreshExpiryDelta(tuning))
+
+	// An absolute minimum takes precedence when it is larger.
+	tuning.RefreshBeforeExpirySeconds = 600
+	assert.Equal(t, 10*time.Minute, refreshExpiryDelta(tuning))
+
+	tuning.RefreshCheckIntervalSeconds = 3600
+	assert.Equal(t, 72*time.Minute, refreshExpiryDelta(tuning))
+}
+
+func TestRefreshScheduleTimeExpiryLeeway(t *testing.T) {
+	now := time.Now()
+
+	entry := &persistence.AuthCodeEntry{
+		Token: &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken:  "access",
+				RefreshToken: "refresh",
+				Expiry:       now.Add(time.Hour),
+			},
+		},
+	}
+
+	tuning := persistence.DefaultConfigTuningEntry
+	at, ok := refreshScheduleTime(entry, tuning)
+	require.True(t, ok)
+	assert.Equal(t, now.Add(time.Hour-72*time.Second), at)
+
+	// Tokens are refreshed later by the leeway, when they would otherwise
+	// be considered expired.
+	tuning.ExpiryLeewaySeconds = 30
+	at, ok = refreshScheduleTime(entry, tuning)
+	require.True(t, ok)
+	assert.Equal(t, now.Add(time.Hour-42*time.Second), at)
+}
+
+func BenchmarkRefreshSchedule(b *testing.B) {
+	now := time.Now()
+
+	for _, n := range []int{1000, 100000} {
+		b.Run(fmt.Sprintf("entries=%d", n), func(b *testing.B) {
+			rs := newRefreshSchedule()
+			rs.tuning = &persistence.DefaultConfigTuningEntry
+
+			entry := func(i int) *persistence.AuthCodeEntry {
+				return &persistence.AuthCodeEntry{
+					Token: &provider.Token{
+						Token: &oauth2.Token{
+							AccessToken:  "access",
+							RefreshToken: "refresh",
+							Expiry:       now.Add(time.Hour + time.Duration(i)*time.Second),
+						},
+					},
+				}
+			}
+
+			keyers := make([]persistence.AuthCodeKeyer, n)
+			for i := range keyers {
+				keyers[i] = persistence.AuthCodeKey(fmt.Sprintf("bench-%d", i))
+				rs.AuthCodeWritten(keyers[i], entry(i))
+			}
+
+			// Each iteration reschedules a credential, as writing it would, and
+			// checks for credentials that are due, as the refresher does.
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rs.AuthCodeWritten(keyers[i%n], entry(n+i))
+				rs.Due(now, time.Minute)
+			}
+		})
+	}
+}
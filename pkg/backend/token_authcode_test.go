@@ -2,6 +2,7 @@ package backend_test
 
 import (
 	"context"
+	"net/http"
 	"testing"
 	"time"
 
@@ -9,8 +10,10 @@ import (
 	"github.com/puppetlabs/leg/timeutil/pkg/clock"
 	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/interop"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	testclock "k8s.io/apimachinery/pkg/util/clock"
 )
@@ -376,7 +379,7 @@ func TestMinimumSeconds(t *testing.T) {
 			Name:          "verify that second is marked expired if new token is less than request",
 			Token:         "second",
 			Data:          map[string]interface{}{"minimum_seconds": "200"},
-			ExpectedError: "token expired",
+			ExpectedError: "[ERR_TOKEN_EXPIRED] token expired",
 		},
 	}
 	for _, test := range tests {
@@ -409,3 +412,308 @@ func TestMinimumSeconds(t *testing.T) {
 		})
 	}
 }
+
+func TestRotatingRefreshToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exchange := testutil.RotatingMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(i int) (time.Duration, error) {
+			if i > 2 {
+				// The provider has detected that a refresh token was reused.
+				return 0, testutil.MockErrorResponse(http.StatusBadRequest, &interop.JSONError{Error: "invalid_grant"})
+			}
+
+			return time.Duration(i) * time.Minute, nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	// Write configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ConfigPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Write our credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"code": "123456",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	read := func(minimumSeconds int) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"minimum_seconds": minimumSeconds,
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	// Force a refresh, which rotates the refresh token.
+	resp = read(90)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "token_2", resp.Data["access_token"])
+	assert.Equal(t, false, resp.Data["expired"])
+	assert.Equal(t, 0, resp.Data["refresh_attempts"])
+	assert.NotEmpty(t, resp.Data["last_refresh_time"])
+	assert.NotEmpty(t, resp.Data["next_scheduled_refresh"])
+	assert.NotContains(t, resp.Data, "last_refresh_error")
+	assert.NotContains(t, resp.Data, "provider_response_code")
+
+	// The next refresh is rejected, so the credential must be reauthorized.
+	resp = read(60)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "token_2", resp.Data["access_token"])
+
+	resp = read(150)
+	require.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "credential must be reauthorized")
+	assert.Contains(t, resp.Error().Error(), "reused")
+
+	code, ok := backend.ParseErrorCode(resp.Error().Error())
+	require.True(t, ok)
+	assert.Equal(t, backend.ErrorCodeRefreshRevoked, code)
+
+	resp = read(60)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Len(t, resp.Warnings, 1)
+	assert.Contains(t, resp.Warnings[0], "must be reauthorized")
+	assert.Contains(t, resp.Data["last_refresh_error"], "invalid_grant")
+	assert.Equal(t, http.StatusBadRequest, resp.Data["provider_response_code"])
+	assert.NotContains(t, resp.Data, "next_scheduled_refresh")
+}
+
+func TestRefreshScheduleReconciliation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	refreshed := make(chan string, 1)
+
+	exchange := testutil.AmendTokenMockAuthCodeExchange(
+		testutil.RefreshableMockAuthCodeExchange(
+			testutil.IncrementMockAuthCodeExchange("token_"),
+			func(i int) (time.Duration, error) { return 10 * time.Minute, nil },
+		),
+		func(tok *provider.Token) error {
+			if tok.AccessToken == "token_1" {
+				return nil
+			}
+
+			select {
+			case refreshed <- tok.AccessToken:
+			default:
+			}
+			return nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	// Write the configuration and credential using a backend that is shut
+	// down before the token needs to be refreshed, like a node that fails
+	// over.
+	prev := backend.New(backend.Options{
+		ProviderRegistry: pr,
+	})
+	require.NoError(t, prev.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, prev.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"client_id":     client.ID,
+				"client_secret": client.Secret,
+				"provider":      "mock",
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + "test",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"code": "test",
+			},
+		},
+	} {
+		resp, err := prev.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	prev.Clean(ctx)
+
+	// The new backend has never seen the credential, so it must find it in
+	// storage to refresh it.
+	clk := testclock.NewFakeClock(time.Now())
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock: clock.NewTimerCallbackClock(
+			k8sext.NewClock(clk),
+			func(d time.Duration) {
+				clk.Step(d)
+			},
+		),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	select {
+	case tok := <-refreshed:
+		assert.Equal(t, "token_2", tok)
+	case <-ctx.Done():
+		require.Fail(t, "context expired waiting for token refresh")
+	}
+}
+
+func TestRefreshShortLivedToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	refreshed := make(chan string, 1)
+
+	// Every token expires well within the refresh window of the check
+	// interval configured below.
+	exchange := testutil.AmendTokenMockAuthCodeExchange(
+		testutil.IncrementMockAuthCodeExchange("token_"),
+		func(tok *provider.Token) error {
+			tok.RefreshToken = "refresh"
+			tok.Expiry = clk.Now().Add(time.Minute)
+
+			if tok.AccessToken == "token_1" {
+				return nil
+			}
+
+			select {
+			case refreshed <- tok.AccessToken:
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Clean(ctx)
+
+	for _, req := range []*logical.Request{
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ConfigPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"client_id":                           client.ID,
+				"client_secret":                       client.Secret,
+				"provider":                            "mock",
+				"tune_refresh_check_interval_seconds": "1h",
+			},
+		},
+		{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + "test",
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"code": "test",
+			},
+		},
+	} {
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	select {
+	case tok := <-refreshed:
+		assert.Equal(t, "token_2", tok)
+	case <-ctx.Done():
+		require.Fail(t, "context expired waiting for token refresh")
+	}
+
+	// The new token is also within the refresh window, but it is not
+	// refreshed again right away.
+	select {
+	case tok := <-refreshed:
+		require.Fail(t, "token refreshed again immediately", tok)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// It is refreshed again halfway to its expiry, long before the check
+	// interval elapses.
+	clk.Step(30 * time.Second)
+
+	select {
+	case tok := <-refreshed:
+		assert.Equal(t, "token_3", tok)
+	case <-ctx.Done():
+		require.Fail(t, "context expired waiting for token refresh")
+	}
+}
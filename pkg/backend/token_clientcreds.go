@@ -11,8 +11,13 @@ import (
 )
 
 func (b *backend) updateClientCredsToken(ctx context.Context, storage logical.Storage, keyer persistence.ClientCredsKeyer, expiryDelta time.Duration) (*persistence.ClientCredsEntry, error) {
+	leeway, err := b.expiryLeeway(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
 	var entry *persistence.ClientCredsEntry
-	err := b.data.Managers(storage).ClientCreds().WithLock(keyer, func(cm *persistence.LockedClientCredsManager) error {
+	err = b.data.Managers(storage).ClientCreds().WithLock(keyer, func(cm *persistence.LockedClientCredsManager) error {
 		// In case someone else updated this token from under us, we'll re-request
 		// it here with the lock acquired.
 		candidate, err := cm.ReadClientCredsEntry(ctx)
@@ -21,20 +26,32 @@ func (b *backend) updateClientCredsToken(ctx context.Context, storage logical.St
 			return err
 		case candidate == nil:
 			candidate = &persistence.ClientCredsEntry{}
-		case b.tokenValid(candidate.Token, expiryDelta):
+		case b.tokenValid(candidate.Token, expiryDelta, leeway):
 			entry = candidate
 			return nil
 		}
 
+		if b.readOnly() {
+			return logical.ErrReadOnly
+		}
+
 		c, err := b.getCache(ctx, storage)
 		if err != nil {
 			return err
 		} else if c == nil {
 			return ErrNotConfigured
+		} else if c.Config.MaintenanceMode {
+			return ErrMaintenanceMode
+		} else if disallowed := c.Config.DisallowedScopes(candidate.Config.Scopes); len(disallowed) > 0 {
+			return scopeNotAllowedError(disallowed)
+		}
+
+		p, err := c.ProviderWithTimeout()
+		if err != nil {
+			return err
 		}
 
-		updated, err := c.
-			ProviderWithTimeout(expiryDelta).
+		updated, err := p.
 			Private(c.Config.ClientID, c.Config.ClientSecret).
 			ClientCredentials(
 				clockctx.WithClock(ctx, b.clock),
@@ -60,11 +77,16 @@ func (b *backend) updateClientCredsToken(ctx context.Context, storage logical.St
 }
 
 func (b *backend) getUpdateClientCredsToken(ctx context.Context, storage logical.Storage, keyer persistence.ClientCredsKeyer, expiryDelta time.Duration) (*persistence.ClientCredsEntry, error) {
+	leeway, err := b.expiryLeeway(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
 	entry, err := b.data.Managers(storage).ClientCreds().ReadClientCredsEntry(ctx, keyer)
 	switch {
 	case err != nil:
 		return nil, err
-	case entry != nil && b.tokenValid(entry.Token, expiryDelta):
+	case entry != nil && b.tokenValid(entry.Token, expiryDelta, leeway):
 		return entry, nil
 	default:
 		return b.updateClientCredsToken(ctx, storage, keyer, expiryDelta)
@@ -32,6 +32,9 @@ func (dcep *deviceCodeExchangeProcess) Description() string {
 }
 
 func (dcep *deviceCodeExchangeProcess) Run(ctx context.Context) error {
+	ctx, release := dcep.backend.leaseCache(ctx)
+	defer release()
+
 	return dcep.backend.getExchangeDeviceAuth(ctx, dcep.storage, dcep.keyer)
 }
 
@@ -48,6 +51,13 @@ func (dced *deviceCodeExchangeDescriptor) Run(ctx context.Context, pc chan<- sch
 		backoff.NonSliding,
 	)
 	err := retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		// Pending exchanges are resumed once maintenance is over.
+		if maintenance, err := dced.backend.maintenanceMode(ctx, dced.storage); err != nil {
+			return retry.Done(err)
+		} else if maintenance {
+			return retry.Repeat(nil)
+		}
+
 		err := dced.backend.data.Managers(dced.storage).AuthCode().ForEachDeviceAuthKey(ctx, func(keyer persistence.AuthCodeKeyer) {
 			proc := &deviceCodeExchangeProcess{
 				backend: dced.backend,
@@ -94,7 +104,7 @@ func (b *backend) exchangeDeviceAuth(ctx context.Context, storage logical.Storag
 
 		// Check the issue time one last time. Someone could have updated this from
 		// under us as well.
-		if !auth.ShouldPoll() {
+		if !auth.ShouldPoll(b.clock.Now()) {
 			return nil
 		}
 
@@ -106,10 +116,16 @@ func (b *backend) exchangeDeviceAuth(ctx context.Context, storage logical.Storag
 			return ErrNotConfigured
 		}
 
+		p, err := c.ProviderWithTimeout()
+		if err != nil {
+			return err
+		}
+
 		// Perform the exchange.
+		attempts := ct.TransientErrorsSinceLastIssue
 		auth, ct, err = deviceAuthExchange(
 			clockctx.WithClock(ctx, b.clock),
-			c.ProviderWithTimeout(defaultExpiryDelta).Public(c.Config.ClientID),
+			p.Public(c.Config.ClientID),
 			auth,
 			ct,
 		)
@@ -117,6 +133,17 @@ func (b *backend) exchangeDeviceAuth(ctx context.Context, storage logical.Storag
 			return err
 		}
 
+		// Polls that are only told to wait are not worth recording.
+		switch {
+		case ct.TokenIssued():
+			ct.SetClaimMetadata(c.Config.ClaimMetadata)
+			b.recordCredHistory(c, ct, credHistoryEventExchanged, "")
+		case ct.UserError != "":
+			b.recordCredHistory(c, ct, credHistoryEventExchangeFailed, ct.UserError)
+		case ct.TransientErrorsSinceLastIssue > attempts:
+			b.recordCredHistory(c, ct, credHistoryEventExchangeFailed, ct.LastTransientError)
+		}
+
 		// We need to run the auth exchange again, so go ahead and update it
 		// now.
 		if !ct.TokenIssued() && ct.UserError == "" {
@@ -150,7 +177,7 @@ func (b *backend) getExchangeDeviceAuth(ctx context.Context, storage logical.Sto
 		return err
 	case entry == nil:
 		return nil
-	case !entry.ShouldPoll():
+	case !entry.ShouldPoll(b.clock.Now()):
 		return nil
 	default:
 		return b.exchangeDeviceAuth(ctx, storage, keyer)
@@ -175,14 +202,16 @@ func deviceAuthExchange(ctx context.Context, ops provider.PublicOperations, dae
 			dae.Interval += 5 // seconds
 		case semerr.IsCode(err, "authorization_pending"):
 		case errmark.MarkedUser(err):
-			ace.SetUserError(msg)
+			ace.SetUserError(msg, clockctx.Clock(ctx).Now())
+			ace.LastProviderResponseCode, _ = semerr.StatusCode(err)
 		default:
-			ace.SetTransientError(msg)
+			ace.SetTransientError(msg, clockctx.Clock(ctx).Now())
+			ace.LastProviderResponseCode, _ = semerr.StatusCode(err)
 		}
 
 		dae.LastAttemptedIssueTime = ace.LastAttemptedIssueTime
 	} else {
-		ace.SetToken(tok)
+		ace.SetToken(tok, clockctx.Clock(ctx).Now())
 	}
 
 	return dae, ace, nil
@@ -0,0 +1,140 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// jwtScope is a scope claim, which providers encode either as a
+// space-separated string (RFC 9068) or as an array of strings.
+type jwtScope []string
+
+func (s *jwtScope) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err == nil {
+		*s = strings.Fields(str)
+		return nil
+	}
+
+	var strs []string
+	if err := json.Unmarshal(b, &strs); err != nil {
+		return err
+	}
+	*s = strs
+	return nil
+}
+
+// jwtAccessTokenClaims are the claims of an access token that is a JWT.
+type jwtAccessTokenClaims struct {
+	Issuer   string           `json:"iss,omitempty"`
+	Audience jwt.Audience     `json:"aud,omitempty"`
+	Expiry   *jwt.NumericDate `json:"exp,omitempty"`
+	Scope    jwtScope         `json:"scope,omitempty"`
+
+	// SCP is the scope claim used by some providers instead of scope.
+	SCP jwtScope `json:"scp,omitempty"`
+}
+
+func (atc *jwtAccessTokenClaims) scopes() []string {
+	if len(atc.Scope) > 0 {
+		return atc.Scope
+	}
+	return atc.SCP
+}
+
+func (atc *jwtAccessTokenClaims) hasScope(scope string) bool {
+	for _, s := range atc.scopes() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns true if the claims have all of the given non-empty values.
+func (atc *jwtAccessTokenClaims) matches(issuer, audience, scope string) bool {
+	switch {
+	case issuer != "" && atc.Issuer != issuer:
+		return false
+	case audience != "" && !atc.Audience.Contains(audience):
+		return false
+	case scope != "" && !atc.hasScope(scope):
+		return false
+	default:
+		return true
+	}
+}
+
+func (atc *jwtAccessTokenClaims) responseData() map[string]interface{} {
+	rd := make(map[string]interface{})
+
+	if atc.Issuer != "" {
+		rd["iss"] = atc.Issuer
+	}
+
+	if len(atc.Audience) > 0 {
+		rd["aud"] = []string(atc.Audience)
+	}
+
+	if atc.Expiry != nil {
+		rd["exp"] = atc.Expiry.Time()
+	}
+
+	if scopes := atc.scopes(); len(scopes) > 0 {
+		rd["scope"] = strings.Join(scopes, " ")
+	}
+
+	return rd
+}
+
+// JWTAccessTokenClaims returns the claims of the given access token if
+// decoding JWT access tokens is enabled and the token is a JWT. If a key set
+// is configured, the signature of the token must also be valid.
+func (c *cache) JWTAccessTokenClaims(ctx context.Context, token string) (*jwtAccessTokenClaims, bool) {
+	if !c.Config.DecodeJWTAccessTokens {
+		return nil, false
+	}
+
+	var payload []byte
+	if c.JWTKeySet != nil {
+		p, err := c.JWTKeySet.VerifySignature(ctx, token)
+		if err != nil {
+			c.logger.Debug("failed to verify JWT access token", "error", err)
+			return nil, false
+		}
+		payload = p
+	} else {
+		// Opaque tokens are not JWTs, so failing to parse the token is not
+		// an error.
+		jws, err := jose.ParseSigned(token)
+		if err != nil {
+			return nil, false
+		}
+		payload = jws.UnsafePayloadWithoutVerification()
+	}
+
+	claims := &jwtAccessTokenClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		c.logger.Debug("failed to decode JWT access token claims", "error", err)
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// jwtAccessTokenExpiry is a provider.ExpiryFunc that uses the exp claim of a
+// JWT access token.
+func (c *cache) jwtAccessTokenExpiry(ctx context.Context, tok *provider.Token) (time.Time, bool) {
+	claims, ok := c.JWTAccessTokenClaims(ctx, tok.AccessToken)
+	if !ok || claims.Expiry == nil {
+		return time.Time{}, false
+	}
+
+	return claims.Expiry.Time(), true
+}
@@ -0,0 +1,187 @@
+package backend_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestJWTAccessTokens(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serveKey := func(key *ecdsa.PrivateKey) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", "application/json")
+			_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+				Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "key-1", Algorithm: string(jose.ES256), Use: "sig"}},
+			})
+		}))
+	}
+
+	jwks := serveKey(key)
+	defer jwks.Close()
+
+	otherJWKS := serveKey(otherKey)
+	defer otherJWKS.Close()
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jose.JSONWebKey{Key: key, KeyID: "key-1"}},
+		(&jose.SignerOptions{}).WithType("at+jwt"),
+	)
+	require.NoError(t, err)
+
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	accessToken, err := jwt.Signed(signer).
+		Claims(map[string]interface{}{"scope": "read write"}).
+		Claims(&jwt.Claims{
+			Issuer:   "https://login.example.com",
+			Audience: jwt.Audience{"https://api.example.com"},
+			Expiry:   jwt.NewNumericDate(expiry),
+		}).
+		CompactSerialize()
+	require.NoError(t, err)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.RestrictMockAuthCodeExchange(map[string]testutil.MockAuthCodeExchangeFunc{
+			"jwt": testutil.StaticMockAuthCodeExchange(&provider.Token{
+				Token: &oauth2.Token{AccessToken: accessToken},
+			}),
+			"opaque": testutil.RandomMockAuthCodeExchange,
+		})),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+	defer b.Clean(ctx)
+
+	handle := func(op logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: op,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	config := map[string]interface{}{
+		"client_id":     client.ID,
+		"client_secret": client.Secret,
+		"provider":      "mock",
+	}
+
+	resp := handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Credentials can't be filtered by their claims until decoding is
+	// enabled.
+	resp = handle(logical.ListOperation, backend.CredsPathPrefix, map[string]interface{}{
+		"access_token_scope": "read",
+	})
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	code, ok := backend.ParseErrorCode(resp.Error().Error())
+	require.True(t, ok)
+	assert.Equal(t, backend.ErrorCodeUnsupported, code)
+
+	config["decode_jwt_access_tokens"] = true
+	resp = handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	for name, code := range map[string]string{"jwt": "jwt", "opaque": "opaque"} {
+		resp = handle(logical.UpdateOperation, backend.CredsPathPrefix+name, map[string]interface{}{
+			"code": code,
+		})
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	// The expiry of the token is taken from its claims.
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"jwt", nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.True(t, expiry.Equal(resp.Data["expire_time"].(time.Time)))
+	assert.Equal(t, map[string]interface{}{
+		"iss":   "https://login.example.com",
+		"aud":   []string{"https://api.example.com"},
+		"exp":   expiry,
+		"scope": "read write",
+	}, resp.Data["access_token_claims"])
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"opaque", nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.NotContains(t, resp.Data, "expire_time")
+	assert.NotContains(t, resp.Data, "access_token_claims")
+
+	list := func(filter map[string]interface{}) []string {
+		resp := handle(logical.ListOperation, backend.CredsPathPrefix, filter)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+		keys, _ := resp.Data["keys"].([]string)
+		return keys
+	}
+
+	assert.Equal(t, []string{"jwt", "opaque"}, list(nil))
+	assert.Equal(t, []string{"jwt"}, list(map[string]interface{}{"access_token_scope": "read"}))
+	assert.Equal(t, []string{"jwt"}, list(map[string]interface{}{"access_token_audience": "https://api.example.com"}))
+	assert.Empty(t, list(map[string]interface{}{"access_token_issuer": "https://other.example.com"}))
+	assert.Empty(t, list(map[string]interface{}{"access_token_scope": "admin"}))
+
+	// Claims are only used if the signature is valid.
+	config["jwt_access_token_jwks_url"] = jwks.URL
+	resp = handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"jwt", nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Contains(t, resp.Data, "access_token_claims")
+
+	config["jwt_access_token_jwks_url"] = otherJWKS.URL
+	resp = handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp = handle(logical.ReadOperation, backend.CredsPathPrefix+"jwt", nil)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.NotContains(t, resp.Data, "access_token_claims")
+	assert.Empty(t, list(map[string]interface{}{"access_token_scope": "read"}))
+
+	// A key set can only be used when decoding is enabled.
+	config["decode_jwt_access_tokens"] = false
+	resp = handle(logical.UpdateOperation, backend.ConfigPath, config)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+}
@@ -0,0 +1,187 @@
+package backend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// JWTBearerGrantType is the grant type for exchanging a JWT assertion for
+	// an access token, as described in RFC 7523.
+	JWTBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+	// jwtBearerAssertionLifetime is the time each assertion we mint is valid
+	// for. Assertions are used immediately, so this only needs to account for
+	// clock skew.
+	jwtBearerAssertionLifetime = 5 * time.Minute
+)
+
+var jwtBearerSigningAlgorithms = map[jose.SignatureAlgorithm]func(key interface{}) bool{
+	jose.RS256: isRSAKey,
+	jose.RS384: isRSAKey,
+	jose.RS512: isRSAKey,
+	jose.PS256: isRSAKey,
+	jose.PS384: isRSAKey,
+	jose.PS512: isRSAKey,
+	jose.ES256: isECKey(elliptic.P256()),
+	jose.ES384: isECKey(elliptic.P384()),
+	jose.ES512: isECKey(elliptic.P521()),
+}
+
+func isRSAKey(key interface{}) bool {
+	_, ok := key.(*rsa.PrivateKey)
+	return ok
+}
+
+func isECKey(curve elliptic.Curve) func(key interface{}) bool {
+	return func(key interface{}) bool {
+		k, ok := key.(*ecdsa.PrivateKey)
+		return ok && k.Curve == curve
+	}
+}
+
+// parseJWTBearerSigningKey decodes a PEM-encoded private key and determines
+// the algorithm to sign assertions with. If no algorithm is given, a default
+// is selected based on the type of the key.
+func parseJWTBearerSigningKey(data, algorithm string) (interface{}, jose.SignatureAlgorithm, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, "", errors.New("signing key is not PEM-encoded")
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid signing key: %w", err)
+		}
+
+		key = k
+	case "EC PRIVATE KEY":
+		k, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid signing key: %w", err)
+		}
+
+		key = k
+	case "PRIVATE KEY":
+		k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid signing key: %w", err)
+		}
+
+		key = k
+	default:
+		return nil, "", fmt.Errorf("unsupported signing key type %q", block.Type)
+	}
+
+	alg := jose.SignatureAlgorithm(algorithm)
+	if alg == "" {
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			alg = jose.RS256
+		case *ecdsa.PrivateKey:
+			switch k.Curve {
+			case elliptic.P256():
+				alg = jose.ES256
+			case elliptic.P384():
+				alg = jose.ES384
+			case elliptic.P521():
+				alg = jose.ES512
+			}
+		}
+	}
+
+	compatible, found := jwtBearerSigningAlgorithms[alg]
+	if !found {
+		return nil, "", fmt.Errorf("unsupported signing algorithm %q", alg)
+	} else if !compatible(key) {
+		return nil, "", fmt.Errorf("signing algorithm %q cannot be used with the given key", alg)
+	}
+
+	return key, alg, nil
+}
+
+// mintJWTBearerAssertion creates a new signed assertion from the given
+// configuration.
+func (b *backend) mintJWTBearerAssertion(cfg *persistence.JWTBearerEntry) (string, error) {
+	key, alg, err := parseJWTBearerSigningKey(cfg.SigningKey, cfg.SigningAlgorithm)
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{
+			Algorithm: alg,
+			Key:       jose.JSONWebKey{Key: key, KeyID: cfg.SigningKeyID},
+		},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := b.clock.Now()
+
+	// The claims in the template can't override the registered claims that
+	// we manage.
+	return jwt.Signed(signer).
+		Claims(cfg.Claims).
+		Claims(&jwt.Claims{
+			Issuer:    cfg.Issuer,
+			Subject:   cfg.Subject,
+			Audience:  jwt.Audience(cfg.Audience),
+			Expiry:    jwt.NewNumericDate(now.Add(jwtBearerAssertionLifetime)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        id,
+		}).
+		CompactSerialize()
+}
+
+// jwtBearerExchange mints an assertion and exchanges it for an access token.
+func (b *backend) jwtBearerExchange(ctx context.Context, c *cache, cfg *persistence.JWTBearerEntry) (*provider.Token, error) {
+	assertion, err := b.mintJWTBearerAssertion(cfg)
+	if err != nil {
+		return nil, errmark.MarkUser(fmt.Errorf("could not create assertion: %w", err))
+	}
+
+	p, err := c.ProviderWithTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	// The provider client credentials flow allows us to override the grant
+	// type, and otherwise makes the same request we need.
+	return p.
+		Private(c.Config.ClientID, c.Config.ClientSecret).
+		ClientCredentials(
+			clockctx.WithClock(ctx, b.clock),
+			provider.WithURLParams{
+				"grant_type": JWTBearerGrantType,
+				"assertion":  assertion,
+			},
+			provider.WithScopes(cfg.Scopes),
+			provider.WithProviderOptions(cfg.ProviderOptions),
+		)
+}
@@ -0,0 +1,80 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+type userInfoCacheEntry struct {
+	digest       [sha256.Size]byte
+	claims       map[string]interface{}
+	retrieveTime time.Time
+	expireTime   time.Time
+}
+
+// userInfoCache holds the claims most recently retrieved for each credential.
+// Entries are keyed by credential name, but are only reused for the access
+// token they were retrieved with, so refreshing a credential invalidates them.
+type userInfoCache struct {
+	ttl time.Duration
+
+	mut     sync.Mutex
+	entries map[string]*userInfoCacheEntry
+}
+
+// Get returns the cached claims for the given credential and access token, if
+// any, along with the time they were retrieved.
+func (uic *userInfoCache) Get(name, accessToken string, now time.Time) (map[string]interface{}, time.Time, bool) {
+	if uic == nil {
+		return nil, time.Time{}, false
+	}
+
+	uic.mut.Lock()
+	defer uic.mut.Unlock()
+
+	entry, found := uic.entries[name]
+	if !found || entry.digest != sha256.Sum256([]byte(accessToken)) || !now.Before(entry.expireTime) {
+		return nil, time.Time{}, false
+	}
+
+	return entry.claims, entry.retrieveTime, true
+}
+
+// Put caches the claims retrieved for the given credential and access token.
+func (uic *userInfoCache) Put(name, accessToken string, claims map[string]interface{}, now time.Time) {
+	if uic == nil {
+		return
+	}
+
+	uic.mut.Lock()
+	defer uic.mut.Unlock()
+
+	// Entries for credentials that are no longer read would otherwise be kept
+	// until the configuration changes.
+	for key, entry := range uic.entries {
+		if !now.Before(entry.expireTime) {
+			delete(uic.entries, key)
+		}
+	}
+
+	uic.entries[name] = &userInfoCacheEntry{
+		digest:       sha256.Sum256([]byte(accessToken)),
+		claims:       claims,
+		retrieveTime: now,
+		expireTime:   now.Add(uic.ttl),
+	}
+}
+
+// newUserInfoCache creates a cache that retains claims for the given duration,
+// or returns nil if caching is disabled.
+func newUserInfoCache(ttl time.Duration) *userInfoCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &userInfoCache{
+		ttl:     ttl,
+		entries: make(map[string]*userInfoCacheEntry),
+	}
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// DefaultAuthorizeAddr is the address the redirect listener of Authorize binds
+// to by default. The port is chosen by the operating system, so the provider
+// must accept any port for loopback redirect URLs (RFC 8252, section 7.3).
+const DefaultAuthorizeAddr = "127.0.0.1:0"
+
+// AuthorizeOptions changes how Authorize runs the authorization code flow.
+type AuthorizeOptions struct {
+	// Addr is the address to listen for the redirect from the provider on. If
+	// not set, DefaultAuthorizeAddr is used.
+	Addr string
+
+	// Scopes are requested from the provider.
+	Scopes []string
+
+	// ProviderOptions are passed to the provider when constructing the
+	// authorization code URL and exchanging the code.
+	ProviderOptions map[string]string
+
+	// StateTTLSeconds is how long the user has to complete the authorization.
+	// If not set, the default of the plugin is used.
+	StateTTLSeconds int
+
+	// OpenURL is called with the authorization code URL the user must visit,
+	// for example to open it in a browser. It is required.
+	OpenURL func(url string) error
+}
+
+type authorizeResult struct {
+	code string
+	err  error
+}
+
+// Authorize runs the authorization code flow for the credential with the
+// given name: it listens for the redirect from the provider on a local
+// address, asks the user to visit the authorization code URL, and writes the
+// code it receives to the credential.
+func (c *Client) Authorize(ctx context.Context, name string, opts AuthorizeOptions) error {
+	if opts.OpenURL == nil {
+		return errors.New("OpenURL is required")
+	}
+
+	addr := opts.Addr
+	if addr == "" {
+		addr = DefaultAuthorizeAddr
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	redirectURL := "http://" + ln.Addr().String() + "/callback"
+
+	// The plugin generates a state tied to the credential, so it remembers the
+	// redirect URL and provider options for when we write the code back.
+	u, err := c.AuthCodeURL(ctx, &AuthCodeURLRequest{
+		Name:            name,
+		RedirectURL:     redirectURL,
+		Scopes:          opts.Scopes,
+		ProviderOptions: opts.ProviderOptions,
+		StateTTLSeconds: opts.StateTTLSeconds,
+	})
+	if err != nil {
+		return err
+	} else if u.State == "" {
+		return errEmptyResponse
+	}
+
+	results := make(chan authorizeResult, 1)
+	srv := &http.Server{Handler: authorizeHandler(u.State, results)}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	if err := opts.OpenURL(u.URL); err != nil {
+		return err
+	}
+
+	var result authorizeResult
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if result.err != nil {
+		return result.err
+	}
+
+	_, err = c.WriteCreds(ctx, name, &WriteCredsRequest{
+		Code:  result.code,
+		State: u.State,
+	})
+	return err
+}
+
+// authorizeHandler handles the redirect from the provider and sends the code
+// or error it contains to the given channel. Requests with a different state
+// are rejected, as they did not come from the authorization we started.
+func authorizeHandler(state string, results chan<- authorizeResult) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("state") != state {
+			http.Error(w, "Unexpected state. Start the authorization again.", http.StatusBadRequest)
+			return
+		}
+
+		var result authorizeResult
+		switch {
+		case q.Get("error") != "":
+			result.err = fmt.Errorf("provider returned error %q: %s", q.Get("error"), q.Get("error_description"))
+			http.Error(w, "Authorization failed. You can close this window.", http.StatusBadRequest)
+		case q.Get("code") == "":
+			result.err = errors.New("provider did not return an authorization code")
+			http.Error(w, "Authorization failed. You can close this window.", http.StatusBadRequest)
+		default:
+			result.code = q.Get("code")
+			_, _ = fmt.Fprintln(w, "Authorization complete. You can close this window.")
+		}
+
+		select {
+		case results <- result:
+		default:
+		}
+	})
+	return mux
+}
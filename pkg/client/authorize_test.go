@@ -0,0 +1,113 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/client"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorize(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mc := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(mc, testutil.IncrementMockAuthCodeExchange("token_")),
+	))
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	srv := httptest.NewServer(&vaultHandler{t: t, backend: b, storage: &logical.InmemStorage{}})
+	defer srv.Close()
+
+	cfg := api.DefaultConfig()
+	cfg.Address = srv.URL
+
+	ac, err := api.NewClient(cfg)
+	require.NoError(t, err)
+
+	c := client.New(ac, "oauth2")
+
+	config, err := c.DefaultConfig(ctx, "")
+	require.NoError(t, err)
+	config.ClientID = mc.ID
+	config.ClientSecret = mc.Secret
+	config.Provider = "mock"
+
+	_, err = c.WriteConfig(ctx, config)
+	require.NoError(t, err)
+
+	// redirect simulates the provider sending the user back to the redirect
+	// URL in the given authorization code URL.
+	redirect := func(t *testing.T, authCodeURL string, params url.Values) int {
+		u, err := url.Parse(authCodeURL)
+		require.NoError(t, err)
+
+		q := url.Values{}
+		for k, v := range params {
+			q[k] = v
+		}
+		if q.Get("state") == "" {
+			q.Set("state", u.Query().Get("state"))
+		}
+
+		resp, err := http.Get(u.Query().Get("redirect_uri") + "?" + q.Encode())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	t.Run("success", func(t *testing.T) {
+		err := c.Authorize(ctx, "test", client.AuthorizeOptions{
+			Scopes: []string{"read"},
+			OpenURL: func(authCodeURL string) error {
+				assert.Contains(t, authCodeURL, "scope=read")
+
+				// A redirect with another state is ignored.
+				assert.Equal(t, http.StatusBadRequest, redirect(t, authCodeURL, url.Values{"state": {"foo"}, "code": {"bad"}}))
+				assert.Equal(t, http.StatusOK, redirect(t, authCodeURL, url.Values{"code": {"test"}}))
+				return nil
+			},
+		})
+		require.NoError(t, err)
+
+		cred, err := c.ReadCreds(ctx, "test", nil)
+		require.NoError(t, err)
+		require.NotNil(t, cred)
+		assert.Equal(t, "token_1", cred.AccessToken)
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		err := c.Authorize(ctx, "denied", client.AuthorizeOptions{
+			OpenURL: func(authCodeURL string) error {
+				assert.Equal(t, http.StatusBadRequest, redirect(t, authCodeURL, url.Values{"error": {"access_denied"}}))
+				return nil
+			},
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "access_denied")
+
+		cred, err := c.ReadCreds(ctx, "denied", nil)
+		require.NoError(t, err)
+		assert.Nil(t, cred)
+	})
+}
@@ -0,0 +1,152 @@
+// Package client provides typed access to the API of a mounted instance of
+// this plugin using the Vault API client.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+)
+
+var errEmptyResponse = errors.New("unexpected empty response")
+
+// Error is an error response from the plugin.
+type Error struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Code is the machine-readable code of the error, if the plugin returned
+	// one. See the README for the possible codes.
+	Code backend.ErrorCode
+
+	// Message is the error message returned by Vault.
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ErrorCode returns the machine-readable code of the given error if it is an
+// error response from the plugin.
+func ErrorCode(err error) (backend.ErrorCode, bool) {
+	var e *Error
+	if !errors.As(err, &e) || e.Code == "" {
+		return "", false
+	}
+
+	return e.Code, true
+}
+
+// Client makes requests to a mount of this plugin.
+type Client struct {
+	api   *api.Client
+	mount string
+}
+
+// New creates a client for the plugin mounted at the given path, e.g.,
+// "oauth2".
+func New(c *api.Client, mount string) *Client {
+	return &Client{
+		api:   c,
+		mount: strings.Trim(mount, "/"),
+	}
+}
+
+// do makes a request to the given path relative to the mount. It returns nil
+// if the plugin did not return any data.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body map[string]interface{}) (*api.Secret, error) {
+	r := c.api.NewRequest(method, "/v1/"+c.mount+"/"+path)
+	if query != nil {
+		r.Params = query
+	}
+	if body != nil {
+		if err := r.SetJSONBody(body); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.api.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+
+	var re *api.ResponseError
+	switch {
+	case errors.As(err, &re) && re.StatusCode == http.StatusNotFound && len(re.Errors) == 0:
+		// Vault responds to a read of data that doesn't exist with a 404 (Not
+		// Found) response without any errors.
+		return nil, nil
+	case re != nil:
+		e := &Error{
+			StatusCode: re.StatusCode,
+			Message:    strings.Join(re.Errors, "; "),
+		}
+		if len(re.Errors) > 0 {
+			e.Code, _ = backend.ParseErrorCode(re.Errors[0])
+		}
+		return nil, e
+	case err != nil:
+		return nil, err
+	}
+
+	return api.ParseSecret(resp.Body)
+}
+
+// decode copies the data of the given response into the given value, which
+// must have JSON tags matching the names of the fields the plugin returns.
+func decode(secret *api.Secret, v interface{}) error {
+	b, err := json.Marshal(secret.Data)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("unexpected response: %w", err)
+	}
+
+	return nil
+}
+
+// encode converts the given value to request data using its JSON tags.
+func encode(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// queryValues converts the given parameters to a query string, omitting
+// parameters with empty values.
+func queryValues(params map[string]string) url.Values {
+	q := make(url.Values, len(params))
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	return q
+}
+
+// pathEscape escapes each segment of a credential name, which may contain
+// slashes.
+func pathEscape(name string) string {
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
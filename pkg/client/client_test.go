@@ -0,0 +1,147 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/client"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// vaultHandler serves a backend mounted at oauth2/ over HTTP like Vault does.
+type vaultHandler struct {
+	t       *testing.T
+	backend logical.Backend
+	storage logical.Storage
+}
+
+func (vh *vaultHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := &logical.Request{
+		Path:    strings.TrimPrefix(r.URL.Path, "/v1/oauth2/"),
+		Storage: vh.storage,
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		req.Operation = logical.ReadOperation
+		req.Data = make(map[string]interface{})
+		for k, v := range r.URL.Query() {
+			req.Data[k] = v[0]
+		}
+	case http.MethodPut, http.MethodPost:
+		req.Operation = logical.UpdateOperation
+		require.NoError(vh.t, json.NewDecoder(r.Body).Decode(&req.Data))
+	case http.MethodDelete:
+		req.Operation = logical.DeleteOperation
+	}
+
+	resp, err := vh.backend.HandleRequest(r.Context(), req)
+	require.NoError(vh.t, err)
+
+	w.Header().Set("content-type", "application/json")
+	switch {
+	case resp != nil && resp.IsError():
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{resp.Error().Error()}})
+	case resp == nil && req.Operation == logical.ReadOperation:
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{}})
+	case resp == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": resp.Data, "warnings": resp.Warnings})
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mc := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(mc, testutil.IncrementMockAuthCodeExchange("token_")),
+	))
+
+	b := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{}))
+
+	srv := httptest.NewServer(&vaultHandler{t: t, backend: b, storage: &logical.InmemStorage{}})
+	defer srv.Close()
+
+	cfg := api.DefaultConfig()
+	cfg.Address = srv.URL
+
+	ac, err := api.NewClient(cfg)
+	require.NoError(t, err)
+
+	c := client.New(ac, "oauth2")
+
+	// The mount is not configured yet.
+	config, err := c.ReadConfig(ctx)
+	require.NoError(t, err)
+	require.Nil(t, config)
+
+	_, err = c.AuthCodeURL(ctx, &client.AuthCodeURLRequest{State: "foo"})
+	code, ok := client.ErrorCode(err)
+	require.True(t, ok, "unexpected error: %+v", err)
+	require.Equal(t, backend.ErrorCodeNotConfigured, code)
+
+	// Start from the defaults so that writing the configuration doesn't
+	// change any other settings.
+	config, err = c.DefaultConfig(ctx, "")
+	require.NoError(t, err)
+	require.NotNil(t, config)
+	config.ClientID = mc.ID
+	config.ClientSecret = mc.Secret
+	config.Provider = "mock"
+
+	warnings, err := c.WriteConfig(ctx, config)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	read, err := c.ReadConfig(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, read)
+	assert.Equal(t, mc.ID, read.ClientID)
+	assert.Empty(t, read.ClientSecret)
+	assert.Equal(t, "mock", read.Provider)
+	assert.Equal(t, config.Tuning, read.Tuning)
+
+	u, err := c.AuthCodeURL(ctx, &client.AuthCodeURLRequest{State: "foo", Scopes: []string{"read"}})
+	require.NoError(t, err)
+	assert.Contains(t, u.URL, "state=foo")
+
+	// Credentials with slashes in their names can be written and read.
+	pending, err := c.WriteCreds(ctx, "github/work", &client.WriteCredsRequest{Code: "test"})
+	require.NoError(t, err)
+	assert.Nil(t, pending)
+
+	cred, err := c.ReadCreds(ctx, "github/work", &client.ReadCredsOptions{MinimumSeconds: 60})
+	require.NoError(t, err)
+	require.NotNil(t, cred)
+	assert.Equal(t, "token_1", cred.AccessToken)
+	assert.Equal(t, "Bearer", cred.Type)
+	assert.Equal(t, "ready", cred.Status)
+
+	require.NoError(t, c.DeleteCreds(ctx, "github/work"))
+
+	cred, err = c.ReadCreds(ctx, "github/work", nil)
+	require.NoError(t, err)
+	assert.Nil(t, cred)
+}
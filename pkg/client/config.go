@@ -0,0 +1,198 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+)
+
+// Tuning contains the tuning options of a configuration. See the README for
+// the meaning of each option.
+type Tuning struct {
+	ProviderTimeoutSeconds            int     `json:"tune_provider_timeout_seconds"`
+	ProviderTimeoutExpiryLeewayFactor float64 `json:"tune_provider_timeout_expiry_leeway_factor"`
+	RefreshCheckIntervalSeconds       int     `json:"tune_refresh_check_interval_seconds"`
+	RefreshExpiryDeltaFactor          float64 `json:"tune_refresh_expiry_delta_factor"`
+	RefreshBeforeExpirySeconds        int     `json:"tune_refresh_before_expiry_seconds"`
+	ExpiryLeewaySeconds               int     `json:"tune_expiry_leeway_seconds"`
+	ReapCheckIntervalSeconds          int     `json:"tune_reap_check_interval_seconds"`
+	ReapDryRun                        bool    `json:"tune_reap_dry_run"`
+	ReapNonRefreshableSeconds         int     `json:"tune_reap_non_refreshable_seconds"`
+	ReapRevokedSeconds                int     `json:"tune_reap_revoked_seconds"`
+	ReapTransientErrorAttempts        int     `json:"tune_reap_transient_error_attempts"`
+	ReapTransientErrorSeconds         int     `json:"tune_reap_transient_error_seconds"`
+	ReapQuarantineSeconds             int     `json:"tune_reap_quarantine_seconds"`
+	ReapDeletedEntities               bool    `json:"tune_reap_deleted_entities"`
+	IntrospectionCheckIntervalSeconds int     `json:"tune_introspection_check_interval_seconds"`
+	IntrospectionSampleSize           int     `json:"tune_introspection_sample_size"`
+	MaxCredentialVersions             int     `json:"tune_max_credential_versions"`
+	MaxCredentialHistory              int     `json:"tune_max_credential_history"`
+	MaxCredentials                    int     `json:"tune_max_credentials"`
+	MaxCredentialsPerEntity           int     `json:"tune_max_credentials_per_entity"`
+	StorageScanPageSize               int     `json:"tune_storage_scan_page_size"`
+	StorageScanPagesPerSecond         float64 `json:"tune_storage_scan_pages_per_second"`
+	CircuitBreakerFailures            int     `json:"tune_circuit_breaker_failures"`
+	CircuitBreakerCoolDownSeconds     int     `json:"tune_circuit_breaker_cool_down_seconds"`
+	UserInfoCacheSeconds              int     `json:"tune_user_info_cache_seconds"`
+}
+
+// Config is the configuration of a mount.
+type Config struct {
+	ClientID string `json:"client_id"`
+
+	// ClientSecret is never returned when the configuration is read.
+	ClientSecret string `json:"client_secret,omitempty"`
+
+	AuthURLParams   map[string]string `json:"auth_url_params"`
+	Provider        string            `json:"provider"`
+	ProviderOptions map[string]string `json:"provider_options"`
+	ClaimMetadata   map[string]string `json:"claim_metadata"`
+
+	// ProviderVersion is set when the configuration is read and ignored when
+	// it is written.
+	ProviderVersion int `json:"provider_version,omitempty"`
+
+	LeaseTokens                 bool     `json:"lease_tokens"`
+	TokenTTLSeconds             int      `json:"token_ttl_seconds"`
+	DefaultTokenLifetimeSeconds int      `json:"default_token_lifetime_seconds"`
+	AllowPasswordGrant          bool     `json:"allow_password_grant"`
+	AllowedGrantTypes           []string `json:"allowed_grant_types"`
+	AllowedRedirectURLs         []string `json:"allowed_redirect_urls"`
+	AllowedScopes               []string `json:"allowed_scopes"`
+	DeniedScopes                []string `json:"denied_scopes"`
+	AllowedReadProviderOptions  []string `json:"allowed_read_provider_options"`
+	ReauthorizationWebhookURL   string   `json:"reauthorization_webhook_url"`
+	MaintenanceMode             bool     `json:"maintenance_mode"`
+	RedactTokens                bool     `json:"redact_tokens"`
+	TracingOTLPEndpoint         string   `json:"tracing_otlp_endpoint"`
+	EventLogFile                string   `json:"event_log_file"`
+	EventLogSyslog              bool     `json:"event_log_syslog"`
+
+	// RequestObjectSigningKey is never returned when the configuration is
+	// read.
+	RequestObjectSigningKey       string `json:"request_object_signing_key,omitempty"`
+	RequestObjectSigningKeyID     string `json:"request_object_signing_key_id"`
+	RequestObjectSigningAlgorithm string `json:"request_object_signing_algorithm"`
+	RequestObjectAudience         string `json:"request_object_audience"`
+	JARMJWKSURL                   string `json:"jarm_jwks_url"`
+	JARMIssuer                    string `json:"jarm_issuer"`
+	DecodeJWTAccessTokens         bool   `json:"decode_jwt_access_tokens"`
+	JWTAccessTokenJWKSURL         string `json:"jwt_access_token_jwks_url"`
+
+	Tuning
+
+	// PreviousClientSecretExpireTime is set when the configuration is read if
+	// the previous client secret is still accepted after a rotation.
+	PreviousClientSecretExpireTime time.Time `json:"previous_client_secret_expire_time,omitempty"`
+}
+
+// DefaultConfig returns a configuration with the default settings for the
+// given provider. It reads the defaults from the plugin so that they match
+// the version of the plugin that is mounted.
+func (c *Client) DefaultConfig(ctx context.Context, provider string) (*Config, error) {
+	secret, err := c.do(ctx, http.MethodGet, backend.ConfigDefaultsPath, queryValues(map[string]string{"provider": provider}), nil)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := decode(secret, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ReadConfig returns the configuration of the mount, or nil if it has not been
+// configured.
+func (c *Client) ReadConfig(ctx context.Context) (*Config, error) {
+	secret, err := c.do(ctx, http.MethodGet, backend.ConfigPath, nil, nil)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := decode(secret, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// WriteConfig replaces the configuration of the mount. Every setting is
+// written, so start from DefaultConfig or ReadConfig to keep the settings you
+// don't want to change. It returns any warnings about the configuration.
+func (c *Client) WriteConfig(ctx context.Context, cfg *Config) ([]string, error) {
+	body, err := encode(cfg)
+	if err != nil {
+		return nil, err
+	}
+	delete(body, "provider_version")
+	delete(body, "previous_client_secret_expire_time")
+
+	secret, err := c.do(ctx, http.MethodPut, backend.ConfigPath, nil, body)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+	return secret.Warnings, nil
+}
+
+// DeleteConfig removes the configuration of the mount.
+func (c *Client) DeleteConfig(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodDelete, backend.ConfigPath, nil, nil)
+	return err
+}
+
+// AuthCodeURLRequest contains the parameters of an authorization code URL.
+type AuthCodeURLRequest struct {
+	// State is sent to the provider and returned to the redirect URL. It is
+	// generated if not set and Name is.
+	State string `json:"state,omitempty"`
+
+	// Name is the name of a credential to create when the provider redirects
+	// to the callback endpoint of the plugin. The credential must not already
+	// exist.
+	Name string `json:"-"`
+
+	RedirectURL     string            `json:"redirect_url,omitempty"`
+	Scopes          []string          `json:"scopes,omitempty"`
+	Resources       []string          `json:"resources,omitempty"`
+	AuthURLParams   map[string]string `json:"auth_url_params,omitempty"`
+	ProviderOptions map[string]string `json:"provider_options,omitempty"`
+	StateTTLSeconds int               `json:"state_ttl_seconds,omitempty"`
+}
+
+// AuthCodeURL is an authorization code URL to send a user to.
+type AuthCodeURL struct {
+	URL        string    `json:"url"`
+	State      string    `json:"state"`
+	Nonce      string    `json:"nonce"`
+	ExpireTime time.Time `json:"expire_time"`
+}
+
+// AuthCodeURL returns an authorization code URL for the current
+// configuration.
+func (c *Client) AuthCodeURL(ctx context.Context, req *AuthCodeURLRequest) (*AuthCodeURL, error) {
+	body, err := encode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	path := backend.ConfigAuthCodeURLPath
+	if req.Name != "" {
+		path = backend.AuthCodeURLCredsPathPrefix + pathEscape(req.Name)
+	}
+
+	secret, err := c.do(ctx, http.MethodPut, path, nil, body)
+	if err != nil {
+		return nil, err
+	} else if secret == nil {
+		return nil, errEmptyResponse
+	}
+
+	u := &AuthCodeURL{}
+	if err := decode(secret, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/backend"
+)
+
+// Credential is a token read from the plugin.
+type Credential struct {
+	AccessToken            string                 `json:"access_token"`
+	AccessTokenFingerprint string                 `json:"access_token_fingerprint"`
+	Type                   string                 `json:"type"`
+	ExpireTime             time.Time              `json:"expire_time"`
+	ExtraData              map[string]interface{} `json:"extra_data"`
+
+	// The following fields are only returned for credentials managed by the
+	// creds endpoints.
+	Version                int               `json:"version"`
+	Status                 string            `json:"status"`
+	ProviderOptions        map[string]string `json:"provider_options"`
+	Metadata               map[string]string `json:"metadata"`
+	Resources              []string          `json:"resources"`
+	BoundEntityID          string            `json:"bound_entity_id"`
+	BoundTokenAccessor     string            `json:"bound_token_accessor"`
+	Expired                bool              `json:"expired"`
+	RefreshAttempts        int               `json:"refresh_attempts"`
+	LastRefreshTime        time.Time         `json:"last_refresh_time"`
+	LastRefreshError       string            `json:"last_refresh_error"`
+	ProviderResponseCode   int               `json:"provider_response_code"`
+	RefreshTokenExpireTime time.Time         `json:"refresh_token_expire_time"`
+	ReauthorizeTime        time.Time         `json:"reauthorize_time"`
+	NextScheduledRefresh   time.Time         `json:"next_scheduled_refresh"`
+}
+
+// ReadCredsOptions changes how a credential is read.
+type ReadCredsOptions struct {
+	// MinimumSeconds is the minimum remaining lifetime of the access token.
+	// A token that expires sooner is refreshed first.
+	MinimumSeconds int
+
+	// IncludeToken returns tokens even if the mount redacts them.
+	IncludeToken bool
+
+	// Version is a previous version of the credential to read.
+	Version int
+
+	// ProviderOptions issues a token with these options in addition to those
+	// of the credential. The token is not stored.
+	ProviderOptions map[string]string
+}
+
+func (o *ReadCredsOptions) query() url.Values {
+	params := make(map[string]string)
+	if o == nil {
+		return queryValues(params)
+	}
+
+	if o.MinimumSeconds > 0 {
+		params["minimum_seconds"] = strconv.Itoa(o.MinimumSeconds)
+	}
+	if o.IncludeToken {
+		params["include_token"] = "true"
+	}
+	if o.Version > 0 {
+		params["version"] = strconv.Itoa(o.Version)
+	}
+
+	q := queryValues(params)
+	for k, v := range o.ProviderOptions {
+		q.Add("provider_options", k+"="+v)
+	}
+	return q
+}
+
+// WriteCredsRequest creates or replaces a credential. See the README for the
+// fields used by each grant type.
+type WriteCredsRequest struct {
+	// GrantType defaults to authorization_code.
+	GrantType string `json:"grant_type,omitempty"`
+
+	Code         string `json:"code,omitempty"`
+	RedirectURL  string `json:"redirect_url,omitempty"`
+	State        string `json:"state,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	DeviceCode   string `json:"device_code,omitempty"`
+
+	// AccessToken, TokenType, and ExpireTime are stored as given by the static
+	// grant type.
+	AccessToken string     `json:"access_token,omitempty"`
+	TokenType   string     `json:"token_type,omitempty"`
+	ExpireTime  *time.Time `json:"expire_time,omitempty"`
+
+	Scopes          []string          `json:"scopes,omitempty"`
+	Resources       []string          `json:"resources,omitempty"`
+	ProviderOptions map[string]string `json:"provider_options,omitempty"`
+
+	// Async returns before the authorization code is exchanged. Read the
+	// credential to find out whether the exchange succeeded.
+	Async bool `json:"async,omitempty"`
+
+	// DeferRefresh stores a refresh token without exchanging it until the
+	// credential is first read.
+	DeferRefresh bool `json:"defer_refresh,omitempty"`
+
+	// Bind restricts access to the credential to the entity or token making
+	// the request. It is one of "entity", "accessor", or "none".
+	Bind string `json:"bind,omitempty"`
+}
+
+// PendingCredential is returned when a credential is not ready yet, for
+// example because the user must complete a device code authorization.
+type PendingCredential struct {
+	Status                  string    `json:"status"`
+	SubmitTime              time.Time `json:"submit_time"`
+	UserCode                string    `json:"user_code"`
+	VerificationURI         string    `json:"verification_uri"`
+	VerificationURIComplete string    `json:"verification_uri_complete"`
+	ExpireTime              time.Time `json:"expire_time"`
+	FailTime                time.Time `json:"fail_time"`
+	Error                   string    `json:"error"`
+}
+
+// ReadCreds returns a current access token for the credential with the given
+// name, or nil if the credential does not exist.
+func (c *Client) ReadCreds(ctx context.Context, name string, opts *ReadCredsOptions) (*Credential, error) {
+	return c.readCredential(ctx, backend.CredsPathPrefix+pathEscape(name), opts)
+}
+
+// WriteCreds creates or replaces the credential with the given name. If the
+// credential is not ready yet, it returns what the user must do to complete
+// it; otherwise, it returns nil.
+func (c *Client) WriteCreds(ctx context.Context, name string, req *WriteCredsRequest) (*PendingCredential, error) {
+	body, err := encode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := c.do(ctx, http.MethodPut, backend.CredsPathPrefix+pathEscape(name), nil, body)
+	if err != nil || secret == nil || len(secret.Data) == 0 {
+		return nil, err
+	}
+
+	pending := &PendingCredential{}
+	if err := decode(secret, pending); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// DeleteCreds removes the credential with the given name.
+func (c *Client) DeleteCreds(ctx context.Context, name string) error {
+	_, err := c.do(ctx, http.MethodDelete, backend.CredsPathPrefix+pathEscape(name), nil, nil)
+	return err
+}
+
+// ReadSelf returns a current access token issued to the plugin itself using
+// the client credentials flow with the client credentials configuration of
+// the given name, or nil if it does not exist.
+func (c *Client) ReadSelf(ctx context.Context, name string, opts *ReadCredsOptions) (*Credential, error) {
+	return c.readCredential(ctx, backend.SelfPathPrefix+pathEscape(name), opts)
+}
+
+func (c *Client) readCredential(ctx context.Context, path string, opts *ReadCredsOptions) (*Credential, error) {
+	secret, err := c.do(ctx, http.MethodGet, path, opts.query(), nil)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+
+	cred := &Credential{}
+	if err := decode(secret, cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
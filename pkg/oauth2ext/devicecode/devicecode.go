@@ -12,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/interop"
 	"golang.org/x/oauth2"
 )
@@ -139,7 +140,7 @@ func (c *Config) DeviceCodeExchange(ctx context.Context, deviceCode string) (*oa
 			RefreshToken: base.RefreshToken,
 		}
 		if base.ExpiresIn != 0 {
-			tok.Expiry = time.Now().Add(time.Duration(base.ExpiresIn) * time.Second)
+			tok.Expiry = clockctx.Clock(ctx).Now().Add(time.Duration(base.ExpiresIn) * time.Second)
 		}
 
 		// The Go library does not check for errors here. If there is one, it
@@ -0,0 +1,93 @@
+// Package introspection implements OAuth 2.0 token introspection (RFC 7662).
+package introspection
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// Response is the response of the introspection endpoint. Only the active
+// field is required; the rest are included at the discretion of the provider.
+type Response struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Expiry    int64  `json:"exp,omitempty"`
+}
+
+type Config struct {
+	*oauth2.Config
+
+	IntrospectionURL string
+}
+
+// Introspect asks the provider whether the given token is currently active.
+func (c *Config) Introspect(ctx context.Context, token, tokenTypeHint string) (*Response, error) {
+	v := url.Values{
+		"token": {token},
+	}
+	if tokenTypeHint != "" {
+		v.Set("token_type_hint", tokenTypeHint)
+	}
+
+	// Clients authenticate the same way they do at the token endpoint.
+	inHeader := c.Endpoint.AuthStyle != oauth2.AuthStyleInParams
+	if !inHeader {
+		v.Set("client_id", c.ClientID)
+		if c.ClientSecret != "" {
+			v.Set("client_secret", c.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.IntrospectionURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if inHeader {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	resp, err := oauth2.NewClient(ctx, nil).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// This is the same restriction as used by Go's OAuth2 package for
+	// consistency.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("cannot introspect token: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &oauth2.RetrieveError{
+			Response: resp,
+			Body:     body,
+		}
+	}
+
+	ir := &Response{}
+	if err := json.Unmarshal(body, ir); err != nil {
+		return nil, fmt.Errorf("cannot parse introspection response: %w", err)
+	}
+
+	return ir, nil
+}
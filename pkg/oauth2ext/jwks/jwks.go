@@ -0,0 +1,331 @@
+// Package jwks provides a cache for JSON Web Key Sets used to verify the
+// signatures of tokens issued by a provider.
+package jwks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/puppetlabs/leg/timeutil/pkg/clock"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	DefaultTTL                = time.Hour
+	DefaultMinRefreshInterval = time.Minute
+)
+
+var (
+	ErrNoMatchingKey = errors.New("jwks: no key matches the token signature")
+)
+
+type Options struct {
+	// TTL is the time to cache the key set for if the server does not specify
+	// a lifetime using the Cache-Control header.
+	TTL time.Duration
+
+	// MinRefreshInterval is the minimum time between requests for the key set
+	// when a token is signed by an unknown key.
+	MinRefreshInterval time.Duration
+
+	// Clock is the clock to use to determine when to refresh the key set.
+	Clock clock.Clock
+}
+
+// KeySet is a remote JSON Web Key Set that is cached and refreshed in the
+// background. It implements the KeySet interface of the go-oidc package.
+type KeySet struct {
+	ctx  context.Context
+	url  string
+	opts Options
+
+	// fetchMut ensures only one request for the key set is outstanding.
+	fetchMut sync.Mutex
+
+	// mut protects the cached data.
+	mut          sync.RWMutex
+	keys         []jose.JSONWebKey
+	etag         string
+	lastModified string
+	fetchTime    time.Time
+	expiry       time.Time
+
+	populated     chan struct{}
+	populatedOnce sync.Once
+}
+
+// NewKeySet creates a key set that fetches keys from the given URL. The HTTP
+// client is taken from the context as with the oauth2 package. The key set is
+// fetched when it is first needed and then refreshed in the background until
+// the context is done.
+func NewKeySet(ctx context.Context, url string, opts Options) *KeySet {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.MinRefreshInterval <= 0 {
+		opts.MinRefreshInterval = DefaultMinRefreshInterval
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.RealClock
+	}
+
+	ks := &KeySet{
+		ctx:       ctx,
+		url:       url,
+		opts:      opts,
+		populated: make(chan struct{}),
+	}
+	go ks.run()
+	return ks
+}
+
+// VerifySignature verifies the signature of the given JWT using a key from the
+// key set and returns its payload.
+func (ks *KeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: malformed JWT: %w", err)
+	}
+
+	var kid string
+	if len(jws.Signatures) > 0 {
+		kid = jws.Signatures[0].Header.KeyID
+	}
+
+	keys, err := ks.currentKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if payload, ok := verify(jws, keys, kid); ok {
+		return payload, nil
+	}
+
+	// The provider may have rotated its keys since we last fetched them.
+	refreshed, err := ks.refreshForUnknownKey(ctx)
+	if err != nil {
+		return nil, err
+	} else if !refreshed {
+		return nil, ErrNoMatchingKey
+	}
+
+	if payload, ok := verify(jws, ks.cachedKeys(), kid); ok {
+		return payload, nil
+	}
+
+	return nil, ErrNoMatchingKey
+}
+
+// Refresh fetches the key set from the server, regardless of whether the
+// cached copy has expired.
+func (ks *KeySet) Refresh(ctx context.Context) error {
+	ks.fetchMut.Lock()
+	defer ks.fetchMut.Unlock()
+
+	return ks.fetch(ctx)
+}
+
+func (ks *KeySet) cachedKeys() []jose.JSONWebKey {
+	ks.mut.RLock()
+	defer ks.mut.RUnlock()
+
+	return ks.keys
+}
+
+func (ks *KeySet) currentKeys(ctx context.Context) ([]jose.JSONWebKey, error) {
+	ks.mut.RLock()
+	keys, expiry := ks.keys, ks.expiry
+	ks.mut.RUnlock()
+
+	if keys != nil && ks.opts.Clock.Now().Before(expiry) {
+		return keys, nil
+	}
+
+	ks.fetchMut.Lock()
+	defer ks.fetchMut.Unlock()
+
+	// Someone else may have fetched the key set while we were waiting.
+	ks.mut.RLock()
+	keys, expiry = ks.keys, ks.expiry
+	ks.mut.RUnlock()
+
+	if keys != nil && ks.opts.Clock.Now().Before(expiry) {
+		return keys, nil
+	}
+
+	if err := ks.fetch(ctx); err != nil {
+		// If we have keys, even stale ones, prefer them to failing outright.
+		if keys != nil {
+			return keys, nil
+		}
+
+		return nil, err
+	}
+
+	return ks.cachedKeys(), nil
+}
+
+func (ks *KeySet) refreshForUnknownKey(ctx context.Context) (bool, error) {
+	ks.fetchMut.Lock()
+	defer ks.fetchMut.Unlock()
+
+	ks.mut.RLock()
+	fetchTime := ks.fetchTime
+	ks.mut.RUnlock()
+
+	if ks.opts.Clock.Since(fetchTime) < ks.opts.MinRefreshInterval {
+		return false, nil
+	}
+
+	if err := ks.fetch(ctx); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// fetch requests the key set from the server. The fetch mutex must be held.
+func (ks *KeySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return err
+	}
+
+	ks.mut.RLock()
+	if ks.keys != nil {
+		if ks.etag != "" {
+			req.Header.Set("If-None-Match", ks.etag)
+		}
+		if ks.lastModified != "" {
+			req.Header.Set("If-Modified-Since", ks.lastModified)
+		}
+	}
+	ks.mut.RUnlock()
+
+	resp, err := oauth2.NewClient(ks.ctx, nil).Do(req)
+	if err != nil {
+		return fmt.Errorf("jwks: error fetching keys: %w", err)
+	}
+	defer resp.Body.Close()
+
+	now := ks.opts.Clock.Now()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		ks.mut.Lock()
+		defer ks.mut.Unlock()
+
+		ks.fetchTime = now
+		ks.expiry = now.Add(ks.ttl(resp.Header))
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("jwks: unexpected status %d fetching keys from %s", resp.StatusCode, ks.url)
+	}
+
+	// This is the same restriction as used by Go's OAuth2 package for
+	// consistency.
+	var set jose.JSONWebKeySet
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return fmt.Errorf("jwks: error decoding keys: %w", err)
+	}
+
+	keys := set.Keys
+	if keys == nil {
+		keys = []jose.JSONWebKey{}
+	}
+
+	ks.mut.Lock()
+	ks.keys = keys
+	ks.etag = resp.Header.Get("ETag")
+	ks.lastModified = resp.Header.Get("Last-Modified")
+	ks.fetchTime = now
+	ks.expiry = now.Add(ks.ttl(resp.Header))
+	ks.mut.Unlock()
+
+	ks.populatedOnce.Do(func() { close(ks.populated) })
+	return nil
+}
+
+// ttl determines how long to cache a response for, preferring the max-age
+// directive of the Cache-Control header if present.
+func (ks *KeySet) ttl(h http.Header) time.Duration {
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return ks.opts.MinRefreshInterval
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil {
+				continue
+			}
+
+			ttl := time.Duration(seconds) * time.Second
+			if ttl < ks.opts.MinRefreshInterval {
+				ttl = ks.opts.MinRefreshInterval
+			}
+			return ttl
+		}
+	}
+
+	return ks.opts.TTL
+}
+
+// run refreshes the key set as it expires so that verification does not need
+// to wait for a request to the server.
+func (ks *KeySet) run() {
+	select {
+	case <-ks.populated:
+	case <-ks.ctx.Done():
+		return
+	}
+
+	for {
+		ks.mut.RLock()
+		wait := ks.expiry.Sub(ks.opts.Clock.Now())
+		ks.mut.RUnlock()
+
+		if wait < 0 {
+			wait = 0
+		}
+
+		timer := ks.opts.Clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-ks.ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		if err := ks.Refresh(ks.ctx); err != nil {
+			// Try again after a short delay; the cached keys remain in use.
+			ks.mut.Lock()
+			ks.expiry = ks.opts.Clock.Now().Add(ks.opts.MinRefreshInterval)
+			ks.mut.Unlock()
+		}
+	}
+}
+
+func verify(jws *jose.JSONWebSignature, keys []jose.JSONWebKey, kid string) ([]byte, bool) {
+	for i := range keys {
+		if kid != "" && keys[i].KeyID != kid {
+			continue
+		}
+
+		if payload, err := jws.Verify(&keys[i]); err == nil {
+			return payload, true
+		}
+	}
+
+	return nil, false
+}
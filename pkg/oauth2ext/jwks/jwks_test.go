@@ -0,0 +1,127 @@
+package jwks_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/jwks"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+	testclock "k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestKeySet(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	newKey := func(kid string) (jose.JSONWebKey, jose.Signer) {
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		signer, err := jose.NewSigner(jose.SigningKey{
+			Algorithm: jose.RS256,
+			Key:       jose.JSONWebKey{Key: privateKey, KeyID: kid},
+		}, nil)
+		require.NoError(t, err)
+
+		return jose.JSONWebKey{Key: &privateKey.PublicKey, KeyID: kid, Use: "sig"}, signer
+	}
+
+	keyA, signerA := newKey("a")
+	keyB, signerB := newKey("b")
+
+	var (
+		mut         sync.Mutex
+		keys        = []jose.JSONWebKey{keyA}
+		etag        = `"1"`
+		requests    int32
+		notModified int32
+	)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		defer mut.Unlock()
+
+		atomic.AddInt32(&requests, 1)
+
+		if r.Header.Get("If-None-Match") == etag {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		_ = json.NewEncoder(w).Encode(&jose.JSONWebKeySet{Keys: keys})
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	ks := jwks.NewKeySet(ctx, "http://localhost/jwks", jwks.Options{
+		TTL:                time.Hour,
+		MinRefreshInterval: time.Minute,
+		Clock:              k8sext.NewClock(clk),
+	})
+
+	sign := func(signer jose.Signer) string {
+		jws, err := signer.Sign([]byte("payload"))
+		require.NoError(t, err)
+
+		token, err := jws.CompactSerialize()
+		require.NoError(t, err)
+
+		return token
+	}
+
+	// Keys are fetched once and then cached.
+	for i := 0; i < 3; i++ {
+		payload, err := ks.VerifySignature(ctx, sign(signerA))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("payload"), payload)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// The issuer rotates its keys.
+	mut.Lock()
+	keys = []jose.JSONWebKey{keyA, keyB}
+	etag = `"2"`
+	mut.Unlock()
+
+	// Unknown keys do not cause a refresh more often than the minimum
+	// interval.
+	_, err := ks.VerifySignature(ctx, sign(signerB))
+	require.True(t, errors.Is(err, jwks.ErrNoMatchingKey), "unexpected error: %+v", err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	clk.Step(2 * time.Minute)
+
+	payload, err := ks.VerifySignature(ctx, sign(signerB))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), payload)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// Once the keys expire, they are refreshed in the background using a
+	// conditional request.
+	clk.Step(2 * time.Hour)
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&notModified) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	payload, err = ks.VerifySignature(ctx, sign(signerA))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), payload)
+}
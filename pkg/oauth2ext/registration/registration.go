@@ -0,0 +1,138 @@
+// Package registration implements OAuth 2.0 dynamic client registration (RFC
+// 7591) and the corresponding management protocol (RFC 7592).
+package registration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// Client is the information a provider returns about a registered client.
+type Client struct {
+	ClientID                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
+
+	// Metadata contains the client metadata returned by the provider, not
+	// including any of the fields above.
+	Metadata map[string]interface{} `json:"-"`
+}
+
+var clientFields = []string{
+	"client_id",
+	"client_secret",
+	"client_secret_expires_at",
+	"client_id_issued_at",
+	"registration_access_token",
+	"registration_client_uri",
+}
+
+// Register creates a new client at the given registration endpoint. If the
+// provider requires one, initialAccessToken authorizes the request.
+func Register(ctx context.Context, endpoint, initialAccessToken string, metadata map[string]interface{}) (*Client, error) {
+	return do(ctx, http.MethodPost, endpoint, initialAccessToken, metadata)
+}
+
+// Update replaces the metadata of a registered client. Providers may issue a
+// new client secret in response.
+func Update(ctx context.Context, clientURI, registrationAccessToken, clientID string, metadata map[string]interface{}) (*Client, error) {
+	body := make(map[string]interface{}, len(metadata)+1)
+	for k, v := range metadata {
+		body[k] = v
+	}
+	body["client_id"] = clientID
+
+	return do(ctx, http.MethodPut, clientURI, registrationAccessToken, body)
+}
+
+// Delete removes a registered client from the provider.
+func Delete(ctx context.Context, clientURI, registrationAccessToken string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, clientURI, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+registrationAccessToken)
+
+	resp, err := oauth2.NewClient(ctx, nil).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return fmt.Errorf("cannot delete client registration: %w", err)
+		}
+
+		return &oauth2.RetrieveError{
+			Response: resp,
+			Body:     body,
+		}
+	}
+
+	return nil
+}
+
+func do(ctx context.Context, method, url, token string, metadata map[string]interface{}) (*Client, error) {
+	b, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := oauth2.NewClient(ctx, nil).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// This is the same restriction as used by Go's OAuth2 package for
+	// consistency.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("cannot register client: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &oauth2.RetrieveError{
+			Response: resp,
+			Body:     body,
+		}
+	}
+
+	client := &Client{}
+	if err := json.Unmarshal(body, client); err != nil {
+		return nil, err
+	} else if client.ClientID == "" {
+		return nil, errors.New("server response missing client_id")
+	}
+
+	if err := json.Unmarshal(body, &client.Metadata); err != nil {
+		return nil, err
+	}
+	for _, field := range clientFields {
+		delete(client.Metadata, field)
+	}
+
+	return client, nil
+}
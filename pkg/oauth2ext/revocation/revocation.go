@@ -0,0 +1,76 @@
+// Package revocation implements OAuth 2.0 token revocation (RFC 7009).
+package revocation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+type Config struct {
+	*oauth2.Config
+
+	RevocationURL string
+}
+
+// Revoke asks the provider to invalidate the given token. Per RFC 7009, the
+// provider responds successfully even if the token was already invalid.
+func (c *Config) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	v := url.Values{
+		"token": {token},
+	}
+	if tokenTypeHint != "" {
+		v.Set("token_type_hint", tokenTypeHint)
+	}
+
+	// Clients authenticate the same way they do at the token endpoint.
+	inHeader := c.Endpoint.AuthStyle != oauth2.AuthStyleInParams
+	if !inHeader {
+		v.Set("client_id", c.ClientID)
+		if c.ClientSecret != "" {
+			v.Set("client_secret", c.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.RevocationURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if inHeader {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	resp, err := oauth2.NewClient(ctx, nil).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// This is the same restriction as used by Go's OAuth2 package for
+	// consistency.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("cannot revoke token: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &oauth2.RetrieveError{
+			Response: resp,
+			Body:     body,
+		}
+	}
+
+	return nil
+}
@@ -15,6 +15,10 @@ type Error struct {
 	Code        string
 	Description string
 	URI         string
+
+	// StatusCode is the HTTP status code of the response that contained this
+	// error.
+	StatusCode int
 }
 
 func (e *Error) Error() string {
@@ -37,6 +41,22 @@ func IsCode(err error, code string) bool {
 	return e.Code == code
 }
 
+// StatusCode returns the HTTP status code of the server response that caused
+// the given error, if any.
+func StatusCode(err error) (int, bool) {
+	var e *Error
+	if errors.As(err, &e) && e.StatusCode != 0 {
+		return e.StatusCode, true
+	}
+
+	var rerr *oauth2.RetrieveError
+	if errors.As(err, &rerr) && rerr.Response != nil {
+		return rerr.Response.StatusCode, true
+	}
+
+	return 0, false
+}
+
 func RuleCode(code string) errmark.Rule {
 	return errmark.RuleFunc(func(err error) bool {
 		return IsCode(err, code)
@@ -76,6 +96,7 @@ func Map(cerr error) error {
 			Code:        env.Error,
 			Description: env.ErrorDescription,
 			URI:         env.ErrorURI,
+			StatusCode:  rerr.Response.StatusCode,
 		},
 		errmark.RuleAny(
 			RuleCode("invalid_request"),
@@ -0,0 +1,109 @@
+package persistence
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	accessTokenLeaseKeyPrefix = "access-token-leases/"
+)
+
+type AccessTokenLeaseKeyer interface {
+	// AccessTokenLeaseKey returns the storage key for storing
+	// AccessTokenLeaseEntry objects.
+	AccessTokenLeaseKey() string
+}
+
+// AccessTokenLeaseEntry tracks the Vault leases that hold an access token, so
+// that the token is only revoked at the provider when the last of them is
+// revoked.
+type AccessTokenLeaseEntry struct {
+	// Count is the number of unrevoked leases for the token.
+	Count int `json:"count"`
+}
+
+type AccessTokenLeaseKey string
+
+var _ AccessTokenLeaseKeyer = AccessTokenLeaseKey("")
+
+func (ak AccessTokenLeaseKey) AccessTokenLeaseKey() string {
+	return accessTokenLeaseKeyPrefix + string(ak)
+}
+
+// AccessTokenLeaseName returns the keyer for the given access token. The token
+// is hashed so that it cannot be recovered by listing storage.
+func AccessTokenLeaseName(accessToken string) AccessTokenLeaseKeyer {
+	hash := sha256.Sum256([]byte(accessToken))
+	return AccessTokenLeaseKey(fmt.Sprintf("%x", hash))
+}
+
+type LockedAccessTokenLeaseManager struct {
+	storage logical.Storage
+	keyer   AccessTokenLeaseKeyer
+}
+
+func (lam *LockedAccessTokenLeaseManager) ReadAccessTokenLeaseEntry(ctx context.Context) (*AccessTokenLeaseEntry, error) {
+	se, err := lam.storage.Get(ctx, lam.keyer.AccessTokenLeaseKey())
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return nil, nil
+	}
+
+	entry := &AccessTokenLeaseEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (lam *LockedAccessTokenLeaseManager) WriteAccessTokenLeaseEntry(ctx context.Context, entry *AccessTokenLeaseEntry) error {
+	se, err := logical.StorageEntryJSON(lam.keyer.AccessTokenLeaseKey(), entry)
+	if err != nil {
+		return err
+	}
+
+	return lam.storage.Put(ctx, se)
+}
+
+func (lam *LockedAccessTokenLeaseManager) DeleteAccessTokenLeaseEntry(ctx context.Context) error {
+	return lam.storage.Delete(ctx, lam.keyer.AccessTokenLeaseKey())
+}
+
+type AccessTokenLeaseManager struct {
+	storage logical.Storage
+	locks   []*locksutil.LockEntry
+}
+
+func (am *AccessTokenLeaseManager) WithLock(keyer AccessTokenLeaseKeyer, fn func(*LockedAccessTokenLeaseManager) error) error {
+	lock := locksutil.LockForKey(am.locks, keyer.AccessTokenLeaseKey())
+	lock.Lock()
+	defer lock.Unlock()
+
+	return fn(&LockedAccessTokenLeaseManager{
+		storage: am.storage,
+		keyer:   keyer,
+	})
+}
+
+// AddAccessTokenLease records a new lease for the access token with the given
+// keyer.
+func (am *AccessTokenLeaseManager) AddAccessTokenLease(ctx context.Context, keyer AccessTokenLeaseKeyer) error {
+	return am.WithLock(keyer, func(lam *LockedAccessTokenLeaseManager) error {
+		entry, err := lam.ReadAccessTokenLeaseEntry(ctx)
+		if err != nil {
+			return err
+		} else if entry == nil {
+			entry = &AccessTokenLeaseEntry{}
+		}
+
+		entry.Count++
+		return lam.WriteAccessTokenLeaseEntry(ctx, entry)
+	})
+}
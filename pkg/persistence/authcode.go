@@ -7,19 +7,35 @@ package persistence
 import (
 	"context"
 	"crypto/sha1"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
 )
 
 const (
-	authCodeKeyPrefix   = "creds/"
-	deviceAuthKeyPrefix = "devices/"
+	authCodeKeyPrefix             = "creds/"
+	deviceAuthKeyPrefix           = "devices/"
+	pendingStateKeyPrefix         = "pending-states/"
+	pendingAuthorizationKeyPrefix = "pending-authorizations/"
+	authCodeExchangeKeyPrefix     = "exchanges/"
+	reapedAuthCodeKeyPrefix       = "reaped/"
+	entityAuthCodeKeyPrefix       = "entity-creds/"
 )
 
+// AuthCodeObserver is notified after a credential is written or deleted while
+// its lock is held.
+type AuthCodeObserver interface {
+	AuthCodeWritten(keyer AuthCodeKeyer, entry *AuthCodeEntry)
+	AuthCodeDeleted(keyer AuthCodeKeyer)
+}
+
 type AuthCodeKeyer interface {
 	// AuthCodeKey returns the storage key for storing AuthCodeEntry objects.
 	AuthCodeKey() string
@@ -27,6 +43,28 @@ type AuthCodeKeyer interface {
 	// DeviceAuthKey returns the storage key for storing DeviceAuthEntry
 	// objects.
 	DeviceAuthKey() string
+
+	// PendingStateKey returns the storage key for storing PendingStateEntry
+	// objects.
+	PendingStateKey() string
+
+	// PendingAuthorizationKey returns the storage key for storing
+	// PendingAuthorizationEntry objects.
+	PendingAuthorizationKey() string
+
+	// AuthCodeExchangeKey returns the storage key for storing
+	// AuthCodeExchangeEntry objects.
+	AuthCodeExchangeKey() string
+
+	// ReapedAuthCodeKey returns the storage key for storing
+	// ReapedAuthCodeEntry objects.
+	ReapedAuthCodeKey() string
+}
+
+// AuthCodeNamer is implemented by keyers that know the name of the credential
+// they refer to.
+type AuthCodeNamer interface {
+	AuthCodeName() string
 }
 
 type AuthCodeEntry struct {
@@ -35,6 +73,10 @@ type AuthCodeEntry struct {
 	// configuration.
 	*provider.Token `json:",inline"`
 
+	// Name is the name of the credential. It is not present for credentials
+	// written by versions of this plugin prior to its introduction.
+	Name string `json:"name,omitempty"`
+
 	// LastIssueTime is the most recent time a token was successfully issued.
 	LastIssueTime time.Time `json:"last_issue_time,omitempty"`
 
@@ -55,26 +97,427 @@ type AuthCodeEntry struct {
 	// If the most recent exchange did not succeed, this holds the time that
 	// exchange occurred.
 	LastAttemptedIssueTime time.Time `json:"last_attempted_issue_time,omitempty"`
+
+	// LastProviderResponseCode is the HTTP status code of the provider
+	// response to the most recent exchange, if it did not succeed.
+	LastProviderResponseCode int `json:"last_provider_response_code,omitempty"`
+
+	// RefreshTokenRotated indicates that the provider issued a new refresh
+	// token during the most recent refresh, invalidating the previous one.
+	RefreshTokenRotated bool `json:"refresh_token_rotated,omitempty"`
+
+	// ReauthorizationRequired indicates that the refresh token was rejected by
+	// the provider and the user must authorize the application again.
+	ReauthorizationRequired bool `json:"reauthorization_required,omitempty"`
+
+	// RefreshTokenIssueTime is the time the current refresh token was issued.
+	// It is retained when a refresh does not rotate the refresh token.
+	RefreshTokenIssueTime time.Time `json:"refresh_token_issue_time,omitempty"`
+
+	// RefreshTokenExpireTime is the time the current refresh token expires,
+	// if the provider reported it.
+	RefreshTokenExpireTime time.Time `json:"refresh_token_expire_time,omitempty"`
+
+	// RefreshTokenTTLSeconds is the known lifetime of refresh tokens for
+	// providers that do not report it.
+	RefreshTokenTTLSeconds int `json:"refresh_token_ttl_seconds,omitempty"`
+
+	// ReauthorizeBeforeSeconds is the amount of time before the refresh token
+	// expires that the user should authorize the application again.
+	ReauthorizeBeforeSeconds int `json:"reauthorize_before_seconds,omitempty"`
+
+	// Disabled indicates that an operator suspended this credential. Its token
+	// is retained, but it is neither refreshed nor returned to clients.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// DisableTime is the time the credential was disabled.
+	DisableTime time.Time `json:"disable_time,omitempty"`
+
+	// LastRefreshCheckTime is the most recent time the automatic refresher
+	// considered this credential for refresh.
+	LastRefreshCheckTime time.Time `json:"last_refresh_check_time,omitempty"`
+
+	// GrantedScopes are the scopes the provider reported granting when the
+	// token was first issued. They are retained across refreshes.
+	GrantedScopes []string `json:"granted_scopes,omitempty"`
+
+	// RevokedScopes are the granted scopes that the provider no longer
+	// reported granting as of the most recent refresh.
+	RevokedScopes []string `json:"revoked_scopes,omitempty"`
+
+	// ScopeDowngradeTime is the time a refresh first reported the current set
+	// of revoked scopes.
+	ScopeDowngradeTime time.Time `json:"scope_downgrade_time,omitempty"`
+
+	// InactiveTime is the time the provider first reported that the current
+	// access token is no longer active, even though it has not expired.
+	InactiveTime time.Time `json:"inactive_time,omitempty"`
+
+	// Metadata holds values copied from the claims of the token according to
+	// the claim mapping of the mount configuration, in addition to any
+	// StaticMetadata.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Template is the name of the template this credential was created from,
+	// if any.
+	Template string `json:"template,omitempty"`
+
+	// StaticMetadata holds metadata inherited from a template. Values copied
+	// from claims take precedence.
+	StaticMetadata map[string]string `json:"static_metadata,omitempty"`
+
+	// Resources are the RFC 8707 resource indicators to request when the
+	// token is refreshed.
+	Resources []string `json:"resources,omitempty"`
+
+	// BoundEntityID and BoundTokenAccessor restrict access to this
+	// credential to requests made by the given Vault entity or token.
+	BoundEntityID      string `json:"bound_entity_id,omitempty"`
+	BoundTokenAccessor string `json:"bound_token_accessor,omitempty"`
+
+	// CreatorEntityID is the ID of the Vault entity that created this
+	// credential, if any. The credential counts toward the entity's quota.
+	CreatorEntityID string `json:"creator_entity_id,omitempty"`
+
+	// Tuning overrides the mount tuning for this credential, if set.
+	Tuning *AuthCodeTuningEntry `json:"tuning,omitempty"`
+
+	// JWTBearer holds the configuration for minting assertions if this
+	// credential was issued using the JWT bearer grant. Such credentials are
+	// renewed by minting a new assertion instead of using a refresh token.
+	JWTBearer *JWTBearerEntry `json:"jwt_bearer,omitempty"`
+
+	// Version is the revision of this credential. It is incremented every time
+	// the credential is replaced by a write (but not by a refresh).
+	Version int `json:"version,omitempty"`
+
+	// PreviousVersions holds the tokens this credential has replaced, most
+	// recent first. Its length is bounded by the configured retention.
+	PreviousVersions []*AuthCodeVersionEntry `json:"previous_versions,omitempty"`
+
+	// History records the outcomes of recent requests to the provider to
+	// issue or refresh the token, most recent first. Its length is bounded by
+	// the configured retention.
+	History []*AuthCodeHistoryEntry `json:"history,omitempty"`
 }
 
-func (ace *AuthCodeEntry) SetToken(tok *provider.Token) {
+func (ace *AuthCodeEntry) SetToken(tok *provider.Token, now time.Time) {
 	ace.Token = tok
-	ace.LastIssueTime = time.Now()
+	ace.LastIssueTime = now
 	ace.UserError = ""
 	ace.TransientErrorsSinceLastIssue = 0
 	ace.LastTransientError = ""
 	ace.LastAttemptedIssueTime = time.Time{}
+	ace.LastProviderResponseCode = 0
+	ace.RefreshTokenRotated = false
+	ace.ReauthorizationRequired = false
+	ace.RefreshTokenIssueTime = time.Time{}
+	ace.RefreshTokenExpireTime = time.Time{}
+	ace.GrantedScopes = tokenScopes(tok)
+	ace.RevokedScopes = nil
+	ace.ScopeDowngradeTime = time.Time{}
+	ace.InactiveTime = time.Time{}
+
+	if tok != nil && tok.Token != nil && tok.RefreshToken != "" {
+		ace.RefreshTokenIssueTime = ace.LastIssueTime
+
+		if expiresIn, ok := refreshTokenExpiresIn(tok); ok {
+			ace.RefreshTokenExpireTime = ace.LastIssueTime.Add(expiresIn)
+		}
+	}
 }
 
-func (ace *AuthCodeEntry) SetUserError(err string) {
+// SetRefreshedToken replaces the token with one obtained by refreshing it,
+// tracking whether the provider rotated the refresh token and whether it
+// stopped granting any of the originally granted scopes.
+func (ace *AuthCodeEntry) SetRefreshedToken(tok *provider.Token, now time.Time) {
+	rotated := ace.Token != nil && tok.RefreshToken != "" && tok.RefreshToken != ace.RefreshToken
+	issueTime, expireTime := ace.RefreshTokenIssueTime, ace.RefreshTokenExpireTime
+	granted, revoked, downgradeTime := ace.GrantedScopes, ace.RevokedScopes, ace.ScopeDowngradeTime
+
+	ace.SetToken(tok, now)
+	ace.RefreshTokenRotated = rotated
+
+	// The lifetime of a refresh token that was not rotated is unchanged.
+	if !rotated && !issueTime.IsZero() {
+		ace.RefreshTokenIssueTime = issueTime
+		ace.RefreshTokenExpireTime = expireTime
+	}
+
+	// If the scopes were not known when the token was issued (for example,
+	// because only a refresh token was imported), the first refresh
+	// establishes them.
+	if len(granted) == 0 {
+		return
+	}
+
+	current := ace.GrantedScopes
+	ace.GrantedScopes = granted
+
+	// Providers may omit the scopes from a refresh response if they are
+	// unchanged.
+	if current == nil {
+		ace.RevokedScopes, ace.ScopeDowngradeTime = revoked, downgradeTime
+		return
+	}
+
+	ace.RevokedScopes = missingScopes(granted, current)
+	switch {
+	case len(ace.RevokedScopes) == 0:
+		ace.RevokedScopes = nil
+	case equalScopes(ace.RevokedScopes, revoked):
+		ace.ScopeDowngradeTime = downgradeTime
+	default:
+		ace.ScopeDowngradeTime = now
+	}
+}
+
+// ScopesDowngraded indicates whether the provider no longer grants some of the
+// scopes originally granted to this credential.
+func (ace *AuthCodeEntry) ScopesDowngraded() bool {
+	return len(ace.RevokedScopes) > 0
+}
+
+// tokenScopes returns the scopes the provider reported granting with the given
+// token, or nil if it did not report them. Most providers separate scopes with
+// spaces as RFC 6749 requires, but some, like Slack, use commas.
+func tokenScopes(tok *provider.Token) []string {
+	if tok == nil || tok.Token == nil {
+		return nil
+	}
+
+	raw, ok := tok.ExtraData["scope"].(string)
+	if !ok {
+		raw, ok = tok.Extra("scope").(string)
+	}
+	if !ok {
+		return nil
+	}
+
+	scopes := strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return scopes
+}
+
+// missingScopes returns the scopes in want that are not in have.
+func missingScopes(want, have []string) []string {
+	set := make(map[string]struct{}, len(have))
+	for _, scope := range have {
+		set[scope] = struct{}{}
+	}
+
+	var missing []string
+	for _, scope := range want {
+		if _, found := set[scope]; !found {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+func equalScopes(a, b []string) bool {
+	return len(a) == len(b) && len(missingScopes(a, b)) == 0
+}
+
+// refreshTokenExpiresIn returns the lifetime of the refresh token reported by
+// the provider, if any. GitHub, for example, reports it in the
+// refresh_token_expires_in field of the token response.
+func refreshTokenExpiresIn(tok *provider.Token) (time.Duration, bool) {
+	var seconds int64
+	switch v := tok.Extra("refresh_token_expires_in").(type) {
+	case int64:
+		seconds = v
+	case float64:
+		seconds = int64(v)
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return 0, false
+		}
+		seconds = i
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		seconds = i
+	default:
+		return 0, false
+	}
+
+	if seconds <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+func (ace *AuthCodeEntry) SetUserError(err string, now time.Time) {
 	ace.UserError = err
-	ace.LastAttemptedIssueTime = time.Now()
+	ace.LastAttemptedIssueTime = now
+}
+
+// SetReauthorizationRequired records a permanent error that can only be
+// resolved by the user authorizing the application again.
+func (ace *AuthCodeEntry) SetReauthorizationRequired(err string, now time.Time) {
+	ace.SetUserError(err, now)
+	ace.ReauthorizationRequired = true
 }
 
-func (ace *AuthCodeEntry) SetTransientError(err string) {
+func (ace *AuthCodeEntry) SetTransientError(err string, now time.Time) {
 	ace.TransientErrorsSinceLastIssue++
 	ace.LastTransientError = err
-	ace.LastAttemptedIssueTime = time.Now()
+	ace.LastAttemptedIssueTime = now
+}
+
+// BoundTo returns true if a request made by the given Vault entity and token
+// accessor may access this credential.
+func (ace *AuthCodeEntry) BoundTo(entityID, accessor string) bool {
+	switch {
+	case ace.BoundEntityID != "" && ace.BoundEntityID != entityID:
+		return false
+	case ace.BoundTokenAccessor != "" && ace.BoundTokenAccessor != accessor:
+		return false
+	default:
+		return true
+	}
+}
+
+// Supersede prepares this entry to replace the given entry in storage. It
+// assigns the next version number and retains at most n previous versions of
+// the token.
+func (ace *AuthCodeEntry) Supersede(prev *AuthCodeEntry, n int, now time.Time) {
+	if prev == nil {
+		ace.Version = 1
+		ace.PreviousVersions = nil
+		return
+	}
+
+	ace.Version = prev.Version + 1
+
+	// A disabled credential stays disabled until it is explicitly enabled,
+	// even if it is issued a new token.
+	ace.Disabled = prev.Disabled
+	ace.DisableTime = prev.DisableTime
+
+	// Reauthorization settings belong to the credential, not the token.
+	if ace.RefreshTokenTTLSeconds == 0 {
+		ace.RefreshTokenTTLSeconds = prev.RefreshTokenTTLSeconds
+	}
+	if ace.ReauthorizeBeforeSeconds == 0 {
+		ace.ReauthorizeBeforeSeconds = prev.ReauthorizeBeforeSeconds
+	}
+	if ace.Tuning == nil {
+		ace.Tuning = prev.Tuning
+	}
+	if len(ace.Resources) == 0 {
+		ace.Resources = prev.Resources
+	}
+	if ace.Template == "" {
+		ace.Template = prev.Template
+		ace.StaticMetadata = prev.StaticMetadata
+	}
+
+	// Only the identity the credential is bound to can replace its token, so
+	// the binding is kept.
+	ace.BoundEntityID = prev.BoundEntityID
+	ace.BoundTokenAccessor = prev.BoundTokenAccessor
+	ace.CreatorEntityID = prev.CreatorEntityID
+
+	// The history describes the credential across all of its versions.
+	ace.History = prev.History
+
+	var versions []*AuthCodeVersionEntry
+	if prev.TokenIssued() {
+		versions = append(versions, &AuthCodeVersionEntry{
+			Version:        prev.Version,
+			Token:          prev.Token,
+			SupersededTime: now,
+		})
+	}
+	versions = append(versions, prev.PreviousVersions...)
+
+	if n < 0 {
+		n = 0
+	}
+	if len(versions) > n {
+		versions = versions[:n]
+	}
+	if len(versions) == 0 {
+		versions = nil
+	}
+
+	ace.PreviousVersions = versions
+}
+
+// RecordHistory adds an event to the history of this credential, retaining at
+// most n events.
+func (ace *AuthCodeEntry) RecordHistory(he *AuthCodeHistoryEntry, n int) {
+	history := append([]*AuthCodeHistoryEntry{he}, ace.History...)
+
+	if n < 0 {
+		n = 0
+	}
+	if len(history) > n {
+		history = history[:n]
+	}
+	if len(history) == 0 {
+		history = nil
+	}
+
+	ace.History = history
+}
+
+// PreviousVersion looks up a retained previous version of this credential.
+func (ace *AuthCodeEntry) PreviousVersion(version int) (*AuthCodeVersionEntry, bool) {
+	for _, ve := range ace.PreviousVersions {
+		if ve.Version == version {
+			return ve, true
+		}
+	}
+
+	return nil, false
+}
+
+// Refreshable indicates whether a new token can be obtained for this
+// credential without user interaction.
+func (ace *AuthCodeEntry) Refreshable() bool {
+	return ace.JWTBearer != nil || (ace.Token != nil && ace.RefreshToken != "")
+}
+
+// RefreshTokenExpiry returns the time the refresh token expires, or the zero
+// time if its lifetime is not known.
+func (ace *AuthCodeEntry) RefreshTokenExpiry() time.Time {
+	switch {
+	case !ace.RefreshTokenExpireTime.IsZero():
+		return ace.RefreshTokenExpireTime
+	case ace.RefreshTokenTTLSeconds > 0 && !ace.RefreshTokenIssueTime.IsZero():
+		return ace.RefreshTokenIssueTime.Add(time.Duration(ace.RefreshTokenTTLSeconds) * time.Second)
+	default:
+		return time.Time{}
+	}
+}
+
+// ReauthorizeTime returns the time at which the user should authorize the
+// application again to avoid the refresh token expiring, or the zero time if
+// the lifetime of the refresh token is not known.
+func (ace *AuthCodeEntry) ReauthorizeTime() time.Time {
+	expiry := ace.RefreshTokenExpiry()
+	if expiry.IsZero() {
+		return expiry
+	}
+
+	return expiry.Add(-time.Duration(ace.ReauthorizeBeforeSeconds) * time.Second)
+}
+
+// AuthorizationPending indicates whether this credential can no longer be
+// used without the user authorizing the application again.
+func (ace *AuthCodeEntry) AuthorizationPending() bool {
+	return ace.UserError != ""
 }
 
 // TokenIssued indicates whether a token has been issued at all.
@@ -86,6 +529,94 @@ func (ace *AuthCodeEntry) TokenIssued() bool {
 	return ace.Token != nil && ace.AccessToken != ""
 }
 
+// RefreshDeferred indicates that this credential was imported with only a
+// refresh token and its first access token will be obtained when it is read.
+func (ace *AuthCodeEntry) RefreshDeferred() bool {
+	return ace.Token != nil && ace.AccessToken == "" && ace.RefreshToken != ""
+}
+
+// AuthCodeTuningEntry overrides parts of the mount tuning for a single
+// credential. Fields that are not set use the mount tuning.
+type AuthCodeTuningEntry struct {
+	ProviderTimeoutSeconds     *int     `json:"provider_timeout_seconds,omitempty"`
+	RefreshExpiryDeltaFactor   *float64 `json:"refresh_expiry_delta_factor,omitempty"`
+	ReapNonRefreshableSeconds  *int     `json:"reap_non_refreshable_seconds,omitempty"`
+	ReapRevokedSeconds         *int     `json:"reap_revoked_seconds,omitempty"`
+	ReapTransientErrorAttempts *int     `json:"reap_transient_error_attempts,omitempty"`
+	ReapTransientErrorSeconds  *int     `json:"reap_transient_error_seconds,omitempty"`
+}
+
+// Empty returns true if this entry does not override any tuning.
+func (ate *AuthCodeTuningEntry) Empty() bool {
+	return ate == nil || *ate == AuthCodeTuningEntry{}
+}
+
+// Apply returns the given mount tuning with the overrides of this entry
+// applied. It is safe to call on a nil entry.
+func (ate *AuthCodeTuningEntry) Apply(tuning ConfigTuningEntry) ConfigTuningEntry {
+	if ate == nil {
+		return tuning
+	}
+
+	if ate.ProviderTimeoutSeconds != nil {
+		tuning.ProviderTimeoutSeconds = *ate.ProviderTimeoutSeconds
+	}
+	if ate.RefreshExpiryDeltaFactor != nil {
+		tuning.RefreshExpiryDeltaFactor = *ate.RefreshExpiryDeltaFactor
+	}
+	if ate.ReapNonRefreshableSeconds != nil {
+		tuning.ReapNonRefreshableSeconds = *ate.ReapNonRefreshableSeconds
+	}
+	if ate.ReapRevokedSeconds != nil {
+		tuning.ReapRevokedSeconds = *ate.ReapRevokedSeconds
+	}
+	if ate.ReapTransientErrorAttempts != nil {
+		tuning.ReapTransientErrorAttempts = *ate.ReapTransientErrorAttempts
+	}
+	if ate.ReapTransientErrorSeconds != nil {
+		tuning.ReapTransientErrorSeconds = *ate.ReapTransientErrorSeconds
+	}
+	return tuning
+}
+
+// AuthCodeVersionEntry is a token that has been replaced by a newer version of
+// a credential.
+type AuthCodeVersionEntry struct {
+	Version        int             `json:"version"`
+	Token          *provider.Token `json:"token"`
+	SupersededTime time.Time       `json:"superseded_time"`
+}
+
+// AuthCodeHistoryEntry is the outcome of a request to the provider to issue or
+// refresh the token of a credential. It never contains the token itself.
+type AuthCodeHistoryEntry struct {
+	Time                 time.Time `json:"time"`
+	Event                string    `json:"event"`
+	Error                string    `json:"error,omitempty"`
+	ProviderResponseCode int       `json:"provider_response_code,omitempty"`
+}
+
+// JWTBearerEntry describes how to mint the assertion for a JWT bearer grant
+// (RFC 7523).
+type JWTBearerEntry struct {
+	// SigningKey is the PEM-encoded private key used to sign assertions.
+	SigningKey string `json:"signing_key"`
+
+	// SigningKeyID is the optional key ID to include in the assertion header.
+	SigningKeyID string `json:"signing_key_id,omitempty"`
+
+	// SigningAlgorithm is the JWS algorithm used to sign assertions.
+	SigningAlgorithm string `json:"signing_algorithm"`
+
+	Issuer   string                 `json:"issuer"`
+	Subject  string                 `json:"subject"`
+	Audience []string               `json:"audience"`
+	Claims   map[string]interface{} `json:"claims,omitempty"`
+
+	Scopes          []string          `json:"scopes,omitempty"`
+	ProviderOptions map[string]string `json:"provider_options,omitempty"`
+}
+
 type DeviceAuthEntry struct {
 	DeviceCode             string            `json:"device_code"`
 	Interval               int32             `json:"interval"`
@@ -93,33 +624,169 @@ type DeviceAuthEntry struct {
 	ProviderOptions        map[string]string `json:"provider_options"`
 }
 
-func (dae *DeviceAuthEntry) ShouldPoll() bool {
-	return dae.LastAttemptedIssueTime.Add(time.Duration(dae.Interval) * time.Second).Before(time.Now())
+func (dae *DeviceAuthEntry) ShouldPoll(now time.Time) bool {
+	return dae.LastAttemptedIssueTime.Add(time.Duration(dae.Interval) * time.Second).Before(now)
+}
+
+// AuthCodeExchangeEntry is an authorization code waiting to be exchanged for a
+// token in the background.
+type AuthCodeExchangeEntry struct {
+	Code            string            `json:"code"`
+	RedirectURL     string            `json:"redirect_url,omitempty"`
+	Resources       []string          `json:"resources,omitempty"`
+	ProviderOptions map[string]string `json:"provider_options,omitempty"`
+	SubmitTime      time.Time         `json:"submit_time"`
+	FailTime        time.Time         `json:"fail_time,omitempty"`
+}
+
+// Failed indicates whether the exchange was attempted and did not succeed.
+// Providers generally reject a code they have already processed, so a failed
+// exchange is not attempted again.
+func (acee *AuthCodeExchangeEntry) Failed() bool {
+	return !acee.FailTime.IsZero()
+}
+
+// SetFailed records that the exchange did not succeed and discards the code.
+func (acee *AuthCodeExchangeEntry) SetFailed(t time.Time) {
+	acee.Code = ""
+	acee.FailTime = t
+}
+
+// PendingStateEntry indicates that a state has been generated for a
+// credential, so writing an authorization code to the credential requires it.
+type PendingStateEntry struct {
+	ExpireTime time.Time `json:"expire_time"`
+}
+
+// Expired indicates whether this entry has expired as of the given time.
+func (pse *PendingStateEntry) Expired(now time.Time) bool {
+	return !pse.ExpireTime.After(now)
+}
+
+// PendingAuthorizationEntry records that a credential requires the user to
+// authorize the application again, either because the provider rejected it or
+// because its refresh token will expire.
+type PendingAuthorizationEntry struct {
+	Name   string    `json:"name"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+
+	// RefreshTokenExpireTime and ReauthorizeTime are set if the lifetime of
+	// the refresh token is known.
+	RefreshTokenExpireTime time.Time `json:"refresh_token_expire_time,omitempty"`
+	ReauthorizeTime        time.Time `json:"reauthorize_time,omitempty"`
+
+	// NotifyTime is the time a notification was successfully sent for this
+	// entry.
+	NotifyTime time.Time `json:"notify_time,omitempty"`
+}
+
+// Due indicates whether the user must authorize the application again as of
+// the given time.
+func (pae *PendingAuthorizationEntry) Due(now time.Time) bool {
+	return pae.Reason != "" || (!pae.ReauthorizeTime.IsZero() && !now.Before(pae.ReauthorizeTime))
+}
+
+// DueTime returns the time the user was first required to authorize the
+// application again.
+func (pae *PendingAuthorizationEntry) DueTime() time.Time {
+	if pae.Reason != "" || pae.ReauthorizeTime.IsZero() {
+		return pae.Time
+	}
+
+	return pae.ReauthorizeTime
+}
+
+// ReapedAuthCodeEntry is a credential that was removed by the reaper and is
+// retained in quarantine so that it can be restored.
+type ReapedAuthCodeEntry struct {
+	Name       string         `json:"name"`
+	Entry      *AuthCodeEntry `json:"entry"`
+	Reason     string         `json:"reason"`
+	ReapTime   time.Time      `json:"reap_time"`
+	ExpireTime time.Time      `json:"expire_time"`
+}
+
+// Expired indicates whether this entry should be permanently deleted as of the
+// given time.
+func (rae *ReapedAuthCodeEntry) Expired(now time.Time) bool {
+	return !rae.ExpireTime.After(now)
 }
 
 type AuthCodeKey string
 
 var _ AuthCodeKeyer = AuthCodeKey("")
 
-func (ack AuthCodeKey) AuthCodeKey() string   { return authCodeKeyPrefix + string(ack) }
-func (ack AuthCodeKey) DeviceAuthKey() string { return deviceAuthKeyPrefix + string(ack) }
+func (ack AuthCodeKey) AuthCodeKey() string     { return authCodeKeyPrefix + string(ack) }
+func (ack AuthCodeKey) DeviceAuthKey() string   { return deviceAuthKeyPrefix + string(ack) }
+func (ack AuthCodeKey) PendingStateKey() string { return pendingStateKeyPrefix + string(ack) }
+func (ack AuthCodeKey) PendingAuthorizationKey() string {
+	return pendingAuthorizationKeyPrefix + string(ack)
+}
+func (ack AuthCodeKey) AuthCodeExchangeKey() string { return authCodeExchangeKeyPrefix + string(ack) }
+func (ack AuthCodeKey) ReapedAuthCodeKey() string   { return reapedAuthCodeKeyPrefix + string(ack) }
+
+// AuthCodeKeyFromStorageKey returns the keyer for a credential given its
+// storage key, such as a key passed to a backend invalidation function.
+func AuthCodeKeyFromStorageKey(key string) (AuthCodeKeyer, bool) {
+	if !strings.HasPrefix(key, authCodeKeyPrefix) {
+		return nil, false
+	}
+
+	return AuthCodeKey(strings.TrimPrefix(key, authCodeKeyPrefix)), true
+}
+
+// authCodeName is a keyer for a credential that also retains its name.
+type authCodeName struct {
+	key  AuthCodeKey
+	name string
+}
+
+var _ AuthCodeKeyer = authCodeName{}
+var _ AuthCodeNamer = authCodeName{}
+
+func (acn authCodeName) AuthCodeKey() string             { return acn.key.AuthCodeKey() }
+func (acn authCodeName) DeviceAuthKey() string           { return acn.key.DeviceAuthKey() }
+func (acn authCodeName) PendingStateKey() string         { return acn.key.PendingStateKey() }
+func (acn authCodeName) PendingAuthorizationKey() string { return acn.key.PendingAuthorizationKey() }
+func (acn authCodeName) AuthCodeExchangeKey() string     { return acn.key.AuthCodeExchangeKey() }
+func (acn authCodeName) ReapedAuthCodeKey() string       { return acn.key.ReapedAuthCodeKey() }
+func (acn authCodeName) AuthCodeName() string            { return acn.name }
 
 func AuthCodeName(name string) AuthCodeKeyer {
 	hash := sha1.Sum([]byte(name))
 	first, second, rest := hash[:2], hash[2:4], hash[4:]
-	return AuthCodeKey(fmt.Sprintf("%x/%x/%x", first, second, rest))
+	return authCodeName{
+		key:  AuthCodeKey(fmt.Sprintf("%x/%x/%x", first, second, rest)),
+		name: name,
+	}
 }
 
 type LockedAuthCodeManager struct {
-	storage logical.Storage
-	keyer   AuthCodeKeyer
+	storage  logical.Storage
+	keyer    AuthCodeKeyer
+	observer AuthCodeObserver
+	quota    *authCodeQuota
+
+	// stored records whether the credential was in storage when it was last
+	// read, written, or deleted under the lock, if it has been, so that
+	// writes know whether the credential is new without reading it again.
+	stored *bool
+}
+
+// setStored records whether the credential is in storage.
+func (lacm *LockedAuthCodeManager) setStored(stored bool) {
+	lacm.stored = &stored
 }
 
 func (lacm *LockedAuthCodeManager) ReadAuthCodeEntry(ctx context.Context) (*AuthCodeEntry, error) {
 	se, err := lacm.storage.Get(ctx, lacm.keyer.AuthCodeKey())
 	if err != nil {
 		return nil, err
-	} else if se == nil {
+	}
+
+	lacm.setStored(se != nil)
+	if se == nil {
 		return nil, nil
 	}
 
@@ -147,13 +814,130 @@ func (lacm *LockedAuthCodeManager) ReadDeviceAuthEntry(ctx context.Context) (*De
 	return entry, nil
 }
 
+func (lacm *LockedAuthCodeManager) ReadPendingStateEntry(ctx context.Context) (*PendingStateEntry, error) {
+	se, err := lacm.storage.Get(ctx, lacm.keyer.PendingStateKey())
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return nil, nil
+	}
+
+	entry := &PendingStateEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (lacm *LockedAuthCodeManager) ReadPendingAuthorizationEntry(ctx context.Context) (*PendingAuthorizationEntry, error) {
+	se, err := lacm.storage.Get(ctx, lacm.keyer.PendingAuthorizationKey())
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return nil, nil
+	}
+
+	entry := &PendingAuthorizationEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (lacm *LockedAuthCodeManager) ReadAuthCodeExchangeEntry(ctx context.Context) (*AuthCodeExchangeEntry, error) {
+	se, err := lacm.storage.Get(ctx, lacm.keyer.AuthCodeExchangeKey())
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return nil, nil
+	}
+
+	entry := &AuthCodeExchangeEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (lacm *LockedAuthCodeManager) ReadReapedAuthCodeEntry(ctx context.Context) (*ReapedAuthCodeEntry, error) {
+	se, err := lacm.storage.Get(ctx, lacm.keyer.ReapedAuthCodeKey())
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return nil, nil
+	}
+
+	entry := &ReapedAuthCodeEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// WriteAuthCodeEntry stores the given credential. It also maintains the
+// inventory of credentials that require authorization.
 func (lacm *LockedAuthCodeManager) WriteAuthCodeEntry(ctx context.Context, entry *AuthCodeEntry) error {
+	if namer, ok := lacm.keyer.(AuthCodeNamer); ok && entry.Name == "" {
+		entry.Name = namer.AuthCodeName()
+	}
+
 	se, err := logical.StorageEntryJSON(lacm.keyer.AuthCodeKey(), entry)
 	if err != nil {
 		return err
 	}
 
-	return lacm.storage.Put(ctx, se)
+	var isNew bool
+	if lacm.stored != nil {
+		isNew = !*lacm.stored
+	} else {
+		prev, err := lacm.storage.Get(ctx, se.Key)
+		if err != nil {
+			return err
+		}
+		isNew = prev == nil
+	}
+
+	if err := lacm.putAuthCodeEntry(ctx, se, entry, isNew); err != nil {
+		return err
+	}
+	lacm.setStored(true)
+
+	if lacm.observer != nil {
+		lacm.observer.AuthCodeWritten(lacm.keyer, entry)
+	}
+
+	next := &PendingAuthorizationEntry{
+		Name:                   entry.Name,
+		RefreshTokenExpireTime: entry.RefreshTokenExpiry(),
+		ReauthorizeTime:        entry.ReauthorizeTime(),
+	}
+	if entry.AuthorizationPending() {
+		next.Reason = entry.UserError
+		next.Time = entry.LastAttemptedIssueTime
+		if next.Time.IsZero() {
+			next.Time = clockctx.Clock(ctx).Now()
+		}
+	} else if next.RefreshTokenExpireTime.IsZero() {
+		return lacm.storage.Delete(ctx, lacm.keyer.PendingAuthorizationKey())
+	}
+
+	// Keep the existing entry (and its notification state) if nothing about
+	// it has changed.
+	pae, err := lacm.ReadPendingAuthorizationEntry(ctx)
+	if err != nil {
+		return err
+	} else if pae != nil &&
+		pae.Reason == next.Reason &&
+		pae.RefreshTokenExpireTime.Equal(next.RefreshTokenExpireTime) &&
+		pae.ReauthorizeTime.Equal(next.ReauthorizeTime) {
+		return nil
+	}
+
+	return lacm.WritePendingAuthorizationEntry(ctx, next)
 }
 
 func (lacm *LockedAuthCodeManager) WriteDeviceAuthEntry(ctx context.Context, entry *DeviceAuthEntry) error {
@@ -165,17 +949,80 @@ func (lacm *LockedAuthCodeManager) WriteDeviceAuthEntry(ctx context.Context, ent
 	return lacm.storage.Put(ctx, se)
 }
 
+func (lacm *LockedAuthCodeManager) WritePendingStateEntry(ctx context.Context, entry *PendingStateEntry) error {
+	se, err := logical.StorageEntryJSON(lacm.keyer.PendingStateKey(), entry)
+	if err != nil {
+		return err
+	}
+
+	return lacm.storage.Put(ctx, se)
+}
+
+func (lacm *LockedAuthCodeManager) WriteAuthCodeExchangeEntry(ctx context.Context, entry *AuthCodeExchangeEntry) error {
+	se, err := logical.StorageEntryJSON(lacm.keyer.AuthCodeExchangeKey(), entry)
+	if err != nil {
+		return err
+	}
+
+	return lacm.storage.Put(ctx, se)
+}
+
+func (lacm *LockedAuthCodeManager) WritePendingAuthorizationEntry(ctx context.Context, entry *PendingAuthorizationEntry) error {
+	se, err := logical.StorageEntryJSON(lacm.keyer.PendingAuthorizationKey(), entry)
+	if err != nil {
+		return err
+	}
+
+	return lacm.storage.Put(ctx, se)
+}
+
+func (lacm *LockedAuthCodeManager) WriteReapedAuthCodeEntry(ctx context.Context, entry *ReapedAuthCodeEntry) error {
+	se, err := logical.StorageEntryJSON(lacm.keyer.ReapedAuthCodeKey(), entry)
+	if err != nil {
+		return err
+	}
+
+	return lacm.storage.Put(ctx, se)
+}
+
 func (lacm *LockedAuthCodeManager) DeleteAuthCodeEntry(ctx context.Context) error {
-	return lacm.storage.Delete(ctx, lacm.keyer.AuthCodeKey())
+	if err := lacm.deleteAuthCodeEntry(ctx); err != nil {
+		return err
+	}
+	lacm.setStored(false)
+
+	if lacm.observer != nil {
+		lacm.observer.AuthCodeDeleted(lacm.keyer)
+	}
+
+	if err := lacm.storage.Delete(ctx, lacm.keyer.AuthCodeExchangeKey()); err != nil {
+		return err
+	}
+
+	return lacm.storage.Delete(ctx, lacm.keyer.PendingAuthorizationKey())
 }
 
 func (lacm *LockedAuthCodeManager) DeleteDeviceAuthEntry(ctx context.Context) error {
 	return lacm.storage.Delete(ctx, lacm.keyer.DeviceAuthKey())
 }
 
+func (lacm *LockedAuthCodeManager) DeleteAuthCodeExchangeEntry(ctx context.Context) error {
+	return lacm.storage.Delete(ctx, lacm.keyer.AuthCodeExchangeKey())
+}
+
+func (lacm *LockedAuthCodeManager) DeletePendingStateEntry(ctx context.Context) error {
+	return lacm.storage.Delete(ctx, lacm.keyer.PendingStateKey())
+}
+
+func (lacm *LockedAuthCodeManager) DeleteReapedAuthCodeEntry(ctx context.Context) error {
+	return lacm.storage.Delete(ctx, lacm.keyer.ReapedAuthCodeKey())
+}
+
 type AuthCodeManager struct {
-	storage logical.Storage
-	locks   []*locksutil.LockEntry
+	storage  logical.Storage
+	locks    []*locksutil.LockEntry
+	observer AuthCodeObserver
+	quota    *authCodeQuota
 }
 
 func (acm *AuthCodeManager) WithLock(keyer AuthCodeKeyer, fn func(*LockedAuthCodeManager) error) error {
@@ -184,11 +1031,40 @@ func (acm *AuthCodeManager) WithLock(keyer AuthCodeKeyer, fn func(*LockedAuthCod
 	defer lock.Unlock()
 
 	return fn(&LockedAuthCodeManager{
-		storage: acm.storage,
-		keyer:   keyer,
+		storage:  acm.storage,
+		keyer:    keyer,
+		observer: acm.observer,
+		quota:    acm.quota,
 	})
 }
 
+// WithLocks is like WithLock, but holds the locks for all of the given
+// credentials at once. The locks are acquired in a consistent order, so
+// concurrent callers cannot deadlock.
+func (acm *AuthCodeManager) WithLocks(keyers []AuthCodeKeyer, fn func([]*LockedAuthCodeManager) error) error {
+	keys := make([]string, len(keyers))
+	for i, keyer := range keyers {
+		keys[i] = keyer.AuthCodeKey()
+	}
+
+	for _, lock := range locksutil.LocksForKeys(acm.locks, keys) {
+		lock.Lock()
+		defer lock.Unlock()
+	}
+
+	lacms := make([]*LockedAuthCodeManager, len(keyers))
+	for i, keyer := range keyers {
+		lacms[i] = &LockedAuthCodeManager{
+			storage:  acm.storage,
+			keyer:    keyer,
+			observer: acm.observer,
+			quota:    acm.quota,
+		}
+	}
+
+	return fn(lacms)
+}
+
 func (acm *AuthCodeManager) ReadAuthCodeEntry(ctx context.Context, keyer AuthCodeKeyer) (*AuthCodeEntry, error) {
 	var entry *AuthCodeEntry
 	err := acm.WithLock(keyer, func(lacm *LockedAuthCodeManager) (err error) {
@@ -207,6 +1083,42 @@ func (acm *AuthCodeManager) ReadDeviceAuthEntry(ctx context.Context, keyer AuthC
 	return entry, err
 }
 
+func (acm *AuthCodeManager) ReadAuthCodeExchangeEntry(ctx context.Context, keyer AuthCodeKeyer) (*AuthCodeExchangeEntry, error) {
+	var entry *AuthCodeExchangeEntry
+	err := acm.WithLock(keyer, func(lacm *LockedAuthCodeManager) (err error) {
+		entry, err = lacm.ReadAuthCodeExchangeEntry(ctx)
+		return
+	})
+	return entry, err
+}
+
+func (acm *AuthCodeManager) ReadPendingStateEntry(ctx context.Context, keyer AuthCodeKeyer) (*PendingStateEntry, error) {
+	var entry *PendingStateEntry
+	err := acm.WithLock(keyer, func(lacm *LockedAuthCodeManager) (err error) {
+		entry, err = lacm.ReadPendingStateEntry(ctx)
+		return
+	})
+	return entry, err
+}
+
+func (acm *AuthCodeManager) ReadPendingAuthorizationEntry(ctx context.Context, keyer AuthCodeKeyer) (*PendingAuthorizationEntry, error) {
+	var entry *PendingAuthorizationEntry
+	err := acm.WithLock(keyer, func(lacm *LockedAuthCodeManager) (err error) {
+		entry, err = lacm.ReadPendingAuthorizationEntry(ctx)
+		return
+	})
+	return entry, err
+}
+
+func (acm *AuthCodeManager) ReadReapedAuthCodeEntry(ctx context.Context, keyer AuthCodeKeyer) (*ReapedAuthCodeEntry, error) {
+	var entry *ReapedAuthCodeEntry
+	err := acm.WithLock(keyer, func(lacm *LockedAuthCodeManager) (err error) {
+		entry, err = lacm.ReadReapedAuthCodeEntry(ctx)
+		return
+	})
+	return entry, err
+}
+
 func (acm *AuthCodeManager) WriteAuthCodeEntry(ctx context.Context, keyer AuthCodeKeyer, entry *AuthCodeEntry) error {
 	return acm.WithLock(keyer, func(lacm *LockedAuthCodeManager) error {
 		return lacm.WriteAuthCodeEntry(ctx, entry)
@@ -236,7 +1148,57 @@ func (acm *AuthCodeManager) ForEachAuthCodeKey(ctx context.Context, fn func(Auth
 	return logical.ScanView(ctx, view, func(path string) { fn(AuthCodeKey(path)) })
 }
 
+// ForEachAuthCodeKeyPage calls fn with batches of at most size credential
+// keys, so that callers that need to read each credential never hold more than
+// one batch of entries in memory. Storage is listed incrementally as pages are
+// requested. Iteration stops at the first error returned by fn.
+func (acm *AuthCodeManager) ForEachAuthCodeKeyPage(ctx context.Context, size int, fn func([]AuthCodeKeyer) error) error {
+	return forEachAuthCodeKeyPage(ctx, acm.storage, authCodeKeyPrefix, size, fn)
+}
+
+func (acm *AuthCodeManager) ForEachPendingStateKey(ctx context.Context, fn func(AuthCodeKeyer)) error {
+	view := logical.NewStorageView(acm.storage, pendingStateKeyPrefix)
+	return logical.ScanView(ctx, view, func(path string) { fn(AuthCodeKey(path)) })
+}
+
 func (acm *AuthCodeManager) ForEachDeviceAuthKey(ctx context.Context, fn func(AuthCodeKeyer)) error {
 	view := logical.NewStorageView(acm.storage, deviceAuthKeyPrefix)
 	return logical.ScanView(ctx, view, func(path string) { fn(AuthCodeKey(path)) })
 }
+
+func (acm *AuthCodeManager) ForEachAuthCodeExchangeKey(ctx context.Context, fn func(AuthCodeKeyer)) error {
+	view := logical.NewStorageView(acm.storage, authCodeExchangeKeyPrefix)
+	return logical.ScanView(ctx, view, func(path string) { fn(AuthCodeKey(path)) })
+}
+
+// ForEachPendingStateKeyPage is like ForEachAuthCodeKeyPage, but for pending
+// authorization code states.
+func (acm *AuthCodeManager) ForEachPendingStateKeyPage(ctx context.Context, size int, fn func([]AuthCodeKeyer) error) error {
+	return forEachAuthCodeKeyPage(ctx, acm.storage, pendingStateKeyPrefix, size, fn)
+}
+
+// ForEachReapedAuthCodeKeyPage is like ForEachAuthCodeKeyPage, but for
+// quarantined credentials.
+func (acm *AuthCodeManager) ForEachReapedAuthCodeKeyPage(ctx context.Context, size int, fn func([]AuthCodeKeyer) error) error {
+	return forEachAuthCodeKeyPage(ctx, acm.storage, reapedAuthCodeKeyPrefix, size, fn)
+}
+
+func (acm *AuthCodeManager) ForEachReapedAuthCodeKey(ctx context.Context, fn func(AuthCodeKeyer)) error {
+	view := logical.NewStorageView(acm.storage, reapedAuthCodeKeyPrefix)
+	return logical.ScanView(ctx, view, func(path string) { fn(AuthCodeKey(path)) })
+}
+
+func (acm *AuthCodeManager) ForEachPendingAuthorizationKey(ctx context.Context, fn func(AuthCodeKeyer)) error {
+	view := logical.NewStorageView(acm.storage, pendingAuthorizationKeyPrefix)
+	return logical.ScanView(ctx, view, func(path string) { fn(AuthCodeKey(path)) })
+}
+
+func forEachAuthCodeKeyPage(ctx context.Context, storage logical.Storage, prefix string, size int, fn func([]AuthCodeKeyer) error) error {
+	return forEachKeyPage(ctx, storage, prefix, size, func(keys []string) error {
+		page := make([]AuthCodeKeyer, len(keys))
+		for i, key := range keys {
+			page[i] = AuthCodeKey(key)
+		}
+		return fn(page)
+	})
+}
@@ -0,0 +1,301 @@
+package persistence_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+var (
+	benchmarkEntryCounts = []int{1000, 100000}
+	benchmarkParallelism = []int{1, 8}
+)
+
+func benchmarkAuthCodeEntry(i int) *persistence.AuthCodeEntry {
+	ace := &persistence.AuthCodeEntry{}
+	ace.SetToken(&provider.Token{
+		Token: &oauth2.Token{
+			AccessToken:  fmt.Sprintf("access-%d", i),
+			RefreshToken: fmt.Sprintf("refresh-%d", i),
+			Expiry:       time.Now().Add(time.Hour),
+		},
+	}, time.Now())
+	return ace
+}
+
+// benchmarkAuthCodeStorage returns storage containing the given number of
+// credentials, named bench-0 through bench-(n-1).
+func benchmarkAuthCodeStorage(b *testing.B, n int) (logical.Storage, *persistence.AuthCodeManager) {
+	if n > 1000 && testing.Short() {
+		b.Skip("not populating large storage in short mode")
+	}
+
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	acm := persistence.NewHolder().Managers(storage).AuthCode()
+
+	for i := 0; i < n; i++ {
+		keyer := persistence.AuthCodeName(fmt.Sprintf("bench-%d", i))
+		require.NoError(b, acm.WriteAuthCodeEntry(ctx, keyer, benchmarkAuthCodeEntry(i)))
+	}
+
+	return storage, acm
+}
+
+// benchmarkAuthCode runs fn for every combination of entry count and
+// parallelism. Each goroutine calls fn with successive integers, starting from
+// a different offset, until the benchmark is done.
+func benchmarkAuthCode(b *testing.B, fn func(b *testing.B, acm *persistence.AuthCodeManager, n, i int)) {
+	for _, n := range benchmarkEntryCounts {
+		b.Run(fmt.Sprintf("entries=%d", n), func(b *testing.B) {
+			_, acm := benchmarkAuthCodeStorage(b, n)
+
+			for _, p := range benchmarkParallelism {
+				b.Run(fmt.Sprintf("parallelism=%d", p), func(b *testing.B) {
+					var offset int64
+
+					b.ReportAllocs()
+					b.SetParallelism(p)
+					b.ResetTimer()
+					b.RunParallel(func(pb *testing.PB) {
+						i := int(atomic.AddInt64(&offset, 7919))
+						for pb.Next() {
+							fn(b, acm, n, i)
+							i++
+						}
+					})
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkAuthCodeRead(b *testing.B) {
+	ctx := context.Background()
+
+	benchmarkAuthCode(b, func(b *testing.B, acm *persistence.AuthCodeManager, n, i int) {
+		ace, err := acm.ReadAuthCodeEntry(ctx, persistence.AuthCodeName(fmt.Sprintf("bench-%d", i%n)))
+		if err != nil || ace == nil {
+			b.Fatalf("read failed: %+v", err)
+		}
+	})
+}
+
+func BenchmarkAuthCodeWrite(b *testing.B) {
+	ctx := context.Background()
+
+	benchmarkAuthCode(b, func(b *testing.B, acm *persistence.AuthCodeManager, n, i int) {
+		if err := acm.WriteAuthCodeEntry(ctx, persistence.AuthCodeName(fmt.Sprintf("bench-%d", i%n)), benchmarkAuthCodeEntry(i)); err != nil {
+			b.Fatalf("write failed: %+v", err)
+		}
+	})
+}
+
+func BenchmarkAuthCodeList(b *testing.B) {
+	ctx := context.Background()
+
+	for _, n := range benchmarkEntryCounts {
+		b.Run(fmt.Sprintf("entries=%d", n), func(b *testing.B) {
+			_, acm := benchmarkAuthCodeStorage(b, n)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				var found int
+				err := acm.ForEachAuthCodeKeyPage(ctx, 500, func(page []persistence.AuthCodeKeyer) error {
+					found += len(page)
+					return nil
+				})
+				if err != nil || found != n {
+					b.Fatalf("listed %d of %d credentials: %+v", found, n, err)
+				}
+			}
+			b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(b.N*n), "ns/entry")
+		})
+	}
+}
+
+func TestAuthCodeEntryScopeDowngrade(t *testing.T) {
+	now := time.Now()
+
+	token := func(scope string) *provider.Token {
+		tok := &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken:  "access",
+				RefreshToken: "refresh",
+			},
+		}
+		if scope != "" {
+			tok.ExtraData = map[string]interface{}{"scope": scope}
+		}
+		return tok
+	}
+
+	ace := &persistence.AuthCodeEntry{}
+	ace.SetToken(token("read write admin"), now)
+	require.Equal(t, []string{"read", "write", "admin"}, ace.GrantedScopes)
+	require.False(t, ace.ScopesDowngraded())
+
+	// A refresh that does not report scopes leaves them unchanged.
+	ace.SetRefreshedToken(token(""), now.Add(time.Minute))
+	require.False(t, ace.ScopesDowngraded())
+
+	ace.SetRefreshedToken(token("read,write"), now.Add(2*time.Minute))
+	require.True(t, ace.ScopesDowngraded())
+	require.Equal(t, []string{"admin"}, ace.RevokedScopes)
+	require.Equal(t, now.Add(2*time.Minute), ace.ScopeDowngradeTime)
+	require.Equal(t, []string{"read", "write", "admin"}, ace.GrantedScopes)
+
+	// The downgrade time is retained while the same scopes are missing.
+	ace.SetRefreshedToken(token("write read"), now.Add(3*time.Minute))
+	require.Equal(t, []string{"admin"}, ace.RevokedScopes)
+	require.Equal(t, now.Add(2*time.Minute), ace.ScopeDowngradeTime)
+
+	ace.SetRefreshedToken(token(""), now.Add(4*time.Minute))
+	require.Equal(t, []string{"admin"}, ace.RevokedScopes)
+	require.Equal(t, now.Add(2*time.Minute), ace.ScopeDowngradeTime)
+
+	ace.SetRefreshedToken(token("read"), now.Add(5*time.Minute))
+	require.Equal(t, []string{"write", "admin"}, ace.RevokedScopes)
+	require.Equal(t, now.Add(5*time.Minute), ace.ScopeDowngradeTime)
+
+	ace.SetRefreshedToken(token("read write admin"), now.Add(6*time.Minute))
+	require.False(t, ace.ScopesDowngraded())
+	require.True(t, ace.ScopeDowngradeTime.IsZero())
+
+	// Issuing a new token establishes a new set of granted scopes.
+	ace.SetRefreshedToken(token("read"), now.Add(7*time.Minute))
+	require.True(t, ace.ScopesDowngraded())
+
+	ace.SetToken(token("read"), now.Add(8*time.Minute))
+	require.Equal(t, []string{"read"}, ace.GrantedScopes)
+	require.False(t, ace.ScopesDowngraded())
+}
+
+func TestAuthCodeEntryClaimMetadata(t *testing.T) {
+	ace := &persistence.AuthCodeEntry{}
+	ace.SetToken(&provider.Token{
+		Token: &oauth2.Token{AccessToken: "access"},
+		ExtraData: map[string]interface{}{
+			"id_token_claims": map[string]interface{}{
+				"sub":   "1234",
+				"email": "alice@example.com",
+				"age":   float64(42),
+			},
+			"user_info": map[string]interface{}{
+				"email": "alice@example.org",
+			},
+		},
+	}, time.Now())
+
+	// UserInfo claims take precedence over ID token claims.
+	ace.SetClaimMetadata(map[string]string{
+		"user":    "sub",
+		"email":   "email",
+		"age":     "age",
+		"missing": "nickname",
+	})
+	require.Equal(t, map[string]string{
+		"user":  "1234",
+		"email": "alice@example.org",
+		"age":   "42",
+	}, ace.Metadata)
+
+	require.True(t, ace.MatchesMetadata(nil))
+	require.True(t, ace.MatchesMetadata(map[string]string{"user": "1234", "age": "42"}))
+	require.False(t, ace.MatchesMetadata(map[string]string{"user": "1234", "missing": ""}))
+
+	ace.SetClaimMetadata(nil)
+	require.Nil(t, ace.Metadata)
+}
+
+func TestReserveAuthCode(t *testing.T) {
+	ctx := context.Background()
+	acm := persistence.NewHolder().Managers(&logical.InmemStorage{}).AuthCode()
+
+	write := func(name, entityID string) {
+		require.NoError(t, acm.WriteAuthCodeEntry(ctx, persistence.AuthCodeName(name), &persistence.AuthCodeEntry{
+			Token:           &provider.Token{Token: &oauth2.Token{AccessToken: name}},
+			CreatorEntityID: entityID,
+		}))
+	}
+
+	requireExceeded := func(entityID string, limit, entityLimit int) {
+		_, err := acm.ReserveAuthCode(ctx, entityID, limit, entityLimit)
+		require.True(t, errors.As(err, new(*persistence.AuthCodeQuotaError)), "expected quota error, got %+v", err)
+	}
+
+	// Credentials written before the counts exist are counted from storage.
+	write("a1", "alice")
+
+	// Reservations count toward the limits until they are released.
+	release, err := acm.ReserveAuthCode(ctx, "alice", 3, 2)
+	require.NoError(t, err)
+	requireExceeded("alice", 3, 2)
+
+	write("a2", "alice")
+	release()
+	requireExceeded("alice", 3, 2)
+
+	// Releasing more than once has no effect.
+	release()
+	requireExceeded("alice", 3, 2)
+
+	release, err = acm.ReserveAuthCode(ctx, "bob", 3, 2)
+	require.NoError(t, err)
+	requireExceeded("", 3, 0)
+	release()
+
+	// Deleting a credential frees it for the mount and for its creator.
+	require.NoError(t, acm.DeleteAuthCodeEntry(ctx, persistence.AuthCodeName("a2")))
+	release, err = acm.ReserveAuthCode(ctx, "alice", 3, 2)
+	require.NoError(t, err)
+	release()
+
+	// Replacing a credential does not count it again.
+	write("a1", "alice")
+	release, err = acm.ReserveAuthCode(ctx, "alice", 3, 2)
+	require.NoError(t, err)
+	release()
+
+	// A credential deleted and written again under the same lock is counted
+	// again.
+	require.NoError(t, acm.WithLock(persistence.AuthCodeName("a1"), func(lacm *persistence.LockedAuthCodeManager) error {
+		entry, err := lacm.ReadAuthCodeEntry(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, entry)
+
+		require.NoError(t, lacm.DeleteAuthCodeEntry(ctx))
+		return lacm.WriteAuthCodeEntry(ctx, entry)
+	}))
+	write("a2", "alice")
+	requireExceeded("alice", 3, 2)
+	require.NoError(t, acm.DeleteAuthCodeEntry(ctx, persistence.AuthCodeName("a2")))
+
+	// Concurrent reservations cannot exceed the limit.
+	var reserved int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if _, err := acm.ReserveAuthCode(ctx, "carol", 100, 3); err == nil {
+				atomic.AddInt32(&reserved, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(3), reserved)
+}
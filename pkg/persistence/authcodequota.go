@@ -0,0 +1,310 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	authCodeCountKey = "cred-count"
+
+	// countKeyPageSize is the number of keys listed at a time when counting
+	// credentials for the first time.
+	countKeyPageSize = 1000
+)
+
+// AuthCodeQuotaError is returned when creating a credential would exceed the
+// number of credentials allowed in the mount or for an entity.
+type AuthCodeQuotaError struct {
+	// EntityID is the ID of the entity whose limit would be exceeded, or empty
+	// if the limit of the mount would be exceeded.
+	EntityID string
+
+	// Limit is the number of credentials allowed.
+	Limit int
+}
+
+func (e *AuthCodeQuotaError) Error() string {
+	if e.EntityID == "" {
+		return fmt.Sprintf("this mount already has the maximum of %d credentials", e.Limit)
+	}
+
+	return fmt.Sprintf("entity %q already has the maximum of %d credentials", e.EntityID, e.Limit)
+}
+
+type authCodeCountEntry struct {
+	Count int `json:"count"`
+}
+
+// authCodeQuota serializes changes to the number of credentials in the mount
+// and created by each entity. It also tracks credentials that have passed a
+// quota check but have not been written yet, so that concurrent requests
+// cannot exceed a limit. Only the active node creates credentials, so
+// reservations do not need to be persisted.
+type authCodeQuota struct {
+	mut      sync.Mutex
+	reserved map[string]int
+}
+
+func entityAuthCodeKey(entityID string, keyer AuthCodeKeyer) string {
+	return entityAuthCodeKeyPrefix + entityID + "/" + strings.TrimPrefix(keyer.AuthCodeKey(), authCodeKeyPrefix)
+}
+
+func entityAuthCodeCountKey(entityID string) string {
+	return entityAuthCodeKeyPrefix + entityID
+}
+
+func readAuthCodeCount(ctx context.Context, storage logical.Storage, key string) (int, bool, error) {
+	se, err := storage.Get(ctx, key)
+	if err != nil || se == nil {
+		return 0, false, err
+	}
+
+	entry := &authCodeCountEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return 0, false, err
+	}
+
+	return entry.Count, true, nil
+}
+
+func writeAuthCodeCount(ctx context.Context, storage logical.Storage, key string, n int) error {
+	se, err := logical.StorageEntryJSON(key, &authCodeCountEntry{Count: n})
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, se)
+}
+
+// adjustAuthCodeCount adds delta to the count stored at the given key. Counts
+// that have never been read are left alone, as they are computed from storage
+// when they are first needed. The quota lock must be held.
+func adjustAuthCodeCount(ctx context.Context, storage logical.Storage, key string, delta int) error {
+	n, ok, err := readAuthCodeCount(ctx, storage, key)
+	if err != nil || !ok {
+		return err
+	}
+
+	n += delta
+	if n < 0 {
+		n = 0
+	}
+
+	return writeAuthCodeCount(ctx, storage, key, n)
+}
+
+// putAuthCodeEntry stores the given credential, counting it if it is new.
+func (lacm *LockedAuthCodeManager) putAuthCodeEntry(ctx context.Context, se *logical.StorageEntry, entry *AuthCodeEntry, isNew bool) error {
+	if !isNew {
+		return lacm.storage.Put(ctx, se)
+	}
+
+	lacm.quota.mut.Lock()
+	defer lacm.quota.mut.Unlock()
+
+	if err := lacm.storage.Put(ctx, se); err != nil {
+		return err
+	}
+
+	if err := adjustAuthCodeCount(ctx, lacm.storage, authCodeCountKey, 1); err != nil {
+		return err
+	}
+
+	if entry.CreatorEntityID != "" {
+		return lacm.addEntityAuthCode(ctx, entry.CreatorEntityID)
+	}
+
+	return nil
+}
+
+// deleteAuthCodeEntry removes the credential and updates the counts of the
+// mount and its creator.
+func (lacm *LockedAuthCodeManager) deleteAuthCodeEntry(ctx context.Context) error {
+	entry, err := lacm.ReadAuthCodeEntry(ctx)
+	if err != nil || entry == nil {
+		return err
+	}
+
+	lacm.quota.mut.Lock()
+	defer lacm.quota.mut.Unlock()
+
+	if err := lacm.storage.Delete(ctx, lacm.keyer.AuthCodeKey()); err != nil {
+		return err
+	}
+
+	if err := adjustAuthCodeCount(ctx, lacm.storage, authCodeCountKey, -1); err != nil {
+		return err
+	}
+
+	if entry.CreatorEntityID != "" {
+		return lacm.deleteEntityAuthCode(ctx, entry.CreatorEntityID)
+	}
+
+	return nil
+}
+
+// addEntityAuthCode records that the credential was created by the given
+// entity. The quota lock must be held.
+func (lacm *LockedAuthCodeManager) addEntityAuthCode(ctx context.Context, entityID string) error {
+	key := entityAuthCodeKey(entityID, lacm.keyer)
+
+	se, err := lacm.storage.Get(ctx, key)
+	if err != nil || se != nil {
+		return err
+	}
+
+	if err := lacm.storage.Put(ctx, &logical.StorageEntry{Key: key}); err != nil {
+		return err
+	}
+
+	return adjustAuthCodeCount(ctx, lacm.storage, entityAuthCodeCountKey(entityID), 1)
+}
+
+// deleteEntityAuthCode removes the record that the credential was created by
+// the given entity. The quota lock must be held.
+func (lacm *LockedAuthCodeManager) deleteEntityAuthCode(ctx context.Context, entityID string) error {
+	key := entityAuthCodeKey(entityID, lacm.keyer)
+
+	se, err := lacm.storage.Get(ctx, key)
+	if err != nil || se == nil {
+		return err
+	}
+
+	if err := lacm.storage.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	return adjustAuthCodeCount(ctx, lacm.storage, entityAuthCodeCountKey(entityID), -1)
+}
+
+// countAuthCodeEntries returns the number of credentials in the mount. The
+// count is kept up to date as credentials are written and deleted, so storage
+// is only scanned the first time. The quota lock must be held.
+func (acm *AuthCodeManager) countAuthCodeEntries(ctx context.Context) (int, error) {
+	if n, ok, err := readAuthCodeCount(ctx, acm.storage, authCodeCountKey); err != nil || ok {
+		return n, err
+	}
+
+	var n int
+	err := forEachKeyPage(ctx, acm.storage, authCodeKeyPrefix, countKeyPageSize, func(keys []string) error {
+		n += len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, writeAuthCodeCount(ctx, acm.storage, authCodeCountKey, n)
+}
+
+// countEntityAuthCodes returns the number of credentials created by the Vault
+// entity with the given ID that still exist. The count is kept in the entity's
+// index alongside the records of its credentials, so the index is only scanned
+// the first time. Records of credentials that have since been deleted are
+// removed by the scan. The quota lock must be held.
+func (acm *AuthCodeManager) countEntityAuthCodes(ctx context.Context, entityID string) (int, error) {
+	countKey := entityAuthCodeCountKey(entityID)
+	if n, ok, err := readAuthCodeCount(ctx, acm.storage, countKey); err != nil || ok {
+		return n, err
+	}
+
+	prefix := countKey + "/"
+
+	var n int
+	err := forEachKeyPage(ctx, acm.storage, prefix, countKeyPageSize, func(keys []string) error {
+		for _, key := range keys {
+			se, err := acm.storage.Get(ctx, AuthCodeKey(key).AuthCodeKey())
+			if err != nil {
+				return err
+			} else if se == nil {
+				if err := acm.storage.Delete(ctx, prefix+key); err != nil {
+					return err
+				}
+				continue
+			}
+
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, writeAuthCodeCount(ctx, acm.storage, countKey, n)
+}
+
+// ReserveAuthCode checks that a new credential can be created by the Vault
+// entity with the given ID, if any, without the mount exceeding limit
+// credentials or the entity exceeding entityLimit credentials. A limit of 0
+// allows any number of credentials. If a limit would be exceeded, an
+// *AuthCodeQuotaError is returned.
+//
+// Otherwise, the credential counts toward the limits as if it existed until
+// the returned function is called, which must happen once the credential and
+// its creator have been written or its creation has failed.
+func (acm *AuthCodeManager) ReserveAuthCode(ctx context.Context, entityID string, limit, entityLimit int) (func(), error) {
+	type check struct {
+		key      string
+		entityID string
+		limit    int
+		count    func() (int, error)
+	}
+
+	var checks []check
+	if limit > 0 {
+		checks = append(checks, check{
+			key:   authCodeCountKey,
+			limit: limit,
+			count: func() (int, error) { return acm.countAuthCodeEntries(ctx) },
+		})
+	}
+	if entityLimit > 0 && entityID != "" {
+		checks = append(checks, check{
+			key:      entityAuthCodeCountKey(entityID),
+			entityID: entityID,
+			limit:    entityLimit,
+			count:    func() (int, error) { return acm.countEntityAuthCodes(ctx, entityID) },
+		})
+	}
+
+	q := acm.quota
+
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	for _, c := range checks {
+		n, err := c.count()
+		if err != nil {
+			return nil, err
+		} else if n+q.reserved[c.key] >= c.limit {
+			return nil, &AuthCodeQuotaError{EntityID: c.entityID, Limit: c.limit}
+		}
+	}
+
+	if q.reserved == nil {
+		q.reserved = make(map[string]int)
+	}
+	for _, c := range checks {
+		q.reserved[c.key]++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mut.Lock()
+			defer q.mut.Unlock()
+
+			for _, c := range checks {
+				if q.reserved[c.key]--; q.reserved[c.key] <= 0 {
+					delete(q.reserved, c.key)
+				}
+			}
+		})
+	}, nil
+}
@@ -0,0 +1,235 @@
+package persistence
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	authCodeStateKeyPrefix     = "states/"
+	authCodeStateSigningKeyKey = "state_signing_key"
+
+	authCodeStateSigningKeySize = 32
+)
+
+type AuthCodeStateKeyer interface {
+	// AuthCodeStateKey returns the storage key for storing AuthCodeStateEntry
+	// objects.
+	AuthCodeStateKey() string
+}
+
+// AuthCodeStateEntry is a pending authorization code flow that will be
+// completed when the provider redirects back to the plugin with a matching
+// state.
+type AuthCodeStateEntry struct {
+	// CredentialName is the name of the credential to write when the flow
+	// completes.
+	CredentialName string `json:"credential_name"`
+
+	// RedirectURL is the redirect URL used to generate the authorization code
+	// URL, which some providers require during the exchange.
+	RedirectURL string `json:"redirect_url,omitempty"`
+
+	// ProviderOptions are the credential provider options to use for the
+	// exchange.
+	ProviderOptions map[string]string `json:"provider_options,omitempty"`
+
+	// Resources are the RFC 8707 resource indicators requested in the
+	// authorization code URL.
+	Resources []string `json:"resources,omitempty"`
+
+	// Template is the name of the template to create the credential from, if
+	// any.
+	Template string `json:"template,omitempty"`
+
+	// EntityID is the ID of the Vault entity that requested the authorization
+	// code URL, if any. The credential counts toward the quota of this
+	// entity.
+	EntityID string `json:"entity_id,omitempty"`
+
+	// Reauthorize indicates that the flow replaces the token of an existing
+	// credential that must be authorized again. Otherwise, the flow may only
+	// create a new credential.
+	Reauthorize bool `json:"reauthorize,omitempty"`
+
+	// ExpireTime is the time after which this entry can no longer be used.
+	ExpireTime time.Time `json:"expire_time"`
+
+	// Signed indicates that the state was generated by the plugin and must
+	// have a valid signature.
+	Signed bool `json:"signed,omitempty"`
+}
+
+// Expired indicates whether this entry has expired as of the given time.
+func (ase *AuthCodeStateEntry) Expired(now time.Time) bool {
+	return !ase.ExpireTime.After(now)
+}
+
+// AuthCodeStateSigningKeyEntry holds the secret key used to sign generated
+// states.
+type AuthCodeStateSigningKeyEntry struct {
+	Key []byte `json:"key"`
+}
+
+type AuthCodeStateKey string
+
+var _ AuthCodeStateKeyer = AuthCodeStateKey("")
+
+func (ask AuthCodeStateKey) AuthCodeStateKey() string { return authCodeStateKeyPrefix + string(ask) }
+
+// AuthCodeStateName returns the keyer for the given state value. The state is
+// hashed so that it cannot be recovered by listing storage.
+func AuthCodeStateName(state string) AuthCodeStateKeyer {
+	hash := sha256.Sum256([]byte(state))
+	return AuthCodeStateKey(fmt.Sprintf("%x", hash))
+}
+
+type LockedAuthCodeStateManager struct {
+	storage logical.Storage
+	keyer   AuthCodeStateKeyer
+}
+
+func (lasm *LockedAuthCodeStateManager) ReadAuthCodeStateEntry(ctx context.Context) (*AuthCodeStateEntry, error) {
+	se, err := lasm.storage.Get(ctx, lasm.keyer.AuthCodeStateKey())
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return nil, nil
+	}
+
+	entry := &AuthCodeStateEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (lasm *LockedAuthCodeStateManager) WriteAuthCodeStateEntry(ctx context.Context, entry *AuthCodeStateEntry) error {
+	se, err := logical.StorageEntryJSON(lasm.keyer.AuthCodeStateKey(), entry)
+	if err != nil {
+		return err
+	}
+
+	return lasm.storage.Put(ctx, se)
+}
+
+func (lasm *LockedAuthCodeStateManager) DeleteAuthCodeStateEntry(ctx context.Context) error {
+	return lasm.storage.Delete(ctx, lasm.keyer.AuthCodeStateKey())
+}
+
+type AuthCodeStateManager struct {
+	storage logical.Storage
+	locks   []*locksutil.LockEntry
+}
+
+func (asm *AuthCodeStateManager) WithLock(keyer AuthCodeStateKeyer, fn func(*LockedAuthCodeStateManager) error) error {
+	lock := locksutil.LockForKey(asm.locks, keyer.AuthCodeStateKey())
+	lock.Lock()
+	defer lock.Unlock()
+
+	return fn(&LockedAuthCodeStateManager{
+		storage: asm.storage,
+		keyer:   keyer,
+	})
+}
+
+func (asm *AuthCodeStateManager) ReadAuthCodeStateEntry(ctx context.Context, keyer AuthCodeStateKeyer) (*AuthCodeStateEntry, error) {
+	var entry *AuthCodeStateEntry
+	err := asm.WithLock(keyer, func(lasm *LockedAuthCodeStateManager) (err error) {
+		entry, err = lasm.ReadAuthCodeStateEntry(ctx)
+		return
+	})
+	return entry, err
+}
+
+func (asm *AuthCodeStateManager) WriteAuthCodeStateEntry(ctx context.Context, keyer AuthCodeStateKeyer, entry *AuthCodeStateEntry) error {
+	return asm.WithLock(keyer, func(lasm *LockedAuthCodeStateManager) error {
+		return lasm.WriteAuthCodeStateEntry(ctx, entry)
+	})
+}
+
+func (asm *AuthCodeStateManager) DeleteAuthCodeStateEntry(ctx context.Context, keyer AuthCodeStateKeyer) error {
+	return asm.WithLock(keyer, func(lasm *LockedAuthCodeStateManager) error {
+		return lasm.DeleteAuthCodeStateEntry(ctx)
+	})
+}
+
+// ReadSigningKey returns the key used to sign generated states, or nil if it
+// has not been created. Unlike ReadOrCreateSigningKey, it never writes to
+// storage, so it can be used on nodes that cannot.
+func (asm *AuthCodeStateManager) ReadSigningKey(ctx context.Context) ([]byte, error) {
+	lock := locksutil.LockForKey(asm.locks, authCodeStateSigningKeyKey)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return asm.readSigningKey(ctx)
+}
+
+func (asm *AuthCodeStateManager) readSigningKey(ctx context.Context) ([]byte, error) {
+	se, err := asm.storage.Get(ctx, authCodeStateSigningKeyKey)
+	if err != nil || se == nil {
+		return nil, err
+	}
+
+	entry := &AuthCodeStateSigningKeyEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry.Key, nil
+}
+
+// ReadOrCreateSigningKey returns the key used to sign generated states,
+// creating it if it does not exist.
+func (asm *AuthCodeStateManager) ReadOrCreateSigningKey(ctx context.Context) ([]byte, error) {
+	lock := locksutil.LockForKey(asm.locks, authCodeStateSigningKeyKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if key, err := asm.readSigningKey(ctx); err != nil || key != nil {
+		return key, err
+	}
+
+	entry := &AuthCodeStateSigningKeyEntry{
+		Key: make([]byte, authCodeStateSigningKeySize),
+	}
+	if _, err := rand.Read(entry.Key); err != nil {
+		return nil, err
+	}
+
+	se, err := logical.StorageEntryJSON(authCodeStateSigningKeyKey, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := asm.storage.Put(ctx, se); err != nil {
+		return nil, err
+	}
+
+	return entry.Key, nil
+}
+
+func (asm *AuthCodeStateManager) ForEachAuthCodeStateKey(ctx context.Context, fn func(AuthCodeStateKeyer)) error {
+	view := logical.NewStorageView(asm.storage, authCodeStateKeyPrefix)
+	return logical.ScanView(ctx, view, func(path string) { fn(AuthCodeStateKey(path)) })
+}
+
+// ForEachAuthCodeStateKeyPage calls fn with batches of at most size state keys.
+// Storage is listed incrementally as pages are requested. Iteration stops at
+// the first error returned by fn.
+func (asm *AuthCodeStateManager) ForEachAuthCodeStateKeyPage(ctx context.Context, size int, fn func([]AuthCodeStateKeyer) error) error {
+	return forEachKeyPage(ctx, asm.storage, authCodeStateKeyPrefix, size, func(keys []string) error {
+		page := make([]AuthCodeStateKeyer, len(keys))
+		for i, key := range keys {
+			page[i] = AuthCodeStateKey(key)
+		}
+		return fn(page)
+	})
+}
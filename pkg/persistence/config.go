@@ -2,6 +2,8 @@ package persistence
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -36,17 +38,42 @@ func (cv ConfigVersion) SupportsTuningReaper() bool {
 	return cv >= ConfigVersion2
 }
 
+// ConfigVersionError is returned when the configuration was written by a newer
+// version of this plugin than the one currently running.
+type ConfigVersionError struct {
+	Version ConfigVersion
+}
+
+func (e *ConfigVersionError) Error() string {
+	return fmt.Sprintf("configuration version %d is newer than the latest version supported by this plugin (%d); upgrade the plugin to continue", e.Version, ConfigVersionLatest)
+}
+
 type ConfigTuningEntry struct {
 	ProviderTimeoutSeconds            int     `json:"provider_timeout_seconds"`
 	ProviderTimeoutExpiryLeewayFactor float64 `json:"provider_timeout_expiry_leeway_factor"`
 	RefreshCheckIntervalSeconds       int     `json:"refresh_check_interval_seconds"`
 	RefreshExpiryDeltaFactor          float64 `json:"refresh_expiry_delta_factor"`
+	RefreshBeforeExpirySeconds        int     `json:"refresh_before_expiry_seconds"`
+	ExpiryLeewaySeconds               int     `json:"expiry_leeway_seconds"`
 	ReapCheckIntervalSeconds          int     `json:"reap_check_interval_seconds"`
 	ReapDryRun                        bool    `json:"reap_dry_run"`
 	ReapNonRefreshableSeconds         int     `json:"reap_non_refreshable_seconds"`
 	ReapRevokedSeconds                int     `json:"reap_revoked_seconds"`
 	ReapTransientErrorAttempts        int     `json:"reap_transient_error_attempts"`
 	ReapTransientErrorSeconds         int     `json:"reap_transient_error_seconds"`
+	ReapQuarantineSeconds             int     `json:"reap_quarantine_seconds"`
+	ReapDeletedEntities               bool    `json:"reap_deleted_entities"`
+	IntrospectionCheckIntervalSeconds int     `json:"introspection_check_interval_seconds"`
+	IntrospectionSampleSize           int     `json:"introspection_sample_size"`
+	MaxCredentialVersions             int     `json:"max_credential_versions"`
+	MaxCredentialHistory              int     `json:"max_credential_history"`
+	MaxCredentials                    int     `json:"max_credentials"`
+	MaxCredentialsPerEntity           int     `json:"max_credentials_per_entity"`
+	StorageScanPageSize               int     `json:"storage_scan_page_size"`
+	StorageScanPagesPerSecond         float64 `json:"storage_scan_pages_per_second"`
+	CircuitBreakerFailures            int     `json:"circuit_breaker_failures"`
+	CircuitBreakerCoolDownSeconds     int     `json:"circuit_breaker_cool_down_seconds"`
+	UserInfoCacheSeconds              int     `json:"user_info_cache_seconds"`
 }
 
 var DefaultConfigTuningEntry = ConfigTuningEntry{
@@ -54,12 +81,27 @@ var DefaultConfigTuningEntry = ConfigTuningEntry{
 	ProviderTimeoutExpiryLeewayFactor: 1.5,
 	RefreshCheckIntervalSeconds:       60,
 	RefreshExpiryDeltaFactor:          1.2,
+	RefreshBeforeExpirySeconds:        0,
+	ExpiryLeewaySeconds:               0,
 	ReapCheckIntervalSeconds:          300,
 	ReapDryRun:                        false,
 	ReapNonRefreshableSeconds:         86400,
 	ReapRevokedSeconds:                3600,
 	ReapTransientErrorAttempts:        10,
 	ReapTransientErrorSeconds:         86400,
+	ReapQuarantineSeconds:             0,
+	ReapDeletedEntities:               false,
+	IntrospectionCheckIntervalSeconds: 0,
+	IntrospectionSampleSize:           0,
+	MaxCredentialVersions:             0,
+	MaxCredentialHistory:              10,
+	MaxCredentials:                    0,
+	MaxCredentialsPerEntity:           0,
+	StorageScanPageSize:               500,
+	StorageScanPagesPerSecond:         20,
+	CircuitBreakerFailures:            0,
+	CircuitBreakerCoolDownSeconds:     60,
+	UserInfoCacheSeconds:              60,
 }
 
 type ConfigEntry struct {
@@ -71,6 +113,171 @@ type ConfigEntry struct {
 	ProviderVersion int               `json:"provider_version"`
 	ProviderOptions map[string]string `json:"provider_options"`
 	Tuning          ConfigTuningEntry `json:"tuning"`
+
+	// ClaimMetadata maps credential metadata fields to the names of the
+	// ID token or UserInfo claims to populate them from.
+	ClaimMetadata map[string]string `json:"claim_metadata,omitempty"`
+
+	// LeaseTokens causes access tokens to be returned as leased secrets.
+	LeaseTokens bool `json:"lease_tokens,omitempty"`
+
+	// TokenTTLSeconds is the TTL of leases for access tokens. If zero, leases
+	// last until the access token expires.
+	TokenTTLSeconds int `json:"token_ttl_seconds,omitempty"`
+
+	// DefaultTokenLifetimeSeconds is the lifetime to assume for access tokens
+	// issued without an expiry. If zero, such tokens never expire.
+	DefaultTokenLifetimeSeconds int `json:"default_token_lifetime_seconds,omitempty"`
+
+	// AllowPasswordGrant permits credentials to be issued using the resource
+	// owner password credentials grant.
+	AllowPasswordGrant bool `json:"allow_password_grant,omitempty"`
+
+	// AllowedGrantTypes restricts the grant types credentials may be issued
+	// with. If empty, all grant types are allowed.
+	AllowedGrantTypes []string `json:"allowed_grant_types,omitempty"`
+
+	// AllowedRedirectURLs restricts the redirect URLs authorization codes may
+	// be requested for and exchanged with. If empty, any redirect URL is
+	// allowed.
+	AllowedRedirectURLs []string `json:"allowed_redirect_urls,omitempty"`
+
+	// AllowedScopes restricts the scopes credentials may request. If empty,
+	// any scope that is not denied is allowed.
+	AllowedScopes []string `json:"allowed_scopes,omitempty"`
+
+	// DeniedScopes are scopes credentials may never request. They take
+	// precedence over AllowedScopes.
+	DeniedScopes []string `json:"denied_scopes,omitempty"`
+
+	// AllowedReadProviderOptions are the provider options that may be given
+	// when reading a credential. If empty, provider options can't be given
+	// when reading credentials.
+	AllowedReadProviderOptions []string `json:"allowed_read_provider_options,omitempty"`
+
+	// RequestObjectSigningKey is a PEM-encoded private key. If set, the
+	// parameters of authorization code URLs are sent in a request object
+	// signed with it instead of in the query string.
+	RequestObjectSigningKey       string `json:"request_object_signing_key,omitempty"`
+	RequestObjectSigningKeyID     string `json:"request_object_signing_key_id,omitempty"`
+	RequestObjectSigningAlgorithm string `json:"request_object_signing_algorithm,omitempty"`
+
+	// RequestObjectAudience is the audience of signed request objects,
+	// usually the issuer identifier of the authorization server.
+	RequestObjectAudience string `json:"request_object_audience,omitempty"`
+
+	// JARMJWKSURL is the URL of the key set used to verify JWT-secured
+	// authorization responses. If empty, they are not accepted.
+	JARMJWKSURL string `json:"jarm_jwks_url,omitempty"`
+
+	// JARMIssuer is the issuer of JWT-secured authorization responses.
+	JARMIssuer string `json:"jarm_issuer,omitempty"`
+
+	// DecodeJWTAccessTokens causes access tokens that are JWTs to be decoded
+	// so that their claims can be reported and used to determine their
+	// expiry.
+	DecodeJWTAccessTokens bool `json:"decode_jwt_access_tokens,omitempty"`
+
+	// JWTAccessTokenJWKSURL is the URL of the key set used to verify JWT
+	// access tokens before their claims are used. If empty, the claims are
+	// used without verifying the signature.
+	JWTAccessTokenJWKSURL string `json:"jwt_access_token_jwks_url,omitempty"`
+
+	// ReauthorizationWebhookURL receives a notification when a credential
+	// must be authorized again.
+	ReauthorizationWebhookURL string `json:"reauthorization_webhook_url,omitempty"`
+
+	// MaintenanceMode pauses all requests to the provider. Tokens that are
+	// still valid continue to be served from storage.
+	MaintenanceMode bool `json:"maintenance_mode,omitempty"`
+
+	// RedactTokens causes read responses to include digests of tokens instead
+	// of the tokens themselves unless they are explicitly requested.
+	RedactTokens bool `json:"redact_tokens,omitempty"`
+
+	// TracingOTLPEndpoint is the URL of an OTLP/HTTP collector to export
+	// spans for provider operations to.
+	TracingOTLPEndpoint string `json:"tracing_otlp_endpoint,omitempty"`
+
+	// EventLogFile is the name of a file in the event log directory of the
+	// plugin to append credential lifecycle events to, one JSON object per
+	// line.
+	EventLogFile string `json:"event_log_file,omitempty"`
+
+	// EventLogSyslog causes credential lifecycle events to be sent to the
+	// local syslog daemon.
+	EventLogSyslog bool `json:"event_log_syslog,omitempty"`
+
+	// PreviousClientSecret is the client secret replaced by the most recent
+	// rotation. Until PreviousClientSecretExpireTime, refreshes that the
+	// provider rejects with the current secret are retried with it.
+	PreviousClientSecret           string    `json:"previous_client_secret,omitempty"`
+	PreviousClientSecretExpireTime time.Time `json:"previous_client_secret_expire_time,omitempty"`
+}
+
+// PreviousClientSecretValid returns true if the previous client secret may
+// still be used at the given time.
+func (ce *ConfigEntry) PreviousClientSecretValid(now time.Time) bool {
+	return ce.PreviousClientSecret != "" && now.Before(ce.PreviousClientSecretExpireTime)
+}
+
+// GrantTypeAllowed returns true if credentials may be issued using the given
+// grant type.
+func (ce *ConfigEntry) GrantTypeAllowed(grantType string) bool {
+	if len(ce.AllowedGrantTypes) == 0 {
+		return true
+	}
+
+	for _, allowed := range ce.AllowedGrantTypes {
+		if allowed == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// RedirectURLAllowed returns true if authorization codes may be requested for
+// and exchanged with the given redirect URL. Redirect URLs must match an
+// allowed URL exactly. Not specifying a redirect URL is always allowed.
+func (ce *ConfigEntry) RedirectURLAllowed(redirectURL string) bool {
+	if len(ce.AllowedRedirectURLs) == 0 || redirectURL == "" {
+		return true
+	}
+
+	for _, allowed := range ce.AllowedRedirectURLs {
+		if allowed == redirectURL {
+			return true
+		}
+	}
+	return false
+}
+
+// DisallowedScopes returns the given scopes that credentials may not request
+// according to the scope policy of this configuration.
+func (ce *ConfigEntry) DisallowedScopes(scopes []string) []string {
+	var disallowed []string
+
+	for _, scope := range scopes {
+		if containsString(ce.DeniedScopes, scope) || (len(ce.AllowedScopes) > 0 && !containsString(ce.AllowedScopes, scope)) {
+			disallowed = append(disallowed, scope)
+		}
+	}
+	return disallowed
+}
+
+// ReadProviderOptionAllowed indicates whether the given provider option may
+// be given when reading a credential.
+func (ce *ConfigEntry) ReadProviderOptionAllowed(name string) bool {
+	return containsString(ce.AllowedReadProviderOptions, name)
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, candidate := range haystack {
+		if candidate == needle {
+			return true
+		}
+	}
+	return false
 }
 
 type LockedConfigManager struct {
@@ -85,11 +292,17 @@ func (lcm *LockedConfigManager) ReadConfig(ctx context.Context) (*ConfigEntry, e
 		return nil, nil
 	}
 
-	entry := &ConfigEntry{}
+	// Tuning options added after the configuration was written take their
+	// default values.
+	entry := &ConfigEntry{Tuning: DefaultConfigTuningEntry}
 	if err := se.DecodeJSON(entry); err != nil {
 		return nil, err
 	}
 
+	if entry.Version > ConfigVersionLatest {
+		return nil, &ConfigVersionError{Version: entry.Version}
+	}
+
 	if !entry.Version.SupportsTuningRefresh() {
 		entry.Tuning.RefreshCheckIntervalSeconds = DefaultConfigTuningEntry.RefreshCheckIntervalSeconds
 	}
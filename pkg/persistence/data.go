@@ -1,13 +1,36 @@
 package persistence
 
 import (
+	"sync"
+
 	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
+// SealWrapStorage returns the storage keys and key prefixes that contain
+// secrets, such as refresh tokens, client secrets, and signing keys. Vault seal
+// wraps them if the seal supports it.
+func SealWrapStorage() []string {
+	return []string{
+		configKey,
+		authCodeKeyPrefix,
+		deviceAuthKeyPrefix,
+		authCodeExchangeKeyPrefix,
+		reapedAuthCodeKeyPrefix,
+		authCodeStateSigningKeyKey,
+		clientCredsKeyPrefix,
+		fingerprintKeyKey,
+		registrationKey,
+		revocationKeyPrefix,
+	}
+}
+
 type Managers struct {
-	storage logical.Storage
-	locks   []*locksutil.LockEntry
+	storage          logical.Storage
+	locks            []*locksutil.LockEntry
+	migrationLock    *sync.Mutex
+	authCodeObserver AuthCodeObserver
+	authCodeQuota    *authCodeQuota
 }
 
 func (m *Managers) Config() *ConfigManager {
@@ -17,8 +40,24 @@ func (m *Managers) Config() *ConfigManager {
 	}
 }
 
+func (m *Managers) AccessTokenLease() *AccessTokenLeaseManager {
+	return &AccessTokenLeaseManager{
+		storage: m.storage,
+		locks:   m.locks,
+	}
+}
+
 func (m *Managers) AuthCode() *AuthCodeManager {
 	return &AuthCodeManager{
+		storage:  m.storage,
+		locks:    m.locks,
+		observer: m.authCodeObserver,
+		quota:    m.authCodeQuota,
+	}
+}
+
+func (m *Managers) AuthCodeState() *AuthCodeStateManager {
+	return &AuthCodeStateManager{
 		storage: m.storage,
 		locks:   m.locks,
 	}
@@ -31,17 +70,66 @@ func (m *Managers) ClientCreds() *ClientCredsManager {
 	}
 }
 
+func (m *Managers) CredTemplate() *CredTemplateManager {
+	return &CredTemplateManager{
+		storage: m.storage,
+		locks:   m.locks,
+	}
+}
+
+func (m *Managers) Fingerprint() *FingerprintManager {
+	return &FingerprintManager{
+		storage: m.storage,
+		locks:   m.locks,
+	}
+}
+
+func (m *Managers) Registration() *RegistrationManager {
+	return &RegistrationManager{
+		storage: m.storage,
+		locks:   m.locks,
+	}
+}
+
+func (m *Managers) Revocation() *RevocationManager {
+	return &RevocationManager{
+		storage: m.storage,
+		locks:   m.locks,
+	}
+}
+
+func (m *Managers) Migration() *MigrationManager {
+	return &MigrationManager{
+		storage: m.storage,
+		locks:   m.locks,
+		lock:    m.migrationLock,
+	}
+}
+
 type Holder struct {
-	locks []*locksutil.LockEntry
+	locks            []*locksutil.LockEntry
+	migrationLock    sync.Mutex
+	authCodeObserver AuthCodeObserver
+	authCodeQuota    authCodeQuota
 }
 
 func (h *Holder) Managers(storage logical.Storage) *Managers {
 	return &Managers{
-		storage: storage,
-		locks:   h.locks,
+		storage:          storage,
+		locks:            h.locks,
+		migrationLock:    &h.migrationLock,
+		authCodeObserver: h.authCodeObserver,
+		authCodeQuota:    &h.authCodeQuota,
 	}
 }
 
+// ObserveAuthCode registers an observer to be notified of every credential
+// written or deleted through managers created by this holder. It must be
+// called before any managers are created.
+func (h *Holder) ObserveAuthCode(o AuthCodeObserver) {
+	h.authCodeObserver = o
+}
+
 func NewHolder() *Holder {
 	return &Holder{
 		locks: locksutil.CreateLocks(),
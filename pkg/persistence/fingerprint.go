@@ -0,0 +1,64 @@
+package persistence
+
+import (
+	"context"
+	"crypto/rand"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	fingerprintKeyKey = "fingerprint_key"
+
+	fingerprintKeySize = 32
+)
+
+// FingerprintKeyEntry holds the secret key used to compute token
+// fingerprints.
+type FingerprintKeyEntry struct {
+	Key []byte `json:"key"`
+}
+
+type FingerprintManager struct {
+	storage logical.Storage
+	locks   []*locksutil.LockEntry
+}
+
+// ReadOrCreateKey returns the key used to compute token fingerprints, creating
+// it if it does not exist.
+func (fm *FingerprintManager) ReadOrCreateKey(ctx context.Context) ([]byte, error) {
+	lock := locksutil.LockForKey(fm.locks, fingerprintKeyKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	se, err := fm.storage.Get(ctx, fingerprintKeyKey)
+	if err != nil {
+		return nil, err
+	} else if se != nil {
+		entry := &FingerprintKeyEntry{}
+		if err := se.DecodeJSON(entry); err != nil {
+			return nil, err
+		}
+
+		return entry.Key, nil
+	}
+
+	entry := &FingerprintKeyEntry{
+		Key: make([]byte, fingerprintKeySize),
+	}
+	if _, err := rand.Read(entry.Key); err != nil {
+		return nil, err
+	}
+
+	se, err = logical.StorageEntryJSON(fingerprintKeyKey, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fm.storage.Put(ctx, se); err != nil {
+		return nil, err
+	}
+
+	return entry.Key, nil
+}
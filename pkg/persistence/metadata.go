@@ -0,0 +1,89 @@
+package persistence
+
+import (
+	"strconv"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+)
+
+// claimSources are the fields of the extra data of a token that may hold
+// claims about its subject, in order of precedence. The OIDC provider only
+// populates them if they are requested using its extra_data_fields option.
+var claimSources = []string{"user_info", "id_token_claims"}
+
+// SetClaimMetadata replaces the metadata of this entry with its static
+// metadata and the values of the claims of its token named by the given
+// mapping of metadata fields to claim names. Claims that are not present or
+// that are not strings, numbers, or booleans are omitted.
+func (ace *AuthCodeEntry) SetClaimMetadata(mapping map[string]string) {
+	ace.Metadata = nil
+	for field, value := range ace.StaticMetadata {
+		if ace.Metadata == nil {
+			ace.Metadata = make(map[string]string, len(ace.StaticMetadata)+len(mapping))
+		}
+		ace.Metadata[field] = value
+	}
+
+	if len(mapping) == 0 || ace.Token == nil {
+		return
+	}
+
+	for field, claim := range mapping {
+		value, ok := tokenClaim(ace.Token, claim)
+		if !ok {
+			continue
+		}
+
+		if ace.Metadata == nil {
+			ace.Metadata = make(map[string]string, len(mapping))
+		}
+		ace.Metadata[field] = value
+	}
+}
+
+// ApplyTemplate records that this entry was created from the given template,
+// if any, and inherits its metadata.
+func (ace *AuthCodeEntry) ApplyTemplate(tmpl *CredTemplateEntry) {
+	if tmpl == nil {
+		return
+	}
+
+	ace.Template = tmpl.Name
+	ace.StaticMetadata = make(map[string]string, len(tmpl.Metadata))
+	for field, value := range tmpl.Metadata {
+		ace.StaticMetadata[field] = value
+	}
+}
+
+// MatchesMetadata returns true if every field in the given filter has the
+// same value in the metadata of this entry.
+func (ace *AuthCodeEntry) MatchesMetadata(filter map[string]string) bool {
+	for field, value := range filter {
+		if actual, found := ace.Metadata[field]; !found || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenClaim returns the value of the named claim from the extra data of the
+// given token formatted as a string.
+func tokenClaim(tok *provider.Token, claim string) (string, bool) {
+	for _, source := range claimSources {
+		claims, ok := tok.ExtraData[source].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		switch v := claims[claim].(type) {
+		case string:
+			return v, true
+		case bool:
+			return strconv.FormatBool(v), true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		}
+	}
+
+	return "", false
+}
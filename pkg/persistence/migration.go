@@ -0,0 +1,290 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+)
+
+const (
+	migrationKey = "migration"
+
+	// migrationProgressInterval is the number of processed items between
+	// persisted progress updates.
+	migrationProgressInterval = 100
+)
+
+type StorageVersion int
+
+const (
+	StorageVersionInitial StorageVersion = iota
+	StorageVersion1
+	StorageVersionLatest = StorageVersion1
+)
+
+// StorageVersionError is returned when the storage schema was written by a
+// newer version of this plugin than the one currently running.
+type StorageVersionError struct {
+	// Version is the storage schema version that has been fully applied.
+	Version StorageVersion
+
+	// TargetVersion is the storage schema version of an incomplete migration
+	// started by a newer version of this plugin, if any.
+	TargetVersion StorageVersion
+}
+
+func (e *StorageVersionError) Error() string {
+	if e.Version <= StorageVersionLatest {
+		return fmt.Sprintf("storage schema version %d has a migration in progress to version %d, which is newer than the latest version supported by this plugin (%d); upgrade the plugin to continue", e.Version, e.TargetVersion, StorageVersionLatest)
+	}
+
+	return fmt.Sprintf("storage schema version %d is newer than the latest version supported by this plugin (%d); upgrade the plugin to continue", e.Version, StorageVersionLatest)
+}
+
+type MigrationEntry struct {
+	// Version is the storage schema version that has been fully applied.
+	Version StorageVersion `json:"version"`
+
+	// TargetVersion is the storage schema version requested by the most
+	// recent migration.
+	TargetVersion StorageVersion `json:"target_version,omitempty"`
+
+	// Processed is the number of items processed by the most recent
+	// migration.
+	Processed int `json:"processed,omitempty"`
+
+	// StartedTime is the time the most recent migration started.
+	StartedTime time.Time `json:"started_time,omitempty"`
+
+	// CompletedTime is the time the most recent migration completed
+	// successfully.
+	CompletedTime time.Time `json:"completed_time,omitempty"`
+
+	// LastError is the error that caused the most recent migration to stop, if
+	// any.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// InProgress indicates whether a migration has been started but not
+// completed, either because it is still running or because it failed.
+func (me *MigrationEntry) InProgress() bool {
+	return me.TargetVersion > me.Version
+}
+
+// Pending indicates whether the storage schema can be upgraded.
+func (me *MigrationEntry) Pending() bool {
+	return me.Version < StorageVersionLatest
+}
+
+// Supported returns an error if the storage schema is too new for this plugin,
+// including when a newer plugin started a migration that did not complete.
+func (me *MigrationEntry) Supported() error {
+	if me.Version > StorageVersionLatest || me.TargetVersion > StorageVersionLatest {
+		return &StorageVersionError{
+			Version:       me.Version,
+			TargetVersion: me.TargetVersion,
+		}
+	}
+
+	return nil
+}
+
+type migrationStep struct {
+	Description string
+	Run         func(ctx context.Context, m *Managers, progress func() error) error
+}
+
+// migrationSteps contains the procedure to upgrade storage from the version
+// at each index to the next version.
+var migrationSteps = []migrationStep{
+	StorageVersionInitial: {
+		Description: "upgrade configuration to the latest version and assign versions to existing credentials",
+		Run:         migrateToStorageVersion1,
+	},
+}
+
+func migrateToStorageVersion1(ctx context.Context, m *Managers, progress func() error) error {
+	pageSize := DefaultConfigTuningEntry.StorageScanPageSize
+
+	err := m.Config().WithLock(func(lcm *LockedConfigManager) error {
+		// Reading the configuration applies the defaults for older versions,
+		// so we just need to write it back.
+		entry, err := lcm.ReadConfig(ctx)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		if entry.Tuning.StorageScanPageSize > 0 {
+			pageSize = entry.Tuning.StorageScanPageSize
+		}
+
+		if entry.Version == ConfigVersionLatest {
+			return nil
+		}
+
+		entry.Version = ConfigVersionLatest
+		return lcm.WriteConfig(ctx, entry)
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.AuthCode().ForEachAuthCodeKeyPage(ctx, pageSize, func(page []AuthCodeKeyer) error {
+		for _, keyer := range page {
+			err := m.AuthCode().WithLock(keyer, func(lacm *LockedAuthCodeManager) error {
+				entry, err := lacm.ReadAuthCodeEntry(ctx)
+				if err != nil || entry == nil || entry.Version > 0 {
+					return err
+				}
+
+				entry.Version = 1
+				return lacm.WriteAuthCodeEntry(ctx, entry)
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := progress(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+type LockedMigrationManager struct {
+	storage logical.Storage
+	locks   []*locksutil.LockEntry
+}
+
+func (lmm *LockedMigrationManager) ReadMigrationEntry(ctx context.Context) (*MigrationEntry, error) {
+	se, err := lmm.storage.Get(ctx, migrationKey)
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return &MigrationEntry{Version: StorageVersionInitial}, nil
+	}
+
+	entry := &MigrationEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (lmm *LockedMigrationManager) WriteMigrationEntry(ctx context.Context, entry *MigrationEntry) error {
+	se, err := logical.StorageEntryJSON(migrationKey, entry)
+	if err != nil {
+		return err
+	}
+
+	return lmm.storage.Put(ctx, se)
+}
+
+// Migrate upgrades the storage schema to the latest version. Progress is
+// persisted as the migration runs, so it can be observed using
+// ReadMigrationEntry and an interrupted migration is resumed by calling
+// Migrate again.
+func (lmm *LockedMigrationManager) Migrate(ctx context.Context) (*MigrationEntry, error) {
+	entry, err := lmm.ReadMigrationEntry(ctx)
+	if err != nil {
+		return nil, err
+	} else if err := entry.Supported(); err != nil {
+		return nil, err
+	} else if !entry.Pending() {
+		return entry, nil
+	}
+
+	entry.TargetVersion = StorageVersionLatest
+	entry.Processed = 0
+	entry.StartedTime = clockctx.Clock(ctx).Now()
+	entry.CompletedTime = time.Time{}
+	entry.LastError = ""
+	if err := lmm.WriteMigrationEntry(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	m := &Managers{
+		storage: lmm.storage,
+		locks:   lmm.locks,
+	}
+
+	progress := func() error {
+		entry.Processed++
+		if entry.Processed%migrationProgressInterval != 0 {
+			return nil
+		}
+
+		return lmm.WriteMigrationEntry(ctx, entry)
+	}
+
+	for entry.Version < entry.TargetVersion {
+		step := migrationSteps[entry.Version]
+		if err := step.Run(ctx, m, progress); err != nil {
+			entry.LastError = fmt.Sprintf("migration to version %d (%s) failed: %+v", entry.Version+1, step.Description, err)
+			if werr := lmm.WriteMigrationEntry(ctx, entry); werr != nil {
+				return nil, werr
+			}
+
+			return nil, err
+		}
+
+		entry.Version++
+		if err := lmm.WriteMigrationEntry(ctx, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	entry.CompletedTime = clockctx.Clock(ctx).Now()
+	if err := lmm.WriteMigrationEntry(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+type MigrationManager struct {
+	storage logical.Storage
+	locks   []*locksutil.LockEntry
+
+	// lock is separate from the other locks because a migration acquires
+	// them while it runs.
+	lock *sync.Mutex
+}
+
+func (mm *MigrationManager) WithLock(fn func(*LockedMigrationManager) error) error {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	return fn(&LockedMigrationManager{
+		storage: mm.storage,
+		locks:   mm.locks,
+	})
+}
+
+// ReadMigrationEntry reads the current migration state. Unlike the other
+// managers, it does not acquire the lock, so that the progress of a running
+// migration can be observed.
+func (mm *MigrationManager) ReadMigrationEntry(ctx context.Context) (*MigrationEntry, error) {
+	return (&LockedMigrationManager{storage: mm.storage}).ReadMigrationEntry(ctx)
+}
+
+func (mm *MigrationManager) Migrate(ctx context.Context) (*MigrationEntry, error) {
+	var entry *MigrationEntry
+	err := mm.WithLock(func(lmm *LockedMigrationManager) (err error) {
+		entry, err = lmm.Migrate(ctx)
+		return
+	})
+	return entry, err
+}
+
+func IsMigrationKey(key string) bool {
+	return key == migrationKey
+}
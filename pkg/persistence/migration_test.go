@@ -0,0 +1,113 @@
+package persistence_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	testclock "k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestMigrateToStorageVersion1(t *testing.T) {
+	clk := testclock.NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := clockctx.WithClock(context.Background(), k8sext.NewClock(clk))
+	m := persistence.NewHolder().Managers(&logical.InmemStorage{})
+
+	// Use a page size that does not divide the number of credentials evenly.
+	require.NoError(t, m.Config().WriteConfig(ctx, &persistence.ConfigEntry{
+		Version: persistence.ConfigVersionInitial,
+		Tuning: persistence.ConfigTuningEntry{
+			StorageScanPageSize: 40,
+		},
+	}))
+
+	for i := 0; i < 150; i++ {
+		require.NoError(t, m.AuthCode().WriteAuthCodeEntry(ctx, persistence.AuthCodeName(fmt.Sprintf("test%d", i)), &persistence.AuthCodeEntry{
+			Token: &provider.Token{Token: &oauth2.Token{AccessToken: "abcd"}},
+		}))
+	}
+
+	entry, err := m.Migration().ReadMigrationEntry(ctx)
+	require.NoError(t, err)
+	require.Equal(t, persistence.StorageVersionInitial, entry.Version)
+	require.True(t, entry.Pending())
+
+	entry, err = m.Migration().Migrate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, persistence.StorageVersionLatest, entry.Version)
+	require.False(t, entry.Pending())
+	require.False(t, entry.InProgress())
+	require.Equal(t, 150, entry.Processed)
+	require.Equal(t, clk.Now(), entry.StartedTime)
+	require.Equal(t, clk.Now(), entry.CompletedTime)
+
+	cfg, err := m.Config().ReadConfig(ctx)
+	require.NoError(t, err)
+	require.Equal(t, persistence.ConfigVersionLatest, cfg.Version)
+	require.Equal(t, persistence.DefaultConfigTuningEntry.RefreshCheckIntervalSeconds, cfg.Tuning.RefreshCheckIntervalSeconds)
+	require.Equal(t, 0, cfg.Tuning.ReapCheckIntervalSeconds)
+
+	ace, err := m.AuthCode().ReadAuthCodeEntry(ctx, persistence.AuthCodeName("test0"))
+	require.NoError(t, err)
+	require.Equal(t, 1, ace.Version)
+
+	// Migrating again is a no-op.
+	entry, err = m.Migration().Migrate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, persistence.StorageVersionLatest, entry.Version)
+	require.Equal(t, 150, entry.Processed)
+}
+
+func TestMigrateDowngradeGuard(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	m := persistence.NewHolder().Managers(storage)
+
+	se, err := logical.StorageEntryJSON("migration", &persistence.MigrationEntry{
+		Version: persistence.StorageVersionLatest + 1,
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, se))
+
+	entry, err := m.Migration().ReadMigrationEntry(ctx)
+	require.NoError(t, err)
+
+	var sve *persistence.StorageVersionError
+	require.True(t, errors.As(entry.Supported(), &sve))
+	require.Equal(t, persistence.StorageVersionLatest+1, sve.Version)
+
+	_, err = m.Migration().Migrate(ctx)
+	require.True(t, errors.As(err, &sve))
+
+	// An incomplete migration started by a newer plugin reports the version
+	// that was actually stored.
+	se, err = logical.StorageEntryJSON("migration", &persistence.MigrationEntry{
+		Version:       persistence.StorageVersionLatest,
+		TargetVersion: persistence.StorageVersionLatest + 1,
+	})
+	require.NoError(t, err)
+	require.NoError(t, storage.Put(ctx, se))
+
+	_, err = m.Migration().Migrate(ctx)
+	require.True(t, errors.As(err, &sve))
+	require.Equal(t, persistence.StorageVersionLatest, sve.Version)
+	require.Equal(t, persistence.StorageVersionLatest+1, sve.TargetVersion)
+	require.Contains(t, err.Error(), "migration in progress")
+
+	require.NoError(t, m.Config().WriteConfig(ctx, &persistence.ConfigEntry{
+		Version: persistence.ConfigVersionLatest + 1,
+	}))
+
+	var cve *persistence.ConfigVersionError
+	_, err = m.Config().ReadConfig(ctx)
+	require.True(t, errors.As(err, &cve))
+}
@@ -0,0 +1,89 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	registrationKey = "registration"
+)
+
+// RegistrationEntry records a client registered using dynamic client
+// registration so that it can be managed later.
+type RegistrationEntry struct {
+	RegistrationEndpoint    string                 `json:"registration_endpoint"`
+	RegistrationClientURI   string                 `json:"registration_client_uri,omitempty"`
+	RegistrationAccessToken string                 `json:"registration_access_token,omitempty"`
+	ClientID                string                 `json:"client_id"`
+	ClientSecretExpireTime  time.Time              `json:"client_secret_expire_time,omitempty"`
+	Metadata                map[string]interface{} `json:"metadata,omitempty"`
+	RegisterTime            time.Time              `json:"register_time"`
+	UpdateTime              time.Time              `json:"update_time,omitempty"`
+}
+
+// Manageable returns true if the provider supports updating or deleting the
+// registration.
+func (re *RegistrationEntry) Manageable() bool {
+	return re.RegistrationClientURI != "" && re.RegistrationAccessToken != ""
+}
+
+type LockedRegistrationManager struct {
+	storage logical.Storage
+}
+
+func (lrm *LockedRegistrationManager) ReadRegistrationEntry(ctx context.Context) (*RegistrationEntry, error) {
+	se, err := lrm.storage.Get(ctx, registrationKey)
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return nil, nil
+	}
+
+	entry := &RegistrationEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (lrm *LockedRegistrationManager) WriteRegistrationEntry(ctx context.Context, entry *RegistrationEntry) error {
+	se, err := logical.StorageEntryJSON(registrationKey, entry)
+	if err != nil {
+		return err
+	}
+
+	return lrm.storage.Put(ctx, se)
+}
+
+func (lrm *LockedRegistrationManager) DeleteRegistrationEntry(ctx context.Context) error {
+	return lrm.storage.Delete(ctx, registrationKey)
+}
+
+type RegistrationManager struct {
+	storage logical.Storage
+	locks   []*locksutil.LockEntry
+}
+
+func (rm *RegistrationManager) WithLock(fn func(*LockedRegistrationManager) error) error {
+	lock := locksutil.LockForKey(rm.locks, registrationKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return fn(&LockedRegistrationManager{
+		storage: rm.storage,
+	})
+}
+
+func (rm *RegistrationManager) ReadRegistrationEntry(ctx context.Context) (*RegistrationEntry, error) {
+	var entry *RegistrationEntry
+	err := rm.WithLock(func(lrm *LockedRegistrationManager) (err error) {
+		entry, err = lrm.ReadRegistrationEntry(ctx)
+		return
+	})
+	return entry, err
+}
@@ -0,0 +1,141 @@
+package persistence
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	revocationKeyPrefix = "revocations/"
+)
+
+type RevocationKeyer interface {
+	// RevocationKey returns the storage key for storing RevocationEntry
+	// objects.
+	RevocationKey() string
+}
+
+// RevocationEntry is an access token that must be revoked at the provider
+// once its permitted lifetime ends.
+type RevocationEntry struct {
+	// CredentialName is the name of the credential the token was issued for.
+	CredentialName string `json:"credential_name"`
+
+	// AccessToken is the token to revoke.
+	AccessToken string `json:"access_token"`
+
+	// ProviderOptions are the provider options the token was issued with.
+	ProviderOptions map[string]string `json:"provider_options,omitempty"`
+
+	// RevokeTime is the time at which the token must be revoked.
+	RevokeTime time.Time `json:"revoke_time"`
+
+	// ExpireTime is the time the provider will expire the token on its own,
+	// if known. The token no longer needs to be revoked after this time.
+	ExpireTime time.Time `json:"expire_time,omitempty"`
+
+	// LastError is the error from the most recent failed attempt to revoke
+	// the token, if any.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Due indicates whether the token should be revoked as of the given time.
+func (re *RevocationEntry) Due(now time.Time) bool {
+	return !re.RevokeTime.After(now)
+}
+
+type RevocationKey string
+
+var _ RevocationKeyer = RevocationKey("")
+
+func (rk RevocationKey) RevocationKey() string { return revocationKeyPrefix + string(rk) }
+
+// RevocationName returns the keyer for the given access token. The token is
+// hashed so that it cannot be recovered by listing storage.
+func RevocationName(accessToken string) RevocationKeyer {
+	hash := sha256.Sum256([]byte(accessToken))
+	return RevocationKey(fmt.Sprintf("%x", hash))
+}
+
+type LockedRevocationManager struct {
+	storage logical.Storage
+	keyer   RevocationKeyer
+}
+
+func (lrm *LockedRevocationManager) ReadRevocationEntry(ctx context.Context) (*RevocationEntry, error) {
+	se, err := lrm.storage.Get(ctx, lrm.keyer.RevocationKey())
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return nil, nil
+	}
+
+	entry := &RevocationEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (lrm *LockedRevocationManager) WriteRevocationEntry(ctx context.Context, entry *RevocationEntry) error {
+	se, err := logical.StorageEntryJSON(lrm.keyer.RevocationKey(), entry)
+	if err != nil {
+		return err
+	}
+
+	return lrm.storage.Put(ctx, se)
+}
+
+func (lrm *LockedRevocationManager) DeleteRevocationEntry(ctx context.Context) error {
+	return lrm.storage.Delete(ctx, lrm.keyer.RevocationKey())
+}
+
+type RevocationManager struct {
+	storage logical.Storage
+	locks   []*locksutil.LockEntry
+}
+
+func (rm *RevocationManager) WithLock(keyer RevocationKeyer, fn func(*LockedRevocationManager) error) error {
+	lock := locksutil.LockForKey(rm.locks, keyer.RevocationKey())
+	lock.Lock()
+	defer lock.Unlock()
+
+	return fn(&LockedRevocationManager{
+		storage: rm.storage,
+		keyer:   keyer,
+	})
+}
+
+func (rm *RevocationManager) ReadRevocationEntry(ctx context.Context, keyer RevocationKeyer) (*RevocationEntry, error) {
+	var entry *RevocationEntry
+	err := rm.WithLock(keyer, func(lrm *LockedRevocationManager) (err error) {
+		entry, err = lrm.ReadRevocationEntry(ctx)
+		return
+	})
+	return entry, err
+}
+
+func (rm *RevocationManager) WriteRevocationEntry(ctx context.Context, keyer RevocationKeyer, entry *RevocationEntry) error {
+	return rm.WithLock(keyer, func(lrm *LockedRevocationManager) error {
+		return lrm.WriteRevocationEntry(ctx, entry)
+	})
+}
+
+// ForEachRevocationKeyPage calls fn with batches of at most size revocation
+// keys. Storage is listed incrementally as pages are requested. Iteration stops
+// at the first error returned by fn.
+func (rm *RevocationManager) ForEachRevocationKeyPage(ctx context.Context, size int, fn func([]RevocationKeyer) error) error {
+	return forEachKeyPage(ctx, rm.storage, revocationKeyPrefix, size, func(keys []string) error {
+		page := make([]RevocationKeyer, len(keys))
+		for i, key := range keys {
+			page[i] = RevocationKey(key)
+		}
+		return fn(page)
+	})
+}
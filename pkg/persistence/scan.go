@@ -0,0 +1,109 @@
+package persistence
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+type keyScannerLevel struct {
+	dir      string
+	contents []string
+}
+
+// keyScanner iterates over the keys of a storage view in lexical order, one
+// directory listing at a time. Unlike logical.ScanView, it can be stopped after
+// any number of keys and resumed later, so it never holds more than the
+// listings of the directories along its current path in memory.
+type keyScanner struct {
+	view    logical.Storage
+	stack   []keyScannerLevel
+	started bool
+}
+
+func newKeyScanner(storage logical.Storage, prefix string) *keyScanner {
+	return &keyScanner{
+		view: logical.NewStorageView(storage, prefix),
+	}
+}
+
+func (ks *keyScanner) push(ctx context.Context, dir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	contents, err := ks.view.List(ctx, dir)
+	if err != nil {
+		return err
+	}
+	sort.Strings(contents)
+
+	ks.stack = append(ks.stack, keyScannerLevel{dir: dir, contents: contents})
+	return nil
+}
+
+// Next returns up to size keys following the keys previously returned. It
+// returns an empty slice when there are no more keys.
+func (ks *keyScanner) Next(ctx context.Context, size int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !ks.started {
+		ks.started = true
+		if err := ks.push(ctx, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	var keys []string
+	for len(keys) < size && len(ks.stack) > 0 {
+		top := &ks.stack[len(ks.stack)-1]
+		if len(top.contents) == 0 {
+			ks.stack = ks.stack[:len(ks.stack)-1]
+			continue
+		}
+
+		path := top.dir + top.contents[0]
+		top.contents = top.contents[1:]
+
+		if strings.HasSuffix(path, "/") {
+			if err := ks.push(ctx, path); err != nil {
+				return nil, err
+			}
+		} else {
+			keys = append(keys, path)
+		}
+	}
+	return keys, nil
+}
+
+// forEachKeyPage calls fn with successive pages of at most size keys under the
+// given prefix. Each page is listed only once the previous call to fn returns,
+// so fn can pace the scan. Iteration stops at the first error returned by fn
+// or when the context is canceled.
+func forEachKeyPage(ctx context.Context, storage logical.Storage, prefix string, size int, fn func([]string) error) error {
+	if size <= 0 {
+		size = 1
+	}
+
+	ks := newKeyScanner(storage, prefix)
+	for {
+		keys, err := ks.Next(ctx, size)
+		if err != nil {
+			return err
+		} else if len(keys) == 0 {
+			return nil
+		}
+
+		if err := fn(keys); err != nil {
+			return err
+		}
+
+		if len(keys) < size {
+			return nil
+		}
+	}
+}
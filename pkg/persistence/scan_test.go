@@ -0,0 +1,63 @@
+package persistence_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/persistence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEachAuthCodeKeyPage(t *testing.T) {
+	ctx := context.Background()
+	storage := &logical.InmemStorage{}
+	acm := persistence.NewHolder().Managers(storage).AuthCode()
+
+	expected := make(map[string]struct{})
+	for i := 0; i < 25; i++ {
+		keyer := persistence.AuthCodeName(fmt.Sprintf("test-%d", i))
+		require.NoError(t, acm.WriteAuthCodeEntry(ctx, keyer, &persistence.AuthCodeEntry{}))
+		expected[keyer.AuthCodeKey()] = struct{}{}
+	}
+
+	var sizes []int
+	var last string
+	seen := make(map[string]struct{})
+	require.NoError(t, acm.ForEachAuthCodeKeyPage(ctx, 10, func(page []persistence.AuthCodeKeyer) error {
+		sizes = append(sizes, len(page))
+		for _, keyer := range page {
+			key := keyer.AuthCodeKey()
+			assert.Greater(t, key, last, "keys must be returned in order")
+			last = key
+			seen[key] = struct{}{}
+		}
+		return nil
+	}))
+	assert.Equal(t, []int{10, 10, 5}, sizes)
+	assert.Equal(t, expected, seen)
+
+	// Iteration stops at the first error.
+	stop := errors.New("stop")
+	pages := 0
+	err := acm.ForEachAuthCodeKeyPage(ctx, 10, func(page []persistence.AuthCodeKeyer) error {
+		pages++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, pages)
+
+	// Iteration stops when the context is canceled.
+	cctx, cancel := context.WithCancel(ctx)
+	pages = 0
+	err = acm.ForEachAuthCodeKeyPage(cctx, 1, func(page []persistence.AuthCodeKeyer) error {
+		pages++
+		cancel()
+		return nil
+	})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 1, pages)
+}
@@ -0,0 +1,78 @@
+package persistence
+
+import (
+	"context"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	credTemplateKeyPrefix = "templates/"
+)
+
+// CredTemplateEntry holds settings that credentials created from it inherit
+// when they are authorized.
+type CredTemplateEntry struct {
+	Name            string            `json:"name"`
+	Scopes          []string          `json:"scopes,omitempty"`
+	RedirectURL     string            `json:"redirect_url,omitempty"`
+	ProviderOptions map[string]string `json:"provider_options,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+}
+
+type CredTemplateManager struct {
+	storage logical.Storage
+	locks   []*locksutil.LockEntry
+}
+
+func (ctm *CredTemplateManager) withLock(name string, fn func() error) error {
+	lock := locksutil.LockForKey(ctm.locks, credTemplateKeyPrefix+name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return fn()
+}
+
+func (ctm *CredTemplateManager) ReadCredTemplateEntry(ctx context.Context, name string) (*CredTemplateEntry, error) {
+	var entry *CredTemplateEntry
+	err := ctm.withLock(name, func() error {
+		se, err := ctm.storage.Get(ctx, credTemplateKeyPrefix+name)
+		if err != nil || se == nil {
+			return err
+		}
+
+		entry = &CredTemplateEntry{}
+		return se.DecodeJSON(entry)
+	})
+	return entry, err
+}
+
+func (ctm *CredTemplateManager) WriteCredTemplateEntry(ctx context.Context, entry *CredTemplateEntry) error {
+	return ctm.withLock(entry.Name, func() error {
+		se, err := logical.StorageEntryJSON(credTemplateKeyPrefix+entry.Name, entry)
+		if err != nil {
+			return err
+		}
+
+		return ctm.storage.Put(ctx, se)
+	})
+}
+
+func (ctm *CredTemplateManager) DeleteCredTemplateEntry(ctx context.Context, name string) error {
+	return ctm.withLock(name, func() error {
+		return ctm.storage.Delete(ctx, credTemplateKeyPrefix+name)
+	})
+}
+
+// ListCredTemplateNames returns the names of all templates in sorted order.
+func (ctm *CredTemplateManager) ListCredTemplateNames(ctx context.Context) ([]string, error) {
+	names, err := ctm.storage.List(ctx, credTemplateKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
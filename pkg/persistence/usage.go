@@ -0,0 +1,43 @@
+package persistence
+
+import (
+	"context"
+)
+
+// StorageUsage is the amount of storage used by a mount.
+type StorageUsage struct {
+	// Entries is the number of storage entries.
+	Entries int
+
+	// Bytes is the approximate size of the storage entries, taken as the sum
+	// of the lengths of their keys and values. It does not account for any
+	// overhead added by Vault's storage backend, such as encryption.
+	Bytes int64
+}
+
+// StorageUsage walks every storage entry of the mount, size keys at a time,
+// and reports how many there are and how large they are.
+func (m *Managers) StorageUsage(ctx context.Context, size int) (*StorageUsage, error) {
+	usage := &StorageUsage{}
+
+	err := forEachKeyPage(ctx, m.storage, "", size, func(keys []string) error {
+		for _, key := range keys {
+			entry, err := m.storage.Get(ctx, key)
+			if err != nil {
+				return err
+			} else if entry == nil {
+				// Deleted since it was listed.
+				continue
+			}
+
+			usage.Entries++
+			usage.Bytes += int64(len(key) + len(entry.Value))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
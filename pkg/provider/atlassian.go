@@ -0,0 +1,214 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/devicecode"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	GlobalRegistry.MustRegister("atlassian", AtlassianFactory,
+		WithSchema(AtlassianSchema),
+		WithVersion(1),
+		basicFlows,
+		WithTokenLifetime(time.Hour), // https://developer.atlassian.com/cloud/jira/platform/oauth-2-3lo-apps/
+	)
+}
+
+const (
+	atlassianAudience                = "api.atlassian.com"
+	atlassianAccessibleResourcesURL  = "https://api.atlassian.com/oauth/token/accessible-resources" // https://developer.atlassian.com/cloud/jira/platform/oauth-2-3lo-apps/#3-1-get-the-cloudid-for-your-site
+	atlassianExtraDataFieldResources = "accessible_resources"
+)
+
+// AtlassianSchema describes the options accepted by AtlassianFactory.
+var AtlassianSchema = OptionSchema{
+	"extra_data_fields": {
+		Type:        OptionTypeCommaStringList,
+		Description: "A comma-separated list of fields to expose in the credential endpoint.",
+		Enum:        []string{atlassianExtraDataFieldResources},
+	},
+}
+
+type atlassianOperations struct {
+	delegate        *basicOperations
+	extraDataFields []string
+}
+
+func (ao *atlassianOperations) updateAccessibleResources(ctx context.Context, t *Token) error {
+	for _, field := range ao.extraDataFields {
+		if field != atlassianExtraDataFieldResources {
+			continue
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, atlassianAccessibleResourcesURL, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("accept", "application/json")
+
+		resp, err := oauth2.NewClient(ctx, oauth2.StaticTokenSource(t.Token)).Do(req)
+		if err != nil {
+			return fmt.Errorf("atlassian: error fetching accessible resources: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("atlassian: unexpected status %d fetching accessible resources", resp.StatusCode)
+		}
+
+		// This is the same restriction as used by Go's OAuth2 package for
+		// consistency.
+		var resources []interface{}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&resources); err != nil {
+			return fmt.Errorf("atlassian: error decoding accessible resources: %w", err)
+		}
+
+		if t.ExtraData == nil {
+			t.ExtraData = make(map[string]interface{})
+		}
+		t.ExtraData[field] = resources
+		break
+	}
+
+	return nil
+}
+
+// carryAccessibleResources copies the extra data fields set by
+// updateAccessibleResources from the previous token to the new one.
+func (ao *atlassianOperations) carryAccessibleResources(prev, next *Token) {
+	for _, field := range ao.extraDataFields {
+		v, found := prev.ExtraData[field]
+		if !found {
+			continue
+		}
+
+		if next.ExtraData == nil {
+			next.ExtraData = make(map[string]interface{})
+		}
+		next.ExtraData[field] = v
+	}
+}
+
+func (ao *atlassianOperations) TokenURL() string {
+	return ao.delegate.TokenURL()
+}
+
+func (ao *atlassianOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool) {
+	// The audience is required, and the consent prompt is required for the
+	// provider to issue a refresh token.
+	opts = append([]AuthCodeURLOption{WithURLParams{"audience": atlassianAudience, "prompt": "consent"}}, opts...)
+	return ao.delegate.AuthCodeURL(state, opts...)
+}
+
+func (ao *atlassianOperations) DeviceCodeAuth(ctx context.Context, opts ...DeviceCodeAuthOption) (*devicecode.Auth, bool, error) {
+	return ao.delegate.DeviceCodeAuth(ctx, opts...)
+}
+
+func (ao *atlassianOperations) DeviceCodeExchange(ctx context.Context, deviceCode string, opts ...DeviceCodeExchangeOption) (*Token, error) {
+	return ao.delegate.DeviceCodeExchange(ctx, deviceCode, opts...)
+}
+
+func (ao *atlassianOperations) AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (*Token, error) {
+	t, err := ao.delegate.AuthCodeExchange(ctx, code, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ao.updateAccessibleResources(ctx, t); err != nil {
+		return nil, errmark.MarkUser(err)
+	}
+
+	return t, nil
+}
+
+func (ao *atlassianOperations) RefreshToken(ctx context.Context, t *Token, opts ...RefreshTokenOption) (*Token, error) {
+	nt, err := ao.delegate.RefreshToken(ctx, t, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The sites a user has granted access to can change at any time, so we
+	// look them up again with each new access token.
+	if err := ao.updateAccessibleResources(ctx, nt); err != nil {
+		// The provider has already rotated the refresh token, so returning
+		// an error here would lose the credential. Keep the resources we
+		// found previously and record the failure instead.
+		trace.SpanFromContext(ctx).RecordError(err)
+		ao.carryAccessibleResources(t, nt)
+	}
+
+	return nt, nil
+}
+
+func (ao *atlassianOperations) ClientCredentials(ctx context.Context, opts ...ClientCredentialsOption) (*Token, error) {
+	return ao.delegate.ClientCredentials(ctx, opts...)
+}
+
+type atlassian struct {
+	vsn             int
+	extraDataFields []string
+}
+
+func (a *atlassian) Version() int {
+	return a.vsn
+}
+
+func (a *atlassian) Public(clientID string) PublicOperations {
+	return a.Private(clientID, "")
+}
+
+func (a *atlassian) Private(clientID, clientSecret string) PrivateOperations {
+	return &atlassianOperations{
+		delegate: &basicOperations{
+			vsn: a.vsn,
+			endpointFactory: StaticEndpointFactory(Endpoint{
+				// https://developer.atlassian.com/cloud/jira/platform/oauth-2-3lo-apps/
+				Endpoint: oauth2.Endpoint{
+					AuthURL:   "https://auth.atlassian.com/authorize",
+					TokenURL:  "https://auth.atlassian.com/oauth/token",
+					AuthStyle: oauth2.AuthStyleInParams,
+				},
+			}),
+			clientID:     clientID,
+			clientSecret: clientSecret,
+		},
+		extraDataFields: a.extraDataFields,
+	}
+}
+
+func AtlassianFactory(ctx context.Context, vsn int, opts map[string]string) (Provider, error) {
+	vsn = selectVersion(vsn, 1)
+
+	switch vsn {
+	case 1:
+	default:
+		return nil, ErrNoProviderWithVersion
+	}
+
+	var fields []string
+	for _, field := range strings.Split(opts["extra_data_fields"], ",") {
+		switch field = strings.TrimSpace(field); field {
+		case atlassianExtraDataFieldResources:
+			fields = append(fields, field)
+		case "":
+		default:
+			return nil, &OptionError{Option: "extra_data_fields", Cause: fmt.Errorf("unknown field %q", field)}
+		}
+	}
+
+	p := &atlassian{
+		vsn:             vsn,
+		extraDataFields: fields,
+	}
+	return p, nil
+}
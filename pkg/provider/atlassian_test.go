@@ -0,0 +1,147 @@
+package provider_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestAtlassian(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Host + r.URL.Path {
+		case "auth.atlassian.com/oauth/token":
+			b, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			assert.Equal(t, "authorization_code", data.Get("grant_type"))
+			assert.Equal(t, "foo", data.Get("client_id"))
+			assert.Equal(t, "bar", data.Get("client_secret"))
+			assert.Equal(t, "123456", data.Get("code"))
+
+			w.Header().Set("content-type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  "abcd",
+				"token_type":    "Bearer",
+				"expires_in":    3600,
+				"refresh_token": "efgh",
+			})
+		case "api.atlassian.com/oauth/token/accessible-resources":
+			assert.Equal(t, "Bearer abcd", r.Header.Get("authorization"))
+
+			w.Header().Set("content-type", "application/json")
+			_ = json.NewEncoder(w).Encode([]interface{}{
+				map[string]interface{}{
+					"id":     "1324a887-45db-1bf4-1e99-ef0ff456d421",
+					"name":   "example",
+					"url":    "https://example.atlassian.net",
+					"scopes": []string{"read:jira-work"},
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	p, err := provider.GlobalRegistry.New(ctx, "atlassian", map[string]string{
+		"extra_data_fields": "accessible_resources",
+	})
+	require.NoError(t, err)
+
+	u, ok := p.Public("foo").AuthCodeURL("123456", provider.WithScopes{"read:jira-work", "offline_access"})
+	require.True(t, ok)
+
+	ur, err := url.Parse(u)
+	require.NoError(t, err)
+	assert.Equal(t, "auth.atlassian.com", ur.Host)
+	assert.Equal(t, "api.atlassian.com", ur.Query().Get("audience"))
+	assert.Equal(t, "consent", ur.Query().Get("prompt"))
+
+	token, err := p.Private("foo", "bar").AuthCodeExchange(ctx, "123456")
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.Equal(t, "abcd", token.AccessToken)
+	assert.Equal(t, "efgh", token.RefreshToken)
+
+	resources, ok := token.ExtraData["accessible_resources"].([]interface{})
+	require.True(t, ok, "unexpected extra data: %+v", token.ExtraData)
+	require.Len(t, resources, 1)
+	assert.Equal(t, "1324a887-45db-1bf4-1e99-ef0ff456d421", resources[0].(map[string]interface{})["id"])
+}
+
+func TestAtlassianRefreshKeepsTokenWhenResourcesFail(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Host + r.URL.Path {
+		case "auth.atlassian.com/oauth/token":
+			b, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			assert.Equal(t, "refresh_token", data.Get("grant_type"))
+			assert.Equal(t, "efgh", data.Get("refresh_token"))
+
+			w.Header().Set("content-type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  "ijkl",
+				"token_type":    "Bearer",
+				"expires_in":    3600,
+				"refresh_token": "mnop",
+			})
+		case "api.atlassian.com/oauth/token/accessible-resources":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	p, err := provider.GlobalRegistry.New(ctx, "atlassian", map[string]string{
+		"extra_data_fields": "accessible_resources",
+	})
+	require.NoError(t, err)
+
+	resources := []interface{}{
+		map[string]interface{}{"id": "1324a887-45db-1bf4-1e99-ef0ff456d421"},
+	}
+	old := &provider.Token{
+		Token: &oauth2.Token{
+			AccessToken:  "abcd",
+			RefreshToken: "efgh",
+		},
+		ExtraData: map[string]interface{}{
+			"accessible_resources": resources,
+		},
+	}
+
+	// The refresh token has been rotated, so the new token must be returned
+	// even though the accessible resources could not be looked up.
+	token, err := p.Private("foo", "bar").RefreshToken(ctx, old)
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.Equal(t, "ijkl", token.AccessToken)
+	assert.Equal(t, "mnop", token.RefreshToken)
+	assert.Equal(t, resources, token.ExtraData["accessible_resources"])
+}
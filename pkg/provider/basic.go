@@ -4,38 +4,166 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"strings"
+	"time"
 
 	gooidc "github.com/coreos/go-oidc"
 	"github.com/puppetlabs/leg/errmap/pkg/errmark"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/devicecode"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/introspection"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/revocation"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/semerr"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/bitbucket"
 	"golang.org/x/oauth2/clientcredentials"
 	"golang.org/x/oauth2/github"
-	"golang.org/x/oauth2/gitlab"
 	"golang.org/x/oauth2/microsoft"
 	"golang.org/x/oauth2/slack"
 )
 
 func init() {
-	GlobalRegistry.MustRegister("bitbucket", BasicFactory(Endpoint{Endpoint: bitbucket.Endpoint}))
+	GlobalRegistry.MustRegister("bitbucket", BasicFactory(Endpoint{Endpoint: bitbucket.Endpoint}),
+		WithSchema(BasicSchema),
+		WithVersion(1),
+		basicFlows,
+		WithTokenLifetime(2*time.Hour), // https://developer.atlassian.com/cloud/bitbucket/oauth-2/
+	)
+	GlobalRegistry.MustRegister("box", BasicFactory(Endpoint{
+		// https://developer.box.com/guides/authentication/oauth2/
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   "https://account.box.com/api/oauth2/authorize",
+			TokenURL:  "https://api.box.com/oauth2/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+	}), WithSchema(BasicSchema), WithVersion(1), basicFlows, WithTokenLifetime(time.Hour))
 	GlobalRegistry.MustRegister("github", BasicFactory(Endpoint{
 		Endpoint:  github.Endpoint,
 		DeviceURL: "https://github.com/login/device/code", // https://docs.github.com/en/developers/apps/authorizing-oauth-apps#device-flow
-	}))
-	GlobalRegistry.MustRegister("gitlab", BasicFactory(Endpoint{Endpoint: gitlab.Endpoint}))
-	GlobalRegistry.MustRegister("microsoft_azure_ad", AzureADFactory)
-	GlobalRegistry.MustRegister("slack", BasicFactory(Endpoint{Endpoint: slack.Endpoint}))
+	}), WithSchema(BasicSchema), WithVersion(1), deviceCodeFlows)
+	GlobalRegistry.MustRegister("microsoft_azure_ad", AzureADFactory,
+		WithSchema(AzureADSchema),
+		WithVersion(2),
+		deviceCodeFlows,
+		// Microsoft assigns a random lifetime between 60 and 90 minutes.
+		//
+		// https://learn.microsoft.com/en-us/azure/active-directory/develop/access-tokens#token-lifetime
+		WithTokenLifetime(time.Hour),
+	)
+	GlobalRegistry.MustRegister("slack", BasicFactory(Endpoint{Endpoint: slack.Endpoint}), WithSchema(BasicSchema), WithVersion(1), basicFlows)
+
+	// The device code flow is only available if the device_code_url option
+	// is specified.
+	GlobalRegistry.MustRegister("custom", CustomFactory, WithSchema(CustomSchema), WithVersion(2), deviceCodeFlows)
+}
+
+var (
+	// basicFlows are the flows supported by providers that only implement
+	// the token endpoint and authorization code URL.
+	basicFlows = WithFlows{FlowAuthCode, FlowClientCredentials, FlowTokenExchange}
+
+	// deviceCodeFlows are the flows supported by providers that also
+	// implement device authorization.
+	deviceCodeFlows = WithFlows{FlowAuthCode, FlowDeviceCode, FlowClientCredentials, FlowTokenExchange}
+)
 
-	GlobalRegistry.MustRegister("custom", CustomFactory)
+// BasicSchema describes the options accepted by BasicFactory, i.e., none.
+var BasicSchema = OptionSchema{}
+
+// AzureADSchema describes the options accepted by AzureADFactory.
+var AzureADSchema = OptionSchema{
+	"tenant": {
+		Type:        OptionTypeString,
+		Description: "The tenant to authenticate to. If not specified, each credential may select its own tenant.",
+	},
+}
+
+// CustomSchema describes the options accepted by CustomFactory.
+var CustomSchema = OptionSchema{
+	"auth_code_url": {
+		Type:        OptionTypeURL,
+		Description: "The URL to submit the initial authorization code request to.",
+	},
+	"device_code_url": {
+		Type:        OptionTypeURL,
+		Description: "The URL to submit a device authorization request to.",
+	},
+	"token_url": {
+		Type:        OptionTypeURL,
+		Description: "The URL to use for exchanging temporary codes and refreshing access tokens.",
+		Required:    true,
+	},
+	"token_exchange_url": {
+		Type:        OptionTypeURL,
+		Description: "The URL to use for exchanging assertions for tokens if it differs from the token URL.",
+	},
+	"revocation_url": {
+		Type:        OptionTypeURL,
+		Description: "The URL to submit requests to revoke access tokens to.",
+	},
+	"introspection_url": {
+		Type:        OptionTypeURL,
+		Description: "The URL to submit requests to check whether access tokens are active to.",
+	},
+	"auth_style": {
+		Type:        OptionTypeString,
+		Description: "How to authenticate to the token URL.",
+		Enum:        []string{"in_header", "in_params"},
+	},
+	"token_params": {
+		Type:        OptionTypeString,
+		Description: "Additional URL-encoded parameters to send with every token request.",
+	},
+	"token_response_path": {
+		Type:        OptionTypeString,
+		Description: "A dot-separated path to the object that contains the token if the token URL wraps its response.",
+	},
+	"expiry_field": {
+		Type:        OptionTypeString,
+		Description: "The name of the field in the token response that contains the token expiry if it is not expires_in.",
+	},
+	"expiry_type": {
+		Type:        OptionTypeString,
+		Description: "How to interpret the expiry_field option.",
+		Enum:        []string{string(ExpiryTypeRelative), string(ExpiryTypeAbsolute)},
+	},
+	"token_failover_urls": {
+		Type:        OptionTypeCommaStringList,
+		Description: "Alternate token URLs to try, in order, when the token URL fails to respond or responds with a server error.",
+	},
+	"token_failover_cooldown": {
+		Type:        OptionTypeDuration,
+		Description: "How long to prefer other token URLs after a request to one fails.",
+		Default:     defaultTokenEndpointFailoverCooldown.String(),
+	},
+	"token_failover_health_check_interval": {
+		Type:        OptionTypeDuration,
+		Description: "How often to check whether each token URL is available in the background. Set to 0 to disable.",
+		Default:     defaultTokenEndpointHealthCheckInterval.String(),
+	},
 }
 
 type basicOperations struct {
 	vsn             int
 	endpointFactory EndpointFactoryFunc
+	quirks          *tokenEndpointQuirks
+	failover        *tokenEndpointFailover
 	clientID        string
 	clientSecret    string
+
+	// omitExtraFields are nonstandard token response fields that should not
+	// be copied into the extra data of a token, usually because the provider
+	// handles them itself.
+	omitExtraFields []string
+}
+
+// tokenContext returns a context with an HTTP client for requests to the token
+// endpoint.
+func (bo *basicOperations) tokenContext(ctx context.Context) context.Context {
+	return bo.quirks.Context(bo.failover.Context(ctx))
+}
+
+func (bo *basicOperations) TokenURL() string {
+	return bo.endpointFactory(nil).TokenURL
 }
 
 func (bo *basicOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool) {
@@ -54,7 +182,7 @@ func (bo *basicOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption)
 		RedirectURL: o.RedirectURL,
 	}
 
-	return cfg.AuthCodeURL(state, o.AuthCodeOptions...), true
+	return withResourceParams(cfg.AuthCodeURL(state, o.AuthCodeOptions...), o.Resources), true
 }
 
 func (bo *basicOperations) DeviceCodeAuth(ctx context.Context, opts ...DeviceCodeAuthOption) (*devicecode.Auth, bool, error) {
@@ -80,6 +208,8 @@ func (bo *basicOperations) DeviceCodeAuth(ctx context.Context, opts ...DeviceCod
 }
 
 func (bo *basicOperations) DeviceCodeExchange(ctx context.Context, deviceCode string, opts ...DeviceCodeExchangeOption) (*Token, error) {
+	ctx = bo.tokenContext(ctx)
+
 	o := &DeviceCodeExchangeOptions{}
 	o.ApplyOptions(opts)
 
@@ -107,15 +237,20 @@ func (bo *basicOperations) DeviceCodeExchange(ctx context.Context, deviceCode st
 		return nil, err
 	}
 
-	return &Token{
+	t := &Token{
 		Token: tok,
 
 		ProviderVersion: bo.vsn,
 		ProviderOptions: o.ProviderOptions,
-	}, nil
+	}
+	updateTokenResponseExtraData(t, nil, bo.omitExtraFields)
+
+	return t, nil
 }
 
 func (bo *basicOperations) AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (*Token, error) {
+	ctx = bo.tokenContext(ctx)
+
 	o := &AuthCodeExchangeOptions{}
 	o.ApplyOptions(opts)
 
@@ -128,20 +263,27 @@ func (bo *basicOperations) AuthCodeExchange(ctx context.Context, code string, op
 		RedirectURL:  o.RedirectURL,
 	}
 
+	ctx = resourceContext(ctx, endpoint.TokenURL, o.Resources)
+
 	tok, err := cfg.Exchange(ctx, code, o.AuthCodeOptions...)
 	if err != nil {
 		return nil, semerr.Map(err)
 	}
 
-	return &Token{
+	t := &Token{
 		Token: tok,
 
 		ProviderVersion: bo.vsn,
 		ProviderOptions: o.ProviderOptions,
-	}, nil
+	}
+	updateTokenResponseExtraData(t, nil, bo.omitExtraFields)
+
+	return t, nil
 }
 
 func (bo *basicOperations) RefreshToken(ctx context.Context, t *Token, opts ...RefreshTokenOption) (*Token, error) {
+	ctx = bo.tokenContext(ctx)
+
 	o := &RefreshTokenOptions{}
 	WithProviderOptions(t.ProviderOptions).ApplyToRefreshTokenOptions(o)
 	o.ApplyOptions(opts)
@@ -154,6 +296,8 @@ func (bo *basicOperations) RefreshToken(ctx context.Context, t *Token, opts ...R
 		ClientSecret: bo.clientSecret,
 	}
 
+	ctx = resourceContext(ctx, endpoint.TokenURL, o.Resources)
+
 	tok, err := cfg.TokenSource(ctx, &oauth2.Token{
 		RefreshToken: t.RefreshToken,
 	}).Token()
@@ -161,27 +305,56 @@ func (bo *basicOperations) RefreshToken(ctx context.Context, t *Token, opts ...R
 		return nil, semerr.Map(err)
 	}
 
-	return &Token{
+	nt := &Token{
 		Token: tok,
 
 		ProviderVersion: bo.vsn,
 		ProviderOptions: o.ProviderOptions,
-	}, nil
+	}
+	updateTokenResponseExtraData(nt, t, bo.omitExtraFields)
+
+	return nt, nil
+}
+
+// assertionGrantTypes are the grant types sent to the token exchange URL, if
+// one is configured.
+var assertionGrantTypes = map[string]bool{
+	"urn:ietf:params:oauth:grant-type:saml2-bearer": true,
+	"urn:ietf:params:oauth:grant-type:jwt-bearer":   true,
 }
 
 func (bo *basicOperations) ClientCredentials(ctx context.Context, opts ...ClientCredentialsOption) (*Token, error) {
+	ctx = bo.tokenContext(ctx)
+
 	o := &ClientCredentialsOptions{}
 	o.ApplyOptions(opts)
 
 	endpoint := bo.endpointFactory(o.ProviderOptions)
 
+	// Assertion grants use this operation with a different grant type, and
+	// some servers accept them at a separate endpoint. Other grants that use
+	// this operation, like the password grant, always go to the token URL.
+	tokenURL := endpoint.TokenURL
+	if assertionGrantTypes[o.EndpointParams.Get("grant_type")] && endpoint.TokenExchangeURL != "" {
+		tokenURL = endpoint.TokenExchangeURL
+	}
+
+	params := o.EndpointParams
+	if len(o.Resources) > 0 {
+		params = make(url.Values, len(o.EndpointParams)+1)
+		for k, v := range o.EndpointParams {
+			params[k] = v
+		}
+		params["resource"] = o.Resources
+	}
+
 	cc := &clientcredentials.Config{
 		ClientID:       bo.clientID,
 		ClientSecret:   bo.clientSecret,
-		TokenURL:       endpoint.TokenURL,
+		TokenURL:       tokenURL,
 		AuthStyle:      endpoint.AuthStyle,
 		Scopes:         o.Scopes,
-		EndpointParams: o.EndpointParams,
+		EndpointParams: params,
 	}
 
 	tok, err := cc.Token(ctx)
@@ -189,17 +362,71 @@ func (bo *basicOperations) ClientCredentials(ctx context.Context, opts ...Client
 		return nil, semerr.Map(err)
 	}
 
-	return &Token{
+	t := &Token{
 		Token: tok,
 
 		ProviderVersion: bo.vsn,
 		ProviderOptions: o.ProviderOptions,
-	}, nil
+	}
+	updateTokenResponseExtraData(t, nil, bo.omitExtraFields)
+
+	return t, nil
+}
+
+func (bo *basicOperations) SupportsRevocation() bool {
+	return bo.endpointFactory(nil).RevocationURL != ""
+}
+
+func (bo *basicOperations) RevokeToken(ctx context.Context, t *Token) error {
+	endpoint := bo.endpointFactory(t.ProviderOptions)
+	if endpoint.RevocationURL == "" {
+		return fmt.Errorf("provider does not support token revocation")
+	}
+
+	cfg := &revocation.Config{
+		Config: &oauth2.Config{
+			Endpoint:     endpoint.Endpoint,
+			ClientID:     bo.clientID,
+			ClientSecret: bo.clientSecret,
+		},
+		RevocationURL: endpoint.RevocationURL,
+	}
+
+	return semerr.Map(cfg.Revoke(ctx, t.AccessToken, revocation.TokenTypeHintAccessToken))
+}
+
+func (bo *basicOperations) SupportsIntrospection() bool {
+	return bo.endpointFactory(nil).IntrospectionURL != ""
+}
+
+func (bo *basicOperations) IntrospectToken(ctx context.Context, t *Token) (bool, error) {
+	endpoint := bo.endpointFactory(t.ProviderOptions)
+	if endpoint.IntrospectionURL == "" {
+		return false, fmt.Errorf("provider does not support token introspection")
+	}
+
+	cfg := &introspection.Config{
+		Config: &oauth2.Config{
+			Endpoint:     endpoint.Endpoint,
+			ClientID:     bo.clientID,
+			ClientSecret: bo.clientSecret,
+		},
+		IntrospectionURL: endpoint.IntrospectionURL,
+	}
+
+	resp, err := cfg.Introspect(ctx, t.AccessToken, introspection.TokenTypeHintAccessToken)
+	if err != nil {
+		return false, semerr.Map(err)
+	}
+
+	return resp.Active, nil
 }
 
 type basic struct {
 	vsn             int
 	endpointFactory EndpointFactoryFunc
+	quirks          *tokenEndpointQuirks
+	failover        *tokenEndpointFailover
 }
 
 func (b *basic) Version() int {
@@ -214,6 +441,8 @@ func (b *basic) Private(clientID, clientSecret string) PrivateOperations {
 	return &basicOperations{
 		vsn:             b.vsn,
 		endpointFactory: b.endpointFactory,
+		quirks:          b.quirks,
+		failover:        b.failover,
 		clientID:        clientID,
 		clientSecret:    clientSecret,
 	}
@@ -322,12 +551,67 @@ func CustomFactory(ctx context.Context, vsn int, opts map[string]string) (Provid
 			TokenURL:  opts["token_url"],
 			AuthStyle: authStyle,
 		},
-		DeviceURL: opts["device_code_url"],
+		DeviceURL:        opts["device_code_url"],
+		TokenExchangeURL: opts["token_exchange_url"],
+		RevocationURL:    opts["revocation_url"],
+		IntrospectionURL: opts["introspection_url"],
+	}
+
+	quirks, err := parseCustomTokenEndpointQuirks(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	failover, err := parseCustomTokenEndpointFailover(opts)
+	if err != nil {
+		return nil, err
 	}
+	failover.Start(ctx)
 
 	p := &basic{
 		vsn:             vsn,
 		endpointFactory: StaticEndpointFactory(endpoint),
+		quirks:          quirks,
+		failover:        failover,
 	}
 	return p, nil
 }
+
+func parseCustomTokenEndpointQuirks(opts map[string]string) (*tokenEndpointQuirks, error) {
+	if opts["token_params"] == "" && opts["token_response_path"] == "" && opts["expiry_field"] == "" && opts["expiry_type"] == "" {
+		return nil, nil
+	}
+
+	quirks := &tokenEndpointQuirks{
+		TokenURL:    opts["token_url"],
+		ExpiryField: opts["expiry_field"],
+		ExpiryType:  ExpiryTypeRelative,
+	}
+
+	if params := opts["token_params"]; params != "" {
+		v, err := url.ParseQuery(params)
+		if err != nil {
+			return nil, &OptionError{Option: "token_params", Cause: fmt.Errorf("invalid format (expected URL-encoded parameters): %w", err)}
+		}
+
+		quirks.Params = v
+	}
+
+	if path := opts["token_response_path"]; path != "" {
+		quirks.EnvelopePath = strings.Split(path, ".")
+	}
+
+	switch et := ExpiryType(opts["expiry_type"]); et {
+	case ExpiryTypeRelative, ExpiryTypeAbsolute:
+		if quirks.ExpiryField == "" {
+			return nil, &OptionError{Option: "expiry_type", Cause: fmt.Errorf("expiry_field must also be specified")}
+		}
+
+		quirks.ExpiryType = et
+	case "":
+	default:
+		return nil, &OptionError{Option: "expiry_type", Cause: fmt.Errorf(`unknown expiry type; expected one of "relative" or "absolute"`)}
+	}
+
+	return quirks, nil
+}
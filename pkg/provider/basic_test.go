@@ -2,17 +2,25 @@ package provider_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
+	testclock "k8s.io/apimachinery/pkg/util/clock"
 )
 
 var basicTestFactory = provider.BasicFactory(provider.Endpoint{
@@ -135,6 +143,120 @@ func TestBasicPrivate(t *testing.T) {
 	require.True(t, token.Valid())
 }
 
+func TestBasicResources(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", basicTestFactory)
+
+	resources := []string{"https://api.example.com", "https://files.example.com"}
+
+	var grants []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		data, err := url.ParseQuery(string(b))
+		require.NoError(t, err)
+
+		grants = append(grants, data.Get("grant_type"))
+		assert.Equal(t, resources, data["resource"])
+
+		_, _ = w.Write([]byte(`access_token=abcd&refresh_token=efgh&token_type=bearer&expires_in=60`))
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	ops := basicTest.Private("foo", "bar")
+
+	authCodeURL, ok := ops.AuthCodeURL("state", provider.WithResources(resources))
+	require.True(t, ok)
+
+	u, err := url.Parse(authCodeURL)
+	require.NoError(t, err)
+	assert.Equal(t, resources, u.Query()["resource"])
+	assert.Equal(t, "state", u.Query().Get("state"))
+
+	token, err := ops.AuthCodeExchange(ctx, "123456", provider.WithResources(resources))
+	require.NoError(t, err)
+
+	_, err = ops.RefreshToken(ctx, token, provider.WithResources(resources))
+	require.NoError(t, err)
+
+	_, err = ops.ClientCredentials(ctx, provider.WithResources(resources))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"authorization_code", "refresh_token", "client_credentials"}, grants)
+}
+
+func TestBasicTokenResponseExtraData(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", basicTestFactory)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		data, err := url.ParseQuery(string(b))
+		require.NoError(t, err)
+
+		w.Header().Set("content-type", "application/json")
+
+		switch data.Get("grant_type") {
+		case "authorization_code":
+			_, _ = w.Write([]byte(`{
+				"access_token": "abcd",
+				"refresh_token": "efgh",
+				"token_type": "bearer",
+				"expires_in": 60,
+				"scope": "read write",
+				"instance_url": "https://example.my.salesforce.com",
+				"team": {"id": "T123"}
+			}`))
+		case "refresh_token":
+			_, _ = w.Write([]byte(`{
+				"access_token": "ijkl",
+				"token_type": "bearer",
+				"expires_in": 60,
+				"instance_url": "https://other.my.salesforce.com"
+			}`))
+		default:
+			assert.Fail(t, "unexpected `grant_type` value: %q", data.Get("grant_type"))
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	ops := basicTest.Private("foo", "bar")
+
+	token, err := ops.AuthCodeExchange(ctx, "123456")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"scope":        "read write",
+		"instance_url": "https://example.my.salesforce.com",
+		"team":         map[string]interface{}{"id": "T123"},
+	}, token.ExtraData)
+
+	// Fields omitted from the refresh response are retained.
+	token, err = ops.RefreshToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"scope":        "read write",
+		"instance_url": "https://other.my.salesforce.com",
+		"team":         map[string]interface{}{"id": "T123"},
+	}, token.ExtraData)
+}
+
 func TestAzureADEndpoint(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -185,3 +307,313 @@ func TestAzureADEndpoint(t *testing.T) {
 		})
 	}
 }
+
+func TestCustomTokenEndpointQuirks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			b, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			assert.Equal(t, "foo", data.Get("client_id"))
+			assert.Equal(t, "bar", data.Get("client_secret"))
+			assert.Equal(t, "https://api.example.com", data.Get("resource"))
+
+			// The grant type cannot be overridden.
+			assert.Equal(t, "authorization_code", data.Get("grant_type"))
+
+			w.Header().Set("content-type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"result": map[string]interface{}{
+					"token": map[string]interface{}{
+						"access_token":  "abcd",
+						"refresh_token": "efgh",
+						"token_type":    "bearer",
+						"expires_on":    strconv.FormatInt(time.Now().Add(15*time.Minute).Unix(), 10),
+					},
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	customTest, err := provider.GlobalRegistry.New(ctx, "custom", map[string]string{
+		"token_url":           "http://localhost/token",
+		"auth_style":          "in_params",
+		"token_params":        "resource=https%3A%2F%2Fapi.example.com&grant_type=password",
+		"token_response_path": "result.token",
+		"expiry_field":        "expires_on",
+		"expiry_type":         "absolute",
+	})
+	require.NoError(t, err)
+
+	token, err := customTest.Private("foo", "bar").AuthCodeExchange(ctx, "123456")
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.Equal(t, "abcd", token.AccessToken)
+	assert.Equal(t, "efgh", token.RefreshToken)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), token.Expiry, 5*time.Second)
+
+	// Expiry types require a field.
+	_, err = provider.GlobalRegistry.New(ctx, "custom", map[string]string{
+		"token_url":   "http://localhost/token",
+		"expiry_type": "absolute",
+	})
+	require.Error(t, err)
+}
+
+// failoverTestTransport serves token requests for a primary and a secondary
+// token endpoint and counts the requests to each of them. The primary endpoint
+// can be made unreachable.
+type failoverTestTransport struct {
+	t *testing.T
+
+	mut      sync.Mutex
+	down     bool
+	status   int
+	requests map[string]int
+}
+
+func (ftt *failoverTestTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ftt.mut.Lock()
+	defer ftt.mut.Unlock()
+
+	key := r.Method + " " + r.URL.Host
+	ftt.requests[key]++
+
+	if r.URL.Host == "primary.example.com" {
+		if ftt.down {
+			return nil, errors.New("connection refused")
+		} else if ftt.status != 0 {
+			w := httptest.NewRecorder()
+			w.WriteHeader(ftt.status)
+			return w.Result(), nil
+		}
+	}
+
+	w := httptest.NewRecorder()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return w.Result(), nil
+	}
+
+	// The request body is sent again to each endpoint.
+	b, err := ioutil.ReadAll(r.Body)
+	require.NoError(ftt.t, err)
+
+	data, err := url.ParseQuery(string(b))
+	require.NoError(ftt.t, err)
+	assert.Equal(ftt.t, "123456", data.Get("code"))
+
+	w.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "token_" + r.URL.Host,
+		"token_type":   "bearer",
+	})
+	return w.Result(), nil
+}
+
+func (ftt *failoverTestTransport) count(key string) int {
+	ftt.mut.Lock()
+	defer ftt.mut.Unlock()
+
+	return ftt.requests[key]
+}
+
+func (ftt *failoverTestTransport) set(down bool, status int) {
+	ftt.mut.Lock()
+	defer ftt.mut.Unlock()
+
+	ftt.down = down
+	ftt.status = status
+}
+
+func TestCustomTokenEndpointFailover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	newProvider := func(ctx context.Context, healthCheckInterval string) provider.Provider {
+		customTest, err := provider.GlobalRegistry.New(ctx, "custom", map[string]string{
+			"token_url":                            "http://primary.example.com/token",
+			"auth_style":                           "in_params",
+			"token_failover_urls":                  "http://secondary.example.com/oauth/token",
+			"token_failover_cooldown":              "1h",
+			"token_failover_health_check_interval": healthCheckInterval,
+		})
+		require.NoError(t, err)
+		return customTest
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		transport := &failoverTestTransport{t: t, down: true, requests: make(map[string]int)}
+		ctx := context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
+		customTest := newProvider(ctx, "0")
+
+		// The request never reaches the primary endpoint, so it is sent to the
+		// secondary endpoint instead.
+		token, err := customTest.Private("foo", "bar").AuthCodeExchange(ctx, "123456")
+		require.NoError(t, err)
+		assert.Equal(t, "token_secondary.example.com", token.AccessToken)
+		assert.Equal(t, 1, transport.count("POST primary.example.com"))
+		assert.Equal(t, 1, transport.count("POST secondary.example.com"))
+
+		// The primary endpoint is skipped until its cooldown elapses.
+		token, err = customTest.Private("foo", "bar").AuthCodeExchange(ctx, "123456")
+		require.NoError(t, err)
+		assert.Equal(t, "token_secondary.example.com", token.AccessToken)
+		assert.Equal(t, 1, transport.count("POST primary.example.com"))
+		assert.Equal(t, 2, transport.count("POST secondary.example.com"))
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		transport := &failoverTestTransport{t: t, status: http.StatusServiceUnavailable, requests: make(map[string]int)}
+		ctx := context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
+		customTest := newProvider(ctx, "0")
+
+		// The primary endpoint received the request and may have acted on
+		// it, so it is not sent again.
+		_, err := customTest.Private("foo", "bar").AuthCodeExchange(ctx, "123456")
+		require.Error(t, err)
+		assert.Equal(t, 1, transport.count("POST primary.example.com"))
+		assert.Equal(t, 0, transport.count("POST secondary.example.com"))
+
+		// Subsequent requests go to the secondary endpoint.
+		token, err := customTest.Private("foo", "bar").AuthCodeExchange(ctx, "123456")
+		require.NoError(t, err)
+		assert.Equal(t, "token_secondary.example.com", token.AccessToken)
+		assert.Equal(t, 1, transport.count("POST primary.example.com"))
+	})
+
+	t.Run("health check", func(t *testing.T) {
+		clk := testclock.NewFakeClock(time.Now())
+		ctx := clockctx.WithClock(ctx, k8sext.NewClock(clk))
+
+		transport := &failoverTestTransport{t: t, down: true, requests: make(map[string]int)}
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
+
+		checkCtx, checkCancel := context.WithCancel(ctx)
+		defer checkCancel()
+		customTest := newProvider(checkCtx, "1m")
+
+		check := func() {
+			require.Eventually(t, clk.HasWaiters, 5*time.Second, 10*time.Millisecond)
+			n := transport.count("GET secondary.example.com")
+			clk.Step(time.Minute)
+
+			// Wait for the checks of both endpoints to finish.
+			require.Eventually(t, func() bool {
+				return transport.count("GET secondary.example.com") > n && clk.HasWaiters()
+			}, 5*time.Second, 10*time.Millisecond)
+		}
+
+		// The unreachable primary endpoint is found by the health check, so
+		// token requests never try it.
+		check()
+		token, err := customTest.Private("foo", "bar").AuthCodeExchange(ctx, "123456")
+		require.NoError(t, err)
+		assert.Equal(t, "token_secondary.example.com", token.AccessToken)
+		assert.Equal(t, 0, transport.count("POST primary.example.com"))
+
+		// Once it recovers, it is used again without waiting for the
+		// cooldown.
+		transport.set(false, 0)
+		check()
+		token, err = customTest.Private("foo", "bar").AuthCodeExchange(ctx, "123456")
+		require.NoError(t, err)
+		assert.Equal(t, "token_primary.example.com", token.AccessToken)
+	})
+
+	_, err := provider.GlobalRegistry.New(ctx, "custom", map[string]string{
+		"token_url":           "http://primary.example.com/token",
+		"token_failover_urls": "/token",
+	})
+	require.Error(t, err)
+}
+
+func TestCustomTokenExchangeURL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		data, err := url.ParseQuery(string(b))
+		require.NoError(t, err)
+
+		switch r.URL.Path {
+		case "/token":
+			assert.Contains(t, []string{"client_credentials", "password"}, data.Get("grant_type"))
+		case "/exchange":
+			assert.Contains(t, []string{"urn:ietf:params:oauth:grant-type:jwt-bearer", "urn:ietf:params:oauth:grant-type:saml2-bearer"}, data.Get("grant_type"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("content-type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": r.URL.Path,
+			"token_type":   "bearer",
+		})
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	customTest, err := provider.GlobalRegistry.New(ctx, "custom", map[string]string{
+		"token_url":          "http://localhost/token",
+		"token_exchange_url": "http://localhost/exchange",
+		"auth_style":         "in_params",
+	})
+	require.NoError(t, err)
+
+	ops := customTest.Private("foo", "bar")
+
+	token, err := ops.ClientCredentials(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "/token", token.AccessToken)
+
+	token, err = ops.ClientCredentials(ctx, provider.WithURLParams{
+		"grant_type": "urn:ietf:params:oauth:grant-type:jwt-bearer",
+		"assertion":  "eyJhbGciOi",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/exchange", token.AccessToken)
+
+	token, err = ops.ClientCredentials(ctx, provider.WithURLParams{
+		"grant_type": "urn:ietf:params:oauth:grant-type:saml2-bearer",
+		"assertion":  "PHNhbWw6",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/exchange", token.AccessToken)
+
+	// Other grants that use this operation still go to the token URL.
+	token, err = ops.ClientCredentials(ctx, provider.WithURLParams{
+		"grant_type": "password",
+		"username":   "alice",
+		"password":   "secret",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/token", token.AccessToken)
+}
+
+func TestDropboxOfflineAccess(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	p, err := provider.GlobalRegistry.New(ctx, "dropbox", map[string]string{})
+	require.NoError(t, err)
+
+	u, ok := p.Public("foo").AuthCodeURL("123456")
+	require.True(t, ok)
+	assert.Equal(t, "https://www.dropbox.com/oauth2/authorize?client_id=foo&response_type=code&state=123456&token_access_type=offline", u)
+}
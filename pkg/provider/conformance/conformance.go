@@ -0,0 +1,359 @@
+// Package conformance provides a test suite that checks a provider
+// implementation against the behavior the plugin expects of every provider.
+//
+// The requests a provider makes are compared to golden files so that changes
+// to the way a provider constructs URLs or encodes parameters are always
+// deliberate. Run the tests with the -conformance.update flag to write the
+// golden files.
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/semerr"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+var update = flag.Bool("conformance.update", false, "write provider conformance golden files instead of comparing them")
+
+// Values sent to and returned by the provider under test.
+/* #nosec G101 */
+const (
+	ClientID     = "conformance-client"
+	ClientSecret = "conformance-secret"
+	State        = "conformance-state"
+	RedirectURL  = "https://vault.example.com/v1/oauth2/callback"
+	Code         = "conformance-code"
+	AccessToken  = "conformance-access-token"
+	RefreshToken = "conformance-refresh-token"
+)
+
+// Scopes are requested when constructing the authorization code URL and
+// making a client credentials request.
+var Scopes = []string{"read", "write"}
+
+// Suite describes a provider to check.
+type Suite struct {
+	// Factory creates the provider under test.
+	Factory provider.FactoryFunc
+
+	// Options are passed to the factory.
+	Options map[string]string
+
+	// GoldenDir is the directory containing the golden files for this
+	// provider, usually a subdirectory of testdata.
+	GoldenDir string
+
+	// TokenURL is the URL of the token endpoint. If not set, the provider
+	// must implement provider.EndpointOperations.
+	TokenURL string
+
+	// TokenResponse, if set, returns additional fields to include in a
+	// successful response to the given request to the token endpoint, such as
+	// an ID token.
+	TokenResponse func(r *http.Request) map[string]interface{}
+
+	// Handler, if set, handles requests to URLs other than the token
+	// endpoint, such as OpenID Connect discovery. Otherwise, these requests
+	// fail with status 404 (Not Found).
+	Handler http.Handler
+
+	// SkipClientCredentials skips checking the client credentials flow for
+	// providers that don't support it.
+	SkipClientCredentials bool
+}
+
+type tokenEndpointReply struct {
+	status int
+	body   map[string]interface{}
+	extra  func(r *http.Request) map[string]interface{}
+}
+
+type recorder struct {
+	handler http.Handler
+
+	mut      sync.Mutex
+	tokenURL string
+	reply    tokenEndpointReply
+	requests []string
+}
+
+func (r *recorder) isTokenURL(u url.URL) bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	u.RawQuery = ""
+	return r.tokenURL != "" && u.String() == r.tokenURL
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !r.isTokenURL(*req.URL) {
+		if r.handler == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		r.handler.ServeHTTP(w, req)
+		return
+	}
+
+	dump, err := dumpTokenRequest(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.mut.Lock()
+	r.requests = append(r.requests, dump)
+	reply := r.reply
+	r.mut.Unlock()
+
+	body := make(map[string]interface{}, len(reply.body))
+	for k, v := range reply.body {
+		body[k] = v
+	}
+	if reply.extra != nil {
+		for k, v := range reply.extra(req) {
+			body[k] = v
+		}
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(reply.status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (r *recorder) expect(reply tokenEndpointReply) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	r.reply = reply
+	r.requests = nil
+}
+
+func (r *recorder) recorded() []string {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	return append([]string{}, r.requests...)
+}
+
+// dumpTokenRequest renders the parts of a request to the token endpoint that
+// matter to the provider in a stable, readable form.
+func dumpTokenRequest(req *http.Request) (string, error) {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s %s\n", req.Method, req.URL.String())
+	for _, name := range []string{"Accept", "Authorization", "Content-Type"} {
+		if v := req.Header.Get(name); v != "" {
+			fmt.Fprintf(&sb, "%s: %s\n", name, v)
+		}
+	}
+
+	if err := req.ParseForm(); err != nil {
+		return "", err
+	}
+
+	sb.WriteString("\n")
+	sb.WriteString(dumpValues(req.PostForm))
+	return sb.String(), nil
+}
+
+func dumpValues(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		for _, v := range values[k] {
+			fmt.Fprintf(&sb, "%s=%s\n", k, v)
+		}
+	}
+	return sb.String()
+}
+
+func (s *Suite) golden(t *testing.T, name, actual string) {
+	file := filepath.Join(s.GoldenDir, name+".golden")
+
+	if *update {
+		require.NoError(t, os.MkdirAll(s.GoldenDir, 0755))
+		require.NoError(t, ioutil.WriteFile(file, []byte(actual), 0644))
+		return
+	}
+
+	expected, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		require.Fail(t, "golden file does not exist", "run the tests with -conformance.update to create %s", file)
+	}
+	require.NoError(t, err)
+
+	assert.Equal(t, string(expected), actual, "request does not match %s; run the tests with -conformance.update if the change is intended", file)
+}
+
+func (s *Suite) tokenReply(refreshToken bool) tokenEndpointReply {
+	body := map[string]interface{}{
+		"access_token": AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	}
+	if refreshToken {
+		body["refresh_token"] = RefreshToken
+	}
+
+	return tokenEndpointReply{status: http.StatusOK, body: body, extra: s.TokenResponse}
+}
+
+func (s *Suite) checkToken(t *testing.T, tok *provider.Token, start time.Time) {
+	require.NotNil(t, tok)
+	assert.Equal(t, AccessToken, tok.AccessToken)
+	assert.Equal(t, "Bearer", tok.Type())
+	assert.Equal(t, RefreshToken, tok.RefreshToken)
+	assert.True(t, tok.Expiry.After(start.Add(59*time.Minute)), "expiry %s does not reflect expires_in", tok.Expiry)
+}
+
+// Run checks the provider described by the suite. Each check runs as a
+// subtest.
+func Run(t *testing.T, s Suite) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rec := &recorder{handler: s.Handler}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: &testutil.MockRoundTripper{Handler: rec}})
+
+	p, err := s.Factory(ctx, provider.VersionLatest, s.Options)
+	require.NoError(t, err)
+
+	ops := p.Private(ClientID, ClientSecret)
+
+	tokenURL := s.TokenURL
+	if tokenURL == "" {
+		eo, ok := ops.(provider.EndpointOperations)
+		require.True(t, ok, "provider does not report its token URL, so Suite.TokenURL must be set")
+		tokenURL = eo.TokenURL()
+	}
+
+	rec.mut.Lock()
+	rec.tokenURL = tokenURL
+	rec.mut.Unlock()
+
+	t.Run("AuthCodeURL", func(t *testing.T) {
+		u, ok := ops.AuthCodeURL(State, provider.WithRedirectURL(RedirectURL), provider.WithScopes(Scopes))
+		require.True(t, ok, "provider does not support the authorization code flow")
+
+		pu, err := url.Parse(u)
+		require.NoError(t, err)
+		assert.Equal(t, State, pu.Query().Get("state"))
+		assert.Equal(t, ClientID, pu.Query().Get("client_id"))
+
+		query := pu.Query()
+		pu.RawQuery = ""
+		s.golden(t, "auth_code_url", pu.String()+"\n\n"+dumpValues(query))
+	})
+
+	t.Run("AuthCodeExchange", func(t *testing.T) {
+		rec.expect(s.tokenReply(true))
+
+		start := time.Now()
+		tok, err := ops.AuthCodeExchange(ctx, Code, provider.WithRedirectURL(RedirectURL))
+		require.NoError(t, err)
+		s.checkToken(t, tok, start)
+
+		requests := rec.recorded()
+		require.Len(t, requests, 1)
+		s.golden(t, "auth_code_exchange", requests[0])
+	})
+
+	t.Run("RefreshToken", func(t *testing.T) {
+		// Providers that don't rotate refresh tokens omit them from the
+		// response, so the previous refresh token must be kept.
+		rec.expect(s.tokenReply(false))
+
+		start := time.Now()
+		tok, err := ops.RefreshToken(ctx, &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken:  "expired",
+				RefreshToken: RefreshToken,
+				Expiry:       start.Add(-time.Minute),
+			},
+		})
+		require.NoError(t, err)
+		s.checkToken(t, tok, start)
+
+		requests := rec.recorded()
+		require.Len(t, requests, 1)
+		s.golden(t, "refresh_token", requests[0])
+	})
+
+	if !s.SkipClientCredentials {
+		t.Run("ClientCredentials", func(t *testing.T) {
+			rec.expect(s.tokenReply(false))
+
+			tok, err := ops.ClientCredentials(ctx, provider.WithScopes(Scopes))
+			require.NoError(t, err)
+			require.NotNil(t, tok)
+			assert.Equal(t, AccessToken, tok.AccessToken)
+
+			requests := rec.recorded()
+			require.Len(t, requests, 1)
+			s.golden(t, "client_credentials", requests[0])
+		})
+	}
+
+	t.Run("ErrorMapping", func(t *testing.T) {
+		tests := []struct {
+			Name       string
+			StatusCode int
+			Code       string
+			User       bool
+		}{
+			{Name: "invalid grant", StatusCode: http.StatusBadRequest, Code: "invalid_grant", User: true},
+			{Name: "invalid client", StatusCode: http.StatusUnauthorized, Code: "invalid_client", User: true},
+			{Name: "server error", StatusCode: http.StatusServiceUnavailable},
+		}
+		for _, test := range tests {
+			t.Run(test.Name, func(t *testing.T) {
+				reply := tokenEndpointReply{status: test.StatusCode, body: map[string]interface{}{}}
+				if test.Code != "" {
+					reply.body["error"] = test.Code
+				}
+				rec.expect(reply)
+
+				_, err := ops.RefreshToken(ctx, &provider.Token{
+					Token: &oauth2.Token{RefreshToken: RefreshToken},
+				})
+				require.Error(t, err)
+
+				if test.Code != "" {
+					assert.True(t, semerr.IsCode(err, test.Code), "error %q does not have code %q", err, test.Code)
+				}
+
+				status, ok := semerr.StatusCode(err)
+				assert.True(t, ok, "error %q does not have a status code", err)
+				assert.Equal(t, test.StatusCode, status)
+
+				assert.Equal(t, test.User, errmark.MarkedUser(err), "error %q is not marked correctly", err)
+			})
+		}
+	})
+}
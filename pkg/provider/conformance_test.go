@@ -0,0 +1,160 @@
+package provider_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider/conformance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// conformanceOIDC serves OpenID Connect discovery documents for whatever
+// issuer is requested and signs ID tokens for the conformance suite.
+type conformanceOIDC struct {
+	t      *testing.T
+	key    *rsa.PrivateKey
+	signer jose.Signer
+}
+
+func newConformanceOIDC(t *testing.T) *conformanceOIDC {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: key, KeyID: "conformance"},
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	require.NoError(t, err)
+
+	return &conformanceOIDC{t: t, key: key, signer: signer}
+}
+
+func (co *conformanceOIDC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const suffix = "/.well-known/openid-configuration"
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, suffix):
+		issuer := "https://" + r.URL.Host + strings.TrimSuffix(r.URL.Path, suffix)
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                issuer,
+			"authorization_endpoint":                issuer + "/authorize",
+			"token_endpoint":                        issuer + "/token",
+			"jwks_uri":                              issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	case strings.HasSuffix(r.URL.Path, "/jwks"):
+		_ = json.NewEncoder(w).Encode(&jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{
+				{Key: &co.key.PublicKey, KeyID: "conformance", Algorithm: "RS256", Use: "sig"},
+			},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// TokenResponse adds an ID token issued by the issuer that owns the token
+// endpoint.
+func (co *conformanceOIDC) TokenResponse(r *http.Request) map[string]interface{} {
+	issuer := "https://" + r.URL.Host + strings.TrimSuffix(r.URL.Path, "/token")
+
+	idToken, err := jwt.Signed(co.signer).
+		Claims(jwt.Claims{
+			Issuer:   issuer,
+			Subject:  "conformance-user",
+			Audience: jwt.Audience{conformance.ClientID},
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}).
+		CompactSerialize()
+	require.NoError(co.t, err)
+
+	return map[string]interface{}{"id_token": idToken}
+}
+
+func TestConformance(t *testing.T) {
+	oidc := newConformanceOIDC(t)
+
+	tests := []struct {
+		Name    string
+		Options map[string]string
+		OIDC    bool
+	}{
+		{Name: "atlassian"},
+		{Name: "bitbucket"},
+		{Name: "box"},
+		{Name: "cilogon", OIDC: true},
+		{
+			Name: "custom",
+			Options: map[string]string{
+				"auth_code_url": "https://auth.example.com/authorize",
+				"token_url":     "https://auth.example.com/token",
+			},
+		},
+		{Name: "dropbox"},
+		{Name: "egi_check_in", OIDC: true},
+		{Name: "github"},
+		{Name: "gitlab"},
+		{Name: "google", OIDC: true},
+		{
+			Name: "keycloak",
+			Options: map[string]string{
+				"base_url": "https://keycloak.example.com",
+				"realm":    "example",
+			},
+			OIDC: true,
+		},
+		{Name: "microsoft_azure_ad", Options: map[string]string{"tenant": "example"}},
+		{
+			Name: "oidc",
+			Options: map[string]string{
+				"issuer_url": "https://oidc.example.com",
+			},
+			OIDC: true,
+		},
+		{Name: "orcid", OIDC: true},
+		{Name: "salesforce"},
+		{Name: "slack"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			info, err := provider.GlobalRegistry.Info(test.Name)
+			require.NoError(t, err)
+
+			factory := func(ctx context.Context, vsn int, opts map[string]string) (provider.Provider, error) {
+				p, err := provider.GlobalRegistry.NewAt(ctx, test.Name, vsn, opts)
+				if err == nil && vsn == provider.VersionLatest {
+					// The published version must match what the factory
+					// actually creates.
+					assert.Equal(t, info.Version, p.Version(), "published version of provider %q is out of date", test.Name)
+				}
+				return p, err
+			}
+
+			suite := conformance.Suite{
+				Factory:   factory,
+				Options:   test.Options,
+				GoldenDir: filepath.Join("testdata", "conformance", test.Name),
+			}
+			if test.OIDC {
+				suite.Handler = oidc
+				suite.TokenResponse = oidc.TokenResponse
+			}
+
+			conformance.Run(t, suite)
+		})
+	}
+}
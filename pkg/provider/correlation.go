@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// CorrelationIDHeader is the HTTP header used to send a correlation ID to the
+// provider.
+const CorrelationIDHeader = "X-Correlation-ID"
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context that causes every HTTP request made
+// using it, including requests to the provider's token endpoint, to include
+// the given correlation ID in the X-Correlation-ID header.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+
+	base := oauth2.NewClient(ctx, nil)
+
+	transport := base.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	c := *base
+	c.Transport = &correlationTransport{
+		delegate: transport,
+		id:       id,
+	}
+
+	ctx = context.WithValue(ctx, correlationIDKey{}, id)
+	return context.WithValue(ctx, oauth2.HTTPClient, &c)
+}
+
+// CorrelationID returns the correlation ID set using WithCorrelationID, if
+// any.
+func CorrelationID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationIDKey{}).(string)
+	return id, ok
+}
+
+type correlationTransport struct {
+	delegate http.RoundTripper
+	id       string
+}
+
+func (ct *correlationTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Header.Get(CorrelationIDHeader) != "" {
+		return ct.delegate.RoundTrip(r)
+	}
+
+	nr := r.Clone(r.Context())
+	nr.Header.Set(CorrelationIDHeader, ct.id)
+	return ct.delegate.RoundTrip(nr)
+}
@@ -0,0 +1,58 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	GlobalRegistry.MustRegister("dropbox", DropboxFactory,
+		WithSchema(BasicSchema),
+		WithVersion(1),
+		basicFlows,
+		WithTokenLifetime(4*time.Hour), // https://developers.dropbox.com/oauth-guide#using-refresh-tokens
+	)
+}
+
+type dropboxOperations struct {
+	*basicOperations
+}
+
+func (do *dropboxOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool) {
+	// Dropbox only issues short-lived access tokens, and only issues a refresh
+	// token if offline access is requested.
+	//
+	// https://developers.dropbox.com/oauth-guide#using-refresh-tokens
+	opts = append([]AuthCodeURLOption{WithURLParams{"token_access_type": "offline"}}, opts...)
+	return do.basicOperations.AuthCodeURL(state, opts...)
+}
+
+type dropbox struct {
+	*basic
+}
+
+func (d *dropbox) Public(clientID string) PublicOperations {
+	return d.Private(clientID, "")
+}
+
+func (d *dropbox) Private(clientID, clientSecret string) PrivateOperations {
+	return &dropboxOperations{
+		basicOperations: d.basic.Private(clientID, clientSecret).(*basicOperations),
+	}
+}
+
+func DropboxFactory(ctx context.Context, vsn int, opts map[string]string) (Provider, error) {
+	p, err := BasicFactory(Endpoint{
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://www.dropbox.com/oauth2/authorize",
+			TokenURL: "https://api.dropboxapi.com/oauth2/token",
+		},
+	})(ctx, vsn, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dropbox{basic: p.(*basic)}, nil
+}
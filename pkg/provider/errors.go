@@ -3,12 +3,14 @@ package provider
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 var (
 	ErrNoSuchProvider        = errors.New("no provider with the given name")
 	ErrNoProviderWithVersion = errors.New("version not supported")
 	ErrNoOptions             = errors.New("options provided but none accepted")
+	ErrUnsupportedOperation  = errors.New("operation not supported by provider")
 )
 
 type OptionError struct {
@@ -23,3 +25,18 @@ func (oe *OptionError) Error() string {
 func (oe *OptionError) Unwrap() error {
 	return oe.Cause
 }
+
+// OptionsError is returned when provider options do not conform to the schema
+// published by the provider.
+type OptionsError struct {
+	Errors []*OptionError
+}
+
+func (oe *OptionsError) Error() string {
+	msgs := make([]string, len(oe.Errors))
+	for i, err := range oe.Errors {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("invalid provider options: %s", strings.Join(msgs, "; "))
+}
@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+)
+
+// ExpiryFunc determines the expiry of a token that the provider issued
+// without one, for example from the token itself. It returns false if the
+// expiry cannot be determined.
+type ExpiryFunc func(ctx context.Context, tok *Token) (time.Time, bool)
+
+type expiry struct {
+	lifetime time.Duration
+	infer    []ExpiryFunc
+}
+
+func (e *expiry) get(ctx context.Context, tok *Token) (time.Time, bool) {
+	for _, fn := range e.infer {
+		if t, ok := fn(ctx, tok); ok {
+			return t, true
+		}
+	}
+
+	if e.lifetime <= 0 {
+		return time.Time{}, false
+	}
+
+	return clockctx.Clock(ctx).Now().Add(e.lifetime), true
+}
+
+// apply sets the expiry of a token that the provider issued without one.
+func (e *expiry) apply(ctx context.Context, tok *Token, err error) (*Token, error) {
+	if err != nil || tok == nil || tok.Token == nil || !tok.Expiry.IsZero() {
+		return tok, err
+	}
+
+	t, ok := e.get(ctx, tok)
+	if !ok {
+		return tok, nil
+	}
+
+	inner := *tok.Token
+	inner.Expiry = t
+
+	cp := *tok
+	cp.Token = &inner
+	return &cp, nil
+}
+
+type publicExpiryOperations struct {
+	PublicOperations
+	e *expiry
+}
+
+func (peo *publicExpiryOperations) DeviceCodeExchange(ctx context.Context, deviceCode string, opts ...DeviceCodeExchangeOption) (*Token, error) {
+	tok, err := peo.PublicOperations.DeviceCodeExchange(ctx, deviceCode, opts...)
+	return peo.e.apply(ctx, tok, err)
+}
+
+func (peo *publicExpiryOperations) RefreshToken(ctx context.Context, t *Token, opts ...RefreshTokenOption) (*Token, error) {
+	tok, err := peo.PublicOperations.RefreshToken(ctx, t, opts...)
+	return peo.e.apply(ctx, tok, err)
+}
+
+type privateExpiryOperations struct {
+	*publicExpiryOperations
+	delegate PrivateOperations
+}
+
+func (peo *privateExpiryOperations) AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (*Token, error) {
+	tok, err := peo.delegate.AuthCodeExchange(ctx, code, opts...)
+	return peo.e.apply(ctx, tok, err)
+}
+
+func (peo *privateExpiryOperations) ClientCredentials(ctx context.Context, opts ...ClientCredentialsOption) (*Token, error) {
+	tok, err := peo.delegate.ClientCredentials(ctx, opts...)
+	return peo.e.apply(ctx, tok, err)
+}
+
+func (peo *privateExpiryOperations) SupportsUserInfo() bool {
+	ops, ok := peo.delegate.(UserInfoOperations)
+	return ok && ops.SupportsUserInfo()
+}
+
+func (peo *privateExpiryOperations) UserInfo(ctx context.Context, t *Token) (map[string]interface{}, error) {
+	ops, ok := peo.delegate.(UserInfoOperations)
+	if !ok {
+		return nil, ErrUnsupportedOperation
+	}
+
+	return ops.UserInfo(ctx, t)
+}
+
+func (peo *privateExpiryOperations) SupportsRevocation() bool {
+	ops, ok := peo.delegate.(RevocationOperations)
+	return ok && ops.SupportsRevocation()
+}
+
+func (peo *privateExpiryOperations) RevokeToken(ctx context.Context, t *Token) error {
+	ops, ok := peo.delegate.(RevocationOperations)
+	if !ok {
+		return ErrUnsupportedOperation
+	}
+
+	return ops.RevokeToken(ctx, t)
+}
+
+func (peo *privateExpiryOperations) SupportsIntrospection() bool {
+	ops, ok := peo.delegate.(IntrospectionOperations)
+	return ok && ops.SupportsIntrospection()
+}
+
+func (peo *privateExpiryOperations) IntrospectToken(ctx context.Context, t *Token) (bool, error) {
+	ops, ok := peo.delegate.(IntrospectionOperations)
+	if !ok {
+		return false, ErrUnsupportedOperation
+	}
+
+	return ops.IntrospectToken(ctx, t)
+}
+
+// ExpiryProvider assigns an expiry to tokens that the provider issues without
+// one, such as when a token response omits the expires_in field. Otherwise
+// these tokens would be considered valid forever and never refreshed.
+type ExpiryProvider struct {
+	delegate Provider
+	e        *expiry
+}
+
+var _ Provider = &ExpiryProvider{}
+
+func (ep *ExpiryProvider) Version() int {
+	return ep.delegate.Version()
+}
+
+func (ep *ExpiryProvider) Public(clientID string) PublicOperations {
+	return &publicExpiryOperations{
+		PublicOperations: ep.delegate.Public(clientID),
+		e:                ep.e,
+	}
+}
+
+func (ep *ExpiryProvider) Private(clientID, clientSecret string) PrivateOperations {
+	priv := ep.delegate.Private(clientID, clientSecret)
+	return &privateExpiryOperations{
+		publicExpiryOperations: &publicExpiryOperations{
+			PublicOperations: priv,
+			e:                ep.e,
+		},
+		delegate: priv,
+	}
+}
+
+// NewExpiryProvider returns a provider that determines the expiry of tokens
+// issued without one using the first of the given functions that succeeds.
+// Otherwise, if lifetime is positive, tokens are assumed to be valid for that
+// long from the time they are issued.
+func NewExpiryProvider(delegate Provider, lifetime time.Duration, infer ...ExpiryFunc) *ExpiryProvider {
+	return &ExpiryProvider{
+		delegate: delegate,
+		e: &expiry{
+			lifetime: lifetime,
+			infer:    infer,
+		},
+	}
+}
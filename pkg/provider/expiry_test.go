@@ -0,0 +1,76 @@
+package provider_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	testclock "k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestExpiryProvider(t *testing.T) {
+	clk := testclock.NewFakeClock(time.Now())
+	ctx := clockctx.WithClock(context.Background(), k8sext.NewClock(clk))
+
+	client := testutil.MockClient{
+		ID:     "foo",
+		Secret: "bar",
+	}
+
+	nonExpiring := &provider.Token{
+		Token: &oauth2.Token{
+			AccessToken: "non-expiring",
+		},
+	}
+	expiring := &provider.Token{
+		Token: &oauth2.Token{
+			AccessToken: "expiring",
+			Expiry:      clk.Now().Add(5 * time.Minute),
+		},
+	}
+
+	delegate, err := testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.StaticMockAuthCodeExchange(nonExpiring)),
+		testutil.MockWithClientCredentials(client, func(_ *provider.ClientCredentialsOptions) (*provider.Token, error) {
+			return expiring, nil
+		}),
+	)(ctx, -1, map[string]string{})
+	require.NoError(t, err)
+
+	p := provider.NewExpiryProvider(delegate, time.Hour)
+
+	// Tokens without an expiry are assigned one.
+	tok, err := p.Private(client.ID, client.Secret).AuthCodeExchange(ctx, "123456")
+	require.NoError(t, err)
+	assert.Equal(t, "non-expiring", tok.AccessToken)
+	assert.Equal(t, clk.Now().Add(time.Hour), tok.Expiry)
+	assert.True(t, nonExpiring.Expiry.IsZero())
+
+	// Tokens with an expiry are left alone.
+	tok, err = p.Private(client.ID, client.Secret).ClientCredentials(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, expiring.Expiry, tok.Expiry)
+
+	// Functions that determine the expiry take precedence over the lifetime.
+	p = provider.NewExpiryProvider(delegate, time.Hour, func(_ context.Context, tok *provider.Token) (time.Time, bool) {
+		return clk.Now().Add(time.Minute), tok.AccessToken == "non-expiring"
+	})
+
+	tok, err = p.Private(client.ID, client.Secret).AuthCodeExchange(ctx, "123456")
+	require.NoError(t, err)
+	assert.Equal(t, clk.Now().Add(time.Minute), tok.Expiry)
+
+	// Without a lifetime, tokens may still never expire.
+	p = provider.NewExpiryProvider(delegate, 0)
+
+	tok, err = p.Private(client.ID, client.Secret).AuthCodeExchange(ctx, "123456")
+	require.NoError(t, err)
+	assert.True(t, tok.Expiry.IsZero())
+}
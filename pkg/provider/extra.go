@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"reflect"
+
+	"golang.org/x/oauth2"
+)
+
+// standardTokenResponseFields are the fields of a token response that are
+// already represented by an oauth2.Token.
+var standardTokenResponseFields = map[string]struct{}{
+	"access_token":  {},
+	"token_type":    {},
+	"refresh_token": {},
+	"expires_in":    {},
+}
+
+// tokenResponseFields returns the names of the fields in the raw response that
+// produced the given token.
+//
+// The oauth2 package does not expose the raw response except by key, so we
+// use reflection to enumerate the keys. The response is either a JSON object
+// or URL-encoded form data, both of which are represented as maps.
+func tokenResponseFields(tok *oauth2.Token) []string {
+	if tok == nil {
+		return nil
+	}
+
+	raw := reflect.ValueOf(tok).Elem().FieldByName("raw")
+	if raw.Kind() == reflect.Interface {
+		raw = raw.Elem()
+	}
+
+	if raw.Kind() != reflect.Map || raw.Type().Key().Kind() != reflect.String {
+		return nil
+	}
+
+	fields := make([]string, 0, raw.Len())
+	for _, key := range raw.MapKeys() {
+		fields = append(fields, key.String())
+	}
+	return fields
+}
+
+// updateTokenResponseExtraData copies the nonstandard fields of the response
+// that produced the given token into its extra data. Fields from the previous
+// token's extra data are retained if the new response omits them, because
+// providers commonly leave out unchanged values (like scope) when refreshing.
+func updateTokenResponseExtraData(t, prev *Token, omit []string) {
+	extra := make(map[string]interface{})
+	if prev != nil {
+		for k, v := range prev.ExtraData {
+			extra[k] = v
+		}
+	}
+
+	for _, field := range tokenResponseFields(t.Token) {
+		if _, found := standardTokenResponseFields[field]; found {
+			continue
+		}
+
+		extra[field] = t.Extra(field)
+	}
+
+	for _, field := range omit {
+		delete(extra, field)
+	}
+
+	if len(extra) == 0 {
+		return
+	}
+
+	if t.ExtraData == nil {
+		t.ExtraData = make(map[string]interface{}, len(extra))
+	}
+
+	for k, v := range extra {
+		if _, found := t.ExtraData[k]; !found {
+			t.ExtraData[k] = v
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultTokenEndpointFailoverCooldown    = 30 * time.Second
+	defaultTokenEndpointHealthCheckInterval = 30 * time.Second
+	tokenEndpointHealthCheckTimeout         = 10 * time.Second
+)
+
+// tokenEndpointFailover sends token requests to alternate token endpoints
+// when the configured one is unavailable. An endpoint that fails to respond or
+// responds with a server error is skipped until its cooldown elapses.
+//
+// A token request is only sent to another endpoint if it could not be sent to
+// the first one at all. Once an endpoint has received a request, it may have
+// acted on it, for example by rotating a refresh token, so sending the same
+// request elsewhere could invalidate the result.
+type tokenEndpointFailover struct {
+	// TokenURL is the URL of the configured token endpoint. Requests to other
+	// URLs are not modified.
+	TokenURL string
+
+	// URLs are the token endpoints to try, in order of preference, starting
+	// with TokenURL.
+	URLs []*url.URL
+
+	// Cooldown is how long to skip an endpoint for after a request to it
+	// fails.
+	Cooldown time.Duration
+
+	// HealthCheckInterval is how often to check whether each endpoint is
+	// available in the background. If zero, endpoints are only checked by
+	// token requests.
+	HealthCheckInterval time.Duration
+
+	mut       sync.Mutex
+	unhealthy map[int]time.Time
+}
+
+// Context returns a context with an HTTP client for the oauth2 package that
+// fails over between token endpoints.
+func (tf *tokenEndpointFailover) Context(ctx context.Context) context.Context {
+	if tf == nil {
+		return ctx
+	}
+
+	base := oauth2.NewClient(ctx, nil)
+
+	transport := base.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	c := *base
+	c.Transport = &failoverTransport{
+		delegate: transport,
+		failover: tf,
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, &c)
+}
+
+// order returns the indices of the endpoints to try. Healthy endpoints are
+// tried first, in order of preference, followed by unhealthy endpoints in
+// case they have recovered.
+func (tf *tokenEndpointFailover) order(now time.Time) []int {
+	tf.mut.Lock()
+	defer tf.mut.Unlock()
+
+	healthy := make([]int, 0, len(tf.URLs))
+	var unhealthy []int
+	for i := range tf.URLs {
+		if until, found := tf.unhealthy[i]; found && now.Before(until) {
+			unhealthy = append(unhealthy, i)
+		} else {
+			healthy = append(healthy, i)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// Start checks the health of each endpoint every HealthCheckInterval until the
+// given context is done.
+func (tf *tokenEndpointFailover) Start(ctx context.Context) {
+	if tf == nil || tf.HealthCheckInterval <= 0 {
+		return
+	}
+
+	go func() {
+		clk := clockctx.Clock(ctx)
+		for {
+			select {
+			case <-clk.After(tf.HealthCheckInterval):
+			case <-ctx.Done():
+				return
+			}
+
+			for i := range tf.URLs {
+				tf.mark(i, tf.check(ctx, i), clk.Now())
+			}
+		}
+	}()
+}
+
+// check sends a GET request to the endpoint with the given index. Token
+// endpoints generally reject such a request, but any response other than a
+// server error shows that the endpoint is able to handle requests.
+func (tf *tokenEndpointFailover) check(ctx context.Context, i int) bool {
+	ctx, cancel := context.WithTimeout(ctx, tokenEndpointHealthCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tf.URLs[i].String(), nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := oauth2.NewClient(ctx, nil).Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode < http.StatusInternalServerError
+}
+
+func (tf *tokenEndpointFailover) mark(i int, ok bool, now time.Time) {
+	tf.mut.Lock()
+	defer tf.mut.Unlock()
+
+	if ok {
+		delete(tf.unhealthy, i)
+		return
+	}
+
+	if tf.unhealthy == nil {
+		tf.unhealthy = make(map[int]time.Time)
+	}
+	tf.unhealthy[i] = now.Add(tf.Cooldown)
+}
+
+type failoverTransport struct {
+	delegate http.RoundTripper
+	failover *tokenEndpointFailover
+}
+
+func (ft *failoverTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodPost || !sameURL(r.URL, ft.failover.TokenURL) {
+		return ft.delegate.RoundTrip(r)
+	}
+
+	var body []byte
+	if r.Body != nil {
+		b, err := ioutil.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		body = b
+	}
+
+	clk := clockctx.Clock(r.Context())
+
+	var err error
+	for n, i := range ft.failover.order(clk.Now()) {
+		if n > 0 {
+			if cerr := r.Context().Err(); cerr != nil {
+				return nil, cerr
+			}
+		}
+
+		target := ft.failover.URLs[i]
+
+		var sent int32
+		ctx := httptrace.WithClientTrace(r.Context(), &httptrace.ClientTrace{
+			WroteHeaders: func() { atomic.StoreInt32(&sent, 1) },
+		})
+
+		nr := r.Clone(ctx)
+		nr.URL.Scheme = target.Scheme
+		nr.URL.Host = target.Host
+		nr.URL.Path = target.Path
+		nr.URL.RawPath = target.RawPath
+		nr.Host = ""
+		nr.Body = ioutil.NopCloser(bytes.NewReader(body))
+		nr.ContentLength = int64(len(body))
+		nr.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+
+		var resp *http.Response
+		resp, err = ft.delegate.RoundTrip(nr)
+		ft.failover.mark(i, err == nil && resp.StatusCode < http.StatusInternalServerError, clk.Now())
+		if err == nil || atomic.LoadInt32(&sent) != 0 {
+			return resp, err
+		}
+
+		// The request did not reach this endpoint, so it is safe to send it
+		// to the next one.
+	}
+
+	return nil, err
+}
+
+func parseCustomTokenEndpointFailover(opts map[string]string) (*tokenEndpointFailover, error) {
+	if opts["token_failover_urls"] == "" {
+		return nil, nil
+	}
+
+	primary, err := url.Parse(opts["token_url"])
+	if err != nil {
+		return nil, &OptionError{Option: "token_url", Cause: fmt.Errorf("invalid URL: %w", err)}
+	}
+
+	failover := &tokenEndpointFailover{
+		TokenURL: opts["token_url"],
+		URLs:     []*url.URL{primary},
+		Cooldown: defaultTokenEndpointFailoverCooldown,
+	}
+
+	urls, err := parseutil.ParseCommaStringSlice(opts["token_failover_urls"])
+	if err != nil {
+		return nil, &OptionError{Option: "token_failover_urls", Cause: fmt.Errorf("invalid format (expected a comma-separated list): %w", err)}
+	}
+
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || !u.IsAbs() || u.Host == "" {
+			return nil, &OptionError{Option: "token_failover_urls", Cause: fmt.Errorf("invalid URL: %q must be an absolute URL", raw)}
+		}
+
+		failover.URLs = append(failover.URLs, u)
+	}
+
+	if cooldown := opts["token_failover_cooldown"]; cooldown != "" {
+		d, err := parseutil.ParseDurationSecond(cooldown)
+		if err != nil {
+			return nil, &OptionError{Option: "token_failover_cooldown", Cause: fmt.Errorf("invalid duration: %w", err)}
+		}
+
+		failover.Cooldown = d
+	}
+
+	failover.HealthCheckInterval = defaultTokenEndpointHealthCheckInterval
+	if interval := opts["token_failover_health_check_interval"]; interval != "" {
+		d, err := parseutil.ParseDurationSecond(interval)
+		if err != nil {
+			return nil, &OptionError{Option: "token_failover_health_check_interval", Cause: fmt.Errorf("invalid duration: %w", err)}
+		}
+
+		failover.HealthCheckInterval = d
+	}
+
+	return failover, nil
+}
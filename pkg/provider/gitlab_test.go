@@ -0,0 +1,115 @@
+package provider_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestGitLabEndpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		Name                string
+		PluginOptions       map[string]string
+		ExpectedAuthCodeURL string
+	}{
+		{
+			Name:                "GitLab.com",
+			ExpectedAuthCodeURL: "https://gitlab.com/oauth/authorize?client_id=foo&response_type=code&state=123456",
+		},
+		{
+			Name: "self-managed",
+			PluginOptions: map[string]string{
+				"base_url": "https://gitlab.example.com/",
+			},
+			ExpectedAuthCodeURL: "https://gitlab.example.com/oauth/authorize?client_id=foo&response_type=code&state=123456",
+		},
+		{
+			Name: "self-managed with relative URL root",
+			PluginOptions: map[string]string{
+				"base_url": "https://example.com/gitlab",
+			},
+			ExpectedAuthCodeURL: "https://example.com/gitlab/oauth/authorize?client_id=foo&response_type=code&state=123456",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			p, err := provider.GlobalRegistry.New(ctx, "gitlab", test.PluginOptions)
+			require.NoError(t, err)
+
+			u, ok := p.Public("foo").AuthCodeURL("123456")
+			require.True(t, ok)
+			require.Equal(t, test.ExpectedAuthCodeURL, u)
+		})
+	}
+}
+
+func TestGitLabRefresh(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Host + r.URL.Path {
+		case "gitlab.example.com/oauth/token":
+			// Credentials must be sent as parameters.
+			_, _, ok := r.BasicAuth()
+			assert.False(t, ok)
+
+			b, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			assert.Equal(t, "refresh_token", data.Get("grant_type"))
+			assert.Equal(t, "foo", data.Get("client_id"))
+			assert.Equal(t, "bar", data.Get("client_secret"))
+			assert.Equal(t, "efgh", data.Get("refresh_token"))
+
+			// GitLab rotates refresh tokens and issues access tokens that
+			// expire after two hours.
+			w.Header().Set("content-type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token":  "ijkl",
+				"token_type":    "Bearer",
+				"expires_in":    7200,
+				"refresh_token": "mnop",
+				"created_at":    time.Now().Unix(),
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	p, err := provider.GlobalRegistry.New(ctx, "gitlab", map[string]string{
+		"base_url": "https://gitlab.example.com",
+	})
+	require.NoError(t, err)
+
+	token, err := p.Private("foo", "bar").RefreshToken(ctx, &provider.Token{
+		Token: &oauth2.Token{
+			AccessToken:  "abcd",
+			RefreshToken: "efgh",
+			Expiry:       time.Now().Add(-time.Minute),
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.Equal(t, "ijkl", token.AccessToken)
+	assert.Equal(t, "mnop", token.RefreshToken)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), token.Expiry, 5*time.Second)
+}
@@ -2,12 +2,25 @@ package provider
 
 import (
 	"context"
+	"time"
 
 	"golang.org/x/oauth2/google"
 )
 
 func init() {
-	GlobalRegistry.MustRegister("google", GoogleFactory)
+	GlobalRegistry.MustRegister("google", GoogleFactory,
+		WithSchema(GoogleSchema),
+		WithVersion(2),
+		deviceCodeFlows,
+		WithTokenLifetime(time.Hour), // https://developers.google.com/identity/protocols/oauth2#expiration
+	)
+}
+
+// GoogleSchema describes the options accepted by GoogleFactory.
+var GoogleSchema = OptionSchema{
+	"extra_data_fields":         oidcExtraDataFieldsSpec,
+	"jwks_cache_ttl":            oidcJWKSCacheTTLSpec,
+	"jwks_min_refresh_interval": oidcJWKSMinRefreshIntervalSpec,
 }
 
 func GoogleFactory(ctx context.Context, vsn int, opts map[string]string) (Provider, error) {
@@ -20,7 +33,12 @@ func GoogleFactory(ctx context.Context, vsn int, opts map[string]string) (Provid
 			return nil, &OptionError{Option: "extra_data_fields", Cause: err}
 		}
 
-		return newOIDC(ctx, vsn, "https://accounts.google.com", fields) // https://developers.google.com/identity/protocols/oauth2/openid-connect#discovery
+		keySetOpts, err := parseOIDCJWKSOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		return newOIDC(ctx, vsn, "https://accounts.google.com", fields, keySetOpts) // https://developers.google.com/identity/protocols/oauth2/openid-connect#discovery
 	case 1:
 		if len(opts) != 0 {
 			return nil, ErrNoOptions
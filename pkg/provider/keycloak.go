@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/devicecode"
+)
+
+func init() {
+	GlobalRegistry.MustRegister("keycloak", KeycloakFactory, WithSchema(KeycloakSchema), WithVersion(1), deviceCodeFlows)
+}
+
+// KeycloakSchema describes the options accepted by KeycloakFactory.
+var KeycloakSchema = OptionSchema{
+	"base_url": {
+		Type:        OptionTypeURL,
+		Description: "The URL of the Keycloak server, including the /auth path prefix for versions prior to 17.",
+		Required:    true,
+	},
+	"realm": {
+		Type:        OptionTypeString,
+		Description: "The realm to authenticate to.",
+		Required:    true,
+	},
+	"offline_access": {
+		Type:        OptionTypeString,
+		Description: "Whether to request offline tokens, which remain valid after the user's session ends.",
+		Enum:        []string{"true", "false"},
+		Default:     "false",
+	},
+	"extra_data_fields":         oidcExtraDataFieldsSpec,
+	"jwks_cache_ttl":            oidcJWKSCacheTTLSpec,
+	"jwks_min_refresh_interval": oidcJWKSMinRefreshIntervalSpec,
+}
+
+type keycloakOperations struct {
+	*oidcOperations
+	offlineAccess bool
+}
+
+func (ko *keycloakOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool) {
+	if ko.offlineAccess {
+		opts = append([]AuthCodeURLOption{WithScopes{"offline_access"}}, opts...)
+	}
+
+	return ko.oidcOperations.AuthCodeURL(state, opts...)
+}
+
+func (ko *keycloakOperations) DeviceCodeAuth(ctx context.Context, opts ...DeviceCodeAuthOption) (*devicecode.Auth, bool, error) {
+	if ko.offlineAccess {
+		opts = append([]DeviceCodeAuthOption{WithScopes{"offline_access"}}, opts...)
+	}
+
+	return ko.oidcOperations.DeviceCodeAuth(ctx, opts...)
+}
+
+type keycloak struct {
+	*oidc
+	offlineAccess bool
+}
+
+func (k *keycloak) Public(clientID string) PublicOperations {
+	return k.Private(clientID, "")
+}
+
+func (k *keycloak) Private(clientID, clientSecret string) PrivateOperations {
+	return &keycloakOperations{
+		oidcOperations: k.oidc.Private(clientID, clientSecret).(*oidcOperations),
+		offlineAccess:  k.offlineAccess,
+	}
+}
+
+func KeycloakFactory(ctx context.Context, vsn int, opts map[string]string) (Provider, error) {
+	vsn = selectVersion(vsn, 1)
+
+	switch vsn {
+	case 1:
+	default:
+		return nil, ErrNoProviderWithVersion
+	}
+
+	if opts["base_url"] == "" {
+		return nil, &OptionError{Option: "base_url", Cause: fmt.Errorf("base URL is required")}
+	} else if opts["realm"] == "" {
+		return nil, &OptionError{Option: "realm", Cause: fmt.Errorf("realm is required")}
+	}
+
+	var offlineAccess bool
+	switch opts["offline_access"] {
+	case "true":
+		offlineAccess = true
+	case "false", "":
+	default:
+		return nil, &OptionError{Option: "offline_access", Cause: fmt.Errorf(`expected one of "true" or "false"`)}
+	}
+
+	fields, err := parseOIDCExtraDataFields(opts["extra_data_fields"])
+	if err != nil {
+		return nil, &OptionError{Option: "extra_data_fields", Cause: err}
+	}
+
+	keySetOpts, err := parseOIDCJWKSOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// https://www.keycloak.org/docs/latest/securing_apps/#endpoints
+	issuerURL := strings.TrimSuffix(opts["base_url"], "/") + "/realms/" + url.PathEscape(opts["realm"])
+
+	p, err := newOIDC(ctx, vsn, issuerURL, fields, keySetOpts)
+	if err != nil {
+		return nil, &OptionError{Option: "base_url", Cause: err}
+	}
+
+	return &keycloak{
+		oidc:          p,
+		offlineAccess: offlineAccess,
+	}, nil
+}
@@ -0,0 +1,54 @@
+package provider_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestKeycloakEndpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/realms/my realm/.well-known/openid-configuration":
+			_, _ = io.WriteString(w, strings.NewReplacer("http://localhost", "http://localhost/realms/my%20realm").Replace(testOIDCConfiguration))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	p, err := provider.GlobalRegistry.New(ctx, "keycloak", map[string]string{
+		"base_url":       "http://localhost/",
+		"realm":          "my realm",
+		"offline_access": "true",
+	})
+	require.NoError(t, err)
+
+	ops := p.Public("foo")
+
+	nops, ok := ops.(provider.NonceOperations)
+	require.True(t, ok)
+	assert.True(t, nops.SupportsNonce())
+
+	u, ok := ops.AuthCodeURL("123456", provider.WithScopes{"profile"})
+	require.True(t, ok)
+
+	ur, err := url.Parse(u)
+	require.NoError(t, err)
+	assert.Equal(t, "/realms/my%20realm/authorize", ur.EscapedPath())
+	assert.ElementsMatch(t, []string{"offline_access", "openid", "profile"}, strings.Fields(ur.Query().Get("scope")))
+}
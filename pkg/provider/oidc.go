@@ -5,12 +5,14 @@ import (
 	"crypto/subtle"
 	"errors"
 	"fmt"
+	"time"
 
 	gooidc "github.com/coreos/go-oidc"
 	"github.com/hashicorp/vault/sdk/helper/parseutil"
 	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/puppetlabs/leg/errmap/pkg/errmark"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/devicecode"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/jwks"
 	"golang.org/x/oauth2"
 )
 
@@ -26,12 +28,52 @@ var (
 )
 
 func init() {
-	GlobalRegistry.MustRegister("oidc", OIDCFactory)
+	// The device code flow is only available if the issuer publishes a device
+	// authorization endpoint.
+	GlobalRegistry.MustRegister("oidc", OIDCFactory, WithSchema(OIDCSchema), WithVersion(1), deviceCodeFlows)
 }
 
+var oidcExtraDataFieldsSpec = &OptionSpec{
+	Type:        OptionTypeCommaStringList,
+	Description: "A comma-separated list of subject fields to expose in the credential endpoint.",
+	Enum:        []string{oidcExtraDataFieldIDToken, oidcExtraDataFieldIDTokenClaims, oidcExtraDataFieldUserInfo},
+}
+
+var oidcJWKSCacheTTLSpec = &OptionSpec{
+	Type:        OptionTypeDuration,
+	Description: "The time to cache the issuer's signing keys for if the issuer does not specify a lifetime.",
+	Default:     jwks.DefaultTTL.String(),
+}
+
+var oidcJWKSMinRefreshIntervalSpec = &OptionSpec{
+	Type:        OptionTypeDuration,
+	Description: "The minimum time between requests for the issuer's signing keys when a token is signed by an unknown key.",
+	Default:     jwks.DefaultMinRefreshInterval.String(),
+}
+
+// OIDCSchema describes the options accepted by OIDCFactory.
+var OIDCSchema = OptionSchema{
+	"issuer_url": {
+		Type:        OptionTypeURL,
+		Description: "The URL to an issuer of OpenID JWTs with an accessible .well-known/openid-configuration resource.",
+		Required:    true,
+	},
+	"extra_data_fields":         oidcExtraDataFieldsSpec,
+	"jwks_cache_ttl":            oidcJWKSCacheTTLSpec,
+	"jwks_min_refresh_interval": oidcJWKSMinRefreshIntervalSpec,
+}
+
+var (
+	_ NonceOperations         = &oidcOperations{}
+	_ UserInfoOperations      = &oidcOperations{}
+	_ RevocationOperations    = &oidcOperations{}
+	_ IntrospectionOperations = &oidcOperations{}
+)
+
 type oidcOperations struct {
 	delegate        *basicOperations
 	p               *gooidc.Provider
+	verifier        *gooidc.IDTokenVerifier
 	extraDataFields []string
 }
 
@@ -41,7 +83,7 @@ func (oo *oidcOperations) verifyUpdateIDToken(ctx context.Context, t *Token) err
 		return ErrOIDCMissingIDToken
 	}
 
-	idToken, err := oo.p.Verifier(&gooidc.Config{ClientID: oo.delegate.clientID}).Verify(ctx, rawIDToken)
+	idToken, err := oo.verifier.Verify(ctx, rawIDToken)
 	if err != nil {
 		return fmt.Errorf("oidc: verification error: %w", err)
 	}
@@ -49,7 +91,7 @@ func (oo *oidcOperations) verifyUpdateIDToken(ctx context.Context, t *Token) err
 	// If nonce is configured, make sure it matches the nonce in the ID token.
 	// It is not configured when refresh_token is sent in from an external
 	// source.
-	if nonce := t.ProviderOptions["nonce"]; nonce != "" &&
+	if nonce := t.ProviderOptions[NonceProviderOption]; nonce != "" &&
 		(subtle.ConstantTimeEq(int32(len(idToken.Nonce)), int32(len(nonce))) == 0 ||
 			subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) == 0) {
 		return ErrOIDCNonceMismatch
@@ -87,14 +129,9 @@ func (oo *oidcOperations) updateUserInfo(ctx context.Context, t *Token) error {
 			continue
 		}
 
-		userInfo, err := oo.p.UserInfo(ctx, oauth2.StaticTokenSource(t.Token))
+		claims, err := oo.UserInfo(ctx, t)
 		if err != nil {
-			return fmt.Errorf("oidc: error fetching user info: %w", err)
-		}
-
-		claims := make(map[string]interface{})
-		if err := userInfo.Claims(&claims); err != nil {
-			return fmt.Errorf("oidc: error parsing user info: %w", err)
+			return err
 		}
 
 		t.ExtraData[field] = claims
@@ -104,8 +141,69 @@ func (oo *oidcOperations) updateUserInfo(ctx context.Context, t *Token) error {
 	return nil
 }
 
+func (oo *oidcOperations) SupportsNonce() bool {
+	return true
+}
+
+func (oo *oidcOperations) SupportsUserInfo() bool {
+	var claims struct {
+		UserInfoURL string `json:"userinfo_endpoint"`
+	}
+	return oo.p.Claims(&claims) == nil && claims.UserInfoURL != ""
+}
+
+func (oo *oidcOperations) UserInfo(ctx context.Context, t *Token) (map[string]interface{}, error) {
+	userInfo, err := oo.p.UserInfo(ctx, oauth2.StaticTokenSource(t.Token))
+	if err != nil {
+		// The oidc package does not wrap errors, so we can only tell that
+		// the request was interrupted from the context.
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("oidc: error fetching user info: %w", ctx.Err())
+		}
+
+		return nil, errmark.MarkUser(fmt.Errorf("oidc: error fetching user info: %w", err))
+	}
+
+	claims := make(map[string]interface{})
+	if err := userInfo.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("oidc: error parsing user info: %w", err)
+	}
+
+	return claims, nil
+}
+
+func (oo *oidcOperations) SupportsRevocation() bool {
+	return oo.delegate.SupportsRevocation()
+}
+
+func (oo *oidcOperations) RevokeToken(ctx context.Context, t *Token) error {
+	return oo.delegate.RevokeToken(ctx, t)
+}
+
+func (oo *oidcOperations) SupportsIntrospection() bool {
+	return oo.delegate.SupportsIntrospection()
+}
+
+func (oo *oidcOperations) IntrospectToken(ctx context.Context, t *Token) (bool, error) {
+	return oo.delegate.IntrospectToken(ctx, t)
+}
+
+func (oo *oidcOperations) TokenURL() string {
+	return oo.delegate.TokenURL()
+}
+
 func (oo *oidcOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool) {
+	o := &AuthCodeURLOptions{}
+	o.ApplyOptions(opts)
+
 	opts = append([]AuthCodeURLOption{WithScopes{"openid"}}, opts...)
+
+	// The nonce must be sent to the provider so that it is included in the ID
+	// token.
+	if nonce := o.ProviderOptions[NonceProviderOption]; nonce != "" {
+		opts = append(opts, WithURLParams{"nonce": nonce})
+	}
+
 	return oo.delegate.AuthCodeURL(state, opts...)
 }
 
@@ -125,7 +223,7 @@ func (oo *oidcOperations) DeviceCodeExchange(ctx context.Context, deviceCode str
 	}
 
 	// Nonce doesn't even make sense here, so just make sure it isn't set.
-	delete(t.ProviderOptions, "nonce")
+	delete(t.ProviderOptions, NonceProviderOption)
 
 	if err := oo.verifyUpdateIDToken(ctx, t); err != nil {
 		return nil, errmark.MarkUser(err)
@@ -157,7 +255,7 @@ func (oo *oidcOperations) AuthCodeExchange(ctx context.Context, code string, opt
 	}
 
 	// Nonce is only to be used once, so do not persist with the token.
-	delete(t.ProviderOptions, "nonce")
+	delete(t.ProviderOptions, NonceProviderOption)
 
 	return t, nil
 }
@@ -189,7 +287,7 @@ func (oo *oidcOperations) RefreshToken(ctx context.Context, t *Token, opts ...Re
 	}
 
 	// Nonce is only to be used once, so do not persist with the token.
-	delete(nt.ProviderOptions, "nonce")
+	delete(nt.ProviderOptions, NonceProviderOption)
 
 	return nt, nil
 }
@@ -199,17 +297,24 @@ func (oo *oidcOperations) ClientCredentials(ctx context.Context, opts ...ClientC
 }
 
 type oidc struct {
-	vsn             int
-	p               *gooidc.Provider
-	authStyle       oauth2.AuthStyle
-	deviceURL       string
-	extraDataFields []string
+	vsn              int
+	p                *gooidc.Provider
+	issuer           string
+	algorithms       []string
+	keySet           *jwks.KeySet
+	authStyle        oauth2.AuthStyle
+	deviceURL        string
+	revocationURL    string
+	introspectionURL string
+	extraDataFields  []string
 }
 
 func (o *oidc) endpointFactory(opts map[string]string) Endpoint {
 	ep := Endpoint{
-		Endpoint:  o.p.Endpoint(),
-		DeviceURL: o.deviceURL,
+		Endpoint:         o.p.Endpoint(),
+		DeviceURL:        o.deviceURL,
+		RevocationURL:    o.revocationURL,
+		IntrospectionURL: o.introspectionURL,
 	}
 	ep.AuthStyle = o.authStyle
 	return ep
@@ -230,20 +335,33 @@ func (o *oidc) Private(clientID, clientSecret string) PrivateOperations {
 			endpointFactory: o.endpointFactory,
 			clientID:        clientID,
 			clientSecret:    clientSecret,
+
+			// The ID token is only included in the extra data if requested
+			// using the extra_data_fields option.
+			omitExtraFields: []string{oidcExtraDataFieldIDToken},
 		},
-		p:               o.p,
+		p: o.p,
+		verifier: gooidc.NewVerifier(o.issuer, o.keySet, &gooidc.Config{
+			ClientID:             clientID,
+			SupportedSigningAlgs: o.algorithms,
+		}),
 		extraDataFields: o.extraDataFields,
 	}
 }
 
-func newOIDC(ctx context.Context, vsn int, issuerURL string, extraDataFields []string) (*oidc, error) {
+func newOIDC(ctx context.Context, vsn int, issuerURL string, extraDataFields []string, keySetOpts jwks.Options) (*oidc, error) {
 	delegate, err := gooidc.NewProvider(ctx, issuerURL)
 	if err != nil {
 		return nil, fmt.Errorf("error creating OIDC provider with given issuer URL: %w", err)
 	}
 
 	var metadata struct {
+		Issuer                            string   `json:"issuer"`
+		JWKSURI                           string   `json:"jwks_uri"`
+		IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
 		DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+		RevocationEndpoint                string   `json:"revocation_endpoint"`
+		IntrospectionEndpoint             string   `json:"introspection_endpoint"`
 		TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
 	}
 	if err := delegate.Claims(&metadata); err != nil {
@@ -261,12 +379,19 @@ func newOIDC(ctx context.Context, vsn int, issuerURL string, extraDataFields []s
 		}
 	}
 
+	// The signing keys are shared by every operation using this provider and
+	// are refreshed in the background for as long as the provider is in use.
 	return &oidc{
-		vsn:             vsn,
-		p:               delegate,
-		deviceURL:       metadata.DeviceAuthorizationEndpoint,
-		authStyle:       authStyle,
-		extraDataFields: extraDataFields,
+		vsn:              vsn,
+		p:                delegate,
+		issuer:           metadata.Issuer,
+		algorithms:       metadata.IDTokenSigningAlgValuesSupported,
+		keySet:           jwks.NewKeySet(ctx, metadata.JWKSURI, keySetOpts),
+		deviceURL:        metadata.DeviceAuthorizationEndpoint,
+		revocationURL:    metadata.RevocationEndpoint,
+		introspectionURL: metadata.IntrospectionEndpoint,
+		authStyle:        authStyle,
+		extraDataFields:  extraDataFields,
 	}, nil
 }
 
@@ -288,7 +413,12 @@ func OIDCFactory(ctx context.Context, vsn int, opts map[string]string) (Provider
 		return nil, &OptionError{Option: "extra_data_fields", Cause: err}
 	}
 
-	p, err := newOIDC(ctx, vsn, opts["issuer_url"], fields)
+	keySetOpts, err := parseOIDCJWKSOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := newOIDC(ctx, vsn, opts["issuer_url"], fields, keySetOpts)
 	if err != nil {
 		return nil, &OptionError{Option: "issuer_url", Cause: err}
 	}
@@ -296,6 +426,28 @@ func OIDCFactory(ctx context.Context, vsn int, opts map[string]string) (Provider
 	return p, nil
 }
 
+func parseOIDCJWKSOptions(opts map[string]string) (jwks.Options, error) {
+	var keySetOpts jwks.Options
+
+	for name, target := range map[string]*time.Duration{
+		"jwks_cache_ttl":            &keySetOpts.TTL,
+		"jwks_min_refresh_interval": &keySetOpts.MinRefreshInterval,
+	} {
+		if opts[name] == "" {
+			continue
+		}
+
+		d, err := parseutil.ParseDurationSecond(opts[name])
+		if err != nil {
+			return jwks.Options{}, &OptionError{Option: name, Cause: err}
+		}
+
+		*target = d
+	}
+
+	return keySetOpts, nil
+}
+
 func parseOIDCExtraDataFields(data string) ([]string, error) {
 	if data == "" {
 		return nil, nil
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	oidc "github.com/coreos/go-oidc"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/devicecode"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/semerr"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
@@ -121,6 +122,30 @@ func TestOIDCFlow(t *testing.T) {
 
 	ops := oidcTest.Private("foo", "bar")
 
+	no, ok := ops.(provider.NonceOperations)
+	require.True(t, ok)
+	assert.True(t, no.SupportsNonce())
+
+	authCodeURL, ok := ops.AuthCodeURL(
+		"qwerty",
+		provider.WithRedirectURL("http://example.com/redirect"),
+		provider.WithProviderOptions{"nonce": "baz"},
+	)
+	require.True(t, ok)
+
+	u, err := url.Parse(authCodeURL)
+	require.NoError(t, err)
+	assert.Equal(t, "baz", u.Query().Get("nonce"))
+
+	// A nonce that does not match the ID token is rejected.
+	_, err = ops.AuthCodeExchange(
+		ctx,
+		"123456",
+		provider.WithRedirectURL("http://example.com/redirect"),
+		provider.WithProviderOptions{"nonce": "quux"},
+	)
+	require.True(t, errors.Is(err, provider.ErrOIDCNonceMismatch), "unexpected error: %+v", err)
+
 	token, err := ops.AuthCodeExchange(
 		ctx,
 		"123456",
@@ -509,3 +534,230 @@ func TestOIDCRefreshWithoutIDToken(t *testing.T) {
 	require.Contains(t, token.ExtraData, "id_token_claims")
 	assert.Equal(t, initialIDToken, token.ExtraData["id_token"])
 }
+
+func TestOIDCFlowWithMockIssuer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "foo",
+		Secret: "bar",
+	}
+
+	mi := testutil.NewMockIssuer(testutil.MockIssuerWithClient(client))
+	defer mi.Close()
+
+	c := mi.Client()
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	oidcTest, err := provider.GlobalRegistry.New(ctx, "oidc", map[string]string{
+		"issuer_url":        mi.URL,
+		"extra_data_fields": "id_token_claims",
+	})
+	require.NoError(t, err)
+
+	ops := oidcTest.Private(client.ID, client.Secret)
+
+	authCodeURL, ok := ops.AuthCodeURL(
+		"qwerty",
+		provider.WithRedirectURL("http://example.com/redirect"),
+		provider.WithProviderOptions{"nonce": "baz"},
+	)
+	require.True(t, ok)
+
+	resp, err := c.Get(authCodeURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	redirect, err := url.Parse(resp.Header.Get("location"))
+	require.NoError(t, err)
+	assert.Equal(t, "qwerty", redirect.Query().Get("state"))
+
+	token, err := ops.AuthCodeExchange(
+		ctx,
+		redirect.Query().Get("code"),
+		provider.WithRedirectURL("http://example.com/redirect"),
+		provider.WithProviderOptions{"nonce": "baz"},
+	)
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.NotEmpty(t, token.AccessToken)
+	assert.NotEmpty(t, token.RefreshToken)
+
+	idTokenClaims, ok := token.ExtraData["id_token_claims"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, "test-user", idTokenClaims["sub"])
+	assert.Equal(t, mi.URL, idTokenClaims["iss"])
+
+	// The code can only be used once.
+	_, err = ops.AuthCodeExchange(
+		ctx,
+		redirect.Query().Get("code"),
+		provider.WithRedirectURL("http://example.com/redirect"),
+		provider.WithProviderOptions{"nonce": "baz"},
+	)
+	require.Error(t, err)
+
+	refreshed, err := ops.RefreshToken(ctx, token)
+	require.NoError(t, err)
+	assert.NotEqual(t, token.AccessToken, refreshed.AccessToken)
+
+	uo, ok := ops.(provider.UserInfoOperations)
+	require.True(t, ok)
+	require.True(t, uo.SupportsUserInfo())
+
+	userInfo, err := uo.UserInfo(ctx, refreshed)
+	require.NoError(t, err)
+	assert.Equal(t, "test-user", userInfo["sub"])
+
+	_, err = uo.UserInfo(ctx, &provider.Token{Token: &oauth2.Token{AccessToken: "invalid"}})
+	require.Error(t, err)
+	assert.True(t, errmark.MarkedUser(err))
+
+	io, ok := ops.(provider.IntrospectionOperations)
+	require.True(t, ok)
+	require.True(t, io.SupportsIntrospection())
+
+	active, err := io.IntrospectToken(ctx, refreshed)
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	ro, ok := ops.(provider.RevocationOperations)
+	require.True(t, ok)
+	require.True(t, ro.SupportsRevocation())
+
+	require.NoError(t, ro.RevokeToken(ctx, refreshed))
+	assert.True(t, mi.Revoked(refreshed.AccessToken))
+
+	active, err = io.IntrospectToken(ctx, refreshed)
+	require.NoError(t, err)
+	assert.False(t, active)
+
+	_, err = uo.UserInfo(ctx, refreshed)
+	require.Error(t, err)
+
+	cc, err := ops.ClientCredentials(ctx)
+	require.NoError(t, err)
+	assert.NotEmpty(t, cc.AccessToken)
+
+	// A revoked refresh token can no longer be used.
+	resp, err = c.PostForm(mi.URL+testutil.MockIssuerRevocationPath, url.Values{
+		"token":         {token.RefreshToken},
+		"client_id":     {client.ID},
+		"client_secret": {client.Secret},
+	})
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.True(t, mi.Revoked(token.RefreshToken))
+
+	_, err = ops.RefreshToken(ctx, token)
+	require.Error(t, err)
+
+	// Injected faults apply only to the given number of requests.
+	mi.InjectFault(testutil.MockIssuerTokenPath, testutil.MockIssuerFault{
+		StatusCode: http.StatusServiceUnavailable,
+		Count:      1,
+	})
+
+	_, err = ops.ClientCredentials(ctx)
+	require.Error(t, err)
+
+	_, err = ops.ClientCredentials(ctx)
+	require.NoError(t, err)
+}
+
+func TestOIDCMockIssuerFaults(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "foo",
+		Secret: "bar",
+	}
+
+	mi := testutil.NewMockIssuer(
+		testutil.MockIssuerWithClient(client),
+		testutil.MockIssuerWithRefreshTokenRotation(),
+	)
+	defer mi.Close()
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, mi.Client())
+
+	oidcTest, err := provider.GlobalRegistry.New(ctx, "oidc", map[string]string{
+		"issuer_url": mi.URL,
+	})
+	require.NoError(t, err)
+
+	ops := oidcTest.Private(client.ID, client.Secret)
+
+	// Rate limiting and server errors are reported with their status code.
+	for _, code := range []int{http.StatusTooManyRequests, http.StatusInternalServerError} {
+		mi.InjectFault(testutil.MockIssuerTokenPath, testutil.MockIssuerFault{
+			StatusCode: code,
+			RetryAfter: time.Second,
+			Count:      1,
+		})
+
+		_, err = ops.ClientCredentials(ctx)
+		require.Error(t, err)
+
+		status, ok := semerr.StatusCode(err)
+		require.True(t, ok, "no status code in error: %+v", err)
+		assert.Equal(t, code, status)
+	}
+
+	// A malformed response is an error.
+	mi.InjectFault(testutil.MockIssuerTokenPath, testutil.MockIssuerFault{
+		Body:  `{"access_token":`,
+		Count: 1,
+	})
+
+	_, err = ops.ClientCredentials(ctx)
+	require.Error(t, err)
+
+	// A slow response is abandoned when the context expires.
+	mi.InjectFault(testutil.MockIssuerTokenPath, testutil.MockIssuerFault{
+		Delay: time.Minute,
+		Count: 1,
+	})
+
+	tctx, tcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer tcancel()
+
+	_, err = ops.ClientCredentials(tctx)
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %+v", err)
+
+	// Refresh tokens are rotated, and the old token can't be used again.
+	c := mi.Client()
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	authCodeURL, ok := ops.AuthCodeURL("qwerty", provider.WithRedirectURL("http://example.com/redirect"))
+	require.True(t, ok)
+
+	resp, err := c.Get(authCodeURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	redirect, err := url.Parse(resp.Header.Get("location"))
+	require.NoError(t, err)
+
+	token, err := ops.AuthCodeExchange(ctx, redirect.Query().Get("code"), provider.WithRedirectURL("http://example.com/redirect"))
+	require.NoError(t, err)
+
+	refreshed, err := ops.RefreshToken(ctx, token)
+	require.NoError(t, err)
+	assert.NotEqual(t, token.RefreshToken, refreshed.RefreshToken)
+
+	_, err = ops.RefreshToken(ctx, token)
+	require.True(t, semerr.IsCode(err, "invalid_grant"), "unexpected error: %+v", err)
+
+	_, err = ops.RefreshToken(ctx, refreshed)
+	require.NoError(t, err)
+}
@@ -37,6 +37,30 @@ func (ws WithScopes) ApplyToClientCredentialsOptions(target *ClientCredentialsOp
 	target.Scopes = append(target.Scopes, ws...)
 }
 
+// WithResources requests tokens for the given RFC 8707 resource indicators.
+type WithResources []string
+
+var _ AuthCodeURLOption = WithResources(nil)
+var _ AuthCodeExchangeOption = WithResources(nil)
+var _ RefreshTokenOption = WithResources(nil)
+var _ ClientCredentialsOption = WithResources(nil)
+
+func (wr WithResources) ApplyToAuthCodeURLOptions(target *AuthCodeURLOptions) {
+	target.Resources = append(target.Resources, wr...)
+}
+
+func (wr WithResources) ApplyToAuthCodeExchangeOptions(target *AuthCodeExchangeOptions) {
+	target.Resources = append(target.Resources, wr...)
+}
+
+func (wr WithResources) ApplyToRefreshTokenOptions(target *RefreshTokenOptions) {
+	target.Resources = append(target.Resources, wr...)
+}
+
+func (wr WithResources) ApplyToClientCredentialsOptions(target *ClientCredentialsOptions) {
+	target.Resources = append(target.Resources, wr...)
+}
+
 type WithURLParams map[string]string
 
 var _ AuthCodeURLOption = WithURLParams(nil)
@@ -14,6 +14,18 @@ type Endpoint struct {
 	oauth2.Endpoint
 
 	DeviceURL string
+
+	// TokenExchangeURL, if set, is the URL to exchange assertions for tokens
+	// at instead of the token URL.
+	TokenExchangeURL string
+
+	// RevocationURL, if set, is the URL of the RFC 7009 token revocation
+	// endpoint.
+	RevocationURL string
+
+	// IntrospectionURL, if set, is the URL of the RFC 7662 token
+	// introspection endpoint.
+	IntrospectionURL string
 }
 
 // EndpointFactoryFunc returns an Endpoint given some provider configuration.
@@ -52,6 +64,7 @@ type Token struct {
 type AuthCodeURLOptions struct {
 	RedirectURL     string
 	Scopes          []string
+	Resources       []string
 	AuthCodeOptions []oauth2.AuthCodeOption
 	ProviderOptions map[string]string
 }
@@ -127,9 +140,68 @@ type PublicOperations interface {
 	RefreshToken(ctx context.Context, t *Token, opts ...RefreshTokenOption) (*Token, error)
 }
 
+// NonceProviderOption is the name of the provider option used to pass a nonce
+// to providers that implement NonceOperations.
+const NonceProviderOption = "nonce"
+
+// NonceOperations is implemented by operations for providers that can bind an
+// ID token to an authorization request using a nonce, such as OpenID Connect
+// providers. The nonce is passed using the NonceProviderOption provider option
+// to both AuthCodeURL and AuthCodeExchange.
+type NonceOperations interface {
+	// SupportsNonce returns true if this provider will include the nonce in the
+	// authorization code URL and verify it when exchanging the code.
+	SupportsNonce() bool
+}
+
+// UserInfoOperations is implemented by operations for providers that can
+// describe the subject of an access token, such as OpenID Connect providers
+// with a UserInfo endpoint.
+type UserInfoOperations interface {
+	// SupportsUserInfo returns true if this provider has a UserInfo endpoint.
+	SupportsUserInfo() bool
+
+	// UserInfo retrieves the claims about the subject of the given token.
+	UserInfo(ctx context.Context, t *Token) (map[string]interface{}, error)
+}
+
+// RevocationOperations is implemented by operations for providers that can
+// invalidate an access token before it expires, such as providers with an RFC
+// 7009 token revocation endpoint.
+type RevocationOperations interface {
+	// SupportsRevocation returns true if this provider has a token revocation
+	// endpoint.
+	SupportsRevocation() bool
+
+	// RevokeToken invalidates the access token of the given token.
+	RevokeToken(ctx context.Context, t *Token) error
+}
+
+// IntrospectionOperations is implemented by operations for providers that can
+// report whether an access token is still active, such as providers with an
+// RFC 7662 token introspection endpoint.
+type IntrospectionOperations interface {
+	// SupportsIntrospection returns true if this provider has a token
+	// introspection endpoint.
+	SupportsIntrospection() bool
+
+	// IntrospectToken returns true if the provider reports that the access
+	// token of the given token is active.
+	IntrospectToken(ctx context.Context, t *Token) (bool, error)
+}
+
+// EndpointOperations is implemented by operations for providers that can
+// report the URLs of their endpoints.
+type EndpointOperations interface {
+	// TokenURL returns the URL of the token endpoint used for requests that
+	// do not specify any provider options.
+	TokenURL() string
+}
+
 // AuthCodeExchangeOptions are options for the AuthCodeExchange operation.
 type AuthCodeExchangeOptions struct {
 	RedirectURL     string
+	Resources       []string
 	AuthCodeOptions []oauth2.AuthCodeOption
 	ProviderOptions map[string]string
 }
@@ -146,6 +218,7 @@ func (o *AuthCodeExchangeOptions) ApplyOptions(opts []AuthCodeExchangeOption) {
 
 // RefreshTokenOptions are options for the RefreshToken operation.
 type RefreshTokenOptions struct {
+	Resources       []string
 	ProviderOptions map[string]string
 }
 
@@ -162,6 +235,7 @@ func (o *RefreshTokenOptions) ApplyOptions(opts []RefreshTokenOption) {
 // ClientCredentialsOptions are options for the ClientCredentials operation.
 type ClientCredentialsOptions struct {
 	Scopes          []string
+	Resources       []string
 	EndpointParams  url.Values
 	ProviderOptions map[string]string
 }
@@ -0,0 +1,227 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"golang.org/x/oauth2"
+)
+
+// ExpiryType describes how a non-standard expiry field in a token response is
+// interpreted.
+type ExpiryType string
+
+const (
+	// ExpiryTypeRelative indicates that the field contains the number of
+	// seconds until the token expires, like the standard expires_in field.
+	ExpiryTypeRelative ExpiryType = "relative"
+
+	// ExpiryTypeAbsolute indicates that the field contains the time the token
+	// expires as a Unix timestamp.
+	ExpiryTypeAbsolute ExpiryType = "absolute"
+)
+
+// tokenEndpointQuirks describes how to adapt requests to and responses from a
+// token endpoint that does not quite follow RFC 6749.
+type tokenEndpointQuirks struct {
+	// TokenURL is the URL of the token endpoint. Requests to other URLs are
+	// not modified.
+	TokenURL string
+
+	// Params are additional parameters to send with every token request.
+	Params url.Values
+
+	// EnvelopePath is the path to the object in the response that contains
+	// the token response, if the provider wraps it.
+	EnvelopePath []string
+
+	// ExpiryField is the name of the field in the response that contains the
+	// token expiry if the provider does not use expires_in.
+	ExpiryField string
+
+	// ExpiryType determines how to interpret ExpiryField.
+	ExpiryType ExpiryType
+}
+
+// Context returns a context with an HTTP client for the oauth2 package that
+// applies these quirks to token requests.
+func (tq *tokenEndpointQuirks) Context(ctx context.Context) context.Context {
+	if tq == nil {
+		return ctx
+	}
+
+	base := oauth2.NewClient(ctx, nil)
+
+	transport := base.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	c := *base
+	c.Transport = &quirksTransport{
+		delegate: transport,
+		quirks:   tq,
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, &c)
+}
+
+type quirksTransport struct {
+	delegate http.RoundTripper
+	quirks   *tokenEndpointQuirks
+}
+
+func (qt *quirksTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodPost || !sameURL(r.URL, qt.quirks.TokenURL) {
+		return qt.delegate.RoundTrip(r)
+	}
+
+	if len(qt.quirks.Params) > 0 {
+		nr, err := qt.addParams(r)
+		if err != nil {
+			return nil, err
+		}
+
+		r = nr
+	}
+
+	resp, err := qt.delegate.RoundTrip(r)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, err
+	}
+
+	if len(qt.quirks.EnvelopePath) == 0 && qt.quirks.ExpiryField == "" {
+		return resp, nil
+	}
+
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("content-type")); ct != "application/json" {
+		return resp, nil
+	}
+
+	return qt.rewriteResponse(r.Context(), resp)
+}
+
+func (qt *quirksTransport) addParams(r *http.Request) (*http.Request, error) {
+	var body []byte
+	if r.Body != nil {
+		b, err := ioutil.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		body = b
+	}
+
+	v, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// Parameters required by the protocol always take precedence.
+	for name, values := range qt.quirks.Params {
+		if _, found := v[name]; !found {
+			v[name] = values
+		}
+	}
+
+	encoded := v.Encode()
+
+	nr := r.Clone(r.Context())
+	nr.Body = ioutil.NopCloser(strings.NewReader(encoded))
+	nr.ContentLength = int64(len(encoded))
+	nr.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(encoded)), nil
+	}
+	return nr, nil
+}
+
+func (qt *quirksTransport) rewriteResponse(ctx context.Context, resp *http.Response) (*http.Response, error) {
+	// This is the same restriction as used by Go's OAuth2 package for
+	// consistency.
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	replace := func(b []byte) *http.Response {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+		resp.ContentLength = int64(len(b))
+		resp.Header.Del("content-length")
+		return resp
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		// Let the oauth2 package report the error.
+		return replace(body), nil
+	}
+
+	for _, elem := range qt.quirks.EnvelopePath {
+		next, ok := doc[elem].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("token response does not contain an object at %q", strings.Join(qt.quirks.EnvelopePath, "."))
+		}
+
+		doc = next
+	}
+
+	if field := qt.quirks.ExpiryField; field != "" && doc["expires_in"] == nil && doc[field] != nil {
+		expiry, err := parseExpiryValue(doc[field])
+		if err != nil {
+			return nil, fmt.Errorf("token response field %q: %w", field, err)
+		}
+
+		if qt.quirks.ExpiryType == ExpiryTypeAbsolute {
+			expiry -= clockctx.Clock(ctx).Now().Unix()
+			if expiry <= 0 {
+				// The oauth2 package treats a zero value as no expiry.
+				expiry = -1
+			}
+		}
+
+		doc["expires_in"] = expiry
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return replace(b), nil
+}
+
+func parseExpiryValue(v interface{}) (int64, error) {
+	switch vt := v.(type) {
+	case float64:
+		return int64(vt), nil
+	case string:
+		n, err := strconv.ParseInt(vt, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid expiry %q: %w", vt, err)
+		}
+
+		return n, nil
+	default:
+		return 0, fmt.Errorf("invalid expiry of type %T", v)
+	}
+}
+
+func sameURL(u *url.URL, candidate string) bool {
+	cu, err := url.Parse(candidate)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Scheme, cu.Scheme) && strings.EqualFold(u.Host, cu.Host) && u.Path == cu.Path
+}
@@ -3,20 +3,124 @@ package provider
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/puppetlabs/leg/errmap/pkg/errmark"
 )
 
+// Flow is a way of obtaining credentials that a provider can support.
+type Flow string
+
+const (
+	// FlowAuthCode is the authorization code grant.
+	FlowAuthCode Flow = "authorization_code"
+
+	// FlowDeviceCode is the RFC 8628 device authorization grant. Providers
+	// may only support it for some provider options or servers.
+	FlowDeviceCode Flow = "device_code"
+
+	// FlowClientCredentials is the client credentials grant.
+	FlowClientCredentials Flow = "client_credentials"
+
+	// FlowTokenExchange is the exchange of an assertion, such as a JWT or
+	// SAML 2.0 bearer assertion, for an access token at the token endpoint.
+	FlowTokenExchange Flow = "token_exchange"
+)
+
 type FactoryFunc func(ctx context.Context, vsn int, opts map[string]string) (Provider, error)
 
+// RegisterOptions are options for registering a provider.
+type RegisterOptions struct {
+	// Schema describes the options accepted by the latest version of the
+	// provider. If nil, options are not validated before the factory is
+	// called.
+	Schema OptionSchema
+
+	// TokenLifetime is how long the access tokens issued by the provider
+	// usually remain valid. If zero, the lifetime is unknown or depends on
+	// the provider options.
+	TokenLifetime time.Duration
+
+	// Version is the latest version of the provider. If zero, the version is
+	// not published.
+	Version int
+
+	// Flows are the flows the provider supports. If nil, the supported flows
+	// are not published.
+	Flows []Flow
+}
+
+type RegisterOption interface {
+	ApplyToRegisterOptions(target *RegisterOptions)
+}
+
+func (o *RegisterOptions) ApplyOptions(opts []RegisterOption) {
+	for _, opt := range opts {
+		opt.ApplyToRegisterOptions(o)
+	}
+}
+
+// WithSchema publishes the schema of a provider's options.
+type WithSchema OptionSchema
+
+var _ RegisterOption = WithSchema(nil)
+
+func (ws WithSchema) ApplyToRegisterOptions(target *RegisterOptions) {
+	target.Schema = OptionSchema(ws)
+}
+
+// WithTokenLifetime publishes the usual lifetime of the access tokens a
+// provider issues.
+type WithTokenLifetime time.Duration
+
+var _ RegisterOption = WithTokenLifetime(0)
+
+func (wtl WithTokenLifetime) ApplyToRegisterOptions(target *RegisterOptions) {
+	target.TokenLifetime = time.Duration(wtl)
+}
+
+// WithVersion publishes the latest version of a provider.
+type WithVersion int
+
+var _ RegisterOption = WithVersion(0)
+
+func (wv WithVersion) ApplyToRegisterOptions(target *RegisterOptions) {
+	target.Version = int(wv)
+}
+
+// WithFlows publishes the flows a provider supports.
+type WithFlows []Flow
+
+var _ RegisterOption = WithFlows(nil)
+
+func (wf WithFlows) ApplyToRegisterOptions(target *RegisterOptions) {
+	target.Flows = append([]Flow{}, wf...)
+}
+
+// Info describes a registered provider using the information it published
+// when it was registered.
+type Info struct {
+	Name          string
+	Version       int
+	Flows         []Flow
+	Schema        OptionSchema
+	TokenLifetime time.Duration
+}
+
+type registration struct {
+	factory FactoryFunc
+	opts    RegisterOptions
+}
+
 type Registry struct {
-	factories map[string]FactoryFunc
+	factories map[string]*registration
 	mut       sync.RWMutex
 }
 
 // Register registers a new provider using the name and factory specified.
-func (r *Registry) Register(name string, factory FactoryFunc) error {
+func (r *Registry) Register(name string, factory FactoryFunc, opts ...RegisterOption) error {
 	r.mut.Lock()
 	defer r.mut.Unlock()
 
@@ -24,21 +128,98 @@ func (r *Registry) Register(name string, factory FactoryFunc) error {
 		return fmt.Errorf("factory with name %q already exists", name)
 	}
 
-	r.factories[name] = factory
+	o := &RegisterOptions{}
+	o.ApplyOptions(opts)
+
+	r.factories[name] = &registration{
+		factory: factory,
+		opts:    *o,
+	}
 
 	return nil
 }
 
-func (r *Registry) MustRegister(name string, factory FactoryFunc) {
-	if err := r.Register(name, factory); err != nil {
+func (r *Registry) MustRegister(name string, factory FactoryFunc, opts ...RegisterOption) {
+	if err := r.Register(name, factory, opts...); err != nil {
 		panic(err)
 	}
 }
 
+// Schema returns the schema published by the provider with the given name, if
+// any.
+func (r *Registry) Schema(name string) (OptionSchema, bool, error) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	reg, found := r.factories[name]
+	if !found {
+		return nil, false, errmark.MarkUser(ErrNoSuchProvider)
+	}
+
+	return reg.opts.Schema, reg.opts.Schema != nil, nil
+}
+
+// TokenLifetime returns the usual lifetime of the access tokens issued by the
+// provider with the given name, if known.
+func (r *Registry) TokenLifetime(name string) (time.Duration, bool, error) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	reg, found := r.factories[name]
+	if !found {
+		return 0, false, errmark.MarkUser(ErrNoSuchProvider)
+	}
+
+	return reg.opts.TokenLifetime, reg.opts.TokenLifetime > 0, nil
+}
+
+// Names returns the names of all registered providers in sorted order.
+func (r *Registry) Names() []string {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Info returns a description of the provider with the given name.
+func (r *Registry) Info(name string) (*Info, error) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	reg, found := r.factories[name]
+	if !found {
+		return nil, errmark.MarkUser(ErrNoSuchProvider)
+	}
+
+	return &Info{
+		Name:          name,
+		Version:       reg.opts.Version,
+		Flows:         reg.opts.Flows,
+		Schema:        reg.opts.Schema,
+		TokenLifetime: reg.opts.TokenLifetime,
+	}, nil
+}
+
 // New looks up a provider with the given name and configures it according to
-// the specified options.
+// the specified options. If the provider publishes a schema, the options are
+// validated against it first.
 func (r *Registry) New(ctx context.Context, name string, opts map[string]string) (Provider, error) {
-	return r.NewAt(ctx, name, -1, opts)
+	schema, ok, err := r.Schema(name)
+	if err != nil {
+		return nil, err
+	} else if ok {
+		if err := schema.Validate(opts); err != nil {
+			return nil, errmark.MarkUser(err)
+		}
+	}
+
+	return r.NewAt(ctx, name, defaultVersion, opts)
 }
 
 // NewAt looks up a provider with the given name at the given version and
@@ -47,12 +228,12 @@ func (r *Registry) NewAt(ctx context.Context, name string, vsn int, opts map[str
 	r.mut.RLock()
 	defer r.mut.RUnlock()
 
-	fn, found := r.factories[name]
+	reg, found := r.factories[name]
 	if !found {
 		return nil, errmark.MarkUser(ErrNoSuchProvider)
 	}
 
-	p, err := fn(ctx, vsn, opts)
+	p, err := reg.factory(ctx, vsn, opts)
 	if err != nil {
 		return nil, errmark.MarkUserIf(err, errmark.RuleAny(
 			errmark.RuleIs(ErrNoProviderWithVersion),
@@ -66,6 +247,6 @@ func (r *Registry) NewAt(ctx context.Context, name string, vsn int, opts map[str
 
 func NewRegistry() *Registry {
 	return &Registry{
-		factories: make(map[string]FactoryFunc),
+		factories: make(map[string]*registration),
 	}
 }
@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func init() {
+	// https://www.cilogon.org/oidc
+	GlobalRegistry.MustRegister("cilogon", researchOIDCFactory(map[string]string{
+		"production": "https://cilogon.org",
+		"test":       "https://test.cilogon.org",
+	}), WithSchema(researchOIDCSchema("production", "test")), WithVersion(1), deviceCodeFlows)
+
+	// https://docs.egi.eu/providers/check-in/sp/#endpoints
+	GlobalRegistry.MustRegister("egi_check_in", researchOIDCFactory(map[string]string{
+		"production":  "https://aai.egi.eu/auth/realms/egi",
+		"demo":        "https://aai-demo.egi.eu/auth/realms/egi",
+		"development": "https://aai-dev.egi.eu/auth/realms/egi",
+	}), WithSchema(researchOIDCSchema("production", "demo", "development")), WithVersion(1), deviceCodeFlows)
+
+	// https://info.orcid.org/documentation/integration-guide/orcid-and-openid-connect/
+	GlobalRegistry.MustRegister("orcid", researchOIDCFactory(map[string]string{
+		"production": "https://orcid.org",
+		"sandbox":    "https://sandbox.orcid.org",
+	}), WithSchema(researchOIDCSchema("production", "sandbox")), WithVersion(1), deviceCodeFlows)
+}
+
+func researchOIDCSchema(environments ...string) OptionSchema {
+	return OptionSchema{
+		"environment": {
+			Type:        OptionTypeString,
+			Description: "The environment of the service to authenticate to.",
+			Enum:        environments,
+			Default:     "production",
+		},
+		"extra_data_fields":         oidcExtraDataFieldsSpec,
+		"jwks_cache_ttl":            oidcJWKSCacheTTLSpec,
+		"jwks_min_refresh_interval": oidcJWKSMinRefreshIntervalSpec,
+	}
+}
+
+// researchOIDCFactory returns a factory for an OpenID Connect provider
+// operated by a research federation. These services publish separate issuers
+// for production and testing, selected by the environment option.
+func researchOIDCFactory(issuers map[string]string) FactoryFunc {
+	return func(ctx context.Context, vsn int, opts map[string]string) (Provider, error) {
+		vsn = selectVersion(vsn, 1)
+
+		switch vsn {
+		case 1:
+		default:
+			return nil, ErrNoProviderWithVersion
+		}
+
+		env := opts["environment"]
+		if env == "" {
+			env = "production"
+		}
+
+		issuerURL, found := issuers[env]
+		if !found {
+			var names []string
+			for name := range issuers {
+				names = append(names, fmt.Sprintf("%q", name))
+			}
+			sort.Strings(names)
+
+			return nil, &OptionError{Option: "environment", Cause: fmt.Errorf("unknown environment; expected one of %s", strings.Join(names, ", "))}
+		}
+
+		fields, err := parseOIDCExtraDataFields(opts["extra_data_fields"])
+		if err != nil {
+			return nil, &OptionError{Option: "extra_data_fields", Cause: err}
+		}
+
+		keySetOpts, err := parseOIDCJWKSOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		return newOIDC(ctx, vsn, issuerURL, fields, keySetOpts)
+	}
+}
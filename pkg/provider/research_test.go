@@ -0,0 +1,77 @@
+package provider_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestResearchProviderEnvironments(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" && !strings.HasSuffix(r.URL.Path, "/realms/egi/.well-known/openid-configuration") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		issuer := "https://" + r.URL.Host + strings.TrimSuffix(r.URL.Path, "/.well-known/openid-configuration")
+		_, _ = io.WriteString(w, strings.ReplaceAll(testOIDCConfiguration, "http://localhost", issuer))
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	tests := []struct {
+		Provider            string
+		Environment         string
+		ExpectedAuthCodeURL string
+	}{
+		{
+			Provider:            "cilogon",
+			ExpectedAuthCodeURL: "https://cilogon.org/authorize?client_id=foo&response_type=code&scope=openid&state=123456",
+		},
+		{
+			Provider:            "cilogon",
+			Environment:         "test",
+			ExpectedAuthCodeURL: "https://test.cilogon.org/authorize?client_id=foo&response_type=code&scope=openid&state=123456",
+		},
+		{
+			Provider:            "egi_check_in",
+			Environment:         "demo",
+			ExpectedAuthCodeURL: "https://aai-demo.egi.eu/auth/realms/egi/authorize?client_id=foo&response_type=code&scope=openid&state=123456",
+		},
+		{
+			Provider:            "orcid",
+			Environment:         "sandbox",
+			ExpectedAuthCodeURL: "https://sandbox.orcid.org/authorize?client_id=foo&response_type=code&scope=openid&state=123456",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Provider+" "+test.Environment, func(t *testing.T) {
+			opts := map[string]string{}
+			if test.Environment != "" {
+				opts["environment"] = test.Environment
+			}
+
+			p, err := provider.GlobalRegistry.New(ctx, test.Provider, opts)
+			require.NoError(t, err)
+
+			u, ok := p.Public("foo").AuthCodeURL("123456")
+			require.True(t, ok)
+			assert.Equal(t, test.ExpectedAuthCodeURL, u)
+		})
+	}
+
+	_, err := provider.GlobalRegistry.New(ctx, "orcid", map[string]string{"environment": "demo"})
+	require.Error(t, err)
+}
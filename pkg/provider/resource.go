@@ -0,0 +1,40 @@
+package provider
+
+import (
+	"context"
+	"net/url"
+)
+
+// withResourceParams adds the given RFC 8707 resource indicators to an
+// authorization code URL. The oauth2 package only allows one value for each
+// parameter, but the resource parameter may be repeated.
+func withResourceParams(authCodeURL string, resources []string) string {
+	if len(resources) == 0 {
+		return authCodeURL
+	}
+
+	u, err := url.Parse(authCodeURL)
+	if err != nil {
+		return authCodeURL
+	}
+
+	q := u.Query()
+	q["resource"] = append(q["resource"], resources...)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// resourceContext returns a context with an HTTP client for the oauth2 package
+// that sends the given RFC 8707 resource indicators with requests to the token
+// URL.
+func resourceContext(ctx context.Context, tokenURL string, resources []string) context.Context {
+	if len(resources) == 0 {
+		return ctx
+	}
+
+	tq := &tokenEndpointQuirks{
+		TokenURL: tokenURL,
+		Params:   url.Values{"resource": resources},
+	}
+	return tq.Context(ctx)
+}
@@ -0,0 +1,180 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/devicecode"
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	GlobalRegistry.MustRegister("salesforce", SalesforceFactory, WithSchema(SalesforceSchema), WithVersion(1), basicFlows)
+}
+
+const (
+	salesforceEnvironmentProduction = "production"
+	salesforceEnvironmentSandbox    = "sandbox"
+
+	salesforceExtraDataFieldInstanceURL = "instance_url"
+)
+
+var salesforceLoginURLs = map[string]string{
+	salesforceEnvironmentProduction: "https://login.salesforce.com",
+	salesforceEnvironmentSandbox:    "https://test.salesforce.com",
+}
+
+// SalesforceSchema describes the options accepted by SalesforceFactory.
+var SalesforceSchema = OptionSchema{
+	"environment": {
+		Type:        OptionTypeString,
+		Description: "The Salesforce environment to authenticate to.",
+		Enum:        []string{salesforceEnvironmentProduction, salesforceEnvironmentSandbox},
+		Default:     salesforceEnvironmentProduction,
+	},
+	"login_url": {
+		Type:        OptionTypeURL,
+		Description: "The login URL of a My Domain to authenticate to. Takes precedence over the environment option.",
+	},
+}
+
+type salesforceOperations struct {
+	delegate *basicOperations
+}
+
+// updateInstanceURL records the instance URL returned with a token. Salesforce
+// returns it with every token response, but we retain the previous value in
+// case it is ever omitted from a refresh.
+func (so *salesforceOperations) updateInstanceURL(t, prev *Token) {
+	if t.ExtraData == nil {
+		t.ExtraData = make(map[string]interface{})
+	}
+
+	if instanceURL, ok := t.Extra("instance_url").(string); ok && instanceURL != "" {
+		t.ExtraData[salesforceExtraDataFieldInstanceURL] = instanceURL
+	} else if prev != nil && prev.ExtraData[salesforceExtraDataFieldInstanceURL] != nil {
+		t.ExtraData[salesforceExtraDataFieldInstanceURL] = prev.ExtraData[salesforceExtraDataFieldInstanceURL]
+	}
+}
+
+func (so *salesforceOperations) TokenURL() string {
+	return so.delegate.TokenURL()
+}
+
+func (so *salesforceOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool) {
+	return so.delegate.AuthCodeURL(state, opts...)
+}
+
+func (so *salesforceOperations) DeviceCodeAuth(ctx context.Context, opts ...DeviceCodeAuthOption) (*devicecode.Auth, bool, error) {
+	return so.delegate.DeviceCodeAuth(ctx, opts...)
+}
+
+func (so *salesforceOperations) DeviceCodeExchange(ctx context.Context, deviceCode string, opts ...DeviceCodeExchangeOption) (*Token, error) {
+	t, err := so.delegate.DeviceCodeExchange(ctx, deviceCode, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	so.updateInstanceURL(t, nil)
+	return t, nil
+}
+
+func (so *salesforceOperations) AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (*Token, error) {
+	t, err := so.delegate.AuthCodeExchange(ctx, code, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	so.updateInstanceURL(t, nil)
+	return t, nil
+}
+
+func (so *salesforceOperations) RefreshToken(ctx context.Context, t *Token, opts ...RefreshTokenOption) (*Token, error) {
+	nt, err := so.delegate.RefreshToken(ctx, t, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	so.updateInstanceURL(nt, t)
+	return nt, nil
+}
+
+func (so *salesforceOperations) ClientCredentials(ctx context.Context, opts ...ClientCredentialsOption) (*Token, error) {
+	t, err := so.delegate.ClientCredentials(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	so.updateInstanceURL(t, nil)
+	return t, nil
+}
+
+type salesforce struct {
+	vsn      int
+	endpoint Endpoint
+}
+
+func (s *salesforce) Version() int {
+	return s.vsn
+}
+
+func (s *salesforce) Public(clientID string) PublicOperations {
+	return s.Private(clientID, "")
+}
+
+func (s *salesforce) Private(clientID, clientSecret string) PrivateOperations {
+	return &salesforceOperations{
+		delegate: &basicOperations{
+			vsn:             s.vsn,
+			endpointFactory: StaticEndpointFactory(s.endpoint),
+			clientID:        clientID,
+			clientSecret:    clientSecret,
+		},
+	}
+}
+
+func SalesforceFactory(ctx context.Context, vsn int, opts map[string]string) (Provider, error) {
+	vsn = selectVersion(vsn, 1)
+
+	switch vsn {
+	case 1:
+	default:
+		return nil, ErrNoProviderWithVersion
+	}
+
+	loginURL := salesforceLoginURLs[salesforceEnvironmentProduction]
+	if env := opts["environment"]; env != "" {
+		u, found := salesforceLoginURLs[env]
+		if !found {
+			return nil, &OptionError{Option: "environment", Cause: fmt.Errorf(`unknown environment; expected one of "production" or "sandbox"`)}
+		}
+
+		loginURL = u
+	}
+
+	if opts["login_url"] != "" {
+		u, err := url.Parse(opts["login_url"])
+		if err != nil {
+			return nil, &OptionError{Option: "login_url", Cause: fmt.Errorf("invalid URL: %w", err)}
+		} else if !u.IsAbs() || u.Host == "" {
+			return nil, &OptionError{Option: "login_url", Cause: fmt.Errorf("login URL must be absolute")}
+		}
+
+		loginURL = strings.TrimSuffix(u.String(), "/")
+	}
+
+	p := &salesforce{
+		vsn: vsn,
+		endpoint: Endpoint{
+			// https://help.salesforce.com/s/articleView?id=sf.remoteaccess_oauth_endpoints.htm
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   loginURL + "/services/oauth2/authorize",
+				TokenURL:  loginURL + "/services/oauth2/token",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+	}
+	return p, nil
+}
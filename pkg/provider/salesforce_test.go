@@ -0,0 +1,91 @@
+package provider_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestSalesforce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Host + r.URL.Path {
+		case "test.salesforce.com/services/oauth2/token":
+			b, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			resp := map[string]interface{}{
+				"access_token": "abcd",
+				"token_type":   "Bearer",
+				"issued_at":    "1278448101416",
+			}
+
+			switch data.Get("grant_type") {
+			case "authorization_code":
+				resp["refresh_token"] = "efgh"
+				resp["instance_url"] = "https://example.my.salesforce.com"
+			case "refresh_token":
+				// Omit the instance URL to make sure we keep the previous
+				// value.
+				resp["access_token"] = "ijkl"
+			}
+
+			w.Header().Set("content-type", "application/json")
+			_ = json.NewEncoder(w).Encode(resp)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	p, err := provider.GlobalRegistry.New(ctx, "salesforce", map[string]string{
+		"environment": "sandbox",
+	})
+	require.NoError(t, err)
+
+	u, ok := p.Public("foo").AuthCodeURL("123456")
+	require.True(t, ok)
+	assert.Equal(t, "https://test.salesforce.com/services/oauth2/authorize?client_id=foo&response_type=code&state=123456", u)
+
+	ops := p.Private("foo", "bar")
+
+	token, err := ops.AuthCodeExchange(ctx, "123456")
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.Equal(t, "abcd", token.AccessToken)
+	assert.Equal(t, "https://example.my.salesforce.com", token.ExtraData["instance_url"])
+
+	token, err = ops.RefreshToken(ctx, token)
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.Equal(t, "ijkl", token.AccessToken)
+	assert.Equal(t, "efgh", token.RefreshToken)
+	assert.Equal(t, "https://example.my.salesforce.com", token.ExtraData["instance_url"])
+
+	// A My Domain login URL takes precedence over the environment.
+	p, err = provider.GlobalRegistry.New(ctx, "salesforce", map[string]string{
+		"environment": "sandbox",
+		"login_url":   "https://example--dev.sandbox.my.salesforce.com/",
+	})
+	require.NoError(t, err)
+
+	u, ok = p.Public("foo").AuthCodeURL("123456")
+	require.True(t, ok)
+	assert.Equal(t, "https://example--dev.sandbox.my.salesforce.com/services/oauth2/authorize?client_id=foo&response_type=code&state=123456", u)
+}
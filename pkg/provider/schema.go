@@ -0,0 +1,192 @@
+package provider
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/parseutil"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+)
+
+// OptionType is the type of the value of a provider option.
+type OptionType string
+
+const (
+	OptionTypeString          OptionType = "string"
+	OptionTypeURL             OptionType = "url"
+	OptionTypeCommaStringList OptionType = "comma_string_list"
+	OptionTypeDuration        OptionType = "duration"
+)
+
+// OptionSpec describes a single provider option.
+type OptionSpec struct {
+	// Type is the type of the option value. If not specified, any string is
+	// accepted.
+	Type OptionType `json:"type"`
+
+	// Description is a human-readable description of the option.
+	Description string `json:"description"`
+
+	// Required indicates that the option must be specified.
+	Required bool `json:"required,omitempty"`
+
+	// Enum, if specified, is the list of permitted values. For list types,
+	// each item of the list must be one of these values.
+	Enum []string `json:"enum,omitempty"`
+
+	// Default is the value the provider uses if the option is not specified,
+	// if there is one. It is informational; providers apply their own
+	// defaults.
+	Default string `json:"default,omitempty"`
+}
+
+func (osp *OptionSpec) validate(value string) error {
+	var values []string
+
+	switch osp.Type {
+	case OptionTypeCommaStringList:
+		vs, err := parseutil.ParseCommaStringSlice(value)
+		if err != nil {
+			return fmt.Errorf("invalid format (expected a comma-separated list): %w", err)
+		}
+
+		values = vs
+	case OptionTypeURL:
+		u, err := url.Parse(value)
+		if err != nil {
+			return fmt.Errorf("invalid URL: %w", err)
+		} else if !u.IsAbs() || u.Host == "" {
+			return fmt.Errorf("invalid URL: %q must be an absolute URL", value)
+		}
+
+		values = []string{value}
+	case OptionTypeDuration:
+		d, err := parseutil.ParseDurationSecond(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration: %w", err)
+		} else if d < 0 {
+			return fmt.Errorf("invalid duration: %q must not be negative", value)
+		}
+
+		values = []string{value}
+	default:
+		values = []string{value}
+	}
+
+	if len(osp.Enum) > 0 {
+		for _, v := range values {
+			if !strutil.StrListContains(osp.Enum, v) {
+				return fmt.Errorf("unexpected value %q; expected one of %q", v, osp.Enum)
+			}
+		}
+	}
+
+	return nil
+}
+
+// OptionSchema describes the provider options accepted by a provider when it
+// is configured.
+type OptionSchema map[string]*OptionSpec
+
+// Validate checks the given options against this schema and returns an
+// *OptionsError describing every problem found.
+func (oss OptionSchema) Validate(opts map[string]string) error {
+	var errs []*OptionError
+
+	for name, spec := range oss {
+		value, found := opts[name]
+		if !found || value == "" {
+			if spec.Required {
+				errs = append(errs, &OptionError{Option: name, Cause: fmt.Errorf("option is required")})
+			}
+
+			continue
+		}
+
+		if err := spec.validate(value); err != nil {
+			errs = append(errs, &OptionError{Option: name, Cause: err})
+		}
+	}
+
+	for name := range opts {
+		if _, found := oss[name]; !found {
+			errs = append(errs, &OptionError{Option: name, Cause: fmt.Errorf("unknown option")})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Option < errs[j].Option })
+	return &OptionsError{Errors: errs}
+}
+
+// Defaults returns the default value of each option that has one.
+func (oss OptionSchema) Defaults() map[string]string {
+	defaults := make(map[string]string)
+	for name, spec := range oss {
+		if spec.Default != "" {
+			defaults[name] = spec.Default
+		}
+	}
+	return defaults
+}
+
+// JSONSchema returns a representation of this schema as a JSON Schema
+// document.
+func (oss OptionSchema) JSONSchema() map[string]interface{} {
+	properties := make(map[string]interface{}, len(oss))
+	required := []string{}
+
+	for name, spec := range oss {
+		prop := map[string]interface{}{
+			"type":        "string",
+			"description": spec.Description,
+		}
+
+		switch spec.Type {
+		case OptionTypeURL:
+			prop["format"] = "uri"
+		case OptionTypeDuration:
+			prop["pattern"] = `^[0-9]+(?:\.[0-9]+)?(?:ns|us|µs|ms|s|m|h)?(?:[0-9]+(?:\.[0-9]+)?(?:ns|us|µs|ms|s|m|h))*$`
+		case OptionTypeCommaStringList:
+			if len(spec.Enum) > 0 {
+				items := make([]string, len(spec.Enum))
+				for i, item := range spec.Enum {
+					items[i] = regexp.QuoteMeta(item)
+				}
+
+				item := "(?:" + strings.Join(items, "|") + ")"
+				prop["pattern"] = fmt.Sprintf(`^\s*%s\s*(?:,\s*%s\s*)*$`, item, item)
+			}
+		}
+
+		if len(spec.Enum) > 0 && spec.Type != OptionTypeCommaStringList {
+			prop["enum"] = spec.Enum
+		}
+
+		if spec.Default != "" {
+			prop["default"] = spec.Default
+		}
+
+		properties[name] = prop
+
+		if spec.Required {
+			required = append(required, name)
+		}
+	}
+
+	sort.Strings(required)
+
+	return map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
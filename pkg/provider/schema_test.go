@@ -0,0 +1,116 @@
+package provider_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionSchemaValidate(t *testing.T) {
+	schema := provider.OptionSchema{
+		"url": {
+			Type:     provider.OptionTypeURL,
+			Required: true,
+		},
+		"style": {
+			Type: provider.OptionTypeString,
+			Enum: []string{"a", "b"},
+		},
+		"fields": {
+			Type: provider.OptionTypeCommaStringList,
+			Enum: []string{"x", "y"},
+		},
+	}
+
+	tests := []struct {
+		Name            string
+		Options         map[string]string
+		ExpectedOptions []string
+	}{
+		{
+			Name: "valid",
+			Options: map[string]string{
+				"url":    "https://example.com",
+				"style":  "a",
+				"fields": "x,y",
+			},
+		},
+		{
+			Name:            "missing required",
+			Options:         map[string]string{},
+			ExpectedOptions: []string{"url"},
+		},
+		{
+			Name: "invalid values",
+			Options: map[string]string{
+				"url":    "/relative",
+				"style":  "c",
+				"fields": "x,z",
+			},
+			ExpectedOptions: []string{"fields", "style", "url"},
+		},
+		{
+			Name: "unknown option",
+			Options: map[string]string{
+				"url":   "https://example.com",
+				"styel": "a",
+			},
+			ExpectedOptions: []string{"styel"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			err := schema.Validate(test.Options)
+			if len(test.ExpectedOptions) == 0 {
+				require.NoError(t, err)
+				return
+			}
+
+			var oe *provider.OptionsError
+			require.True(t, errors.As(err, &oe), "unexpected error: %+v", err)
+
+			var options []string
+			for _, err := range oe.Errors {
+				options = append(options, err.Option)
+			}
+			assert.Equal(t, test.ExpectedOptions, options)
+		})
+	}
+}
+
+func TestRegistrySchema(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := provider.GlobalRegistry.New(ctx, "custom", map[string]string{
+		"token_url":  "https://example.com/token",
+		"auth_stlye": "in_params",
+	})
+
+	var oe *provider.OptionsError
+	require.True(t, errors.As(err, &oe), "unexpected error: %+v", err)
+	require.Len(t, oe.Errors, 1)
+	assert.Equal(t, "auth_stlye", oe.Errors[0].Option)
+
+	schema, ok, err := provider.GlobalRegistry.Schema("oidc")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, []string{"issuer_url"}, schema.JSONSchema()["required"])
+	assert.Equal(t, map[string]string{
+		"jwks_cache_ttl":            "1h0m0s",
+		"jwks_min_refresh_interval": "1m0s",
+	}, schema.Defaults())
+
+	lifetime, ok, err := provider.GlobalRegistry.TokenLifetime("google")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, time.Hour, lifetime)
+
+	_, ok, err = provider.GlobalRegistry.TokenLifetime("oidc")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -13,13 +13,39 @@ type TimeoutAlgorithm interface {
 	Timeout(ctx context.Context, tok *Token) (time.Duration, bool)
 }
 
-func contextWithTimeout(ctx context.Context, alg TimeoutAlgorithm, tok *Token) (context.Context, context.CancelFunc) {
-	timeout, ok := alg.Timeout(ctx, tok)
-	if !ok {
-		return context.WithCancel(ctx)
+// TimeoutObserverFunc is called after each operation of a TimeoutProvider with
+// the name of the operation, the timeout applied to it (or 0 if there was no
+// timeout), how long the operation took, and the error it returned.
+type TimeoutObserverFunc func(op string, timeout, elapsed time.Duration, err error)
+
+type timeouts struct {
+	alg       TimeoutAlgorithm
+	observers []TimeoutObserverFunc
+}
+
+func (t *timeouts) run(ctx context.Context, op string, tok *Token, fn func(ctx context.Context) error) error {
+	timeout, ok := t.alg.Timeout(ctx, tok)
+
+	var cancel context.CancelFunc
+	if ok {
+		ctx, cancel = clockctx.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+		timeout = 0
 	}
+	defer cancel()
+
+	start := clockctx.Clock(ctx).Now()
+	err := fn(ctx)
 
-	return clockctx.WithTimeout(ctx, timeout)
+	if len(t.observers) > 0 {
+		elapsed := clockctx.Clock(ctx).Since(start)
+		for _, observe := range t.observers {
+			observe(op, timeout, elapsed, err)
+		}
+	}
+
+	return err
 }
 
 type ConstantTimeoutAlgorithm struct {
@@ -125,6 +151,43 @@ func NewBoundedLogarithmicTimeoutAlgorithm(expiryLeewayFactor float64, timeout,
 	})
 }
 
+// DeadlineTimeoutAlgorithm never allows an operation on a token that is still
+// valid to take longer than the token has left: until it expires, clients can
+// keep using it, so there is no point in waiting for a replacement any
+// longer. Once the token has expired, clients have nothing to fall back on,
+// so the timeout is extended by a leeway factor instead.
+type DeadlineTimeoutAlgorithm struct {
+	timeout        time.Duration
+	expiredTimeout time.Duration
+}
+
+var _ TimeoutAlgorithm = &DeadlineTimeoutAlgorithm{}
+
+func (dta *DeadlineTimeoutAlgorithm) Timeout(ctx context.Context, tok *Token) (time.Duration, bool) {
+	remaining, ok := timeToExpiry(ctx, tok)
+	switch {
+	case !ok:
+		return dta.timeout, true
+	case remaining <= 0:
+		return dta.expiredTimeout, true
+	case remaining < dta.timeout:
+		return remaining, true
+	default:
+		return dta.timeout, true
+	}
+}
+
+func NewDeadlineTimeoutAlgorithm(expiryLeewayFactor float64, timeout time.Duration) *DeadlineTimeoutAlgorithm {
+	if expiryLeewayFactor < 1 {
+		expiryLeewayFactor = 1
+	}
+
+	return &DeadlineTimeoutAlgorithm{
+		timeout:        timeout,
+		expiredTimeout: time.Duration(float64(timeout) * expiryLeewayFactor),
+	}
+}
+
 func timeToExpiry(ctx context.Context, tok *Token) (time.Duration, bool) {
 	now := clockctx.Clock(ctx).Now()
 
@@ -142,32 +205,35 @@ func timeToExpiry(ctx context.Context, tok *Token) (time.Duration, bool) {
 
 type publicTimeoutOperations struct {
 	delegate PublicOperations
-	alg      TimeoutAlgorithm
+	t        *timeouts
 }
 
 func (pto *publicTimeoutOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool) {
 	return pto.delegate.AuthCodeURL(state, opts...)
 }
 
-func (pto *publicTimeoutOperations) DeviceCodeAuth(ctx context.Context, opts ...DeviceCodeAuthOption) (*devicecode.Auth, bool, error) {
-	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
-	defer cancel()
-
-	return pto.delegate.DeviceCodeAuth(ctx, opts...)
+func (pto *publicTimeoutOperations) DeviceCodeAuth(ctx context.Context, opts ...DeviceCodeAuthOption) (auth *devicecode.Auth, ok bool, err error) {
+	err = pto.t.run(ctx, "DeviceCodeAuth", nil, func(ctx context.Context) (err error) {
+		auth, ok, err = pto.delegate.DeviceCodeAuth(ctx, opts...)
+		return
+	})
+	return
 }
 
-func (pto *publicTimeoutOperations) DeviceCodeExchange(ctx context.Context, deviceCode string, opts ...DeviceCodeExchangeOption) (*Token, error) {
-	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
-	defer cancel()
-
-	return pto.delegate.DeviceCodeExchange(ctx, deviceCode, opts...)
+func (pto *publicTimeoutOperations) DeviceCodeExchange(ctx context.Context, deviceCode string, opts ...DeviceCodeExchangeOption) (tok *Token, err error) {
+	err = pto.t.run(ctx, "DeviceCodeExchange", nil, func(ctx context.Context) (err error) {
+		tok, err = pto.delegate.DeviceCodeExchange(ctx, deviceCode, opts...)
+		return
+	})
+	return
 }
 
-func (pto *publicTimeoutOperations) RefreshToken(ctx context.Context, t *Token, opts ...RefreshTokenOption) (*Token, error) {
-	ctx, cancel := contextWithTimeout(ctx, pto.alg, t)
-	defer cancel()
-
-	return pto.delegate.RefreshToken(ctx, t, opts...)
+func (pto *publicTimeoutOperations) RefreshToken(ctx context.Context, t *Token, opts ...RefreshTokenOption) (tok *Token, err error) {
+	err = pto.t.run(ctx, "RefreshToken", t, func(ctx context.Context) (err error) {
+		tok, err = pto.delegate.RefreshToken(ctx, t, opts...)
+		return
+	})
+	return
 }
 
 type privateTimeoutOperations struct {
@@ -175,23 +241,77 @@ type privateTimeoutOperations struct {
 	delegate PrivateOperations
 }
 
-func (pto *privateTimeoutOperations) AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (*Token, error) {
-	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
-	defer cancel()
+func (pto *privateTimeoutOperations) AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (tok *Token, err error) {
+	err = pto.t.run(ctx, "AuthCodeExchange", nil, func(ctx context.Context) (err error) {
+		tok, err = pto.delegate.AuthCodeExchange(ctx, code, opts...)
+		return
+	})
+	return
+}
 
-	return pto.delegate.AuthCodeExchange(ctx, code, opts...)
+func (pto *privateTimeoutOperations) ClientCredentials(ctx context.Context, opts ...ClientCredentialsOption) (tok *Token, err error) {
+	err = pto.t.run(ctx, "ClientCredentials", nil, func(ctx context.Context) (err error) {
+		tok, err = pto.delegate.ClientCredentials(ctx, opts...)
+		return
+	})
+	return
 }
 
-func (pto *privateTimeoutOperations) ClientCredentials(ctx context.Context, opts ...ClientCredentialsOption) (*Token, error) {
-	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
-	defer cancel()
+func (pto *privateTimeoutOperations) SupportsUserInfo() bool {
+	ops, ok := pto.delegate.(UserInfoOperations)
+	return ok && ops.SupportsUserInfo()
+}
+
+func (pto *privateTimeoutOperations) UserInfo(ctx context.Context, t *Token) (claims map[string]interface{}, err error) {
+	ops, ok := pto.delegate.(UserInfoOperations)
+	if !ok {
+		return nil, ErrUnsupportedOperation
+	}
+
+	err = pto.t.run(ctx, "UserInfo", t, func(ctx context.Context) (err error) {
+		claims, err = ops.UserInfo(ctx, t)
+		return
+	})
+	return
+}
+
+func (pto *privateTimeoutOperations) SupportsRevocation() bool {
+	ops, ok := pto.delegate.(RevocationOperations)
+	return ok && ops.SupportsRevocation()
+}
+
+func (pto *privateTimeoutOperations) RevokeToken(ctx context.Context, t *Token) error {
+	ops, ok := pto.delegate.(RevocationOperations)
+	if !ok {
+		return ErrUnsupportedOperation
+	}
+
+	return pto.t.run(ctx, "RevokeToken", t, func(ctx context.Context) error {
+		return ops.RevokeToken(ctx, t)
+	})
+}
 
-	return pto.delegate.ClientCredentials(ctx, opts...)
+func (pto *privateTimeoutOperations) SupportsIntrospection() bool {
+	ops, ok := pto.delegate.(IntrospectionOperations)
+	return ok && ops.SupportsIntrospection()
+}
+
+func (pto *privateTimeoutOperations) IntrospectToken(ctx context.Context, t *Token) (active bool, err error) {
+	ops, ok := pto.delegate.(IntrospectionOperations)
+	if !ok {
+		return false, ErrUnsupportedOperation
+	}
+
+	err = pto.t.run(ctx, "IntrospectToken", t, func(ctx context.Context) (err error) {
+		active, err = ops.IntrospectToken(ctx, t)
+		return
+	})
+	return
 }
 
 type TimeoutProvider struct {
 	delegate Provider
-	alg      TimeoutAlgorithm
+	t        *timeouts
 }
 
 var _ Provider = &TimeoutProvider{}
@@ -203,7 +323,7 @@ func (tp *TimeoutProvider) Version() int {
 func (tp *TimeoutProvider) Public(clientID string) PublicOperations {
 	return &publicTimeoutOperations{
 		delegate: tp.delegate.Public(clientID),
-		alg:      tp.alg,
+		t:        tp.t,
 	}
 }
 
@@ -212,15 +332,21 @@ func (tp *TimeoutProvider) Private(clientID, clientSecret string) PrivateOperati
 	return &privateTimeoutOperations{
 		publicTimeoutOperations: &publicTimeoutOperations{
 			delegate: priv,
-			alg:      tp.alg,
+			t:        tp.t,
 		},
 		delegate: priv,
 	}
 }
 
-func NewTimeoutProvider(delegate Provider, alg TimeoutAlgorithm) *TimeoutProvider {
+// NewTimeoutProvider creates a provider that applies the timeouts computed by
+// the given algorithm to every operation of the delegate. The given observers
+// are notified when each operation completes.
+func NewTimeoutProvider(delegate Provider, alg TimeoutAlgorithm, observers ...TimeoutObserverFunc) *TimeoutProvider {
 	return &TimeoutProvider{
 		delegate: delegate,
-		alg:      alg,
+		t: &timeouts{
+			alg:       alg,
+			observers: observers,
+		},
 	}
 }
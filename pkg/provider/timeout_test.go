@@ -2,6 +2,7 @@ package provider_test
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -220,6 +221,63 @@ func TestLogarithmicTimeoutAlgorithm(t *testing.T) {
 	}
 }
 
+func TestDeadlineTimeoutAlgorithm(t *testing.T) {
+	clk := testclock.NewFakeClock(time.Now())
+	ctx := clockctx.WithClock(context.Background(), k8sext.NewClock(clk))
+
+	tests := []struct {
+		Name               string
+		Expiry             time.Time
+		ExpiryLeewayFactor float64
+		Expected           time.Duration
+	}{
+		{
+			Name:               "Token does not expire",
+			ExpiryLeewayFactor: 1.5,
+			Expected:           15 * time.Second,
+		},
+		{
+			Name:               "Expiry in the distant future",
+			Expiry:             clk.Now().Add(15 * time.Hour),
+			ExpiryLeewayFactor: 1.5,
+			Expected:           15 * time.Second,
+		},
+		{
+			Name:               "Expiry before timeout",
+			Expiry:             clk.Now().Add(5 * time.Second),
+			ExpiryLeewayFactor: 1.5,
+			Expected:           5 * time.Second,
+		},
+		{
+			Name:               "Already expired",
+			Expiry:             clk.Now().Add(-5 * time.Second),
+			ExpiryLeewayFactor: 1.5,
+			Expected:           22*time.Second + 500*time.Millisecond,
+		},
+		{
+			Name:               "Already expired without leeway",
+			Expiry:             clk.Now(),
+			ExpiryLeewayFactor: 1.0,
+			Expected:           15 * time.Second,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tok := &provider.Token{
+				Token: &oauth2.Token{
+					AccessToken: "token",
+					Expiry:      test.Expiry,
+				},
+			}
+			alg := provider.NewDeadlineTimeoutAlgorithm(test.ExpiryLeewayFactor, 15*time.Second)
+
+			timeout, ok := alg.Timeout(ctx, tok)
+			require.Equal(t, true, ok)
+			require.Equal(t, test.Expected, timeout)
+		})
+	}
+}
+
 func TestTimeoutProvider(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
@@ -274,8 +332,13 @@ func TestTimeoutProvider(t *testing.T) {
 	p, err := factory(ctx, 1, map[string]string{})
 	require.NoError(t, err)
 
+	var observed []string
+	observer := func(op string, timeout, elapsed time.Duration, err error) {
+		observed = append(observed, fmt.Sprintf("%s %s %s %v", op, timeout, elapsed, err))
+	}
+
 	alg := provider.NewBoundedLogarithmicTimeoutAlgorithm(1.5, 10*time.Second, time.Minute)
-	p = provider.NewTimeoutProvider(p, alg)
+	p = provider.NewTimeoutProvider(p, alg, observer)
 
 	ops := p.Private("foo", "bar")
 
@@ -302,4 +365,12 @@ func TestTimeoutProvider(t *testing.T) {
 	stepper <- 10 * time.Second
 	_, err = ops.AuthCodeExchange(ctx, "wait")
 	require.Equal(t, context.DeadlineExceeded, err)
+
+	// Each operation is reported to the observer.
+	require.Equal(t, []string{
+		"AuthCodeExchange 10s 0s <nil>",
+		"RefreshToken 10s 10s context deadline exceeded",
+		"RefreshToken 15s 15s context deadline exceeded",
+		"AuthCodeExchange 10s 10s context deadline exceeded",
+	}, observed)
 }
@@ -0,0 +1,187 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/devicecode"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/semerr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the OpenTelemetry tracer used for provider
+// operations.
+const TracerName = "github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+
+type tracing struct {
+	tracer trace.Tracer
+	attrs  []attribute.KeyValue
+}
+
+func (t *tracing) start(ctx context.Context, op string) (context.Context, trace.Span) {
+	ctx, span := t.tracer.Start(ctx, "oauthapp.provider."+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(t.attrs...))
+	if id, ok := CorrelationID(ctx); ok {
+		span.SetAttributes(attribute.String("oauthapp.correlation_id", id))
+	}
+	return ctx, span
+}
+
+func (t *tracing) end(span trace.Span, err error) {
+	if code, ok := semerr.StatusCode(err); ok {
+		span.SetAttributes(attribute.Int("http.status_code", code))
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+type publicTracingOperations struct {
+	delegate PublicOperations
+	t        *tracing
+}
+
+func (pto *publicTracingOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool) {
+	return pto.delegate.AuthCodeURL(state, opts...)
+}
+
+func (pto *publicTracingOperations) DeviceCodeAuth(ctx context.Context, opts ...DeviceCodeAuthOption) (auth *devicecode.Auth, ok bool, err error) {
+	ctx, span := pto.t.start(ctx, "DeviceCodeAuth")
+	defer func() { pto.t.end(span, err) }()
+
+	return pto.delegate.DeviceCodeAuth(ctx, opts...)
+}
+
+func (pto *publicTracingOperations) DeviceCodeExchange(ctx context.Context, deviceCode string, opts ...DeviceCodeExchangeOption) (tok *Token, err error) {
+	ctx, span := pto.t.start(ctx, "DeviceCodeExchange")
+	defer func() { pto.t.end(span, err) }()
+
+	return pto.delegate.DeviceCodeExchange(ctx, deviceCode, opts...)
+}
+
+func (pto *publicTracingOperations) RefreshToken(ctx context.Context, t *Token, opts ...RefreshTokenOption) (tok *Token, err error) {
+	ctx, span := pto.t.start(ctx, "RefreshToken")
+	defer func() { pto.t.end(span, err) }()
+
+	return pto.delegate.RefreshToken(ctx, t, opts...)
+}
+
+type privateTracingOperations struct {
+	*publicTracingOperations
+	delegate PrivateOperations
+}
+
+func (pto *privateTracingOperations) AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (tok *Token, err error) {
+	ctx, span := pto.t.start(ctx, "AuthCodeExchange")
+	defer func() { pto.t.end(span, err) }()
+
+	return pto.delegate.AuthCodeExchange(ctx, code, opts...)
+}
+
+func (pto *privateTracingOperations) ClientCredentials(ctx context.Context, opts ...ClientCredentialsOption) (tok *Token, err error) {
+	ctx, span := pto.t.start(ctx, "ClientCredentials")
+	defer func() { pto.t.end(span, err) }()
+
+	return pto.delegate.ClientCredentials(ctx, opts...)
+}
+
+func (pto *privateTracingOperations) SupportsUserInfo() bool {
+	ops, ok := pto.delegate.(UserInfoOperations)
+	return ok && ops.SupportsUserInfo()
+}
+
+func (pto *privateTracingOperations) UserInfo(ctx context.Context, t *Token) (claims map[string]interface{}, err error) {
+	ops, ok := pto.delegate.(UserInfoOperations)
+	if !ok {
+		return nil, ErrUnsupportedOperation
+	}
+
+	ctx, span := pto.t.start(ctx, "UserInfo")
+	defer func() { pto.t.end(span, err) }()
+
+	return ops.UserInfo(ctx, t)
+}
+
+func (pto *privateTracingOperations) SupportsRevocation() bool {
+	ops, ok := pto.delegate.(RevocationOperations)
+	return ok && ops.SupportsRevocation()
+}
+
+func (pto *privateTracingOperations) RevokeToken(ctx context.Context, t *Token) (err error) {
+	ops, ok := pto.delegate.(RevocationOperations)
+	if !ok {
+		return ErrUnsupportedOperation
+	}
+
+	ctx, span := pto.t.start(ctx, "RevokeToken")
+	defer func() { pto.t.end(span, err) }()
+
+	return ops.RevokeToken(ctx, t)
+}
+
+func (pto *privateTracingOperations) SupportsIntrospection() bool {
+	ops, ok := pto.delegate.(IntrospectionOperations)
+	return ok && ops.SupportsIntrospection()
+}
+
+func (pto *privateTracingOperations) IntrospectToken(ctx context.Context, t *Token) (active bool, err error) {
+	ops, ok := pto.delegate.(IntrospectionOperations)
+	if !ok {
+		return false, ErrUnsupportedOperation
+	}
+
+	ctx, span := pto.t.start(ctx, "IntrospectToken")
+	defer func() { pto.t.end(span, err) }()
+
+	return ops.IntrospectToken(ctx, t)
+}
+
+// TracingProvider records an OpenTelemetry span for each operation that
+// contacts the provider. Each span includes the name and version of the
+// provider and, if the provider responded with an error, the HTTP status code
+// of the response.
+type TracingProvider struct {
+	delegate Provider
+	t        *tracing
+}
+
+var _ Provider = &TracingProvider{}
+
+func (tp *TracingProvider) Version() int {
+	return tp.delegate.Version()
+}
+
+func (tp *TracingProvider) Public(clientID string) PublicOperations {
+	return &publicTracingOperations{
+		delegate: tp.delegate.Public(clientID),
+		t:        tp.t,
+	}
+}
+
+func (tp *TracingProvider) Private(clientID, clientSecret string) PrivateOperations {
+	priv := tp.delegate.Private(clientID, clientSecret)
+	return &privateTracingOperations{
+		publicTracingOperations: &publicTracingOperations{
+			delegate: priv,
+			t:        tp.t,
+		},
+		delegate: priv,
+	}
+}
+
+func NewTracingProvider(delegate Provider, tp trace.TracerProvider, name string) *TracingProvider {
+	return &TracingProvider{
+		delegate: delegate,
+		t: &tracing{
+			tracer: tp.Tracer(TracerName),
+			attrs: []attribute.KeyValue{
+				attribute.String("oauthapp.provider", name),
+				attribute.Int("oauthapp.provider.version", delegate.Version()),
+			},
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package provider_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/oauth2"
+)
+
+func TestTracingProvider(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "foo",
+		Secret: "bar",
+	}
+
+	token := &provider.Token{
+		Token: &oauth2.Token{
+			AccessToken: "valid",
+		},
+	}
+
+	rejected := func(_ *provider.ClientCredentialsOptions) (*provider.Token, error) {
+		return nil, testutil.MockErrorResponse(http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+	}
+
+	delegate, err := testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.StaticMockAuthCodeExchange(token)),
+		testutil.MockWithClientCredentials(client, rejected),
+		testutil.MockWithUserInfo(func(_ *provider.Token) (map[string]interface{}, error) {
+			return map[string]interface{}{"sub": "alice"}, nil
+		}),
+		testutil.MockWithRevokeToken(func(_ *provider.Token) error { return nil }),
+	)(ctx, -1, map[string]string{})
+	require.NoError(t, err)
+
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	p := provider.NewTracingProvider(delegate, tp, "mock")
+
+	ctx = provider.WithCorrelationID(ctx, "test")
+
+	_, err = p.Private(client.ID, client.Secret).AuthCodeExchange(ctx, "123456")
+	require.NoError(t, err)
+
+	_, err = p.Private(client.ID, client.Secret).ClientCredentials(ctx)
+	require.Error(t, err)
+
+	// Optional operations are traced if the delegate supports them.
+	priv := p.Private(client.ID, client.Secret)
+
+	userInfo, ok := priv.(provider.UserInfoOperations)
+	require.True(t, ok)
+	require.True(t, userInfo.SupportsUserInfo())
+	_, err = userInfo.UserInfo(ctx, token)
+	require.NoError(t, err)
+
+	revocation, ok := priv.(provider.RevocationOperations)
+	require.True(t, ok)
+	require.True(t, revocation.SupportsRevocation())
+	require.NoError(t, revocation.RevokeToken(ctx, token))
+
+	introspection, ok := priv.(provider.IntrospectionOperations)
+	require.True(t, ok)
+	require.False(t, introspection.SupportsIntrospection())
+
+	spans := sr.Ended()
+	require.Len(t, spans, 4)
+
+	assert.Equal(t, "oauthapp.provider.AuthCodeExchange", spans[0].Name())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Contains(t, spans[0].Attributes(), attribute.String("oauthapp.provider", "mock"))
+	assert.Contains(t, spans[0].Attributes(), attribute.String("oauthapp.correlation_id", "test"))
+
+	assert.Equal(t, "oauthapp.provider.ClientCredentials", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Contains(t, spans[1].Attributes(), attribute.Int("http.status_code", http.StatusUnauthorized))
+
+	assert.Equal(t, "oauthapp.provider.UserInfo", spans[2].Name())
+	assert.Equal(t, "oauthapp.provider.RevokeToken", spans[3].Name())
+}
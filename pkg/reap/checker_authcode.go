@@ -23,6 +23,9 @@ func (acc *AuthCodeChecker) Check(ctx context.Context, entry *persistence.AuthCo
 	now := clockctx.Clock(ctx).Now()
 
 	switch {
+	case entry.Disabled:
+		// Disabled credentials are retained for investigation.
+		return nil
 	case entry.UserError != "":
 		if acc.revokedTTL <= 0 {
 			// We will not take action on this token for the revoked
@@ -74,8 +77,8 @@ func (acc *AuthCodeChecker) Check(ctx context.Context, entry *persistence.AuthCo
 	case entry.Expiry.IsZero():
 		// Token never expires.
 		return nil
-	case entry.RefreshToken != "":
-		// Token expires, but it has a valid refresh token.
+	case entry.Refreshable():
+		// Token expires, but it can be refreshed.
 		return nil
 	case acc.nonRefreshableTTL <= 0, entry.Expiry.Add(acc.nonRefreshableTTL).After(now):
 		// Token expires, but it is not yet ready to be reaped.
@@ -86,10 +89,16 @@ func (acc *AuthCodeChecker) Check(ctx context.Context, entry *persistence.AuthCo
 }
 
 func NewAuthCodeChecker(cfg *persistence.ConfigEntry) *AuthCodeChecker {
+	return NewAuthCodeCheckerWithTuning(cfg.Tuning)
+}
+
+// NewAuthCodeCheckerWithTuning creates a checker from the given tuning, such as
+// the mount tuning with the overrides of a particular credential applied.
+func NewAuthCodeCheckerWithTuning(tuning persistence.ConfigTuningEntry) *AuthCodeChecker {
 	return &AuthCodeChecker{
-		nonRefreshableTTL:      time.Duration(cfg.Tuning.ReapNonRefreshableSeconds) * time.Second,
-		revokedTTL:             time.Duration(cfg.Tuning.ReapRevokedSeconds) * time.Second,
-		transientErrorAttempts: cfg.Tuning.ReapTransientErrorAttempts,
-		transientErrorTTL:      time.Duration(cfg.Tuning.ReapTransientErrorSeconds) * time.Second,
+		nonRefreshableTTL:      time.Duration(tuning.ReapNonRefreshableSeconds) * time.Second,
+		revokedTTL:             time.Duration(tuning.ReapRevokedSeconds) * time.Second,
+		transientErrorAttempts: tuning.ReapTransientErrorAttempts,
+		transientErrorTTL:      time.Duration(tuning.ReapTransientErrorSeconds) * time.Second,
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"sync"
 
 	"github.com/puppetlabs/leg/errmap/pkg/errmark"
@@ -43,6 +44,9 @@ type MockAuthCodeExchangeFunc func(code string, opts *provider.AuthCodeExchangeO
 type MockClientCredentialsFunc func(opts *provider.ClientCredentialsOptions) (*provider.Token, error)
 type MockDeviceCodeAuthFunc func(opts *provider.DeviceCodeAuthOptions) (*devicecode.Auth, error)
 type MockDeviceCodeExchangeFunc func(deviceCode string, opts *provider.DeviceCodeExchangeOptions) (*provider.Token, error)
+type MockUserInfoFunc func(t *provider.Token) (map[string]interface{}, error)
+type MockRevokeTokenFunc func(t *provider.Token) error
+type MockIntrospectTokenFunc func(t *provider.Token) (bool, error)
 
 type mockOperations struct {
 	clientID             string
@@ -51,18 +55,34 @@ type mockOperations struct {
 	clientCredentialsFn  MockClientCredentialsFunc
 	deviceCodeAuthFn     MockDeviceCodeAuthFunc
 	deviceCodeExchangeFn MockDeviceCodeExchangeFunc
+	userInfoFn           MockUserInfoFunc
+	revokeTokenFn        MockRevokeTokenFunc
+	introspectTokenFn    MockIntrospectTokenFunc
 }
 
 func (mo *mockOperations) AuthCodeURL(state string, opts ...provider.AuthCodeURLOption) (string, bool) {
 	o := &provider.AuthCodeURLOptions{}
 	o.ApplyOptions(opts)
 
-	return (&oauth2.Config{
+	authCodeURL := (&oauth2.Config{
 		ClientID:    mo.clientID,
 		Endpoint:    MockEndpoint.Endpoint,
 		Scopes:      o.Scopes,
 		RedirectURL: o.RedirectURL,
-	}).AuthCodeURL(state, o.AuthCodeOptions...), true
+	}).AuthCodeURL(state, o.AuthCodeOptions...)
+	if len(o.Resources) > 0 {
+		u, err := url.Parse(authCodeURL)
+		if err != nil {
+			panic(fmt.Errorf("mock: failed to parse authorization code URL: %w", err))
+		}
+
+		q := u.Query()
+		q["resource"] = o.Resources
+		u.RawQuery = q.Encode()
+		authCodeURL = u.String()
+	}
+
+	return authCodeURL, true
 }
 
 func (mo *mockOperations) DeviceCodeAuth(ctx context.Context, opts ...provider.DeviceCodeAuthOption) (*devicecode.Auth, bool, error) {
@@ -149,6 +169,7 @@ func (mo *mockOperations) RefreshToken(ctx context.Context, t *provider.Token, o
 
 	// TODO: It feels wrong to map one option type to another like this.
 	tok, err := mo.authCodeExchangeFn(code, &provider.AuthCodeExchangeOptions{
+		Resources:       o.Resources,
 		ProviderOptions: o.ProviderOptions,
 	})
 	if err != nil {
@@ -184,6 +205,43 @@ func (mo *mockOperations) ClientCredentials(ctx context.Context, opts ...provide
 	return tok, nil
 }
 
+func (mo *mockOperations) SupportsUserInfo() bool {
+	return mo.userInfoFn != nil
+}
+
+func (mo *mockOperations) UserInfo(ctx context.Context, t *provider.Token) (map[string]interface{}, error) {
+	if mo.userInfoFn == nil {
+		return nil, fmt.Errorf("mock: user info is not supported")
+	}
+
+	return mo.userInfoFn(t)
+}
+
+func (mo *mockOperations) SupportsRevocation() bool {
+	return mo.revokeTokenFn != nil
+}
+
+func (mo *mockOperations) RevokeToken(ctx context.Context, t *provider.Token) error {
+	if mo.revokeTokenFn == nil {
+		return fmt.Errorf("mock: token revocation is not supported")
+	}
+
+	return semerr.Map(mo.revokeTokenFn(t))
+}
+
+func (mo *mockOperations) SupportsIntrospection() bool {
+	return mo.introspectTokenFn != nil
+}
+
+func (mo *mockOperations) IntrospectToken(ctx context.Context, t *provider.Token) (bool, error) {
+	if mo.introspectTokenFn == nil {
+		return false, fmt.Errorf("mock: token introspection is not supported")
+	}
+
+	active, err := mo.introspectTokenFn(t)
+	return active, semerr.Map(err)
+}
+
 type mockProvider struct {
 	owner *mock
 }
@@ -205,6 +263,9 @@ func (mp *mockProvider) Private(clientID, clientSecret string) provider.PrivateO
 		clientCredentialsFn:  mp.owner.clientCredentialsFns[mc],
 		deviceCodeAuthFn:     mp.owner.deviceCodeAuthFns[mc],
 		deviceCodeExchangeFn: mp.owner.deviceCodeExchangeFns[mc],
+		userInfoFn:           mp.owner.userInfoFn,
+		revokeTokenFn:        mp.owner.revokeTokenFn,
+		introspectTokenFn:    mp.owner.introspectTokenFn,
 		owner:                mp.owner,
 	}
 }
@@ -216,6 +277,9 @@ type mock struct {
 	clientCredentialsFns  map[MockClient]MockClientCredentialsFunc
 	deviceCodeAuthFns     map[MockClient]MockDeviceCodeAuthFunc
 	deviceCodeExchangeFns map[MockClient]MockDeviceCodeExchangeFunc
+	userInfoFn            MockUserInfoFunc
+	revokeTokenFn         MockRevokeTokenFunc
+	introspectTokenFn     MockIntrospectTokenFunc
 	refresh               map[string]string
 	refreshMut            sync.RWMutex
 }
@@ -303,6 +367,30 @@ func MockWithDeviceCodeExchange(client MockClient, fn MockDeviceCodeExchangeFunc
 	}
 }
 
+// MockWithUserInfo causes the mock provider to support retrieving the claims
+// about the subject of a token using the given function.
+func MockWithUserInfo(fn MockUserInfoFunc) MockOption {
+	return func(m *mock) {
+		m.userInfoFn = fn
+	}
+}
+
+// MockWithRevokeToken causes the mock provider to support revoking access
+// tokens using the given function.
+func MockWithRevokeToken(fn MockRevokeTokenFunc) MockOption {
+	return func(m *mock) {
+		m.revokeTokenFn = fn
+	}
+}
+
+// MockWithIntrospectToken causes the mock provider to support checking whether
+// access tokens are active using the given function.
+func MockWithIntrospectToken(fn MockIntrospectTokenFunc) MockOption {
+	return func(m *mock) {
+		m.introspectTokenFn = fn
+	}
+}
+
 func MockFactory(opts ...MockOption) provider.FactoryFunc {
 	m := &mock{
 		expectedOpts:          make(map[string]string),
@@ -6,6 +6,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/puppetlabs/leg/timeutil/pkg/clock"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/interop"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
 	"golang.org/x/oauth2"
@@ -32,14 +33,45 @@ func AmendTokenMockAuthCodeExchange(get MockAuthCodeExchangeFunc, amend func(tok
 	}
 }
 
-func ExpiringMockAuthCodeExchange(fn MockAuthCodeExchangeFunc, duration time.Duration) MockAuthCodeExchangeFunc {
+type mockExpiry struct {
+	clock clock.PassiveClock
+}
+
+// MockExpiryOption configures how the mock exchanges that issue expiring
+// tokens compute the expiry.
+type MockExpiryOption func(me *mockExpiry)
+
+// MockExpiryWithClock causes token expiries to be computed relative to the
+// given clock instead of the wall clock. Use the same fake clock as the
+// backend so that tests can advance time instead of waiting for tokens to
+// expire.
+func MockExpiryWithClock(clk clock.PassiveClock) MockExpiryOption {
+	return func(me *mockExpiry) {
+		me.clock = clk
+	}
+}
+
+func newMockExpiry(opts []MockExpiryOption) *mockExpiry {
+	me := &mockExpiry{
+		clock: clock.RealClock,
+	}
+	for _, opt := range opts {
+		opt(me)
+	}
+	return me
+}
+
+func ExpiringMockAuthCodeExchange(fn MockAuthCodeExchangeFunc, duration time.Duration, opts ...MockExpiryOption) MockAuthCodeExchangeFunc {
+	me := newMockExpiry(opts)
+
 	return AmendTokenMockAuthCodeExchange(fn, func(t *provider.Token) error {
-		t.Expiry = time.Now().Add(duration)
+		t.Expiry = me.clock.Now().Add(duration)
 		return nil
 	})
 }
 
-func RefreshableMockAuthCodeExchange(fn MockAuthCodeExchangeFunc, step func(i int) (time.Duration, error)) MockAuthCodeExchangeFunc {
+func RefreshableMockAuthCodeExchange(fn MockAuthCodeExchangeFunc, step func(i int) (time.Duration, error), opts ...MockExpiryOption) MockAuthCodeExchangeFunc {
+	me := newMockExpiry(opts)
 	refreshToken := randomToken(40)
 	var i int32
 
@@ -50,7 +82,25 @@ func RefreshableMockAuthCodeExchange(fn MockAuthCodeExchangeFunc, step func(i in
 		}
 
 		t.RefreshToken = refreshToken
-		t.Expiry = time.Now().Add(exp)
+		t.Expiry = me.clock.Now().Add(exp)
+		return nil
+	})
+}
+
+// RotatingMockAuthCodeExchange is like RefreshableMockAuthCodeExchange, but
+// issues a new refresh token every time it is called.
+func RotatingMockAuthCodeExchange(fn MockAuthCodeExchangeFunc, step func(i int) (time.Duration, error), opts ...MockExpiryOption) MockAuthCodeExchangeFunc {
+	me := newMockExpiry(opts)
+	var i int32
+
+	return AmendTokenMockAuthCodeExchange(fn, func(t *provider.Token) error {
+		exp, err := step(int(atomic.AddInt32(&i, 1)))
+		if err != nil {
+			return err
+		}
+
+		t.RefreshToken = randomToken(40)
+		t.Expiry = me.clock.Now().Add(exp)
 		return nil
 	})
 }
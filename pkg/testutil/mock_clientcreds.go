@@ -2,8 +2,10 @@ package testutil
 
 import (
 	"fmt"
+	"net/http"
 	"sync/atomic"
 
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/interop"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
 	"golang.org/x/oauth2"
 )
@@ -46,3 +48,31 @@ func IncrementMockClientCredentials(prefix string) MockClientCredentialsFunc {
 		return &provider.Token{Token: t}, nil
 	}
 }
+
+func ErrorMockClientCredentials(errType string) MockClientCredentialsFunc {
+	return func(_ *provider.ClientCredentialsOptions) (*provider.Token, error) {
+		return nil, MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: errType})
+	}
+}
+
+var (
+	InvalidClientErrorMockClientCredentials      = ErrorMockClientCredentials("invalid_client")
+	InvalidScopeErrorMockClientCredentials       = ErrorMockClientCredentials("invalid_scope")
+	UnauthorizedClientErrorMockClientCredentials = ErrorMockClientCredentials("unauthorized_client")
+)
+
+// SequenceMockClientCredentials responds to each request using the next
+// function in the given list. Once the list is exhausted, the last function
+// handles every remaining request.
+func SequenceMockClientCredentials(fns ...MockClientCredentialsFunc) MockClientCredentialsFunc {
+	var i int32
+
+	return func(opts *provider.ClientCredentialsOptions) (*provider.Token, error) {
+		n := int(atomic.AddInt32(&i, 1)) - 1
+		if n >= len(fns) {
+			n = len(fns) - 1
+		}
+
+		return fns[n](opts)
+	}
+}
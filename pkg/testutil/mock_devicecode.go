@@ -1,11 +1,14 @@
 package testutil
 
 import (
+	"fmt"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/devicecode"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/interop"
 	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"golang.org/x/oauth2"
 )
 
 func StaticMockDeviceCodeAuth(auth *devicecode.Auth) MockDeviceCodeAuthFunc {
@@ -29,6 +32,67 @@ func AmendTokenMockDeviceCodeExchange(get MockDeviceCodeExchangeFunc, amend func
 	}
 }
 
+func StaticMockDeviceCodeExchange(token *provider.Token) MockDeviceCodeExchangeFunc {
+	return func(_ string, _ *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
+		return token, nil
+	}
+}
+
+func RandomMockDeviceCodeExchange(_ string, _ *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
+	t := &oauth2.Token{
+		AccessToken: randomToken(10),
+	}
+	return &provider.Token{Token: t}, nil
+}
+
+func IncrementMockDeviceCodeExchange(prefix string) MockDeviceCodeExchangeFunc {
+	var i int32
+
+	return func(_ string, _ *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
+		t := &oauth2.Token{
+			AccessToken: fmt.Sprintf("%s%d", prefix, atomic.AddInt32(&i, 1)),
+		}
+		return &provider.Token{Token: t}, nil
+	}
+}
+
+// SequenceMockDeviceCodeExchange responds to each exchange using the next
+// function in the given list. Once the list is exhausted, the last function
+// handles every remaining exchange. Use it to simulate a user who completes
+// the authorization after the client has polled a few times:
+//
+//	SequenceMockDeviceCodeExchange(
+//	  AuthorizationPendingErrorMockDeviceCodeExchange,
+//	  SlowDownErrorMockDeviceCodeExchange,
+//	  RandomMockDeviceCodeExchange,
+//	)
+func SequenceMockDeviceCodeExchange(fns ...MockDeviceCodeExchangeFunc) MockDeviceCodeExchangeFunc {
+	var i int32
+
+	return func(deviceCode string, opts *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
+		n := int(atomic.AddInt32(&i, 1)) - 1
+		if n >= len(fns) {
+			n = len(fns) - 1
+		}
+
+		return fns[n](deviceCode, opts)
+	}
+}
+
+// RestrictMockDeviceCodeExchange responds to exchanges of the device codes in
+// the given map using the corresponding function. Other device codes are
+// rejected as expired.
+func RestrictMockDeviceCodeExchange(m map[string]MockDeviceCodeExchangeFunc) MockDeviceCodeExchangeFunc {
+	return func(deviceCode string, opts *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
+		fn, found := m[deviceCode]
+		if !found {
+			fn = ExpiredTokenErrorMockDeviceCodeExchange
+		}
+
+		return fn(deviceCode, opts)
+	}
+}
+
 func ErrorMockDeviceCodeExchange(errType string) MockDeviceCodeExchangeFunc {
 	return func(_ string, _ *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
 		return nil, MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: errType})
@@ -0,0 +1,597 @@
+package testutil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/puppetlabs/leg/timeutil/pkg/clock"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/oauth2ext/interop"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// Paths of the endpoints served by MockIssuer, relative to its URL.
+const (
+	MockIssuerDiscoveryPath     = "/.well-known/openid-configuration"
+	MockIssuerJWKSPath          = "/.well-known/jwks.json"
+	MockIssuerAuthorizePath     = "/authorize"
+	MockIssuerTokenPath         = "/token"
+	MockIssuerIntrospectionPath = "/introspect"
+	MockIssuerRevocationPath    = "/revoke"
+	MockIssuerUserInfoPath      = "/userinfo"
+)
+
+// MockIssuerFault is a failure to inject into responses from an endpoint of a
+// MockIssuer.
+type MockIssuerFault struct {
+	// Delay is how long to wait before responding.
+	Delay time.Duration
+
+	// StatusCode, if set, is returned instead of handling the request.
+	StatusCode int
+
+	// Error is the OAuth 2.0 error code to include in the body of the
+	// response if StatusCode is set.
+	Error string
+
+	// Body, if set, is written as the response instead of handling the
+	// request, for example to simulate a malformed JSON response. If
+	// StatusCode is not set, the response has status 200 (OK).
+	Body string
+
+	// RetryAfter, if set, is sent in the Retry-After header of the response.
+	// It is typically used with status 429 (Too Many Requests) or 503
+	// (Service Unavailable).
+	RetryAfter time.Duration
+
+	// Count is the number of requests to affect. If 0, every request is
+	// affected until the fault is cleared.
+	Count int
+}
+
+type mockIssuerKey struct {
+	jwk    jose.JSONWebKey
+	signer jose.Signer
+}
+
+type mockIssuerCode struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	scope       string
+}
+
+type mockIssuerGrant struct {
+	clientID string
+	subject  string
+	scope    string
+	expiry   time.Time
+	revoked  bool
+}
+
+// MockIssuer is an OpenID Connect provider served by an httptest.Server. It
+// issues real signed ID tokens so that tests can exercise discovery, token
+// verification, and error handling against HTTP responses instead of mocking
+// each handler.
+//
+// The authorization endpoint approves every request immediately, redirecting
+// back to the client with a code for the configured subject.
+type MockIssuer struct {
+	URL string
+
+	server   *httptest.Server
+	closed   chan struct{}
+	clock    clock.PassiveClock
+	clients  map[string]string
+	subject  string
+	lifetime time.Duration
+	latency  time.Duration
+	rotate   bool
+	keys     []*mockIssuerKey
+
+	mut      sync.Mutex
+	faults   map[string]*MockIssuerFault
+	requests map[string]int
+	codes    map[string]*mockIssuerCode
+	access   map[string]*mockIssuerGrant
+	refresh  map[string]*mockIssuerGrant
+}
+
+type MockIssuerOption func(mi *MockIssuer)
+
+// MockIssuerWithClient registers a client with the given ID and secret. A
+// client with an empty secret is a public client.
+func MockIssuerWithClient(client MockClient) MockIssuerOption {
+	return func(mi *MockIssuer) {
+		mi.clients[client.ID] = client.Secret
+	}
+}
+
+// MockIssuerWithSigningKey adds a key to the key set. The first key added is
+// used to sign ID tokens; the rest are only published, for example to test
+// key rotation. If no key is added, a 2048-bit RSA key is generated.
+func MockIssuerWithSigningKey(alg jose.SignatureAlgorithm, key interface{}, keyID string) MockIssuerOption {
+	return func(mi *MockIssuer) {
+		mi.keys = append(mi.keys, newMockIssuerKey(alg, key, keyID))
+	}
+}
+
+// MockIssuerWithSubject sets the subject of the tokens the issuer grants.
+func MockIssuerWithSubject(subject string) MockIssuerOption {
+	return func(mi *MockIssuer) {
+		mi.subject = subject
+	}
+}
+
+// MockIssuerWithTokenLifetime sets how long access and ID tokens are valid.
+func MockIssuerWithTokenLifetime(lifetime time.Duration) MockIssuerOption {
+	return func(mi *MockIssuer) {
+		mi.lifetime = lifetime
+	}
+}
+
+// MockIssuerWithLatency delays every response by the given duration.
+func MockIssuerWithLatency(latency time.Duration) MockIssuerOption {
+	return func(mi *MockIssuer) {
+		mi.latency = latency
+	}
+}
+
+// MockIssuerWithRefreshTokenRotation causes the issuer to return a new
+// refresh token each time a refresh token is used. The old refresh token is
+// rejected with an invalid_grant error if it is used again.
+func MockIssuerWithRefreshTokenRotation() MockIssuerOption {
+	return func(mi *MockIssuer) {
+		mi.rotate = true
+	}
+}
+
+// MockIssuerWithClock causes token expiries to be computed relative to the
+// given clock instead of the wall clock.
+func MockIssuerWithClock(clk clock.PassiveClock) MockIssuerOption {
+	return func(mi *MockIssuer) {
+		mi.clock = clk
+	}
+}
+
+func newMockIssuerKey(alg jose.SignatureAlgorithm, key interface{}, keyID string) *mockIssuerKey {
+	jwk := jose.JSONWebKey{
+		Key:       key,
+		KeyID:     keyID,
+		Algorithm: string(alg),
+		Use:       "sig",
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: jwk}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		panic(fmt.Errorf("failed to create mock issuer signer: %w", err))
+	}
+
+	return &mockIssuerKey{jwk: jwk, signer: signer}
+}
+
+// NewMockIssuer starts a mock OpenID Connect provider. Call Close when it is
+// no longer needed.
+func NewMockIssuer(opts ...MockIssuerOption) *MockIssuer {
+	mi := &MockIssuer{
+		clock:    clock.RealClock,
+		closed:   make(chan struct{}),
+		clients:  make(map[string]string),
+		subject:  "test-user",
+		lifetime: time.Hour,
+		faults:   make(map[string]*MockIssuerFault),
+		requests: make(map[string]int),
+		codes:    make(map[string]*mockIssuerCode),
+		access:   make(map[string]*mockIssuerGrant),
+		refresh:  make(map[string]*mockIssuerGrant),
+	}
+	for _, opt := range opts {
+		opt(mi)
+	}
+
+	if len(mi.keys) == 0 {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(fmt.Errorf("failed to generate mock issuer key: %w", err))
+		}
+
+		mi.keys = append(mi.keys, newMockIssuerKey(jose.RS256, key, "default"))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(MockIssuerDiscoveryPath, mi.discovery)
+	mux.HandleFunc(MockIssuerJWKSPath, mi.jwks)
+	mux.HandleFunc(MockIssuerAuthorizePath, mi.authorize)
+	mux.HandleFunc(MockIssuerTokenPath, mi.token)
+	mux.HandleFunc(MockIssuerIntrospectionPath, mi.introspect)
+	mux.HandleFunc(MockIssuerRevocationPath, mi.revoke)
+	mux.HandleFunc(MockIssuerUserInfoPath, mi.userInfo)
+
+	mi.server = httptest.NewServer(mi.inject(mux))
+	mi.URL = mi.server.URL
+	return mi
+}
+
+// Close shuts down the server.
+func (mi *MockIssuer) Close() {
+	close(mi.closed)
+	mi.server.Close()
+}
+
+// Client returns an HTTP client for requests to the server.
+func (mi *MockIssuer) Client() *http.Client {
+	return mi.server.Client()
+}
+
+// InjectFault causes requests to the endpoint at the given path to fail as
+// described by the fault. It replaces any fault already injected for the
+// endpoint.
+func (mi *MockIssuer) InjectFault(path string, fault MockIssuerFault) {
+	mi.mut.Lock()
+	defer mi.mut.Unlock()
+
+	mi.faults[path] = &fault
+}
+
+// ClearFaults removes all injected faults.
+func (mi *MockIssuer) ClearFaults() {
+	mi.mut.Lock()
+	defer mi.mut.Unlock()
+
+	mi.faults = make(map[string]*MockIssuerFault)
+}
+
+// Requests returns the number of requests made to the endpoint at the given
+// path, including requests that failed because of an injected fault.
+func (mi *MockIssuer) Requests(path string) int {
+	mi.mut.Lock()
+	defer mi.mut.Unlock()
+
+	return mi.requests[path]
+}
+
+// Revoked returns true if the given access or refresh token has been revoked.
+func (mi *MockIssuer) Revoked(token string) bool {
+	mi.mut.Lock()
+	defer mi.mut.Unlock()
+
+	if g, found := mi.access[token]; found {
+		return g.revoked
+	} else if g, found := mi.refresh[token]; found {
+		return g.revoked
+	}
+
+	return false
+}
+
+func (mi *MockIssuer) inject(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mi.mut.Lock()
+		mi.requests[r.URL.Path]++
+
+		var fault MockIssuerFault
+		if f, found := mi.faults[r.URL.Path]; found {
+			fault = *f
+
+			if f.Count > 0 {
+				if f.Count--; f.Count == 0 {
+					delete(mi.faults, r.URL.Path)
+				}
+			}
+		}
+		mi.mut.Unlock()
+
+		if delay := mi.latency + fault.Delay; delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			case <-mi.closed:
+				return
+			}
+		}
+
+		if fault.RetryAfter > 0 {
+			w.Header().Set("retry-after", strconv.Itoa(int(fault.RetryAfter/time.Second)))
+		}
+
+		switch {
+		case fault.Body != "":
+			if fault.StatusCode != 0 {
+				w.WriteHeader(fault.StatusCode)
+			}
+			_, _ = io.WriteString(w, fault.Body)
+			return
+		case fault.StatusCode != 0:
+			mi.writeError(w, fault.StatusCode, fault.Error)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (mi *MockIssuer) writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(obj)
+}
+
+func (mi *MockIssuer) writeError(w http.ResponseWriter, status int, code string) {
+	if code == "" {
+		w.WriteHeader(status)
+		return
+	}
+
+	mi.writeJSON(w, status, &interop.JSONError{Error: code})
+}
+
+func (mi *MockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	algs := make([]string, len(mi.keys))
+	for i, key := range mi.keys {
+		algs[i] = key.jwk.Algorithm
+	}
+
+	mi.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                mi.URL,
+		"authorization_endpoint":                mi.URL + MockIssuerAuthorizePath,
+		"token_endpoint":                        mi.URL + MockIssuerTokenPath,
+		"jwks_uri":                              mi.URL + MockIssuerJWKSPath,
+		"introspection_endpoint":                mi.URL + MockIssuerIntrospectionPath,
+		"revocation_endpoint":                   mi.URL + MockIssuerRevocationPath,
+		"userinfo_endpoint":                     mi.URL + MockIssuerUserInfoPath,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": algs,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+	})
+}
+
+func (mi *MockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	ks := &jose.JSONWebKeySet{}
+	for _, key := range mi.keys {
+		ks.Keys = append(ks.Keys, key.jwk.Public())
+	}
+
+	mi.writeJSON(w, http.StatusOK, ks)
+}
+
+func (mi *MockIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		mi.writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	if _, found := mi.clients[q.Get("client_id")]; !found {
+		mi.writeError(w, http.StatusBadRequest, "unauthorized_client")
+		return
+	}
+
+	code := randomToken(16)
+
+	mi.mut.Lock()
+	mi.codes[code] = &mockIssuerCode{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		nonce:       q.Get("nonce"),
+		scope:       q.Get("scope"),
+	}
+	mi.mut.Unlock()
+
+	rq := redirectURI.Query()
+	rq.Set("code", code)
+	if state := q.Get("state"); state != "" {
+		rq.Set("state", state)
+	}
+	redirectURI.RawQuery = rq.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// authenticate returns the ID of the client making the request, or an empty
+// string if the client could not be authenticated.
+func (mi *MockIssuer) authenticate(r *http.Request) string {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if want, found := mi.clients[id]; !found || want != secret {
+		return ""
+	}
+
+	return id
+}
+
+func (mi *MockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		mi.writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientID := mi.authenticate(r)
+	if clientID == "" {
+		mi.writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	mi.mut.Lock()
+	defer mi.mut.Unlock()
+
+	var subject, scope, nonce string
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, found := mi.codes[r.PostForm.Get("code")]
+		if !found || code.clientID != clientID || code.redirectURI != r.PostForm.Get("redirect_uri") {
+			mi.writeError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		delete(mi.codes, r.PostForm.Get("code"))
+
+		subject, scope, nonce = mi.subject, code.scope, code.nonce
+	case "refresh_token":
+		grant, found := mi.refresh[r.PostForm.Get("refresh_token")]
+		if !found || grant.revoked || grant.clientID != clientID {
+			mi.writeError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+
+		subject, scope = grant.subject, grant.scope
+	case "client_credentials":
+		subject, scope = clientID, strings.Join(r.PostForm["scope"], " ")
+	default:
+		mi.writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	now := mi.clock.Now()
+	expiry := now.Add(mi.lifetime)
+
+	accessToken := randomToken(20)
+	mi.access[accessToken] = &mockIssuerGrant{clientID: clientID, subject: subject, scope: scope, expiry: expiry}
+
+	resp := map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(mi.lifetime / time.Second),
+	}
+	if scope != "" {
+		resp["scope"] = scope
+	}
+
+	if r.PostForm.Get("grant_type") != "client_credentials" {
+		refreshToken := r.PostForm.Get("refresh_token")
+		if refreshToken == "" || mi.rotate {
+			if refreshToken != "" {
+				mi.refresh[refreshToken].revoked = true
+			}
+
+			refreshToken = randomToken(20)
+			mi.refresh[refreshToken] = &mockIssuerGrant{clientID: clientID, subject: subject, scope: scope}
+		}
+		resp["refresh_token"] = refreshToken
+
+		claims := map[string]interface{}{}
+		if nonce != "" {
+			claims["nonce"] = nonce
+		}
+
+		idToken, err := jwt.Signed(mi.keys[0].signer).
+			Claims(&jwt.Claims{
+				Issuer:   mi.URL,
+				Subject:  subject,
+				Audience: jwt.Audience{clientID},
+				IssuedAt: jwt.NewNumericDate(now),
+				Expiry:   jwt.NewNumericDate(expiry),
+			}).
+			Claims(claims).
+			CompactSerialize()
+		if err != nil {
+			mi.writeError(w, http.StatusInternalServerError, "server_error")
+			return
+		}
+		resp["id_token"] = idToken
+	}
+
+	mi.writeJSON(w, http.StatusOK, resp)
+}
+
+func (mi *MockIssuer) introspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		mi.writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	if mi.authenticate(r) == "" {
+		mi.writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	mi.mut.Lock()
+	defer mi.mut.Unlock()
+
+	token := r.PostForm.Get("token")
+
+	resp := map[string]interface{}{"active": false}
+	if g, found := mi.access[token]; found && !g.revoked && mi.clock.Now().Before(g.expiry) {
+		resp = map[string]interface{}{
+			"active":     true,
+			"client_id":  g.clientID,
+			"sub":        g.subject,
+			"scope":      g.scope,
+			"token_type": "Bearer",
+			"exp":        g.expiry.Unix(),
+		}
+	} else if g, found := mi.refresh[token]; found && !g.revoked {
+		resp = map[string]interface{}{
+			"active":     true,
+			"client_id":  g.clientID,
+			"sub":        g.subject,
+			"scope":      g.scope,
+			"token_type": "refresh_token",
+		}
+	}
+
+	mi.writeJSON(w, http.StatusOK, resp)
+}
+
+func (mi *MockIssuer) revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		mi.writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientID := mi.authenticate(r)
+	if clientID == "" {
+		mi.writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	mi.mut.Lock()
+	defer mi.mut.Unlock()
+
+	// Per RFC 7009, an unknown token is not an error.
+	token := r.PostForm.Get("token")
+	if g, found := mi.access[token]; found && g.clientID == clientID {
+		g.revoked = true
+	} else if g, found := mi.refresh[token]; found && g.clientID == clientID {
+		g.revoked = true
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (mi *MockIssuer) userInfo(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("authorization"), "Bearer ")
+
+	mi.mut.Lock()
+	defer mi.mut.Unlock()
+
+	g, found := mi.access[token]
+	if !found || g.revoked || !mi.clock.Now().Before(g.expiry) {
+		w.Header().Set("www-authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	mi.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sub": g.subject,
+	})
+}
@@ -0,0 +1,166 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// ReplicationCluster simulates a Vault cluster with a single active node and
+// any number of performance standby nodes sharing the same storage.
+//
+// Writes from the active node invalidate the written keys on every standby, the
+// same way Vault replicates storage changes. Standby nodes have a read-only
+// view of the storage, and requests they cannot handle are forwarded to the
+// active node like Vault's router would.
+type ReplicationCluster struct {
+	delegate logical.Storage
+
+	mut      sync.RWMutex
+	active   *framework.Backend
+	standbys []*framework.Backend
+}
+
+// SetActive sets the active node of the cluster.
+func (rc *ReplicationCluster) SetActive(b *framework.Backend) {
+	rc.mut.Lock()
+	defer rc.mut.Unlock()
+
+	rc.active = b
+}
+
+// AddStandby adds a performance standby node to the cluster.
+func (rc *ReplicationCluster) AddStandby(b *framework.Backend) {
+	rc.mut.Lock()
+	defer rc.mut.Unlock()
+
+	rc.standbys = append(rc.standbys, b)
+}
+
+// Promote simulates a failover by replacing the active node with the given
+// standby node. The previous active node is removed from the cluster.
+func (rc *ReplicationCluster) Promote(b *framework.Backend) {
+	rc.mut.Lock()
+	defer rc.mut.Unlock()
+
+	for i, standby := range rc.standbys {
+		if standby == b {
+			rc.standbys = append(rc.standbys[:i], rc.standbys[i+1:]...)
+			break
+		}
+	}
+
+	rc.active = b
+}
+
+// ActiveStorage returns the view of the storage used by the active node.
+func (rc *ReplicationCluster) ActiveStorage() logical.Storage {
+	return &replicatedActiveStorage{cluster: rc}
+}
+
+// StandbyStorage returns the read-only view of the storage used by standby
+// nodes.
+func (rc *ReplicationCluster) StandbyStorage() logical.Storage {
+	return &replicatedStandbyStorage{cluster: rc}
+}
+
+// HandleRequest handles the given request on the given node, forwarding it to
+// the active node if necessary. The storage of the request is set according to
+// the node that ultimately handles it.
+func (rc *ReplicationCluster) HandleRequest(ctx context.Context, node *framework.Backend, req *logical.Request) (*logical.Response, error) {
+	rc.mut.RLock()
+	active := rc.active
+	rc.mut.RUnlock()
+
+	if node == active {
+		req.Storage = rc.ActiveStorage()
+		return node.HandleRequest(ctx, req)
+	}
+
+	if p := node.Route(req.Path); p != nil {
+		if op, found := p.Operations[req.Operation]; found && op.Properties().ForwardPerformanceStandby {
+			return rc.HandleRequest(ctx, active, req)
+		}
+	}
+
+	req.Storage = rc.StandbyStorage()
+
+	resp, err := node.HandleRequest(ctx, req)
+	if errors.Is(err, logical.ErrReadOnly) {
+		return rc.HandleRequest(ctx, active, req)
+	}
+
+	return resp, err
+}
+
+func (rc *ReplicationCluster) invalidate(ctx context.Context, key string) {
+	rc.mut.RLock()
+	defer rc.mut.RUnlock()
+
+	for _, standby := range rc.standbys {
+		standby.InvalidateKey(ctx, key)
+	}
+}
+
+func NewReplicationCluster(storage logical.Storage) *ReplicationCluster {
+	return &ReplicationCluster{
+		delegate: storage,
+	}
+}
+
+type replicatedActiveStorage struct {
+	cluster *ReplicationCluster
+}
+
+var _ logical.Storage = &replicatedActiveStorage{}
+
+func (ras *replicatedActiveStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	return ras.cluster.delegate.List(ctx, prefix)
+}
+
+func (ras *replicatedActiveStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	return ras.cluster.delegate.Get(ctx, key)
+}
+
+func (ras *replicatedActiveStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if err := ras.cluster.delegate.Put(ctx, entry); err != nil {
+		return err
+	}
+
+	ras.cluster.invalidate(ctx, entry.Key)
+	return nil
+}
+
+func (ras *replicatedActiveStorage) Delete(ctx context.Context, key string) error {
+	if err := ras.cluster.delegate.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	ras.cluster.invalidate(ctx, key)
+	return nil
+}
+
+type replicatedStandbyStorage struct {
+	cluster *ReplicationCluster
+}
+
+var _ logical.Storage = &replicatedStandbyStorage{}
+
+func (rss *replicatedStandbyStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	return rss.cluster.delegate.List(ctx, prefix)
+}
+
+func (rss *replicatedStandbyStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	return rss.cluster.delegate.Get(ctx, key)
+}
+
+func (rss *replicatedStandbyStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	return logical.ErrReadOnly
+}
+
+func (rss *replicatedStandbyStorage) Delete(ctx context.Context, key string) error {
+	return logical.ErrReadOnly
+}
@@ -0,0 +1,314 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/puppetlabs/vault-plugin-secrets-oauthapp/v2/pkg/provider"
+	"golang.org/x/oauth2"
+)
+
+// StressOperation is a kind of request made repeatedly by a StressTest.
+type StressOperation struct {
+	// Name identifies the operation in the report.
+	Name string
+
+	// Weight is how often this operation is chosen relative to the other
+	// operations. If 0, it is treated as 1.
+	Weight int
+
+	// Request returns the request to make. The given random source is owned
+	// by the calling worker.
+	Request func(rnd *rand.Rand) *logical.Request
+
+	// Check verifies the result of the request and returns an error if an
+	// invariant does not hold. It is called concurrently from every worker.
+	// If not set, any error or error response is a violation.
+	Check func(sr *StressResult) error
+}
+
+// StressResult is the outcome of a single request made by a StressTest.
+type StressResult struct {
+	Request  *logical.Request
+	Response *logical.Response
+	Err      error
+
+	// Start and End are the times immediately before and after the request
+	// was handled.
+	Start, End time.Time
+}
+
+// StressReport summarizes the requests made by a StressTest.
+type StressReport struct {
+	// Requests is the number of requests made for each operation.
+	Requests map[string]int
+
+	// Violations are the errors returned by the Check function of each
+	// operation.
+	Violations []error
+}
+
+// Err returns an error describing the first few violations, or nil if there
+// were none.
+func (sr *StressReport) Err() error {
+	if len(sr.Violations) == 0 {
+		return nil
+	}
+
+	const max = 5
+
+	msgs := make([]string, 0, max)
+	for i, v := range sr.Violations {
+		if i == max {
+			break
+		}
+
+		msgs = append(msgs, v.Error())
+	}
+
+	return fmt.Errorf("%d invariant violation(s), including: %s", len(sr.Violations), strings.Join(msgs, "; "))
+}
+
+// StressTest drives concurrent requests against a backend to find locking
+// regressions. Run the test with the race detector enabled to also find data
+// races.
+type StressTest struct {
+	Backend logical.Backend
+	Storage logical.Storage
+
+	// Workers is the number of goroutines making requests. If 0, 8 workers
+	// are used.
+	Workers int
+
+	// Iterations is the number of requests each worker makes. If 0, 100
+	// requests are made.
+	Iterations int
+
+	// Seed initializes the random sources of the workers so that a failing
+	// run can be reproduced, at least as far as the scheduler allows.
+	Seed int64
+
+	Operations []StressOperation
+}
+
+func (st *StressTest) choose(rnd *rand.Rand, total int) *StressOperation {
+	n := rnd.Intn(total)
+	for i := range st.Operations {
+		op := &st.Operations[i]
+
+		weight := op.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+
+		if n < weight {
+			return op
+		}
+		n -= weight
+	}
+
+	panic("unreachable")
+}
+
+// Run makes the requests and waits for every worker to finish or for the
+// context to expire.
+func (st *StressTest) Run(ctx context.Context) *StressReport {
+	workers, iterations := st.Workers, st.Iterations
+	if workers <= 0 {
+		workers = 8
+	}
+	if iterations <= 0 {
+		iterations = 100
+	}
+
+	var total int
+	for _, op := range st.Operations {
+		if op.Weight <= 0 {
+			total++
+		} else {
+			total += op.Weight
+		}
+	}
+
+	report := &StressReport{
+		Requests: make(map[string]int),
+	}
+	if total == 0 {
+		return report
+	}
+
+	var mut sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(rnd *rand.Rand) {
+			defer wg.Done()
+
+			for i := 0; i < iterations && ctx.Err() == nil; i++ {
+				op := st.choose(rnd, total)
+
+				req := op.Request(rnd)
+				req.Storage = st.Storage
+
+				sr := &StressResult{Request: req, Start: time.Now()}
+				sr.Response, sr.Err = st.Backend.HandleRequest(ctx, req)
+				sr.End = time.Now()
+
+				check := op.Check
+				if check == nil {
+					check = stressCheckSuccess
+				}
+				verr := check(sr)
+
+				mut.Lock()
+				report.Requests[op.Name]++
+				if verr != nil {
+					report.Violations = append(report.Violations, fmt.Errorf("%s %s: %w", op.Name, req.Path, verr))
+				}
+				mut.Unlock()
+			}
+		}(rand.New(rand.NewSource(st.Seed + int64(w))))
+	}
+	wg.Wait()
+
+	return report
+}
+
+func stressCheckSuccess(sr *StressResult) error {
+	if sr.Err != nil {
+		return sr.Err
+	} else if sr.Response != nil && sr.Response.IsError() {
+		return sr.Response.Error()
+	}
+
+	return nil
+}
+
+type stressObservation struct {
+	seq int
+	end time.Time
+}
+
+type stressGrant struct {
+	latest       int
+	observations []stressObservation
+}
+
+// StressLedger records the tokens issued by a mock provider so that the
+// results of a StressTest can be checked against them.
+//
+// Every token the ledger issues has a new access token and refresh token, so
+// refresh tokens are rotated on every refresh. The ledger tracks tokens by
+// grant, i.e., by the authorization code originally exchanged for them, so
+// each credential written during the test should use a unique code.
+type StressLedger struct {
+	mut    sync.Mutex
+	seq    int
+	tokens map[string]string
+	grants map[string]*stressGrant
+}
+
+// AuthCodeExchange returns an exchange function for the mock provider that
+// issues tokens recorded by this ledger. The given function determines the
+// lifetime of tokens issued for a code and whether they can be refreshed.
+func (sl *StressLedger) AuthCodeExchange(grant func(code string) (lifetime time.Duration, refreshable bool), opts ...MockExpiryOption) MockAuthCodeExchangeFunc {
+	me := newMockExpiry(opts)
+
+	return func(code string, _ *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		lifetime, refreshable := grant(code)
+
+		sl.mut.Lock()
+		defer sl.mut.Unlock()
+
+		g, found := sl.grants[code]
+		if !found {
+			g = &stressGrant{}
+			sl.grants[code] = g
+		}
+
+		sl.seq++
+		g.latest = sl.seq
+
+		t := &oauth2.Token{
+			AccessToken: fmt.Sprintf("stress_%d", sl.seq),
+			Expiry:      me.clock.Now().Add(lifetime),
+		}
+		if refreshable {
+			t.RefreshToken = randomToken(40)
+		}
+		sl.tokens[t.AccessToken] = code
+
+		return &provider.Token{Token: t}, nil
+	}
+}
+
+func (sl *StressLedger) lookup(accessToken string) (*stressGrant, int, error) {
+	code, found := sl.tokens[accessToken]
+	if !found {
+		return nil, 0, fmt.Errorf("access token %q was never issued", accessToken)
+	}
+
+	seq, err := strconv.Atoi(strings.TrimPrefix(accessToken, "stress_"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return sl.grants[code], seq, nil
+}
+
+// Observe checks an access token returned by a read that started and ended
+// at the given times. It returns an error if a read that finished before this
+// one started already returned a newer token for the same grant, i.e., if
+// this read was stale.
+func (sl *StressLedger) Observe(accessToken string, start, end time.Time) error {
+	sl.mut.Lock()
+	defer sl.mut.Unlock()
+
+	g, seq, err := sl.lookup(accessToken)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range g.observations {
+		if o.seq > seq && o.end.Before(start) {
+			return fmt.Errorf("stale read: access token %q returned after a previous read returned %q", accessToken, fmt.Sprintf("stress_%d", o.seq))
+		}
+	}
+
+	g.observations = append(g.observations, stressObservation{seq: seq, end: end})
+	return nil
+}
+
+// Current checks that an access token is the last one the ledger issued for
+// its grant. Because the ledger rotates refresh tokens, an older access token
+// means that a newer refresh token was lost. Call it only once no more
+// requests are being made.
+func (sl *StressLedger) Current(accessToken string) error {
+	sl.mut.Lock()
+	defer sl.mut.Unlock()
+
+	g, seq, err := sl.lookup(accessToken)
+	if err != nil {
+		return err
+	}
+
+	if seq != g.latest {
+		return fmt.Errorf("lost refresh token: access token %q is stored but %q was issued later", accessToken, fmt.Sprintf("stress_%d", g.latest))
+	}
+
+	return nil
+}
+
+// NewStressLedger creates an empty ledger.
+func NewStressLedger() *StressLedger {
+	return &StressLedger{
+		tokens: make(map[string]string),
+		grants: make(map[string]*stressGrant),
+	}
+}